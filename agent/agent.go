@@ -8,13 +8,22 @@ import (
 	"strings"
 	"time"
 
+	"github.com/subutai-io/agent/agent/alert"
+	"github.com/subutai-io/agent/agent/auth"
+	"github.com/subutai-io/agent/agent/cluster"
 	"github.com/subutai-io/agent/agent/container"
 	"github.com/subutai-io/agent/agent/discovery"
+	"github.com/subutai-io/agent/agent/exporter"
+	"github.com/subutai-io/agent/agent/limiter"
+	"github.com/subutai-io/agent/agent/logs"
 	"github.com/subutai-io/agent/agent/monitor"
+	"github.com/subutai-io/agent/agent/telemetry"
 	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/cli"
 	"github.com/subutai-io/agent/agent/console"
 	"github.com/subutai-io/agent/agent/vars"
+	"github.com/subutai-io/agent/lib/proxy"
+	"github.com/subutai-io/agent/log"
 )
 
 var (
@@ -39,6 +48,39 @@ func Start() {
 	//restart containers that got stopped not by user
 	go container.StateRestore()
 
+	//evaluate alert rules, does not depend on Console
+	go alert.Monitor()
+
+	//detect pool growth and recalibrate container disk quotas
+	go cli.MonitorPoolCapacity()
+
+	//report anonymized usage counts if enabled by user
+	go telemetry.Monitor()
+
+	//forward container journals to syslog if enabled by user
+	go logs.Forward()
+
+	//serve container metrics to Prometheus if enabled by user
+	go exporter.Serve()
+
+	//find peer resource hosts if cluster mode is enabled
+	go cluster.Monitor()
+
+	//replicate management of primary host if this host is its standby
+	go cli.MonitorStandby()
+
+	//stop management replicated to standby if this host loses quorum of cluster
+	go cli.MonitorPrimary()
+
+	//assign and rebalance cores of containers with automatic cpuset
+	go cli.MonitorCPUsets()
+
+	//find new versions of templates in use and upgrade opted in containers if enabled by user
+	go cli.MonitorTemplateUpdates()
+
+	//iptables rules do not survive reboot, install NAT reflection of port mappings again
+	go proxy.RestoreReflection()
+
 	//wait till Console is loaded
 	for !consol.IsReady() {
 		time.Sleep(time.Second * 3)
@@ -63,13 +105,13 @@ func Start() {
 }
 
 //HTTP server >>>>
-var mux map[string]func(http.ResponseWriter, *http.Request)
-
-type myHandler struct{}
+type myHandler struct {
+	mux map[string]func(http.ResponseWriter, *http.Request)
+}
 
-func (*myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if h, ok := mux[r.URL.String()]; ok {
-		h(w, r)
+func (h *myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := h.mux[r.URL.String()]; ok {
+		handler(w, r)
 		return
 	}
 
@@ -82,13 +124,59 @@ func setupHttpServer() {
 		ReadHeaderTimeout: 15 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		WriteTimeout:      30 * time.Second,
-		Handler:           &myHandler{},
 	}
-	mux = make(map[string]func(http.ResponseWriter, *http.Request))
+	mux := make(map[string]func(http.ResponseWriter, *http.Request))
 	mux["/trigger"] = triggerHandler
 	mux["/ping"] = pingHandler
 	mux["/heartbeat"] = heartbeatHandler
+
+	srv.Handler = limiter.Wrap(&myHandler{mux: mux}, endpoints(mux)...)
 	go srv.ListenAndServe()
+
+	setupTlsServer()
+}
+
+// setupTlsServer starts TLS listener serving cluster peers and API callers if certificate of host is set.
+// Responses are not limited in time since commands and streams served there take long, callers are
+// authenticated before anything is run
+func setupTlsServer() {
+	if !cluster.ServesTLS() {
+		return
+	}
+	tlsConfig, err := cluster.ServerTLSConfig()
+	if log.Check(log.WarnLevel, "Configuring TLS listener", err) {
+		return
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + config.TLS.Port,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 15 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+	mux := make(map[string]func(http.ResponseWriter, *http.Request))
+	mux[cluster.ExecPath] = cluster.ExecHandler
+	mux[cluster.PeersPath] = cluster.PeersHandler
+	mux[cluster.TemplatePath] = cluster.TemplateHandler
+	mux[cluster.LockPath] = cluster.LockHandler
+	mux[cluster.LocksPath] = cluster.LocksHandler
+	mux[cluster.ReplicaPath] = cluster.ReplicaHandler
+	mux[cluster.ReplicaStreamPath] = cluster.ReplicaStreamHandler
+	mux[cluster.PlacementPath] = cluster.PlacementHandler
+	mux[auth.ExecPath] = auth.ExecHandler
+
+	srv.Handler = limiter.Wrap(&myHandler{mux: mux}, endpoints(mux)...)
+	go func() {
+		log.Check(log.WarnLevel, "Serving TLS listener", srv.ListenAndServeTLS("", ""))
+	}()
+}
+
+func endpoints(mux map[string]func(http.ResponseWriter, *http.Request)) []string {
+	var paths []string
+	for path := range mux {
+		paths = append(paths, path)
+	}
+	return paths
 }
 
 func pingHandler(rw http.ResponseWriter, request *http.Request) {
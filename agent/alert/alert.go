@@ -0,0 +1,234 @@
+// Package alert evaluates threshold rules over host and container metrics and performs configured actions
+// (log, webhook, container restart) when a rule keeps firing longer than its duration.
+// This provides basic alerting even when no external monitoring stack is available.
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	Pending  = "PENDING"
+	Firing   = "FIRING"
+	Resolved = "RESOLVED"
+	Ok       = "OK"
+)
+
+// Status describes evaluation result of a rule for a single target
+type Status struct {
+	Rule     string    `json:"rule"`
+	Metric   string    `json:"metric"`
+	Target   string    `json:"target"`
+	Value    float64   `json:"value"`
+	Operator string    `json:"operator"`
+	Limit    float64   `json:"threshold"`
+	Severity string    `json:"severity"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Host     string    `json:"host"`
+}
+
+type engine struct {
+	collector *collector
+	//breach start time by rule and target
+	pending map[string]time.Time
+	//firing alerts by rule and target
+	firing map[string]bool
+}
+
+func newEngine() *engine {
+	return &engine{collector: newCollector(), pending: make(map[string]time.Time), firing: make(map[string]bool)}
+}
+
+// Monitor periodically evaluates alert rules. Rules file is re-read on each iteration
+// so changes made via CLI are picked up without agent restart
+func Monitor() {
+	e := newEngine()
+	for {
+		common.RunNRecover(e.run)
+
+		time.Sleep(time.Second * 30)
+	}
+}
+
+func (e *engine) run() {
+	rules, err := LoadRules()
+	if log.Check(log.WarnLevel, "Loading alert rules", err) {
+		return
+	}
+
+	for _, status := range e.evaluate(rules) {
+		if status.State == Firing || status.State == Resolved {
+			for _, rule := range rules {
+				if rule.Name == status.Rule {
+					notify(rule, status)
+				}
+			}
+		}
+	}
+}
+
+// evaluate checks each rule against current metric values and returns statuses of all rule targets.
+// Only transitions are reported as Firing and Resolved, ongoing alerts are reported as Pending until they fire
+func (e *engine) evaluate(rules []Rule) []Status {
+	var statuses []Status
+	seen := make(map[string]bool)
+
+	hostname, _ := os.Hostname()
+
+	for _, rule := range rules {
+		for _, target := range targets(rule.Target) {
+			key := rule.Name + "/" + target
+			seen[key] = true
+
+			value, ok, err := e.collector.value(rule.Metric, target)
+			if log.Check(log.DebugLevel, fmt.Sprintf("Getting %s of %s", rule.Metric, target), err) || !ok {
+				continue
+			}
+
+			status := Status{Rule: rule.Name, Metric: rule.Metric, Target: target, Value: value,
+				Operator: rule.Operator, Limit: rule.Threshold, Severity: rule.Severity, State: Ok, Host: hostname}
+
+			if rule.breached(value) {
+				since, exists := e.pending[key]
+				if !exists {
+					since = time.Now()
+					e.pending[key] = since
+				}
+				status.Since = since
+
+				if time.Since(since) >= rule.For {
+					if e.firing[key] {
+						status.State = Pending
+					} else {
+						e.firing[key] = true
+						status.State = Firing
+					}
+				} else {
+					status.State = Pending
+				}
+			} else {
+				delete(e.pending, key)
+				if e.firing[key] {
+					delete(e.firing, key)
+					status.State = Resolved
+				}
+			}
+
+			statuses = append(statuses, status)
+		}
+	}
+
+	//forget state of removed rules and destroyed containers
+	for key := range e.pending {
+		if !seen[key] {
+			delete(e.pending, key)
+		}
+	}
+	for key := range e.firing {
+		if !seen[key] {
+			delete(e.firing, key)
+		}
+	}
+
+	return statuses
+}
+
+// Check evaluates rules once ignoring their durations and returns current statuses.
+// Actions are not executed
+func Check() ([]Status, error) {
+	rules, err := LoadRules()
+	if err != nil {
+		return nil, err
+	}
+
+	e := newEngine()
+
+	//prime cpu samples
+	for _, rule := range rules {
+		if rule.Metric == CPU {
+			for _, target := range targets(rule.Target) {
+				e.collector.value(CPU, target)
+			}
+		}
+	}
+	time.Sleep(time.Second)
+
+	for i := range rules {
+		rules[i].For = 0
+	}
+
+	statuses := e.evaluate(rules)
+	for i := range statuses {
+		if statuses[i].State != Ok {
+			statuses[i].State = Firing
+		}
+	}
+
+	return statuses, nil
+}
+
+func targets(target string) []string {
+	if target == ALL {
+		return container.Containers()
+	}
+
+	return []string{target}
+}
+
+func notify(rule Rule, status Status) {
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case LOG:
+			logStatus(status)
+		case WEBHOOK:
+			log.Check(log.WarnLevel, "Sending alert to "+action.URL, postWebhook(action.URL, status))
+		case RESTART:
+			if status.State == Firing {
+				log.Info("Restarting container " + status.Target + " on alert " + status.Rule)
+				log.Check(log.WarnLevel, "Restarting container "+status.Target, container.Restart(status.Target))
+			}
+		}
+	}
+}
+
+func logStatus(status Status) {
+	msg := fmt.Sprintf("Alert %s [%s] %s: %s %s is %.2f (threshold %s %v)", status.Rule, status.Severity,
+		status.State, status.Target, status.Metric, status.Value, status.Operator, status.Limit)
+
+	if status.State == Firing && status.Severity != "info" {
+		log.Warn(msg)
+	} else {
+		log.Info(msg)
+	}
+}
+
+func postWebhook(url string, status Status) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	clnt := util.GetClient(false, 15)
+	resp, err := clnt.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer util.Close(resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("HTTP status: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package alert
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/metrics/cgroup"
+)
+
+type cpuSample struct {
+	busy  uint64
+	total uint64
+}
+
+// collector reads current metric values.
+// CPU load is calculated as a difference between two consecutive samples,
+// so the first reading of cpu metric for each target yields no value
+type collector struct {
+	samples map[string]cpuSample
+}
+
+func newCollector() *collector {
+	return &collector{samples: make(map[string]cpuSample)}
+}
+
+// value returns current value of metric for target, false if value is not available yet
+func (c *collector) value(metric, target string) (float64, bool, error) {
+	switch metric {
+	case CPU:
+		return c.cpu(target)
+	case RAM:
+		if target == HOST {
+			v, err := hostRam()
+			return v, err == nil, err
+		}
+		v, err := containerRam(target)
+		return v, err == nil, err
+	case DISK:
+		if target == HOST {
+			v, err := hostDisk()
+			return v, err == nil, err
+		}
+		return containerDisk(target)
+	case STATE:
+		if container.State(target) == container.Running {
+			return 1, true, nil
+		}
+		return 0, true, nil
+	}
+
+	return 0, false, errors.Errorf("Unknown metric %s", metric)
+}
+
+func (c *collector) cpu(target string) (float64, bool, error) {
+	var sample cpuSample
+	var err error
+	if target == HOST {
+		sample, err = hostCpuSample()
+	} else {
+		sample, err = containerCpuSample(target)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	prev, ok := c.samples[target]
+	c.samples[target] = sample
+	if !ok || sample.total <= prev.total {
+		return 0, false, nil
+	}
+
+	return float64(sample.busy-prev.busy) * 100 / float64(sample.total-prev.total), true, nil
+}
+
+//host cpu sample in jiffies taken from /proc/stat
+func hostCpuSample() (cpuSample, error) {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return cpuSample{}, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 5 && fields[0] == "cpu" {
+			var sample cpuSample
+			for i := 1; i < len(fields); i++ {
+				val, err := strconv.ParseUint(fields[i], 10, 64)
+				if err != nil {
+					return cpuSample{}, err
+				}
+				sample.total += val
+				//idle and iowait
+				if i != 4 && i != 5 {
+					sample.busy += val
+				}
+			}
+			return sample, nil
+		}
+	}
+
+	return cpuSample{}, errors.New("Failed to parse /proc/stat")
+}
+
+//container cpu sample in nanoseconds, total is wall time multiplied by number of cores
+func containerCpuSample(name string) (cpuSample, error) {
+	sample, err := cgroup.SampleCPU(container.GetRuntime(), name)
+	if err != nil {
+		return cpuSample{}, err
+	}
+
+	return cpuSample{busy: sample.Usage, total: uint64(sample.Time.UnixNano()) * uint64(runtime.NumCPU())}, nil
+}
+
+func hostRam() (float64, error) {
+	total, available, err := meminfo()
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(total-available) * 100 / float64(total), nil
+}
+
+func containerRam(name string) (float64, error) {
+	rt := container.GetRuntime()
+	usage, err := cgroup.MemoryUsage(rt, name)
+	if err != nil {
+		return 0, err
+	}
+
+	limit, err := cgroup.MemoryLimit(rt, name)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit == 0 {
+		total, _, err := meminfo()
+		if err != nil {
+			return 0, err
+		}
+		limit = total
+	}
+
+	return float64(usage) * 100 / float64(limit), nil
+}
+
+func hostDisk() (float64, error) {
+	out, err := exec.Execute("zfs", "list", "-Hp", "-o", "used,avail", config.Agent.Dataset)
+	if err != nil {
+		return 0, errors.Errorf("Error getting zfs usage: %s", out)
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		return 0, errors.New("Failed to parse zfs usage from " + out)
+	}
+
+	used, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	avail, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if used+avail == 0 {
+		return 0, nil
+	}
+
+	return used * 100 / (used + avail), nil
+}
+
+//disk usage of container relative to its quota, no value if quota is not set
+func containerDisk(name string) (float64, bool, error) {
+	quota, err := fs.GetQuota(name)
+	if err != nil {
+		return 0, false, err
+	}
+	if quota == 0 {
+		return 0, false, nil
+	}
+
+	used, err := fs.DatasetDiskUsage(name)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return float64(used) * 100 / float64(quota), true, nil
+}
+
+//returns total and available memory in bytes
+func meminfo() (total, available uint64, err error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.Replace(scanner.Text(), ":", "", 1))
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal":
+			total = value * 1024
+		case "MemAvailable":
+			available = value * 1024
+		}
+	}
+
+	if total == 0 {
+		return 0, 0, errors.New("Failed to parse /proc/meminfo")
+	}
+
+	return total, available, nil
+}
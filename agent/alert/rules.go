@@ -0,0 +1,211 @@
+package alert
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	HOST = "host"
+	//matches all containers
+	ALL = "*"
+)
+
+const (
+	CPU   = "cpu"
+	RAM   = "ram"
+	DISK  = "disk"
+	STATE = "state"
+)
+
+const (
+	LOG     = "log"
+	WEBHOOK = "webhook"
+	RESTART = "restart"
+)
+
+var (
+	metrics    = []string{CPU, RAM, DISK, STATE}
+	operators  = []string{">", ">=", "<", "<=", "==", "!="}
+	severities = []string{"info", "warning", "critical"}
+)
+
+// Rule describes a threshold over a host or container metric.
+// Rule fires once the metric keeps breaching the threshold longer than For duration.
+// Metric values are percents for cpu, ram and disk; state is 1 for running container and 0 otherwise
+type Rule struct {
+	Name      string        `yaml:"name"`
+	Metric    string        `yaml:"metric"`
+	Target    string        `yaml:"target"`
+	Operator  string        `yaml:"operator"`
+	Threshold float64       `yaml:"threshold"`
+	For       time.Duration `yaml:"for,omitempty"`
+	Severity  string        `yaml:"severity"`
+	Actions   []Action      `yaml:"actions"`
+}
+
+// Action is executed when rule starts firing or gets resolved.
+// Webhook action requires URL, restart action is applicable to container targets only
+type Action struct {
+	Type string `yaml:"type"`
+	URL  string `yaml:"url,omitempty"`
+}
+
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Validate checks that rule is well-formed
+func (r Rule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("Rule name is required")
+	}
+	if !contains(metrics, r.Metric) {
+		return errors.Errorf("Invalid metric %s, expected one of %s", r.Metric, strings.Join(metrics, ", "))
+	}
+	if strings.TrimSpace(r.Target) == "" {
+		return errors.New("Rule target is required")
+	}
+	if r.Metric == STATE && r.Target == HOST {
+		return errors.New("State metric is applicable to containers only")
+	}
+	if !contains(operators, r.Operator) {
+		return errors.Errorf("Invalid operator %s, expected one of %s", r.Operator, strings.Join(operators, " "))
+	}
+	if r.For < 0 {
+		return errors.New("Rule duration must not be negative")
+	}
+	if !contains(severities, r.Severity) {
+		return errors.Errorf("Invalid severity %s, expected one of %s", r.Severity, strings.Join(severities, ", "))
+	}
+	if len(r.Actions) == 0 {
+		return errors.New("At least one action is required")
+	}
+	for _, a := range r.Actions {
+		switch a.Type {
+		case LOG:
+		case WEBHOOK:
+			if strings.TrimSpace(a.URL) == "" {
+				return errors.New("Webhook action requires url")
+			}
+		case RESTART:
+			if r.Target == HOST {
+				return errors.New("Restart action is applicable to containers only")
+			}
+		default:
+			return errors.Errorf("Invalid action %s, expected one of %s, %s, %s", a.Type, LOG, WEBHOOK, RESTART)
+		}
+	}
+
+	return nil
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%s %s %s %v", r.Target, r.Metric, r.Operator, r.Threshold)
+}
+
+// breached checks metric value against rule threshold
+func (r Rule) breached(value float64) bool {
+	switch r.Operator {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	return false
+}
+
+// LoadRules reads rules from file specified by config.Agent.AlertRules.
+// Missing file means no rules
+func LoadRules() ([]Rule, error) {
+	data, err := ioutil.ReadFile(config.Agent.AlertRules)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file rulesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, errors.Errorf("Error parsing %s: %s", config.Agent.AlertRules, err.Error())
+	}
+
+	for _, rule := range file.Rules {
+		if err := rule.Validate(); err != nil {
+			return nil, errors.Errorf("Invalid rule %s: %s", rule.Name, err.Error())
+		}
+	}
+
+	return file.Rules, nil
+}
+
+// SaveRules writes rules to file specified by config.Agent.AlertRules
+func SaveRules(rules []Rule) error {
+	data, err := yaml.Marshal(rulesFile{Rules: rules})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(config.Agent.AlertRules, data, 0644)
+}
+
+// AddRule validates and adds rule, replacing existing rule with the same name
+func AddRule(rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	rules, err := LoadRules()
+	if err != nil {
+		return err
+	}
+
+	for i, r := range rules {
+		if r.Name == rule.Name {
+			rules[i] = rule
+			return SaveRules(rules)
+		}
+	}
+
+	return SaveRules(append(rules, rule))
+}
+
+// RemoveRule removes rule by name
+func RemoveRule(name string) error {
+	rules, err := LoadRules()
+	if err != nil {
+		return err
+	}
+
+	for i, r := range rules {
+		if r.Name == name {
+			return SaveRules(append(rules[:i], rules[i+1:]...))
+		}
+	}
+
+	return errors.Errorf("Rule %s not found", name)
+}
+
+func contains(list []string, s string) bool {
+	for _, i := range list {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
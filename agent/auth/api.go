@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/subutai-io/agent/agent/cluster"
+	"github.com/subutai-io/agent/agent/limiter"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// ExecPath is endpoint running subutai command for authenticated caller
+const ExecPath = "/api/exec"
+
+// header caller may pass operation id in, see "subutai operation", it is returned in the same header
+const operationHeader = "X-Operation-Id"
+
+// ExecHandler runs subutai command of request if caller is authenticated and its role allows the command. API is
+// served on TLS listener only so credentials are never sent in clear, e.g.
+//
+//	curl -H "Authorization: Bearer $TOKEN" -d '{"args":["list","containers"]}' https://host:8444/api/exec
+func ExecHandler(rw http.ResponseWriter, request *http.Request) {
+	if !Enabled() || request.TLS == nil || request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	identity, err := Authenticate(request)
+	if err != nil {
+		log.Warn("Authenticating API request from " + request.RemoteAddr + ": " + err.Error())
+		limiter.Fail(request)
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="subutai"`)
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, request.Body, 1<<20))
+	var command struct {
+		Args []string `json:"args"`
+	}
+	if err != nil || json.Unmarshal(body, &command) != nil || len(command.Args) == 0 {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, arg := range command.Args {
+		//forwarding to peers or starting another daemon is not allowed
+		if arg == "daemon" || arg == "--host" || strings.HasPrefix(arg, "--host=") {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if !Allowed(identity.Role, command.Args) {
+		log.Warn("API caller " + identity.Name + " with role " + identity.Role + " is not allowed to run " +
+			strings.Join(command.Args, " "))
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	operation := request.Header.Get(operationHeader)
+	if !common.IsOperationID(operation) {
+		operation = common.NewOperationID()
+	}
+	log.Info("Running command of API caller " + identity.Name + " (" + identity.Backend + ", " + identity.Role +
+		"), operation " + operation + ": " + strings.Join(command.Args, " "))
+
+	self, err := os.Executable()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	options := exec.Options{Env: []string{common.OperationEnv + "=" + operation}}
+	result, err := exec.Run(context.Background(), options, self, command.Args...)
+	execResult := cluster.ExecResult{Stdout: string(result.Stdout), Stderr: string(result.Stderr), ExitCode: result.ExitCode}
+	if err != nil && execResult.ExitCode <= 0 {
+		execResult.ExitCode = 1
+		execResult.Stderr += err.Error() + "\n"
+	}
+
+	response, err := json.Marshal(execResult)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set(operationHeader, operation)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(response)
+}
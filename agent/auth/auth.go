@@ -0,0 +1,186 @@
+// Package auth authenticates callers of daemon API with tokens validated by pluggable backends: local tokens
+// created with "subutai api token create", tokens of OpenID Connect provider and LDAP credentials. Groups of
+// OIDC and LDAP users are mapped to roles which limit subutai commands caller may run
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+)
+
+// Roles of API callers, from the most privileged
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+var Roles = []string{RoleAdmin, RoleOperator, RoleViewer}
+
+// commands which may be run by roles other than admin, admin may run any command. Subcommand is matched
+// if command is listed with it
+var (
+	viewerCommands = []string{"list", "exists", "info", "stats", "metrics", "drift", "quota get",
+		"template info", "template inspect", "template outdated", "snapshot list", "tenant list", "cluster peers"}
+	operatorCommands = append([]string{"start", "stop", "restart", "quota", "snapshot create", "logs", "packages",
+		"scan", "audit", "operation"}, viewerCommands...)
+)
+
+// flags of commands allowed to role which change state of host, so role may not pass them
+var deniedFlags = map[string][]string{
+	RoleViewer: {"drift --reconcile"},
+}
+
+// ErrUnauthenticated is returned when credentials are missing or none of backends accepts them
+var ErrUnauthenticated = errors.New("Invalid credentials")
+
+// Identity is authenticated caller of API
+type Identity struct {
+	Name    string
+	Role    string
+	Backend string
+}
+
+// Backend validates credentials of request, it returns nil identity if credentials are not of its kind,
+// e.g. basic auth credentials passed to token backend
+type Backend interface {
+	Name() string
+	Authenticate(request *http.Request) (*Identity, error)
+}
+
+// Enabled checks if API has backends configured
+func Enabled() bool {
+	return len(backends()) > 0
+}
+
+// Authenticate validates credentials of request with backends in order they are configured
+func Authenticate(request *http.Request) (*Identity, error) {
+	for _, backend := range backends() {
+		identity, err := backend.Authenticate(request)
+		if err != nil {
+			return nil, errors.Wrap(err, backend.Name())
+		}
+		if identity != nil {
+			identity.Backend = backend.Name()
+			return identity, nil
+		}
+	}
+
+	return nil, ErrUnauthenticated
+}
+
+// IsRole checks if role is one of known roles
+func IsRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed checks if role may run subutai command with args
+func Allowed(role string, args []string) bool {
+	switch role {
+	case RoleAdmin:
+		return true
+	case RoleOperator:
+		return matches(args, operatorCommands) && !hasFlag(args, deniedFlags[role])
+	case RoleViewer:
+		return matches(args, viewerCommands) && !hasFlag(args, deniedFlags[role])
+	}
+	return false
+}
+
+// hasFlag checks if args run one of commands listed with flag, e.g. "drift --reconcile", passing that flag in
+// any form kingpin accepts it
+func hasFlag(args []string, flags []string) bool {
+	for _, f := range flags {
+		words := strings.Fields(f)
+		flag := words[len(words)-1]
+		if !matches(args, []string{strings.Join(words[:len(words)-1], " ")}) {
+			continue
+		}
+		for _, arg := range args {
+			//the rest are positional arguments
+			if arg == "--" {
+				break
+			}
+			if arg == flag || strings.HasPrefix(arg, flag+"=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matches(args []string, commands []string) bool {
+	//global debug flag is the only flag allowed before command
+	for len(args) > 0 && (args[0] == "-d" || args[0] == "--debug") {
+		args = args[1:]
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return false
+	}
+
+	for _, c := range commands {
+		words := strings.Fields(c)
+		if len(words) > len(args) {
+			continue
+		}
+		matched := true
+		for i, w := range words {
+			matched = matched && args[i] == w
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// roleOf returns the most privileged role groups are mapped to, empty if none of groups is mapped
+func roleOf(groups []string) string {
+	mapped := map[string]string{
+		RoleAdmin:    config.API.AdminGroups,
+		RoleOperator: config.API.OperatorGroups,
+		RoleViewer:   config.API.ViewerGroups,
+	}
+	for _, role := range Roles {
+		for _, g := range strings.Split(mapped[role], ",") {
+			for _, group := range groups {
+				if g = strings.TrimSpace(g); g != "" && g == group {
+					return role
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func backends() []Backend {
+	var list []Backend
+	for _, name := range strings.Split(config.API.Backends, ",") {
+		switch strings.TrimSpace(name) {
+		case "local":
+			list = append(list, localBackend{})
+		case "oidc":
+			list = append(list, oidcBackend{})
+		case "ldap":
+			list = append(list, ldapBackend{})
+		}
+	}
+	return list
+}
+
+// bearerToken returns token of Authorization header, empty if request is not authorized with bearer token
+func bearerToken(request *http.Request) string {
+	header := request.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/exec"
+)
+
+// ldapBackend validates basic auth credentials by binding to LDAP server as user, groups of user are those
+// under group base listing DN of user as member. OpenLDAP client tools are used for LDAP operations
+type ldapBackend struct{}
+
+func (ldapBackend) Name() string {
+	return "ldap"
+}
+
+func (ldapBackend) Authenticate(request *http.Request) (*Identity, error) {
+	user, password, ok := request.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	if config.API.LdapUrl == "" || !strings.Contains(config.API.LdapUserDn, "%s") {
+		return nil, errors.New("LDAP server or user DN is not configured")
+	}
+	//user name is put into DN and search filter, characters special to either are refused
+	if user == "" || password == "" || strings.ContainsAny(user, `,=+<>#;\"*()/`+"\x00") {
+		return nil, ErrUnauthenticated
+	}
+	dn := fmt.Sprintf(config.API.LdapUserDn, user)
+
+	//password is passed in file, so it is not seen in process list
+	file, err := ioutil.TempFile("", "ldap")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(password)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	bind := []string{"-x", "-H", config.API.LdapUrl, "-D", dn, "-y", file.Name()}
+	if _, err = exec.Run(context.Background(), exec.Options{Quiet: true}, "ldapwhoami", bind...); err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	var groups []string
+	if config.API.LdapGroupBase != "" {
+		args := append(bind, "-LLL", "-b", config.API.LdapGroupBase, "(member="+escapeFilter(dn)+")", "cn")
+		result, err := exec.Run(context.Background(), exec.Options{Quiet: true}, "ldapsearch", args...)
+		if err != nil {
+			return nil, errors.Wrap(err, "searching groups of "+user)
+		}
+		for _, line := range strings.Split(string(result.Stdout), "\n") {
+			if strings.HasPrefix(line, "cn: ") {
+				groups = append(groups, strings.TrimSpace(line[4:]))
+			}
+		}
+	}
+
+	role := roleOf(groups)
+	if role == "" {
+		return nil, errors.Errorf("None of groups of %s is mapped to role", user)
+	}
+
+	return &Identity{Name: user, Role: role}, nil
+}
+
+// escapeFilter escapes value put into LDAP search filter
+func escapeFilter(value string) string {
+	return strings.NewReplacer(`\`, `\5c`, `*`, `\2a`, `(`, `\28`, `)`, `\29`, "\x00", `\00`).Replace(value)
+}
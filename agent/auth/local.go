@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/gpg"
+)
+
+// localBackend validates tokens created with "subutai api token create"
+type localBackend struct{}
+
+func (localBackend) Name() string {
+	return "local"
+}
+
+func (localBackend) Authenticate(request *http.Request) (*Identity, error) {
+	token := bearerToken(request)
+	if token == "" {
+		return nil, nil
+	}
+
+	t, err := db.FindApiToken("Hash", gpg.HashToken(token))
+	if err != nil || t == nil {
+		return nil, err
+	}
+	switch {
+	case t.Revoked:
+		return nil, errors.Errorf("Token %s is revoked", t.Name)
+	case !t.Expires.IsZero() && time.Now().After(t.Expires):
+		return nil, errors.Errorf("Token %s expired at %s", t.Name, t.Expires.Format(time.RFC3339))
+	}
+
+	return &Identity{Name: t.Name, Role: t.Role}, nil
+}
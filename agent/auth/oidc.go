@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/config"
+)
+
+// keys of provider are fetched again after this time, or when token is signed with unknown key
+const keysTtl = time.Hour
+
+// oidcBackend validates RS256 signed JWTs issued by OpenID Connect provider against its published keys
+type oidcBackend struct{}
+
+var keyCache struct {
+	sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func (oidcBackend) Name() string {
+	return "oidc"
+}
+
+func (oidcBackend) Authenticate(request *http.Request) (*Identity, error) {
+	token := bearerToken(request)
+	if strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+	if config.API.OidcIssuer == "" {
+		return nil, errors.New("OIDC issuer is not configured")
+	}
+
+	claims, err := verifyJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(config.API.OidcIssuer, "/") {
+		return nil, errors.Errorf("Token is issued by %s", iss)
+	}
+	if config.API.OidcAudience != "" && !hasAudience(claims["aud"], config.API.OidcAudience) {
+		return nil, errors.New("Token is issued for another audience")
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); !ok || now > exp {
+		return nil, errors.New("Token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, errors.New("Token is not valid yet")
+	}
+
+	name, _ := claims["preferred_username"].(string)
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+	var groups []string
+	if list, ok := claims[config.API.OidcGroupsClaim].([]interface{}); ok {
+		for _, g := range list {
+			if group, ok := g.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+	role := roleOf(groups)
+	if role == "" {
+		return nil, errors.Errorf("None of groups of %s is mapped to role", name)
+	}
+
+	return &Identity{Name: name, Role: role}, nil
+}
+
+// verifyJWT checks signature of token and returns its claims
+func verifyJWT(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.Wrap(err, "parsing token header")
+	}
+	if header.Alg != "RS256" {
+		return nil, errors.Errorf("Unsupported token algorithm %s, RS256 expected", header.Alg)
+	}
+
+	key, err := signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("Invalid token signature")
+	}
+
+	claims := make(map[string]interface{})
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.Wrap(err, "parsing token claims")
+	}
+	return claims, nil
+}
+
+// signingKey returns key of provider with id, keys are fetched again if key is not known
+func signingKey(kid string) (*rsa.PublicKey, error) {
+	keyCache.Lock()
+	defer keyCache.Unlock()
+
+	if key, ok := keyCache.keys[kid]; ok && time.Since(keyCache.fetched) < keysTtl {
+		return key, nil
+	}
+	//unknown keys do not make us hit provider more often than once a minute
+	if time.Since(keyCache.fetched) < time.Minute {
+		return nil, errors.Errorf("Unknown signing key %s", kid)
+	}
+
+	keys, err := fetchKeys()
+	keyCache.fetched = time.Now()
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching keys of OIDC provider")
+	}
+	keyCache.keys = keys
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.Errorf("Unknown signing key %s", kid)
+}
+
+// fetchKeys reads RSA keys of provider from JWKS published in its discovery document
+func fetchKeys() (map[string]*rsa.PublicKey, error) {
+	var discovery struct {
+		JwksUri string `json:"jwks_uri"`
+	}
+	issuer := strings.TrimSuffix(config.API.OidcIssuer, "/")
+	if err := getJSON(issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.JwksUri == "" {
+		return nil, errors.New("Provider does not publish jwks_uri")
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(discovery.JwksUri, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	return keys, nil
+}
+
+func getJSON(url string, to interface{}) error {
+	clnt := util.GetClient(false, 15)
+	resp, err := clnt.Get(url)
+	if err != nil {
+		return err
+	}
+	defer util.Close(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("HTTP status of %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(to)
+}
+
+func decodeSegment(segment string, to interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+// hasAudience checks aud claim which is either a string or a list of strings
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,490 @@
+// Package cluster lets resource hosts find each other and run subutai commands on behalf of each other,
+// e.g. "subutai --host rh2 list". Peers are listed in [cluster] section of config and, with gossip turned on,
+// learnt from peer lists of other peers. Peers talk over TLS listener of daemon and authenticate each other
+// with certificates issued by cluster CA, see [tls] section of config. Requests and responses are signed
+// with shared secret too and every request is accepted only once
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	gonet "net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/limiter"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/log"
+)
+
+// endpoints served by agent daemon
+const (
+	ExecPath  = "/cluster/exec"
+	PeersPath = "/cluster/peers"
+)
+
+const (
+	timestampHeader = "X-Subutai-Timestamp"
+	nonceHeader     = "X-Subutai-Nonce"
+	signatureHeader = "X-Subutai-Signature"
+	//requests older than this are rejected, nonces of newer ones are remembered to reject replays
+	maxSkew = time.Minute
+	//limit of request body
+	maxBody = 1 << 20
+)
+
+// ExecResult holds outcome of command run on peer
+type ExecResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+}
+
+type execRequest struct {
+	Args []string `json:"args"`
+}
+
+type peerInfo struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type peersResponse struct {
+	Name  string     `json:"name"`
+	Peers []peerInfo `json:"peers"`
+}
+
+// nonces of requests accepted within maxSkew, with times they were seen
+var (
+	noncesLock sync.Mutex
+	nonces     = make(map[string]time.Time)
+)
+
+// Enabled tells if cluster mode is turned on and configured
+func Enabled() bool {
+	return config.Cluster.Enabled && config.Cluster.Secret != "" && ServesTLS() && config.TLS.ClusterCa != ""
+}
+
+// stamp identifies request, response is signed with stamp of request so it can not be replayed as response
+// to another request
+type stamp struct {
+	timestamp string
+	nonce     string
+}
+
+func newStamp() (stamp, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return stamp{}, err
+	}
+	return stamp{timestamp: strconv.FormatInt(time.Now().Unix(), 10), nonce: hex.EncodeToString(nonce)}, nil
+}
+
+func stampOf(header http.Header) stamp {
+	return stamp{timestamp: header.Get(timestampHeader), nonce: header.Get(nonceHeader)}
+}
+
+func (s stamp) set(header http.Header) {
+	header.Set(timestampHeader, s.timestamp)
+	header.Set(nonceHeader, s.nonce)
+}
+
+func sign(s stamp, kind string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(config.Cluster.Secret))
+	mac.Write([]byte(s.timestamp + "\n" + s.nonce + "\n" + kind + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks signature of request or response
+func verify(s stamp, kind, signature string, body []byte) error {
+	expected := sign(s, kind, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("Invalid signature")
+	}
+	return nil
+}
+
+// verifyResponse checks that response is signed for request with stamp s
+func verifyResponse(response *http.Response, s stamp, kind string, body []byte) error {
+	if stampOf(response.Header) != s ||
+		verify(s, "response:"+kind, response.Header.Get(signatureHeader), body) != nil {
+		return errors.Errorf("Peer %s failed authentication", response.Request.URL.Host)
+	}
+	return nil
+}
+
+func checkRequest(rw http.ResponseWriter, request *http.Request, kind string) ([]byte, error) {
+	if err := verifiedPeer(request); err != nil {
+		limiter.Fail(request)
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, request.Body, maxBody))
+	if err != nil {
+		return nil, err
+	}
+
+	s := stampOf(request.Header)
+	sec, err := strconv.ParseInt(s.timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, errors.New("Request expired")
+	}
+	if len(s.nonce) != 32 {
+		return nil, errors.New("Invalid nonce")
+	}
+	if err = verify(s, kind, request.Header.Get(signatureHeader), body); err != nil {
+		limiter.Fail(request)
+		return nil, err
+	}
+
+	return body, checkNonce(s.nonce)
+}
+
+// checkNonce remembers nonce of authenticated request, request with nonce seen before is replayed one.
+// Nonces are forgotten once requests carrying them expire anyway
+func checkNonce(nonce string) error {
+	noncesLock.Lock()
+	defer noncesLock.Unlock()
+
+	for n, seen := range nonces {
+		if time.Since(seen) > 2*maxSkew {
+			delete(nonces, n)
+		}
+	}
+	if _, replayed := nonces[nonce]; replayed {
+		return errors.New("Request replayed")
+	}
+	nonces[nonce] = time.Now()
+
+	return nil
+}
+
+func respond(rw http.ResponseWriter, request *http.Request, kind string, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s := stampOf(request.Header)
+	s.set(rw.Header())
+	rw.Header().Set(signatureHeader, sign(s, "response:"+kind, body))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(body)
+}
+
+// ExecHandler runs subutai command requested by peer
+func ExecHandler(rw http.ResponseWriter, request *http.Request) {
+	if !Enabled() || request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := checkRequest(rw, request, ExecPath)
+	if log.Check(log.WarnLevel, "Authenticating cluster request from "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var command execRequest
+	if err = json.Unmarshal(body, &command); err != nil || len(command.Args) == 0 {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, arg := range command.Args {
+		//forwarding further or starting another daemon is not allowed
+		if arg == "daemon" || arg == "--host" || strings.HasPrefix(arg, "--host=") {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	log.Info("Running command of cluster peer " + request.RemoteAddr + ": " + strings.Join(command.Args, " "))
+
+	self, err := os.Executable()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	result, err := exec.Run(context.Background(), exec.Options{}, self, command.Args...)
+	execResult := ExecResult{Stdout: string(result.Stdout), Stderr: string(result.Stderr), ExitCode: result.ExitCode}
+	if err != nil && execResult.ExitCode <= 0 {
+		execResult.ExitCode = 1
+		execResult.Stderr += err.Error() + "\n"
+	}
+
+	respond(rw, request, ExecPath, execResult)
+}
+
+// PeersHandler returns name of host and peers it knows
+func PeersHandler(rw http.ResponseWriter, request *http.Request) {
+	if !Enabled() || request.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if _, err := checkRequest(rw, request, PeersPath); log.Check(log.WarnLevel,
+		"Authenticating cluster request from "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	name, _ := os.Hostname()
+	response := peersResponse{Name: name}
+
+	peers, err := db.GetAllClusterPeers()
+	if log.Check(log.WarnLevel, "Reading cluster peers", err) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, peer := range peers {
+		response.Peers = append(response.Peers, peerInfo{Name: peer.Name, Address: peer.Address})
+	}
+
+	respond(rw, request, PeersPath, response)
+}
+
+// call sends signed request to peer and decodes signed response into result
+func call(address, method, path string, payload interface{}, timeout time.Duration, result interface{}) error {
+	client, err := peerClient(0)
+	if err != nil {
+		return err
+	}
+	client.Timeout = timeout
+
+	response, s, err := send(address, method, path, payload, client)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if err = verifyResponse(response, s, path, data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// peerClient returns client calling peers over TLS, only waiting for connection and response headers is
+// limited in time for responses which may take long to transfer, 0 headerTimeout means no limit
+func peerClient(headerTimeout time.Duration) (*http.Client, error) {
+	tlsConfig, err := clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext:           (&gonet.Dialer{Timeout: 5 * time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: headerTimeout,
+	}}, nil
+}
+
+// send sends signed request to peer, response with status other than 200 is returned as error.
+// Stamp request is signed with is returned to verify response
+func send(address, method, path string, payload interface{}, client *http.Client) (*http.Response, stamp, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, stamp{}, err
+		}
+	}
+
+	request, err := http.NewRequest(method, "https://"+withPort(address)+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, stamp{}, err
+	}
+	s, err := newStamp()
+	if err != nil {
+		return nil, stamp{}, err
+	}
+	s.set(request.Header)
+	request.Header.Set(signatureHeader, sign(s, path, body))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, stamp{}, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, stamp{}, errors.Errorf("Peer %s replied with status %d", address, response.StatusCode)
+	}
+
+	return response, s, nil
+}
+
+func withPort(address string) string {
+	if _, _, err := gonet.SplitHostPort(address); err == nil {
+		return address
+	}
+	return gonet.JoinHostPort(address, config.TLS.Port)
+}
+
+// Exec runs subutai command with args on peer found by name or address
+func Exec(host string, args []string) (ExecResult, error) {
+	var result ExecResult
+
+	if !Enabled() {
+		return result, errors.New("Cluster mode is not enabled or not configured")
+	}
+
+	address, err := peerAddress(host)
+	if err != nil {
+		return result, err
+	}
+
+	//commands are not limited in time, as if they were run locally
+	err = call(address, http.MethodPost, ExecPath, execRequest{Args: args}, 0, &result)
+
+	return result, err
+}
+
+// peerAddress returns address of peer found by name or address, host is returned as is if it is not known peer
+func peerAddress(host string) (string, error) {
+	peer, err := db.FindClusterPeer(host)
+	if err != nil {
+		return "", err
+	}
+	if peer != nil {
+		return peer.Address, nil
+	}
+	return host, nil
+}
+
+// Ping checks if peer found by name or address responds to cluster requests
+func Ping(host string) error {
+	if !Enabled() {
+		return errors.New("Cluster mode is not enabled or not configured")
+	}
+
+	address, err := peerAddress(host)
+	if err != nil {
+		return err
+	}
+
+	var response peersResponse
+	return call(address, http.MethodGet, PeersPath, nil, 10*time.Second, &response)
+}
+
+// Monitor discovers peers periodically while cluster mode is on
+func Monitor() {
+	if !config.Cluster.Enabled {
+		return
+	}
+	if config.Cluster.Secret == "" {
+		log.Warn("Cluster mode requires secret, set it in [cluster] section of agent config")
+		return
+	}
+	if !Enabled() {
+		log.Warn("Cluster mode requires certificate, key and cluster CA, set them in [tls] section of agent config")
+		return
+	}
+
+	for {
+		discover()
+		time.Sleep(discoveryInterval())
+	}
+}
+
+func discoveryInterval() time.Duration {
+	interval := time.Duration(config.Cluster.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return interval
+}
+
+// discover asks peers for their names and peer lists, learnt peers are contacted in the next round
+func discover() {
+	name, _ := os.Hostname()
+	ip := net.GetIp()
+
+	static := make(map[string]bool)
+	for _, address := range strings.Split(config.Cluster.Peers, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			static[address] = true
+		}
+	}
+
+	addresses := make(map[string]bool)
+	for address := range static {
+		addresses[address] = true
+	}
+	if config.Cluster.Gossip {
+		peers, err := db.GetAllClusterPeers()
+		if log.Check(log.WarnLevel, "Reading cluster peers", err) {
+			return
+		}
+		for _, peer := range peers {
+			addresses[peer.Address] = true
+		}
+	}
+
+	for address := range addresses {
+		if address == ip {
+			continue
+		}
+
+		var response peersResponse
+		if log.Check(log.DebugLevel, "Contacting cluster peer "+address,
+			call(address, http.MethodGet, PeersPath, nil, 10*time.Second, &response)) {
+			continue
+		}
+
+		savePeer(db.ClusterPeer{Name: response.Name, Address: address, Static: static[address], Seen: time.Now()})
+
+		if config.Cluster.Gossip {
+			for _, peer := range response.Peers {
+				if peer.Name != name && peer.Address != ip && !addresses[peer.Address] {
+					savePeer(db.ClusterPeer{Name: peer.Name, Address: peer.Address})
+				}
+			}
+		}
+	}
+}
+
+// savePeer records peer, for peer learnt from another one time it was seen last and static flag are kept
+func savePeer(peer db.ClusterPeer) {
+	if peer.Name == "" {
+		return
+	}
+
+	existing, err := db.FindClusterPeer(peer.Name)
+	if log.Check(log.WarnLevel, "Reading cluster peer", err) {
+		return
+	}
+	if existing != nil {
+		peer.Id = existing.Id
+		if peer.Seen.IsZero() {
+			peer.Seen, peer.Static = existing.Seen, existing.Static
+		}
+	}
+
+	log.Check(log.WarnLevel, "Saving cluster peer "+peer.Name, db.SaveClusterPeer(&peer))
+}
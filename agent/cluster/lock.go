@@ -0,0 +1,354 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/log"
+)
+
+// endpoints granting and listing leases of cluster-wide locks
+const (
+	LockPath  = "/cluster/lock"
+	LocksPath = "/cluster/locks"
+)
+
+// Lock is granted by majority of cluster members, i.e. this host and peers listed in [cluster] section of config,
+// so two hosts can not hold the same lock even if some members are unreachable. Peers learnt by gossip are not
+// members, otherwise isolated host would count majority over peers it still sees. Every member grants lease,
+// which expires after ttl unless renewed, so lock of crashed holder is released by itself.
+// Leases are kept in db, restarted member remembers leases it granted
+const (
+	MinLockTtl = time.Second * 5
+	MaxLockTtl = time.Hour
+)
+
+var lockNameRx = regexp.MustCompile(`^[[:alnum:]_.:-]+$`)
+
+// Lease describes lock granted by host
+type Lease struct {
+	Name    string    `json:"name"`
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+type lockRequest struct {
+	Name    string `json:"name"`
+	Holder  string `json:"holder"`
+	Ttl     int    `json:"ttl"`
+	Release bool   `json:"release"`
+}
+
+type lockResponse struct {
+	Granted bool `json:"granted"`
+	//holder of lease which prevented grant
+	Holder string `json:"holder,omitempty"`
+}
+
+//leases are read and written by daemon only, lock serializes requests of members
+var leasesLock sync.Mutex
+
+// LockHandler grants, renews and releases leases requested by members
+func LockHandler(rw http.ResponseWriter, request *http.Request) {
+	if !Enabled() || request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := checkRequest(rw, request, LockPath)
+	if log.Check(log.WarnLevel, "Authenticating cluster request from "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var lock lockRequest
+	if err = json.Unmarshal(body, &lock); err != nil || !lockNameRx.MatchString(lock.Name) || lock.Holder == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	response, err := grant(lock)
+	if log.Check(log.WarnLevel, "Granting lease of lock "+lock.Name, err) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respond(rw, request, LockPath, response)
+}
+
+func grant(lock lockRequest) (lockResponse, error) {
+	leasesLock.Lock()
+	defer leasesLock.Unlock()
+
+	leases, err := db.GetAllClusterLeases()
+	if err != nil {
+		return lockResponse{}, err
+	}
+	var lease db.ClusterLease
+	held := false
+	for _, l := range leases {
+		if l.Name == lock.Name && time.Now().Before(l.Expires) {
+			lease, held = l, true
+		}
+	}
+
+	if lock.Release {
+		if held && lease.Holder == lock.Holder {
+			return lockResponse{Granted: true}, db.RemoveClusterLease(lock.Name)
+		}
+		return lockResponse{Granted: true}, nil
+	}
+
+	if held && lease.Holder != lock.Holder {
+		return lockResponse{Holder: lease.Holder}, nil
+	}
+
+	ttl := time.Duration(lock.Ttl) * time.Second
+	if ttl < MinLockTtl {
+		ttl = MinLockTtl
+	} else if ttl > MaxLockTtl {
+		ttl = MaxLockTtl
+	}
+
+	//lease is granted only once it is stored, so it survives restart of daemon
+	err = db.SaveClusterLease(&db.ClusterLease{Name: lock.Name, Holder: lock.Holder, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return lockResponse{}, err
+	}
+
+	return lockResponse{Granted: true}, nil
+}
+
+// LocksHandler returns leases granted by host
+func LocksHandler(rw http.ResponseWriter, request *http.Request) {
+	if !Enabled() || request.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if _, err := checkRequest(rw, request, LocksPath); log.Check(log.WarnLevel,
+		"Authenticating cluster request from "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	leasesLock.Lock()
+	leases, err := db.GetAllClusterLeases()
+	leasesLock.Unlock()
+	if log.Check(log.WarnLevel, "Reading leases of cluster locks", err) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	result := []Lease{}
+	for _, lease := range leases {
+		if time.Now().Before(lease.Expires) {
+			result = append(result, Lease{Name: lease.Name, Holder: lease.Holder, Expires: lease.Expires})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	respond(rw, request, LocksPath, result)
+}
+
+// Lock is cluster-wide lock held by this process
+type Lock struct {
+	Name   string
+	Holder string
+	ttl    time.Duration
+	//addresses of members lease was requested from
+	members []string
+
+	mu      sync.Mutex
+	expires time.Time
+	cancel  context.CancelFunc
+}
+
+// Acquire takes cluster-wide lock for ttl, error is returned if lock is held by another holder
+// or majority of members can not be reached
+func Acquire(name string, ttl time.Duration) (*Lock, error) {
+	if !Enabled() {
+		return nil, errors.New("Cluster mode is not enabled or not configured")
+	}
+	if !lockNameRx.MatchString(name) {
+		return nil, errors.Errorf("Invalid lock name %s", name)
+	}
+	if ttl < MinLockTtl || ttl > MaxLockTtl {
+		return nil, errors.Errorf("Lock ttl must be between %s and %s", MinLockTtl, MaxLockTtl)
+	}
+
+	hostname, _ := os.Hostname()
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	lock := &Lock{Name: name, Holder: hostname + "/" + strconv.Itoa(os.Getpid()) + "/" + hex.EncodeToString(token),
+		ttl: ttl, members: members()}
+
+	if err := lock.Renew(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// members returns addresses of cluster members locks are granted by: this host, whose daemon keeps leases,
+// and peers listed in config. Membership does not depend on which peers are reachable
+func members() []string {
+	ip := net.GetIp()
+	result := []string{"127.0.0.1"}
+	seen := map[string]bool{"127.0.0.1": true, ip: true}
+	for _, address := range strings.Split(config.Cluster.Peers, ",") {
+		if address = strings.TrimSpace(address); address != "" && !seen[address] {
+			seen[address] = true
+			result = append(result, address)
+		}
+	}
+	return result
+}
+
+// Hold keeps lock renewed until it is released, returned context is canceled once lease expires without
+// being renewed, e.g. when majority of members becomes unreachable, so work done under lock can be stopped
+// before another holder takes it
+func (l *Lock) Hold(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	l.mu.Lock()
+	l.cancel = cancel
+	l.mu.Unlock()
+
+	go func() {
+		//leases are renewed several times per ttl, so one missed renewal does not lose lock
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := l.Renew()
+				if err == nil {
+					continue
+				}
+				if !l.Valid() {
+					log.Warn("Cluster lock " + l.Name + " is lost: " + err.Error())
+					cancel()
+					return
+				}
+				log.Debug("Renewing cluster lock " + l.Name + ": " + err.Error())
+			}
+		}
+	}()
+
+	return ctx
+}
+
+// Renew extends lease of lock for another ttl, lock must be renewed before it expires to stay held
+func (l *Lock) Renew() error {
+	start := time.Now()
+	responses := l.request(false)
+
+	granted := 0
+	holder := ""
+	for _, response := range responses {
+		if response.Granted {
+			granted++
+		} else if response.Holder != "" {
+			holder = response.Holder
+		}
+	}
+
+	if granted*2 <= len(l.members) {
+		if holder != "" {
+			return errors.Errorf("Lock %s is held by %s", l.Name, holder)
+		}
+		return errors.Errorf("Lock %s granted by %d of %d cluster members, majority required",
+			l.Name, granted, len(l.members))
+	}
+
+	//leases are counted by members from the moment they receive request, so holder counts from sending it
+	l.mu.Lock()
+	l.expires = start.Add(l.ttl)
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Valid tells if lease of lock has not expired yet
+func (l *Lock) Valid() bool {
+	return time.Now().Before(l.Expires())
+}
+
+// Expires returns time lease of lock expires at unless it is renewed
+func (l *Lock) Expires() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expires
+}
+
+// Release returns leases of lock to members, leases of unreachable members expire by themselves.
+// Context returned by Hold is canceled
+func (l *Lock) Release() {
+	l.mu.Lock()
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.expires = time.Time{}
+	l.mu.Unlock()
+
+	l.request(true)
+}
+
+// request sends lease request to all members at once, unreachable members are left out of responses
+func (l *Lock) request(release bool) []lockResponse {
+	payload := lockRequest{Name: l.Name, Holder: l.Holder, Ttl: int(l.ttl / time.Second), Release: release}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var responses []lockResponse
+	for _, address := range l.members {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+
+			var response lockResponse
+			if log.Check(log.DebugLevel, "Requesting lease of lock "+l.Name+" from "+address,
+				call(address, http.MethodPost, LockPath, payload, 5*time.Second, &response)) {
+				return
+			}
+
+			mu.Lock()
+			responses = append(responses, response)
+			mu.Unlock()
+		}(address)
+	}
+	wg.Wait()
+
+	return responses
+}
+
+// Leases returns leases granted by daemon of this host
+func Leases() ([]Lease, error) {
+	if !Enabled() {
+		return nil, errors.New("Cluster mode is not enabled or not configured")
+	}
+
+	var result []Lease
+	err := call("127.0.0.1", http.MethodGet, LocksPath, nil, 10*time.Second, &result)
+
+	return result, err
+}
@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// PlacementPath is endpoint telling scheduler of several hosts if container can be placed on this host
+const PlacementPath = "/cluster/placement"
+
+var (
+	placementTemplateRx = regexp.MustCompile(`^[[:alnum:]][[:alnum:]_.@:-]*$`)
+	placementSizeRx     = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+)
+
+type placementRequest struct {
+	Template string `json:"template"`
+	Size     string `json:"size"`
+}
+
+// PlacementHandler returns placement of container of requested template and size on this host,
+// see "subutai host placement"
+func PlacementHandler(rw http.ResponseWriter, request *http.Request) {
+	if !Enabled() || request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := checkRequest(rw, request, PlacementPath)
+	if log.Check(log.WarnLevel, "Authenticating cluster request from "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var placement placementRequest
+	if err = json.Unmarshal(body, &placement); err != nil ||
+		!placementTemplateRx.MatchString(placement.Template) || !placementSizeRx.MatchString(placement.Size) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	result, err := exec.Run(context.Background(), exec.Options{Timeout: time.Minute}, self,
+		"host", "placement", placement.Template, "--size", placement.Size, "--json")
+	if log.Check(log.WarnLevel, "Evaluating placement of "+placement.Template, err) {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	respond(rw, request, PlacementPath, json.RawMessage(result.Stdout))
+}
@@ -0,0 +1,267 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// Management container of primary host is replicated to standby host with snapshots: standby asks primary
+// to take snapshot of management and pulls incremental streams of its partitions since the previous snapshot
+// standby received. Only the latest replicated snapshot is kept on both hosts as base of the next stream
+const (
+	ReplicaPath       = "/cluster/replica"
+	ReplicaStreamPath = "/cluster/replica/stream"
+	// ReplicaPrefix starts labels of snapshots taken for replication
+	ReplicaPrefix = "ha-"
+)
+
+var replicaLabelRx = regexp.MustCompile(`^` + ReplicaPrefix + `[0-9]+$`)
+
+// Replica describes snapshot of management container taken for standby together with files kept outside of
+// container partitions. Secrets of primary host, e.g. management credentials, are not sent
+type Replica struct {
+	Label string `json:"label"`
+	//template management is cloned from, standby needs it to receive the first stream
+	Parent    string `json:"parent"`
+	Config    string `json:"config"`
+	PublicKey string `json:"publicKey"`
+}
+
+type replicaRequest struct {
+	//label of snapshot standby received last, empty for the first replication
+	Base string `json:"base"`
+}
+
+type replicaStreamRequest struct {
+	Partition string `json:"partition"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// ReplicaHandler takes snapshot of management container requested by standby
+func ReplicaHandler(rw http.ResponseWriter, request *http.Request) {
+	if !Enabled() || request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := checkRequest(rw, request, ReplicaPath)
+	if log.Check(log.WarnLevel, "Authenticating cluster request from "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var replicaReq replicaRequest
+	if err = json.Unmarshal(body, &replicaReq); err != nil ||
+		(replicaReq.Base != "" && !replicaLabelRx.MatchString(replicaReq.Base)) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !container.IsContainer(container.Management) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	replica, err := takeReplica(replicaReq.Base)
+	if log.Check(log.WarnLevel, "Taking snapshot of management for standby "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respond(rw, request, ReplicaPath, replica)
+}
+
+func takeReplica(base string) (Replica, error) {
+	replica := Replica{Label: ReplicaPrefix + strconv.FormatInt(time.Now().Unix(), 10)}
+
+	parent, err := container.ParentRef(container.Management)
+	if err != nil {
+		return replica, errors.Wrap(err, "Reading parent template")
+	}
+	replica.Parent = parent.String()
+
+	dir := path.Join(config.Agent.LxcPrefix, container.Management)
+	data, err := ioutil.ReadFile(path.Join(dir, "config"))
+	if err != nil {
+		return replica, errors.Wrap(err, "Reading container config")
+	}
+	replica.Config = string(data)
+	if data, err = ioutil.ReadFile(path.Join(dir, "public.pub")); err == nil {
+		replica.PublicKey = string(data)
+	}
+
+	//snapshots of previous replications are not needed anymore, except base of the next stream
+	labels, err := ReplicaLabels()
+	if err != nil {
+		return replica, err
+	}
+	for _, label := range labels {
+		if label != base {
+			log.Check(log.WarnLevel, "Removing snapshot "+label,
+				fs.RemoveDataset(container.Management+"@"+label, true))
+		}
+	}
+
+	return replica, errors.Wrap(fs.CreateSnapshot(container.Management+"@"+replica.Label, true), "Creating snapshot")
+}
+
+// ReplicaLabels returns labels of snapshots of management container taken for replication
+func ReplicaLabels() ([]string, error) {
+	out, err := fs.ListSnapshotNamesOnly(container.Management)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []string
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "@", 2)
+		if len(parts) == 2 && path.Base(parts[0]) == container.Management && replicaLabelRx.MatchString(parts[1]) {
+			labels = append(labels, parts[1])
+		}
+	}
+
+	return labels, nil
+}
+
+// ReplicaStreamHandler sends stream of management partition between snapshots requested by standby
+func ReplicaStreamHandler(rw http.ResponseWriter, request *http.Request) {
+	if !Enabled() || request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := checkRequest(rw, request, ReplicaStreamPath)
+	if log.Check(log.WarnLevel, "Authenticating cluster request from "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var stream replicaStreamRequest
+	if err = json.Unmarshal(body, &stream); err != nil || !validPartition(stream.Partition) ||
+		!replicaLabelRx.MatchString(stream.To) || (stream.From != "" && !replicaLabelRx.MatchString(stream.From)) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	//the first stream starts from snapshot of template management is cloned from
+	from := container.Management + "/" + stream.Partition + "@" + stream.From
+	if stream.From == "" {
+		parent, err := container.ParentRef(container.Management)
+		if log.Check(log.WarnLevel, "Reading parent template of management", err) {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		from = parent.String() + "/" + stream.Partition + "@" + container.ParentSnapshot(container.Management)
+	}
+	to := container.Management + "/" + stream.Partition + "@" + stream.To
+	if !fs.DatasetExists(from) || !fs.DatasetExists(to) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	delta := path.Join(config.Agent.CacheDir, "replica-"+stream.Partition+"-"+stream.To+".delta")
+	defer os.Remove(delta)
+	if log.Check(log.WarnLevel, "Sending stream of management partition "+stream.Partition,
+		fs.SendStream(from, to, delta)) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(delta)
+	if log.Check(log.WarnLevel, "Opening stream of management partition "+stream.Partition, err) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s := stampOf(request.Header)
+	s.set(rw.Header())
+	rw.Header().Set(signatureHeader, sign(s, "response:"+ReplicaStreamPath, body))
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	io.Copy(rw, file)
+}
+
+func validPartition(partition string) bool {
+	for _, p := range fs.ChildDatasets {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// TakeReplica asks primary to take snapshot of management container, base is label of snapshot standby
+// received last, it is kept on primary for the next stream
+func TakeReplica(primary, base string) (Replica, error) {
+	var replica Replica
+
+	if !Enabled() {
+		return replica, errors.New("Cluster mode is not enabled or not configured")
+	}
+
+	address, err := peerAddress(primary)
+	if err != nil {
+		return replica, err
+	}
+
+	err = call(address, http.MethodPost, ReplicaPath, replicaRequest{Base: base}, 5*time.Minute, &replica)
+
+	return replica, err
+}
+
+// ReceiveReplica pulls stream of management partition between snapshots from primary and passes it to receive,
+// empty from requests stream since snapshot of template management is cloned from
+func ReceiveReplica(primary, partition, from, to string, receive func(stream io.Reader) error) error {
+	if !Enabled() {
+		return errors.New("Cluster mode is not enabled or not configured")
+	}
+
+	address, err := peerAddress(primary)
+	if err != nil {
+		return err
+	}
+
+	payload := replicaStreamRequest{Partition: partition, From: from, To: to}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	//primary writes stream before it replies, so waiting for response is not limited
+	client, err := peerClient(0)
+	if err != nil {
+		return err
+	}
+	response, s, err := send(address, http.MethodPost, ReplicaStreamPath, payload, client)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err = verifyResponse(response, s, ReplicaStreamPath, body); err != nil {
+		return err
+	}
+
+	return receive(response.Body)
+}
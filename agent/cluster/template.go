@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// TemplatePath is endpoint serving template archives kept in cache to peers
+const TemplatePath = "/cluster/template"
+
+// Archives are streamed as is, so only request and peer identity are authenticated, not content.
+// Importing host verifies archive against digest published by CDN before using it
+var templateIdRx = regexp.MustCompile(`^[[:alnum:]][[:alnum:]_.-]*$`)
+
+type templateRequest struct {
+	Id string `json:"id"`
+}
+
+// SharesTemplates tells if archives of imported templates are kept in cache and served to peers
+func SharesTemplates() bool {
+	return Enabled() && config.Cluster.ShareTemplates
+}
+
+// TemplateHandler sends archive of template requested by peer if it is kept in cache
+func TemplateHandler(rw http.ResponseWriter, request *http.Request) {
+	if !SharesTemplates() || request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := checkRequest(rw, request, TemplatePath)
+	if log.Check(log.WarnLevel, "Authenticating cluster request from "+request.RemoteAddr, err) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var template templateRequest
+	if err = json.Unmarshal(body, &template); err != nil || !templateIdRx.MatchString(template.Id) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	file, err := os.Open(path.Join(config.Agent.CacheDir, template.Id))
+	if err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Debug("Sending template " + template.Id + " to cluster peer " + request.RemoteAddr)
+
+	s := stampOf(request.Header)
+	s.set(rw.Header())
+	rw.Header().Set(signatureHeader, sign(s, "response:"+TemplatePath, []byte(template.Id)))
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if _, err = io.Copy(rw, file); err == nil {
+		log.Check(log.DebugLevel, "Recording use of template "+template.Id, useTemplate(template.Id, info.Size()))
+	}
+}
+
+// KeepTemplate keeps archive of imported template in cache for peers and removes least recently used archives
+// kept before if archives exceed cache limit
+func KeepTemplate(id string) error {
+	info, err := os.Stat(path.Join(config.Agent.CacheDir, id))
+	if err != nil {
+		return err
+	}
+	if err = useTemplate(id, info.Size()); err != nil {
+		return err
+	}
+
+	return evictTemplates()
+}
+
+// useTemplate records that archive is used now, so that it is removed after archives used earlier
+func useTemplate(id string, size int64) error {
+	return db.SaveSharedTemplate(&db.SharedTemplate{Id: id, Size: size, Used: time.Now()})
+}
+
+// evictTemplates removes least recently used archives kept for peers until archives fit cache limit
+func evictTemplates() error {
+	templates, err := db.GetAllSharedTemplates()
+	if err != nil {
+		return errors.Wrap(err, "Reading kept templates")
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Used.After(templates[j].Used) })
+
+	limit := int64(config.Cluster.ShareCacheSize) << 30
+	var total int64
+	for i := range templates {
+		t := &templates[i]
+		file := path.Join(config.Agent.CacheDir, t.Id)
+		if !fs.FileExists(file) {
+			log.Check(log.WarnLevel, "Removing record of template "+t.Id, db.RemoveSharedTemplate(t))
+			continue
+		}
+
+		total += t.Size
+		//the most recently used archive is kept even if it alone exceeds limit
+		if limit == 0 || total <= limit || i == 0 {
+			continue
+		}
+
+		log.Debug("Removing template " + t.Id + " kept for cluster peers")
+		if err = os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Removing template "+t.Id)
+		}
+		total -= t.Size
+		log.Check(log.WarnLevel, "Removing record of template "+t.Id, db.RemoveSharedTemplate(t))
+	}
+
+	return nil
+}
+
+// FetchTemplate downloads archive of template from the first peer having it in cache to dest.
+// Archive is checked with valid, invalid archives are discarded and the next peer is asked.
+// Name of peer archive is downloaded from is returned
+func FetchTemplate(id, dest string, valid func(file string) bool) (string, error) {
+	if !Enabled() {
+		return "", errors.New("Cluster mode is not enabled or not configured")
+	}
+
+	peers, err := livePeers()
+	if err != nil {
+		return "", err
+	}
+
+	//peer not having template replies at once, only the archive itself may take long
+	client, err := peerClient(10 * time.Second)
+	if err != nil {
+		return "", err
+	}
+	for _, peer := range peers {
+		err := fetchFrom(client, peer.Address, id, dest)
+		if log.Check(log.DebugLevel, "Fetching template "+id+" from cluster peer "+peer.Name, err) {
+			continue
+		}
+		if !valid(dest) {
+			log.Warn("Template " + id + " received from cluster peer " + peer.Name + " failed verification")
+			os.Remove(dest)
+			continue
+		}
+
+		return peer.Name, nil
+	}
+
+	return "", errors.Errorf("Template %s not found on cluster peers", id)
+}
+
+func fetchFrom(client *http.Client, address, id, dest string) error {
+	response, s, err := send(address, http.MethodPost, TemplatePath, templateRequest{Id: id}, client)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err = verifyResponse(response, s, TemplatePath, []byte(id)); err != nil {
+		return err
+	}
+
+	//archive is written under temporary name so that interrupted transfer is not taken for archive
+	tmp := dest + ".peer"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, response.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+// livePeers returns peers seen by the latest discovery rounds, the most recently seen first
+func livePeers() ([]db.ClusterPeer, error) {
+	peers, err := db.GetAllClusterPeers()
+	if err != nil {
+		return nil, errors.Wrap(err, "Reading cluster peers")
+	}
+
+	var live []db.ClusterPeer
+	for _, peer := range peers {
+		if time.Since(peer.Seen) <= 3*discoveryInterval() {
+			live = append(live, peer)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].Seen.After(live[j].Seen) })
+
+	return live, nil
+}
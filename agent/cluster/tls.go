@@ -0,0 +1,110 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/fips"
+)
+
+// Peers talk over TLS listener of daemon and authenticate each other with certificates issued by cluster CA.
+// Certificates are checked against CA only, not against addresses of peers, so peers may be reached by any
+// address, including loopback one
+
+// ServesTLS tells if certificate and key of host are set, so daemon serves TLS listener
+func ServesTLS() bool {
+	return config.TLS.Cert != "" && config.TLS.Key != ""
+}
+
+// ServerTLSConfig returns config of TLS listener of daemon, client certificates are requested but not required
+// since API callers authenticate with tokens, cluster endpoints require them
+func ServerTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.TLS.Cert, config.TLS.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Loading certificate of host")
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequestClientCert}
+	if config.TLS.ClusterCa != "" {
+		pool, err := clusterCa()
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs, cfg.ClientAuth = pool, tls.VerifyClientCertIfGiven
+	}
+
+	return fips.TLSConfig(cfg), nil
+}
+
+// clientTLSConfig returns config peers are called with: certificate of host is presented and certificate of
+// peer must be issued by cluster CA
+func clientTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.TLS.Cert, config.TLS.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Loading certificate of host")
+	}
+	pool, err := clusterCa()
+	if err != nil {
+		return nil, err
+	}
+
+	//peer certificate is verified against cluster CA below, without matching address of peer
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPeerCert(pool, rawCerts)
+		}}
+
+	return fips.TLSConfig(cfg), nil
+}
+
+func verifyPeerCert(pool *x509.CertPool, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("Peer presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	var leaf *x509.Certificate
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			leaf = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{Roots: pool, Intermediates: intermediates,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return errors.Wrap(err, "Verifying certificate of peer")
+}
+
+func clusterCa() (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(config.TLS.ClusterCa)
+	if err != nil {
+		return nil, errors.Wrap(err, "Reading cluster CA certificate")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("No certificates found in %s", config.TLS.ClusterCa)
+	}
+	return pool, nil
+}
+
+// verifiedPeer checks that request came over TLS from host presenting certificate issued by cluster CA
+func verifiedPeer(request *http.Request) error {
+	if request.TLS == nil {
+		return errors.New("Cluster requests are accepted over TLS only")
+	}
+	if len(request.TLS.VerifiedChains) == 0 {
+		return errors.New("Peer presented no certificate issued by cluster CA")
+	}
+	return nil
+}
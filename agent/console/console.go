@@ -1,28 +1,29 @@
 package console
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/drift"
+	"github.com/subutai-io/agent/agent/executer"
 	"github.com/subutai-io/agent/agent/util"
-	"net/http"
 	"github.com/subutai-io/agent/config"
-	"path"
+	"github.com/subutai-io/agent/db"
+	cont "github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/lib/gpg"
-	"io/ioutil"
-	"github.com/pkg/errors"
-	"fmt"
-	"os"
-	"strings"
-	"bytes"
-	"encoding/json"
-	"runtime"
 	"github.com/subutai-io/agent/lib/net"
 	"github.com/subutai-io/agent/log"
+	"github.com/wunderlist/ttlcache"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strings"
 	"sync"
-	"github.com/subutai-io/agent/agent/executer"
 	"time"
-	"github.com/subutai-io/agent/db"
-	cont "github.com/subutai-io/agent/lib/container"
-	"github.com/wunderlist/ttlcache"
 )
 
 var (
@@ -66,7 +67,7 @@ func (c Console) Heartbeats() {
 //returns true if Console is ready to operate
 //returns false if not approved or any error during checking status
 func (c Console) IsReady() bool {
-	resp, err := c.client.Get("https://" + path.Join(config.ManagementIP) + ":8443/rest/health/ready")
+	resp, err := c.client.Get("https://" + path.Join(config.ManagementIP) + ":" + config.Management.Port + "/rest/health/ready")
 	if err == nil {
 		defer c.Close(resp)
 		if resp.StatusCode == http.StatusOK {
@@ -80,7 +81,7 @@ func (c Console) IsReady() bool {
 //returns true if Console has approved this RH registration
 //returns false if not approved or any error during checking registration
 func (c Console) IsRegistered() bool {
-	theUrl := "https://" + path.Join(config.ManagementIP) + ":8444/rest/v1/agent/check/" + c.fingerprint
+	theUrl := "https://" + path.Join(config.ManagementIP) + ":" + config.Management.SecurePort + "/rest/v1/agent/check/" + c.fingerprint
 	resp, err := c.secureClient.Get(theUrl)
 	if err == nil {
 		defer c.Close(resp)
@@ -142,7 +143,7 @@ func (c Console) Register() error {
 		return err
 	}
 
-	resp, err := c.client.Post("https://"+path.Join(config.ManagementIP)+":8443/rest/v1/registration/public-key", "text/plain",
+	resp, err := c.client.Post("https://"+path.Join(config.ManagementIP)+":"+config.Management.Port+"/rest/v1/registration/public-key", "text/plain",
 		bytes.NewBuffer(msg))
 	if err == nil {
 		defer c.Close(resp)
@@ -154,7 +155,7 @@ func (c Console) Register() error {
 }
 
 func (c Console) GetFingerprint() (string, error) {
-	resp, err := c.client.Get("https://" + path.Join(config.ManagementIP) + ":8443/rest/v1/security/keyman/getpublickeyfingerprint")
+	resp, err := c.client.Get("https://" + path.Join(config.ManagementIP) + ":" + config.Management.Port + "/rest/v1/security/keyman/getpublickeyfingerprint")
 	if err == nil {
 		defer c.Close(resp)
 	} else {
@@ -197,6 +198,7 @@ func (c Console) SendHeartBeat(force bool) error {
 		Arch:       instanceArch,
 		Instance:   instanceType,
 		Containers: pool,
+		Drift:      drift.Report(),
 	}}
 	heartbeat, err := json.Marshal(&res)
 	if log.Check(log.WarnLevel, "Marshaling heartbeat JSON", err) {
@@ -213,7 +215,7 @@ func (c Console) SendHeartBeat(force bool) error {
 		message, err := json.Marshal(map[string]string{"hostId": gpg.GetRhFingerprint(), "response": string(encryptedMessage)})
 		log.Check(log.WarnLevel, "Marshal response json", err)
 
-		resp, err := postForm(c.secureClient, "https://"+path.Join(config.ManagementIP)+":8444/rest/v1/agent/heartbeat", url.Values{"heartbeat": {string(message)}})
+		resp, err := postForm(c.secureClient, "https://"+path.Join(config.ManagementIP)+":"+config.Management.SecurePort+"/rest/v1/agent/heartbeat", url.Values{"heartbeat": {string(message)}})
 		if !log.Check(log.WarnLevel, "Sending heartbeat: "+string(heartbeat), err) {
 			defer util.Close(resp)
 
@@ -282,7 +284,7 @@ func (c Console) Close(resp *http.Response) {
 func (c Console) getCommands() []executer.EncRequest {
 	var rsp []executer.EncRequest
 
-	theUrl := "https://" + path.Join(config.ManagementIP) + ":8444/rest/v1/agent/requests/" + gpg.GetRhFingerprint()
+	theUrl := "https://" + path.Join(config.ManagementIP) + ":" + config.Management.SecurePort + "/rest/v1/agent/requests/" + gpg.GetRhFingerprint()
 
 	resp, err := c.secureClient.Get(theUrl)
 	if err == nil {
@@ -311,7 +313,7 @@ func (c Console) getCommands() []executer.EncRequest {
 
 //send a single command execution result to Console
 func (c Console) sendResponse(msg []byte, deadline time.Time) {
-	resp, err := postForm(c.secureClient, "https://"+path.Join(config.ManagementIP)+":8444/rest/v1/agent/response", url.Values{"response": {string(msg)}})
+	resp, err := postForm(c.secureClient, "https://"+path.Join(config.ManagementIP)+":"+config.Management.SecurePort+"/rest/v1/agent/response", url.Values{"response": {string(msg)}})
 	if !log.Check(log.WarnLevel, "Sending response "+string(msg), err) {
 		defer util.Close(resp)
 		if resp.StatusCode == http.StatusAccepted {
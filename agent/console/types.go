@@ -2,6 +2,8 @@ package console
 
 import (
 	"net/http"
+
+	"github.com/subutai-io/agent/agent/drift"
 	"github.com/subutai-io/agent/agent/util"
 )
 
@@ -58,11 +60,12 @@ type response struct {
 
 //heartbeat describes JSON formated information that Agent sends to Management server.
 type heartbeat struct {
-	Type       string      `json:"type"`
-	Hostname   string      `json:"hostname"`
-	Address    string      `json:"address"`
-	ID         string      `json:"id"`
-	Arch       string      `json:"arch"`
-	Instance   string      `json:"instance"`
-	Containers []Container `json:"containers,omitempty"`
+	Type       string       `json:"type"`
+	Hostname   string       `json:"hostname"`
+	Address    string       `json:"address"`
+	ID         string       `json:"id"`
+	Arch       string       `json:"arch"`
+	Instance   string       `json:"instance"`
+	Containers []Container  `json:"containers,omitempty"`
+	Drift      []drift.Item `json:"drift,omitempty"`
 }
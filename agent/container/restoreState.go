@@ -27,6 +27,9 @@ func doRestore() {
 			if startErr != nil {
 				log.Warn("Failed to start container " + v.Name + ": " + startErr.Error())
 			}
+		} else {
+			//containers may be started not by agent, e.g. by lxc autostart on boot
+			log.Check(log.WarnLevel, "Syncing systemd scope of "+v.Name, container.SyncUnit(v.Name))
 		}
 	}
 }
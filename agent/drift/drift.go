@@ -0,0 +1,171 @@
+// Package drift detects configuration files managed by agent which no longer match state recorded in db,
+// e.g. container configs or nginx includes edited by hand or removed. Detected drift is reported in heartbeat
+// and by "subutai drift", which can also rewrite drifted files from db
+package drift
+
+import (
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/proxy"
+	"github.com/subutai-io/agent/log"
+)
+
+// kinds of drifted files
+const (
+	ContainerConfig = "container"
+	ProxyConfig     = "proxy"
+)
+
+// problems found in drifted files
+const (
+	Missing  = "missing"
+	Modified = "modified"
+)
+
+// Item describes single drifted file
+type Item struct {
+	Kind string `json:"kind"`
+	//container name or proxy tag
+	Name    string `json:"name"`
+	File    string `json:"file"`
+	Problem string `json:"problem"`
+	//config items which differ from db, for container configs only
+	Items []string `json:"items,omitempty"`
+}
+
+var (
+	lastLock   sync.Mutex
+	lastCheck  time.Time
+	lastReport []Item
+)
+
+// Detect compares files managed by agent with state recorded in db
+func Detect() ([]Item, error) {
+	items, err := containerDrift()
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := proxy.CheckConfigs()
+	if err != nil {
+		return nil, errors.Wrap(err, "Checking nginx configs")
+	}
+	for _, state := range states {
+		item := Item{Kind: ProxyConfig, Name: state.Tag, File: state.File, Problem: Modified}
+		if state.Missing {
+			item.Problem = Missing
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func containerDrift() ([]Item, error) {
+	containers, err := db.FindContainers("", "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "Reading containers from db")
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+
+	var items []Item
+	for _, c := range containers {
+		//container may be removed by concurrent destroy
+		if !container.IsContainer(c.Name) {
+			continue
+		}
+		//network of management container is set up by its own init rather than from db record, see initManagement
+		if c.Name == container.Management {
+			continue
+		}
+
+		file := path.Join(config.Agent.LxcPrefix, c.Name, "config")
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			items = append(items, Item{Kind: ContainerConfig, Name: c.Name, File: file, Problem: Missing})
+			continue
+		}
+
+		managed := container.ManagedConfig(c)
+		var keys []string
+		for _, item := range managed {
+			keys = append(keys, item[0])
+		}
+		actual := container.GetConfigItems(file, keys...)
+
+		var differ []string
+		for _, item := range managed {
+			if actual[item[0]] != item[1] {
+				differ = append(differ, item[0])
+			}
+		}
+		if len(differ) > 0 {
+			items = append(items, Item{Kind: ContainerConfig, Name: c.Name, File: file, Problem: Modified, Items: differ})
+		}
+	}
+
+	return items, nil
+}
+
+// Reconcile rewrites drifted files from db. Missing container configs can not be restored
+// since most of their content comes from template, such items are returned as error
+func Reconcile(items []Item) error {
+	var failed []string
+
+	for _, item := range items {
+		var err error
+		switch {
+		case item.Kind == ProxyConfig:
+			err = proxy.RewriteConfig(item.Name)
+		case item.Kind == ContainerConfig && item.Problem == Modified:
+			var c *db.Container
+			if c, err = db.FindContainerByName(item.Name); err == nil && c != nil {
+				err = container.SetContainerConf(c.Name, container.ManagedConfig(*c))
+			}
+		default:
+			err = errors.Errorf("%s can not be restored, restore container from backup", item.File)
+		}
+
+		if log.Check(log.WarnLevel, "Reconciling "+item.File, err) {
+			failed = append(failed, item.File)
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("Failed to reconcile %d of %d files", len(failed), len(items))
+	}
+
+	return nil
+}
+
+// Report returns drift found by the latest check, checking again if config.Agent.DriftInterval passed since it.
+// It is called on every heartbeat, so the check runs no more often than heartbeats are sent
+func Report() []Item {
+	interval := time.Duration(config.Agent.DriftInterval) * time.Minute
+	if interval <= 0 {
+		return nil
+	}
+
+	lastLock.Lock()
+	defer lastLock.Unlock()
+
+	if time.Since(lastCheck) >= interval {
+		items, err := Detect()
+		if !log.Check(log.WarnLevel, "Detecting configuration drift", err) {
+			if len(items) > 0 && len(lastReport) == 0 {
+				log.Warn("Configuration drift detected, run \"subutai drift\" for details")
+			}
+			lastReport = items
+		}
+		lastCheck = time.Now()
+	}
+
+	return lastReport
+}
@@ -14,11 +14,10 @@ import (
 	"time"
 	"unsafe"
 
-	"gopkg.in/lxc/go-lxc.v2"
-
 	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/log"
 	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/lib/gpg"
 	"path"
 	"encoding/json"
@@ -241,6 +240,10 @@ func buildCmd(r *RequestOptions) *exec.Cmd {
 	cmd.Dir = r.WorkingDir
 	cmd.SysProcAttr = &syscall.SysProcAttr{}
 	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid32, Gid: gid32}
+	//subutai commands run by Console are audited under id of Console command
+	if common.IsOperationID(r.CommandID) {
+		cmd.Env = append(os.Environ(), common.OperationEnv+"="+r.CommandID)
+	}
 
 	return cmd
 }
@@ -260,12 +263,6 @@ func genericResponse(req RequestOptions) ResponseOptions {
 func execInContainer(name string, req RequestOptions, outCh chan<- ResponseOptions) error {
 	defer close(outCh)
 
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err != nil {
-		return err
-	}
-	defer lxc.Release(c)
-
 	rop, wop, err := os.Pipe()
 	if err != nil {
 		return err
@@ -278,13 +275,9 @@ func execInContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 	}
 	defer rep.Close()
 
-	opts := lxc.DefaultAttachOptions
+	opts := container.ExecOptions{Stdout: wop, Stderr: wep, Cwd: req.WorkingDir, ClearEnv: true,
+		EnvToKeep: []string{"TERM", "USER", "LS_COLORS"}}
 	opts.UID, opts.GID = credentials(req.RunAs, name)
-	opts.StdoutFd = wop.Fd()
-	opts.StderrFd = wep.Fd()
-	opts.Cwd = req.WorkingDir
-	opts.EnvToKeep = []string{"TERM", "USER", "LS_COLORS"}
-	opts.ClearEnv = true
 
 	var exitCode int
 	var cmd bytes.Buffer
@@ -302,7 +295,8 @@ func execInContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 
 	log.Debug("Executing command in container " + name + ":" + cmd.String())
 	go func() {
-		exitCode, err = c.RunCommandStatus([]string{"timeout", strconv.Itoa(req.Timeout), "/bin/bash", "-c", cmd.String()}, opts)
+		exitCode, err = container.GetRuntime().Exec(name,
+			[]string{"timeout", strconv.Itoa(req.Timeout), "/bin/bash", "-c", cmd.String()}, opts)
 		log.Check(log.DebugLevel, "Executing command inside container", err)
 		log.Check(log.DebugLevel, "Closing standard output", wop.Close())
 		log.Check(log.DebugLevel, "Closing error output", wep.Close())
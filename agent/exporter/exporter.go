@@ -0,0 +1,214 @@
+// Package exporter serves metrics of containers and template cache in Prometheus text format, so hosts can be
+// monitored with standard tooling. Counters are exported as they are read, rates are left to Prometheus
+package exporter
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/agent/limiter"
+	"github.com/subutai-io/agent/agent/vars"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/metrics"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/log"
+)
+
+// Path of endpoint metrics are served at
+const Path = "/metrics"
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+//reading disk usage of all containers is not cheap, concurrent scrapes wait for each other
+var scrape sync.Mutex
+
+// Serve starts exporter on address configured in [prometheus] section if it is enabled
+func Serve() {
+	if !config.Prometheus.Enabled {
+		return
+	}
+
+	allowed, err := net.ParseNetworks(config.Prometheus.Allow)
+	if log.Check(log.WarnLevel, "Parsing addresses allowed to scrape metrics", err) {
+		return
+	}
+
+	handler := http.NewServeMux()
+	handler.HandleFunc(Path, func(rw http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet || len(allowed) > 0 && !net.InNetworks(request.RemoteAddr, allowed) {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.Header().Set("Content-Type", contentType)
+		rw.Write(Gather())
+	})
+
+	srv := &http.Server{
+		Addr:              config.Prometheus.Address,
+		ReadHeaderTimeout: 15 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		Handler:           limiter.Wrap(handler, Path),
+	}
+	log.Check(log.WarnLevel, "Serving Prometheus metrics on "+config.Prometheus.Address, srv.ListenAndServe())
+}
+
+// Gather returns metrics of the host in Prometheus text format
+func Gather() []byte {
+	scrape.Lock()
+	defer scrape.Unlock()
+
+	r := &registry{}
+	r.add("subutai_agent_info", "gauge", "Version of agent", 1, "version", vars.Version)
+
+	containers := container.Containers()
+	sort.Strings(containers)
+	for _, name := range containers {
+		state := container.State(name)
+		r.add("subutai_container_state", "gauge", "State of container, 1 for the current state", 1,
+			"container", name, "state", strings.ToLower(state))
+		if state == container.Running {
+			gatherContainer(r, name)
+		}
+	}
+	r.add("subutai_templates", "gauge", "Number of installed templates", float64(len(container.Templates())))
+
+	gatherTemplateStats(r)
+
+	for _, rejection := range limiter.Rejections() {
+		r.add("subutai_http_rejected_total", "counter", "Requests to daemon endpoints rejected by rate limits",
+			float64(rejection.Count), "endpoint", rejection.Endpoint, "reason", rejection.Reason)
+	}
+	total, locked := limiter.Lockouts()
+	r.add("subutai_http_lockouts_total", "counter", "Lockouts of addresses refused too often", float64(total))
+	r.add("subutai_http_locked_addresses", "gauge", "Addresses locked out now", float64(locked))
+
+	return r.bytes()
+}
+
+//metrics of running container, container stopped after it was listed is skipped
+func gatherContainer(r *registry, name string) {
+	m, err := metrics.Collect(name)
+	if log.Check(log.DebugLevel, "Collecting metrics of "+name, err) {
+		return
+	}
+
+	r.add("subutai_container_cpu_seconds_total", "counter", "CPU time consumed by container since its start",
+		float64(m.CPU.Usage)/1e9, "container", name)
+	r.add("subutai_container_cpu_quota_percent", "gauge", "CPU quota of container in percents of all cores, 0 if unlimited",
+		float64(m.CPU.Quota), "container", name)
+	r.add("subutai_container_memory_usage_bytes", "gauge", "Memory used by container, including cache",
+		float64(m.Memory.Usage), "container", name)
+	r.add("subutai_container_memory_cache_bytes", "gauge", "Page cache of container",
+		float64(m.Memory.Cache), "container", name)
+	r.add("subutai_container_memory_quota_bytes", "gauge", "Memory quota of container, 0 if unlimited",
+		float64(m.Memory.Quota), "container", name)
+	r.add("subutai_container_disk_used_bytes", "gauge", "Disk space used by all partitions of container",
+		float64(m.Disk.Used), "container", name)
+	r.add("subutai_container_disk_quota_bytes", "gauge", "Disk quota of container, 0 if unlimited",
+		float64(m.Disk.Quota), "container", name)
+	r.add("subutai_container_blkio_read_bytes_total", "counter", "Bytes read by container from block devices",
+		float64(m.BlockIO.ReadBytes), "container", name)
+	r.add("subutai_container_blkio_write_bytes_total", "counter", "Bytes written by container to block devices",
+		float64(m.BlockIO.WriteBytes), "container", name)
+	r.add("subutai_container_network_receive_bytes_total", "counter", "Bytes received by container",
+		float64(m.Network.RxBytes), "container", name)
+	r.add("subutai_container_network_transmit_bytes_total", "counter", "Bytes sent by container",
+		float64(m.Network.TxBytes), "container", name)
+	r.add("subutai_container_network_receive_dropped_total", "counter", "Packets dropped on the way to container",
+		float64(m.Network.RxDropped), "container", name)
+	r.add("subutai_container_network_transmit_dropped_total", "counter", "Packets sent by container and dropped",
+		float64(m.Network.TxDropped), "container", name)
+	r.add("subutai_container_network_quota_kbps", "gauge", "Rate limit of traffic sent by container, 0 if unlimited",
+		float64(m.Network.Quota), "container", name)
+}
+
+//counters of template imports and downloads from CDN mirrors
+func gatherTemplateStats(r *registry) {
+	stats, err := db.GetTemplateStats()
+	if !log.Check(log.WarnLevel, "Reading template stats", err) {
+		for _, s := range stats {
+			r.add("subutai_template_imports_total", "counter", "Imports of template", float64(s.Imports),
+				"template", s.Template)
+			r.add("subutai_template_cache_hits_total", "counter", "Imports of template served from local cache",
+				float64(s.CacheHits), "template", s.Template)
+			r.add("subutai_template_cache_misses_total", "counter", "Imports of template which had to download it",
+				float64(s.CacheMisses), "template", s.Template)
+			r.add("subutai_template_download_seconds_total", "counter", "Time spent downloading template",
+				s.DownloadTime.Seconds(), "template", s.Template)
+			r.add("subutai_template_failures_total", "counter", "Failed imports of template", float64(s.Failures),
+				"template", s.Template)
+		}
+	}
+
+	mirrors, err := db.GetMirrorStats()
+	if !log.Check(log.WarnLevel, "Reading mirror stats", err) {
+		for _, s := range mirrors {
+			r.add("subutai_mirror_downloads_total", "counter", "Templates downloaded from mirror",
+				float64(s.Downloads), "mirror", s.Mirror)
+			r.add("subutai_mirror_download_bytes_total", "counter", "Bytes downloaded from mirror",
+				float64(s.Bytes), "mirror", s.Mirror)
+			r.add("subutai_mirror_failures_total", "counter", "Failed downloads from mirror",
+				float64(s.Failures), "mirror", s.Mirror)
+		}
+	}
+}
+
+// registry keeps samples grouped by metric, in order metrics were first added
+type registry struct {
+	families []*family
+}
+
+type family struct {
+	name, kind, help string
+	samples          []string
+}
+
+//labels are name and value pairs
+func (r *registry) add(name, kind, help string, value float64, labels ...string) {
+	var f *family
+	for _, existing := range r.families {
+		if existing.name == name {
+			f = existing
+			break
+		}
+	}
+	if f == nil {
+		f = &family{name: name, kind: kind, help: help}
+		r.families = append(r.families, f)
+	}
+
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"=\""+escape(labels[i+1])+"\"")
+	}
+	sample := name
+	if len(pairs) > 0 {
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	f.samples = append(f.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (r *registry) bytes() []byte {
+	var buf bytes.Buffer
+	for _, f := range r.families {
+		buf.WriteString("# HELP " + f.name + " " + f.help + "\n")
+		buf.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+		for _, s := range f.samples {
+			buf.WriteString(s + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,223 @@
+// Package limiter protects endpoints of agent daemon on hosts exposed to untrusted networks: requests are
+// limited per source address and addresses whose requests are refused for invalid credentials too often,
+// e.g. while guessing cluster secret, are locked out for a while. Handlers report such refusals with Fail
+package limiter
+
+import (
+	"context"
+	"math"
+	gonet "net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/log"
+)
+
+// reasons requests are rejected for
+const (
+	ReasonRate    = "rate"
+	ReasonLockout = "lockout"
+)
+
+// state of addresses is dropped when there are more of them, so flood from many addresses does not exhaust memory
+const maxSources = 10000
+
+// Rejection is number of requests to endpoint rejected for reason
+type Rejection struct {
+	Endpoint string
+	Reason   string
+	Count    int64
+}
+
+type source struct {
+	//tokens of bucket, request takes one
+	tokens  float64
+	updated time.Time
+	//requests with invalid credentials since firstFailure, reset when they are older than lockout
+	failures     int
+	firstFailure time.Time
+	lockedUntil  time.Time
+}
+
+var (
+	mu       sync.Mutex
+	sources  = make(map[string]*source)
+	rejected = make(map[Rejection]int64)
+	lockouts int64
+)
+
+// Wrap returns handler limiting requests to handler per source address if limits are enabled in [rateLimit]
+// section of config. Rejections are counted per endpoint, paths other than endpoints are counted as "other"
+func Wrap(handler http.Handler, endpoints ...string) http.Handler {
+	if !config.RateLimit.Enabled {
+		return handler
+	}
+
+	exempt, err := net.ParseNetworks(config.RateLimit.Exempt)
+	log.Check(log.WarnLevel, "Parsing addresses exempt from rate limits", err)
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		addr := host(request.RemoteAddr)
+		if addr == config.ManagementIP || net.InNetworks(addr, exempt) {
+			handler.ServeHTTP(rw, request)
+			return
+		}
+
+		endpoint := "other"
+		for _, e := range endpoints {
+			if request.URL.Path == e {
+				endpoint = e
+			}
+		}
+
+		if reason, retry := admit(addr, time.Now()); reason != "" {
+			reject(endpoint, reason)
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		failed := false
+		handler.ServeHTTP(rw, request.WithContext(context.WithValue(request.Context(), failedKey{}, &failed)))
+		if failed {
+			fail(addr, time.Now())
+		}
+	})
+}
+
+// Fail marks request as refused for invalid credentials, so its source address counts towards lockout. Other
+// refusals, e.g. of unknown paths or of callers not allowed to use endpoint, are not counted
+func Fail(request *http.Request) {
+	if failed, ok := request.Context().Value(failedKey{}).(*bool); ok {
+		*failed = true
+	}
+}
+
+// Rejections returns numbers of rejected requests since daemon start
+func Rejections() []Rejection {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var list []Rejection
+	for r, count := range rejected {
+		r.Count = count
+		list = append(list, r)
+	}
+	return list
+}
+
+// Lockouts returns number of lockouts since daemon start and number of addresses locked out now
+func Lockouts() (total int64, locked int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for _, s := range sources {
+		if now.Before(s.lockedUntil) {
+			locked++
+		}
+	}
+	return lockouts, locked
+}
+
+// admit takes token of address, returns reason of rejection and time after which request may be retried
+// if request is rejected
+func admit(addr string, now time.Time) (string, time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rate := float64(config.RateLimit.Requests) / 60
+	burst := float64(config.RateLimit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	s, ok := sources[addr]
+	if !ok {
+		if len(sources) >= maxSources {
+			prune(now)
+		}
+		s = &source{tokens: burst, updated: now}
+		sources[addr] = s
+	}
+
+	if now.Before(s.lockedUntil) {
+		return ReasonLockout, s.lockedUntil.Sub(now)
+	}
+	if rate <= 0 {
+		return "", 0
+	}
+
+	s.tokens = math.Min(burst, s.tokens+now.Sub(s.updated).Seconds()*rate)
+	s.updated = now
+	if s.tokens < 1 {
+		return ReasonRate, time.Duration((1 - s.tokens) / rate * float64(time.Second))
+	}
+	s.tokens--
+
+	return "", 0
+}
+
+// fail counts refused request of address and locks address out once it has too many of them
+func fail(addr string, now time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := sources[addr]
+	if !ok || config.RateLimit.Failures <= 0 {
+		return
+	}
+
+	lockout := time.Duration(config.RateLimit.Lockout) * time.Second
+	if now.Sub(s.firstFailure) > lockout {
+		s.failures = 0
+		s.firstFailure = now
+	}
+	s.failures++
+
+	if s.failures >= config.RateLimit.Failures {
+		s.lockedUntil = now.Add(lockout)
+		s.failures = 0
+		lockouts++
+		log.Warn("Locking out " + addr + " for " + lockout.String() + " after " +
+			strconv.Itoa(config.RateLimit.Failures) + " requests with invalid credentials")
+	}
+}
+
+func reject(endpoint, reason string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rejected[Rejection{Endpoint: endpoint, Reason: reason}]++
+}
+
+// prune drops addresses which are not locked out and whose buckets are full, or all addresses if there are
+// still too many of them
+func prune(now time.Time) {
+	full := time.Duration(config.RateLimit.Lockout) * time.Second
+	if config.RateLimit.Requests > 0 {
+		full = time.Duration(config.RateLimit.Burst) * time.Minute / time.Duration(config.RateLimit.Requests)
+	}
+	for addr, s := range sources {
+		if now.After(s.lockedUntil) && now.Sub(s.updated) > full {
+			delete(sources, addr)
+		}
+	}
+	if len(sources) >= maxSources {
+		sources = make(map[string]*source)
+	}
+}
+
+func host(remoteAddr string) string {
+	if h, _, err := gonet.SplitHostPort(remoteAddr); err == nil {
+		return h
+	}
+	return remoteAddr
+}
+
+//key of request context value set by Fail
+type failedKey struct{}
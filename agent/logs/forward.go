@@ -0,0 +1,123 @@
+// Package logs forwards journals of running containers to syslog, local or remote one, tagging records
+// with container name, so that logs of all containers are aggregated at a single point on the host
+package logs
+
+import (
+	"log/syslog"
+	"strings"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// max number of records forwarded per container in one pass
+const batchSize = 1000
+
+type forwarder struct {
+	writer *syslog.Writer
+	//time of the latest forwarded record
+	last time.Time
+}
+
+var forwarders = make(map[string]*forwarder)
+
+// Forward periodically sends new journal records of running containers to syslog configured in [logs] section
+func Forward() {
+	if !config.Logs.Forward {
+		return
+	}
+
+	interval := time.Duration(config.Logs.Interval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	for {
+		common.RunNRecover(forwardAll)
+
+		time.Sleep(interval)
+	}
+}
+
+func forwardAll() {
+	containers, err := db.FindContainers("", container.Running, "")
+	if log.Check(log.WarnLevel, "Getting list of running containers", err) {
+		return
+	}
+
+	running := make(map[string]bool)
+	for _, c := range containers {
+		running[c.Name] = true
+		log.Check(log.DebugLevel, "Forwarding logs of "+c.Name, forward(c.Name))
+	}
+
+	for name, f := range forwarders {
+		if !running[name] {
+			f.writer.Close()
+			delete(forwarders, name)
+		}
+	}
+}
+
+func forward(name string) error {
+	f, ok := forwarders[name]
+	if !ok {
+		network, address := "", ""
+		if parts := strings.SplitN(config.Logs.Address, ":", 2); len(parts) == 2 {
+			network, address = parts[0], parts[1]
+		}
+
+		writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, name)
+		if err != nil {
+			return err
+		}
+
+		//records written before agent noticed container are not forwarded
+		f = &forwarder{writer: writer, last: time.Now()}
+		forwarders[name] = f
+	}
+
+	entries, err := container.ReadJournal(name, batchSize, f.last)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		message := entry.Message
+		if entry.Identifier != "" {
+			message = entry.Identifier + ": " + message
+		}
+		if err := write(f.writer, entry.Priority, message); err != nil {
+			return err
+		}
+		f.last = entry.Time
+	}
+
+	return nil
+}
+
+// write sends message with priority of journal record, which uses syslog severities
+func write(writer *syslog.Writer, priority int, message string) error {
+	switch priority {
+	case 0:
+		return writer.Emerg(message)
+	case 1:
+		return writer.Alert(message)
+	case 2:
+		return writer.Crit(message)
+	case 3:
+		return writer.Err(message)
+	case 4:
+		return writer.Warning(message)
+	case 5:
+		return writer.Notice(message)
+	case 7:
+		return writer.Debug(message)
+	default:
+		return writer.Info(message)
+	}
+}
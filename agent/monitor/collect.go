@@ -7,7 +7,6 @@ import (
 	exc "github.com/subutai-io/agent/lib/exec"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
@@ -20,6 +19,7 @@ import (
 	"github.com/subutai-io/agent/lib/common"
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/proxy"
 	"github.com/subutai-io/agent/log"
 	"path"
 )
@@ -74,6 +74,7 @@ func doCollect() {
 				diskFree(bp)
 				cpuStat(bp)
 				memStat(bp)
+				proxyStat(bp)
 
 				err = influx.Write(bp)
 
@@ -218,7 +219,7 @@ func zfsStat(bp client.BatchPoints) {
 func diskFree(bp client.BatchPoints) {
 	hostname, err := os.Hostname()
 	log.Check(log.DebugLevel, "Getting hostname of the system", err)
-	out, err := exec.Command("df", "-B1").Output()
+	out, err := exc.Output("df", "-B1")
 	if log.Check(log.DebugLevel, "Getting disk usage stats", err) {
 		return
 	}
@@ -288,3 +289,27 @@ func cpuStat(bp client.BatchPoints) {
 		}
 	}
 }
+
+func proxyStat(bp client.BatchPoints) {
+	hostname, err := os.Hostname()
+	log.Check(log.DebugLevel, "Getting hostname of the system", err)
+
+	stats, err := proxy.GetStats("")
+	if log.Check(log.WarnLevel, "Collecting proxy statistics", err) {
+		return
+	}
+
+	for _, s := range stats {
+		values := map[string]int64{"requests": s.Requests, "in": s.BytesIn, "out": s.BytesOut,
+			"connections": int64(s.Connections)}
+		for metric, value := range values {
+			point, err := client.NewPoint("host_proxy",
+				map[string]string{"hostname": hostname, "tag": s.Tag, "domain": s.Domain, "type": metric},
+				map[string]interface{}{"value": value},
+				time.Now())
+			if err == nil {
+				bp.AddPoint(point)
+			}
+		}
+	}
+}
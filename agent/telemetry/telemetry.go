@@ -0,0 +1,171 @@
+// Package telemetry reports anonymized usage counts of the host to a configurable endpoint.
+// Reporting is strictly opt-in: nothing is collected or sent unless it is enabled in agent config.
+// Reports contain no names, addresses or identifiers except random installation id.
+package telemetry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/agent/vars"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// Report is the only data sent to telemetry endpoint
+type Report struct {
+	//random id generated on first report, it is not derived from host data
+	InstallationId string    `json:"installationId"`
+	AgentVersion   string    `json:"agentVersion"`
+	Runtime        string    `json:"runtime"`
+	Os             string    `json:"os"`
+	Kernel         string    `json:"kernel"`
+	Arch           string    `json:"arch"`
+	Containers     int       `json:"containers"`
+	Templates      int       `json:"templates"`
+	VMs            int       `json:"vms"`
+	Imports        int       `json:"imports"`
+	Downloads      int       `json:"downloads"`
+	Failures       int       `json:"failures"`
+	Time           time.Time `json:"time"`
+}
+
+// Enabled checks if reporting is turned on and has an endpoint to report to
+func Enabled() bool {
+	return config.Telemetry.Enabled && config.Telemetry.Endpoint != ""
+}
+
+// Monitor periodically sends report while telemetry is enabled
+func Monitor() {
+	if !Enabled() {
+		return
+	}
+
+	interval := time.Duration(config.Telemetry.Interval) * time.Hour
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+
+	for {
+		common.RunNRecover(func() {
+			log.Check(log.DebugLevel, "Sending telemetry report", Send())
+		})
+
+		time.Sleep(interval)
+	}
+}
+
+// Collect builds report of the host, it is used both for sending and local preview
+func Collect() (Report, error) {
+	id, err := installationId()
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{
+		InstallationId: id,
+		AgentVersion:   vars.Version,
+		Runtime:        config.Agent.Runtime,
+		Os:             osRelease(),
+		Kernel:         readTrimmed("/proc/sys/kernel/osrelease"),
+		Arch:           runtime.GOARCH,
+		Containers:     len(container.Containers()),
+		Templates:      len(container.Templates()),
+		VMs:            len(container.VMs()),
+		Time:           time.Now(),
+	}
+
+	//only totals are reported, template names are left out
+	stats, err := db.GetTemplateStats()
+	if err != nil {
+		return Report{}, err
+	}
+	for _, s := range stats {
+		report.Imports += s.Imports
+		report.Downloads += s.Downloads
+		report.Failures += s.Failures
+	}
+
+	return report, nil
+}
+
+// Send posts report to configured endpoint
+func Send() error {
+	if !Enabled() {
+		return errors.New("Telemetry is disabled")
+	}
+
+	report, err := Collect()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	clnt := util.GetClient(false, 15)
+	resp, err := clnt.Post(config.Telemetry.Endpoint, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer util.Close(resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("HTTP status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// installationId returns random id of host kept in db. Id is kept only once reporting is turned on, preview of
+// report shows a sample id till then, so nothing is written for users who never turn reporting on
+func installationId() (string, error) {
+	id, err := db.GetTelemetryId()
+	if err != nil || id != "" {
+		return id, err
+	}
+
+	buf := make([]byte, 16)
+	if _, err = rand.Read(buf); err != nil {
+		return "", err
+	}
+	id = hex.EncodeToString(buf)
+	if !Enabled() {
+		return id, nil
+	}
+
+	return id, db.SaveTelemetryId(id)
+}
+
+// osRelease returns distribution id and version, e.g. "debian 9"
+func osRelease() string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(readTrimmed("/etc/os-release"), "\n") {
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+			fields[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.TrimSpace(fields["ID"] + " " + fields["VERSION_ID"])
+}
+
+func readTrimmed(file string) string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
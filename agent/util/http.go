@@ -1,19 +1,20 @@
 package util
 
 import (
-	http2 "net/http"
+	"bytes"
 	"crypto/tls"
-	"time"
-	"io/ioutil"
 	"crypto/x509"
-	"path"
+	"encoding/pem"
+	"github.com/subutai-io/agent/agent/vars"
 	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/fips"
 	"github.com/subutai-io/agent/log"
-	"encoding/pem"
 	"io"
+	"io/ioutil"
+	http2 "net/http"
+	"path"
 	"strconv"
-	"bytes"
-	"github.com/subutai-io/agent/agent/vars"
+	"time"
 )
 
 const MaxIdleConnections = 10
@@ -43,7 +44,7 @@ func (http HttpUtil) Close(resp *http2.Response) {
 
 func (http HttpUtil) GetClient(timeoutSec int) *http2.Client {
 	tr := &http2.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: allowInsecure,},
+		TLSClientConfig: fips.TLSConfig(&tls.Config{InsecureSkipVerify: allowInsecure}),
 		IdleConnTimeout: time.Minute,
 		MaxIdleConns:    MaxIdleConnections,}
 
@@ -102,10 +103,10 @@ func newTLSConfig() (*tls.Config, error) {
 		log.Debug("SslPath is " + sslPath + " AllowInsecure is " + strconv.FormatBool(allowInsecure))
 	}
 
-	return &tls.Config{
+	return fips.TLSConfig(&tls.Config{
 		ClientAuth:         tls.NoClientCert,
 		ClientCAs:          nil,
 		InsecureSkipVerify: allowInsecure,
 		Certificates:       []tls.Certificate{cert},
-	}, nil
+	}), nil
 }
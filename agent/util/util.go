@@ -8,14 +8,15 @@ import (
 	"strings"
 	"time"
 
+	"errors"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
 	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fips"
 	"github.com/subutai-io/agent/log"
-	"github.com/influxdata/influxdb/client/v2"
-	"regexp"
 	"path"
-	"fmt"
-	"github.com/subutai-io/agent/lib/exec"
-	"errors"
+	"regexp"
 )
 
 var httpUtil = GetUtil()
@@ -23,11 +24,12 @@ var httpUtil = GetUtil()
 // ---> InfluxDB
 func InfluxDbClient() (clnt client.Client, err error) {
 	return client.NewHTTPClient(client.HTTPConfig{
-		Addr:               "https://" + path.Join(config.ManagementIP) + ":8086",
+		Addr:               "https://" + path.Join(config.ManagementIP) + ":" + config.Management.MetricsPort,
 		Username:           config.Influxdb.User,
 		Password:           config.Influxdb.Pass,
 		Timeout:            time.Second * 60,
 		InsecureSkipVerify: true,
+		TLSConfig:          fips.TLSConfig(&tls.Config{InsecureSkipVerify: true}),
 	})
 }
 
@@ -54,7 +56,7 @@ func InstanceType() string {
 }
 
 func GetClient(allowInsecure bool, timeoutSec int) *http.Client {
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: allowInsecure}}
+	tr := &http.Transport{TLSClientConfig: fips.TLSConfig(&tls.Config{InsecureSkipVerify: allowInsecure})}
 	return &http.Client{Transport: tr, Timeout: time.Second * time.Duration(timeoutSec)}
 }
 
@@ -71,28 +73,6 @@ func RetryGet(url string, clnt *http.Client, attempts int) (*http.Response, erro
 	return response, err
 }
 
-func VerifyLxcName(name string) {
-	/*
-	The labels must follow the rules for ARPANET host names.  They must
-	start with a letter, end with a letter or digit, and have as interior
-	characters only letters, digits, and hyphen.  There are also some
-	restrictions on the length.  Labels must be 63 characters or less.
-	*/
-
-	hostnameRegex := regexp.MustCompile(`^[[:alpha:]][[:alnum:]\-]{0,61}[[:alnum:]]$`)
-	singleLetterHostnameRegex := regexp.MustCompile(`^[[:alpha:]]$`)
-
-	if len(name) == 1 {
-		if !singleLetterHostnameRegex.MatchString(name) {
-			log.Error(fmt.Sprintf("value '%s' does not match %s",
-				name, singleLetterHostnameRegex.String()))
-		}
-	} else if !hostnameRegex.MatchString(name) {
-		log.Error(fmt.Sprintf("value '%s' does not match %s",
-			name, hostnameRegex.String()))
-	}
-}
-
 func MatchRegexGroups(regEx *regexp.Regexp, url string) (paramsMap map[string]string) {
 
 	match := regEx.FindStringSubmatch(url)
@@ -110,7 +90,7 @@ func GetConsolePubKey() ([]byte, error) {
 
 	clnt := httpUtil.GetClient(30)
 
-	resp, err := clnt.Get("https://" + path.Join(config.ManagementIP) + ":8443/rest/v1/security/keyman/getpublickeyring")
+	resp, err := clnt.Get("https://" + path.Join(config.ManagementIP) + ":" + config.Management.Port + "/rest/v1/security/keyman/getpublickeyring")
 
 	if err == nil {
 		defer Close(resp)
@@ -0,0 +1,247 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+// adoptedTemplate is recorded as parent of adopted containers unless template is specified,
+// they are not cloned from Subutai template
+const adoptedTemplate = "adopted"
+
+// LxcAdopt converts LXC container created outside of Subutai into container managed by agent: its root
+// filesystem is copied to datasets of the usual layout, config gets Subutai network, id mapping and parent
+// template items and container is registered in db. Without name, containers which can be adopted are listed
+//
+// subutai adopt [name] [--template {ref}] [-e {env-id}] [-n {net-settings}]
+func LxcAdopt(name, template, envID, addr string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		listForeignContainers()
+		return
+	}
+
+	checkValid(container.ValidateNewName(name))
+	checkTenantName("", name)
+	checkState(!fs.DatasetExists(name), "Container %s is managed by agent already", name)
+	source := path.Join(config.Agent.LxcPrefix, name)
+	checkState(fs.FileExists(path.Join(source, "config")), "LXC container %s not found", name)
+
+	rootfs, err := foreignRootfs(name)
+	checkValid(err)
+	checkState(container.State(name) == container.Stopped,
+		"Container %s must be stopped before adoption, stop it with \"lxc-stop -n %s\"", name, name)
+
+	owner := config.Agent.TemplateOwner
+	if owner == "" {
+		owner = "local"
+	}
+	parentRef := templ.Ref{Name: adoptedTemplate, Owner: owner, Version: "0.0.0"}
+	if template != "" {
+		parentRef, err = templ.ParseFullRef(template)
+		checkValid(err)
+	}
+
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: name})
+	defer lock.Release()
+
+	//original directory is moved aside since datasets are mounted in its place, it is restored on failure
+	backup := path.Join(config.Agent.LxcPrefix, "."+name+".adopt")
+	log.Check(log.ErrorLevel, "Moving container directory", os.Rename(source, backup))
+	if rootfs == source || strings.HasPrefix(rootfs, source+"/") {
+		rootfs = backup + strings.TrimPrefix(rootfs, source)
+	}
+	//datasets are removed and directory is restored if adoption fails, also if failure stops process midway
+	adopted := false
+	log.AtExit(func(int) {
+		if adopted {
+			return
+		}
+		if fs.DatasetExists(name) {
+			log.Check(log.WarnLevel, "Removing datasets", fs.RemoveDataset(name, true))
+		}
+		//mountpoint of removed dataset may be left behind
+		os.Remove(source)
+		log.Check(log.WarnLevel, "Restoring container directory", os.Rename(backup, source))
+	})
+
+	log.Info("Copying filesystem of " + name)
+	log.Check(log.ErrorLevel, "Creating dataset", fs.CreateDataset(name))
+	for _, partition := range fs.ChildDatasets {
+		log.Check(log.ErrorLevel, "Creating dataset", fs.CreateDataset(path.Join(name, partition)))
+	}
+	log.Check(log.ErrorLevel, "Copying root filesystem", copyRootfs(rootfs, name))
+	log.Check(log.ErrorLevel, "Copying config", fs.Copy(path.Join(backup, "config"), path.Join(source, "config")))
+
+	mac, err := container.Mac()
+	log.Check(log.ErrorLevel, "Generating mac address", err)
+	mtu, err := net.GetP2pMtu()
+	log.Check(log.ErrorLevel, "Obtaining MTU", err)
+
+	if common.GetMajorVersion() < 3 {
+		err = container.SetContainerConf(name, [][]string{
+			{"lxc.network.hwaddr", mac},
+			{"lxc.network.veth.pair", strings.Replace(mac, ":", "", -1)},
+			{"lxc.network.mtu", strconv.Itoa(mtu)},
+			{"subutai.parent", parentRef.Name},
+			{"subutai.parent.owner", parentRef.Owner},
+			{"subutai.parent.version", parentRef.Version},
+			{"lxc.rootfs", path.Join(config.Agent.LxcPrefix, name, "rootfs")},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "home") + " home none bind,rw 0 0"},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "opt") + " opt none bind,rw 0 0"},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "var") + " var none bind,rw 0 0"},
+			{"lxc.rootfs.backend", "zfs"},
+			{"lxc.utsname", name},
+		})
+	} else {
+		err = container.SetContainerConf(name, [][]string{
+			{"lxc.net.0.hwaddr", mac},
+			{"lxc.net.0.veth.pair", strings.Replace(mac, ":", "", -1)},
+			{"lxc.net.0.mtu", strconv.Itoa(mtu)},
+			{"subutai.parent", parentRef.Name},
+			{"subutai.parent.owner", parentRef.Owner},
+			{"subutai.parent.version", parentRef.Version},
+			{"lxc.rootfs.path", "zfs:" + path.Join(config.Agent.LxcPrefix, name, "rootfs")},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "home") + " home none bind,rw 0 0"},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "opt") + " opt none bind,rw 0 0"},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "var") + " var none bind,rw 0 0"},
+			{"lxc.uts.name", name},
+		})
+	}
+	log.Check(log.ErrorLevel, "Setting container config", err)
+
+	cont := &db.Container{Name: name, State: container.Stopped, EnvironmentId: envID, Template: parentRef.Name,
+		TemplateOwner: parentRef.Owner, TemplateVersion: parentRef.Version}
+
+	setContainerNetwork(name, addr, cont)
+	container.SetStaticNet(name)
+	cont.Uid, _ = container.SetContainerUID(name)
+	container.SetDNS(name)
+	container.CopyParentReference(name, parentRef.Owner, parentRef.Version)
+	log.Check(log.ErrorLevel, "Generating key", gpg.GenerateKey(name))
+
+	if common.GetMajorVersion() < 3 {
+		cont.Interface = container.GetProperty(name, "lxc.network.veth.pair")
+	} else {
+		cont.Interface = container.GetProperty(name, "lxc.net.0.veth.pair")
+	}
+
+	log.Check(log.ErrorLevel, "Writing container metadata to database", db.SaveContainer(cont))
+	adopted = true
+
+	log.Check(log.WarnLevel, "Removing original container directory", os.RemoveAll(backup))
+	if !strings.HasPrefix(rootfs, backup+"/") {
+		log.Info("Original root filesystem " + rootfs + " is kept, remove it when it is not needed")
+	}
+
+	log.Info(name + " with ID " + gpg.GetFingerprint(name) + " successfully adopted")
+}
+
+// foreignRootfs returns directory holding root filesystem of LXC container, only directory backed
+// containers can be adopted
+func foreignRootfs(name string) (string, error) {
+	items := container.GetConfigItems(path.Join(config.Agent.LxcPrefix, name, "config"), "lxc.rootfs.path", "lxc.rootfs")
+	rootfs := items["lxc.rootfs.path"]
+	if rootfs == "" {
+		rootfs = items["lxc.rootfs"]
+	}
+	if rootfs == "" {
+		rootfs = path.Join(config.Agent.LxcPrefix, name, "rootfs")
+	}
+
+	if strings.HasPrefix(rootfs, "dir:") {
+		rootfs = strings.TrimPrefix(rootfs, "dir:")
+	} else if i := strings.Index(rootfs, ":"); i > 0 {
+		return "", errors.Errorf("Container %s uses %s storage, only directory backed containers can be adopted",
+			name, rootfs[:i])
+	}
+
+	if info, err := os.Stat(rootfs); err != nil || !info.IsDir() {
+		return "", errors.Errorf("Root filesystem %s of container %s not found", rootfs, name)
+	}
+
+	return path.Clean(rootfs), nil
+}
+
+// copyRootfs copies root filesystem to datasets of container, content of home, opt and var goes to
+// datasets of these partitions
+func copyRootfs(rootfs, name string) error {
+	entries, err := ioutil.ReadDir(rootfs)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		source := path.Join(rootfs, entry.Name())
+		target := path.Join(config.Agent.LxcPrefix, name, "rootfs")
+
+		if entry.IsDir() && isMountedPartition(entry.Name()) {
+			//partitions are bind mounted to empty directories of rootfs
+			if err = os.Mkdir(path.Join(target, entry.Name()), entry.Mode().Perm()); err != nil && !os.IsExist(err) {
+				return err
+			}
+			source, target = source+"/.", path.Join(config.Agent.LxcPrefix, name, entry.Name())
+		}
+
+		//copying takes time proportional to container size, so it is not limited
+		result, err := exec.Run(context.Background(), exec.Options{Timeout: -1}, "cp", "-a", source, target)
+		if err != nil {
+			return errors.Errorf("Copying %s: %s %s", source, result.Stderr, err.Error())
+		}
+	}
+
+	for _, partition := range fs.ChildDatasets {
+		if isMountedPartition(partition) {
+			if err = os.Mkdir(path.Join(config.Agent.LxcPrefix, name, "rootfs", partition), 0755); err != nil && !os.IsExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isMountedPartition tells if directory of rootfs is kept in own dataset mounted into container
+func isMountedPartition(dir string) bool {
+	for _, partition := range fs.ChildDatasets {
+		if dir == partition && partition != "rootfs" {
+			return true
+		}
+	}
+	return false
+}
+
+func listForeignContainers() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tSTATE\tROOTFS")
+	for _, name := range container.All() {
+		if strings.HasPrefix(name, ".") || fs.DatasetExists(name) || !fs.FileExists(path.Join(config.Agent.LxcPrefix, name, "config")) {
+			continue
+		}
+
+		rootfs, err := foreignRootfs(name)
+		if err != nil {
+			rootfs = err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, container.State(name), rootfs)
+	}
+	w.Flush()
+}
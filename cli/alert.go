@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/agent/alert"
+	"github.com/subutai-io/agent/log"
+)
+
+// AddAlertRule creates or replaces an alert rule evaluated by the agent daemon.
+// Actions are passed in form type[=url], e.g. "log", "restart", "webhook=https://hooks.example.com/alert"
+//
+// subutai alert add high-ram -m ram -t foo -o ">" -l 90 -f 5m -s critical -a log -a restart
+func AddAlertRule(name, metric, target, operator string, threshold float64, duration, severity string, actions []string) {
+	rule := alert.Rule{
+		Name:      strings.TrimSpace(name),
+		Metric:    strings.ToLower(strings.TrimSpace(metric)),
+		Target:    strings.TrimSpace(target),
+		Operator:  strings.TrimSpace(operator),
+		Threshold: threshold,
+		Severity:  strings.ToLower(strings.TrimSpace(severity)),
+	}
+
+	if strings.TrimSpace(duration) != "" {
+		d, err := time.ParseDuration(strings.TrimSpace(duration))
+		checkArgument(err == nil, "Invalid duration %s", duration)
+		rule.For = d
+	}
+
+	for _, a := range actions {
+		parts := strings.SplitN(a, "=", 2)
+		action := alert.Action{Type: strings.ToLower(strings.TrimSpace(parts[0]))}
+		if len(parts) > 1 {
+			action.URL = strings.TrimSpace(parts[1])
+		}
+		rule.Actions = append(rule.Actions, action)
+	}
+
+	log.Check(log.ErrorLevel, "Saving alert rule", alert.AddRule(rule))
+}
+
+func RemoveAlertRule(name string) {
+	log.Check(log.ErrorLevel, "Removing alert rule", alert.RemoveRule(strings.TrimSpace(name)))
+}
+
+func ListAlertRules() {
+	rules, err := alert.LoadRules()
+	log.Check(log.ErrorLevel, "Loading alert rules", err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tMETRIC\tTARGET\tCONDITION\tFOR\tSEVERITY\tACTIONS")
+	for _, r := range rules {
+		var actions []string
+		for _, a := range r.Actions {
+			if a.URL != "" {
+				actions = append(actions, a.Type+"="+a.URL)
+			} else {
+				actions = append(actions, a.Type)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s %v\t%s\t%s\t%s\n", r.Name, r.Metric, r.Target, r.Operator, r.Threshold,
+			r.For, r.Severity, strings.Join(actions, ","))
+	}
+	w.Flush()
+}
+
+// CheckAlerts evaluates alert rules once, ignoring their durations, and prints current values
+func CheckAlerts() {
+	statuses, err := alert.Check()
+	log.Check(log.ErrorLevel, "Checking alert rules", err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "RULE\tTARGET\tMETRIC\tVALUE\tCONDITION\tSEVERITY\tSTATE")
+	for _, s := range statuses {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\t%s %v\t%s\t%s\n", s.Rule, s.Target, s.Metric, s.Value, s.Operator, s.Limit,
+			s.Severity, s.State)
+	}
+	w.Flush()
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/agent/auth"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/log"
+)
+
+// ApiTokenCreate generates token of daemon API validated by local backend and prints it. Role limits commands
+// token may run, token without ttl does not expire. Only hash of token is stored, so it can not be retrieved later
+//
+// subutai api token create ci --role operator [--ttl 86400]
+func ApiTokenCreate(name, role string, ttl int) {
+	name = strings.TrimSpace(name)
+	checkArgument(name != "", "Token name is empty")
+	checkArgument(auth.IsRole(role), "Invalid role %s, valid roles are %s", role, strings.Join(auth.Roles, ", "))
+	existing, err := db.FindApiToken("Name", name)
+	log.Check(log.ErrorLevel, "Reading API token", err)
+	checkState(existing == nil, "Token %s already exists", name)
+
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	log.Check(log.ErrorLevel, "Generating token", err)
+	token := hex.EncodeToString(buf)
+
+	t := &db.ApiToken{Hash: gpg.HashToken(token), Name: name, Role: role, Created: time.Now()}
+	if ttl > 0 {
+		t.Expires = t.Created.Add(time.Duration(ttl) * time.Second)
+	}
+	log.Check(log.ErrorLevel, "Saving API token", db.SaveApiToken(t))
+
+	fmt.Println(token)
+}
+
+// ApiTokenList prints tokens of daemon API validated by local backend
+//
+// subutai api token list
+func ApiTokenList() {
+	tokens, err := db.GetAllApiTokens()
+	log.Check(log.ErrorLevel, "Reading API tokens", err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tROLE\tCREATED\tEXPIRES\tSTATE")
+	for _, t := range tokens {
+		expires, state := "never", "valid"
+		if !t.Expires.IsZero() {
+			expires = t.Expires.Format(time.RFC3339)
+		}
+		if t.Revoked {
+			state = "revoked"
+		} else if !t.Expires.IsZero() && time.Now().After(t.Expires) {
+			state = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Name, t.Role, t.Created.Format(time.RFC3339), expires, state)
+	}
+	w.Flush()
+}
+
+// ApiTokenRevoke prevents further use of token of daemon API
+//
+// subutai api token revoke ci
+func ApiTokenRevoke(name string) {
+	t, err := db.FindApiToken("Name", name)
+	log.Check(log.ErrorLevel, "Reading API token", err)
+	checkState(t != nil, "Token %s not found", name)
+
+	t.Revoked = true
+	log.Check(log.ErrorLevel, "Saving API token", db.SaveApiToken(t))
+}
@@ -4,9 +4,8 @@
 package cli
 
 import (
-	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/log"
-	"gopkg.in/lxc/go-lxc.v2"
 )
 
 // LxcAttach allows user to use container's TTY.
@@ -15,20 +14,12 @@ import (
 // otherwise command will return error message and non-zero exit code.
 func LxcAttach(name string, cmd string) {
 	log.Debug("Attaching to container " + name)
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	log.Check(log.ErrorLevel, "Creating container object", err)
-	defer lxc.Release(c)
-
-	options := lxc.DefaultAttachOptions
-	options.EnvToKeep = []string{"TERM", "LS_COLORS"}
-	options.Env = []string{"HOME=/root", "USER=root"}
-
-	options.ClearEnv = true
 
+	var command []string
 	if len(cmd) > 0 {
-		_, err = c.RunCommand([]string{"/bin/bash", "-c", cmd}, options)
-		log.Check(log.ErrorLevel, "Attaching shell", err)
-	} else {
-		log.Check(log.ErrorLevel, "Attaching shell", c.AttachShell(options))
+		command = []string{"/bin/bash", "-c", cmd}
 	}
+
+	err := container.GetRuntime().Attach(name, command, []string{"HOME=/root", "USER=root"})
+	log.Check(log.ErrorLevel, "Attaching shell", err)
 }
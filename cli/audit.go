@@ -0,0 +1,234 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/lib/proxy"
+	"github.com/subutai-io/agent/log"
+)
+
+// severities of audit findings with points they take off score of 100
+var severityPenalty = map[string]int{"critical": 40, "high": 20, "medium": 10, "low": 5}
+
+// host paths which give container control over host or other containers when bind mounted into it
+var sensitiveHostPaths = []string{"/", "/boot", "/dev", "/etc", "/proc", "/root", "/run", "/sys", "/var/run",
+	"/var/lib/lxc", "/var/lib/lxd", "/lib/modules", "/usr"}
+
+// AuditFinding is risky setting of container found by security audit
+type AuditFinding struct {
+	Check       string `json:"check"`
+	Severity    string `json:"severity"`
+	Problem     string `json:"problem"`
+	Remediation string `json:"remediation"`
+}
+
+// SecurityReport is result of security audit of container, score is 100 less penalties of findings
+type SecurityReport struct {
+	Container string         `json:"container"`
+	Score     int            `json:"score"`
+	Findings  []AuditFinding `json:"findings"`
+}
+
+// AuditSecurity checks config of container for settings weakening its isolation from host: missing user
+// namespace mapping, broad device access, bind mounts of sensitive host paths, nesting and unconfined AppArmor,
+// and host privileged ports forwarded to it. Report is scored with remediation hint for each finding
+//
+// subutai audit security foo [--json]
+func AuditSecurity(name string, asJson bool) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	report, err := auditSecurity(name)
+	log.Check(log.ErrorLevel, "Auditing container "+name, err)
+
+	if asJson {
+		if report.Findings == nil {
+			report.Findings = []AuditFinding{}
+		}
+		out, err := json.Marshal(report)
+		log.Check(log.ErrorLevel, "Marshalling security report", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Printf("Container %s security score %d/100\n", report.Container, report.Score)
+	if len(report.Findings) == 0 {
+		fmt.Println("No risky settings found")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "SEVERITY\tCHECK\tPROBLEM\tREMEDIATION")
+	for _, f := range report.Findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Severity, f.Check, f.Problem, f.Remediation)
+	}
+	w.Flush()
+}
+
+func auditSecurity(name string) (*SecurityReport, error) {
+	report := &SecurityReport{Container: name, Score: 100}
+	add := func(check, severity, problem, remediation string) {
+		report.Findings = append(report.Findings, AuditFinding{Check: check, Severity: severity, Problem: problem,
+			Remediation: remediation})
+		report.Score -= severityPenalty[severity]
+	}
+	list := func(item string) ([]string, error) {
+		return container.GetContainerConfList(name, item)
+	}
+
+	//idmap
+	idmapItem := "lxc.idmap"
+	if common.GetMajorVersion() < 3 {
+		idmapItem = "lxc.id_map"
+	}
+	idmaps, err := list(idmapItem)
+	if err != nil {
+		return nil, err
+	}
+	if len(idmaps) == 0 {
+		add("idmap", "critical", "no user namespace mapping, root of container is root of host",
+			fmt.Sprintf("map ids with \"%s = u 0 %s 65536\" and \"%s = g 0 %s 65536\", then shift rootfs ownership",
+				idmapItem, container.GetContainerUID(name), idmapItem, container.GetContainerUID(name)))
+	} else {
+		for _, m := range idmaps {
+			if fields := strings.Fields(m); len(fields) == 4 && fields[1] == "0" && fields[2] == "0" {
+				add("idmap", "critical", "container root is mapped to host root: "+m,
+					"map container ids to unprivileged range of host, e.g. "+container.GetContainerUID(name))
+			}
+		}
+	}
+
+	//cgroup device allows
+	for _, item := range []string{"lxc.cgroup.devices.allow", "lxc.cgroup2.devices.allow"} {
+		allows, err := list(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, allow := range allows {
+			fields := strings.Fields(allow)
+			if len(fields) == 1 && fields[0] == "a" || len(fields) > 1 && strings.HasPrefix(fields[1], "*:*") {
+				add("devices", "high", item+" = "+allow+" grants access to all devices of host",
+					"remove the item and allow required devices by major:minor number only")
+			}
+		}
+	}
+
+	//bind mounts
+	entries, err := list("lxc.mount.entry")
+	if err != nil {
+		return nil, err
+	}
+	own := path.Join(config.Agent.LxcPrefix, name)
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) < 4 || !strings.Contains(fields[3], "bind") {
+			continue
+		}
+		src := path.Clean(fields[0])
+		if src == own || strings.HasPrefix(src, own+"/") {
+			continue
+		}
+		severity := ""
+		for _, sensitive := range append(sensitiveHostPaths, config.Agent.LxcPrefix, config.Agent.DataPrefix) {
+			if src == sensitive || sensitive != "/" && strings.HasPrefix(src, sensitive+"/") {
+				severity = "high"
+				if hasOption(fields[3], "ro") {
+					severity = "medium"
+				}
+			}
+		}
+		if severity != "" {
+			add("mounts", severity, "host path "+src+" is bind mounted into container",
+				"remove the mount entry or mount a dedicated directory read-only")
+		}
+	}
+
+	//nesting and confinement
+	includes, err := list("lxc.include")
+	if err != nil {
+		return nil, err
+	}
+	for _, include := range includes {
+		if path.Base(include) == "nesting.conf" {
+			add("nesting", "high", "nesting is enabled with "+include,
+				"remove the include unless container must run its own containers")
+		}
+	}
+	autoMounts, err := list("lxc.mount.auto")
+	if err != nil {
+		return nil, err
+	}
+	for _, auto := range autoMounts {
+		for _, option := range strings.Fields(auto) {
+			if option == "proc:rw" || option == "sys:rw" || strings.HasPrefix(option, "cgroup:rw") ||
+				strings.HasPrefix(option, "cgroup-full:rw") {
+				add("nesting", "high", "lxc.mount.auto mounts "+option+" writable",
+					"mount proc, sys and cgroup read-only or mixed, e.g. \"proc:mixed sys:mixed cgroup:mixed\"")
+			}
+		}
+	}
+	for _, item := range []string{"lxc.apparmor.profile", "lxc.aa_profile"} {
+		profiles, err := list(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, profile := range profiles {
+			if profile == "unconfined" {
+				add("apparmor", "high", "container is not confined by AppArmor",
+					"remove "+item+" to use default generated profile")
+			}
+		}
+	}
+	nesting, err := list("lxc.apparmor.allow_nesting")
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nesting {
+		if n == "1" {
+			add("nesting", "medium", "AppArmor profile allows nesting",
+				"set lxc.apparmor.allow_nesting = 0 unless container must run its own containers")
+		}
+	}
+
+	//privileged ports
+	ips := strings.Fields(container.GetIp(name))
+	proxies, err := proxy.GetProxies("")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range proxies {
+		if p.Proxy.Port >= 1024 || p.Proxy.Port == 80 || p.Proxy.Port == 443 {
+			continue
+		}
+		for _, server := range p.Servers {
+			if stringInList(net.SocketHost(server.Socket), ips) {
+				add("ports", "low", fmt.Sprintf("privileged %s port %d of host is forwarded to %s",
+					p.Proxy.Protocol, p.Proxy.Port, server.Socket),
+					"map an unprivileged port or restrict mapping with bind option to internal interface")
+			}
+		}
+	}
+
+	if report.Score < 0 {
+		report.Score = 0
+	}
+
+	return report, nil
+}
+
+// hasOption checks if comma separated mount options include option
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
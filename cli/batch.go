@@ -3,7 +3,7 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
-	"os/exec"
+	"github.com/subutai-io/agent/lib/exec"
 	"strings"
 
 	"github.com/subutai-io/agent/log"
@@ -35,7 +35,7 @@ func Batch(data string) {
 
 	for _, item := range list {
 		args := append([]string{item.Action}, item.Args...)
-		out, err := exec.Command("subutai", args...).CombinedOutput()
+		out, err := exec.CombinedOutput("subutai", args...)
 		cmdout.ExitCode = "0"
 		cmdout.Output = string(out)
 		if err != nil {
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// StartContainers starts named containers, all containers or containers of environment. Several containers
+// are started concurrently, up to parallel at once, and summary is printed
+//
+// subutai start foo bar baz [--parallel 5]
+// subutai start --env {env-id}
+// subutai start --all
+func StartContainers(names []string, all bool, env string, parallel int) {
+	if len(names) == 1 && !all && env == "" {
+		LxcStart(names[0])
+		return
+	}
+
+	targets := bulkTargets(names, all, env, parallel)
+	reportBulk("started", container.StartAll(targets, parallel))
+}
+
+// StopContainers stops named containers, all containers or containers of environment. Several containers
+// are stopped concurrently, up to parallel at once, and summary is printed
+//
+// subutai stop foo bar baz [--parallel 5]
+// subutai stop --env {env-id}
+// subutai stop --all
+func StopContainers(names []string, all bool, env string, parallel int) {
+	if len(names) == 1 && !all && env == "" {
+		LxcStop(names[0])
+		return
+	}
+
+	targets := bulkTargets(names, all, env, parallel)
+	reportBulk("stopped", container.StopAll(targets, parallel))
+}
+
+// DestroyContainers destroys named containers and templates, all containers or containers of environment.
+// Several of them are destroyed concurrently, up to parallel at once, and summary is printed. Single name is
+// handled by LxcDestroy, so "everything" and "id:" forms keep working
+//
+// subutai destroy foo bar baz [--parallel 5]
+// subutai destroy --env {env-id}
+// subutai destroy --all
+func DestroyContainers(names []string, all bool, env string, parallel int) {
+	if len(names) == 1 && !all && env == "" {
+		LxcDestroy(names[0])
+		return
+	}
+
+	targets := bulkTargets(names, all, env, parallel)
+
+	//network of containers is released one by one, ovs and iptables do not take concurrent changes well
+	var results []container.BulkResult
+	var ready []string
+	envs := make(map[string]bool)
+	for _, name := range targets {
+		c, err := db.FindContainerByName(name)
+		log.Check(log.WarnLevel, "Reading container metadata from db", err)
+		if c != nil {
+			if err = releaseNetwork(c); err != nil {
+				results = append(results, container.BulkResult{Name: name, Err: err})
+				continue
+			}
+			if c.EnvironmentId != "" {
+				envs[c.EnvironmentId] = true
+			}
+		}
+		ready = append(ready, name)
+	}
+
+	destroyed := container.DestroyAll(ready, parallel)
+	for _, r := range destroyed {
+		if r.Name == container.Management && r.Err == nil {
+			deleteManagement()
+		}
+	}
+	for env := range envs {
+		log.Check(log.WarnLevel, "Updating hosts of environment "+env, container.UpdateEnvironmentHosts(env))
+	}
+
+	reportBulk("destroyed", append(results, destroyed...))
+}
+
+// bulkTargets returns names of containers bulk operation applies to. Management container is not included
+// in all containers, it must be named explicitly
+func bulkTargets(names []string, all bool, env string, parallel int) []string {
+	checkArgument(parallel > 0, "Number of parallel operations must be positive")
+	checkArgument(len(names) > 0 || all || env != "", "Specify containers, --all or --env")
+	checkArgument(len(names) == 0 || !all && env == "", "Containers can not be named along with --all or --env")
+	checkArgument(!all || env == "", "Specify either --all or --env")
+
+	if len(names) > 0 {
+		return names
+	}
+
+	var targets []string
+	if env != "" {
+		list, err := db.FindContainers("", "", "")
+		log.Check(log.ErrorLevel, "Reading container metadata from db", err)
+		for _, c := range list {
+			if c.EnvironmentId == env && container.IsContainer(c.Name) {
+				targets = append(targets, c.Name)
+			}
+		}
+		checkState(len(targets) > 0, "No containers of environment %s found", env)
+	} else {
+		for _, name := range container.Containers() {
+			if name != container.Management {
+				targets = append(targets, name)
+			}
+		}
+		checkState(len(targets) > 0, "No containers found")
+	}
+	sort.Strings(targets)
+
+	return targets
+}
+
+// reportBulk prints outcome of bulk operation per container, error is logged if operation failed for any of them
+func reportBulk(action string, results []container.BulkResult) {
+	sendHeartbeat()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "CONTAINER\tRESULT")
+	for _, r := range results {
+		result := action
+		if r.Err != nil {
+			result = "failed: " + r.Err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\n", r.Name, result)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		log.Error(fmt.Sprintf("Failed for %d of %d containers", failed, len(results)))
+	}
+	log.Info(fmt.Sprintf("%d containers %s", len(results), action))
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// resourceCapacity compares resource of host with sum of quotas of containers and actual usage
+type resourceCapacity struct {
+	Total     int64 `json:"total"`
+	Committed int64 `json:"committed"`
+	Used      int64 `json:"used"`
+	//number of containers without quota of the resource, they may use all of it
+	Unlimited int `json:"unlimited"`
+	//committed to total ratio, above 1 means resource is overcommitted
+	Overcommit float64 `json:"overcommit"`
+}
+
+type hostCapacity struct {
+	Containers int `json:"containers"`
+	//percents of all cores
+	CPU resourceCapacity `json:"cpu"`
+	//bytes
+	RAM  resourceCapacity `json:"ram"`
+	Disk resourceCapacity `json:"disk"`
+}
+
+// HostCapacity prints total, committed and used CPU, RAM and disk of host, where committed is sum of quotas of
+// containers, along with overcommit ratios, e.g. for placement of new containers by Console or external schedulers.
+// CPU is in percents of all cores, RAM and disk are in bytes
+//
+// subutai host capacity [--json]
+func HostCapacity(asJSON bool) {
+	capacity := hostCapacity{}
+	capacity.CPU.Total = 100
+
+	memFree, memTotal, memCached := ramLoad()
+	if total, ok := memTotal.(int); ok {
+		capacity.RAM.Total = int64(total)
+		free, _ := memFree.(int)
+		cached, _ := memCached.(int)
+		capacity.RAM.Used = int64(total - free - cached)
+	}
+
+	size, allocated, _, err := fs.GetPoolUsage()
+	log.Check(log.ErrorLevel, "Reading pool usage", err)
+	capacity.Disk.Total, capacity.Disk.Used = size, allocated
+
+	idle0, total0 := getCPUstat()
+	time.Sleep(time.Second)
+	idle1, total1 := getCPUstat()
+	if total1 > total0 {
+		capacity.CPU.Used = int64(100 * (float64(total1-total0) - float64(idle1-idle0)) / float64(total1-total0))
+	}
+
+	for _, name := range container.Containers() {
+		capacity.Containers++
+
+		if cpu := container.QuotaCPU(name, ""); cpu > 0 {
+			capacity.CPU.Committed += int64(cpu)
+		} else {
+			capacity.CPU.Unlimited++
+		}
+		if ram := container.QuotaRAM(name, ""); ram > 0 {
+			capacity.RAM.Committed += int64(ram) * 1024 * 1024
+		} else {
+			capacity.RAM.Unlimited++
+		}
+		if disk, err := fs.GetQuota(name); err == nil && disk > 0 {
+			capacity.Disk.Committed += int64(disk)
+		} else {
+			capacity.Disk.Unlimited++
+		}
+	}
+
+	for _, r := range []*resourceCapacity{&capacity.CPU, &capacity.RAM, &capacity.Disk} {
+		if r.Total > 0 {
+			r.Overcommit = float64(r.Committed) / float64(r.Total)
+		}
+	}
+
+	if asJSON {
+		out, err := json.Marshal(capacity)
+		log.Check(log.ErrorLevel, "Marshalling host capacity", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "RESOURCE\tTOTAL\tCOMMITTED\tUSED\tUNLIMITED\tOVERCOMMIT")
+	fmt.Fprintf(w, "cpu\t%d%%\t%d%%\t%d%%\t%d\t%.2f\n", capacity.CPU.Total, capacity.CPU.Committed, capacity.CPU.Used,
+		capacity.CPU.Unlimited, capacity.CPU.Overcommit)
+	for _, r := range []struct {
+		name string
+		*resourceCapacity
+	}{{"ram", &capacity.RAM}, {"disk", &capacity.Disk}} {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%.2f\n", r.name, formatSize(r.Total), formatSize(r.Committed),
+			formatSize(r.Used), r.Unlimited, r.Overcommit)
+	}
+	w.Flush()
+}
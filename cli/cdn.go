@@ -1,24 +1,25 @@
 package cli
 
 import (
-	"strings"
-	"github.com/subutai-io/agent/lib/fs"
-	"github.com/subutai-io/agent/config"
-	"github.com/subutai-io/agent/agent/util"
-	"github.com/subutai-io/agent/log"
-	"io/ioutil"
 	"fmt"
-	"regexp"
 	"github.com/cavaliercoder/grab"
-	"time"
-	"path"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/fips"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
 	"gopkg.in/cheggaaa/pb.v1"
-	"mime/multipart"
-	"os"
 	"io"
-	"sync"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 func DownloadRawFile(id, destDir string) error {
@@ -77,6 +78,7 @@ func downloadFile(fileId, fileName, destDir string) error {
 
 	// create client
 	client := grab.NewClient()
+	client.HTTPClient.Transport = fips.Transport(client.HTTPClient.Transport)
 
 	req, err := grab.NewRequest(destFile, fileUrl)
 
@@ -126,7 +128,7 @@ Loop:
 	return nil
 }
 
-func UploadRawFile(filePath, cdnToken string) error {
+func UploadRawFile(filePath, cdnToken, bwLimit string) error {
 
 	filePath = strings.TrimSpace(filePath)
 	cdnToken = strings.TrimSpace(cdnToken)
@@ -134,8 +136,10 @@ func UploadRawFile(filePath, cdnToken string) error {
 	checkArgument(filePath != "", "Invalid file path")
 	checkArgument(cdnToken != "", "Invalid token")
 	checkState(fs.FileExists(filePath), "File %s not found", filePath)
+	rate, err := fs.TransferRate(bwLimit)
+	checkValid(err)
 
-	out, err := uploadFile(filePath, cdnToken)
+	out, err := uploadFile(filePath, cdnToken, rate)
 	log.Check(log.ErrorLevel, "Uploading file "+filePath, err)
 
 	log.Info("File " + filepath.Base(filePath) + " uploaded to CDN:\n" + out)
@@ -143,7 +147,7 @@ func UploadRawFile(filePath, cdnToken string) error {
 	return nil
 }
 
-func uploadFile(filePath, token string) (string, error) {
+func uploadFile(filePath, token string, rate int64) (string, error) {
 
 	file, err := os.Open(filePath)
 	if log.Check(log.DebugLevel, "Opening file for upload", err) {
@@ -181,7 +185,7 @@ func uploadFile(filePath, token string) (string, error) {
 			w.CloseWithError(err)
 		}
 		part = io.MultiWriter(part, bar)
-		if _, err = io.Copy(part, file); err != nil {
+		if _, err = io.Copy(part, fs.ThrottleReader(file, rate)); err != nil {
 			w.CloseWithError(err)
 		}
 		if err = mpw.Close(); err != nil {
@@ -2,60 +2,64 @@ package cli
 
 import (
 	"net"
+	"strconv"
 	"strings"
 
-	"fmt"
-	"github.com/nightlyone/lockfile"
-	"github.com/subutai-io/agent/agent/util"
 	"github.com/subutai-io/agent/db"
 	"github.com/subutai-io/agent/lib/common"
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/lib/gpg"
 	"github.com/subutai-io/agent/log"
-	"reflect"
-	"regexp"
-	"sort"
-	"time"
-)
-
-var (
-	templateNameNOwnerNVersionRx = regexp.MustCompile(`^(?P<name>[a-zA-Z0-9._-]+)[@:](?P<owner>[a-zA-Z0-9._-]+):(?P<version>\d+\.\d+\.\d+)$`)
-	templateNameNOwnerRx         = regexp.MustCompile(`^(?P<name>[a-zA-Z0-9._-]+)[@:](?P<owner>[a-zA-Z0-9._-]+)$`)
-	templateNameRx               = regexp.MustCompile(`^(?P<name>[a-zA-Z0-9._-]+)$`)
 )
 
 // LxcClone function creates new `child` container from a Subutai `parent` template.
 //
 // If the specified template argument is not deployed in system, Subutai first tries to import it, and if import succeeds, it then continues to clone from the imported template image.
 //
-// If `-n` option is defined, separate bridge interface will be created in specified VLAN and new container will receive the specified static IP address.
+// If `-n` option is defined, separate bridge interface will be created in specified VLAN and new container will receive the specified static IP address. Optional third field of `-n` gives container IPv6 address in /64 network, e.g. "172.16.1.5/24 1100 fd00:1100::5", making it dual-stack.
 // Option `-e` writes the environment ID string inside new container.
 // Option `-s` is intended to check the origin of new container creation request during environment build.
 // This is one of the security checks which makes sure that each container creation request is authorized by registered user.
+// Option `--snapshot` clones container from labeled snapshot of installed template, e.g. pinned validated state, instead of @now.
+// Option `--tenant` clones container of tenant, which must be named with tenant prefix, e.g. acme-web, and is limited by quotas of tenant.
+// Option `-f` provisions container on its first boot, similar to cloud-init: files of yaml payload are written into container before it is started, then its scripts are run inside started container with variables of payload.
 //
 // The clone options are not intended for manual use: unless you're confident about what you're doing. Use default clone format without additional options to create Subutai containers.
-func LxcClone(parent, child, envID, addr, consoleSecret string) {
+func LxcClone(parent, child, snapshot, envID, addr, consoleSecret, provisionFile, tenant string) {
+
+	checkValid(container.ValidateNewName(child))
+	tenant = strings.TrimSpace(tenant)
+
+	var provision *provisionPayload
+	if provisionFile != "" {
+		var err error
+		provision, err = readProvision(provisionFile)
+		log.Check(log.ErrorLevel, "Reading provisioning payload", err)
+	}
 
-	util.VerifyLxcName(child)
+	snapshot = strings.TrimSpace(snapshot)
+	if snapshot == "" {
+		snapshot = "now"
+	}
+	checkValid(container.ValidateDatasetComponent(snapshot))
 
 	if container.LxcInstanceExists(child) {
 		log.Error("Container " + child + " already exists")
 	}
 
-	//synchronize
-	var lock lockfile.Lockfile
-	var err error
-	for lock, err = common.LockFile("", "clone"); err != nil; lock, err = common.LockFile("", "clone") {
-		time.Sleep(time.Second * 1)
-	}
-	defer lock.Unlock()
+	//synchronize, template lock is acquired by import if template is missing
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: child})
+	defer lock.Release()
 	//<<<synchronize
 
+	tenantLock := checkTenantClone(tenant, child, consoleSecret, nil)
+	defer tenantLock.Release()
+
 	defer sendHeartbeat()
 
 	t := getTemplateInfo(parent)
 
-	log.Debug("Parent template is " + t.Name + "@" + t.Owner + ":" + t.Version)
+	log.Debug("Parent template is " + t.Ref().CdnString())
 
 	cont := &db.Container{}
 	cont.Name = child
@@ -63,85 +67,36 @@ func LxcClone(parent, child, envID, addr, consoleSecret string) {
 	cont.TemplateOwner = t.Owner
 	cont.TemplateVersion = t.Version
 	cont.TemplateId = t.Id
+	cont.Tenant = tenant
 
-	fullRef := strings.Join([]string{t.Name, t.Owner, t.Version}, ":")
+	fullRef := t.Ref().String()
 
+	checks := []hostCheck{poolCheck(), lxcCheck(), toolCheck("uidmapshift", "uidmapshift", "install subutai uidmapshift")}
 	if !container.IsTemplate(fullRef) {
+		//missing template is downloaded and extracted into cache
+		checks = append(checks, cacheSpaceCheck(2*t.Size))
+	}
+	preflight("clone of "+child, checks...)
+
+	if !container.IsTemplate(fullRef) {
+		//imported template has only @now snapshot
+		checkState(snapshot == "now", "Template %s must be installed to clone from its snapshot %s", fullRef, snapshot)
 		LxcImport("id:"+t.Id, "")
 	}
 
-	log.Check(log.ErrorLevel, "Cloning the container", container.Clone(fullRef, child))
+	log.Check(log.ErrorLevel, "Cloning the container", container.CloneFromSnapshot(fullRef, child, snapshot))
 
 	gpg.GenerateKey(child)
 	if len(consoleSecret) != 0 {
-		gpg.ExchangeAndEncrypt(child, consoleSecret)
+		gpg.ExchangeAndEncrypt(child, consoleSecret, gpg.ScopeClone)
 	}
 
 	if len(envID) != 0 {
 		cont.EnvironmentId = envID
 	}
 
-	if ip := strings.Fields(addr); len(ip) > 1 {
-
-		cont.Ip = strings.Split(ip[0], "/")[0]
-		cont.Gateway = getOrGenerateGateway(addr)
-		cont.Vlan = ip[1]
-
-		if common.GetMajorVersion() < 3 {
-			container.SetContainerConf(child, [][]string{
-				{"lxc.network.flags", "up"},
-				{"lxc.network.ipv4.address", fmt.Sprintf("%s/24", cont.Ip)},
-				{"lxc.network.ipv4.gateway", cont.Gateway},
-				{"#vlan_id", cont.Vlan},
-			})
-		} else {
-			container.SetContainerConf(child, [][]string{
-				{"lxc.net.0.flags", "up"},
-				{"lxc.net.0.ipv4.address", fmt.Sprintf("%s/24", cont.Ip)},
-				{"lxc.net.0.ipv4.gateway", cont.Gateway},
-				{"#vlan_id", cont.Vlan},
-			})
-		}
-	} else {
-		//determine next free IP
-		freeIPs := make(map[string]bool)
-		for i := 100; i < 200; i++ {
-			freeIPs[fmt.Sprintf("10.10.10.%d", i)] = true
-		}
-
-		for _, cont := range container.Containers() {
-			ip := container.GetIp(cont)
-			delete(freeIPs, ip)
-		}
-
-		if len(freeIPs) == 0 {
-			log.Error("There is no free IP in range 10.10.10.1xx left")
-		}
-
-		//sort IPs
-		keys := reflect.ValueOf(freeIPs).MapKeys()
-		sort.Slice(keys[:], func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-		//use first free ip
-		cont.Ip = keys[0].String()
-		cont.Gateway = "10.10.10.254"
-
-		if common.GetMajorVersion() < 3 {
-			container.SetContainerConf(child, [][]string{
-				{"lxc.network.flags", "up"},
-				{"lxc.network.ipv4.address", fmt.Sprintf("%s/24", cont.Ip)},
-				{"lxc.network.ipv4.gateway", cont.Gateway},
-			})
-		} else {
-			container.SetContainerConf(child, [][]string{
-				{"lxc.net.0.flags", "up"},
-				{"lxc.net.0.ipv4.address", fmt.Sprintf("%s/24", cont.Ip)},
-				{"lxc.net.0.ipv4.gateway", cont.Gateway},
-			})
-		}
+	setContainerNetwork(child, addr, cont)
 
-	}
 	//changing from dhcp to manual
 	container.SetStaticNet(child)
 
@@ -162,9 +117,29 @@ func LxcClone(parent, child, envID, addr, consoleSecret string) {
 	}
 
 	log.Check(log.ErrorLevel, "Writing container metadata to database", db.SaveContainer(cont))
+	setTenantDefaultQuotas(tenant, child)
+	tenantLock.Release()
+
+	//containers of environment resolve each other by hostname
+	if cont.EnvironmentId != "" {
+		log.Check(log.WarnLevel, "Updating hosts of environment "+cont.EnvironmentId,
+			container.UpdateEnvironmentHosts(cont.EnvironmentId))
+	} else {
+		log.Check(log.WarnLevel, "Updating hosts of "+child, container.UpdateHosts(child))
+	}
+
+	if provision != nil {
+		log.Check(log.ErrorLevel, "Writing provisioning files", applyProvisionFiles(child, provision))
+	}
 
 	LxcStart(child)
 
+	if provision != nil {
+		log.Check(log.ErrorLevel, "Provisioning "+child+", container is kept for inspection",
+			runProvisionScripts(child, provision))
+		log.Info(child + " provisioned")
+	}
+
 	log.Info(child + " with ID " + gpg.GetFingerprint(child) + " successfully cloned")
 }
 
@@ -183,6 +158,40 @@ func getOrGenerateGateway(addr string) string {
 	return gateway
 }
 
+// getOrGenerateGateway6 parses IPv6 address of container given with or without prefix length and returns it
+// with gateway of its network: gateway of other containers in vlan or the first address of /64 network.
+// Address must not be taken by other container
+func getOrGenerateGateway6(name, addr, vlan string) (string, string) {
+	if !strings.Contains(addr, "/") {
+		addr += "/" + container.Ipv6Prefix
+	}
+	ip, network, err := net.ParseCIDR(addr)
+	checkArgument(err == nil && ip.To4() == nil, "Invalid IPv6 address %s", addr)
+	ones, _ := network.Mask.Size()
+	checkArgument(strconv.Itoa(ones) == container.Ipv6Prefix, "IPv6 network of container must be /%s",
+		container.Ipv6Prefix)
+
+	all, err := db.FindContainers("", "", "")
+	log.Check(log.ErrorLevel, "Looking up containers in db", err)
+	for _, c := range all {
+		checkArgument(c.Name == name || c.Ipv6 == "" || !net.ParseIP(c.Ipv6).Equal(ip),
+			"IPv6 address %s is already taken by %s", ip, c.Name)
+	}
+
+	list, _ := db.FindContainers("", "", vlan)
+	for _, c := range list {
+		if c.Gateway6 != "" {
+			return ip.String(), c.Gateway6
+		}
+	}
+
+	gw := network.IP.To16()
+	gw[15] |= 1
+	checkArgument(!gw.Equal(ip), "IPv6 address %s is reserved for gateway", ip)
+
+	return ip.String(), gw.String()
+}
+
 func getEnvGw(vlan string) (gw string) {
 
 	list, _ := db.FindContainers("", "", vlan)
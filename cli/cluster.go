@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/agent/cluster"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// RemoteExec runs subutai command on cluster peer and prints its output, exit code of command is returned.
+// Host flag is removed from args, so the rest of command line is passed to peer as is
+//
+// subutai --host rh2 list
+func RemoteExec(host string, args []string) int {
+	var forwarded []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--host" {
+			i++
+			continue
+		}
+		if strings.HasPrefix(args[i], "--host=") {
+			continue
+		}
+		forwarded = append(forwarded, args[i])
+	}
+	//command is audited on peer under the same operation
+	if op := log.Operation(); op != "" && !stringInList("--operation", forwarded) {
+		forwarded = append([]string{"--operation", op}, forwarded...)
+	}
+
+	result, err := cluster.Exec(host, forwarded)
+	log.Check(log.ErrorLevel, "Running command on "+host, err)
+
+	fmt.Fprint(os.Stdout, result.Stdout)
+	fmt.Fprint(os.Stderr, result.Stderr)
+
+	return result.ExitCode
+}
+
+// ClusterPeers prints peers found by cluster discovery
+//
+// subutai cluster peers
+func ClusterPeers() {
+	peers, err := db.GetAllClusterPeers()
+	log.Check(log.ErrorLevel, "Reading cluster peers", err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tADDRESS\tSTATIC\tLAST SEEN")
+	for _, p := range peers {
+		seen := "never"
+		if !p.Seen.IsZero() {
+			seen = p.Seen.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", p.Name, p.Address, p.Static, seen)
+	}
+	w.Flush()
+}
+
+// ClusterLock runs command holding cluster-wide lock, so that the same operation, e.g. migration of container,
+// is not run concurrently from other hosts. Lock is renewed while command runs and released after it exits,
+// exit code of command is returned. Command is killed if lock is lost
+//
+// subutai cluster lock migrate-foo -- subutai clone ...
+func ClusterLock(name string, ttl int, command []string) int {
+	lock, err := cluster.Acquire(name, time.Duration(ttl)*time.Second)
+	log.Check(log.ErrorLevel, "Acquiring cluster lock "+name, err)
+	defer lock.Release()
+
+	//command is killed once lock is lost, another holder may take it then
+	ctx := lock.Hold(context.Background())
+	result, err := exec.Run(ctx, exec.Options{Timeout: -1, Stdin: os.Stdin, Stdout: os.Stdout,
+		Stderr: os.Stderr}, command[0], command[1:]...)
+	if ctx.Err() != nil {
+		log.Warn("Command is stopped since cluster lock " + name + " is lost")
+		return 1
+	}
+	if err != nil && result.ExitCode <= 0 {
+		log.Warn("Running command: " + err.Error())
+		return 1
+	}
+
+	return result.ExitCode
+}
+
+// ClusterLocks prints leases of cluster-wide locks granted by this host
+//
+// subutai cluster locks
+func ClusterLocks() {
+	leases, err := cluster.Leases()
+	log.Check(log.ErrorLevel, "Reading leases of cluster locks", err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tHOLDER\tEXPIRES")
+	for _, l := range leases {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", l.Name, l.Holder, l.Expires.Format(time.RFC3339))
+	}
+	w.Flush()
+}
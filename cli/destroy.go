@@ -101,19 +101,21 @@ func destroy(name string) error {
 		if c != nil {
 			//destroy container that has metadata
 
-			err = removeContainerPortMappings(name)
+			err = releaseNetwork(c)
 			if err != nil {
-				return errors.New(fmt.Sprintf("Error removing port mapping: %s", err.Error()))
+				return err
 			}
 
-			//todo check error here
-			net.DelIface(c.Interface)
-
 			err = container.DestroyContainer(name)
 			if err != nil {
 				return errors.New(fmt.Sprintf("Error destroying container: %s", err.Error()))
 			}
 
+			if c.EnvironmentId != "" {
+				log.Check(log.WarnLevel, "Updating hosts of environment "+c.EnvironmentId,
+					container.UpdateEnvironmentHosts(c.EnvironmentId))
+			}
+
 		} else if container.IsContainer(name) {
 			//destroy container with missing metadata
 
@@ -138,6 +140,19 @@ func destroy(name string) error {
 	return nil
 }
 
+//removes port mappings and interface of container
+func releaseNetwork(c *db.Container) error {
+	err := removeContainerPortMappings(c.Name)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error removing port mapping: %s", err.Error()))
+	}
+
+	//todo check error here
+	net.DelIface(c.Interface)
+
+	return nil
+}
+
 func deleteManagement() {
 	exec.Exec("ovs-vsctl", "del-port", "wan", container.Management)
 	exec.Exec("ovs-vsctl", "del-port", "wan", "mng-gw")
@@ -160,14 +175,18 @@ func cleanupNetStat(vlan string) {
 }
 
 func removeContainerPortMappings(name string) error {
-	containerIp := container.GetIp(name)
+	containerIps := strings.Fields(container.GetIp(name))
 	servers, err := db.FindProxiedServers("", "")
 	if !log.Check(log.WarnLevel, "Fetching port mappings", err) {
 		var removedServers []db.ProxiedServer
 
 		for _, server := range servers {
-			sock := strings.Split(server.Socket, ":")
-			if sock[0] == containerIp {
+			host := net.SocketHost(server.Socket)
+			owned := false
+			for _, ip := range containerIps {
+				owned = owned || ip == host
+			}
+			if owned {
 				err = prxy.RemoveProxiedServer(server.ProxyTag, server.Socket)
 				if err != nil {
 					log.Error("Error removing server ", err)
@@ -196,6 +215,21 @@ type gradedTemplate struct {
 	grade     int
 }
 
+// templateRefs returns lowercase references of template of container or template itself and of its parent
+func templateRefs(name string) (self, parent string) {
+	props := container.GetProperties(name, "subutai.template", "subutai.template.owner", "subutai.template.version",
+		"subutai.parent", "subutai.parent.owner", "subutai.parent.version")
+
+	self = strings.ToLower(strings.TrimSpace(props["subutai.template"]) + ":" +
+		strings.TrimSpace(props["subutai.template.owner"]) + ":" +
+		strings.TrimSpace(props["subutai.template.version"]))
+	parent = strings.ToLower(strings.TrimSpace(props["subutai.parent"]) + ":" +
+		strings.TrimSpace(props["subutai.parent.owner"]) + ":" +
+		strings.TrimSpace(props["subutai.parent.version"]))
+
+	return self, parent
+}
+
 //Prune destroys templates that don't have child containers
 //It destroys unused templates by hierarchy, first destroying child templates and then parents
 //this is imposed by underlying zfs file system that prohibits destruction of datasets that have child datasets
@@ -206,26 +240,14 @@ func Prune() {
 	for _, c := range container.Containers() {
 		cont := c
 
-		self := strings.ToLower(strings.TrimSpace(container.GetProperty(cont, "subutai.template")) + ":" +
-			strings.TrimSpace(container.GetProperty(cont, "subutai.template.owner")) + ":" +
-			strings.TrimSpace(container.GetProperty(cont, "subutai.template.version")))
-
-		parent := strings.ToLower(strings.TrimSpace(container.GetProperty(cont, "subutai.parent")) + ":" +
-			strings.TrimSpace(container.GetProperty(cont, "subutai.parent.owner")) + ":" +
-			strings.TrimSpace(container.GetProperty(cont, "subutai.parent.version")))
+		self, parent := templateRefs(cont)
 
 		for self != parent || container.IsContainer(cont) {
 			templatesInUse = append(templatesInUse, parent)
 
 			cont = parent
 
-			self = strings.ToLower(strings.TrimSpace(container.GetProperty(cont, "subutai.template")) + ":" +
-				strings.TrimSpace(container.GetProperty(cont, "subutai.template.owner")) + ":" +
-				strings.TrimSpace(container.GetProperty(cont, "subutai.template.version")))
-
-			parent = strings.ToLower(strings.TrimSpace(container.GetProperty(cont, "subutai.parent")) + ":" +
-				strings.TrimSpace(container.GetProperty(cont, "subutai.parent.owner")) + ":" +
-				strings.TrimSpace(container.GetProperty(cont, "subutai.parent.version")))
+			self, parent = templateRefs(cont)
 		}
 
 	}
@@ -243,13 +265,7 @@ func Prune() {
 	for len(gradedTemplates) < len(allTemplates) && iterations < len(allTemplates) {
 		iterations++
 		for _, t := range allTemplates {
-			self := strings.ToLower(strings.TrimSpace(container.GetProperty(t, "subutai.template")) + ":" +
-				strings.TrimSpace(container.GetProperty(t, "subutai.template.owner")) + ":" +
-				strings.TrimSpace(container.GetProperty(t, "subutai.template.version")))
-
-			parent := strings.ToLower(strings.TrimSpace(container.GetProperty(t, "subutai.parent")) + ":" +
-				strings.TrimSpace(container.GetProperty(t, "subutai.parent.owner")) + ":" +
-				strings.TrimSpace(container.GetProperty(t, "subutai.parent.version")))
+			self, parent := templateRefs(t)
 
 			if self == parent {
 				gradedTemplates[self] = gradedTemplate{reference: self, grade: 0}
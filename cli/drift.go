@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/subutai-io/agent/agent/drift"
+	"github.com/subutai-io/agent/log"
+)
+
+// Drift prints container configs and nginx includes which no longer match state recorded in db,
+// e.g. edited by hand or removed. With reconcile flag drifted files are rewritten from db
+//
+// subutai drift [--reconcile]
+func Drift(reconcile bool) {
+	items, err := drift.Detect()
+	log.Check(log.ErrorLevel, "Detecting configuration drift", err)
+
+	if len(items) == 0 {
+		fmt.Println("No drift detected")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "KIND\tNAME\tPROBLEM\tFILE\tITEMS")
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Kind, item.Name, item.Problem, item.File, strings.Join(item.Items, ","))
+	}
+	w.Flush()
+
+	if reconcile {
+		log.Check(log.ErrorLevel, "Reconciling configuration drift", drift.Reconcile(items))
+		log.Info(fmt.Sprintf("Reconciled %d files", len(items)))
+	}
+}
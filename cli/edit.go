@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+// config item of edit container holding reference of template being edited
+const editTemplateItem = "subutai.edit.template"
+
+// TemplateEdit opens installed template for editing: template is cloned to edit container which keeps
+// reference of the template. Changes are applied inside the container and TemplateCommit promotes it
+// to the next version of the template. If command is passed, it is executed inside edit container
+// and the result is committed right away
+//
+// subutai template edit debian-stretch:subutai:0.4.5 [-n {container}] [-e {command}] [-r 0.4.6]
+func TemplateEdit(template, name, command, version string) {
+	ref, err := templ.ParseFullRef(template)
+	checkValid(err)
+	checkState(container.IsTemplate(ref.String()), "Template %s not found", ref.String())
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = ref.Name + "-edit"
+	}
+
+	LxcClone(ref.String(), name, "", "", "", "", "", "")
+	log.Check(log.ErrorLevel, "Saving edited template reference",
+		container.SetContainerConf(name, [][]string{{editTemplateItem, ref.String()}}))
+
+	if strings.TrimSpace(command) == "" {
+		log.Info("Template " + ref.String() + " is open for editing in container " + name +
+			", apply changes and run \"subutai template commit " + name + "\"")
+		return
+	}
+
+	LxcStart(name)
+	_, _, res := container.AttachExecOutput(name, []string{"/bin/bash", "-c", command})
+	if res.Error() != nil || res.ExitCode() != 0 {
+		log.Error("Editing command failed, inspect container " + name +
+			" and commit it or destroy it to discard changes")
+	}
+
+	TemplateCommit(name, version)
+}
+
+// TemplateCommit promotes edit container created by TemplateEdit to the next version of edited template.
+// Version is bumped automatically unless it is passed, new version refers to edited one as its parent
+//
+// subutai template commit debian-stretch-edit [-r 0.4.6]
+func TemplateCommit(name, version string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	edited := container.GetProperty(name, editTemplateItem)
+	checkState(edited != "", "Container %s is not an edit container", name)
+	ref, err := templ.ParseFullRef(edited)
+	checkValid(err)
+
+	version = strings.TrimSpace(version)
+	if version == "" {
+		version = nextVersion(ref)
+	}
+
+	LxcPromote(name, ref.Name, version, container.GetProperty(ref.String(), "subutai.template.size"), ref.Owner)
+
+	//new version keeps provenance of edited one
+	meta, err := templateMetadata(ref.String())
+	log.Check(log.WarnLevel, "Reading metadata of "+ref.String(), err)
+	newRef := templ.Ref{Name: ref.Name, Owner: ref.Owner, Version: version}
+	log.Check(log.WarnLevel, "Saving template metadata", saveTemplateMetadata(newRef.String(), meta))
+}
+
+// nextVersion returns the lowest version above version of template which is not installed yet,
+// bumping patch number, e.g. 0.4.6 for 0.4.5
+func nextVersion(ref templ.Ref) string {
+	parts := strings.Split(ref.Version, ".")
+	patch, err := strconv.Atoi(parts[2])
+	log.Check(log.ErrorLevel, "Parsing template version "+ref.Version, err)
+
+	for {
+		patch++
+		next := templ.Ref{Name: ref.Name, Owner: ref.Owner, Version: parts[0] + "." + parts[1] + "." + strconv.Itoa(patch)}
+		if !container.LxcInstanceExists(next.String()) {
+			return next.Version
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// EstimateExport prints sizes of deltas export of container would send per partition and their total, so
+// transfer and CDN upload can be planned. Current state of container is estimated from temporary snapshot,
+// export hooks may shrink it and template archive is compressed, so actual upload is usually smaller
+//
+// subutai estimate export foo
+func EstimateExport(name string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkState(container.ParentSnapshot(name) == "now",
+		"Container %s is cloned from snapshot %s of its template and can not be exported", name, container.ParentSnapshot(name))
+	parent, err := container.ParentRef(name)
+	log.Check(log.ErrorLevel, "Reading parent template of "+name, err)
+
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: name})
+	defer lock.Release()
+
+	label := "estimate-" + time.Now().Format("20060102150405")
+	log.Check(log.ErrorLevel, "Creating snapshot", fs.CreateSnapshot(name+"@"+label, true))
+
+	//snapshot is removed before failure stops process
+	err = printEstimate(func(partition string) (string, string) {
+		return getSnapshotName(parent.String(), partition, "now"), getSnapshotName(name, partition, label)
+	})
+	log.Check(log.WarnLevel, "Removing snapshot", fs.RemoveDataset(name+"@"+label, true))
+	log.Check(log.ErrorLevel, "Estimating export of "+name, err)
+}
+
+// EstimateBackup prints sizes of deltas snapshot send with the same labels would send per partition and their
+// total. Delta of single label is sent from parent template, of two labels from the first one
+//
+// subutai estimate backup foo monday [tuesday]
+func EstimateBackup(name string, labels ...string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkArgument(len(labels) == 1 || len(labels) == 2, "Invalid number of snapshot labels")
+	for _, label := range labels {
+		checkValid(container.ValidateDatasetComponent(label))
+		for _, partition := range fs.ChildDatasets {
+			snapshot := getSnapshotName(name, partition, label)
+			checkState(fs.DatasetExists(snapshot), "Snapshot %s does not exist", snapshot)
+		}
+	}
+	checkState(len(labels) > 1 || container.ParentSnapshot(name) == "now",
+		"Container %s is cloned from snapshot %s of its template, only deltas between its own snapshots can be sent",
+		name, container.ParentSnapshot(name))
+	parent, err := container.ParentRef(name)
+	log.Check(log.ErrorLevel, "Reading parent template of "+name, err)
+
+	log.Check(log.ErrorLevel, "Estimating backup of "+name, printEstimate(func(partition string) (string, string) {
+		to := getSnapshotName(name, partition, labels[len(labels)-1])
+		if len(labels) == 1 {
+			return getSnapshotName(parent.String(), partition, "now"), to
+		}
+		return getSnapshotName(name, partition, labels[0]), to
+	}))
+}
+
+// printEstimate prints estimated size of stream between snapshots returned by snapshots for every partition,
+// nothing is printed if any stream can not be estimated
+func printEstimate(snapshots func(partition string) (from, to string)) error {
+	sizes := make([]int64, len(fs.ChildDatasets))
+	for i, partition := range fs.ChildDatasets {
+		size, err := fs.EstimateStream(snapshots(partition))
+		if err != nil {
+			return errors.Wrap(err, "Estimating stream of partition "+partition)
+		}
+		sizes[i] = size
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "PARTITION\tSIZE")
+
+	var total int64
+	for i, partition := range fs.ChildDatasets {
+		total += sizes[i]
+		fmt.Fprintf(w, "%s\t%s\n", partition, formatSize(sizes[i]))
+	}
+	fmt.Fprintf(w, "total\t%s\n", formatSize(total))
+
+	return w.Flush()
+}
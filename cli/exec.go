@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// ContainerExec runs command inside running container as user and group resolved inside the container,
+// root by default. Command starts in home directory of user unless working directory is set. With tty,
+// command is attached to the current terminal, e.g. for interactive tools. Interactive command reads standard
+// input, e.g. to load dump piped to agent. Exit code of command is returned
+//
+// subutai exec foo [--user www-data] [--group www-data] [--cwd /var/www] [-i] [--tty] [-e KEY=VALUE] -- php artisan migrate
+// subutai exec foo -i -- mysql shop < dump.sql
+func ContainerExec(name string, command []string, user, group, cwd string, interactive, tty bool, env []string) int {
+	checkArgument(len(command) > 0, "Missing command")
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkState(container.State(name) == container.Running, "Container %s is not running", name)
+
+	options, err := execOptions(name, user, group, cwd, env)
+	checkValid(err)
+	options.Stdout, options.Stderr, options.Tty = os.Stdout, os.Stderr, tty
+	if interactive {
+		options.Stdin = os.Stdin
+	}
+
+	exitCode, err := container.GetRuntime().Exec(name, command, options)
+	if err != nil && exitCode <= 0 {
+		log.Warn("Running command: " + err.Error())
+		return 1
+	}
+
+	return exitCode
+}
+
+// ContainerExecAll runs command in all running containers having labels of selector, or in all running
+// containers except management one if all is set. Up to parallel commands run at once, lines of their output are prefixed with
+// container name. Summary of failed containers is printed and 1 is returned if command failed anywhere
+//
+// subutai exec --all-running -- apt-get -y upgrade
+// subutai exec --label app=web [--parallel 10] -- systemctl reload nginx
+func ContainerExecAll(all bool, selector []string, command []string, user, group, cwd string, env []string, parallel int) int {
+	checkArgument(len(command) > 0, "Missing command")
+	checkArgument(parallel > 0, "Number of parallel commands must be positive")
+	labels, err := parseLabels(selector)
+	checkValid(err)
+	checkArgument(all || len(labels) > 0, "Specify labels of containers or run command in all running containers")
+
+	var names []string
+	for _, name := range container.Containers() {
+		//management container is run by host, commands reach it only by its name
+		if name == container.Management || container.State(name) != container.Running {
+			continue
+		}
+		if len(labels) > 0 {
+			cont, err := db.FindContainerByName(name)
+			if log.Check(log.WarnLevel, "Reading metadata of container "+name, err) || cont == nil ||
+				!matchLabels(cont.Labels, labels) {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checkState(len(names) > 0, "No running containers match")
+
+	//output of concurrent commands is written line by line, so lines of different containers do not mix
+	var output, resultsLock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	results := make(map[string]int)
+
+	for _, name := range names {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			stdout := &prefixWriter{prefix: name + ": ", out: os.Stdout, lock: &output}
+			stderr := &prefixWriter{prefix: name + ": ", out: os.Stderr, lock: &output}
+
+			exitCode := 1
+			options, err := execOptions(name, user, group, cwd, env)
+			if err == nil {
+				options.Stdout, options.Stderr = stdout, stderr
+				exitCode, err = container.GetRuntime().Exec(name, command, options)
+			}
+			if err != nil {
+				fmt.Fprintln(stderr, err.Error())
+				if exitCode <= 0 {
+					exitCode = 1
+				}
+			}
+			stdout.Flush()
+			stderr.Flush()
+
+			resultsLock.Lock()
+			results[name] = exitCode
+			resultsLock.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, name := range names {
+		if results[name] != 0 {
+			failed = append(failed, fmt.Sprintf("%s (%d)", name, results[name]))
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "Command failed in %d of %d containers: %s\n", len(failed), len(names),
+			strings.Join(failed, ", "))
+		return 1
+	}
+
+	return 0
+}
+
+// execOptions returns options running command in container as user and group resolved inside the container.
+// Command starts in home directory of user unless working directory is set
+func execOptions(name, user, group, cwd string, env []string) (container.ExecOptions, error) {
+	account, err := container.LookupUser(name, user, group)
+	if err != nil {
+		return container.ExecOptions{}, err
+	}
+
+	if cwd == "" {
+		cwd = account.Home
+	}
+
+	//environment of caller is not passed, only the usual login variables of user
+	vars := append([]string{"HOME=" + account.Home, "USER=" + account.Name, "LOGNAME=" + account.Name,
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}, env...)
+
+	return container.ExecOptions{
+		UID:       account.UID,
+		GID:       account.GID,
+		Cwd:       cwd,
+		Env:       vars,
+		ClearEnv:  true,
+		EnvToKeep: []string{"TERM", "LS_COLORS"},
+	}, nil
+}
+
+// prefixWriter writes complete lines prefixed with prefix to out holding lock, incomplete line is kept
+// until it is completed or flushed
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	lock   *sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.writeLine(w.buf.Next(i + 1))
+	}
+}
+
+// Flush writes incomplete line left in buffer
+func (w *prefixWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.writeLine(append(w.buf.Bytes(), '\n'))
+		w.buf.Reset()
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+}
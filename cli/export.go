@@ -3,7 +3,6 @@ package cli
 import (
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"runtime"
 
 	"encoding/json"
@@ -35,20 +34,25 @@ var (
 // widely available for others to use.
 // Configuration values for template metadata parameters can be overridden on export, like the recommended container size when the template is cloned using `-s` option.
 // The template's version can also specified on export so the import command can use it to request specific versions.
+// Local export does not contact CDN at all, template owner is taken from `-o` option or templateOwner agent config parameter.
+// Live export does not stop the container, instead it atomically snapshots all partitions and exports from a temporary clone.
+// Upload is limited to bandwidth limit per second, e.g. 50M, or to bwLimit agent config parameter.
+// Description, license, homepage and tags of template are carried in its manifest bundled with archive, so
+// provenance of template is kept when it is shared across organizations.
 
-func LxcExport(name, newname, version, prefsize, token string, local bool) {
+func LxcExport(name, newname, version, prefsize, token, owner string, local, live bool, bwLimit string, meta TemplateMetadata) {
 	//check new template name
 	if newname != "" {
-		util.VerifyLxcName(newname)
+		checkValid(container.ValidateNewName(newname))
 	}
 
 	if !container.IsContainer(name) {
 		log.Error("Container " + name + " not found")
 	}
 
-	if token == "" {
-		log.Error("Missing CDN token")
-	}
+	//container must not be destroyed or restored while its partitions are exported
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: name})
+	defer lock.Release()
 
 	version = strings.TrimSpace(version)
 
@@ -56,15 +60,33 @@ func LxcExport(name, newname, version, prefsize, token string, local bool) {
 		log.Error("Version must be in form X.Y.Z")
 	}
 
-	owner := getOwner(token)
+	checkValid(meta.validate())
 
-	parent := container.GetProperty(name, "subutai.parent")
-	parentOwner := container.GetProperty(name, "subutai.parent.owner")
-	parentVersion := container.GetProperty(name, "subutai.parent.version")
-	parentRef := strings.Join([]string{parent, parentOwner, parentVersion}, ":")
+	if local {
+		owner = strings.TrimSpace(owner)
+		if owner == "" {
+			owner = config.Agent.TemplateOwner
+		}
+		if owner == "" {
+			log.Error("Missing template owner, specify it with -o option or templateOwner in agent config")
+		}
+	} else {
+		if token == "" {
+			log.Error("Missing CDN token")
+		}
+		owner = getOwner(token)
+	}
+	rate, err := fs.TransferRate(bwLimit)
+	checkValid(err)
+
+	parent, err := container.ParentRef(name)
+	log.Check(log.ErrorLevel, "Reading parent template of "+name, err)
+	parentRef := parent.String()
+	checkState(container.ParentSnapshot(name) == "now",
+		"Container %s is cloned from snapshot %s of its template and can not be exported", name, container.ParentSnapshot(name))
 
 	if version == "" {
-		version = parentVersion
+		version = parent.Version
 	}
 
 	//check template reference uniqueness
@@ -77,14 +99,41 @@ func LxcExport(name, newname, version, prefsize, token string, local bool) {
 		theName = name
 	}
 
-	if templateExists(theName, theOwner, theVersion) {
+	if !local && templateExists(theName, theOwner, theVersion) {
 		log.Error(fmt.Sprintf("Template %s@%s:%s already exists on CDN", theName, theOwner, theVersion))
 	}
 
-	wasRunning := false
-	if container.State(name) == container.Running {
-		LxcStop(name)
-		wasRunning = true
+	//deltas of partitions are written to cache and packed there, they are not larger than partitions
+	used, err := fs.DatasetDiskUsage(name)
+	log.Check(log.WarnLevel, "Getting disk usage of "+name, err)
+	preflight("export of "+name, poolCheck(), lxcCheck(), cacheSpaceCheck(2*int64(used)))
+
+	wasRunning := container.State(name) == container.Running
+
+	//dataset to export partitions from
+	source := name
+	hooks := newExportHook(name, theName)
+
+	if live {
+		//hooks inside container would modify the running instance
+		if len(hooks.hookScripts(HookContainer)) > 0 {
+			log.Warn("Skipping container export hooks in live mode")
+		}
+
+		source = createLiveClone(name)
+		hooks.dir = path.Join(config.Agent.LxcPrefix, source)
+	} else {
+		//sanitize container from inside, it has to be running for that
+		if len(hooks.hookScripts(HookContainer)) > 0 {
+			if !wasRunning {
+				LxcStart(name)
+			}
+			log.Check(log.ErrorLevel, "Running container export hooks", hooks.run(HookContainer))
+		}
+
+		if container.State(name) == container.Running {
+			LxcStop(name)
+		}
 	}
 
 	//preferred size
@@ -95,9 +144,8 @@ func LxcExport(name, newname, version, prefsize, token string, local bool) {
 		}
 	}
 
-	//cleanup files
-	cleanupFS(path.Join(config.Agent.LxcPrefix, name, "/var/log"), 0775)
-	cleanupFS(path.Join(config.Agent.LxcPrefix, name, "/var/cache"), 0775)
+	//sanitize container filesystem, e.g. cleanup logs and caches, reset machine-id, purge ssh host keys
+	log.Check(log.ErrorLevel, "Running pre-export hooks", hooks.run(HookPre))
 
 	var dst string
 	if newname != "" {
@@ -113,50 +161,29 @@ func LxcExport(name, newname, version, prefsize, token string, local bool) {
 
 	for _, vol := range fs.ChildDatasets {
 		//remove old snapshot if any
-		if fs.DatasetExists(name + "/" + vol + "@now") {
-			fs.RemoveDataset(name+"/"+vol+"@now", false)
+		if fs.DatasetExists(source + "/" + vol + "@now") {
+			fs.RemoveDataset(source+"/"+vol+"@now", false)
 		}
 		// snapshot each partition
-		snapshot := name + "/" + vol + "@now"
+		snapshot := source + "/" + vol + "@now"
 		err := fs.CreateSnapshot(snapshot, false)
 		log.Check(log.ErrorLevel, "Creating snapshot "+snapshot, err)
 
 		// send incremental delta between parent and child to delta file
-		err = fs.SendStream(parentRef+"/"+vol+"@now", name+"/"+vol+"@now", dst+"/deltas/"+vol+".delta")
+		err = fs.SendStream(parentRef+"/"+vol+"@now", source+"/"+vol+"@now", dst+"/deltas/"+vol+".delta")
 		log.Check(log.ErrorLevel, "Sending stream for partition "+vol, err)
 	}
 
+	if live {
+		removeLiveClone(name, source)
+	}
+
 	//copy config files
 	src := path.Join(config.Agent.LxcPrefix, name)
 	log.Check(log.ErrorLevel, "Copying config file", fs.Copy(src+"/config", dst+"/config"))
 
 	//update template config
-	var templateConf [][]string
-	if common.GetMajorVersion() < 3 {
-		templateConf = [][]string{
-			{"subutai.template.owner", owner},
-			{"subutai.template.version", version},
-			{"subutai.template.size", pSize},
-			{"lxc.network.ipv4.gateway"},
-			{"lxc.network.ipv4.address"},
-			{"lxc.network.veth.pair"},
-			{"lxc.network.hwaddr"},
-			{"lxc.network.mtu"},
-			{"#vlan_id"},
-		}
-	} else {
-		templateConf = [][]string{
-			{"subutai.template.owner", owner},
-			{"subutai.template.version", version},
-			{"subutai.template.size", pSize},
-			{"lxc.net.0.ipv4.gateway"},
-			{"lxc.net.0.ipv4.address"},
-			{"lxc.net.0.veth.pair"},
-			{"lxc.net.0.hwaddr"},
-			{"lxc.net.0.mtu"},
-			{"#vlan_id"},
-		}
-	}
+	templateConf := templateConfig(owner, version, pSize)
 
 	if newname != "" {
 		templateConf = append(templateConf, []string{"subutai.template", newname})
@@ -176,17 +203,28 @@ func LxcExport(name, newname, version, prefsize, token string, local bool) {
 
 	updateTemplateConfig(dst+"/config", templateConf)
 
-	// check: write package list to packages
+	//package list is collected from inside container, so it has to be running
 	if container.State(name) != container.Running {
 		LxcStart(name)
 	}
+	if err := writePackages(name, dst); err != nil {
+		log.Warn("Template is exported without package list: " + err.Error())
+	}
+
+	//bundle template manifest
+	manifest := Manifest{Name: theName, Owner: owner, Version: version, Parent: parentRef,
+		ParentChain: getParentChain(parentRef), PrefSize: pSize, TemplateMetadata: meta}
+	log.Check(log.ErrorLevel, "Writing template manifest", writeManifest(dst, manifest))
 
 	//archive template contents
-	templateArchive := dst + ".tar.gz"
+	templateArchive := dst + fs.ArchiveExtension()
 	fs.Compress(dst, templateArchive)
 	log.Check(log.WarnLevel, "Removing temporary directory", os.RemoveAll(dst))
 	log.Info(name + " exported to " + templateArchive)
 
+	hooks.archive = templateArchive
+	log.Check(log.ErrorLevel, "Running post-export hooks", hooks.run(HookPost))
+
 	//generate template metadata
 	var templateInfo = Template{}
 	if newname != "" {
@@ -205,10 +243,11 @@ func LxcExport(name, newname, version, prefsize, token string, local bool) {
 	templateInfo.Size = fSize
 	templateInfo.Parent = parentRef
 	templateInfo.PrefSize = pSize
+	templateInfo.TemplateMetadata = meta
 
 	//upload to CDN
 	if !local {
-		if err := upload(templateArchive, token); err != nil {
+		if err := upload(templateArchive, token, rate); err != nil {
 			log.Error("Failed to upload template: " + err.Error())
 		} else {
 			//IMPORTANT: used by Console
@@ -227,6 +266,38 @@ func LxcExport(name, newname, version, prefsize, token string, local bool) {
 
 }
 
+const liveExportLabel = "live_export"
+
+// createLiveClone atomically snapshots all partitions of container and clones them to a temporary dataset.
+// Clone is writable so that pre-export hooks can sanitize it, returns name of the temporary dataset
+func createLiveClone(name string) string {
+	clone := name + "_" + liveExportLabel
+
+	//remove leftovers of previous failed export if any
+	removeLiveClone(name, clone)
+
+	snapshot := name + "@" + liveExportLabel
+	log.Check(log.ErrorLevel, "Creating snapshot "+snapshot, fs.CreateSnapshot(snapshot, true))
+
+	log.Check(log.ErrorLevel, "Creating dataset "+clone, fs.CreateDataset(clone))
+	for _, vol := range fs.ChildDatasets {
+		log.Check(log.ErrorLevel, "Cloning partition "+vol,
+			fs.CloneSnapshot(name+"/"+vol+"@"+liveExportLabel, clone+"/"+vol))
+	}
+
+	return clone
+}
+
+// removeLiveClone removes temporary dataset and snapshots created for live export
+func removeLiveClone(name, clone string) {
+	if fs.DatasetExists(clone) {
+		log.Check(log.WarnLevel, "Removing temporary clone", fs.RemoveDataset(clone, true))
+	}
+	if fs.DatasetExists(name + "@" + liveExportLabel) {
+		log.Check(log.WarnLevel, "Removing temporary snapshot", fs.RemoveDataset(name+"@"+liveExportLabel, true))
+	}
+}
+
 func templateExists(name, owner, version string) bool {
 	theUrl := config.CdnUrl + "/template?name=" + name + "&owner=" + owner + "&version=" + version
 
@@ -268,7 +339,7 @@ func getOwner(token string) string {
 
 }
 
-func upload(template, token string) error {
+func upload(template, token string, rate int64) error {
 
 	file, err := os.Open(template)
 	if log.Check(log.DebugLevel, "Opening template for upload", err) {
@@ -306,7 +377,7 @@ func upload(template, token string) error {
 			w.CloseWithError(err)
 		}
 		part = io.MultiWriter(part, bar)
-		if _, err = io.Copy(part, file); err != nil {
+		if _, err = io.Copy(part, fs.ThrottleReader(file, rate)); err != nil {
 			w.CloseWithError(err)
 		}
 		if err = mpw.Close(); err != nil {
@@ -334,23 +405,40 @@ func upload(template, token string) error {
 	return nil
 }
 
-func updateTemplateConfig(path string, params [][]string) error {
-	return container.CreateContainerConf(path, params)
-}
+// templateConfig returns config items turning container config into config of template with given metadata:
+// template metadata is set and network settings specific to container are removed
+func templateConfig(owner, version, size string) [][]string {
+	if common.GetMajorVersion() < 3 {
+		return [][]string{
+			{"subutai.template.owner", owner},
+			{"subutai.template.version", version},
+			{"subutai.template.size", size},
+			{"lxc.network.ipv4.gateway"},
+			{"lxc.network.ipv4.address"},
+			{"lxc.network.ipv6.gateway"},
+			{"lxc.network.ipv6.address"},
+			{"lxc.network.veth.pair"},
+			{"lxc.network.hwaddr"},
+			{"lxc.network.mtu"},
+			{"#vlan_id"},
+		}
+	}
 
-// clearFile writes an empty byte array to specified file
-func clearFile(path string, f os.FileInfo, ignore error) error {
-	if !f.IsDir() {
-		ioutil.WriteFile(path, []byte{}, 0775)
+	return [][]string{
+		{"subutai.template.owner", owner},
+		{"subutai.template.version", version},
+		{"subutai.template.size", size},
+		{"lxc.net.0.ipv4.gateway"},
+		{"lxc.net.0.ipv4.address"},
+		{"lxc.net.0.ipv6.gateway"},
+		{"lxc.net.0.ipv6.address"},
+		{"lxc.net.0.veth.pair"},
+		{"lxc.net.0.hwaddr"},
+		{"lxc.net.0.mtu"},
+		{"#vlan_id"},
 	}
-	return nil
 }
 
-// cleanupFS removes files in specified path
-func cleanupFS(path string, perm os.FileMode) {
-	if perm == 0000 {
-		os.RemoveAll(path)
-	} else {
-		filepath.Walk(path, clearFile)
-	}
+func updateTemplateConfig(path string, params [][]string) error {
+	return container.CreateContainerConf(path, params)
 }
@@ -0,0 +1,339 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/cluster"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/log"
+)
+
+//interval between checks of primary host by standby
+const standbyCheckInterval = time.Second * 10
+
+//management runs only on host holding lease of this lock, granted by majority of cluster members. Primary cut
+//off from majority stops management before its lease expires, and standby is promoted only once it gets the
+//lease, so there are never two active managements, and only one standby is promoted if several of them lost primary
+const (
+	primaryLock     = "management-primary"
+	primaryLeaseTtl = time.Minute
+)
+
+// MonitorStandby replicates management container of primary host set in [ha] section of config and,
+// if failover timeout is set, promotes this host when primary stays unreachable longer than the timeout.
+// Replication and promotion run as separate commands, so they can not overlap with the same commands run by user
+func MonitorStandby() {
+	if config.HA.Primary == "" {
+		return
+	}
+	if !cluster.Enabled() {
+		log.Warn("Management standby requires cluster mode, enable it in [cluster] section of agent config")
+		return
+	}
+
+	interval := time.Duration(config.HA.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute * 5
+	}
+	timeout := time.Duration(config.HA.FailoverTimeout) * time.Second
+
+	var replicated, unreachable time.Time
+	for {
+		done := false
+		common.RunNRecover(func() {
+			replica, err := db.FindManagementReplica(container.Management)
+			if log.Check(log.WarnLevel, "Reading management replica", err) {
+				return
+			}
+			if replica != nil && replica.Promoted {
+				done = true
+				return
+			}
+
+			if err = cluster.Ping(config.HA.Primary); err != nil {
+				if unreachable.IsZero() {
+					unreachable = time.Now()
+					log.Warn("Primary host " + config.HA.Primary + " is unreachable: " + err.Error())
+				}
+				if timeout > 0 && replica != nil && time.Since(unreachable) >= timeout {
+					log.Warn("Primary host " + config.HA.Primary + " is unreachable for " + timeout.String() +
+						", promoting management standby")
+					log.Check(log.WarnLevel, "Promoting management standby", exec.Exec("subutai", "management", "promote"))
+				}
+				return
+			}
+			if !unreachable.IsZero() {
+				log.Info("Primary host " + config.HA.Primary + " is reachable again")
+				unreachable = time.Time{}
+			}
+
+			if time.Since(replicated) >= interval {
+				log.Check(log.WarnLevel, "Replicating management of "+config.HA.Primary,
+					exec.Exec("subutai", "management", "replicate"))
+				replicated = time.Now()
+			}
+		})
+		if done {
+			return
+		}
+
+		time.Sleep(standbyCheckInterval)
+	}
+}
+
+// ManagementReplicate pulls the latest snapshot of management container from primary host,
+// it is run by daemon of standby host periodically
+//
+// subutai management replicate
+func ManagementReplicate() {
+	checkState(config.HA.Primary != "", "Host is not management standby, set primary in [ha] section of agent config")
+	checkState(cluster.Enabled(), "Management standby requires cluster mode, enable it in [cluster] section of agent config")
+
+	lock, err := common.LockFile(container.Management, "management")
+	checkState(err == nil, "Another operation on management replica is running")
+	defer lock.Unlock()
+
+	log.Check(log.ErrorLevel, "Replicating management of "+config.HA.Primary, replicateManagement(config.HA.Primary))
+}
+
+func replicateManagement(primary string) error {
+	replica, err := db.FindManagementReplica(container.Management)
+	if err != nil {
+		return err
+	}
+	if replica != nil && replica.Promoted {
+		return errors.New("Management replica is promoted, standby does not replicate it anymore")
+	}
+	if replica == nil && container.IsContainer(container.Management) {
+		return errors.New("Management container exists on standby host, destroy it to replicate management of primary")
+	}
+
+	base := ""
+	if replica != nil && container.IsContainer(container.Management) {
+		base = replica.Label
+	}
+
+	snapshot, err := cluster.TakeReplica(primary, base)
+	if err != nil {
+		return errors.Wrap(err, "Taking snapshot on primary")
+	}
+
+	if base == "" {
+		//the first stream is incremental to snapshot of management template
+		if !container.IsTemplate(snapshot.Parent) {
+			log.Info("Importing management template " + snapshot.Parent)
+			if err = exec.Exec("subutai", "import", snapshot.Parent); err != nil {
+				return errors.Wrap(err, "Importing management template")
+			}
+		}
+		if fs.DatasetExists(container.Management) {
+			if err = fs.RemoveDataset(container.Management, true); err != nil {
+				return errors.Wrap(err, "Removing incomplete replica")
+			}
+		}
+		if err = fs.CreateDataset(container.Management); err != nil {
+			return errors.Wrap(err, "Creating management dataset")
+		}
+	}
+
+	for _, partition := range fs.ChildDatasets {
+		dataset := path.Join(container.Management, partition)
+		err = cluster.ReceiveReplica(primary, partition, base, snapshot.Label, func(stream io.Reader) error {
+			return fs.ReceiveStreamFrom(dataset, stream, true)
+		})
+		if err != nil {
+			discardReplica(base)
+			return errors.Wrap(err, "Receiving partition "+partition)
+		}
+	}
+
+	if replica == nil {
+		replica = &db.ManagementReplica{Container: container.Management}
+	}
+	replica.Primary, replica.Label, replica.Updated = primary, snapshot.Label, time.Now()
+	if err = db.SaveManagementReplica(replica); err != nil {
+		return err
+	}
+
+	dir := path.Join(config.Agent.LxcPrefix, container.Management)
+	if err = ioutil.WriteFile(path.Join(dir, "config"), []byte(snapshot.Config), 0644); err != nil {
+		return errors.Wrap(err, "Saving container config")
+	}
+	if snapshot.PublicKey != "" {
+		if err = ioutil.WriteFile(path.Join(dir, "public.pub"), []byte(snapshot.PublicKey), 0644); err != nil {
+			return errors.Wrap(err, "Saving management public key")
+		}
+	}
+
+	//only the latest snapshot is needed as base of the next stream
+	labels, err := cluster.ReplicaLabels()
+	if err != nil {
+		return err
+	}
+	for _, label := range labels {
+		if label != snapshot.Label {
+			log.Check(log.WarnLevel, "Removing snapshot "+label, fs.RemoveDataset(container.Management+"@"+label, true))
+		}
+	}
+
+	return nil
+}
+
+// discardReplica rolls partitions back to snapshot received last, so that the next stream starts from it.
+// Incomplete first replica is removed
+func discardReplica(base string) {
+	if base == "" {
+		log.Check(log.WarnLevel, "Removing incomplete replica", fs.RemoveDataset(container.Management, true))
+		return
+	}
+
+	for _, partition := range fs.ChildDatasets {
+		snapshot := path.Join(container.Management, partition) + "@" + base
+		if fs.DatasetExists(snapshot) {
+			log.Check(log.WarnLevel, "Rolling back to snapshot "+snapshot, fs.RollbackToSnapshot(snapshot, true))
+		}
+	}
+}
+
+// ManagementPromote starts replicated management container on this standby host instead of primary one:
+// management is started, registered in db and exposed through host proxies, then promote hook set in [ha] section
+// of config is run, e.g. to re-point DNS. Unless forced, standby is promoted only if primary is unreachable.
+// Standby is promoted only with lease of primary lock, which primary holds while it runs management
+//
+// subutai management promote [--force]
+func ManagementPromote(force bool) {
+	var err error
+	var lock lockfile.Lockfile
+	for lock, err = common.LockFile(container.Management, "management"); err != nil; lock, err = common.LockFile(container.Management, "management") {
+		time.Sleep(time.Second * 1)
+	}
+	defer lock.Unlock()
+
+	replica, err := db.FindManagementReplica(container.Management)
+	log.Check(log.ErrorLevel, "Reading management replica", err)
+	checkState(replica != nil && container.IsContainer(container.Management), "No replica of management received from primary")
+	checkState(!replica.Promoted, "Management replica is promoted already")
+
+	if !force {
+		checkState(cluster.Ping(replica.Primary) != nil,
+			"Primary host %s is reachable, use --force to promote standby anyway", replica.Primary)
+	}
+
+	//daemon of promoted host takes lease over once management runs
+	failover, err := cluster.Acquire(primaryLock, primaryLeaseTtl)
+	log.Check(log.ErrorLevel, "Acquiring primary lock, primary host may still run management", err)
+	defer failover.Release()
+
+	//replication stops once replica is promoted
+	replica.Promoted = true
+	log.Check(log.ErrorLevel, "Saving management replica", db.SaveManagementReplica(replica))
+
+	log.Info("Promoting management replica " + replica.Label + " of " + replica.Primary)
+	keyExists := fs.FileExists(path.Join(config.Agent.LxcPrefix, container.Management, "public.pub"))
+	setupManagement(!keyExists)
+
+	if config.HA.PromoteHook != "" {
+		_, err = exec.Run(context.Background(), exec.Options{Env: []string{"SUBUTAI_HA_IP=" + net.GetIp()},
+			Stdout: os.Stdout, Stderr: os.Stderr}, "/bin/sh", "-c", config.HA.PromoteHook)
+		log.Check(log.WarnLevel, "Running promote hook", err)
+	}
+
+	//credentials stay with primary, they are kept in replicated container anyway
+	log.Info("Management replica is promoted, log in with credentials set on primary host " + replica.Primary)
+}
+
+// MonitorPrimary holds lease of primary lock while management runs on this host and is replicated to standby or
+// promoted from replica. Management is stopped if lease can not be renewed, e.g. when host is cut off from
+// majority of cluster members, before lease expires and standby may be promoted. It is started again once lease
+// is granted
+func MonitorPrimary() {
+	if !cluster.Enabled() {
+		return
+	}
+
+	var lock *cluster.Lock
+	fenced := false
+	for {
+		common.RunNRecover(func() {
+			lock, fenced = holdPrimary(lock, fenced)
+		})
+		time.Sleep(standbyCheckInterval)
+	}
+}
+
+func holdPrimary(lock *cluster.Lock, fenced bool) (*cluster.Lock, bool) {
+	running := container.IsContainer(container.Management) && container.State(container.Management) == container.Running
+	if !isPrimary() || (!running && !fenced) {
+		if lock != nil {
+			lock.Release()
+		}
+		return nil, false
+	}
+
+	if lock == nil {
+		var err error
+		if lock, err = cluster.Acquire(primaryLock, primaryLeaseTtl); err != nil {
+			if running {
+				fence("Primary lock is not granted: " + err.Error())
+			}
+			return nil, true
+		}
+		if fenced {
+			log.Info("Primary lock is granted, starting management")
+			log.Check(log.WarnLevel, "Starting management", exec.Exec("subutai", "start", container.Management))
+		}
+		return lock, false
+	}
+
+	//management is stopped while lease is still valid, members grant it to standby only after it expires
+	if err := lock.Renew(); err != nil && time.Until(lock.Expires()) < 2*standbyCheckInterval {
+		fence("Primary lock is lost: " + err.Error())
+		lock.Release()
+		return nil, true
+	}
+	return lock, false
+}
+
+// isPrimary tells if management of this host is replicated to standby or promoted from replica
+func isPrimary() bool {
+	replica, err := db.FindManagementReplica(container.Management)
+	if err != nil {
+		return false
+	}
+	if replica != nil {
+		return replica.Promoted
+	}
+	labels, err := cluster.ReplicaLabels()
+	return err == nil && len(labels) > 0 && config.HA.Primary == ""
+}
+
+func fence(reason string) {
+	log.Warn(reason + ", stopping management so that it does not run on two hosts")
+	log.Check(log.WarnLevel, "Stopping management", exec.Exec("subutai", "stop", container.Management))
+}
+
+// ManagementStandby prints state of management replica received by this standby host
+//
+// subutai management standby
+func ManagementStandby() {
+	replica, err := db.FindManagementReplica(container.Management)
+	log.Check(log.ErrorLevel, "Reading management replica", err)
+	checkState(replica != nil, "No replica of management received from primary")
+
+	fmt.Printf("primary: %s\nsnapshot: %s\nreceived: %s\npromoted: %t\n",
+		replica.Primary, replica.Label, replica.Updated.Format(time.RFC3339), replica.Promoted)
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// Export hook phases.
+// Scripts are looked up in config.Agent.ExportHooks/<phase> for all templates
+// and in config.Agent.ExportHooks/templates/<template>/<phase> for a particular template
+const (
+	// scripts run inside running container before it is stopped for export
+	HookContainer = "container"
+	// scripts run on host against stopped container filesystem before snapshotting
+	HookPre = "pre"
+	// scripts run on host after template archive is created
+	HookPost = "post"
+)
+
+type exportHook struct {
+	container string
+	template  string
+	//directory with container partitions mounted, differs from container dir for live export
+	dir     string
+	archive string
+}
+
+func newExportHook(container, template string) exportHook {
+	return exportHook{container: container, template: template, dir: path.Join(config.Agent.LxcPrefix, container)}
+}
+
+// hookScripts returns host-wide scripts followed by template specific ones, each set ordered by file name
+func (h exportHook) hookScripts(phase string) []string {
+	var scripts []string
+	for _, dir := range []string{
+		path.Join(config.Agent.ExportHooks, phase),
+		path.Join(config.Agent.ExportHooks, "templates", h.template, phase),
+	} {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warn("Reading hooks dir " + dir + ": " + err.Error())
+			}
+			continue
+		}
+
+		var names []string
+		for _, f := range files {
+			if f.Mode().IsRegular() {
+				names = append(names, f.Name())
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			scripts = append(scripts, path.Join(dir, name))
+		}
+	}
+
+	return scripts
+}
+
+func (h exportHook) env(phase string) map[string]string {
+	return map[string]string{
+		"SUBUTAI_HOOK_PHASE":    phase,
+		"SUBUTAI_CONTAINER":     h.container,
+		"SUBUTAI_TEMPLATE":      h.template,
+		"SUBUTAI_CONTAINER_DIR": h.dir,
+		"SUBUTAI_ARCHIVE":       h.archive,
+	}
+}
+
+// run executes scripts of the phase, stopping at the first failed one
+func (h exportHook) run(phase string) error {
+	for _, script := range h.hookScripts(phase) {
+		log.Info("Running " + phase + " export hook " + path.Base(script))
+
+		if phase == HookContainer {
+			content, err := ioutil.ReadFile(script)
+			if err != nil {
+				return err
+			}
+
+			var env []string
+			for k, v := range h.env(phase) {
+				env = append(env, k+"="+v)
+			}
+
+			_, errOut, res := container.AttachExecOutput(h.container, []string{"/bin/sh", "-c", string(content)}, env)
+			if res.Error() != nil {
+				return errors.Errorf("Hook %s failed: %s", script, res.Error().Error())
+			}
+			if res.ExitCode() != 0 {
+				return errors.Errorf("Hook %s failed with exit code %d: %s", script, res.ExitCode(), errOut)
+			}
+			continue
+		}
+
+		if out, err := exec.ExecuteOutput(script, h.env(phase)); err != nil {
+			return errors.Errorf("Hook %s failed: %s", script, out)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// hostCheck is outcome of checking prerequisite of resource host, problem is empty if it is met
+type hostCheck struct {
+	Name    string
+	Problem string
+	Fix     string
+}
+
+// HostInit prepares fresh resource host in one pass: prerequisites are checked, root dataset and directories
+// of agent are created and, if Console address is given, it is saved in config and host is registered with
+// Console. Console secret is read from file or stdin, so it never appears in command line. Nothing is changed
+// if any prerequisite is not met. Steps are safe to repeat
+//
+// subutai host init [--check]
+// subutai host init --console 10.0.0.5 --secret-file {file}
+// cat {file} | subutai host init --console 10.0.0.5 --secret-file -
+func HostInit(console, secretFile string, checkOnly bool) {
+	checks := hostPrerequisites()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "CHECK\tRESULT\tFIX")
+	failed := 0
+	for _, c := range checks {
+		result, fix := "ok", ""
+		if c.Problem != "" {
+			result, fix = c.Problem, c.Fix
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, result, fix)
+	}
+	w.Flush()
+
+	checkState(failed == 0, "%d of %d prerequisites are not met, fix them and run init again", failed, len(checks))
+	if checkOnly {
+		log.Info("Host meets all prerequisites")
+		return
+	}
+
+	//root dataset is mounted where agent looks for containers
+	if !fs.DatasetExists("") {
+		out, err := exec.Execute("zfs", "create", "-p", "-o", "mountpoint="+config.Agent.LxcPrefix, config.Agent.Dataset)
+		log.Check(log.ErrorLevel, "Creating root dataset "+config.Agent.Dataset+" "+out, err)
+		log.Info("Root dataset " + config.Agent.Dataset + " is created")
+	}
+
+	for _, dir := range []string{config.Agent.LxcPrefix, config.Agent.DataPrefix, config.Agent.CacheDir,
+		config.Agent.ExportHooks, path.Dir(config.ConfPath)} {
+		log.Check(log.ErrorLevel, "Creating directory "+dir, os.MkdirAll(dir, 0755))
+	}
+
+	if !fs.FileExists(path.Join(config.CDN.IpfsPath, "config")) {
+		out, err := exec.ExecuteOutput("ipfs", map[string]string{"IPFS_PATH": config.CDN.IpfsPath}, "init")
+		log.Check(log.ErrorLevel, "Initializing IPFS repository "+config.CDN.IpfsPath+" "+out, err)
+		log.Info("IPFS repository " + config.CDN.IpfsPath + " is initialized")
+	}
+
+	if console == "" {
+		log.Info("Host is initialized, run init with --console to register it with Console")
+		return
+	}
+
+	secret := ""
+	if secretFile != "" {
+		var err error
+		secret, err = readSecret(secretFile)
+		log.Check(log.ErrorLevel, "Reading Console secret", err)
+		checkArgument(secret != "", "Console secret in %s is empty", secretFile)
+	}
+
+	log.Check(log.ErrorLevel, "Saving Console address", config.SaveOption(config.ConfPath, "management", "host", console))
+	if secret != "" {
+		log.Check(log.ErrorLevel, "Saving Console secret", config.SaveOption(config.ConfPath, "management", "secret", secret))
+		config.Management.Secret = secret
+	}
+	config.Management.Host = console
+	config.ManagementIP = console
+
+	log.Check(log.ErrorLevel, "Importing Console key", consol.ImportPubKey())
+	log.Check(log.ErrorLevel, "Sending registration request to Console", consol.Register())
+	log.Check(log.WarnLevel, "Saving Console address", db.SaveDiscoveredIp(console))
+
+	log.Info("Registration request is sent to Console " + console + ", approve host there and restart agent")
+}
+
+// readSecret reads secret from file, or from stdin if file is "-"
+func readSecret(file string) (string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+
+	return strings.TrimSpace(string(data)), err
+}
+
+// hostPrerequisites checks software and kernel features resource host needs
+func hostPrerequisites() []hostCheck {
+	var checks []hostCheck
+	check := func(name, problem, fix string) {
+		checks = append(checks, hostCheck{Name: name, Problem: problem, Fix: fix})
+	}
+
+	//root dataset is created by init, so only problems of pool itself are reported
+	if pool := poolCheck(); strings.HasPrefix(pool.Problem, "root dataset "+config.Agent.Dataset+" not found") {
+		check(pool.Name, "", "")
+	} else {
+		checks = append(checks, pool)
+	}
+	checks = append(checks, lxcCheck(), toolCheck("uidmapshift", "uidmapshift", "install subutai uidmapshift"))
+
+	kernel := []struct{ name, file, fix string }{
+		{"user namespaces", "/proc/self/ns/user", "boot kernel built with CONFIG_USER_NS"},
+		{"cgroups", "/sys/fs/cgroup", "mount cgroup filesystem, e.g. install cgroupfs-mount"},
+		{"zfs module", "/sys/module/zfs", "load it with modprobe zfs"},
+		{"openvswitch module", "/sys/module/openvswitch", "load it with modprobe openvswitch"},
+	}
+	for _, k := range kernel {
+		if fs.FileExists(k.file) {
+			check(k.name, "", "")
+		} else {
+			check(k.name, k.file+" not found", k.fix)
+		}
+	}
+
+	checks = append(checks, toolCheck("openvswitch", "ovs-vsctl", "install openvswitch-switch"),
+		toolCheck("nginx", "nginx", "install nginx"), toolCheck("ipfs", "ipfs", "install go-ipfs"))
+
+	return checks
+}
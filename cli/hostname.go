@@ -1,52 +1,23 @@
 package cli
 
 import (
-	"bufio"
-	"io/ioutil"
-	"os"
-	"os/exec"
-	"strings"
-
-	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
 	"github.com/subutai-io/agent/log"
-	"path"
 )
 
-// LxcHostname command changes container configs to apply a new name for the container. Used for internal SS purposes.
+// LxcHostname command changes hostname of container: /etc/hostname, uts name of container config and
+// /etc/hosts of containers of its environment are updated. Used for internal SS purposes.
+//
+// subutai hostname con foo new-container-hostname
 func LxcHostname(c, name string) {
-	if !container.LxcInstanceExists(c) || container.IsTemplate(c) {
-		log.Error(c + " is not an container")
-		return
-	}
-
-	err := ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, c, "/rootfs/etc/hostname"), []byte(name), 0644)
-	log.Check(log.FatalLevel, "Replacing /etc/hostname for "+c, err)
-
-	file, err := os.Open(path.Join(config.Agent.LxcPrefix, c, "/rootfs/etc/hosts"))
-	log.Check(log.FatalLevel, "Reading /etc/hosts for "+c, err)
-	defer file.Close()
-	scanner := bufio.NewScanner(bufio.NewReader(file))
-
-	var hosts string
-	for scanner.Scan() {
-		if strings.HasPrefix(scanner.Text(), "127.0.1.1") {
-			hosts = hosts + "127.0.1.1\t" + name + "\n"
-		} else {
-			hosts = hosts + scanner.Text() + "\n"
-		}
-	}
-	err = ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, c, "/rootfs/etc/hosts"), []byte(hosts), 0644)
-	log.Check(log.FatalLevel, "Fixing /etc/hosts for "+c, err)
-
-	if container.State(c) == container.Running {
-		container.AttachExec(c, []string{"/bin/hostname", name})
-	}
+	checkState(container.IsContainer(c), "%s is not a container", c)
 
+	log.Check(log.ErrorLevel, "Setting hostname of "+c, container.SetHostname(c, name))
 }
 
 // Hostname sets the hostname of host
 func Hostname(name string) {
-	out, err := exec.Command("hostnamectl", "set-hostname", name).CombinedOutput()
+	out, err := exec.CombinedOutput("hostnamectl", "set-hostname", name)
 	log.Check(log.FatalLevel, "Setting host hostname: "+string(out), err)
 }
@@ -1,37 +1,55 @@
 package cli
 
 import (
+	"crypto/md5"
+	sha "crypto/sha256"
+	"crypto/sha512"
 	"encoding/json"
 	"fmt"
 	"github.com/cavaliercoder/grab"
-	"github.com/nightlyone/lockfile"
 	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/cluster"
 	"github.com/subutai-io/agent/agent/util"
 	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/db"
 	"github.com/subutai-io/agent/lib/common"
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fault"
+	"github.com/subutai-io/agent/lib/fips"
 	"github.com/subutai-io/agent/lib/fs"
 	"github.com/subutai-io/agent/lib/gpg"
-	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/lib/templ"
 	"github.com/subutai-io/agent/log"
-	"gopkg.in/cheggaaa/pb.v1"
+	"hash"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 const maxDownloadAttempts = 3
 
+// mirror failing this many times in a row is tried after healthy mirrors till mirrorRecovery passes
+const mirrorMaxFailures = 3
+const mirrorRecovery = 10 * time.Minute
+
+// errNotOnMirror is returned when mirror responds that it has no template, it is not failure of mirror
+var errNotOnMirror = errors.New("Template not found on mirror")
+
 const wrappedTemplateSuffix = ".tar.gz"
 const Md5DigestMethod = "md5"
 const Sha256DigestMethod = "sha256"
+const Sha384DigestMethod = "sha384"
+const Sha512DigestMethod = "sha512"
 
 type Template struct {
 	Id           string `json:"id"`
@@ -45,6 +63,12 @@ type Template struct {
 	Size         int64  `json:"size"`
 	FullRef      string `json:"full-ref"`
 	PrefSize     string `json:"pref-size"`
+	TemplateMetadata
+}
+
+// Ref returns reference of template as described by CDN
+func (t Template) Ref() templ.Ref {
+	return templ.Ref{Name: t.Name, Owner: t.Owner, Version: t.Version}
 }
 
 func init() {
@@ -74,22 +98,23 @@ func getTemplateInfoById(t *Template, id string) {
 	body, err := ioutil.ReadAll(response.Body)
 	log.Check(log.ErrorLevel, "Reading template info", err)
 
-	var templ Template
-	if log.Check(log.WarnLevel, "Parsing response body", json.Unmarshal(body, &templ)) {
+	var info Template
+	if log.Check(log.WarnLevel, "Parsing response body", json.Unmarshal(body, &info)) {
 		log.Error("Failed to parse template info")
 	}
 
-	t.Name = templ.Name
-	t.Owner = templ.Owner
-	t.Version = templ.Version
-	t.Id = templ.Id
-	t.MD5 = templ.MD5
-	t.Parent = templ.Parent
-	t.Size = templ.Size
-	t.DigestMethod = templ.DigestMethod
-	t.DigestHash = templ.DigestHash
-
-	log.Debug("Template identified as " + t.Name + "@" + t.Owner + ":" + t.Version)
+	t.Name = info.Name
+	t.Owner = info.Owner
+	t.Version = info.Version
+	t.Id = info.Id
+	t.MD5 = info.MD5
+	t.Parent = info.Parent
+	t.Size = info.Size
+	t.DigestMethod = info.DigestMethod
+	t.DigestHash = info.DigestHash
+	t.TemplateMetadata = info.TemplateMetadata
+
+	log.Debug("Template identified as " + t.Ref().CdnString())
 }
 
 //TODO extract all BZR CDN related functionality to own package
@@ -124,22 +149,23 @@ func getTemplateInfoByName(t *Template, name string, owner string, version strin
 	body, err := ioutil.ReadAll(response.Body)
 	log.Check(log.ErrorLevel, "Reading template info", err)
 
-	var templ Template
-	if log.Check(log.WarnLevel, "Parsing response body", json.Unmarshal(body, &templ)) {
+	var info Template
+	if log.Check(log.WarnLevel, "Parsing response body", json.Unmarshal(body, &info)) {
 		log.Error("Failed to parse template info")
 	}
 
-	t.Name = templ.Name
-	t.Owner = templ.Owner
-	t.Version = templ.Version
-	t.Id = templ.Id
-	t.MD5 = templ.MD5
-	t.Parent = templ.Parent
-	t.Size = templ.Size
-	t.DigestMethod = templ.DigestMethod
-	t.DigestHash = templ.DigestHash
-
-	log.Debug("Template identified as " + t.Name + "@" + t.Owner + ":" + t.Version)
+	t.Name = info.Name
+	t.Owner = info.Owner
+	t.Version = info.Version
+	t.Id = info.Id
+	t.MD5 = info.MD5
+	t.Parent = info.Parent
+	t.Size = info.Size
+	t.DigestMethod = info.DigestMethod
+	t.DigestHash = info.DigestHash
+	t.TemplateMetadata = info.TemplateMetadata
+
+	log.Debug("Template identified as " + t.Ref().CdnString())
 }
 
 func getTemplateInfo(template string) Template {
@@ -155,60 +181,157 @@ func getTemplateInfo(template string) Template {
 
 		// full template reference is template@owner:version e.g. master@subutai:4.0.0
 		// if owner is missing then we use verified only, if version is missing we use latest version
+		ref, err := templ.ParseRef(template)
+		log.Check(log.ErrorLevel, "Parsing template reference", err)
 
-		if templateNameNOwnerNVersionRx.MatchString(template) {
-			groups := util.MatchRegexGroups(templateNameNOwnerNVersionRx, template)
+		//installed templates referenced in full, e.g. promoted ones, may be missing on CDN
+		if ref.IsFull() && container.IsTemplate(ref.String()) {
+			return getLocalTemplateInfo(ref)
+		}
 
-			getTemplateInfoByName(&t, groups["name"], groups["owner"], groups["version"])
-		} else if templateNameNOwnerRx.MatchString(template) {
-			groups := util.MatchRegexGroups(templateNameNOwnerRx, template)
+		getTemplateInfoByName(&t, ref.Name, ref.Owner, ref.Version)
 
-			getTemplateInfoByName(&t, groups["name"], groups["owner"], "")
-		} else if templateNameRx.MatchString(template) {
-			groups := util.MatchRegexGroups(templateNameRx, template)
+	}
 
-			getTemplateInfoByName(&t, groups["name"], "", "")
-		} else {
-			log.Error("Invalid template name " + template)
-		}
+	return t
+}
 
-	}
+// getLocalTemplateInfo describes installed template using its config
+func getLocalTemplateInfo(ref templ.Ref) Template {
+	t := Template{Name: ref.Name, Owner: ref.Owner, Version: ref.Version, FullRef: ref.String()}
+
+	parent, err := container.ParentRef(ref.String())
+	log.Check(log.ErrorLevel, "Reading parent template of "+ref.String(), err)
+	t.Parent = parent.String()
+	t.PrefSize = container.GetProperty(ref.String(), "subutai.template.size")
+
+	log.Debug("Template identified as installed " + ref.String())
 
 	return t
 }
 
-// md5sum returns MD5 hash sum of specified file
-func md5sum(filePath string) string {
-	hash, err := fs.Md5Sum(filePath)
-	log.Check(log.WarnLevel, "Getting md5sum of "+filePath, err)
-	return hash
+// verifyChecksum checks file against template digest, any digest method accepted by newDigest is supported
+func verifyChecksum(template Template, filePath string) bool {
+	digest := newDigest(template)
+	if digest == nil {
+		return false
+	}
+
+	file, err := os.Open(filePath)
+	if log.Check(log.WarnLevel, "Opening "+filePath, err) {
+		return false
+	}
+	defer file.Close()
+	if _, err = io.Copy(digest, file); log.Check(log.WarnLevel, "Getting "+template.DigestMethod+" sum of "+filePath, err) {
+		return false
+	}
+
+	return strings.EqualFold(template.DigestHash, fmt.Sprintf("%x", digest.Sum(nil)))
+}
+
+// newDigest returns hash matching template digest method, nil if method is not supported. Methods supported in
+// FIPS mode are those accepted by fips.CheckDigest
+func newDigest(template Template) hash.Hash {
+	switch strings.ToLower(template.DigestMethod) {
+	case Sha256DigestMethod:
+		return sha.New()
+	case Sha384DigestMethod:
+		return sha512.New384()
+	case Sha512DigestMethod:
+		return sha512.New()
+	case Md5DigestMethod:
+		if !fips.Enabled() {
+			return md5.New()
+		}
+	}
+
+	return nil
+}
+
+// hashingTransport computes digest of downloaded content on the fly,
+// so that template archive does not need to be re-read for verification after download
+type hashingTransport struct {
+	http.RoundTripper
+	digest hash.Hash
 }
 
-func sha256(filePath string) string {
-	hash, err := fs.Sha256Sum(filePath)
-	log.Check(log.WarnLevel, "Getting sha256sum of "+filePath, err)
-	return hash
+type hashingBody struct {
+	io.Reader
+	io.Closer
 }
 
-func verifyChecksum(template Template, filePath string) bool {
-	if template.DigestMethod == Sha256DigestMethod {
-		return template.DigestHash == sha256(filePath)
-	} else if template.DigestMethod == Md5DigestMethod {
-		return template.DigestHash == md5sum(filePath)
+func (t *hashingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && req.Method == http.MethodGet {
+		//each response (including redirects) starts a new digest, the last one is the downloaded content
+		t.digest.Reset()
+		resp.Body = hashingBody{Reader: io.TeeReader(resp.Body, t.digest), Closer: resp.Body}
 	}
+	return resp, err
+}
 
-	return false
+// checkImportPolicy checks template described by CDN against import policy of host, owner is verified if CDN
+// resolves template name without owner to it
+func checkImportPolicy(t Template) error {
+	policy := templ.HostPolicy()
+
+	verified := false
+	if policy.VerifiedOnly {
+		owner, err := verifiedOwner(t.Name)
+		if err != nil {
+			return errors.Wrap(err, "Resolving verified owner of "+t.Name)
+		}
+		verified = owner == strings.ToLower(t.Owner)
+	}
+
+	return policy.Check(t.Ref(), verified)
 }
 
-func LxcImport(name, token string, auxDepList ...string) {
-	var err error
+// verifiedOwner returns owner of template name CDN implies when owner is not given
+func verifiedOwner(name string) (string, error) {
+	t, err := latestTemplate(name, "")
+	if err != nil || t == nil {
+		return "", err
+	}
+	return strings.ToLower(t.Owner), nil
+}
+
+// latestTemplate returns the latest version of template published on CDN, nil if template is not found.
+// Unlike getTemplateInfoByName it returns errors, so it may be used by daemon
+func latestTemplate(name, owner string) (*Template, error) {
+	theUrl := config.CdnUrl + "/template?name=" + name + "&version=latest"
+	if owner != "" {
+		theUrl += "&owner=" + owner
+	}
+
+	clnt := util.GetClient(config.CDN.AllowInsecure, 30)
+
+	response, err := util.RetryGet(theUrl, clnt, 3)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(response)
 
-	if !fs.DatasetExists("") {
-		log.Fatal("Root dataset " + config.Agent.Dataset + " not mounted")
+	if response.StatusCode == 404 {
+		return nil, nil
+	}
+	if response.StatusCode != 200 {
+		return nil, errors.New("Failed to get template info: " + response.Status)
 	}
 
-	if container.LxcInstanceExists(name) && name == container.Management && len(token) > 1 {
-		gpg.ExchangeAndEncrypt(container.Management, token)
+	var info Template
+	if err = json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+func LxcImport(name, token string, auxDepList ...string) {
+	var err error
+
+	if name == container.ManagementTemplate && container.LxcInstanceExists(container.Management) && len(token) > 1 {
+		gpg.ExchangeAndEncrypt(container.Management, token, gpg.ScopeManagement)
 		return
 	}
 
@@ -220,7 +343,7 @@ func LxcImport(name, token string, auxDepList ...string) {
 
 	if !local {
 		t = getTemplateInfo(name)
-		templateRef = strings.Join([]string{t.Name, t.Owner, t.Version}, ":")
+		templateRef = t.Ref().String()
 		localArchive = path.Join(config.Agent.CacheDir, t.Id)
 	} else {
 		//for local import we accept full path to template archive
@@ -229,21 +352,35 @@ func LxcImport(name, token string, auxDepList ...string) {
 		}
 
 		t.Name = filepath.Base(name)
+		//archive name becomes temporary dataset name until template reference is read from the archive
+		checkValid(container.ValidateDatasetComponent(t.Name))
 		templateRef = "tmpl_" + t.Name
 		localArchive = name
 	}
 
+	//archive is downloaded unless it is cached and then extracted into cache, management container needs proxies
+	checks := []hostCheck{poolCheck(), lxcCheck()}
+	if !container.LxcInstanceExists(templateRef) {
+		need := 2 * t.Size
+		if info, err := os.Stat(localArchive); err == nil {
+			need = info.Size()
+		}
+		checks = append(checks, cacheSpaceCheck(need))
+	}
+	if t.Name == container.ManagementTemplate {
+		checks = append(checks, nginxCheck())
+	}
+	preflight("import of "+t.Name, checks...)
+
 	log.Info("Importing " + t.Name)
 
-	var lock lockfile.Lockfile
-	for lock, err = common.LockFile(templateRef, "import"); err != nil; lock, err = common.LockFile(templateRef, "import") {
-		time.Sleep(time.Second * 1)
-	}
-	defer lock.Unlock()
+	//parent templates are imported while holding this lock, see common.AcquireLocks for lock order
+	lock := common.AcquireLocks(common.LockKey{Kind: common.TemplateLock, Name: templateRef})
+	defer lock.Release()
 
 	//for local import this check currently does not work
 	if container.LxcInstanceExists(templateRef) {
-		if t.Name == container.Management && !container.IsContainer(container.Management) {
+		if t.Name == container.ManagementTemplate && !container.IsContainer(container.Management) && config.HA.Primary == "" {
 			initManagement(templateRef)
 			return
 		}
@@ -252,6 +389,12 @@ func LxcImport(name, token string, auxDepList ...string) {
 		return
 	}
 
+	//templates published with md5 digest only are refused in FIPS mode rather than verified with it
+	if !local {
+		log.Check(log.ErrorLevel, "Checking import policy", checkImportPolicy(t))
+		log.Check(log.ErrorLevel, "Checking digest of "+t.Name, fips.CheckDigest(t.DigestMethod))
+	}
+
 	var archiveExists = fs.FileExists(localArchive)
 
 	if archiveExists {
@@ -276,23 +419,80 @@ func LxcImport(name, token string, auxDepList ...string) {
 		log.Debug("Template archive is missing in local cache")
 	}
 
+	if !local {
+		log.Check(log.DebugLevel, "Recording template cache lookup",
+			db.RecordTemplateCacheLookup(templateStatsRef(t), archiveExists))
+	}
+
 	if !archiveExists {
 		download(t)
 	}
 
+	extractDir := path.Join(config.Agent.CacheDir, templateRef)
+	//files left by interrupted import would be taken for files of archive
+	log.Check(log.WarnLevel, "Removing temp dir "+extractDir, os.RemoveAll(extractDir))
+	progress.Stage("unpack", t.Name)
+
+	var manifest *Manifest
+	var signed bool
+	if local {
+		//manifest and import policy are checked before anything else is extracted from local archive,
+		//config is read for reference of older archives lacking manifest
+		log.Check(log.FatalLevel, "Extracting manifest", fs.DecompressSkipping(localArchive, extractDir,
+			func(name string) bool { return name != manifestFile && name != "config" }))
+
+		//local archives are described by bundled manifest, older archives lack it and are described by config only
+		manifest, err = readManifest(extractDir)
+		log.Check(log.WarnLevel, "Reading template manifest", err)
+		if signed, err = verifyManifest(manifest); err != nil {
+			log.Check(log.WarnLevel, "Removing temp dir "+extractDir, os.RemoveAll(extractDir))
+			log.Error("Verifying template signature: " + err.Error())
+		}
+
+		var ref templ.Ref
+		if manifest != nil {
+			ref, err = templ.NewRef(manifest.Name, manifest.Owner, manifest.Version)
+			t.Name = manifest.Name
+		} else {
+			ref, err = container.ConfigRef(extractDir+"/config", "subutai.template")
+		}
+		if err != nil {
+			log.Check(log.WarnLevel, "Removing temp dir "+extractDir, os.RemoveAll(extractDir))
+			log.Error("Reading template reference: " + err.Error())
+		}
+		if err = templ.HostPolicy().Check(ref, signed); err != nil {
+			log.Check(log.WarnLevel, "Removing temp dir "+extractDir, os.RemoveAll(extractDir))
+			log.Error("Checking import policy: " + err.Error())
+		}
+		templateRef = ref.String()
+	}
+
 	//!important used by Console
 	log.Info("Unpacking template " + t.Name)
 	log.Debug(localArchive + " to " + templateRef)
-	extractDir := path.Join(config.Agent.CacheDir, templateRef)
-	log.Check(log.FatalLevel, "Extracting tgz", fs.Decompress(localArchive, extractDir))
-
-	templateName := container.GetConfigItem(extractDir+"/config", "subutai.template")
-	templateOwner := container.GetConfigItem(extractDir+"/config", "subutai.template.owner")
-	templateVersion := container.GetConfigItem(extractDir+"/config", "subutai.template.version")
+	//deltas are streamed directly from archive during installation
+	log.Check(log.FatalLevel, "Extracting tgz", fs.DecompressSkipping(localArchive, extractDir, func(name string) bool {
+		return isDelta(name) || (local && (name == manifestFile || name == "config"))
+	}))
+	progress.Finish()
+
+	//downloaded archive bundles manifest too, only its metadata is used since archive is verified by CDN digest
+	bundled := manifest
+	if !local {
+		bundled, err = readManifest(extractDir)
+		log.Check(log.WarnLevel, "Reading template manifest", err)
+	}
 
 	if local {
+		//signature covers other files only through their digests, config is installed as is
+		if signed {
+			if err = verifyManifestFiles(extractDir, manifest); err != nil {
+				log.Check(log.WarnLevel, "Removing temp dir "+extractDir, os.RemoveAll(extractDir))
+				log.Error("Verifying template files: " + err.Error())
+			}
+		}
+
 		//rename template directory to follow full reference convention
-		templateRef = strings.Join([]string{templateName, templateOwner, templateVersion}, ":")
 		log.Check(log.ErrorLevel, "Renaming template", os.Rename(extractDir, path.Join(config.Agent.CacheDir, templateRef)))
 		extractDir = path.Join(config.Agent.CacheDir, templateRef)
 	}
@@ -302,11 +502,18 @@ func LxcImport(name, token string, auxDepList ...string) {
 		log.Error(templateRef + " exists")
 	}
 
-	parent := container.GetConfigItem(extractDir+"/config", "subutai.parent")
-	parentOwner := container.GetConfigItem(extractDir+"/config", "subutai.parent.owner")
-	parentVersion := container.GetConfigItem(extractDir+"/config", "subutai.parent.version")
+	var parent templ.Ref
+	if manifest != nil {
+		parent, err = templ.ParseFullRef(manifest.Parent)
+	} else {
+		parent, err = container.ConfigRef(extractDir+"/config", "subutai.parent")
+	}
+	if err != nil {
+		log.Check(log.WarnLevel, "Removing temp dir "+extractDir, os.RemoveAll(extractDir))
+		log.Error("Reading parent template reference: " + err.Error())
+	}
 
-	parentRef := strings.Join([]string{parent, parentOwner, parentVersion}, ":")
+	parentRef := parent.String()
 	if parentRef != templateRef && !container.IsTemplate(parentRef) && !stringInList(parentRef, auxDepList) {
 		// Append the template and parent name to dependency list
 		auxDepList = append(auxDepList, parentRef, templateRef)
@@ -322,16 +529,37 @@ func LxcImport(name, token string, auxDepList ...string) {
 		container.Destroy(templateRef, true)
 	}
 
-	install(templateRef)
+	var deltaDigests map[string]string
+	if manifest != nil && manifest.DigestMethod == Sha256DigestMethod {
+		deltaDigests = manifest.Deltas
+	}
+
+	progress.Stage("install", t.Name)
+	log.Check(log.ErrorLevel, "Installing template", install(templateRef, localArchive, deltaDigests))
+	progress.Finish()
+	log.Check(log.WarnLevel, "Saving package inventory", readPackages(templateRef, extractDir))
+	//metadata is carried in bundled manifest, CDN info may describe older archives lacking it
+	meta := t.TemplateMetadata
+	if bundled != nil {
+		meta = bundled.TemplateMetadata
+	}
+	log.Check(log.WarnLevel, "Saving template metadata", saveTemplateMetadata(templateRef, meta))
 
 	log.Check(log.WarnLevel, "Removing temp dir "+extractDir, os.RemoveAll(extractDir))
 
-	//delete template archive
 	if !local {
+		log.Check(log.DebugLevel, "Recording template import", db.RecordTemplateImport(templateStatsRef(t)))
+	}
+
+	//delete template archive, unless it is kept for cluster peers
+	if !local && !cluster.SharesTemplates() {
 		log.Check(log.WarnLevel, "Removing file: "+localArchive, os.Remove(localArchive))
+	} else if !local {
+		log.Check(log.WarnLevel, "Keeping template archive for cluster peers", cluster.KeepTemplate(t.Id))
 	}
 
-	if t.Name == container.Management {
+	//standby host receives management container from primary, only template is installed there
+	if t.Name == container.ManagementTemplate && config.HA.Primary == "" {
 		initManagement(templateRef)
 		return
 	}
@@ -341,14 +569,40 @@ func LxcImport(name, token string, auxDepList ...string) {
 
 func download(template Template) {
 
-	if isValidUrl(config.CDN.TemplateDownloadUrl) {
-		downloadFromGateway(template)
+	if cluster.Enabled() && downloadFromPeers(template) {
+		return
+	}
+
+	if gateways := templateGateways(); len(gateways) > 0 {
+		downloadFromGateway(template, gateways)
 	} else {
 		downloadViaLocalIPFSNode(template)
 	}
 
 }
 
+// downloadFromPeers fetches template archive kept in cache by cluster peer, saving WAN traffic when hosts
+// import the same templates. Archive is accepted only if it matches digest published by CDN
+func downloadFromPeers(template Template) bool {
+	start := time.Now()
+	peer, err := cluster.FetchTemplate(template.Id, path.Join(config.Agent.CacheDir, template.Id),
+		func(file string) bool { return verifyChecksum(template, file) })
+	if log.Check(log.DebugLevel, "Looking up template on cluster peers", err) {
+		return false
+	}
+
+	log.Info("Template " + template.Name + " received from cluster peer " + peer)
+	log.Check(log.DebugLevel, "Recording template download",
+		db.RecordTemplateDownload(templateStatsRef(template), "peer:"+peer, template.Size, time.Since(start), nil))
+
+	return true
+}
+
+// templateStatsRef returns reference under which template usage is recorded
+func templateStatsRef(template Template) string {
+	return template.Ref().CdnString()
+}
+
 func isValidUrl(toTest string) bool {
 	_, err := url.ParseRequestURI(toTest)
 	if err != nil {
@@ -358,49 +612,133 @@ func isValidUrl(toTest string) bool {
 	}
 }
 
-func getTemplateUrl(template Template) string {
+// templateGateways returns valid gateways of templateDownloadUrl and templateMirrors in configured order,
+// except that unhealthy mirrors go after healthy ones
+func templateGateways() []string {
+	var healthy, unhealthy []string
+	stats, err := db.GetMirrorStats()
+	log.Check(log.DebugLevel, "Reading mirror stats", err)
+
+	for _, gateway := range append([]string{config.CDN.TemplateDownloadUrl}, strings.Split(config.CDN.TemplateMirrors, ",")...) {
+		gateway = strings.TrimSpace(gateway)
+		if !isValidUrl(gateway) || stringInList(gateway, healthy) || stringInList(gateway, unhealthy) {
+			continue
+		}
+		if mirrorHealthy(mirrorName(gateway), stats) {
+			healthy = append(healthy, gateway)
+		} else {
+			unhealthy = append(unhealthy, gateway)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// mirrorHealthy tells if mirror has not failed several times in a row recently
+func mirrorHealthy(mirror string, stats []db.MirrorStats) bool {
+	for _, s := range stats {
+		if s.Mirror == mirror {
+			return s.ConsecutiveFailures < mirrorMaxFailures || time.Since(s.LastFailure) > mirrorRecovery
+		}
+	}
+	return true
+}
+
+// mirrorName returns host of gateway, download stats are recorded by it
+func mirrorName(gateway string) string {
+	if u, err := url.Parse(gateway); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return gateway
+}
+
+func getTemplateUrl(template Template, gateway string) (string, error) {
 
-	directUrl := strings.Replace(config.CDN.TemplateDownloadUrl, "{ID}", template.Id, 1)
+	directUrl := strings.Replace(gateway, "{ID}", template.Id, 1)
 
 	u, err := url.Parse(directUrl)
-	log.Check(log.ErrorLevel, "Parsing template url", err)
+	if err != nil {
+		return "", errors.Wrap(err, "Parsing template url")
+	}
 
 	u.Path = path.Join(u.Path, template.Name)
 	wrappedUrl := u.String() + wrappedTemplateSuffix
 	res, err := http.Head(wrappedUrl)
-	log.Check(log.ErrorLevel, "Checking wrapped template existence", err)
+	if err != nil {
+		return "", errors.Wrap(err, "Checking wrapped template existence")
+	}
+	res.Body.Close()
 	if res.StatusCode == 200 {
-		return wrappedUrl
+		return wrappedUrl, nil
 	}
 
 	res, err = http.Head(directUrl)
-	log.Check(log.ErrorLevel, "Checking template existence", err)
+	if err != nil {
+		return "", errors.Wrap(err, "Checking template existence")
+	}
+	res.Body.Close()
 	if res.StatusCode == 200 {
-		return directUrl
+		return directUrl, nil
+	}
+	if res.StatusCode == 404 {
+		return "", errNotOnMirror
 	}
 
-	log.Error("Template not found")
-
-	//should not reach here
-	return ""
+	return "", errors.New("Checking template existence: " + res.Status)
 }
 
 func isWrappedTemplateUrl(url string) bool {
 	return strings.HasSuffix(url, wrappedTemplateSuffix)
 }
 
-func downloadFromGateway(template Template) {
-	templateUrl := getTemplateUrl(template)
-	attempts := 1
+// downloadFromGateway downloads template from the first of gateways having it, next gateway is tried when
+// template is not found on gateway or all attempts to download it fail
+func downloadFromGateway(template Template, gateways []string) {
 	var err error
+	for _, gateway := range gateways {
+		var templateUrl string
+		templateUrl, err = getTemplateUrl(template, gateway)
+		if err == errNotOnMirror {
+			log.Warn("Template " + template.Name + " is not found on " + mirrorName(gateway))
+			continue
+		}
+		if err != nil {
+			log.Check(log.DebugLevel, "Recording template download",
+				db.RecordTemplateDownload(templateStatsRef(template), mirrorName(gateway), template.Size, 0, err))
+			log.Warn("Template " + template.Name + " is not available on " + mirrorName(gateway) + ": " + err.Error())
+			continue
+		}
 
-	for err = doDownload(template, templateUrl); err != nil && attempts < maxDownloadAttempts; err = doDownload(template, templateUrl) {
-		attempts++
+		attempts := 1
+		for err = recordedDownload(template, templateUrl); err != nil && err != errNotOnMirror && attempts < maxDownloadAttempts; err = recordedDownload(template, templateUrl) {
+			attempts++
+		}
+		if err == nil {
+			return
+		}
+		log.Warn("Downloading template " + template.Name + " from " + mirrorName(gateway) + " failed: " + err.Error())
 	}
 
 	log.Check(log.ErrorLevel, "Download completed", err)
 }
 
+// recordedDownload downloads template and records download duration or failure reason, template missing
+// on mirror is not recorded
+func recordedDownload(template Template, templateUrl string) error {
+	mirror := mirrorName(templateUrl)
+
+	start := time.Now()
+	err := doDownload(template, templateUrl)
+	if code, ok := errors.Cause(err).(grab.StatusCodeError); ok && code == http.StatusNotFound {
+		return errNotOnMirror
+	}
+
+	log.Check(log.DebugLevel, "Recording template download",
+		db.RecordTemplateDownload(templateStatsRef(template), mirror, template.Size, time.Since(start), err))
+
+	return err
+}
+
 func doDownload(template Template, templateUrl string) error {
 	templatePath := path.Join(config.Agent.CacheDir, template.Id)
 
@@ -412,6 +750,13 @@ func doDownload(template Template, templateUrl string) error {
 
 	// create client
 	client := grab.NewClient()
+	client.HTTPClient.Transport = fault.Transport(fips.Transport(client.HTTPClient.Transport))
+
+	//calculate digest while downloading
+	digest := newDigest(template)
+	if digest != nil {
+		client.HTTPClient.Transport = &hashingTransport{RoundTripper: client.HTTPClient.Transport, digest: digest}
+	}
 
 	req, err := grab.NewRequest(templatePath+wrappedSuffix, templateUrl)
 
@@ -433,30 +778,26 @@ func doDownload(template Template, templateUrl string) error {
 	t := time.NewTicker(100 * time.Millisecond)
 	defer t.Stop()
 
-	bar := pb.New(int(resp.Size)).SetUnits(pb.U_BYTES)
-	if resp.Size <= 0 {
-		bar.NotPrint = true
-	}
-	bar.Start()
-	defer bar.Finish()
+	progress.Stage("download", template.Name)
+	defer progress.Finish()
 Loop:
 	for {
 		select {
 		case <-t.C:
-			bar.Set(int(resp.BytesComplete()))
+			progress.Bytes(resp.BytesComplete(), resp.Size)
 
 		case <-resp.Done:
 			// download is complete
-			bar.Set(int(resp.BytesComplete()))
+			progress.Bytes(resp.BytesComplete(), resp.Size)
 			break Loop
 		}
 	}
 
-	bar.Finish()
+	progress.Finish()
 
 	// check for errors
 	if log.Check(log.DebugLevel, "Checking download status", resp.Err()) {
-		return err
+		return resp.Err()
 	}
 
 	if isWrapped {
@@ -465,8 +806,12 @@ Loop:
 		log.Check(log.ErrorLevel, "Renaming template", os.Rename(templatePath+wrappedSuffix, templatePath))
 	}
 
-	//check hash sum
-	if !verifyChecksum(template, templatePath) {
+	//check hash sum, resumed download contains bytes not seen by streaming digest so re-read file in this case
+	if digest != nil && !resp.DidResume {
+		if !strings.EqualFold(template.DigestHash, fmt.Sprintf("%x", digest.Sum(nil))) {
+			return errors.New("File integrity verification failed")
+		}
+	} else if !verifyChecksum(template, templatePath) {
 		return errors.New("File integrity verification failed")
 	}
 
@@ -485,6 +830,7 @@ func downloadViaLocalIPFSNode(template Template) {
 	}
 
 	if err != nil {
+		recordIPFSDownload(template, 0, errors.New("Template not found in CDN network"))
 		log.Fatal("Template not found in CDN network")
 	}
 
@@ -494,7 +840,13 @@ func downloadViaLocalIPFSNode(template Template) {
 	templatePath := path.Join(config.Agent.CacheDir, template.Id)
 
 	//download template
+	start := time.Now()
+	progress.Stage("download", template.Name)
 	_, err = exec.ExecuteOutput("ipfs", map[string]string{"IPFS_PATH": config.CDN.IpfsPath}, "get", template.Id, "-o", templatePath)
+	progress.Finish()
+	if err != nil {
+		recordIPFSDownload(template, 0, err)
+	}
 	log.Check(log.FatalLevel, "Checking download status", err)
 
 	//check if download is a directory
@@ -515,13 +867,21 @@ func downloadViaLocalIPFSNode(template Template) {
 
 	//verify its md5 sum
 	if !verifyChecksum(template, templatePath) {
+		recordIPFSDownload(template, 0, errors.New("File integrity verification failed"))
 		log.Fatal("File integrity verification failed")
 	}
 
+	recordIPFSDownload(template, time.Since(start), nil)
+
 	//pin template
 	exec.Exec("ipfs", "pin", "add", template.Id)
 }
 
+func recordIPFSDownload(template Template, duration time.Duration, err error) {
+	log.Check(log.DebugLevel, "Recording template download",
+		db.RecordTemplateDownload(templateStatsRef(template), "ipfs", template.Size, duration, err))
+}
+
 func updateContainerConfig(templateName string) error {
 
 	if common.GetMajorVersion() < 3 {
@@ -553,7 +913,34 @@ func stringInList(s string, list []string) bool {
 	return false
 }
 
-func install(templateName string) error {
+// installDelta receives delta of template partition read from input and verifies its digest if expected
+// digest is not empty
+func installDelta(templateName, partition string, input io.Reader, expected string) error {
+	digest := sha.New()
+	stream := io.TeeReader(input, digest)
+	if err := fs.ReceiveStreamFrom(templateName+"/"+partition, stream, false); err != nil {
+		return errors.Wrap(err, "Receiving "+partition+" delta")
+	}
+	//zfs may leave trailing bytes unread
+	if _, err := io.Copy(ioutil.Discard, stream); err != nil {
+		return errors.Wrap(err, "Receiving "+partition+" delta")
+	}
+
+	if expected != "" && expected != fmt.Sprintf("%x", digest.Sum(nil)) {
+		return errors.New("Integrity verification of " + partition + " delta failed")
+	}
+
+	return nil
+}
+
+// isDelta checks if archive entry is a zfs stream of template partition
+func isDelta(name string) bool {
+	return path.Dir(name) == "deltas" && strings.HasSuffix(name, ".delta")
+}
+
+// install creates template datasets from deltas in archive.
+// If deltaDigests are passed, sha256 digest of each delta is verified
+func install(templateName, archive string, deltaDigests map[string]string) error {
 
 	pathToDecompressedTemplate := path.Join(config.Agent.CacheDir, templateName)
 
@@ -562,22 +949,67 @@ func install(templateName string) error {
 	if err != nil {
 		return err
 	}
-	// create partitions
-	err = fs.ReceiveStream(templateName+"/rootfs", path.Join(pathToDecompressedTemplate, "deltas", "rootfs.delta"), false)
-	if err != nil {
-		return err
+	// create partitions streaming deltas directly from archive. Archive is read once, since its entries can
+	// only be read one after another, and each delta is piped to worker receiving it, so that receiving of
+	// partition is finished while next delta is read
+	type delta struct {
+		partition string
+		input     *io.PipeReader
 	}
-	err = fs.ReceiveStream(templateName+"/home", path.Join(pathToDecompressedTemplate, "deltas", "home.delta"), false)
-	if err != nil {
-		return err
+	deltas := make(chan delta)
+
+	workers := runtime.NumCPU()
+	if workers > len(fs.ChildDatasets) {
+		workers = len(fs.ChildDatasets)
 	}
-	err = fs.ReceiveStream(templateName+"/var", path.Join(pathToDecompressedTemplate, "deltas", "var.delta"), false)
-	if err != nil {
-		return err
+
+	var mu sync.Mutex
+	var failures []string
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range deltas {
+				if err := installDelta(templateName, d.partition, d.input, deltaDigests[d.partition]); err != nil {
+					mu.Lock()
+					failures = append(failures, err.Error())
+					mu.Unlock()
+				}
+				//rest of failed delta is skipped so that archive is read further
+				io.Copy(ioutil.Discard, d.input)
+				d.input.Close()
+			}
+		}()
 	}
-	err = fs.ReceiveStream(templateName+"/opt", path.Join(pathToDecompressedTemplate, "deltas", "opt.delta"), false)
-	if err != nil {
+
+	received := make(map[string]bool)
+	err = fs.StreamEntries(archive, func(name string) bool {
+		return isDelta(name) && stringInList(strings.TrimSuffix(path.Base(name), ".delta"), fs.ChildDatasets)
+	}, func(name string, input io.Reader) error {
+		partition := strings.TrimSuffix(path.Base(name), ".delta")
+		received[partition] = true
+
+		reader, writer := io.Pipe()
+		deltas <- delta{partition: partition, input: reader}
+		_, err := io.Copy(writer, input)
+		writer.CloseWithError(err)
 		return err
+	})
+	close(deltas)
+	wg.Wait()
+
+	if err != nil {
+		failures = append(failures, "Reading template archive: "+err.Error())
+	}
+	for _, partition := range fs.ChildDatasets {
+		if !received[partition] {
+			failures = append(failures, "Template archive is missing "+partition+" delta")
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return errors.New(strings.Join(failures, "; "))
 	}
 
 	// set partitions as read-only
@@ -609,6 +1041,14 @@ func install(templateName string) error {
 func initManagement(templateRef string) {
 	container.Clone(templateRef, container.Management)
 
+	setupManagement(true)
+
+	printManagementBanner(resetManagementCredentials())
+}
+
+// setupManagement configures network, keys, db record and proxies of management container,
+// starts it and waits for it to become ready
+func setupManagement(generateKey bool) {
 	container.SetContainerUID(container.Management)
 	if common.GetMajorVersion() < 3 {
 		container.SetContainerConf(container.Management, [][]string{
@@ -620,34 +1060,27 @@ func initManagement(templateRef string) {
 		})
 
 	}
-	gpg.GenerateKey(container.Management)
+	if generateKey {
+		gpg.GenerateKey(container.Management)
+	}
 	container.SetDNS(container.Management)
 	container.SetManagementNet()
 	container.Start(container.Management)
 
-	//TODO use proxy lib
-	log.Check(log.WarnLevel, "Setting up proxy for port 8443",
-		exec.Exec("subutai", "proxy", "create", "-t", "management-8443", "-p", "tcp", "-e", "8443"))
-	log.Check(log.WarnLevel, "Redirecting port 8443 to management container",
-		exec.Exec("subutai", "proxy", "srv", "add", "-t", "management-8443", "-s", "10.10.10.1:8443"))
-	log.Check(log.WarnLevel, "Setting up proxy for port 8444",
-		exec.Exec("subutai", "proxy", "create", "-t", "management-8444", "-p", "tcp", "-e", "8444"))
-	log.Check(log.WarnLevel, "Redirecting port 8444 to management container",
-		exec.Exec("subutai", "proxy", "srv", "add", "-t", "management-8444", "-s", "10.10.10.1:8444"))
-	log.Check(log.WarnLevel, "Setting up proxy for port 8086",
-		exec.Exec("subutai", "proxy", "create", "-t", "management-8086", "-p", "tcp", "-e", "8086"))
-	log.Check(log.WarnLevel, "Redirecting port 8086 to management container",
-		exec.Exec("subutai", "proxy", "srv", "add", "-t", "management-8086", "-s", "10.10.10.1:8086"))
-
 	mgmtCont := &db.Container{}
 	mgmtCont.Name = container.Management
 	mgmtCont.Ip = container.ManagementIp
+	mgmtCont.Ipv6 = config.Management.ContainerIpv6
+	mgmtCont.Gateway6 = config.Management.ContainerGateway6
 	mgmtCont.State = container.Running
 	log.Check(log.ErrorLevel, "Writing container data to database", db.SaveContainer(mgmtCont))
 
-	log.Info("********************")
-	log.Info("Subutai Management UI will be shortly available at https://" + net.GetIp() + ":8443")
-	log.Info("login: admin")
-	log.Info("password: secret")
-	log.Info("********************")
+	deadline := time.Now().Add(time.Second * time.Duration(config.Management.ReadyTimeout))
+
+	log.Info("Waiting for management to start")
+	log.Check(log.ErrorLevel, "Waiting for management",
+		waitForManagement("https://"+container.ManagementIp+":"+config.Management.Port, deadline))
+
+	log.Info("Setting up management proxies")
+	log.Check(log.ErrorLevel, "Setting up management proxies", setupManagementProxies(deadline))
 }
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -309,7 +308,7 @@ func GetFingerprint(container string) string {
 
 func GetOsName() string {
 
-	out, err := exec.Command("/bin/bash", "-c", "cat /etc/*release").Output()
+	out, err := exc.Output("/bin/bash", "-c", "cat /etc/*release")
 
 	log.Check(log.ErrorLevel, "Determining OS name", err)
 
@@ -343,7 +342,7 @@ func GetOsName() string {
 func GetUsedPorts() map[string]bool {
 	ports := make(map[string]bool)
 
-	out, _ := exec.Command("ss", "-ltun").Output()
+	out, _ := exc.Output("ss", "-ltun")
 	scanner := bufio.NewScanner(bytes.NewReader(out))
 
 	for scanner.Scan() {
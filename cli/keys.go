@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/signing"
+	"github.com/subutai-io/agent/log"
+)
+
+// KeysGenerate creates ed25519 key pair signing manifests of templates exported by host, the most recently
+// generated unexpired key is used. Key without ttl does not expire. Public key is printed to be trusted by
+// other hosts
+//
+// subutai keys generate host1 [--ttl 365]
+func KeysGenerate(name string, ttlDays int) {
+	name = strings.TrimSpace(name)
+	checkArgument(name != "" && !strings.ContainsAny(name, " \t"), "Invalid key name %s", name)
+	checkArgument(ttlDays >= 0, "Invalid ttl %d", ttlDays)
+	existing, err := db.FindSigningKey(name)
+	log.Check(log.ErrorLevel, "Reading signing key", err)
+	checkState(existing == nil, "Key %s already exists", name)
+
+	key, err := signing.Generate(name, time.Duration(ttlDays)*24*time.Hour)
+	log.Check(log.ErrorLevel, "Generating signing key", err)
+
+	fmt.Println(signing.Export(*key))
+}
+
+// KeysList prints own keys and keys of other hosts trusted to sign templates
+//
+// subutai keys list
+func KeysList() {
+	keys, err := db.GetAllSigningKeys()
+	log.Check(log.ErrorLevel, "Reading signing keys", err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tKIND\tFINGERPRINT\tCREATED\tEXPIRES\tSTATE")
+	for _, k := range keys {
+		kind, expires, state := "trusted", "never", "valid"
+		if signing.IsOwn(k) {
+			kind = "own"
+		}
+		if !k.Expires.IsZero() {
+			expires = k.Expires.Format(time.RFC3339)
+		}
+		if signing.IsExpired(k) {
+			state = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", k.Name, kind, signing.Fingerprint(k),
+			k.Created.Format(time.RFC3339), expires, state)
+	}
+	w.Flush()
+}
+
+// KeysExport prints public key in form accepted by "subutai keys trust"
+//
+// subutai keys export host1
+func KeysExport(name string) {
+	key, err := db.FindSigningKey(name)
+	log.Check(log.ErrorLevel, "Reading signing key", err)
+	checkState(key != nil, "Key %s not found", name)
+
+	fmt.Println(signing.Export(*key))
+}
+
+// KeysTrust saves public key of another host, key is given as printed by "subutai keys export" or as path
+// to file holding it. Once any key is trusted, local template archives must be signed with trusted or own key
+//
+// subutai keys trust host2 "ed25519 AAAA... host2" [--ttl 365]
+// subutai keys trust host2 /tmp/host2.pub
+func KeysTrust(name, publicKey string, ttlDays int) {
+	name = strings.TrimSpace(name)
+	checkArgument(name != "" && !strings.ContainsAny(name, " \t"), "Invalid key name %s", name)
+	checkArgument(ttlDays >= 0, "Invalid ttl %d", ttlDays)
+	existing, err := db.FindSigningKey(name)
+	log.Check(log.ErrorLevel, "Reading signing key", err)
+	checkState(existing == nil, "Key %s already exists", name)
+
+	if fs.FileExists(publicKey) {
+		data, err := ioutil.ReadFile(publicKey)
+		log.Check(log.ErrorLevel, "Reading public key", err)
+		publicKey = string(data)
+	}
+	public, _, err := signing.ParsePublicKey(publicKey)
+	checkValid(err)
+
+	key, err := signing.Trust(name, public, time.Duration(ttlDays)*24*time.Hour)
+	log.Check(log.ErrorLevel, "Saving trusted key", err)
+
+	log.Info("Key " + name + " " + signing.Fingerprint(*key) + " is trusted")
+}
+
+// KeysRemove removes own or trusted key, templates signed with it are not accepted anymore
+//
+// subutai keys remove host2
+func KeysRemove(name string) {
+	key, err := db.FindSigningKey(name)
+	log.Check(log.ErrorLevel, "Reading signing key", err)
+	checkState(key != nil, "Key %s not found", name)
+
+	log.Check(log.ErrorLevel, "Removing signing key", db.RemoveSigningKey(key))
+}
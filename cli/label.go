@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+var labelKeyRx = regexp.MustCompile(`^[[:alnum:]][[:alnum:]_./-]*$`)
+
+// ContainerLabel sets labels in form key=value and removes labels of container, labels are printed
+// if nothing is changed
+//
+// subutai label foo [app=web tier=front] [--remove tier]
+func ContainerLabel(name string, labels, remove []string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	cont, err := db.FindContainerByName(name)
+	log.Check(log.ErrorLevel, "Reading container metadata", err)
+	checkState(cont != nil, "Container %s is not registered in db", name)
+
+	if len(labels) == 0 && len(remove) == 0 {
+		printLabels(cont.Labels)
+		return
+	}
+
+	set, err := parseLabels(labels)
+	checkValid(err)
+
+	if cont.Labels == nil {
+		cont.Labels = make(map[string]string)
+	}
+	for key, value := range set {
+		cont.Labels[key] = value
+	}
+	for _, key := range remove {
+		delete(cont.Labels, key)
+	}
+
+	log.Check(log.ErrorLevel, "Saving container metadata", db.SaveContainer(cont))
+}
+
+func printLabels(labels map[string]string) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "KEY\tVALUE")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\n", key, labels[key])
+	}
+	w.Flush()
+}
+
+// parseLabels parses labels in form key=value
+func parseLabels(labels []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, label := range labels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || !labelKeyRx.MatchString(parts[0]) {
+			return nil, errors.Errorf("Invalid label %s, use key=value", label)
+		}
+		result[parts[0]] = parts[1]
+	}
+
+	return result, nil
+}
+
+// matchLabels tells if container has all labels of selector
+func matchLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
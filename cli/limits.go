@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// ContainerLimits sets prlimits and sysctls in form key=value and removes them from container, e.g. for
+// Elasticsearch-like workloads. Limits are printed if nothing is changed. Prlimits apply after restart of container,
+// namespaced sysctls are also written to running container and the rest, e.g. vm.max_map_count, are raised on host
+//
+// subutai limits foo [nofile=65536 nproc=4096 net.core.somaxconn=1024 vm.max_map_count=262144] [--remove nproc]
+func ContainerLimits(name string, limits, remove []string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	if len(limits) == 0 && len(remove) == 0 {
+		current := container.Limits(name)
+		keys := make([]string, 0, len(current))
+		for key := range current {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "KEY\tVALUE")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\n", key, current[key])
+		}
+		w.Flush()
+		return
+	}
+
+	set := make(map[string]string)
+	for _, limit := range limits {
+		parts := strings.SplitN(limit, "=", 2)
+		checkArgument(len(parts) == 2 && parts[0] != "", "Invalid limit %s, use key=value", limit)
+		set[parts[0]] = parts[1]
+	}
+
+	if len(remove) > 0 {
+		log.Check(log.ErrorLevel, "Removing limits of "+name, container.RemoveLimits(name, remove))
+	}
+	if len(set) > 0 {
+		checkValid(container.SetLimits(name, set))
+	}
+	if container.State(name) == container.Running {
+		log.Info("Prlimits and removed limits apply after restart of " + name)
+	}
+}
@@ -3,11 +3,22 @@ package cli
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"runtime"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/util"
 	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/metrics/cgroup"
+	"github.com/subutai-io/agent/log"
+	"github.com/wunderlist/ttlcache"
 	"sort"
 )
 
@@ -47,20 +58,23 @@ func printList(list []string, c, t, i, p bool) {
 }
 
 // LxcList function shows a listing of Subutai instances with information such as IP address, parent template, etc.
-func LxcList(name string, c, t, i, p bool) {
+// Only containers of tenant are listed if tenant is set, templates are shared by all tenants
+func LxcList(name, tenant string, c, t, i, p bool) {
 	var list []string
 	if i {
 		if name == "" {
-			for _, item := range container.Containers() {
+			for _, item := range containersOf(tenant) {
 				list = append(list, info(item)...)
 			}
 		} else {
 			list = append(list, info(name)...)
 		}
-	} else if c == t {
+	} else if c == t && tenant == "" {
 		list = append(list, container.All()...)
+	} else if c == t {
+		list = append(append(list, containersOf(tenant)...), container.Templates()...)
 	} else if c {
-		list = append(list, container.Containers()...)
+		list = append(list, containersOf(tenant)...)
 	} else if t {
 		list = append(list, container.Templates()...)
 	}
@@ -80,13 +94,22 @@ func LxcList(name string, c, t, i, p bool) {
 
 }
 
+// containersOf returns names of containers of tenant, or of all containers if tenant is not set
+func containersOf(tenant string) []string {
+	if tenant == "" {
+		return container.Containers()
+	}
+	return tenantContainers(tenant)
+}
+
 // addParent adds parent to each template in list
 func addParent(list []string) []string {
 	for i := range list {
 		name := strings.Fields(list[i])[0]
-		parent := strings.TrimSpace(container.GetProperty(name, "subutai.parent")) + ":" +
-			strings.TrimSpace(container.GetProperty(name, "subutai.parent.owner")) + ":" +
-			strings.TrimSpace(container.GetProperty(name, "subutai.parent.version"))
+		props := container.GetProperties(name, "subutai.parent", "subutai.parent.owner", "subutai.parent.version")
+		parent := strings.TrimSpace(props["subutai.parent"]) + ":" +
+			strings.TrimSpace(props["subutai.parent.owner"]) + ":" +
+			strings.TrimSpace(props["subutai.parent.version"])
 		if name == parent {
 			list[i] = list[i] + "\t"
 		} else {
@@ -100,3 +123,240 @@ func addParent(list []string) []string {
 func info(name string) (result []string) {
 	return append(result, name+"\t"+container.State(name)+"\t"+container.GetIp(name)+"\t"+container.ContainerDefaultIface)
 }
+
+// Sort keys of resource usage listing
+const (
+	SortByName   = "name"
+	SortByCpu    = "cpu"
+	SortByRam    = "ram"
+	SortByDisk   = "disk"
+	SortByUptime = "uptime"
+)
+
+//refresh interval of watch mode
+const watchInterval = time.Second * 2
+
+//linux USER_HZ, clock ticks per second used in /proc/<pid>/stat
+const clockTicks = 100
+
+type containerUsage struct {
+	name   string
+	state  string
+	ip     string
+	parent string
+	//-1 if not available
+	cpu    float64
+	ram    int64
+	disk   int64
+	uptime time.Duration
+}
+
+// usageCollector keeps previous cpu samples to calculate cpu load between refreshes
+// and caches disk usage which is expensive to query
+type usageCollector struct {
+	cpuSamples map[string]cgroup.CPUSample
+	diskCache  *ttlcache.Cache
+}
+
+// LxcListUsage shows containers with their current resource usage: CPU load, RAM, disk usage and uptime.
+// CPU load is measured over a short interval, in watch mode over the interval between refreshes
+//
+// subutai list containers --usage --sort-by cpu --watch
+func LxcListUsage(name, tenant string, p bool, sortBy string, watch bool) {
+	if sortBy == "" {
+		sortBy = SortByName
+	}
+	checkArgument(sortBy == SortByName || sortBy == SortByCpu || sortBy == SortByRam || sortBy == SortByDisk ||
+		sortBy == SortByUptime, "Unknown sort key %s", sortBy)
+
+	names := containersOf(tenant)
+	if name != "" {
+		checkState(container.IsContainer(name), "Container %s not found", name)
+		names = []string{name}
+	}
+
+	collector := &usageCollector{cpuSamples: make(map[string]cgroup.CPUSample), diskCache: util.GetCache(time.Second * 30)}
+
+	//prime cpu samples
+	collector.collect(names)
+	time.Sleep(time.Second)
+
+	for {
+		usages := collector.collect(names)
+		sortUsages(usages, sortBy)
+
+		if watch {
+			//clear screen
+			fmt.Print("\033[H\033[2J")
+		}
+		printUsages(usages, p)
+
+		if !watch {
+			return
+		}
+		time.Sleep(watchInterval)
+
+		if name == "" {
+			names = containersOf(tenant)
+		}
+	}
+}
+
+func (c *usageCollector) collect(names []string) []containerUsage {
+	rt := container.GetRuntime()
+	var usages []containerUsage
+
+	for _, name := range names {
+		u := containerUsage{name: name, state: rt.State(name), cpu: -1, ram: -1, disk: -1}
+
+		u.disk, _ = strconv.ParseInt(util.GetFromCacheOrCalculate(c.diskCache, name, func() string {
+			used, err := fs.DatasetDiskUsage(name)
+			if log.Check(log.DebugLevel, "Getting disk usage of "+name, err) {
+				return ""
+			}
+			return strconv.Itoa(used)
+		}), 10, 64)
+
+		u.parent = parentRef(name)
+
+		if u.state != container.Running {
+			delete(c.cpuSamples, name)
+			usages = append(usages, u)
+			continue
+		}
+
+		u.ip = container.GetIp(name)
+
+		if ram, err := cgroup.MemoryUsage(rt, name); err == nil {
+			u.ram = int64(ram)
+		}
+
+		if sample, err := cgroup.SampleCPU(rt, name); err == nil {
+			if cores, ok := cgroup.CPURate(c.cpuSamples[name], sample); ok {
+				//load is relative to all cores
+				u.cpu = cores * 100 / float64(runtime.NumCPU())
+			}
+			c.cpuSamples[name] = sample
+		}
+
+		if pid := rt.InitPid(name); pid > 0 {
+			uptime, err := processUptime(pid)
+			if !log.Check(log.DebugLevel, "Getting uptime of "+name, err) {
+				u.uptime = uptime
+			}
+		}
+
+		usages = append(usages, u)
+	}
+
+	return usages
+}
+
+func sortUsages(usages []containerUsage, sortBy string) {
+	sort.SliceStable(usages, func(i, j int) bool {
+		switch sortBy {
+		case SortByCpu:
+			return usages[i].cpu > usages[j].cpu
+		case SortByRam:
+			return usages[i].ram > usages[j].ram
+		case SortByDisk:
+			return usages[i].disk > usages[j].disk
+		case SortByUptime:
+			return usages[i].uptime > usages[j].uptime
+		}
+		return usages[i].name < usages[j].name
+	})
+}
+
+func printUsages(usages []containerUsage, p bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+
+	header := "NAME\tSTATE\tIP\tCPU%\tRAM\tDISK\tUPTIME"
+	if p {
+		header += "\tPARENT"
+	}
+	fmt.Fprintln(w, header)
+
+	for _, u := range usages {
+		cpu, ram, disk, uptime := "-", "-", "-", "-"
+		if u.cpu >= 0 {
+			cpu = fmt.Sprintf("%.1f", u.cpu)
+		}
+		if u.ram >= 0 {
+			ram = formatSize(u.ram)
+		}
+		if u.disk >= 0 {
+			disk = formatSize(u.disk)
+		}
+		if u.uptime > 0 {
+			uptime = u.uptime.Round(time.Second).String()
+		}
+
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", u.name, u.state, u.ip, cpu, ram, disk, uptime)
+		if p {
+			line += "\t" + u.parent
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	w.Flush()
+}
+
+func parentRef(name string) string {
+	props := container.GetProperties(name, "subutai.parent", "subutai.parent.owner", "subutai.parent.version")
+	parent := strings.TrimSpace(props["subutai.parent"])
+	if parent == "" {
+		return ""
+	}
+	return parent + ":" + strings.TrimSpace(props["subutai.parent.owner"]) + ":" +
+		strings.TrimSpace(props["subutai.parent.version"])
+}
+
+// processUptime calculates how long process is running from its start time in /proc/<pid>/stat
+func processUptime(pid int) (time.Duration, error) {
+	data, err := ioutil.ReadFile(path.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	//command name in parentheses may contain spaces, fields are counted after it
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return 0, errors.Errorf("Failed to parse stat of process %d", pid)
+	}
+	//starttime is the 22nd field, i.e. the 20th after pid and command name
+	startTicks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	bootTime, err := bootTime()
+	if err != nil {
+		return 0, err
+	}
+
+	start := bootTime.Add(time.Duration(startTicks) * time.Second / clockTicks)
+
+	return time.Since(start), nil
+}
+
+func bootTime() (time.Time, error) {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "btime" {
+			btime, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(btime, 0), nil
+		}
+	}
+
+	return time.Time{}, errors.New("Failed to parse boot time from /proc/stat")
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// ContainerLogs prints latest records of container journal, as JSON array for Console UI with json flag.
+// Containers which do not run journald have no records
+//
+// subutai logs foo [-n 100] [--json]
+func ContainerLogs(name string, lines int, asJson bool) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkArgument(lines > 0, "Number of lines must be positive")
+
+	entries, err := container.ReadJournal(name, lines, time.Time{})
+	log.Check(log.ErrorLevel, "Reading container logs", err)
+
+	if asJson {
+		if entries == nil {
+			entries = []container.JournalEntry{}
+		}
+		out, err := json.Marshal(entries)
+		log.Check(log.ErrorLevel, "Marshalling container logs", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	for _, entry := range entries {
+		source := entry.Identifier
+		if source == "" {
+			source = entry.Unit
+		}
+		fmt.Printf("%s %s: %s\n", entry.Time.Format(time.Stamp), source, entry.Message)
+	}
+}
@@ -0,0 +1,338 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+	"gopkg.in/yaml.v2"
+)
+
+// LXD unified image is a tarball holding metadata.yaml and root filesystem in rootfs directory
+const lxdMetadataFile = "metadata.yaml"
+
+var lxdNameRx = regexp.MustCompile(`[^a-z0-9]+`)
+
+// lxdMetadata is the part of LXD image metadata agent reads and writes, templates of the image are not supported
+type lxdMetadata struct {
+	Architecture string            `yaml:"architecture"`
+	CreationDate int64             `yaml:"creation_date"`
+	Properties   map[string]string `yaml:"properties,omitempty"`
+}
+
+// LxdImport installs LXD unified image as template without parent. Root filesystem of the image is split
+// to partitions of Subutai template and lxc settings are taken from config of base template, by default from
+// installed template without parent. Template name is derived from os and release properties of image unless set
+//
+// subutai template lxd-import {archive} [-n {name}] [-o {owner}] [-r {version}] [--base {ref}]
+func LxdImport(archive, name, owner, version, base string) {
+	checkState(fs.DatasetExists(""), "Root dataset %s not mounted", config.Agent.Dataset)
+	checkState(fs.FileExists(archive), "Image %s not found", archive)
+
+	version = strings.TrimSpace(version)
+	if version == "" {
+		version = "1.0.0"
+	}
+	checkArgument(versionRx.MatchString(version), "Version must be in form X.Y.Z")
+
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		owner = config.Agent.TemplateOwner
+	}
+	if owner == "" {
+		owner = "local"
+	}
+
+	baseRef := lxdBaseTemplate(base)
+
+	//image is unpacked with tar to keep ownership and special files of root filesystem
+	staging, err := ioutil.TempDir(config.Agent.CacheDir, "lxd_")
+	log.Check(log.ErrorLevel, "Creating temporary directory", err)
+	defer os.RemoveAll(staging)
+
+	//datasets are removed if import fails once they are created
+	templateRef := ""
+	checkImport := func(msg string, err error) {
+		if err != nil {
+			if templateRef != "" {
+				log.Check(log.WarnLevel, "Removing datasets", fs.RemoveDataset(templateRef, true))
+			}
+			log.Check(log.WarnLevel, "Removing temporary directory", os.RemoveAll(staging))
+			log.Error(msg + ": " + err.Error())
+		}
+	}
+
+	log.Info("Unpacking image " + archive)
+	result, err := exec.Run(context.Background(), exec.Options{Timeout: -1},
+		"tar", "--numeric-owner", "-xpf", archive, "-C", staging)
+	if err != nil {
+		err = errors.Errorf("%s %s", strings.TrimSpace(string(result.Stderr)), err.Error())
+	}
+	checkImport("Unpacking image", err)
+
+	metadata, err := readLxdMetadata(path.Join(staging, lxdMetadataFile))
+	checkImport("Reading image metadata", err)
+	if !fs.FileExists(path.Join(staging, "rootfs")) {
+		checkImport("Reading image", errors.New("Image has no root filesystem, only unified LXD images are supported"))
+	}
+	//image without architecture is taken as built for host
+	arch := lxdArchitecture(runtime.GOARCH)
+	if metadata.Architecture != "" && metadata.Architecture != arch {
+		log.Warn("Image is built for " + metadata.Architecture + ", host architecture is " + arch)
+		arch = metadata.Architecture
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = strings.Trim(lxdNameRx.ReplaceAllString(
+			strings.ToLower(metadata.Properties["os"]+"-"+metadata.Properties["release"]), "-"), "-")
+		if name == "" {
+			checkImport("Reading image", errors.New("Image does not name its os and release, specify template name with -n"))
+		}
+	}
+	ref, err := templ.NewRef(name, owner, version)
+	if err == nil {
+		err = container.ValidateNewName(ref.Name)
+	}
+	checkImport("Validating template name", err)
+
+	lock := common.AcquireLocks(common.LockKey{Kind: common.TemplateLock, Name: ref.String()})
+	defer lock.Release()
+
+	if fs.DatasetExists(ref.String()) {
+		checkImport("Installing template", errors.New(ref.String()+" exists"))
+	}
+
+	//!important used by Console
+	log.Info("Installing template " + ref.String())
+	checkImport("Creating dataset", fs.CreateDataset(ref.String()))
+	templateRef = ref.String()
+	for _, partition := range fs.ChildDatasets {
+		checkImport("Creating dataset", fs.CreateDataset(path.Join(templateRef, partition)))
+	}
+	checkImport("Copying root filesystem", copyRootfs(path.Join(staging, "rootfs"), templateRef))
+
+	//template without parent refers to itself
+	conf := templateConfig(owner, version, "tiny")
+	conf = append(conf,
+		[]string{"subutai.template", name},
+		[]string{"subutai.parent", name},
+		[]string{"subutai.parent.owner", owner},
+		[]string{"subutai.parent.version", version},
+		[]string{"lxc.arch", arch},
+	)
+	if common.GetMajorVersion() < 3 {
+		conf = append(conf, []string{"lxc.utsname", name})
+	} else {
+		conf = append(conf, []string{"lxc.uts.name", name})
+	}
+	confPath := path.Join(config.Agent.LxcPrefix, templateRef, "config")
+	checkImport("Copying config of base template "+baseRef,
+		fs.Copy(path.Join(config.Agent.LxcPrefix, baseRef, "config"), confPath))
+	checkImport("Setting template config", updateTemplateConfig(confPath, conf))
+	checkImport("Setting lxc config", updateContainerConfig(templateRef))
+
+	for _, partition := range fs.ChildDatasets {
+		dataset := path.Join(templateRef, partition)
+		checkImport("Creating snapshot", fs.CreateSnapshot(dataset+"@now", false))
+		checkImport("Setting partition read-only", fs.SetDatasetReadOnly(dataset))
+	}
+
+	log.Info("Image " + archive + " imported as template " + templateRef)
+}
+
+// lxdBaseTemplate returns reference of installed template lxc settings of imported image are taken from
+func lxdBaseTemplate(base string) string {
+	if base = strings.TrimSpace(base); base != "" {
+		ref, err := templ.ParseFullRef(base)
+		checkValid(err)
+		checkState(container.IsTemplate(ref.String()), "Template %s not found", ref.String())
+		return ref.String()
+	}
+
+	templates := container.Templates()
+	sort.Strings(templates)
+	for _, template := range templates {
+		parent, err := container.ParentRef(template)
+		if err == nil && parent.String() == template {
+			return template
+		}
+	}
+
+	log.Error("No template without parent is installed to take lxc settings from, import one or specify it with --base")
+	return ""
+}
+
+func readLxdMetadata(file string) (lxdMetadata, error) {
+	var metadata lxdMetadata
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return metadata, errors.New("Image has no " + lxdMetadataFile + ", only unified LXD images are supported")
+	} else if err != nil {
+		return metadata, err
+	}
+
+	err = yaml.Unmarshal(data, &metadata)
+
+	return metadata, errors.Wrap(err, "Parsing "+lxdMetadataFile)
+}
+
+// LxdExport packs installed template into LXD unified image: partitions are merged back to single root
+// filesystem owned by container root and metadata.yaml describes the template. Image is written to
+// cache directory unless other directory is specified, it can be imported with "lxc image import"
+//
+// subutai template lxd-export {ref} [-d {dir}]
+func LxdExport(template, dir string) {
+	ref, err := templ.ParseFullRef(template)
+	checkValid(err)
+	templateRef := ref.String()
+	checkState(container.IsTemplate(templateRef), "Template %s not found", templateRef)
+
+	dir = strings.TrimSpace(dir)
+	if dir == "" {
+		dir = config.Agent.CacheDir
+	}
+	image := path.Join(dir, ref.Name+"-"+ref.Version+"-lxd.tar.gz")
+
+	//template must not be destroyed while its partitions are copied
+	lock := common.AcquireLocks(common.LockKey{Kind: common.TemplateLock, Name: templateRef})
+	defer lock.Release()
+
+	staging, err := ioutil.TempDir(config.Agent.CacheDir, "lxd_")
+	log.Check(log.ErrorLevel, "Creating temporary directory", err)
+	defer os.RemoveAll(staging)
+	rootfs := path.Join(staging, "rootfs")
+
+	checkExport := func(msg string, err error) {
+		if err != nil {
+			log.Check(log.WarnLevel, "Removing temporary directory", os.RemoveAll(staging))
+			os.Remove(image)
+			log.Error(msg + ": " + err.Error())
+		}
+	}
+
+	log.Info("Copying filesystem of " + templateRef)
+	source := path.Join(config.Agent.LxcPrefix, templateRef)
+	for _, partition := range fs.ChildDatasets {
+		target := rootfs
+		if isMountedPartition(partition) {
+			target = path.Join(rootfs, partition)
+		}
+		checkExport("Creating directory "+target, os.MkdirAll(target, 0755))
+
+		result, err := exec.Run(context.Background(), exec.Options{Timeout: -1},
+			"cp", "-a", path.Join(source, partition)+"/.", target)
+		if err != nil {
+			err = errors.Errorf("%s %s", strings.TrimSpace(string(result.Stderr)), err.Error())
+		}
+		checkExport("Copying "+partition, err)
+	}
+
+	//LXD shifts ids of image itself, so files are owned by root of container in image
+	info, err := os.Stat(rootfs)
+	checkExport("Reading root filesystem owner", err)
+	if uid := info.Sys().(*syscall.Stat_t).Uid; uid != 0 {
+		checkExport("Shifting ids of root filesystem",
+			exec.Exec("uidmapshift", "-b", rootfs, strconv.Itoa(int(uid)), "0", "65536"))
+	}
+
+	metadata := lxdMetadata{
+		Architecture: lxdArchitecture(runtime.GOARCH),
+		CreationDate: time.Now().Unix(),
+		Properties:   osRelease(rootfs),
+	}
+	metadata.Properties["name"] = ref.Name
+	metadata.Properties["description"] = fmt.Sprintf("Subutai template %s", templateRef)
+	metadata.Properties["architecture"] = metadata.Architecture
+	data, err := yaml.Marshal(metadata)
+	checkExport("Encoding "+lxdMetadataFile, err)
+	checkExport("Writing "+lxdMetadataFile, ioutil.WriteFile(path.Join(staging, lxdMetadataFile), data, 0644))
+
+	log.Info("Packing image " + image)
+	result, err := exec.Run(context.Background(), exec.Options{Timeout: -1},
+		"tar", "--numeric-owner", "-czf", image, "-C", staging, lxdMetadataFile, "rootfs")
+	if err != nil {
+		err = errors.Errorf("%s %s", strings.TrimSpace(string(result.Stderr)), err.Error())
+	}
+	checkExport("Packing image", err)
+
+	log.Info(templateRef + " exported to " + image)
+}
+
+// osRelease returns os and release image properties read from os-release file of root filesystem
+func osRelease(rootfs string) map[string]string {
+	properties := make(map[string]string)
+
+	for _, file := range []string{"etc/os-release", "usr/lib/os-release"} {
+		//absolute symlinks would point to files of host
+		file = path.Join(rootfs, file)
+		if info, err := os.Lstat(file); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+
+		values := make(map[string]string)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+			if len(parts) == 2 {
+				values[parts[0]] = strings.Trim(parts[1], `"'`)
+			}
+		}
+		f.Close()
+
+		release := values["VERSION_CODENAME"]
+		if release == "" {
+			release = values["VERSION_ID"]
+		}
+		for key, value := range map[string]string{"os": values["ID"], "release": release} {
+			if value != "" {
+				properties[key] = value
+			}
+		}
+		break
+	}
+
+	return properties
+}
+
+// lxdArchitecture returns name of architecture used by LXD for Go architecture
+func lxdArchitecture(goarch string) string {
+	switch goarch {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		return "aarch64"
+	case "arm":
+		return "armv7l"
+	case "ppc64le":
+		return "ppc64le"
+	case "s390x":
+		return "s390x"
+	}
+	return goarch
+}
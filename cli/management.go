@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/log"
+)
+
+//interval between management readiness checks
+const readinessCheckInterval = time.Second * 5
+
+// managementResponds checks if management answers HTTP requests at url, any HTTP status means it is up
+func managementResponds(url string) error {
+	resp, err := util.GetClient(true, 5).Get(url)
+	if err != nil {
+		return err
+	}
+	util.Close(resp)
+
+	return nil
+}
+
+// waitForManagement polls management at url until it responds or deadline is reached
+func waitForManagement(url string, deadline time.Time) error {
+	var err error
+	for {
+		if err = managementResponds(url); err == nil {
+			return nil
+		}
+
+		if state := container.State(container.Management); state != container.Running {
+			return errors.Errorf("Management container is %s", state)
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("Management did not respond at %s before timeout, last error: %s. "+
+				"Check management container with \"subutai attach management\"", url, err.Error())
+		}
+
+		log.Debug("Management is not ready yet: " + err.Error())
+		time.Sleep(readinessCheckInterval)
+	}
+}
+
+// setupManagementProxies maps management ports on host and retries until management responds through the proxy
+func setupManagementProxies(deadline time.Time) error {
+	var err error
+	for {
+		//ports of management container exposed on host
+		for _, port := range []string{config.Management.Port, config.Management.SecurePort, config.Management.MetricsPort} {
+			//TODO use proxy lib
+			tag := "management-" + port
+			log.Check(log.DebugLevel, "Setting up proxy for port "+port,
+				exec.Exec("subutai", "proxy", "create", "-t", tag, "-p", "tcp", "-e", port))
+			log.Check(log.DebugLevel, "Redirecting port "+port+" to management container",
+				exec.Exec("subutai", "proxy", "srv", "add", "-t", tag, "-s", container.ManagementIp+":"+port))
+		}
+
+		if err = managementResponds("https://127.0.0.1:" + config.Management.Port); err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("Management did not respond through proxy on port %s before timeout, last error: %s. "+
+				"Check proxies with \"subutai map list\"", config.Management.Port, err.Error())
+		}
+
+		log.Debug("Management is not reachable through proxy yet: " + err.Error())
+		time.Sleep(readinessCheckInterval)
+	}
+}
+
+// ManagementReinit re-runs bootstrap of existing management container, e.g. after it partially failed.
+// By default management is re-created from its template, with keepData flag container filesystem and
+// GPG key are kept and only network, proxies and db record are configured again
+//
+// subutai management reinit [--keep-data]
+func ManagementReinit(keepData bool) {
+	checkState(container.IsContainer(container.Management),
+		"Management container %s not found, import management template first", container.Management)
+
+	if container.State(container.Management) == container.Running {
+		log.Check(log.ErrorLevel, "Stopping management container", container.Stop(container.Management))
+	}
+
+	if keepData {
+		keyExists := fs.FileExists(path.Join(config.Agent.LxcPrefix, container.Management, "public.pub"))
+		setupManagement(!keyExists)
+		printManagementBanner(loadManagementCredentials())
+		return
+	}
+
+	parent, err := container.ParentRef(container.Management)
+	log.Check(log.ErrorLevel, "Reading management template reference", err)
+	templateRef := parent.String()
+	checkState(container.IsTemplate(templateRef), "Management template %s not found", templateRef)
+
+	log.Check(log.ErrorLevel, "Destroying management container", destroy(container.Management))
+
+	initManagement(templateRef)
+}
+
+const (
+	managementAdmin = "admin"
+	//password of management template, in effect until it is changed
+	defaultManagementPassword = "secret"
+	passwordLength            = 16
+	passwordAlphabet          = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// ManagementCredentials holds management admin credentials kept in agent secrets store
+type ManagementCredentials struct {
+	User     string    `json:"user"`
+	Password string    `json:"password"`
+	Changed  time.Time `json:"changed"`
+}
+
+// ManagementCredentialsCmd prints management admin credentials, with rotate flag it generates new password
+// and sets it inside management container first
+//
+// subutai management credentials [--rotate]
+func ManagementCredentialsCmd(rotate bool) {
+	checkState(container.IsContainer(container.Management), "Management container %s not found", container.Management)
+
+	creds := loadManagementCredentials()
+
+	if rotate {
+		checkState(container.State(container.Management) == container.Running, "Management container is not running")
+
+		password, err := generatePassword()
+		log.Check(log.ErrorLevel, "Generating password", err)
+		log.Check(log.ErrorLevel, "Setting password in management container", setManagementPassword(creds.User, password))
+
+		creds = ManagementCredentials{User: creds.User, Password: password, Changed: time.Now()}
+		log.Check(log.ErrorLevel, "Saving management credentials", saveManagementCredentials(creds))
+		log.Info("Management password rotated")
+	}
+
+	fmt.Printf("login: %s\npassword: %s\n", creds.User, creds.Password)
+}
+
+// resetManagementCredentials replaces default password of freshly bootstrapped management with generated one.
+// If password can not be set, default credentials are kept so that stored ones always match management
+func resetManagementCredentials() ManagementCredentials {
+	creds := ManagementCredentials{User: managementAdmin, Password: defaultManagementPassword, Changed: time.Now()}
+
+	password, err := generatePassword()
+	if !log.Check(log.WarnLevel, "Generating password", err) {
+		err = setManagementPassword(creds.User, password)
+		if !log.Check(log.WarnLevel, "Setting password in management container, default password is kept", err) {
+			creds.Password = password
+		}
+	}
+
+	log.Check(log.ErrorLevel, "Saving management credentials", saveManagementCredentials(creds))
+
+	return creds
+}
+
+// loadManagementCredentials returns stored credentials, management bootstrapped by older agents has default ones
+func loadManagementCredentials() ManagementCredentials {
+	data, err := db.GetSecret(db.ManagementCredentialsSecret)
+	log.Check(log.ErrorLevel, "Reading management credentials", err)
+
+	creds := ManagementCredentials{User: managementAdmin, Password: defaultManagementPassword}
+	if data != "" {
+		log.Check(log.ErrorLevel, "Parsing management credentials", json.Unmarshal([]byte(data), &creds))
+	}
+
+	return creds
+}
+
+func saveManagementCredentials(creds ManagementCredentials) error {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+
+	return db.SaveSecret(db.ManagementCredentialsSecret, string(data))
+}
+
+// setManagementPassword runs password command inside management container,
+// credentials are passed via environment to keep them out of process list
+func setManagementPassword(user, password string) error {
+	var stderr bytes.Buffer
+	exitCode, err := container.GetRuntime().Exec(container.Management, []string{"/bin/sh", "-c", config.Management.PasswordCommand},
+		container.ExecOptions{Env: []string{"SUBUTAI_USER=" + user, "SUBUTAI_PASSWORD=" + password}, Stderr: &stderr})
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return errors.Errorf("%s exited with code %d: %s", config.Management.PasswordCommand, exitCode, stderr.String())
+	}
+
+	return nil
+}
+
+func generatePassword() (string, error) {
+	buf := make([]byte, passwordLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	for i := range buf {
+		buf[i] = passwordAlphabet[int(buf[i])%len(passwordAlphabet)]
+	}
+
+	return string(buf), nil
+}
+
+func printManagementBanner(creds ManagementCredentials) {
+	log.Info("********************")
+	log.Info("Subutai Management UI is available at https://" + net.GetIp() + ":" + config.Management.Port)
+	log.Info("login: " + creds.User)
+	log.Info("password: " + creds.Password)
+	log.Info("Credentials can be retrieved later with \"subutai management credentials\"")
+	log.Info("********************")
+}
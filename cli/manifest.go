@@ -0,0 +1,276 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/signing"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+const manifestFile = "manifest.json"
+
+// Manifest describes template and is bundled into template archive on export,
+// so that local archives are self-describing and do not need CDN lookups or config parsing on import
+type Manifest struct {
+	Name         string            `json:"name"`
+	Owner        string            `json:"owner"`
+	Version      string            `json:"version"`
+	Parent       string            `json:"parent"`
+	ParentChain  []string          `json:"parent-chain"`
+	PrefSize     string            `json:"pref-size"`
+	DigestMethod string            `json:"digest-method"`
+	Deltas       map[string]string `json:"deltas"`
+	//digests of other files of archive, e.g. container config, keyed by path in archive
+	Files map[string]string `json:"files,omitempty"`
+	//signature of manifest made with key of exporting host, see "subutai keys"
+	SigningKey string `json:"signing-key,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+	TemplateMetadata
+}
+
+// TemplateMetadata describes provenance of template shared across organizations, it is set on export, carried
+// in manifest and kept in db once template is installed
+type TemplateMetadata struct {
+	Description string   `json:"description,omitempty"`
+	License     string   `json:"license,omitempty"`
+	Homepage    string   `json:"homepage,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// IsEmpty returns true if no metadata is set
+func (m TemplateMetadata) IsEmpty() bool {
+	return m.Description == "" && m.License == "" && m.Homepage == "" && len(m.Tags) == 0
+}
+
+// validate trims metadata and checks homepage and tags, tags may be given comma separated
+func (m *TemplateMetadata) validate() error {
+	m.Description = strings.TrimSpace(m.Description)
+	m.License = strings.TrimSpace(m.License)
+	m.Homepage = strings.TrimSpace(m.Homepage)
+	if m.Homepage != "" {
+		u, err := url.Parse(m.Homepage)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("Invalid homepage %s, http or https url expected", m.Homepage)
+		}
+	}
+
+	var tags []string
+	for _, list := range m.Tags {
+		for _, tag := range strings.Split(list, ",") {
+			if tag = strings.TrimSpace(tag); tag == "" {
+				continue
+			}
+			if strings.ContainsAny(tag, " \t") {
+				return errors.Errorf("Invalid tag %q", tag)
+			}
+			if !stringInList(tag, tags) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	m.Tags = tags
+
+	return nil
+}
+
+// saveTemplateMetadata keeps metadata of installed template in db, nothing is saved if metadata is empty
+func saveTemplateMetadata(templateRef string, meta TemplateMetadata) error {
+	if meta.IsEmpty() {
+		return nil
+	}
+
+	return db.SaveTemplateMeta(&db.TemplateMeta{Template: templateRef, Description: meta.Description,
+		License: meta.License, Homepage: meta.Homepage, Tags: meta.Tags})
+}
+
+// templateMetadata returns metadata of installed template kept in db
+func templateMetadata(templateRef string) (TemplateMetadata, error) {
+	meta, err := db.FindTemplateMeta(templateRef)
+	if err != nil || meta == nil {
+		return TemplateMetadata{}, err
+	}
+
+	return TemplateMetadata{Description: meta.Description, License: meta.License, Homepage: meta.Homepage,
+		Tags: meta.Tags}, nil
+}
+
+// Ref returns full template reference in form name:owner:version
+func (m Manifest) Ref() string {
+	return templ.Ref{Name: m.Name, Owner: m.Owner, Version: m.Version}.String()
+}
+
+// writeManifest calculates digests of deltas and other files found in dir and saves manifest there
+func writeManifest(dir string, manifest Manifest) error {
+	manifest.DigestMethod = Sha256DigestMethod
+	manifest.Deltas = make(map[string]string)
+	for _, partition := range fs.ChildDatasets {
+		hash, err := fs.Sha256Sum(path.Join(dir, "deltas", partition+".delta"))
+		if err != nil {
+			return err
+		}
+		manifest.Deltas[partition] = hash
+	}
+	var err error
+	if manifest.Files, err = archiveFiles(dir); err != nil {
+		return err
+	}
+
+	//manifest holds digests of deltas and other files, so its signature covers whole template
+	key, signature, err := signing.Sign(manifest.signedData())
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		manifest.SigningKey, manifest.Signature = key, base64.StdEncoding.EncodeToString(signature)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(dir, manifestFile), data, 0644)
+}
+
+// readManifest reads manifest from extracted template dir, returns nil if archive has no manifest
+func readManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// archiveFiles returns digests of files found in template dir except deltas and manifest itself,
+// files other than regular ones are refused
+func archiveFiles(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if info.IsDir() {
+			if name == "deltas" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name == manifestFile {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return errors.Errorf("%s is not a regular file", name)
+		}
+
+		files[name], err = fs.Sha256Sum(file)
+		return err
+	})
+
+	return files, err
+}
+
+// verifyManifestFiles checks that files extracted from archive to dir, e.g. container config which is installed
+// as is, are exactly those listed in manifest
+func verifyManifestFiles(dir string, manifest *Manifest) error {
+	if manifest.Files == nil {
+		return errors.New("Manifest has no digests of template files, export template again")
+	}
+
+	files, err := archiveFiles(dir)
+	if err != nil {
+		return err
+	}
+	for name, digest := range files {
+		if expected, ok := manifest.Files[name]; !ok {
+			return errors.Errorf("File %s is not listed in manifest", name)
+		} else if expected != digest {
+			return errors.Errorf("Digest of file %s does not match manifest", name)
+		}
+	}
+	for name := range manifest.Files {
+		if _, ok := files[name]; !ok {
+			return errors.Errorf("File %s listed in manifest is missing", name)
+		}
+	}
+
+	return nil
+}
+
+// signedData returns content of manifest covered by its signature
+func (m Manifest) signedData() []byte {
+	m.SigningKey, m.Signature = "", ""
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// verifyManifest checks signature of manifest of local template archive and tells if it is signed with own or
+// trusted key. Once keys of other hosts are trusted, only archives signed with trusted or own keys are accepted,
+// otherwise invalid signature is only warned about
+func verifyManifest(manifest *Manifest) (bool, error) {
+	required, err := signing.Required()
+	if err != nil {
+		return false, errors.Wrap(err, "Reading signing keys")
+	}
+
+	if manifest == nil || manifest.Signature == "" {
+		if required {
+			return false, errors.New("Template archive is not signed, only archives signed with trusted keys are accepted")
+		}
+		return false, nil
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	if err == nil {
+		err = signing.Verify(manifest.SigningKey, manifest.signedData(), signature)
+	}
+	if err != nil && !required {
+		log.Warn("Signature of template archive is not verified: " + err.Error())
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// getParentChain returns references of installed ancestors starting from parentRef up to the root template
+func getParentChain(parentRef string) []string {
+	var chain []string
+	seen := make(map[string]bool)
+
+	for ref := parentRef; ref != "" && !seen[ref]; {
+		seen[ref] = true
+		chain = append(chain, ref)
+
+		parent, err := container.ParentRef(ref)
+		if err != nil {
+			break
+		}
+		ref = parent.String()
+	}
+
+	return chain
+}
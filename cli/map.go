@@ -7,12 +7,18 @@
 package cli
 
 import (
-	"strings"
+	"encoding/json"
+	"fmt"
 	"github.com/subutai-io/agent/config"
-	"github.com/subutai-io/agent/log"
+	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/lib/proxy"
+	"github.com/subutai-io/agent/log"
+	"net"
+	"os"
 	"path"
-	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 var (
@@ -47,6 +53,7 @@ func RemovePortMapping(protocol, domain string, port int, server string) {
 	}
 
 	if server != "" {
+		server = resolveServer(server)
 		err := proxy.RemoveProxiedServer(tag, server)
 		log.Check(log.ErrorLevel, "Removing server", err)
 
@@ -64,7 +71,7 @@ func RemovePortMapping(protocol, domain string, port int, server string) {
 	}
 }
 
-func AddPortMapping(protocol, domain, loadBalancing string, port int, server, certPath string, redirect80Port, sslBackend, http2 bool) {
+func AddPortMapping(protocol, domain, loadBalancing string, port int, server, certPath string, redirect80Port, sslBackend, http2 bool, bind, reflect, set string) {
 	protocol = strings.ToLower(protocol)
 	domain = strings.ToLower(domain)
 
@@ -77,13 +84,85 @@ func AddPortMapping(protocol, domain, loadBalancing string, port int, server, ce
 	log.Check(log.ErrorLevel, "Getting proxy from db", err)
 
 	if prxy == nil {
-		err = proxy.CreateProxy(protocol, domain, loadBalancing, tag, port, redirect80Port, sslBackend, certPath, http2)
+		err = proxy.CreateProxy(protocol, domain, loadBalancing, tag, port, redirect80Port, sslBackend, certPath, http2, bind, reflect)
 		log.Check(log.ErrorLevel, "Creating proxy", err)
 		prxy, err = proxy.FindProxyByTag(tag)
 		log.Check(log.ErrorLevel, "Getting proxy from db", err)
 	}
 
-	err = proxy.AddProxiedServer(tag, server)
+	err = proxy.AddProxiedServer(tag, resolveServer(server), set)
 	log.Check(log.ErrorLevel, "Adding server", err)
 
 }
+
+// CheckPortMapping prints services and settings of host which would prevent mapping of port
+func CheckPortMapping(protocol, bind string, port int, asJson bool) {
+	conflicts, err := proxy.CheckPort(strings.ToLower(protocol), bind, port)
+	log.Check(log.ErrorLevel, "Checking port", err)
+
+	if asJson {
+		if conflicts == nil {
+			conflicts = []proxy.PortConflict{}
+		}
+		out, err := json.Marshal(conflicts)
+		log.Check(log.ErrorLevel, "Marshalling port conflicts", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	if len(conflicts) == 0 {
+		fmt.Printf("Port %d is free\n", port)
+		return
+	}
+	for _, c := range conflicts {
+		fmt.Println(c.String())
+	}
+}
+
+// ProxyStats prints requests (sessions for tcp and udp), bytes received and sent by proxies since their creation
+// and connections currently established to their ports
+func ProxyStats(tag string, asJson bool) {
+	stats, err := proxy.GetStats(tag)
+	log.Check(log.ErrorLevel, "Getting proxy statistics", err)
+
+	if asJson {
+		if stats == nil {
+			stats = []proxy.Stats{}
+		}
+		out, err := json.Marshal(stats)
+		log.Check(log.ErrorLevel, "Marshalling proxy statistics", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Tag\tProtocol\tPort\tDomain\tRequests\tIn\tOut\tConnections\tSince")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%d\t%d\t%d\t%s\n", s.Tag, s.Protocol, s.Port, s.Domain,
+			s.Requests, s.BytesIn, s.BytesOut, s.Connections, s.Since.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+// resolveServer replaces VM or container name in server socket with its ip,
+// e.g. win10:3389 becomes 192.168.122.10:3389
+func resolveServer(server string) string {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) != nil {
+		return server
+	}
+
+	var ips []string
+	if container.IsVM(host) {
+		ips, err = container.VmRuntime().IPAddresses(host, "")
+		log.Check(log.ErrorLevel, "Getting ip of VM "+host, err)
+	} else if container.IsContainer(host) {
+		ips = strings.Fields(container.GetIp(host))
+	} else {
+		return server
+	}
+
+	checkState(len(ips) > 0, "%s has no ip address", host)
+
+	return net.JoinHostPort(ips[0], port)
+}
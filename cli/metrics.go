@@ -17,6 +17,8 @@ var (
 	tableNet  = "host_net"
 	tableMem  = "host_memory"
 	tableDisk = "host_disk"
+	//traffic of port mappings, collected on host only
+	tableProxy = "host_proxy"
 
 	timeRange = "day"
 	timeGroup = "5m"
@@ -79,24 +81,41 @@ func GetHostMetrics(host, start, end string) string {
 	res, _ := queryInfluxDB(c, `
 			SELECT non_negative_derivative(mean(value),1s) as value
 			FROM `+ timeRange+ `.`+ tableCPU+ `
-			WHERE hostname = '`+ host+ `' AND time > '`+ start+ `' AND time < '`+ end+ `'
-			GROUP BY time(`+ timeGroup+ `), type fill(none);
+			WHERE hostname = '`+host+`' AND time > '`+start+`' AND time < '`+end+`'
+			GROUP BY time(`+timeGroup+`), type fill(none);
 
 			SELECT non_negative_derivative(mean(value),1s) as value
 			FROM `+ timeRange+ `.`+ tableNet+ `
-			WHERE hostname = '`+ host+ `' AND time > '`+ start+ `' AND time < '`+ end+ `'
-			GROUP BY time(`+ timeGroup+ `), iface, type fill(none);
+			WHERE hostname = '`+host+`' AND time > '`+start+`' AND time < '`+end+`'
+			GROUP BY time(`+timeGroup+`), iface, type fill(none);
 
 			SELECT mean(value) as value
 			FROM `+ timeRange+ `.`+ tableMem+ `
-			WHERE hostname = '`+ host+ `' AND time > '`+ start+ `' AND time < '`+ end+ `'
-			GROUP BY time(`+ timeGroup+ `), type fill(none);
+			WHERE hostname = '`+host+`' AND time > '`+start+`' AND time < '`+end+`'
+			GROUP BY time(`+timeGroup+`), type fill(none);
 
 			SELECT mean(value) as value
 			FROM `+ timeRange+ `.`+ tableDisk+ `
-			WHERE hostname = '`+ host+ `' AND time > '`+ start+ `' AND time < '`+ end+ `'
-			GROUP BY time(`+ timeGroup+ `), mount, type fill(none);
+			WHERE hostname = '`+host+`' AND time > '`+start+`' AND time < '`+end+`'
+			GROUP BY time(`+timeGroup+`), mount, type fill(none);
 		`)
+
+	if host == hostname {
+		//requests and bytes are counters, connections are current values
+		proxyRes, _ := queryInfluxDB(c, `
+			SELECT non_negative_derivative(mean(value),1s) as value
+			FROM `+timeRange+`.`+tableProxy+`
+			WHERE hostname = '`+host+`' AND type != 'connections' AND time > '`+start+`' AND time < '`+end+`'
+			GROUP BY time(`+timeGroup+`), tag, domain, type fill(none);
+
+			SELECT mean(value) as value
+			FROM `+timeRange+`.`+tableProxy+`
+			WHERE hostname = '`+host+`' AND type = 'connections' AND time > '`+start+`' AND time < '`+end+`'
+			GROUP BY time(`+timeGroup+`), tag, domain fill(none);
+		`)
+		res = append(res, proxyRes...)
+	}
+
 	out, _ := json.Marshal(res)
 
 	return "{\"Metrics\":" + string(out) + "}"
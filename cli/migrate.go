@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// ContainerMigrate moves container to another resource host over ssh. Partitions are sent while container runs,
+// then container is stopped and only changes made meanwhile are sent, so downtime is short. Container is restored
+// on target host by its agent, keeping environment and address in environment vlan, and started if it was running.
+// On failure everything created on target host is destroyed and container is started again. Migrated container
+// is kept stopped on this host unless destroy is set
+//
+// subutai migrate foo ssh://root@10.0.0.2 [--destroy] [--bwlimit 50M]
+func ContainerMigrate(name, target string, destroyLocal bool, bwLimit string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkArgument(name != container.Management, "Management container can not be migrated")
+	if !strings.Contains(target, "://") {
+		target = "ssh://root@" + target
+	}
+	remote := sshCommand(target)
+	rate, err := fs.TransferRate(bwLimit)
+	checkValid(err)
+	checkState(container.ParentSnapshot(name) == "now",
+		"Container %s is cloned from snapshot %s of its template and can not be migrated", name, container.ParentSnapshot(name))
+
+	cont, err := db.FindContainerByName(name)
+	log.Check(log.ErrorLevel, "Reading container metadata from db", err)
+	checkState(cont != nil, "Metadata of container %s not found", name)
+	parent, err := container.ParentRef(name)
+	log.Check(log.ErrorLevel, "Reading parent template of "+name, err)
+
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: name})
+	defer lock.Release()
+
+	//remote subutai commands limit external commands they run, so long ones, e.g. import, run with timeout -1
+	run := func(timeout time.Duration, args ...string) error {
+		out, err := exec.Run(context.Background(), exec.Options{Timeout: timeout}, remote[0], append(remote[1:], args...)...)
+		if err != nil {
+			return errors.Errorf("%s: %s %s", strings.Join(args, " "), strings.TrimSpace(string(out.Stderr)), err.Error())
+		}
+		return nil
+	}
+
+	//exists exits with 1 if container is not found, other codes mean target is not reachable
+	out, err := exec.Run(context.Background(), exec.Options{}, remote[0], append(remote[1:], "subutai", "exists", name)...)
+	checkState(err != nil, "Container %s already exists on %s", name, target)
+	checkState(out.ExitCode == 1, "Checking %s: %s %s", target, strings.TrimSpace(string(out.Stderr)), err)
+
+	templateRef := parent.String()
+	if cont.TemplateId != "" {
+		templateRef = "id:" + cont.TemplateId
+	}
+	log.Info("Importing template " + parent.String() + " on " + target)
+	log.Check(log.ErrorLevel, "Importing template", run(-1, "subutai", "import", templateRef))
+
+	running := container.State(name) == container.Running
+	label := "migrate-" + time.Now().Format("20060102150405")
+	labels := []string{label, label + "-final"}
+
+	created := false
+	rollback := func(msg string, err error) {
+		if err == nil {
+			return
+		}
+		if created {
+			log.Check(log.WarnLevel, "Destroying container on "+target, run(0, "subutai", "destroy", name))
+		}
+		for _, l := range labels {
+			if fs.DatasetExists(name + "@" + l) {
+				log.Check(log.WarnLevel, "Removing snapshot", fs.RemoveDataset(name+"@"+l, true))
+			}
+		}
+		if running {
+			LxcStart(name)
+		}
+		log.Error(msg + ": " + err.Error())
+	}
+
+	send := func(from func(partition string) string, label string) {
+		rollback("Creating snapshot", fs.CreateSnapshot(name+"@"+label, true))
+		for _, partition := range fs.ChildDatasets {
+			created = true
+			rollback("Sending partition "+partition, sendRemoteStream(remote, name, partition, from(partition),
+				getSnapshotName(name, partition, label), rate))
+		}
+	}
+
+	log.Info("Sending " + name + " to " + target)
+	send(func(partition string) string { return getSnapshotName(parent.String(), partition, "now") }, labels[0])
+
+	if running {
+		log.Info("Stopping " + name)
+		LxcStop(name)
+		if container.State(name) == container.Running {
+			rollback("Stopping container", errors.New(name+" is still running"))
+		}
+	}
+	log.Info("Sending changes of " + name)
+	send(func(partition string) string { return getSnapshotName(name, partition, labels[0]) }, labels[1])
+
+	configFile, err := os.Open(path.Join(config.Agent.LxcPrefix, name, "config"))
+	rollback("Opening config file", err)
+	defer configFile.Close()
+	result, err := exec.Run(context.Background(), exec.Options{Stdin: configFile}, remote[0],
+		append(remote[1:], "subutai", "snapshot", "receive", "-c", name, "--stream", "config")...)
+	if err != nil {
+		err = errors.Errorf("%s %s", strings.TrimSpace(string(result.Stderr)), err.Error())
+	}
+	rollback("Sending config file", err)
+	rollback("Verifying snapshots on "+target, verifyRemoteSnapshots(remote, name, labels[1]))
+
+	//containers of default network get free address of target host
+	restore := []string{"subutai", "restore", name}
+	if cont.EnvironmentId != "" {
+		restore = append(restore, "-e", cont.EnvironmentId)
+	}
+	if cont.Vlan != "" {
+		//ssh passes command to remote shell
+		restore = append(restore, "-n", "'"+cont.Ip+"/24 "+cont.Vlan+"'")
+	}
+	if cont.Tenant != "" {
+		//tenant must exist on target host, container is limited by its quotas there
+		restore = append(restore, "--tenant", cont.Tenant)
+	}
+	log.Info("Restoring " + name + " on " + target)
+	rollback("Restoring container", run(-1, restore...))
+	if !running {
+		log.Check(log.WarnLevel, "Stopping container on "+target, run(0, "subutai", "stop", name))
+	}
+
+	for _, l := range labels {
+		log.Check(log.WarnLevel, "Removing snapshot", fs.RemoveDataset(name+"@"+l, true))
+		log.Check(log.WarnLevel, "Removing snapshot on "+target, run(0, "subutai", "snapshot", "remove", "-c", name, "-p", "all", "-l", l))
+	}
+
+	if destroyLocal {
+		defer sendHeartbeat()
+		log.Check(log.ErrorLevel, "Destroying container", destroy(name))
+		log.Info(name + " migrated to " + target + " and destroyed")
+		return
+	}
+
+	log.Info(name + " migrated to " + target + ", stopped container is kept here, destroy it when it is not needed")
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/log"
+)
+
+// invocation of command being audited, nil once it is recorded
+var invocation *db.OperationRecord
+
+// secretFlags are flags whose values are not recorded in audit, whatever command they are passed to
+var secretFlags = []string{"--secret", "--token", "--password"}
+
+// secretShortFlags are short forms of secret flags, they mean other things for other commands so they are
+// masked only for commands listed
+var secretShortFlags = map[string][]string{
+	"clone":        {"-s"},
+	"import":       {"-s"},
+	"export":       {"-t"},
+	"restore":      {"-s"},
+	"cdn put":      {"-t"},
+	"file encrypt": {"-p"},
+	"file decrypt": {"-p"},
+}
+
+// BeginOperation tags logs of command with operation id and records command in audit when it exits.
+// Id is taken from --operation flag or from environment of calling agent process, e.g. daemon executing
+// Console command, and is generated otherwise. It is passed to agent processes started by the command and
+// printed along with error the command fails with, so the caller can trace it
+func BeginOperation(id, command string, args []string) {
+	if id == "" {
+		id = os.Getenv(common.OperationEnv)
+	}
+	if !common.IsOperationID(id) {
+		id = common.NewOperationID()
+	}
+
+	log.SetOperation(id)
+	log.Check(log.DebugLevel, "Setting operation id", os.Setenv(common.OperationEnv, id))
+
+	invocation = &db.OperationRecord{Operation: id, Command: auditCommand(command, args), Started: time.Now()}
+	log.AtExit(EndOperation)
+}
+
+// EndOperation records command started with BeginOperation and its exit code in audit
+func EndOperation(code int) {
+	record := invocation
+	if record == nil {
+		return
+	}
+	invocation = nil
+
+	record.Finished = time.Now()
+	record.ExitCode = code
+	record.Error = log.Failure()
+	log.Check(log.DebugLevel, "Saving operation record", db.SaveOperationRecord(record))
+}
+
+// auditCommand returns command line recorded in audit, values of secret flags are masked
+func auditCommand(command string, args []string) string {
+	masked := append([]string{}, args...)
+	flags := append(append([]string{}, secretShortFlags[command]...), secretFlags...)
+	for i := range masked {
+		for _, flag := range flags {
+			if masked[i] == flag && i+1 < len(masked) {
+				masked[i+1] = "***"
+			} else if strings.HasPrefix(masked[i], flag+"=") {
+				masked[i] = flag + "=***"
+			}
+		}
+	}
+	//token to register is positional
+	if command == "token create" {
+		positional := false
+		for i := 1; i < len(masked); i++ {
+			if masked[i-1] == "token" && (masked[i] == "create" || masked[i] == "add") {
+				positional = true
+			} else if positional && !strings.HasPrefix(masked[i], "-") && !stringInList(masked[i-1],
+				[]string{"--ttl", "--scope", "--tenant", "--host"}) {
+				masked[i] = "***"
+			}
+		}
+	}
+
+	return strings.Join(masked, " ")
+}
+
+// OperationList prints audit of the latest commands, or of commands of operation
+//
+// subutai operation list [--limit 20]
+// subutai operation show {id}
+func OperationList(id string, limit int) {
+	if limit <= 0 {
+		limit = 20
+	}
+	records, err := db.FindOperationRecords(id, limit)
+	log.Check(log.ErrorLevel, "Reading operation records from db", err)
+	if id != "" {
+		checkState(len(records) > 0, "Operation %s not found", id)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "OPERATION\tSTARTED\tDURATION\tEXIT CODE\tCOMMAND\tERROR")
+	for _, r := range records {
+		duration := "-"
+		if !r.Finished.IsZero() {
+			duration = r.Finished.Sub(r.Started).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", r.Operation, r.Started.Format(time.RFC3339), duration,
+			r.ExitCode, r.Command, r.Error)
+	}
+	w.Flush()
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// file of template archive listing packages installed in template
+const packagesFile = "packages"
+
+// ContainerPackages prints packages installed in container or template, e.g. to match them against
+// vulnerability feeds. Inventory of container is collected when it is missing or refresh is requested,
+// container must be running for that. Inventory of template is the one bundled into its archive
+//
+// subutai packages foo [--refresh] [--json]
+func ContainerPackages(name string, refresh, asJson bool) {
+	checkState(container.IsContainer(name) || container.IsTemplate(name), "Container %s not found", name)
+
+	inventory, err := db.FindPackageInventory(name)
+	log.Check(log.ErrorLevel, "Reading package inventory", err)
+
+	if container.IsContainer(name) && (refresh || inventory == nil) {
+		checkState(container.State(name) == container.Running,
+			"Container %s must be running to collect its packages", name)
+		inventory, err = collectPackages(name)
+		log.Check(log.ErrorLevel, "Collecting packages of "+name, err)
+	}
+	checkState(inventory != nil, "No package inventory of %s, it was exported without it", name)
+
+	if asJson {
+		if inventory.Packages == nil {
+			inventory.Packages = []db.Package{}
+		}
+		out, err := json.Marshal(inventory)
+		log.Check(log.ErrorLevel, "Marshalling package inventory", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tVERSION\tARCH")
+	for _, pkg := range inventory.Packages {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", pkg.Name, pkg.Version, pkg.Arch)
+	}
+	w.Flush()
+}
+
+// collectPackages lists packages installed in running container and saves them as its inventory
+func collectPackages(name string) (*db.PackageInventory, error) {
+	manager, packages, err := container.ListPackages(name)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := &db.PackageInventory{Container: name, Manager: manager, Packages: packages, Collected: time.Now()}
+
+	return inventory, db.SavePackageInventory(inventory)
+}
+
+// writePackages collects packages of container and writes them to package list of exported template
+func writePackages(name, dst string) error {
+	inventory, err := collectPackages(name)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path.Join(dst, packagesFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return container.WritePackages(file, inventory.Packages)
+}
+
+// readPackages saves package list of imported template as its inventory, archives exported without
+// package list are skipped
+func readPackages(templateRef, dir string) error {
+	file, err := os.Open(path.Join(dir, packagesFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	packages, err := container.ParsePackages(file)
+	if err != nil {
+		return err
+	}
+
+	return db.SavePackageInventory(&db.PackageInventory{Container: templateRef, Packages: packages, Collected: time.Now()})
+}
@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+// sizePreset is resources container of size preset is expected to use
+type sizePreset struct {
+	//Mb
+	RAM int
+	//Gb
+	Disk int
+}
+
+// sizePresets are resources of container sizes, quota profile named after size overrides its preset
+var sizePresets = map[string]sizePreset{
+	"tiny":   {RAM: 256, Disk: 4},
+	"small":  {RAM: 512, Disk: 10},
+	"medium": {RAM: 1024, Disk: 20},
+	"large":  {RAM: 2048, Disk: 40},
+	"huge":   {RAM: 4096, Disk: 100},
+}
+
+// Placement tells if container of template and size fits host and how well, score is 0 if it does not fit,
+// otherwise the more resources stay free and the lower load of host is the higher score is, up to 100
+type Placement struct {
+	Template string   `json:"template"`
+	Size     string   `json:"size"`
+	Fits     bool     `json:"fits"`
+	Score    int      `json:"score"`
+	Reasons  []string `json:"reasons,omitempty"`
+	//bytes needed by container and template if it is not installed yet
+	NeededDisk int64 `json:"neededDisk"`
+	NeededRAM  int64 `json:"neededRam"`
+	FreeDisk   int64 `json:"freeDisk"`
+	FreeRAM    int64 `json:"freeRam"`
+	//load average of the last minute per core
+	Load       float64 `json:"load"`
+	PoolHealth string  `json:"poolHealth"`
+}
+
+// HostPlacement prints whether container of template and size preset can be placed on host along with placement
+// score, so scheduler of several hosts can pick the best one. Size is one of tiny, small, medium, large and huge,
+// or name of quota profile. Template which is not installed is looked up on CDN for its size
+//
+// subutai host placement debian-stretch --size medium [--json]
+func HostPlacement(template, size string, asJSON bool) {
+	preset := placementPreset(size)
+
+	placement := Placement{Template: template, Size: size, Fits: true}
+	placement.NeededRAM = int64(preset.RAM) * 1024 * 1024
+	placement.NeededDisk = int64(preset.Disk) * 1024 * 1024 * 1024
+	if ref, err := templ.ParseFullRef(template); err != nil || !container.IsTemplate(ref.String()) {
+		placement.NeededDisk += getTemplateInfo(template).Size
+	}
+
+	reject := func(reason string) {
+		placement.Fits = false
+		placement.Reasons = append(placement.Reasons, reason)
+	}
+
+	health, err := fs.PoolHealth()
+	log.Check(log.ErrorLevel, "Reading pool health", err)
+	placement.PoolHealth = health
+	if health != "ONLINE" {
+		reject("pool is " + health)
+	}
+
+	poolSize, _, free, err := fs.GetPoolUsage()
+	log.Check(log.ErrorLevel, "Reading pool usage", err)
+	placement.FreeDisk = free
+	if free < placement.NeededDisk {
+		reject(fmt.Sprintf("%s of disk needed, %s free", formatSize(placement.NeededDisk), formatSize(free)))
+	}
+
+	memFree, memTotal, memCached := ramLoad()
+	total, _ := memTotal.(int)
+	if total > 0 {
+		free, _ := memFree.(int)
+		cached, _ := memCached.(int)
+		placement.FreeRAM = int64(free + cached)
+	}
+	if placement.FreeRAM < placement.NeededRAM {
+		reject(fmt.Sprintf("%s of RAM needed, %s free", formatSize(placement.NeededRAM), formatSize(placement.FreeRAM)))
+	}
+
+	placement.Load = loadPerCore()
+	if placement.Fits {
+		//free disk and RAM left after placement and idle cores weigh the same
+		score := 0.0
+		if poolSize > 0 {
+			score += float64(free-placement.NeededDisk) / float64(poolSize)
+		}
+		if total > 0 {
+			score += float64(placement.FreeRAM-placement.NeededRAM) / float64(total)
+		}
+		if placement.Load < 1 {
+			score += 1 - placement.Load
+		}
+		placement.Score = int(score * 100 / 3)
+	}
+
+	if asJSON {
+		out, err := json.Marshal(placement)
+		log.Check(log.ErrorLevel, "Marshalling placement", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(w, "Fits:\t%t\n", placement.Fits)
+	fmt.Fprintf(w, "Score:\t%d\n", placement.Score)
+	fmt.Fprintf(w, "Disk needed/free:\t%s/%s\n", formatSize(placement.NeededDisk), formatSize(placement.FreeDisk))
+	fmt.Fprintf(w, "RAM needed/free:\t%s/%s\n", formatSize(placement.NeededRAM), formatSize(placement.FreeRAM))
+	fmt.Fprintf(w, "Load per core:\t%.2f\n", placement.Load)
+	fmt.Fprintf(w, "Pool health:\t%s\n", placement.PoolHealth)
+	for _, reason := range placement.Reasons {
+		fmt.Fprintf(w, "Reason:\t%s\n", reason)
+	}
+	w.Flush()
+}
+
+// placementPreset returns resources of size, quota profile named after size overrides built-in preset
+func placementPreset(size string) sizePreset {
+	preset, ok := sizePresets[size]
+
+	profile, err := db.FindQuotaProfile(size)
+	log.Check(log.ErrorLevel, "Reading quota profile from db", err)
+	if profile != nil {
+		ok = true
+		if ram, err := strconv.Atoi(profile.Quotas["ram"]); err == nil {
+			preset.RAM = ram
+		}
+		if disk, err := strconv.Atoi(profile.Quotas["disk"]); err == nil {
+			preset.Disk = disk
+		}
+	}
+	checkArgument(ok, "Unknown size %s, expected one of %s or name of quota profile", size, strings.Join(allsizes, ", "))
+
+	return preset
+}
+
+// loadPerCore returns load average of the last minute divided by number of cores
+func loadPerCore() float64 {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if log.Check(log.WarnLevel, "Reading load average", err) {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	load, _ := strconv.ParseFloat(fields[0], 64)
+
+	return load / float64(runtime.NumCPU())
+}
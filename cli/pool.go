@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// Container disk quota policies applied when pool grows
+const (
+	// quotas are left intact
+	QuotaPolicyNone = "none"
+	// quotas are raised by the same ratio the pool has grown by
+	QuotaPolicyProportional = "proportional"
+)
+
+// QuotaChange describes disk quota of container raised on pool growth, in GB
+type QuotaChange struct {
+	Container string `json:"container"`
+	OldQuota  int    `json:"old-quota"`
+	NewQuota  int    `json:"new-quota"`
+}
+
+// PoolEvent is emitted when pool capacity changes
+type PoolEvent struct {
+	Host      string        `json:"host"`
+	OldSize   int64         `json:"old-size"`
+	NewSize   int64         `json:"new-size"`
+	Policy    string        `json:"policy"`
+	Quotas    []QuotaChange `json:"quotas"`
+	Time      time.Time     `json:"time"`
+	Operation string        `json:"operation,omitempty"`
+}
+
+// ExpandStorage detects new pool capacity, raises container disk quotas according to policy and emits pool event.
+// With expand flag set, pool devices are expanded first to use capacity of grown disks
+//
+// subutai storage expand [--policy proportional] [--devices]
+func ExpandStorage(policy string, expand bool) {
+	if policy == "" {
+		policy = config.Agent.QuotaPolicy
+	}
+	checkArgument(policy == QuotaPolicyNone || policy == QuotaPolicyProportional, "Unknown quota policy %s", policy)
+
+	if expand {
+		log.Check(log.ErrorLevel, "Expanding pool devices", fs.ExpandPool())
+	}
+
+	event, err := checkPoolCapacity(policy)
+	log.Check(log.ErrorLevel, "Checking pool capacity", err)
+
+	if event == nil {
+		log.Info("Pool capacity has not grown")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(w, "Pool size:\t%s -> %s\n", formatSize(event.OldSize), formatSize(event.NewSize))
+	if len(event.Quotas) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "CONTAINER\tOLD QUOTA\tNEW QUOTA")
+		for _, q := range event.Quotas {
+			fmt.Fprintf(w, "%s\t%dG\t%dG\n", q.Container, q.OldQuota, q.NewQuota)
+		}
+	}
+	w.Flush()
+}
+
+// MonitorPoolCapacity periodically checks pool capacity and applies configured quota policy on growth
+func MonitorPoolCapacity() {
+	for {
+		common.RunNRecover(func() {
+			_, err := checkPoolCapacity(config.Agent.QuotaPolicy)
+			log.Check(log.WarnLevel, "Checking pool capacity", err)
+		})
+
+		time.Sleep(time.Minute * 5)
+	}
+}
+
+// checkPoolCapacity compares current pool size with the last known one and returns event if pool has grown.
+// The first check only remembers the pool size. Quotas are scaled against pool size they were last scaled for,
+// so growth seen by check with policy none is still applied by later check with proportional policy
+func checkPoolCapacity(policy string) (*PoolEvent, error) {
+	size, _, _, err := fs.GetPoolUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	oldSize, err := db.GetPoolSize()
+	if err != nil {
+		return nil, err
+	}
+	quotaSize, err := db.GetQuotaPoolSize()
+	if err != nil {
+		return nil, err
+	}
+	//quotas of hosts checked before quota baseline was recorded were scaled for the last known size
+	if quotaSize == 0 && oldSize != 0 {
+		if err := db.SaveQuotaPoolSize(oldSize); err != nil {
+			return nil, err
+		}
+		quotaSize = oldSize
+	}
+
+	if oldSize != size {
+		if err := db.SavePoolSize(size); err != nil {
+			return nil, err
+		}
+	}
+
+	//shrunk pool is saved as is, otherwise the next growth would be undetected
+	if quotaSize == 0 || size < quotaSize {
+		if err := db.SaveQuotaPoolSize(size); err != nil {
+			return nil, err
+		}
+		quotaSize = size
+	}
+
+	grown := oldSize != 0 && size > oldSize
+	scale := policy == QuotaPolicyProportional && size > quotaSize
+	if !grown && !scale {
+		return nil, nil
+	}
+
+	hostname, _ := os.Hostname()
+	event := &PoolEvent{Host: hostname, OldSize: oldSize, NewSize: size, Policy: policy, Time: time.Now(),
+		Operation: log.Operation()}
+
+	if scale {
+		event.OldSize = quotaSize
+		event.Quotas = raiseQuotas(float64(size) / float64(quotaSize))
+		if err := db.SaveQuotaPoolSize(size); err != nil {
+			return nil, err
+		}
+	}
+
+	emitPoolEvent(*event)
+
+	return event, nil
+}
+
+// raiseQuotas multiplies disk quotas of containers by ratio, containers without quota are skipped
+func raiseQuotas(ratio float64) []QuotaChange {
+	var changes []QuotaChange
+
+	for _, name := range container.Containers() {
+		quota, err := fs.GetQuota(name)
+		if log.Check(log.WarnLevel, "Getting disk quota of "+name, err) || quota <= 0 {
+			continue
+		}
+
+		oldQuota := quota / 1024 / 1024 / 1024
+		newQuota := int(math.Ceil(float64(quota) * ratio / 1024 / 1024 / 1024))
+		if newQuota <= oldQuota {
+			continue
+		}
+
+		if !log.Check(log.WarnLevel, "Raising disk quota of "+name, fs.SetQuota(name, newQuota)) {
+			changes = append(changes, QuotaChange{Container: name, OldQuota: oldQuota, NewQuota: newQuota})
+		}
+	}
+
+	return changes
+}
+
+// emitPoolEvent logs event, notifies Console via heartbeat and posts event to configured webhook
+func emitPoolEvent(event PoolEvent) {
+	log.Info(fmt.Sprintf("Pool has grown from %s to %s, %d container quotas raised",
+		formatSize(event.OldSize), formatSize(event.NewSize), len(event.Quotas)))
+
+	sendHeartbeat()
+
+	if config.Agent.PoolEventWebhook != "" {
+		log.Check(log.WarnLevel, "Sending pool event to "+config.Agent.PoolEventWebhook,
+			postEvent(config.Agent.PoolEventWebhook, event))
+	}
+}
+
+// postEvent posts event as JSON to webhook
+func postEvent(url string, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	clnt := util.GetClient(false, 15)
+	resp, err := clnt.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer util.Close(resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("HTTP status: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -20,6 +20,13 @@ func checkState(condition bool, errMsg string, vals ...interface{}) {
 	})
 }
 
+// checkValid fails with validation error if any
+func checkValid(err error) {
+	checkCondition(err == nil, func() {
+		log.Error(err.Error())
+	})
+}
+
 func checkCondition(condition bool, fallback func()) {
 	if !condition {
 		fallback()
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// preflight stops operation before it changes anything if any of checks found a problem, all problems are
+// listed with their fixes so they can be solved at once rather than one by one as operation fails midway
+func preflight(operation string, checks ...hostCheck) {
+	var problems []string
+	for _, c := range checks {
+		if c.Problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s, %s", c.Name, c.Problem, c.Fix))
+		}
+	}
+	if len(problems) > 0 {
+		log.Error(fmt.Sprintf("Preflight checks of %s failed:\n  - %s", operation, strings.Join(problems, "\n  - ")))
+	}
+}
+
+// poolCheck checks that zfs pool is healthy and root dataset is mounted where containers are looked up
+func poolCheck() hostCheck {
+	c := hostCheck{Name: "zfs pool"}
+	pool := strings.Split(config.Agent.Dataset, "/")[0]
+	health, err := fs.PoolHealth()
+	if err != nil {
+		c.Problem, c.Fix = "pool "+pool+" not found", "create it, e.g. zpool create "+pool+" /dev/sdb, and run subutai host init"
+		return c
+	}
+	if health != "ONLINE" {
+		c.Problem, c.Fix = "pool "+pool+" is "+health, "check devices with zpool status "+pool
+		return c
+	}
+
+	out, err := exec.Execute("zfs", "get", "-H", "-o", "value", "mounted", config.Agent.Dataset)
+	if err != nil {
+		c.Problem, c.Fix = "root dataset "+config.Agent.Dataset+" not found", "create it with subutai host init"
+	} else if strings.TrimSpace(out) != "yes" {
+		c.Problem, c.Fix = "root dataset "+config.Agent.Dataset+" is not mounted", "mount it with zfs mount "+config.Agent.Dataset
+	}
+	return c
+}
+
+// lxcCheck checks that supported lxc is installed along with tools agent runs, lxc-update-config
+// migrates configs of templates to lxc 3
+func lxcCheck() hostCheck {
+	c := hostCheck{Name: "lxc"}
+	out, err := exec.Output("lxc-info", "--version")
+	if err != nil {
+		c.Problem, c.Fix = "lxc-info does not run", "install lxc 2.0 or newer"
+		return c
+	}
+	major, err := strconv.Atoi(strings.Split(strings.TrimSpace(string(out)), ".")[0])
+	if err != nil || major < 2 {
+		c.Problem, c.Fix = "lxc "+strings.TrimSpace(string(out))+" is not supported", "install lxc 2.0 or newer"
+		return c
+	}
+	if _, err = exec.LookPath("lxc-start"); err != nil {
+		c.Problem, c.Fix = "lxc-start not found in PATH", "reinstall lxc"
+	} else if _, err = exec.LookPath("lxc-update-config"); major >= 3 && err != nil {
+		c.Problem, c.Fix = "lxc-update-config not found in PATH", "install lxc-utils of lxc "+strconv.Itoa(major)
+	}
+	return c
+}
+
+// toolCheck checks that binary is found in PATH
+func toolCheck(name, binary, fix string) hostCheck {
+	c := hostCheck{Name: name}
+	if _, err := exec.LookPath(binary); err != nil {
+		c.Problem, c.Fix = binary+" not found in PATH", fix
+	}
+	return c
+}
+
+// nginxCheck checks that nginx is installed and directories of proxy configs it includes exist
+func nginxCheck() hostCheck {
+	c := toolCheck("nginx", "nginx", "install nginx")
+	if c.Problem != "" {
+		return c
+	}
+	for _, dir := range []string{"http", "https", "tcp", "udp"} {
+		if include := path.Join(nginxInc, dir); !fs.FileExists(include) {
+			c.Problem, c.Fix = "proxy config directory "+include+" not found", "create it or run subutai host init"
+			return c
+		}
+	}
+	return c
+}
+
+// cacheSpaceCheck checks that cache directory has at least size bytes free
+func cacheSpaceCheck(size int64) hostCheck {
+	c := hostCheck{Name: "cache space"}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(config.Agent.CacheDir, &stat); err != nil {
+		c.Problem, c.Fix = "cache directory "+config.Agent.CacheDir+" not found", "create it or run subutai host init"
+		return c
+	}
+	if free := int64(stat.Bavail) * int64(stat.Bsize); free < size {
+		c.Problem = fmt.Sprintf("%s has %d MB free, %d MB needed", config.Agent.CacheDir, free>>20, size>>20+1)
+		c.Fix = "remove unused archives from " + config.Agent.CacheDir + " or prune templates with subutai prune"
+	}
+	return c
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/subutai-io/agent/log"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+// Progress receives progress of template import as it goes through stages: download, unpack and install.
+// Bytes reports data transferred in current stage, total is 0 if it is unknown
+type Progress interface {
+	Stage(stage, template string)
+	Bytes(done, total int64)
+	Finish()
+}
+
+// ProgressEvent is progress of import printed in json mode, one event per line
+type ProgressEvent struct {
+	//start, progress or finish
+	Event    string `json:"event"`
+	Stage    string `json:"stage"`
+	Template string `json:"template"`
+	Done     int64  `json:"done,omitempty"`
+	Total    int64  `json:"total,omitempty"`
+	Percent  int    `json:"percent,omitempty"`
+}
+
+// progress reports progress of imports, see SetProgress
+var progress = newProgress("auto")
+
+// SetProgress selects how progress of imports is reported: bar draws progress bar, plain logs a line per 10 percent,
+// json prints an event per percent and none reports nothing. Auto draws bar on terminal and logs plain lines
+// otherwise, e.g. when agent is driven by Console or systemd
+func SetProgress(mode string) {
+	progress = newProgress(mode)
+}
+
+func newProgress(mode string) Progress {
+	if mode == "auto" {
+		mode = "plain"
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			mode = "bar"
+		}
+	}
+
+	switch mode {
+	case "bar":
+		return &barProgress{}
+	case "json":
+		return &stepProgress{step: 1, emit: printProgressEvent}
+	case "none":
+		return noProgress{}
+	}
+	return &stepProgress{step: 10, emit: logProgressEvent}
+}
+
+// barProgress draws terminal progress bar of stages transferring data
+type barProgress struct {
+	bar *pb.ProgressBar
+}
+
+func (p *barProgress) Stage(stage, template string) {
+	p.Finish()
+}
+
+func (p *barProgress) Bytes(done, total int64) {
+	if p.bar == nil {
+		p.bar = pb.New64(total).SetUnits(pb.U_BYTES)
+		if total <= 0 {
+			p.bar.NotPrint = true
+		}
+		p.bar.Start()
+	}
+	p.bar.Set64(done)
+}
+
+func (p *barProgress) Finish() {
+	if p.bar != nil {
+		p.bar.Finish()
+		p.bar = nil
+	}
+}
+
+// stepProgress emits event each step percent, or each step seconds if total is unknown
+type stepProgress struct {
+	step     int
+	emit     func(ProgressEvent)
+	event    ProgressEvent
+	reported time.Time
+}
+
+func (p *stepProgress) Stage(stage, template string) {
+	p.event = ProgressEvent{Event: "start", Stage: stage, Template: template}
+	p.reported = time.Now()
+	p.emit(p.event)
+}
+
+func (p *stepProgress) Bytes(done, total int64) {
+	p.event.Event, p.event.Done, p.event.Total = "progress", done, total
+	if total > 0 {
+		percent := int(done * 100 / total)
+		if percent < p.event.Percent+p.step {
+			return
+		}
+		p.event.Percent = percent - percent%p.step
+	} else if time.Since(p.reported) < time.Duration(p.step)*time.Second {
+		return
+	}
+	p.reported = time.Now()
+	p.emit(p.event)
+}
+
+func (p *stepProgress) Finish() {
+	if p.event.Event == "" || p.event.Event == "finish" {
+		return
+	}
+	p.event.Event = "finish"
+	if p.event.Total > 0 {
+		p.event.Percent = 100
+	}
+	p.emit(p.event)
+}
+
+func printProgressEvent(event ProgressEvent) {
+	out, err := json.Marshal(event)
+	if !log.Check(log.WarnLevel, "Marshalling progress event", err) {
+		fmt.Println(string(out))
+	}
+}
+
+// logProgressEvent logs progress only, stages are logged by import itself
+func logProgressEvent(event ProgressEvent) {
+	if event.Event != "progress" {
+		return
+	}
+	if event.Total > 0 {
+		log.Info(fmt.Sprintf("%s %s %d%% (%d of %d MB)", event.Template, event.Stage, event.Percent,
+			event.Done>>20, event.Total>>20))
+	} else {
+		log.Info(fmt.Sprintf("%s %s %d MB", event.Template, event.Stage, event.Done>>20))
+	}
+}
+
+type noProgress struct{}
+
+func (noProgress) Stage(stage, template string) {}
+
+func (noProgress) Bytes(done, total int64) {}
+
+func (noProgress) Finish() {}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"path"
+	"strings"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+// LxcPromote turns container into local template in place, without producing archive and uploading it to CDN.
+// Partitions are snapshotted and set readonly, dataset is renamed to full template reference and config
+// gets template metadata, so the template can be used as clone parent right away.
+// Owner is taken from `-o` option or templateOwner agent config parameter, version defaults to version of parent.
+// Promoted template is not available on CDN, so containers cloned from it may be exported only locally
+//
+// subutai promote foo [-n {template-name} -o {owner} -r 1.0.0 -s tiny]
+func LxcPromote(name, newname, version, prefsize, owner string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkState(!container.IsReservedName(name), "Container %s can not be promoted", name)
+
+	newname = strings.TrimSpace(newname)
+	if newname == "" {
+		newname = name
+	} else {
+		checkValid(container.ValidateNewName(newname))
+	}
+
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		owner = config.Agent.TemplateOwner
+	}
+	checkArgument(owner != "", "Missing template owner, specify it with -o option or templateOwner in agent config")
+
+	parent, err := container.ParentRef(name)
+	log.Check(log.ErrorLevel, "Reading parent template of "+name, err)
+
+	version = strings.TrimSpace(version)
+	if version == "" {
+		version = parent.Version
+	}
+
+	ref, err := templ.NewRef(newname, owner, version)
+	checkValid(err)
+	templateRef := ref.String()
+
+	pSize := "tiny"
+	for _, s := range allsizes {
+		if prefsize == s {
+			pSize = prefsize
+		}
+	}
+
+	lock := common.AcquireLocks(
+		common.LockKey{Kind: common.ContainerLock, Name: name},
+		common.LockKey{Kind: common.TemplateLock, Name: templateRef},
+	)
+	defer lock.Release()
+
+	checkState(!fs.DatasetExists(templateRef), "Template %s already exists", templateRef)
+
+	defer sendHeartbeat()
+
+	if container.State(name) == container.Running {
+		LxcStop(name)
+	}
+
+	for _, partition := range fs.ChildDatasets {
+		//remove old snapshot if any
+		snapshot := name + "/" + partition + "@now"
+		if fs.DatasetExists(snapshot) {
+			log.Check(log.ErrorLevel, "Removing snapshot "+snapshot, fs.RemoveDataset(snapshot, false))
+		}
+		log.Check(log.ErrorLevel, "Creating snapshot "+snapshot, fs.CreateSnapshot(snapshot, false))
+	}
+
+	log.Check(log.ErrorLevel, "Renaming dataset "+name, fs.RenameDataset(name, templateRef))
+
+	templateConf := templateConfig(owner, version, pSize)
+	templateConf = append(templateConf,
+		[]string{"subutai.template", newname},
+		//template must not be started with the host
+		[]string{"lxc.start.auto"},
+		[]string{editTemplateItem},
+	)
+	if common.GetMajorVersion() < 3 {
+		templateConf = append(templateConf, []string{"lxc.utsname", newname})
+	} else {
+		templateConf = append(templateConf, []string{"lxc.uts.name", newname})
+	}
+	log.Check(log.ErrorLevel, "Updating template config", container.SetContainerConf(templateRef, templateConf))
+	log.Check(log.ErrorLevel, "Setting lxc config", updateContainerConfig(templateRef))
+
+	for _, partition := range fs.ChildDatasets {
+		dataset := path.Join(templateRef, partition)
+		log.Check(log.ErrorLevel, "Setting dataset "+dataset+" readonly", fs.SetDatasetReadOnly(dataset))
+	}
+
+	cont, err := db.FindContainerByName(name)
+	log.Check(log.WarnLevel, "Reading container metadata from db", err)
+	if cont != nil {
+		log.Check(log.WarnLevel, "Deleting container metadata entry", db.RemoveContainer(cont))
+	}
+
+	log.Info("Container " + name + " promoted to template " + templateRef)
+}
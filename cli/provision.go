@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+	"gopkg.in/yaml.v2"
+)
+
+// provisionPayload is provisioning applied to container on its first boot, e.g.
+//
+//	env:
+//	  DB_HOST: 10.10.10.2
+//	files:
+//	  - path: /etc/app/app.conf
+//	    content: |
+//	      port = 8080
+//	    owner: www-data:www-data
+//	    mode: "0640"
+//	  - path: /root/.ssh/authorized_keys
+//	    source: /root/keys.pub
+//	scripts:
+//	  - apt-get -y install nginx
+type provisionPayload struct {
+	//variables scripts are run with
+	Env   map[string]string `yaml:"env"`
+	Files []provisionFile   `yaml:"files"`
+	//shell scripts run in order after container is started
+	Scripts []string `yaml:"scripts"`
+}
+
+// provisionFile is file written into container before it is started
+type provisionFile struct {
+	//absolute path inside container
+	Path    string `yaml:"path"`
+	Content string `yaml:"content"`
+	//file of host copied instead of content
+	Source string `yaml:"source"`
+	//user[:group] of container, root by default
+	Owner string `yaml:"owner"`
+	//octal, 0644 by default
+	Mode string `yaml:"mode"`
+}
+
+// readProvision reads and validates provisioning payload, so clone fails before container is created
+func readProvision(file string) (*provisionPayload, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &provisionPayload{}
+	if err = yaml.UnmarshalStrict(data, payload); err != nil {
+		return nil, errors.Wrap(err, "parsing "+file)
+	}
+
+	for key := range payload.Env {
+		if key == "" || strings.ContainsAny(key, "= ") {
+			return nil, errors.Errorf("Invalid variable name %q", key)
+		}
+	}
+	for i := range payload.Files {
+		f := &payload.Files[i]
+		if !path.IsAbs(f.Path) || path.Clean(f.Path) == "/" {
+			return nil, errors.Errorf("Invalid file path %q, absolute path inside container expected", f.Path)
+		}
+		if f.Source != "" {
+			if f.Content != "" {
+				return nil, errors.Errorf("Both content and source are set for %s", f.Path)
+			}
+			content, err := ioutil.ReadFile(f.Source)
+			if err != nil {
+				return nil, err
+			}
+			f.Content = string(content)
+		}
+		if f.Mode == "" {
+			f.Mode = "0644"
+		}
+		if perm, err := strconv.ParseUint(f.Mode, 8, 32); err != nil || perm > 0777 {
+			return nil, errors.Errorf("Invalid file mode %s of %s", f.Mode, f.Path)
+		}
+	}
+
+	return payload, nil
+}
+
+// applyProvisionFiles writes files of payload into rootfs of stopped container
+func applyProvisionFiles(name string, payload *provisionPayload) error {
+	for _, f := range payload.Files {
+		user, group := f.Owner, ""
+		if i := strings.Index(f.Owner, ":"); i >= 0 {
+			user, group = f.Owner[:i], f.Owner[i+1:]
+		}
+		uid, gid, err := container.LookupOwner(name, user, group)
+		if err != nil {
+			return errors.Wrap(err, "owner of "+f.Path)
+		}
+		perm, _ := strconv.ParseUint(f.Mode, 8, 32)
+
+		if err = writeContainerFile(name, f.Path, []byte(f.Content), uid, gid, os.FileMode(perm)); err != nil {
+			return errors.Wrap(err, "writing "+f.Path)
+		}
+		log.Debug(f.Path + " written to " + name)
+	}
+
+	return nil
+}
+
+// bootStateScript prints "running" once init of container finished booting, with systemd or sysvinit
+const bootStateScript = `state=$(systemctl is-system-running 2>/dev/null)
+case "$state" in
+running|degraded) echo running ;;
+offline|"") runlevel 2>/dev/null | grep -qv unknown && echo running ;;
+esac`
+
+// waitProvisionReady waits for init of started container to finish booting and for container to get address,
+// so scripts do not race with services and network coming up
+func waitProvisionReady(name string, deadline time.Time) error {
+	for {
+		booted := false
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		output, err := container.AttachExecCtx(ctx, name, []string{"/bin/sh", "-c", bootStateScript})
+		cancel()
+		if err == nil && len(output) > 0 && output[0] == "running" {
+			booted = true
+		}
+		if booted && container.GetIp(name) != "" {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if !booted {
+				return errors.New("container did not finish booting")
+			}
+			return errors.New("container did not get address")
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// runProvisionScripts runs scripts of payload inside started container one by one once it is booted and has
+// network, the first failed script stops provisioning. Each script is killed if it runs longer than provision
+// timeout
+func runProvisionScripts(name string, payload *provisionPayload) error {
+	if len(payload.Scripts) == 0 {
+		return nil
+	}
+
+	var env []string
+	for key, value := range payload.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+
+	log.Info("Waiting for " + name + " to boot")
+	if err := waitProvisionReady(name, time.Now().Add(time.Duration(config.Timeouts.Boot)*time.Second)); err != nil {
+		return err
+	}
+
+	timeout := time.Duration(config.Timeouts.Provision) * time.Second
+	for i, script := range payload.Scripts {
+		log.Info("Running provisioning script " + strconv.Itoa(i+1) + " of " + strconv.Itoa(len(payload.Scripts)))
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		_, errOut, res := container.AttachExecOutputCtx(ctx, name, []string{"/bin/sh", "-c", script}, env)
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.Errorf("script %d timed out after %s", i+1, timeout)
+		}
+		if res.Error() != nil {
+			return errors.Wrapf(res.Error(), "script %d", i+1)
+		}
+		if res.ExitCode() != 0 {
+			return errors.Errorf("script %d exited with code %d: %s", i+1, res.ExitCode(), strings.TrimSpace(errOut))
+		}
+	}
+
+	return nil
+}
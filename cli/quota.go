@@ -1,48 +1,241 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
 	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
 	"github.com/subutai-io/agent/log"
 )
 
+var (
+	//resources which may be limited with quota
+	quotaResources = []string{"cpu", "cpuset", "ram", "disk", "network", "io", "read-bps", "write-bps", "read-iops",
+		"write-iops", "hugepages", "tmp", "run", "numa"}
+	profileNameRx = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+)
+
 // LxcQuota function controls container's quotas and thresholds. Available resources:
 //	cpu, %
-//	cpuset, available cores
+//	cpuset, available cores, or auto to let agent assign and rebalance cores
 //	ram, Mb
 //	network, Kbps
+//	io, relative block IO weight 10-1000
+//	read-bps/write-bps, bytes per second container may read from or write to disks of pool, 0 removes limit
+//	read-iops/write-iops, IO operations per second container may issue to disks of pool, 0 removes limit
 //	rootfs/home/var/opt, Gb
+//	hugepages, Mb of huge pages reserved for container and mounted at /dev/hugepages
+//	tmp/run, Mb of tmpfs mounted at /tmp or /run, 0 removes tmpfs
+//	numa, id of NUMA node to bind cores and memory to, auto to pick node fitting cpu and ram quotas, or none
 // The threshold value represents a percentage for each resource. Once resource consumption exceeds this threshold it triggers an alert.
 // The clone operation, sets no quotas and thresholds for new containers; quotas need to be configured with quota command after a clone operation.
+// Disk quota below current usage of container is refused unless force is set, since writes would fail right away.
+// CPU, RAM and disk quotas of tenant container are refused if sum of quotas of tenant containers would exceed tenant limit.
+// Block IO rates and weight are enforced by cgroups only on IO issued by processes of container. ZFS, which holds all
+// containers, issues most of disk IO from its own threads, so these limits mostly do not hold, see container.QuotaIO.
 //todo improve, remove threshold param since alerts are not used
-func LxcQuota(name, res, size, threshold string) {
+func LxcQuota(name, res, size, threshold string, force bool) {
 	if len(threshold) > 0 {
 		setQuotaThreshold(name, res, threshold)
 	}
-	quota := "0"
 	alert := getQuotaThreshold(name, res)
 	switch res {
-	case "network":
-		quota = container.QuotaNet(name, size)
 	case "disk":
-		quota = strconv.Itoa(container.QuotaDisk(name, size))
-	case "cpuset":
-		quota = container.QuotaCPUset(name, size)
-	case "ram":
-		quota = strconv.Itoa(container.QuotaRAM(name, size))
-	case "cpu":
-		quota = strconv.Itoa(container.QuotaCPU(name, size))
+		if size != "" {
+			checkValid(checkTenantQuotas(name, map[string]string{res: size}))
+			if err := checkDiskQuota(name, size); err != nil {
+				checkState(force, "%s, set --force to apply it anyway", err.Error())
+				log.Warn(err.Error())
+			}
+		}
+	case "ram", "cpu":
+		if size != "" {
+			checkValid(checkTenantQuotas(name, map[string]string{res: size}))
+		}
 	}
-
-	if quota == "none" {
-		quota = "0"
+	if size != "" {
+		log.Check(log.ErrorLevel, "Setting "+res+" quota of "+name, applyQuota(name, res, size))
 	}
+	quota, err := currentQuota(name, res)
+	log.Check(log.ErrorLevel, "Reading "+res+" quota of "+name, err)
 
 	fmt.Println(`{"quota":"` + quota + `", "threshold":` + alert + `}`)
 }
 
+// ContainerQuota prints all quotas of container, or quota of a single resource, and sets quotas given as
+// resource=limit pairs or as a resource followed by its limit. Every limit is validated before any quota is set
+// and quotas set before a failure are restored, so several quotas are applied either all or none. Disk quota
+// below current usage of container is refused unless force is set, quotas above limits of tenant of container
+// are refused
+//
+// subutai quota foo [--json]
+// subutai quota foo cpu
+// subutai quota foo cpu 50
+// subutai quota foo cpu=50 ram=2048 disk=100 [--force]
+func ContainerQuota(name string, args []string, asJSON, force bool) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	var resources []string
+	quotas := make(map[string]string)
+	switch {
+	case len(args) == 0:
+		resources = quotaResources
+	case len(args) == 1 && !strings.Contains(args[0], "="):
+		resources = []string{quotaResource(args[0])}
+	case len(args) == 2 && !strings.Contains(args[0]+args[1], "="):
+		quotas[quotaResource(args[0])] = args[1]
+	default:
+		for _, arg := range args {
+			pair := strings.SplitN(arg, "=", 2)
+			checkArgument(len(pair) == 2, "Invalid quota %s, resource=limit expected", arg)
+			resource := quotaResource(pair[0])
+			_, duplicate := quotas[resource]
+			checkArgument(!duplicate, "Quota of %s is given more than once", resource)
+			quotas[resource] = pair[1]
+		}
+	}
+
+	if len(quotas) > 0 {
+		for resource, value := range quotas {
+			checkValid(validateQuota(resource, value))
+		}
+		checkValid(checkTenantQuotas(name, quotas))
+		if value, ok := quotas["disk"]; ok {
+			if err := checkDiskQuota(name, value); err != nil {
+				checkState(force, "%s, set --force to apply it anyway", err.Error())
+				log.Warn(err.Error())
+			}
+		}
+		setQuotas(name, quotas)
+
+		for _, resource := range quotaResources {
+			if _, ok := quotas[resource]; ok {
+				resources = append(resources, resource)
+			}
+		}
+	}
+
+	current := make(map[string]string)
+	for _, resource := range resources {
+		value, err := currentQuota(name, resource)
+		log.Check(log.ErrorLevel, "Reading "+resource+" quota of "+name, err)
+		current[resource] = value
+	}
+
+	if asJSON {
+		out, err := json.Marshal(current)
+		log.Check(log.ErrorLevel, "Marshalling quotas", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "RESOURCE\tQUOTA")
+	for _, resource := range resources {
+		fmt.Fprintf(w, "%s\t%s\n", resource, current[resource])
+	}
+	w.Flush()
+}
+
+// quotaResource returns resource of quota command argument, e.g. network for net
+func quotaResource(arg string) string {
+	resource := strings.TrimSpace(arg)
+	if resource == "net" {
+		resource = "network"
+	}
+	checkArgument(stringInList(resource, quotaResources),
+		"Unknown resource %s, expected one of %s", arg, strings.Join(quotaResources, ", "))
+	return resource
+}
+
+// setQuotas sets quotas of container, quotas set before a failure are restored to their previous values
+func setQuotas(name string, quotas map[string]string) {
+	previous := make(map[string]string)
+	for resource := range quotas {
+		value, err := currentQuota(name, resource)
+		log.Check(log.ErrorLevel, "Reading "+resource+" quota of "+name, err)
+		previous[resource] = value
+	}
+
+	var applied []string
+	for _, resource := range quotaResources {
+		value, ok := quotas[resource]
+		if !ok {
+			continue
+		}
+		err := applyQuota(name, resource, value)
+		if err == nil {
+			applied = append(applied, resource)
+			continue
+		}
+
+		for i := len(applied) - 1; i >= 0; i-- {
+			if err := applyQuota(name, applied[i], previous[applied[i]]); err != nil {
+				log.Warn("Restoring " + applied[i] + " quota of " + name + " failed: " + err.Error())
+			}
+		}
+		log.Error("Setting " + resource + " quota of " + name + " failed, no quota is changed: " + err.Error())
+	}
+}
+
+// currentQuota returns quota of resource of container in units it is set in
+func currentQuota(name, resource string) (string, error) {
+	switch resource {
+	case "cpu", "cpuset", "ram", "disk", "network":
+		quota, err := container.GetQuota(name)
+		if err != nil {
+			return "", err
+		}
+		switch resource {
+		case "cpu":
+			return strconv.Itoa(quota.CPU), nil
+		case "cpuset":
+			return quota.CPUSet, nil
+		case "ram":
+			return strconv.Itoa(quota.RAM), nil
+		case "disk":
+			return strconv.Itoa(quota.Disk), nil
+		}
+		return strconv.Itoa(quota.Net), nil
+	case "io":
+		return container.QuotaIOWeight(name, ""), nil
+	case "read-bps", "write-bps", "read-iops", "write-iops":
+		limit, err := container.GetIOLimit(name)
+		if err != nil {
+			return "", err
+		}
+		switch resource {
+		case "read-bps":
+			return strconv.FormatInt(limit.ReadBps, 10), nil
+		case "write-bps":
+			return strconv.FormatInt(limit.WriteBps, 10), nil
+		case "read-iops":
+			return strconv.FormatInt(limit.ReadIops, 10), nil
+		}
+		return strconv.FormatInt(limit.WriteIops, 10), nil
+	case "hugepages":
+		mb, err := container.QuotaHugepages(name, "")
+		return strconv.Itoa(mb), err
+	case "tmp", "run":
+		mb, err := container.QuotaTmpfs(name, resource, "")
+		return strconv.Itoa(mb), err
+	case "numa":
+		return container.QuotaNUMA(name, "")
+	}
+	return "", errors.Errorf("Unknown resource %s", resource)
+}
+
 // setQuotaThreshold sets threshold for quota alerts
 func setQuotaThreshold(name, resource, size string) {
 	if resource == "rootfs" || resource == "var" || resource == "opt" || resource == "home" {
@@ -66,3 +259,234 @@ func getQuotaThreshold(name, resource string) string {
 	}
 	return "0"
 }
+
+// applyQuota sets quota of resource to value
+func applyQuota(name, resource, value string) (err error) {
+	switch resource {
+	case "cpu", "cpuset", "ram", "disk", "network":
+		err = setContainerQuota(name, resource, value)
+	case "io":
+		container.QuotaIOWeight(name, value)
+	case "read-bps", "write-bps", "read-iops", "write-iops":
+		err = setIOLimit(name, resource, value)
+	case "hugepages":
+		_, err = container.QuotaHugepages(name, value)
+	case "tmp", "run":
+		_, err = container.QuotaTmpfs(name, resource, value)
+	case "numa":
+		_, err = container.QuotaNUMA(name, value)
+	default:
+		err = errors.Errorf("Unknown resource %s", resource)
+	}
+	return err
+}
+
+// setContainerQuota sets cpu, cpuset, ram, disk or network quota of container, keeping its other quotas
+func setContainerQuota(name, resource, value string) error {
+	quota, err := container.GetQuota(name)
+	if err != nil {
+		return err
+	}
+
+	if resource == "cpuset" {
+		quota.CPUSet = value
+		return container.SetQuota(name, quota)
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return errors.Errorf("Invalid %s quota %s, non-negative number expected", resource, value)
+	}
+	switch resource {
+	case "cpu":
+		quota.CPU = limit
+	case "ram":
+		quota.RAM = limit
+	case "disk":
+		quota.Disk = limit
+	case "network":
+		quota.Net = limit
+	}
+	return container.SetQuota(name, quota)
+}
+
+// setIOLimit sets one of block IO limits of container, keeping the others
+func setIOLimit(name, resource, value string) error {
+	limit, err := container.GetIOLimit(name)
+	if err != nil {
+		return err
+	}
+
+	rate, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || rate < 0 {
+		return errors.Errorf("Invalid %s quota %s, non-negative number expected", resource, value)
+	}
+	switch resource {
+	case "read-bps":
+		limit.ReadBps = rate
+	case "write-bps":
+		limit.WriteBps = rate
+	case "read-iops":
+		limit.ReadIops = rate
+	case "write-iops":
+		limit.WriteIops = rate
+	}
+	if rate > 0 {
+		log.Warn("Block IO limits are largely ineffective on ZFS, since ZFS issues most of disk IO from its own threads")
+	}
+	return container.QuotaIO(name, limit.ReadBps, limit.WriteBps, limit.ReadIops, limit.WriteIops)
+}
+
+// checkDiskQuota returns error if disk quota in Gb is below current disk usage of container, including its
+// snapshots, since writes to container would fail with disk quota exceeded till data is removed. 0 removes quota
+func checkDiskQuota(name, size string) error {
+	quota, err := strconv.Atoi(size)
+	if err != nil || quota <= 0 {
+		return nil
+	}
+	usage, err := fs.DatasetDiskUsage(name)
+	if err != nil {
+		return errors.Wrap(err, "Reading disk usage of "+name)
+	}
+
+	if int64(quota)*1024*1024*1024 < int64(usage) {
+		return errors.Errorf("Disk quota %dG of %s is below its current usage %s, writes to container would fail",
+			quota, name, formatSize(int64(usage)))
+	}
+	return nil
+}
+
+// validateQuota checks that value is acceptable limit of resource
+func validateQuota(resource, value string) error {
+	switch resource {
+	case "cpu", "ram", "disk", "network", "hugepages", "tmp", "run", "read-bps", "write-bps", "read-iops", "write-iops":
+		if v, err := strconv.ParseInt(value, 10, 64); err != nil || v < 0 {
+			return errors.Errorf("Invalid %s quota %s, non-negative number expected", resource, value)
+		}
+	case "io":
+		if v, err := strconv.Atoi(value); err != nil || v < 10 || v > 1000 {
+			return errors.Errorf("Invalid io quota %s, weight from 10 to 1000 expected", value)
+		}
+	case "cpuset":
+		if value == container.CPUsetAuto {
+			return nil
+		}
+		if cores, err := container.ParseCPUList(value); err != nil || len(cores) == 0 {
+			return errors.Errorf("Invalid cpuset quota %s, list of cores or %s expected", value, container.CPUsetAuto)
+		}
+	case "numa":
+		if id, err := strconv.Atoi(value); (err != nil || id < 0) && value != container.NUMAAuto && value != container.NUMANone {
+			return errors.Errorf("Invalid numa quota %s, node id, %s or %s expected", value, container.NUMAAuto, container.NUMANone)
+		}
+	default:
+		return errors.Errorf("Unknown resource %s, expected one of %s", resource, strings.Join(quotaResources, ", "))
+	}
+	return nil
+}
+
+// QuotaProfileAdd saves named set of quotas which may be applied to containers with "subutai quota apply".
+// Existing profile with the same name is replaced
+//
+// subutai quota profile add db-large cpu=50 ram=4096 disk=100 io=800
+func QuotaProfileAdd(name string, quotas map[string]string) {
+	name = strings.TrimSpace(name)
+	checkArgument(profileNameRx.MatchString(name),
+		"Invalid profile name %s, only lowercase letters, digits and _.- are allowed", name)
+	checkArgument(len(quotas) > 0, "Profile must limit at least one resource")
+	for resource, value := range quotas {
+		checkValid(validateQuota(resource, value))
+	}
+
+	profile, err := db.FindQuotaProfile(name)
+	log.Check(log.ErrorLevel, "Reading quota profile from db", err)
+	if profile == nil {
+		profile = &db.QuotaProfile{Name: name}
+	}
+	profile.Quotas = quotas
+
+	log.Check(log.ErrorLevel, "Saving quota profile", db.SaveQuotaProfile(profile))
+}
+
+// QuotaProfileList prints quota profiles
+//
+// subutai quota profile list
+func QuotaProfileList() {
+	profiles, err := db.GetAllQuotaProfiles()
+	log.Check(log.ErrorLevel, "Reading quota profiles from db", err)
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tQUOTAS")
+	for _, p := range profiles {
+		var quotas []string
+		for _, resource := range quotaResources {
+			if value, ok := p.Quotas[resource]; ok {
+				quotas = append(quotas, resource+"="+value)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\n", p.Name, strings.Join(quotas, " "))
+	}
+	w.Flush()
+}
+
+// QuotaProfileRemove removes quota profile, containers it was applied to keep their quotas
+//
+// subutai quota profile remove db-large
+func QuotaProfileRemove(name string) {
+	profile, err := db.FindQuotaProfile(name)
+	log.Check(log.ErrorLevel, "Reading quota profile from db", err)
+	checkState(profile != nil, "Quota profile %s not found", name)
+
+	log.Check(log.ErrorLevel, "Removing quota profile", db.RemoveQuotaProfile(profile))
+}
+
+// QuotaApply sets all quotas of profile to container and records profile name in container config.
+// Profile exceeding limits of tenant of container is not applied
+//
+// subutai quota apply foo --profile db-large
+func QuotaApply(name, profileName string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	profile, err := db.FindQuotaProfile(profileName)
+	log.Check(log.ErrorLevel, "Reading quota profile from db", err)
+	checkState(profile != nil, "Quota profile %s not found", profileName)
+	checkValid(checkTenantQuotas(name, profile.Quotas))
+
+	applied := true
+	for _, resource := range quotaResources {
+		value, ok := profile.Quotas[resource]
+		if !ok {
+			continue
+		}
+		if resource == "disk" {
+			if err := checkDiskQuota(name, value); err != nil {
+				log.Warn(err.Error() + ", skipping")
+				applied = false
+				continue
+			}
+		}
+		if err := applyQuota(name, resource, value); err != nil {
+			log.Warn("Setting " + resource + " quota: " + err.Error() + ", skipping")
+			applied = false
+		}
+	}
+
+	log.Check(log.ErrorLevel, "Saving quota profile of container",
+		container.SetContainerConf(name, [][]string{{"subutai.quota.profile", profile.Name}}))
+
+	if !applied {
+		log.Warn("Quota profile " + profile.Name + " was applied to " + name + " partially")
+	}
+}
+
+// MonitorCPUsets rebalances cores of containers with automatic cpuset by their cpu quotas and usage
+func MonitorCPUsets() {
+	balancer := new(container.CPUBalancer)
+	for {
+		common.RunNRecover(func() {
+			log.Check(log.WarnLevel, "Balancing cores of containers", balancer.Rebalance())
+		})
+
+		time.Sleep(time.Minute)
+	}
+}
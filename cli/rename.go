@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// LxcRename renames container in place, without export and import. Running container is stopped for rename
+// and started again under the new name. Container of tenant keeps prefix of tenant, other containers can not
+// take prefixes of tenants
+//
+// subutai rename foo bar
+func LxcRename(name, newName string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkState(!container.LxcInstanceExists(newName), "Container or template %s already exists", newName)
+	checkValid(container.ValidateName(newName))
+	checkTenantName(tenantOf(name), newName)
+
+	running := container.State(name) == container.Running
+	if running {
+		log.Check(log.ErrorLevel, "Stopping "+name, container.Stop(name))
+	}
+
+	if err := container.Rename(name, newName); err != nil {
+		//rename is rolled back unless rollback failed too
+		if running {
+			current := name
+			if !container.IsContainer(name) && container.IsContainer(newName) {
+				current = newName
+			}
+			log.Check(log.WarnLevel, "Starting "+current, container.Start(current))
+		}
+		log.Error("Renaming " + name + ": " + err.Error())
+	}
+
+	if running {
+		log.Check(log.ErrorLevel, "Starting "+newName, container.Start(newName))
+	}
+
+	sendHeartbeat()
+	log.Info(name + " is renamed to " + newName)
+}
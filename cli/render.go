@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// restartScript restarts service inside container with systemd or sysvinit tools
+const restartScript = `if command -v systemctl >/dev/null 2>&1; then systemctl restart "$1"; else service "$1" restart; fi`
+
+// RenderTemplate renders Go template with variables passed in form key=value and writes result into container.
+// File is written atomically, owned by user and group of container and has mode set, missing directories are
+// created. Variables are referred as {{.key}} in template, undefined variables fail rendering. Service of
+// running container is restarted afterwards if set
+//
+// subutai render foo --template app.conf.tmpl --dest /etc/app/app.conf --vars db=10.10.10.2,port=5432 [--owner www-data] [--mode 0640] [--restart app]
+func RenderTemplate(name, templateFile, dest string, vars []string, owner, mode, service string) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	values := make(map[string]string)
+	for _, list := range vars {
+		for _, item := range strings.Split(list, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			parts := strings.SplitN(item, "=", 2)
+			checkArgument(len(parts) == 2 && parts[0] != "", "Invalid variable %s, use key=value", item)
+			values[parts[0]] = parts[1]
+		}
+	}
+
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	checkArgument(err == nil && perm <= 0777, "Invalid file mode %s", mode)
+
+	user, group := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		user, group = owner[:i], owner[i+1:]
+	}
+	uid, gid, err := container.LookupOwner(name, user, group)
+	checkValid(err)
+
+	data, err := ioutil.ReadFile(templateFile)
+	log.Check(log.ErrorLevel, "Reading template "+templateFile, err)
+	tmpl, err := template.New(path.Base(templateFile)).Option("missingkey=error").Parse(string(data))
+	log.Check(log.ErrorLevel, "Parsing template "+templateFile, err)
+	var content bytes.Buffer
+	log.Check(log.ErrorLevel, "Rendering template "+templateFile, tmpl.Execute(&content, values))
+
+	log.Check(log.ErrorLevel, "Writing "+dest, writeContainerFile(name, dest, content.Bytes(), uid, gid, os.FileMode(perm)))
+	log.Info(dest + " written to " + name)
+
+	if service != "" {
+		checkState(container.State(name) == container.Running, "Container %s is not running, service %s is not restarted", name, service)
+		exitCode, err := container.GetRuntime().Exec(name, []string{"/bin/sh", "-c", restartScript, "sh", service},
+			container.ExecOptions{Stdout: os.Stdout, Stderr: os.Stderr})
+		if err == nil && exitCode != 0 {
+			err = errors.Errorf("exit code %d", exitCode)
+		}
+		log.Check(log.ErrorLevel, "Restarting service "+service, err)
+		log.Info("Service " + service + " restarted")
+	}
+}
+
+// writeContainerFile writes file into container replacing it atomically, ids of owner are shifted
+// by id map of container. Missing directories are owned by root of container
+func writeContainerFile(name, dest string, content []byte, uid, gid int, mode os.FileMode) error {
+	root, rel, err := container.HostRoot(name, dest)
+	if err != nil {
+		return err
+	}
+	hostUid, hostGid, err := container.HostIds(name, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	return fs.ReplaceFileInRoot(root, rel, content, mode, hostUid, hostGid)
+}
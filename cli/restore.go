@@ -2,7 +2,6 @@ package cli
 
 import (
 	"fmt"
-	"github.com/nightlyone/lockfile"
 	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/db"
 	"github.com/subutai-io/agent/lib/common"
@@ -16,12 +15,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 )
 
 //todo remove code duplicates from LxcClone and RestoreContainer by moving common part to lib
 
-func RestoreContainer(containerName, envID, addr, consoleSecret string) {
+// RestoreContainer registers container which datasets and config were received from another host, e.g. by
+// migration, with new mac, address, uid mapping and key. Container of tenant is limited by quotas of tenant
+func RestoreContainer(containerName, envID, addr, consoleSecret, tenant string) {
 
 	containerName = strings.TrimSpace(containerName)
 
@@ -33,28 +33,25 @@ func RestoreContainer(containerName, envID, addr, consoleSecret string) {
 
 	checkState(fs.FileExists(configFilePath), "Config file not found")
 
+	checkSnapshotChain(containerName, "", nil)
+
 	//synchronize
-	var lock lockfile.Lockfile
-	var err error
-	for lock, err = common.LockFile("", "clone"); err != nil; lock, err = common.LockFile("", "clone") {
-		time.Sleep(time.Second * 1)
-	}
-	defer lock.Unlock()
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: containerName})
+	defer lock.Release()
 	//<<<synchronize
 
-	defer sendHeartbeat()
+	tenant = strings.TrimSpace(tenant)
+	tenantLock := checkTenantClone(tenant, containerName, consoleSecret, nil)
+	defer tenantLock.Release()
 
-	parentParts := []string{
-		container.GetProperty(containerName, "subutai.template"),
-		container.GetProperty(containerName, "subutai.template.owner"),
-		container.GetProperty(containerName, "subutai.template.version"),
-	}
+	defer sendHeartbeat()
 
-	parent := strings.Join(parentParts, ":")
+	parentRef, err := container.TemplateRef(containerName)
+	log.Check(log.ErrorLevel, "Reading template of container", err)
 
-	t := getTemplateInfo(parent)
+	t := getTemplateInfo(parentRef.String())
 
-	log.Debug("Parent template is " + t.Name + "@" + t.Owner + ":" + t.Version)
+	log.Debug("Parent template is " + t.Ref().CdnString())
 
 	cont := &db.Container{}
 	cont.Name = containerName
@@ -62,6 +59,7 @@ func RestoreContainer(containerName, envID, addr, consoleSecret string) {
 	cont.TemplateOwner = t.Owner
 	cont.TemplateVersion = t.Version
 	cont.TemplateId = t.Id
+	cont.Tenant = tenant
 
 	mac, err := container.Mac()
 	log.Check(log.ErrorLevel, "Generating mac address", err)
@@ -74,9 +72,9 @@ func RestoreContainer(containerName, envID, addr, consoleSecret string) {
 			{"lxc.network.hwaddr", mac},
 			{"lxc.network.veth.pair", strings.Replace(mac, ":", "", -1)},
 			{"lxc.network.mtu", strconv.Itoa(mtu)},
-			{"subutai.parent", parentParts[0]},
-			{"subutai.parent.owner", parentParts[1]},
-			{"subutai.parent.version", parentParts[2]},
+			{"subutai.parent", parentRef.Name},
+			{"subutai.parent.owner", parentRef.Owner},
+			{"subutai.parent.version", parentRef.Version},
 			{"lxc.rootfs", path.Join(config.Agent.LxcPrefix, containerName, "rootfs")},
 			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, containerName, "home") + " home none bind,rw 0 0"},
 			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, containerName, "opt") + " opt none bind,rw 0 0"},
@@ -91,9 +89,9 @@ func RestoreContainer(containerName, envID, addr, consoleSecret string) {
 			{"lxc.net.0.hwaddr", mac},
 			{"lxc.net.0.veth.pair", strings.Replace(mac, ":", "", -1)},
 			{"lxc.net.0.mtu", strconv.Itoa(mtu)},
-			{"subutai.parent", parentParts[0]},
-			{"subutai.parent.owner", parentParts[1]},
-			{"subutai.parent.version", parentParts[2]},
+			{"subutai.parent", parentRef.Name},
+			{"subutai.parent.owner", parentRef.Owner},
+			{"subutai.parent.version", parentRef.Version},
 			{"lxc.rootfs.path", "zfs:" + path.Join(config.Agent.LxcPrefix, containerName, "rootfs")},
 			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, containerName, "home") + " home none bind,rw 0 0"},
 			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, containerName, "opt") + " opt none bind,rw 0 0"},
@@ -101,30 +99,80 @@ func RestoreContainer(containerName, envID, addr, consoleSecret string) {
 			{"lxc.uts.name", containerName},
 			{"lxc.cgroup.memory.limit_in_bytes"},
 			{"lxc.cgroup.cpu.cfs_quota_us"},
+			{"lxc.cgroup2.memory.max"},
+			{"lxc.cgroup2.cpu.max"},
 		})
 
 	}
 
 	gpg.GenerateKey(containerName)
 	if len(consoleSecret) != 0 {
-		gpg.ExchangeAndEncrypt(containerName, consoleSecret)
+		gpg.ExchangeAndEncrypt(containerName, consoleSecret, gpg.ScopeRestore)
 	}
 
 	if len(envID) != 0 {
 		cont.EnvironmentId = envID
 	}
 
+	setContainerNetwork(containerName, addr, cont)
+
+	//changing from dhcp to manual
+	container.SetStaticNet(containerName)
+
+	cont.Uid, _ = container.SetContainerUID(containerName)
+
+	//Need to change it in parent templates
+	container.SetDNS(containerName)
+	//add subutai.template.owner & subutai.template.version
+	container.CopyParentReference(containerName, t.Owner, t.Version)
+
+	//Security matters workaround. Need to change it in parent templates
+	container.DisableSSHPwd(containerName)
+
+	if common.GetMajorVersion() < 3 {
+		cont.Interface = container.GetProperty(containerName, "lxc.network.veth.pair")
+	} else {
+		cont.Interface = container.GetProperty(containerName, "lxc.net.0.veth.pair")
+	}
+
+	log.Check(log.ErrorLevel, "Writing container metadata to database", db.SaveContainer(cont))
+	setTenantDefaultQuotas(tenant, containerName)
+	tenantLock.Release()
+
+	LxcStart(containerName)
+
+	log.Info(containerName + " with ID " + gpg.GetFingerprint(containerName) + " successfully restored")
+
+}
+
+// setContainerNetwork configures container address given in form 'ip/mask vlan [ipv6]' or the next free address
+// of the default network and records it in cont. IPv6 address makes container dual-stack. Address is chosen and
+// written under network lock, so containers created in parallel do not get the same free address
+func setContainerNetwork(containerName, addr string, cont *db.Container) {
+	lock := common.AcquireLocks(common.NetworkLockKey)
+	defer lock.Release()
+
 	if ip := strings.Fields(addr); len(ip) > 1 {
 
 		cont.Ip = strings.Split(ip[0], "/")[0]
 		cont.Gateway = getOrGenerateGateway(addr)
 		cont.Vlan = ip[1]
+		if len(ip) > 2 {
+			cont.Ipv6, cont.Gateway6 = getOrGenerateGateway6(containerName, ip[2], cont.Vlan)
+		}
+
+		address6 := ""
+		if cont.Ipv6 != "" {
+			address6 = cont.Ipv6 + "/" + container.Ipv6Prefix
+		}
 
 		if common.GetMajorVersion() < 3 {
 			container.SetContainerConf(containerName, [][]string{
 				{"lxc.network.flags", "up"},
 				{"lxc.network.ipv4.address", fmt.Sprintf("%s/24", cont.Ip)},
 				{"lxc.network.ipv4.gateway", cont.Gateway},
+				{"lxc.network.ipv6.address", address6},
+				{"lxc.network.ipv6.gateway", cont.Gateway6},
 				{"#vlan_id", cont.Vlan},
 			})
 		} else {
@@ -132,23 +180,28 @@ func RestoreContainer(containerName, envID, addr, consoleSecret string) {
 				{"lxc.net.0.flags", "up"},
 				{"lxc.net.0.ipv4.address", fmt.Sprintf("%s/24", cont.Ip)},
 				{"lxc.net.0.ipv4.gateway", cont.Gateway},
+				{"lxc.net.0.ipv6.address", address6},
+				{"lxc.net.0.ipv6.gateway", cont.Gateway6},
 				{"#vlan_id", cont.Vlan},
 			})
 		}
 	} else {
-		//determine next free IP
+		//determine next free IP among .100-.199 of /24 network of management container
+		gateway := config.Management.ContainerGateway
+		network := gateway[:strings.LastIndex(gateway, ".")+1]
 		freeIPs := make(map[string]bool)
 		for i := 100; i < 200; i++ {
-			freeIPs[fmt.Sprintf("10.10.10.%d", i)] = true
+			freeIPs[fmt.Sprintf("%s%d", network, i)] = true
 		}
 
 		for _, cont := range container.Containers() {
-			ip := container.GetIp(cont)
-			delete(freeIPs, ip)
+			for _, ip := range strings.Fields(container.GetIp(cont)) {
+				delete(freeIPs, ip)
+			}
 		}
 
 		if len(freeIPs) == 0 {
-			log.Error("There is no free IP in range 10.10.10.1xx left")
+			log.Error("There is no free IP in range " + network + "1xx left")
 		}
 
 		//sort IPs
@@ -158,13 +211,15 @@ func RestoreContainer(containerName, envID, addr, consoleSecret string) {
 		})
 		//use first free ip
 		cont.Ip = keys[0].String()
-		cont.Gateway = "10.10.10.254"
+		cont.Gateway = gateway
 
 		if common.GetMajorVersion() < 3 {
 			container.SetContainerConf(containerName, [][]string{
 				{"lxc.network.flags", "up"},
 				{"lxc.network.ipv4.address", fmt.Sprintf("%s/24", cont.Ip)},
 				{"lxc.network.ipv4.gateway", cont.Gateway},
+				{"lxc.network.ipv6.address"},
+				{"lxc.network.ipv6.gateway"},
 				{"#vlan_id"},
 			})
 		} else {
@@ -172,34 +227,10 @@ func RestoreContainer(containerName, envID, addr, consoleSecret string) {
 				{"lxc.net.0.flags", "up"},
 				{"lxc.net.0.ipv4.address", fmt.Sprintf("%s/24", cont.Ip)},
 				{"lxc.net.0.ipv4.gateway", cont.Gateway},
+				{"lxc.net.0.ipv6.address"},
+				{"lxc.net.0.ipv6.gateway"},
 				{"#vlan_id"},
 			})
 		}
 	}
-
-	//changing from dhcp to manual
-	container.SetStaticNet(containerName)
-
-	cont.Uid, _ = container.SetContainerUID(containerName)
-
-	//Need to change it in parent templates
-	container.SetDNS(containerName)
-	//add subutai.template.owner & subutai.template.version
-	container.CopyParentReference(containerName, t.Owner, t.Version)
-
-	//Security matters workaround. Need to change it in parent templates
-	container.DisableSSHPwd(containerName)
-
-	if common.GetMajorVersion() < 3 {
-		cont.Interface = container.GetProperty(containerName, "lxc.network.veth.pair")
-	} else {
-		cont.Interface = container.GetProperty(containerName, "lxc.net.0.veth.pair")
-	}
-
-	log.Check(log.ErrorLevel, "Writing container metadata to database", db.SaveContainer(cont))
-
-	LxcStart(containerName)
-
-	log.Info(containerName + " with ID " + gpg.GetFingerprint(containerName) + " successfully restored")
-
 }
@@ -0,0 +1,226 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/lib/proxy"
+	"github.com/subutai-io/agent/log"
+)
+
+const runtimeBundleVersion = 1
+
+// RuntimeBundle holds container state which is not part of its filesystem and gets lost with snapshots:
+// quotas, quota alert thresholds, proxies (including port mappings) the container is member of and restart policy
+type RuntimeBundle struct {
+	Version    int               `json:"version"`
+	Container  string            `json:"container"`
+	Ip         string            `json:"ip"`
+	Quotas     map[string]string `json:"quotas"`
+	Thresholds map[string]string `json:"thresholds"`
+	Proxies    []ProxyMembership `json:"proxies"`
+	//container is started automatically by agent if it is supposed to be running
+	AutoStart bool `json:"autostart"`
+}
+
+type ProxyMembership struct {
+	Protocol       string   `json:"protocol"`
+	Domain         string   `json:"domain"`
+	Port           int      `json:"port"`
+	Tag            string   `json:"tag"`
+	LoadBalancing  string   `json:"load-balancing"`
+	CertPath       string   `json:"cert-path"`
+	Redirect80Port bool     `json:"redirect-80-port"`
+	SslBackend     bool     `json:"ssl-backend"`
+	Http2          bool     `json:"http2"`
+	Bind           string   `json:"bind,omitempty"`
+	Reflect        string   `json:"reflect,omitempty"`
+	Sockets        []string `json:"sockets"`
+	//server sets of sockets not in default set
+	Sets map[string]string `json:"sets,omitempty"`
+}
+
+// ExportRuntimeBundle prints runtime state of container as JSON or saves it to file
+//
+// subutai runtime export foo [-f /tmp/foo.json]
+func ExportRuntimeBundle(name, file string) {
+	name = strings.TrimSpace(name)
+	checkArgument(name != "", "Invalid container name")
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	bundle := RuntimeBundle{
+		Version:    runtimeBundleVersion,
+		Container:  name,
+		Ip:         containerIp(name),
+		Quotas:     make(map[string]string),
+		Thresholds: make(map[string]string),
+	}
+
+	//quotas in form accepted by "subutai quota set"
+	props := container.GetProperties(name, "subutai.network.ratelimit", "lxc.cgroup.blkio.weight")
+	if ram := strings.TrimSuffix(container.CgroupProperty(name, "memory.limit_in_bytes"), "M"); ram != "" {
+		bundle.Quotas["ram"] = ram
+	}
+	if cfsQuota, err := strconv.Atoi(container.CgroupProperty(name, "cpu.cfs_quota_us")); err == nil && cfsQuota > 0 {
+		//percents are portable between hosts with different number of cores
+		bundle.Quotas["cpu"] = strconv.Itoa(cfsQuota * 100 / 100000 / runtime.NumCPU())
+	}
+	if container.IsCPUsetAuto(name) {
+		//cores are assigned by agent of target host
+		bundle.Quotas["cpuset"] = container.CPUsetAuto
+	} else if cpuset := container.CgroupProperty(name, "cpuset.cpus"); cpuset != "" {
+		bundle.Quotas["cpuset"] = cpuset
+	}
+	if hugepages, err := container.QuotaHugepages(name, ""); err == nil && hugepages > 0 {
+		bundle.Quotas["hugepages"] = strconv.Itoa(hugepages)
+	}
+	for _, dir := range []string{"tmp", "run"} {
+		if size, err := container.QuotaTmpfs(name, dir, ""); err == nil && size > 0 {
+			bundle.Quotas[dir] = strconv.Itoa(size)
+		}
+	}
+	if policy := container.NUMAPolicy(name); policy != "" {
+		bundle.Quotas["numa"] = policy
+	}
+	if network := props["subutai.network.ratelimit"]; network != "" {
+		bundle.Quotas["network"] = network
+	}
+	if io := props["lxc.cgroup.blkio.weight"]; io != "" {
+		bundle.Quotas["io"] = io
+	}
+	if disk, err := fs.GetQuota(name); err == nil && disk > 0 {
+		bundle.Quotas["disk"] = strconv.Itoa(disk / 1024 / 1024 / 1024)
+	}
+
+	for _, resource := range []string{"cpu", "ram", "rootfs", "home", "var", "opt"} {
+		if threshold := getQuotaThreshold(name, resource); threshold != "0" {
+			bundle.Thresholds[resource] = threshold
+		}
+	}
+
+	if bundle.Ip != "" {
+		proxies, err := proxy.GetProxies("")
+		log.Check(log.ErrorLevel, "Getting proxies", err)
+		for _, p := range proxies {
+			var sockets []string
+			sets := make(map[string]string)
+			for _, server := range p.Servers {
+				if net.SocketHost(server.Socket) == bundle.Ip {
+					sockets = append(sockets, server.Socket)
+					if set := proxy.ServerSet(server); set != proxy.DefaultSet {
+						sets[server.Socket] = set
+					}
+				}
+			}
+			if len(sockets) > 0 {
+				bundle.Proxies = append(bundle.Proxies, ProxyMembership{
+					Protocol: p.Proxy.Protocol, Domain: p.Proxy.Domain, Port: p.Proxy.Port, Tag: p.Proxy.Tag,
+					LoadBalancing: p.Proxy.LoadBalancing, CertPath: p.Proxy.CertPath, Redirect80Port: p.Proxy.Redirect80Port,
+					SslBackend: p.Proxy.SslBackend, Http2: p.Proxy.Http2, Bind: p.Proxy.Bind, Reflect: p.Proxy.Reflect,
+					Sockets: sockets, Sets: sets,
+				})
+			}
+		}
+	}
+
+	cont, err := db.FindContainerByName(name)
+	log.Check(log.ErrorLevel, "Reading container metadata from db", err)
+	bundle.AutoStart = cont != nil && cont.State == container.Running
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	log.Check(log.ErrorLevel, "Marshalling runtime bundle", err)
+
+	if strings.TrimSpace(file) == "" {
+		fmt.Println(string(data))
+		return
+	}
+
+	log.Check(log.ErrorLevel, "Writing runtime bundle", ioutil.WriteFile(file, data, 0600))
+}
+
+// ImportRuntimeBundle re-applies runtime state saved by ExportRuntimeBundle to container,
+// e.g. after migration to another host or restore. Proxied sockets are rewritten to the current container ip.
+//
+// subutai runtime import foo /tmp/foo.json
+func ImportRuntimeBundle(name, file string) {
+	name = strings.TrimSpace(name)
+	checkArgument(name != "", "Invalid container name")
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkState(fs.FileExists(file), "File %s not found", file)
+
+	data, err := ioutil.ReadFile(file)
+	log.Check(log.ErrorLevel, "Reading runtime bundle", err)
+
+	var bundle RuntimeBundle
+	log.Check(log.ErrorLevel, "Parsing runtime bundle", json.Unmarshal(data, &bundle))
+	checkState(bundle.Version == runtimeBundleVersion, "Unsupported runtime bundle version %d", bundle.Version)
+
+	//numa placement goes after cpu and ram quotas it is fitted to
+	for _, resource := range quotaResources {
+		if value, ok := bundle.Quotas[resource]; ok {
+			applyQuota(name, resource, value)
+		}
+	}
+
+	for resource, value := range bundle.Thresholds {
+		setQuotaThreshold(name, resource, value)
+	}
+
+	ip := containerIp(name)
+	for _, p := range bundle.Proxies {
+		prxy, err := proxy.FindProxyByTag(p.Tag)
+		log.Check(log.ErrorLevel, "Getting proxy from db", err)
+
+		if prxy == nil {
+			err = proxy.CreateProxy(p.Protocol, p.Domain, p.LoadBalancing, p.Tag, p.Port, p.Redirect80Port,
+				p.SslBackend, p.CertPath, p.Http2, p.Bind, p.Reflect)
+			if log.Check(log.WarnLevel, "Creating proxy "+p.Tag, err) {
+				continue
+			}
+		}
+
+		for _, socket := range p.Sockets {
+			set := p.Sets[socket]
+			//container may have got another ip after migration
+			if ip != "" && bundle.Ip != "" {
+				socket = strings.Replace(socket, bundle.Ip, ip, 1)
+			}
+
+			servers, err := proxy.FindProxiedServers(p.Tag, socket)
+			log.Check(log.ErrorLevel, "Getting proxied servers from db", err)
+			if len(servers) == 0 {
+				log.Check(log.WarnLevel, "Adding server "+socket+" to proxy "+p.Tag, proxy.AddProxiedServer(p.Tag, socket, set))
+			}
+		}
+	}
+
+	cont, err := db.FindContainerByName(name)
+	log.Check(log.ErrorLevel, "Reading container metadata from db", err)
+	if cont != nil {
+		if bundle.AutoStart {
+			cont.State = container.Running
+		} else {
+			cont.State = container.Stopped
+		}
+		log.Check(log.ErrorLevel, "Saving container metadata to db", db.SaveContainer(cont))
+	}
+
+	log.Info("Runtime state applied to " + name)
+}
+
+func containerIp(name string) string {
+	cont, err := db.FindContainerByName(name)
+	if !log.Check(log.WarnLevel, "Reading container metadata from db", err) && cont != nil && cont.Ip != "" {
+		return cont.Ip
+	}
+
+	return strings.Split(container.GetIp(name), " ")[0]
+}
@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// statuses of scan results
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanFailed   = "failed"
+)
+
+// scanTarget is directory of container and its read-only copy on host
+type scanTarget struct {
+	path string
+	dir  string
+}
+
+// ContainerScan scans files of container with scanner configured in [scan] section of agent config, e.g. ClamAV,
+// so scanners do not have to be installed in every container. Datasets of container are snapshotted and their
+// read-only snapshots are scanned on host. Result is saved, logged and posted to configured webhook; 1 is returned
+// if scan found anything or failed. Saved results are printed with list flag
+//
+// subutai scan foo [--path /home]
+// subutai scan foo --list
+func ContainerScan(name, scanPath string, list bool) int {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	if list {
+		results, err := db.FindScanResults(name)
+		log.Check(log.ErrorLevel, "Reading scan results", err)
+		sort.Slice(results, func(i, j int) bool { return results[i].Started.After(results[j].Started) })
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "STARTED\tPATH\tSTATUS\tFINDINGS")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", r.Started.Format(time.RFC3339), r.Path, r.Status, len(r.Findings))
+		}
+		w.Flush()
+		return 0
+	}
+
+	command := strings.Fields(config.Scan.Command)
+	checkState(len(command) > 0, "Scanner is not configured, set command in [scan] section of agent config")
+	if scanPath == "" {
+		scanPath = "/"
+	}
+	checkArgument(path.IsAbs(scanPath), "Path %s is not absolute", scanPath)
+	scanPath = path.Clean(scanPath)
+	file, err := container.OpenFile(name, scanPath, os.O_RDONLY, 0)
+	checkState(err == nil, "Path %s not found in %s", scanPath, name)
+	file.Close()
+
+	result := &db.ScanResult{Container: name, Path: scanPath, Started: time.Now()}
+
+	//snapshots are read-only and consistent, so scanner neither changes files nor races with container
+	label := "scan-" + result.Started.Format("20060102150405")
+	snapshot := name + "@" + label
+	log.Check(log.ErrorLevel, "Creating snapshot of "+name, fs.CreateSnapshot(snapshot, true))
+	defer func() {
+		log.Check(log.WarnLevel, "Removing snapshot "+snapshot, fs.RemoveDataset(snapshot, true))
+	}()
+
+	targets := scanTargets(name, label, scanPath)
+	args := command[1:]
+	for _, target := range targets {
+		args = append(args, target.dir)
+	}
+
+	timeout := time.Duration(config.Timeouts.Scan) * time.Second
+	if timeout <= 0 {
+		timeout = -1
+	}
+	log.Info("Scanning " + scanPath + " of " + name)
+	out, err := exec.Run(context.Background(), exec.Options{Timeout: timeout, Stderr: os.Stderr}, command[0], args...)
+
+	//scanners like clamscan exit with 1 if they found anything and print findings one per line
+	switch {
+	case err == nil:
+		result.Status = ScanClean
+	case out.ExitCode == 1:
+		result.Status = ScanInfected
+	default:
+		result.Status, result.Error = ScanFailed, err.Error()
+	}
+	//output of failed scanner explains failure rather than reports anything found
+	for _, line := range strings.Split(string(out.Stdout), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if result.Status == ScanFailed {
+			result.Errors = append(result.Errors, containerPaths(line, targets))
+		} else {
+			result.Findings = append(result.Findings, containerPaths(line, targets))
+		}
+	}
+	result.Finished = time.Now()
+
+	log.Check(log.WarnLevel, "Saving scan result", db.SaveScanResult(result))
+	emitScanEvent(*result)
+
+	for _, finding := range result.Findings {
+		fmt.Println(finding)
+	}
+	for _, line := range result.Errors {
+		fmt.Fprintln(os.Stderr, line)
+	}
+	if result.Status != ScanClean {
+		return 1
+	}
+	return 0
+}
+
+// scanTargets returns directories of snapshot on host holding path of container. Partitions other than rootfs
+// are separate datasets, so they are added when the whole container is scanned
+func scanTargets(name, label, scanPath string) []scanTarget {
+	snapshotDir := func(partition string) string {
+		return path.Join(config.Agent.LxcPrefix, name, partition, ".zfs", "snapshot", label)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(scanPath, "/"), "/", 2)
+	for _, partition := range fs.ChildDatasets {
+		if partition != "rootfs" && parts[0] == partition {
+			dir := snapshotDir(partition)
+			if len(parts) > 1 {
+				dir = path.Join(dir, parts[1])
+			}
+			return []scanTarget{{path: scanPath, dir: dir}}
+		}
+	}
+
+	targets := []scanTarget{{path: scanPath, dir: path.Join(snapshotDir("rootfs"), scanPath)}}
+	if scanPath == "/" {
+		for _, partition := range fs.ChildDatasets {
+			if partition != "rootfs" {
+				targets = append(targets, scanTarget{path: "/" + partition, dir: snapshotDir(partition)})
+			}
+		}
+	}
+
+	return targets
+}
+
+// containerPaths replaces directories of snapshot in output line of scanner with paths of container
+func containerPaths(line string, targets []scanTarget) string {
+	for _, target := range targets {
+		if strings.HasPrefix(line, target.dir) {
+			return strings.TrimSuffix(target.path, "/") + strings.TrimPrefix(line, target.dir)
+		}
+	}
+	return line
+}
+
+// emitScanEvent logs scan result and posts it to configured webhook
+func emitScanEvent(result db.ScanResult) {
+	switch result.Status {
+	case ScanClean:
+		log.Info("Scan of " + result.Path + " in " + result.Container + " found nothing")
+	case ScanInfected:
+		log.Warn(fmt.Sprintf("Scan of %s in %s found %d issues", result.Path, result.Container, len(result.Findings)))
+	default:
+		log.Warn("Scan of " + result.Path + " in " + result.Container + " failed: " + result.Error)
+	}
+
+	if config.Scan.Webhook != "" {
+		log.Check(log.WarnLevel, "Sending scan event to "+config.Scan.Webhook, postEvent(config.Scan.Webhook, result))
+	}
+}
@@ -1,18 +1,47 @@
 package cli
 
 import (
-	"strings"
-	"github.com/subutai-io/agent/lib/fs"
-	container2 "github.com/subutai-io/agent/lib/container"
+	"bytes"
+	"context"
 	"fmt"
-	"github.com/subutai-io/agent/log"
+	"github.com/pkg/errors"
 	"github.com/subutai-io/agent/config"
-	"path"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	container2 "github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/log"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"os/user"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
-func CreateSnapshot(container, partition, label string, stopContainer bool) {
+// tag of locks placed on snapshots backups are sent from
+const backupLockTag = "subutai-backup"
+
+var (
+	sshHostRx = regexp.MustCompile(`^[[:alnum:]]([[:alnum:]-]{0,61}[[:alnum:]])?(\.[[:alnum:]]([[:alnum:]-]{0,61}[[:alnum:]])?)*$`)
+	sshUserRx = regexp.MustCompile(`^[a-z_][a-z0-9_.-]{0,31}$`)
+)
+
+// CreateSnapshot creates snapshot of container partition or of all partitions. Description, creator and id of
+// operation snapshot is taken for are saved with it and shown by ListSnapshots
+//
+// subutai snapshot create -c foo -p all -l pre-upgrade [-d "before upgrade to php 7.4"] [--operation 1234]
+func CreateSnapshot(container, partition, label string, stopContainer bool, description, operation string) {
 
 	container = strings.TrimSpace(container)
 	partition = strings.ToLower(strings.TrimSpace(partition))
@@ -23,6 +52,7 @@ func CreateSnapshot(container, partition, label string, stopContainer bool) {
 	checkPartitionName(partition)
 
 	checkArgument(label != "", "Invalid snapshot label")
+	checkValid(container2.ValidateDatasetComponent(label))
 
 	// check that container exists
 	checkState(container2.IsContainer(container), "Container %s not found", container)
@@ -42,6 +72,21 @@ func CreateSnapshot(container, partition, label string, stopContainer bool) {
 	checkCondition(err == nil, func() {
 		log.Error("Failed to create snapshot ", err.Error())
 	})
+
+	meta := &db.SnapshotMeta{Snapshot: snapshot, Description: strings.TrimSpace(description), Creator: snapshotCreator(),
+		Operation: strings.TrimSpace(operation), Created: time.Now()}
+	log.Check(log.WarnLevel, "Saving snapshot metadata", db.SaveSnapshotMeta(meta))
+}
+
+// snapshotCreator returns user who runs agent command, the one who invoked sudo rather than root
+func snapshotCreator() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return ""
 }
 
 func RemoveSnapshot(container, partition, label string) {
@@ -54,17 +99,25 @@ func RemoveSnapshot(container, partition, label string) {
 	checkPartitionName(partition)
 
 	checkArgument(label != "", "Invalid snapshot label")
+	checkValid(container2.ValidateDatasetComponent(label))
 
 	// check that container exists
 	checkState(container2.IsContainer(container), "Container %s not found", container)
 	// check that snapshot with such label exists
 	snapshot := getSnapshotName(container, partition, label)
 	//checkState(fs.DatasetExists(snapshot), "Snapshot %s does not exist", snapshot)
+	held := heldSnapshots(container, partition, label)
+	checkState(len(held) == 0, "Snapshots %s are locked, unlock them first", strings.Join(held, ", "))
 
 	err := fs.RemoveDataset(snapshot, partition == "all")
 	checkCondition(err == nil, func() {
 		log.Error("Failed to remove snapshot ", err.Error())
 	})
+
+	log.Check(log.WarnLevel, "Removing snapshot metadata", db.RemoveSnapshotMetas(func(name string) bool {
+		return name == snapshot || partition == "all" && strings.HasPrefix(name, container+"/") &&
+			strings.HasSuffix(name, "@"+label)
+	}))
 }
 
 func ListSnapshots(container, partition string) string {
@@ -121,11 +174,48 @@ func ListSnapshots(container, partition string) string {
 		log.Error("Failed to list snapshots ", err.Error())
 	})
 
-	out = strings.TrimRight(out, "\n")
+	out = strings.TrimRight(describeSnapshots(out), "\n")
 
 	return out
 }
 
+// describeSnapshots appends metadata of snapshots to listing of zfs as extra columns. Snapshots of partitions taken together
+// are described by metadata of snapshot of all partitions
+func describeSnapshots(listing string) string {
+	metas, err := db.GetAllSnapshotMetas()
+	if log.Check(log.WarnLevel, "Reading snapshot metadata", err) {
+		return listing
+	}
+	byName := make(map[string]db.SnapshotMeta)
+	for _, m := range metas {
+		byName[m.Snapshot] = m
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		//columns of zfs are kept as they are, so parsers of listing keep working
+		if fields[0] == "NAME" {
+			fmt.Fprintf(w, "%s\t%s\tCREATOR\tOPERATION\tDESCRIPTION\n", fields[0], fields[1])
+			continue
+		}
+
+		name := strings.TrimPrefix(fields[0], config.Agent.Dataset+"/")
+		meta, ok := byName[name]
+		if parts := strings.SplitN(name, "@", 2); !ok && len(parts) == 2 {
+			meta = byName[strings.Split(parts[0], "/")[0]+"@"+parts[1]]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", fields[0], fields[1], meta.Creator, meta.Operation, meta.Description)
+	}
+	w.Flush()
+
+	return buf.String()
+}
+
 func RollbackToSnapshot(container, partition, label string, forceRollback, stopContainer bool) {
 	container = strings.TrimSpace(container)
 	partition = strings.ToLower(strings.TrimSpace(partition))
@@ -136,6 +226,7 @@ func RollbackToSnapshot(container, partition, label string, forceRollback, stopC
 	checkPartitionName(partition)
 
 	checkArgument(label != "", "Invalid snapshot label")
+	checkValid(container2.ValidateDatasetComponent(label))
 
 	// check that container exists
 	checkState(container2.IsContainer(container), "Container %s not found", container)
@@ -197,18 +288,210 @@ func RollbackToSnapshot(container, partition, label string, forceRollback, stopC
 
 }
 
-func SendContainerSnapshots(container, destDir string, labels ... string) {
+// CloneSnapshot creates new container from snapshot of all partitions of container, e.g. to fork environment as
+// of yesterday without sending snapshots around. New container gets own mac and ip address, uid mapping, key
+// and hostname. It belongs to tenant of container, so it is named with prefix of tenant and is limited by its quotas
+//
+// subutai snapshot clone foo yesterday bar
+func CloneSnapshot(container, label, child string) {
+	container, label = strings.TrimSpace(container), strings.TrimSpace(label)
+	checkState(container2.IsContainer(container), "Container %s not found", container)
+	checkArgument(label != "", "Invalid snapshot label")
+	checkValid(container2.ValidateNewName(child))
+	checkState(!container2.LxcInstanceExists(child), "Container %s already exists", child)
+
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: container},
+		common.LockKey{Kind: common.ContainerLock, Name: child})
+	defer lock.Release()
+
+	defer sendHeartbeat()
+
+	source, err := db.FindContainers(container, "", "")
+	log.Check(log.ErrorLevel, "Reading container metadata from database", err)
+	checkState(len(source) > 0, "Metadata of container %s not found", container)
+
+	//new container belongs to tenant of source and inherits its quotas
+	quotas := make(map[string]string)
+	for _, resource := range tenantLimits[:3] {
+		value, err := currentQuota(container, resource)
+		log.Check(log.ErrorLevel, "Reading "+resource+" quota of "+container, err)
+		quotas[resource] = value
+	}
+	tenantLock := checkTenantClone(source[0].Tenant, child, "", quotas)
+	defer tenantLock.Release()
+
+	//datasets of child are removed if its setup fails, also if failure stops process midway
+	created := false
+	log.AtExit(func(int) {
+		if !created && fs.DatasetExists(child) {
+			log.Check(log.WarnLevel, "Removing partially cloned "+child, container2.Destroy(child, true))
+		}
+	})
+
+	log.Check(log.ErrorLevel, "Cloning the container", container2.CloneContainer(container, child, label))
+
+	cont := &db.Container{Name: child, Template: source[0].Template, TemplateOwner: source[0].TemplateOwner,
+		TemplateVersion: source[0].TemplateVersion, TemplateId: source[0].TemplateId, Labels: source[0].Labels,
+		Tenant: source[0].Tenant}
+	log.Check(log.ErrorLevel, "Setting up "+child, setupSnapshotClone(child, cont))
+	created = true
+	setTenantDefaultQuotas(cont.Tenant, child)
+	tenantLock.Release()
+
+	LxcStart(child)
+
+	log.Info(child + " with ID " + gpg.GetFingerprint(child) + " successfully cloned from " + container + "@" + label)
+}
+
+// setupSnapshotClone gives container cloned from snapshot own key, address, uid mapping and DNS settings and
+// records it in db
+func setupSnapshotClone(child string, cont *db.Container) error {
+	if err := gpg.GenerateKey(child); err != nil {
+		return errors.Wrap(err, "Generating key")
+	}
+	setContainerNetwork(child, "", cont)
+	container2.SetStaticNet(child)
+	uid, err := container2.SetContainerUID(child)
+	if err != nil {
+		return errors.Wrap(err, "Mapping uids")
+	}
+	cont.Uid = uid
+	container2.SetDNS(child)
+
+	if common.GetMajorVersion() < 3 {
+		cont.Interface = container2.GetProperty(child, "lxc.network.veth.pair")
+	} else {
+		cont.Interface = container2.GetProperty(child, "lxc.net.0.veth.pair")
+	}
+
+	return errors.Wrap(db.SaveContainer(cont), "Writing container metadata to database")
+}
+
+// LockSnapshot places hold with tag on snapshot of container partition, or on snapshots of all partitions, so
+// the snapshot can not be removed, e.g. by retention of backups, until it is unlocked. Locks of container
+// snapshots are printed if label is empty
+//
+// subutai snapshot lock -c foo -p all -l backup-1 [--tag offsite]
+func LockSnapshot(container, partition, label, tag string) {
+	container, partition, label = checkSnapshotArgs(container, partition, label, tag)
+
+	if label == "" {
+		printSnapshotLocks(container)
+		return
+	}
+
+	for _, snapshot := range partitionSnapshots(container, partition, label) {
+		log.Check(log.ErrorLevel, "Locking snapshot "+snapshot, fs.HoldSnapshot(snapshot, tag))
+	}
+}
+
+// UnlockSnapshot releases hold with tag of snapshot of container partition, or of snapshots of all partitions
+//
+// subutai snapshot unlock -c foo -p all -l backup-1 [--tag offsite]
+func UnlockSnapshot(container, partition, label, tag string) {
+	container, partition, label = checkSnapshotArgs(container, partition, label, tag)
+	checkArgument(label != "", "Invalid snapshot label")
+
+	for _, snapshot := range partitionSnapshots(container, partition, label) {
+		log.Check(log.ErrorLevel, "Unlocking snapshot "+snapshot, fs.ReleaseSnapshot(snapshot, tag))
+	}
+}
+
+func checkSnapshotArgs(container, partition, label, tag string) (string, string, string) {
+	container = strings.TrimSpace(container)
+	partition = strings.ToLower(strings.TrimSpace(partition))
+	label = strings.ToLower(strings.TrimSpace(label))
+
+	checkArgument(container != "", "Invalid container name")
+	checkPartitionName(partition)
+	if label != "" {
+		checkValid(container2.ValidateDatasetComponent(label))
+	}
+	checkArgument(strings.TrimSpace(tag) != "", "Invalid lock tag")
+	checkState(container2.IsContainer(container), "Container %s not found", container)
+
+	return container, partition, label
+}
+
+// partitionSnapshots returns existing snapshots with label of partition, or of all partitions
+func partitionSnapshots(container, partition, label string) []string {
+	partitions := []string{partition}
+	if partition == "all" {
+		partitions = fs.ChildDatasets
+	}
+
+	var snapshots []string
+	for _, part := range partitions {
+		snapshot := getSnapshotName(container, part, label)
+		checkState(fs.DatasetExists(snapshot), "Snapshot %s does not exist", snapshot)
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+// heldSnapshots returns snapshots with label of partition, or of all partitions, which are locked
+func heldSnapshots(container, partition, label string) []string {
+	holds, err := fs.ListHolds(container)
+	log.Check(log.ErrorLevel, "Listing locks of snapshots", err)
+
+	var held []string
+	for snapshot := range holds {
+		if strings.HasSuffix(snapshot, "@"+label) &&
+			(partition == "all" || snapshot == getSnapshotName(container, partition, label)) {
+			held = append(held, snapshot)
+		}
+	}
+	sort.Strings(held)
+	return held
+}
+
+func printSnapshotLocks(container string) {
+	holds, err := fs.ListHolds(container)
+	log.Check(log.ErrorLevel, "Listing locks of snapshots", err)
+
+	snapshots := make([]string, 0, len(holds))
+	for snapshot := range holds {
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Strings(snapshots)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "SNAPSHOT\tTAGS")
+	for _, snapshot := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\n", snapshot, strings.Join(holds[snapshot], ","))
+	}
+	w.Flush()
+}
+
+// SendContainerSnapshots sends delta between template and snapshot of container, or between two snapshots of container,
+// to archive file in destination directory. With ssh url, e.g. ssh://root@host, deltas are piped through ssh to agent
+// of remote host without archive, transfer interrupted by network failure is resumed by running the command again.
+// Sending to remote host is limited to bandwidth limit per second, e.g. 50M, or to bwLimit agent config parameter
+//
+// subutai snapshot send -c foo -l monday,tuesday [--destination /tmp]
+// subutai snapshot send -c foo -l monday,tuesday --to ssh://root@10.0.0.2 [--bwlimit 50M]
+func SendContainerSnapshots(container, destDir, to, bwLimit string, labels ...string) {
 	container = strings.TrimSpace(container)
 	checkArgument(container != "", "Invalid container name")
 	checkState(container2.IsContainer(container), "Container %s not found", container)
 
-	destDir = strings.TrimSpace(destDir)
-	checkArgument(destDir != "", "Invalid destination directory")
-	checkState(fs.FileExists(destDir), "Destination directory %s not found", destDir)
+	var remote []string
+	var rate int64
+	if to = strings.TrimSpace(to); to != "" {
+		remote = sshCommand(to)
+		var err error
+		rate, err = fs.TransferRate(bwLimit)
+		checkValid(err)
+	} else {
+		destDir = strings.TrimSpace(destDir)
+		checkArgument(destDir != "", "Invalid destination directory")
+		checkState(fs.FileExists(destDir), "Destination directory %s not found", destDir)
+	}
 
 	checkArgument(len(labels) == 1 || len(labels) == 2, "Invalid number of snapshot labels")
 	for _, label := range labels {
 		checkArgument(label != "", "Invalid snapshot label")
+		checkValid(container2.ValidateDatasetComponent(label))
 	}
 	for _, label := range labels {
 		for _, partition := range fs.ChildDatasets {
@@ -219,47 +502,164 @@ func SendContainerSnapshots(container, destDir string, labels ... string) {
 		}
 	}
 
-	// create dump file
-	parts := []string{container}
-	parts = append(parts, labels...)
-	targetDir := path.Join(destDir, strings.Join(parts, "_"))
-	os.MkdirAll(targetDir, 0755)
-
-	parent := container2.GetProperty(container, "subutai.parent")
-	parentOwner := container2.GetProperty(container, "subutai.parent.owner")
-	parentVersion := container2.GetProperty(container, "subutai.parent.version")
-	parentRef := strings.Join([]string{parent, parentOwner, parentVersion}, ":")
+	parent, err := container2.ParentRef(container)
+	log.Check(log.ErrorLevel, "Reading parent template of "+container, err)
+	parentRef := parent.String()
+	checkState(len(labels) > 1 || container2.ParentSnapshot(container) == "now",
+		"Container %s is cloned from snapshot %s of its template, only deltas between its own snapshots can be sent",
+		container, container2.ParentSnapshot(container))
 
-	for _, partition := range fs.ChildDatasets {
-		var err error
+	// send incremental delta between parent and child, or between two child snapshots
+	snapshotFrom := func(partition string) string {
 		if len(labels) == 1 {
+			return getSnapshotName(parentRef, partition, "now")
+		}
+		return getSnapshotName(container, partition, labels[0])
+	}
+	snapshotTo := func(partition string) string {
+		return getSnapshotName(container, partition, labels[len(labels)-1])
+	}
 
-			// send incremental delta between parent and child to delta file
-			err = fs.SendStream(getSnapshotName(parentRef, partition, "now"),
-				getSnapshotName(container, partition, labels[0]), path.Join(targetDir, partition+".delta"))
-		} else {
-			// send incremental delta between two child snapshots to delta file
-			err = fs.SendStream(getSnapshotName(container, partition, labels[0]),
-				getSnapshotName(container, partition, labels[1]), path.Join(targetDir, partition+".delta"))
+	var targetDir string
+	if remote != nil {
+		for _, partition := range fs.ChildDatasets {
+			log.Check(log.ErrorLevel, "Sending stream for partition "+partition,
+				sendRemoteStream(remote, container, partition, snapshotFrom(partition), snapshotTo(partition), rate))
 		}
 
-		log.Check(log.ErrorLevel, "Sending stream for partition "+partition, err)
+		configFile, err := os.Open(path.Join(config.Agent.LxcPrefix, container, "config"))
+		log.Check(log.ErrorLevel, "Opening config file", err)
+		defer configFile.Close()
+		out, err := exec.Run(context.Background(), exec.Options{Stdin: fs.ThrottleReader(configFile, rate)}, remote[0],
+			append(remote[1:], "subutai", "snapshot", "receive", "-c", container, "--stream", "config")...)
+		log.Check(log.ErrorLevel, "Sending config file "+string(out.Stderr), err)
 
+		log.Check(log.ErrorLevel, "Verifying snapshots on "+to, verifyRemoteSnapshots(remote, container, labels[len(labels)-1]))
+	} else {
+		// create dump file
+		parts := []string{container}
+		parts = append(parts, labels...)
+		targetDir = path.Join(destDir, strings.Join(parts, "_"))
+		os.MkdirAll(targetDir, 0755)
+
+		for _, partition := range fs.ChildDatasets {
+			err := fs.SendStream(snapshotFrom(partition), snapshotTo(partition), path.Join(targetDir, partition+".delta"))
+			log.Check(log.ErrorLevel, "Sending stream for partition "+partition, err)
+		}
+
+		//copy config file
+		log.Check(log.ErrorLevel, "Copying config file", fs.Copy(path.Join(config.Agent.LxcPrefix, container, "config"), path.Join(targetDir, "config")))
+
+		//guids let receiver verify it got the very snapshots sent
+		log.Check(log.ErrorLevel, "Writing snapshot guids", writeGuidsManifest(container, labels[len(labels)-1], targetDir))
 	}
 
-	//copy config file
-	log.Check(log.ErrorLevel, "Copying config file", fs.Copy(path.Join(config.Agent.LxcPrefix, container, "config"), path.Join(targetDir, "config")))
+	//the next incremental backup is sent from the latest snapshot, so it is locked instead of the previous one
+	latest := labels[len(labels)-1]
+	for _, partition := range fs.ChildDatasets {
+		if len(labels) > 1 {
+			log.Check(log.DebugLevel, "Unlocking previous backup snapshot",
+				fs.ReleaseSnapshot(getSnapshotName(container, partition, labels[0]), backupLockTag))
+		}
+		log.Check(log.DebugLevel, "Locking backup snapshot",
+			fs.HoldSnapshot(getSnapshotName(container, partition, latest), backupLockTag))
+	}
+
+	if remote != nil {
+		log.Info(container + " snapshots got sent to " + to)
+		return
+	}
 
 	//archive template contents
-	targetFile := targetDir + ".tar.gz"
+	targetFile := targetDir + fs.ArchiveExtension()
 	fs.Compress(targetDir, targetFile)
 	log.Check(log.WarnLevel, "Removing temporary directory", os.RemoveAll(targetDir))
 	log.Info(container + " snapshots got dumped to " + targetFile)
 }
 
+// sshCommand returns ssh command line running commands on host of url, e.g. ssh://root@host:22.
+// Commands of user other than root are run with sudo
+func sshCommand(to string) []string {
+	u, err := url.Parse(to)
+	checkArgument(err == nil && u.Scheme == "ssh" && u.Hostname() != "", "Invalid destination %s, ssh://user@host expected", to)
+	//host and user are passed to ssh as arguments, so they must not be taken for its options
+	checkArgument(sshHostRx.MatchString(u.Hostname()) || net.ParseIP(u.Hostname()) != nil,
+		"Invalid host %s of destination", u.Hostname())
+	if u.User != nil {
+		_, hasPassword := u.User.Password()
+		checkArgument(sshUserRx.MatchString(u.User.Username()) && !hasPassword,
+			"Invalid user of destination %s, only user name is accepted", to)
+	}
+	if u.Port() != "" {
+		port, err := strconv.Atoi(u.Port())
+		checkArgument(err == nil && port > 0 && port < 65536, "Invalid port %s of destination", u.Port())
+	}
+	checkArgument(strings.Trim(u.Path, "/") == "" && u.RawQuery == "", "Invalid destination %s, ssh://user@host expected", to)
+
+	command := []string{"ssh", "-o", "BatchMode=yes"}
+	if u.Port() != "" {
+		command = append(command, "-p", u.Port())
+	}
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	command = append(command, host)
+	if u.User != nil && u.User.Username() != "root" {
+		command = append(command, "sudo", "-n")
+	}
+
+	return command
+}
+
+// sendRemoteStream pipes stream of partition to agent of remote host. Transfer interrupted before is resumed
+// first, stream is skipped if the resumed transfer was already the stream of the same snapshot. Stream is limited
+// to rate bytes per second unless rate is 0
+func sendRemoteStream(remote []string, container, partition, snapshotFrom, snapshotTo string, rate int64) error {
+	receive := append(append([]string{}, remote[1:]...), "subutai", "snapshot", "receive", "-c", container, "--stream", partition)
+
+	out, err := exec.Run(context.Background(), exec.Options{}, remote[0], append(receive, "--token")...)
+	if err != nil {
+		return errors.Errorf("Reading resume token: %s %s", out.Stderr, err.Error())
+	}
+	tokens := []string{""}
+	if token := strings.TrimSpace(string(out.Stdout)); token != "" {
+		log.Info("Resuming interrupted transfer of partition " + partition)
+		tokens = []string{token, ""}
+	}
+
+	for _, token := range tokens {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(fs.SendStreamTo(snapshotFrom, snapshotTo, token, fs.ThrottleWriter(writer, rate)))
+		}()
+
+		out, err = exec.Run(context.Background(), exec.Options{Stdin: reader, Timeout: exec.Timeout("zfs")},
+			remote[0], receive...)
+		reader.CloseWithError(io.ErrClosedPipe)
+		if err != nil {
+			//stream of resumed snapshot is rejected by zfs receive, since the snapshot already exists
+			if token == "" && len(tokens) > 1 && strings.Contains(string(out.Stderr), "exists") {
+				return nil
+			}
+			return errors.Errorf("%s %s", out.Stderr, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// ReceiveContainerSnapshots receives snapshots sent to archive file by SendContainerSnapshots. Received snapshots
+// are verified against guids of sent snapshots, so broken chain is reported before container is restored
+//
+// subutai snapshot receive -c foo -f /tmp/foo_monday_tuesday.tar.gz
 func ReceiveContainerSnapshots(container, sourceFile string) {
 	container = strings.TrimSpace(container)
 	checkArgument(container != "", "Invalid container name")
+	//snapshots create container if it does not exist yet
+	if !fs.DatasetExists(container) {
+		checkValid(container2.ValidateNewName(container))
+	}
 
 	sourceFile = strings.TrimSpace(sourceFile)
 	checkArgument(sourceFile != "", "Invalid path to snapshots file")
@@ -295,8 +695,50 @@ func ReceiveContainerSnapshots(container, sourceFile string) {
 	//copy config file
 	log.Check(log.ErrorLevel, "Copying config file", fs.Copy(path.Join(dest, "config"), path.Join(config.Agent.LxcPrefix, container, "config")))
 
+	label, guids, err := readGuidsManifest(dest)
+	log.Check(log.ErrorLevel, "Reading snapshot guids", err)
+
 	//remove decompressed archive folder
 	log.Check(log.WarnLevel, "Removing temporary directory", os.RemoveAll(dest))
+
+	checkSnapshotChain(container, label, guids)
+}
+
+// ReceiveContainerStream receives stream of container partition, or config file, read from stdin. It is endpoint
+// of SendContainerSnapshots sending to remote host over ssh. Token of interrupted transfer is printed with token flag
+//
+// ssh root@host subutai snapshot receive -c foo --stream rootfs < rootfs.stream
+func ReceiveContainerStream(container, partition string, token bool) {
+	container = strings.TrimSpace(container)
+	checkArgument(container != "", "Invalid container name")
+	if !fs.DatasetExists(container) {
+		checkValid(container2.ValidateNewName(container))
+	}
+	checkArgument(partition == "config" || stringInList(partition, fs.ChildDatasets), "Invalid partition %s", partition)
+
+	if token {
+		if partition != "config" {
+			resume, err := fs.ResumeToken(path.Join(container, partition))
+			log.Check(log.ErrorLevel, "Reading resume token", err)
+			fmt.Println(resume)
+		}
+		return
+	}
+
+	if !fs.DatasetExists(container) {
+		log.Check(log.ErrorLevel, "Creating dataset "+container, fs.CreateDataset(container))
+	}
+
+	if partition == "config" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		log.Check(log.ErrorLevel, "Reading config file", err)
+		log.Check(log.ErrorLevel, "Writing config file",
+			ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, container, "config"), data, 0644))
+		return
+	}
+
+	log.Check(log.ErrorLevel, "Receiving snapshots for partition "+partition,
+		fs.ReceiveResumable(path.Join(container, partition), os.Stdin))
 }
 
 func getFileName(filePath string) string {
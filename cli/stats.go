@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/metrics"
+	"github.com/subutai-io/agent/log"
+)
+
+// ContainerStats prints actual resource consumption of running container next to its quotas: CPU load over
+// a second, memory, disk, block IO and network traffic counted since container start
+//
+// subutai stats foo [--json]
+func ContainerStats(name string, asJSON bool) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+	checkState(container.State(name) == container.Running, "Container %s is not running", name)
+
+	m, err := metrics.Sample(name, time.Second)
+	log.Check(log.ErrorLevel, "Collecting metrics of "+name, err)
+
+	if asJSON {
+		out, err := json.Marshal(m)
+		log.Check(log.ErrorLevel, "Marshalling metrics", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	unlimited := func(quota uint64) string {
+		if quota == 0 {
+			return "-"
+		}
+		return formatSize(int64(quota))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "RESOURCE\tUSAGE\tQUOTA\tDETAIL")
+
+	cpuQuota := "-"
+	if m.CPU.Quota > 0 {
+		cpuQuota = strconv.Itoa(m.CPU.Quota) + "%"
+	}
+	fmt.Fprintf(w, "cpu\t%.1f%%\t%s\t%s consumed\n", m.CPU.Load, cpuQuota,
+		(time.Duration(m.CPU.Usage) * time.Nanosecond).Round(time.Millisecond))
+	fmt.Fprintf(w, "ram\t%s\t%s\tpeak %s, cache %s\n", formatSize(int64(m.Memory.Usage)), unlimited(m.Memory.Quota),
+		formatSize(int64(m.Memory.MaxUsage)), formatSize(int64(m.Memory.Cache)))
+	fmt.Fprintf(w, "disk\t%s\t%s\t\n", formatSize(int64(m.Disk.Used)), unlimited(m.Disk.Quota))
+	fmt.Fprintf(w, "blkio\t%s read, %s written\t-\t%d reads, %d writes, weight %d\n",
+		formatSize(int64(m.BlockIO.ReadBytes)), formatSize(int64(m.BlockIO.WriteBytes)),
+		m.BlockIO.ReadOps, m.BlockIO.WriteOps, m.BlockIO.Weight)
+
+	netQuota := "-"
+	if m.Network.Quota > 0 {
+		netQuota = strconv.Itoa(m.Network.Quota) + " Kbps"
+	}
+	fmt.Fprintf(w, "network\t%s received, %s sent\t%s\t%s, %d/%d packets, %d/%d dropped\n",
+		formatSize(int64(m.Network.RxBytes)), formatSize(int64(m.Network.TxBytes)), netQuota, m.Network.Interface,
+		m.Network.RxPackets, m.Network.TxPackets, m.Network.RxDropped, m.Network.TxDropped)
+
+	w.Flush()
+}
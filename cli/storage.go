@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	kindTemplate  = "template"
+	kindContainer = "container"
+	kindOther     = "other"
+)
+
+// share of snapshots in used space starting from which snapshots are reported
+const snapshotsShareToReport = 0.5
+
+// storageEntity is a template, container or other top-level dataset with its partitions
+type storageEntity struct {
+	name       string
+	kind       string
+	used       int64
+	snapshots  int64
+	parent     string
+	dependents []string
+}
+
+// StorageReport analyzes zfs space accounting of templates and containers:
+// space used by each template chain (template with all templates and containers cloned from it),
+// space used by each container overlay, and gives recommendations on how to reclaim space
+//
+// subutai storage report
+func StorageReport() {
+	datasets, err := fs.ListDatasetsUsage()
+	log.Check(log.ErrorLevel, "Getting datasets usage", err)
+
+	entities := make(map[string]*storageEntity)
+	var names []string
+
+	for _, ds := range datasets {
+		parts := strings.SplitN(ds.Name, "/", 2)
+		name := parts[0]
+
+		e, ok := entities[name]
+		if !ok {
+			e = &storageEntity{name: name, kind: kindOther}
+			entities[name] = e
+			names = append(names, name)
+		}
+
+		if len(parts) == 1 {
+			//used of top-level dataset includes its partitions
+			e.used = ds.Used
+			e.snapshots += ds.UsedBySnapshots
+			continue
+		}
+
+		e.snapshots += ds.UsedBySnapshots
+
+		//clone origin of rootfs points to parent template, e.g. debian-stretch:subutai:0.4.5/rootfs@now
+		if parts[1] == "rootfs" {
+			if container.IsTemplate(name) {
+				e.kind = kindTemplate
+			} else {
+				e.kind = kindContainer
+			}
+			if ds.Origin != "" {
+				e.parent = strings.SplitN(ds.Origin, "/", 2)[0]
+			}
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		e := entities[name]
+		if p, ok := entities[e.parent]; ok && e.parent != name {
+			p.dependents = append(p.dependents, name)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+
+	size, allocated, free, err := fs.GetPoolUsage()
+	if !log.Check(log.WarnLevel, "Getting pool usage", err) {
+		fmt.Fprintf(w, "Pool size:\t%s\n", formatSize(size))
+		fmt.Fprintf(w, "Allocated:\t%s (%.1f%%)\n", formatSize(allocated), float64(allocated)*100/float64(size))
+		fmt.Fprintf(w, "Free:\t%s\n", formatSize(free))
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "TEMPLATE\tOWN\tSNAPSHOTS\tCHAIN TOTAL\tTEMPLATES\tCONTAINERS")
+	for _, name := range names {
+		e := entities[name]
+		if e.kind != kindTemplate {
+			continue
+		}
+		total, templates, containers := chainUsage(entities, name, make(map[string]bool))
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n", name, formatSize(e.used), formatSize(e.snapshots),
+			formatSize(total), templates, containers)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "CONTAINER\tOVERLAY\tSNAPSHOTS\tTEMPLATE")
+	for _, name := range names {
+		e := entities[name]
+		if e.kind != kindContainer {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, formatSize(e.used), formatSize(e.snapshots), e.parent)
+	}
+
+	var recommendations []string
+	for _, name := range names {
+		e := entities[name]
+		switch {
+		case e.kind == kindTemplate && len(e.dependents) == 0:
+			recommendations = append(recommendations, fmt.Sprintf(
+				"Template %s is not used by any container or template, pruning it would reclaim %s", name, formatSize(e.used)))
+		case e.kind == kindOther:
+			recommendations = append(recommendations, fmt.Sprintf(
+				"Dataset %s is neither a container nor a template and uses %s", name, formatSize(e.used)))
+		}
+		if e.used > 0 && float64(e.snapshots)/float64(e.used) >= snapshotsShareToReport {
+			recommendations = append(recommendations, fmt.Sprintf(
+				"Snapshots of %s hold %s, consider removing old ones", name, formatSize(e.snapshots)))
+		}
+	}
+
+	if len(recommendations) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "RECOMMENDATIONS")
+		for _, r := range recommendations {
+			fmt.Fprintln(w, r)
+		}
+	}
+
+	w.Flush()
+}
+
+// chainUsage sums space used by entity and all its descendants
+func chainUsage(entities map[string]*storageEntity, name string, visited map[string]bool) (total int64, templates, containers int) {
+	e, ok := entities[name]
+	if !ok || visited[name] {
+		return 0, 0, 0
+	}
+	visited[name] = true
+
+	total = e.used
+	for _, d := range e.dependents {
+		t, tmpl, cont := chainUsage(entities, d, visited)
+		total += t
+		templates += tmpl
+		containers += cont
+		if entities[d].kind == kindTemplate {
+			templates++
+		} else if entities[d].kind == kindContainer {
+			containers++
+		}
+	}
+
+	return total, templates, containers
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/subutai-io/agent/agent/telemetry"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+// TelemetryShow prints report exactly as it would be sent to telemetry endpoint,
+// so the data can be reviewed before reporting is enabled
+//
+// subutai telemetry show
+func TelemetryShow() {
+	report, err := telemetry.Collect()
+	log.Check(log.ErrorLevel, "Collecting telemetry report", err)
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	log.Check(log.ErrorLevel, "Marshalling telemetry report", err)
+
+	if telemetry.Enabled() {
+		fmt.Printf("Telemetry is enabled, reports are sent to %s every %d hours\n", config.Telemetry.Endpoint, config.Telemetry.Interval)
+	} else {
+		fmt.Println("Telemetry is disabled, set enabled and endpoint in [telemetry] section of agent config to turn it on")
+		fmt.Println("Installation id below is a sample, id of host is generated once telemetry is turned on")
+	}
+	fmt.Println(string(out))
+}
@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+// PrintTemplateStats prints per-template import counts, cache hits/misses and download failures
+// followed by per-mirror download performance. It helps to decide which templates are worth pre-seeding
+// and which CDN mirror performs poorly
+//
+// subutai template stats
+func PrintTemplateStats() {
+	templates, err := db.GetTemplateStats()
+	log.Check(log.ErrorLevel, "Reading template stats", err)
+
+	mirrors, err := db.GetMirrorStats()
+	log.Check(log.ErrorLevel, "Reading mirror stats", err)
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Imports > templates[j].Imports
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+
+	fmt.Fprintln(w, "TEMPLATE\tIMPORTS\tCACHE HITS\tCACHE MISSES\tDOWNLOADS\tAVG DOWNLOAD\tFAILURES\tLAST IMPORT")
+	for _, t := range templates {
+		lastImport := "-"
+		if !t.LastImport.IsZero() {
+			lastImport = t.LastImport.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%d\t%s\n", t.Template, t.Imports, t.CacheHits, t.CacheMisses,
+			t.Downloads, average(t.DownloadTime, t.Downloads), t.Failures, lastImport)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "MIRROR\tDOWNLOADS\tFAILURES\tAVG DOWNLOAD\tAVG SPEED\tHEALTH")
+	for _, m := range mirrors {
+		speed := "-"
+		if m.DownloadTime > 0 && m.Bytes > 0 {
+			speed = fmt.Sprintf("%.2f MB/s", float64(m.Bytes)/m.DownloadTime.Seconds()/1024/1024)
+		}
+		health := "healthy"
+		if !mirrorHealthy(m.Mirror, mirrors) {
+			health = fmt.Sprintf("failing, %d failures in a row", m.ConsecutiveFailures)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", m.Mirror, m.Downloads, m.Failures,
+			average(m.DownloadTime, m.Downloads), speed, health)
+	}
+
+	var failures []string
+	for _, t := range templates {
+		for reason, count := range t.FailureReasons {
+			failures = append(failures, fmt.Sprintf("%s\t%d\t%s", t.Template, count, reason))
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "TEMPLATE\tFAILURES\tREASON")
+		fmt.Fprintln(w, strings.Join(failures, "\n"))
+	}
+
+	w.Flush()
+}
+
+func average(total time.Duration, count int) string {
+	if count == 0 {
+		return "-"
+	}
+
+	return (total / time.Duration(count)).Round(time.Millisecond).String()
+}
+
+// InspectTemplate prints manifest, config and delta sizes of template archive
+// reading it in a single pass without extracting to disk.
+// It allows to validate third-party archives before importing them
+//
+// subutai template inspect /tmp/foo-subutai-template_0.0.1_amd64.tar.gz
+func InspectTemplate(archive string) {
+	archive = strings.TrimSpace(archive)
+	checkArgument(archive != "", "Invalid path to template archive")
+	checkState(fs.FileExists(archive), "File %s not found", archive)
+
+	var manifest *Manifest
+	var configLines []string
+	deltas := make(map[string]int64)
+	var other []string
+
+	err := fs.WalkArchive(archive, func(name string, size int64, input io.Reader) error {
+		switch {
+		case name == manifestFile:
+			data, err := ioutil.ReadAll(input)
+			if err != nil {
+				return err
+			}
+			manifest = &Manifest{}
+			return json.Unmarshal(data, manifest)
+		case name == "config":
+			scanner := bufio.NewScanner(input)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line != "" && !strings.HasPrefix(line, "#") {
+					configLines = append(configLines, line)
+				}
+			}
+			return scanner.Err()
+		case isDelta(name):
+			deltas[strings.TrimSuffix(path.Base(name), ".delta")] = size
+		default:
+			other = append(other, name)
+		}
+		return nil
+	})
+	log.Check(log.ErrorLevel, "Reading template archive", err)
+
+	archiveSize, err := fs.FileSize(archive)
+	log.Check(log.WarnLevel, "Getting archive size", err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+
+	fmt.Fprintln(w, "MANIFEST")
+	if manifest != nil {
+		fmt.Fprintf(w, "Template:\t%s\n", manifest.Ref())
+		fmt.Fprintf(w, "Parent:\t%s\n", manifest.Parent)
+		fmt.Fprintf(w, "Parent chain:\t%s\n", strings.Join(manifest.ParentChain, " <- "))
+		fmt.Fprintf(w, "Preferred size:\t%s\n", manifest.PrefSize)
+		fmt.Fprintf(w, "Signed with:\t%s\n", orNone(manifest.SigningKey))
+		printTemplateMetadata(w, manifest.TemplateMetadata)
+	} else {
+		fmt.Fprintln(w, "Archive has no manifest")
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "CONFIG")
+	for _, line := range configLines {
+		fmt.Fprintln(w, line)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "PARTITION\tDELTA SIZE\tDIGEST")
+	var total int64
+	for _, partition := range fs.ChildDatasets {
+		size, ok := deltas[partition]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%s\t\n", partition, "missing")
+			continue
+		}
+		total += size
+		digest := ""
+		if manifest != nil {
+			digest = manifest.Deltas[partition]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", partition, formatSize(size), digest)
+	}
+
+	if len(other) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "OTHER FILES")
+		for _, name := range other {
+			fmt.Fprintln(w, name)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Archive size:\t%s\n", formatSize(archiveSize))
+	fmt.Fprintf(w, "Estimated installed size:\t%s\n", formatSize(total))
+
+	w.Flush()
+}
+
+// TemplateInfo prints reference, parent, preferred size and provenance metadata of installed template
+//
+// subutai template info debian-stretch:subutai:0.4.5 [--json]
+func TemplateInfo(template string, asJSON bool) {
+	ref, err := templ.ParseFullRef(template)
+	checkValid(err)
+	checkState(container.IsTemplate(ref.String()), "Template %s not found", ref.String())
+
+	info := struct {
+		Template string `json:"template"`
+		Parent   string `json:"parent"`
+		PrefSize string `json:"pref-size"`
+		TemplateMetadata
+	}{Template: ref.String(), PrefSize: container.GetProperty(ref.String(), "subutai.template.size")}
+	if parent, err := container.ParentRef(ref.String()); err == nil {
+		info.Parent = parent.String()
+	}
+	info.TemplateMetadata, err = templateMetadata(ref.String())
+	log.Check(log.ErrorLevel, "Reading template metadata from db", err)
+
+	if asJSON {
+		out, err := json.Marshal(info)
+		log.Check(log.ErrorLevel, "Marshalling template info", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(w, "Template:\t%s\n", info.Template)
+	fmt.Fprintf(w, "Parent:\t%s\n", info.Parent)
+	fmt.Fprintf(w, "Preferred size:\t%s\n", info.PrefSize)
+	printTemplateMetadata(w, info.TemplateMetadata)
+	w.Flush()
+}
+
+// printTemplateMetadata prints metadata which is set
+func printTemplateMetadata(w io.Writer, meta TemplateMetadata) {
+	for _, item := range [][]string{
+		{"Description", meta.Description},
+		{"License", meta.License},
+		{"Homepage", meta.Homepage},
+		{"Tags", strings.Join(meta.Tags, ", ")},
+	} {
+		if item[1] != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", item[0], item[1])
+		}
+	}
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
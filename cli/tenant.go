@@ -0,0 +1,311 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/log"
+)
+
+var tenantNameRx = regexp.MustCompile(`^[a-z][a-z0-9]{1,19}$`)
+
+// tenantLimits are resources sum of quotas of tenant containers is limited for, along with number of containers
+var tenantLimits = []string{"cpu", "ram", "disk", "containers"}
+
+// TenantAdd creates tenant, or changes limits of existing one. Limits are sums of quotas of tenant containers:
+// cpu in percents of all cores, ram in Mb and disk in Gb, and number of tenant containers. Limit which is not
+// given is kept, zero limit means no limit. Containers of tenant are named with tenant name and dash prefix.
+// Containers without quota of limited resource are charged default quota of [tenant] section of config
+//
+// subutai tenant add acme cpu=200 ram=8192 disk=200 containers=10
+func TenantAdd(name string, limits map[string]string) {
+	name = strings.TrimSpace(name)
+	checkArgument(tenantNameRx.MatchString(name),
+		"Invalid tenant name %s, 2 to 20 lowercase letters and digits starting with letter are allowed", name)
+
+	tenant, err := db.FindTenant(name)
+	log.Check(log.ErrorLevel, "Reading tenant from db", err)
+	if tenant == nil {
+		tenant = &db.Tenant{Name: name, Created: time.Now()}
+	}
+
+	for resource, value := range limits {
+		checkArgument(stringInList(resource, tenantLimits),
+			"Unknown limit %s, expected one of %s", resource, strings.Join(tenantLimits, ", "))
+		limit, err := strconv.Atoi(value)
+		checkArgument(err == nil && limit >= 0, "Invalid %s limit %s, non-negative number expected", resource, value)
+		*tenantLimit(tenant, resource) = limit
+	}
+
+	log.Check(log.ErrorLevel, "Saving tenant", db.SaveTenant(tenant))
+
+	usage, err := tenantUsage(name, "", nil)
+	log.Check(log.ErrorLevel, "Reading quotas of tenant containers", err)
+	for _, resource := range tenantLimits {
+		if limit := *tenantLimit(tenant, resource); limit > 0 && usage[resource] > limit {
+			log.Warn(fmt.Sprintf("Tenant %s is already above its %s limit: %d of %d", name, resource, usage[resource], limit))
+		}
+	}
+}
+
+// TenantList prints tenants with their limits and sums of quotas of their containers
+//
+// subutai tenant list
+func TenantList() {
+	tenants, err := db.GetAllTenants()
+	log.Check(log.ErrorLevel, "Reading tenants from db", err)
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "TENANT\tCPU %\tRAM MB\tDISK GB\tCONTAINERS\tUNLIMITED")
+	for i := range tenants {
+		t := &tenants[i]
+		usage, err := tenantUsage(t.Name, "", nil)
+		log.Check(log.ErrorLevel, "Reading quotas of containers of tenant "+t.Name, err)
+
+		columns := []string{t.Name}
+		for _, resource := range tenantLimits {
+			limit := "-"
+			if value := *tenantLimit(t, resource); value > 0 {
+				limit = strconv.Itoa(value)
+			}
+			columns = append(columns, fmt.Sprintf("%d/%s", usage[resource], limit))
+		}
+		columns = append(columns, strconv.Itoa(usage["unlimited"]))
+		fmt.Fprintln(w, strings.Join(columns, "\t"))
+	}
+	w.Flush()
+}
+
+// TenantRemove removes tenant which has no containers
+//
+// subutai tenant remove acme
+func TenantRemove(name string) {
+	tenant, err := db.FindTenant(name)
+	log.Check(log.ErrorLevel, "Reading tenant from db", err)
+	checkState(tenant != nil, "Tenant %s not found", name)
+
+	containers, err := db.FindTenantContainers(name)
+	log.Check(log.ErrorLevel, "Reading tenant containers from db", err)
+	checkState(len(containers) == 0, "Tenant %s has %d containers, destroy them first", name, len(containers))
+
+	log.Check(log.ErrorLevel, "Removing tenant", db.RemoveTenant(tenant))
+}
+
+// tenantLimit returns limit of resource of tenant
+func tenantLimit(tenant *db.Tenant, resource string) *int {
+	switch resource {
+	case "cpu":
+		return &tenant.CPU
+	case "ram":
+		return &tenant.RAM
+	case "disk":
+		return &tenant.Disk
+	}
+	return &tenant.Containers
+}
+
+// tenantOf returns tenant of container, empty for containers of host owner
+func tenantOf(name string) string {
+	cont, err := db.FindContainerByName(name)
+	log.Check(log.ErrorLevel, "Reading container metadata from db", err)
+	if cont == nil {
+		return ""
+	}
+	return cont.Tenant
+}
+
+// tenantUsage returns sums of cpu, ram and disk quotas and number of containers of tenant, along with number
+// of containers without quota of some resource under "unlimited". Containers without quota are charged
+// default quota, so limits can not be bypassed by leaving quotas unset. Quotas of container are taken
+// from quotas if it is set, so sums are known before they are changed
+func tenantUsage(tenant, name string, quotas map[string]string) (map[string]int, error) {
+	containers, err := db.FindTenantContainers(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string]int{"containers": len(containers)}
+	for _, c := range containers {
+		unlimited := false
+		for _, resource := range tenantLimits[:3] {
+			value, ok := quotas[resource]
+			if c.Name != name || !ok {
+				if value, err = currentQuota(c.Name, resource); err != nil {
+					return nil, err
+				}
+			}
+			quota, _ := strconv.Atoi(value)
+			if quota <= 0 {
+				unlimited = true
+				quota = tenantDefaultQuota(resource)
+			}
+			usage[resource] += quota
+		}
+		if unlimited {
+			usage["unlimited"]++
+		}
+	}
+
+	return usage, nil
+}
+
+// checkTenantQuotas checks that setting quotas of container keeps sums of quotas of its tenant within limits.
+// Quota of resource tenant has limit of can not be removed
+func checkTenantQuotas(name string, quotas map[string]string) error {
+	tenantName := tenantOf(name)
+	if tenantName == "" {
+		return nil
+	}
+	tenant, err := db.FindTenant(tenantName)
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	usage, err := tenantUsage(tenantName, name, quotas)
+	if err != nil {
+		return err
+	}
+	for _, resource := range tenantLimits[:3] {
+		value, ok := quotas[resource]
+		if !ok {
+			continue
+		}
+		limit := *tenantLimit(tenant, resource)
+		if quota, _ := strconv.Atoi(value); limit > 0 && quota <= 0 {
+			return errors.Errorf("Tenant %s has %s limit, %s quota of its containers can not be removed",
+				tenantName, resource, resource)
+		}
+		if limit > 0 && usage[resource] > limit {
+			return errors.Errorf("Sum of %s quotas of tenant %s would be %d, its limit is %d",
+				resource, tenantName, usage[resource], limit)
+		}
+	}
+
+	return nil
+}
+
+// checkTenantClone checks that container of tenant may be created: tenant exists, container is named with its
+// prefix, tenant stays within its limits of containers and of cpu, ram and disk once container with quotas is
+// added, and registration token is not bound to another tenant. Container without quotas is charged default
+// ones. Returned lock of tenant must be held till container is recorded in db, so concurrent clones can not
+// pass checks together
+func checkTenantClone(tenantName, child, token string, quotas map[string]string) *common.Locks {
+	if token != "" {
+		t, err := db.FindTokenByHash(gpg.HashToken(token))
+		log.Check(log.ErrorLevel, "Reading registration token", err)
+		if t != nil && t.Tenant != "" {
+			checkArgument(t.Tenant == tenantName, "Token of tenant %s can not register containers of %s",
+				t.Tenant, orNone(tenantName))
+		}
+	}
+	checkTenantName(tenantName, child)
+	if tenantName == "" {
+		return common.AcquireLocks()
+	}
+
+	lock := common.AcquireLocks(common.LockKey{Kind: common.TenantLock, Name: tenantName})
+	tenant, err := db.FindTenant(tenantName)
+	log.Check(log.ErrorLevel, "Reading tenant from db", err)
+	checkState(tenant != nil, "Tenant %s not found", tenantName)
+
+	usage, err := tenantUsage(tenantName, "", nil)
+	log.Check(log.ErrorLevel, "Reading quotas of tenant containers", err)
+	checkState(tenant.Containers == 0 || usage["containers"] < tenant.Containers,
+		"Tenant %s reached its limit of %d containers", tenantName, tenant.Containers)
+	for _, resource := range tenantLimits[:3] {
+		quota, _ := strconv.Atoi(quotas[resource])
+		if quota <= 0 {
+			quota = tenantDefaultQuota(resource)
+		}
+		limit := *tenantLimit(tenant, resource)
+		checkState(limit == 0 || usage[resource]+quota <= limit,
+			"Sum of %s quotas of tenant %s would be %d, its limit is %d", resource, tenantName,
+			usage[resource]+quota, limit)
+	}
+
+	return lock
+}
+
+// checkTenantName checks that container of tenant is named with its prefix and that containers of host owner
+// do not take prefixes of tenants
+func checkTenantName(tenantName, name string) {
+	if tenantName != "" {
+		checkArgument(strings.HasPrefix(name, tenantName+"-"), "Container of tenant %s must be named %s-{name}",
+			tenantName, tenantName)
+		return
+	}
+
+	//prefixes of tenants are reserved for their containers
+	tenants, err := db.GetAllTenants()
+	log.Check(log.ErrorLevel, "Reading tenants from db", err)
+	for _, t := range tenants {
+		checkArgument(!strings.HasPrefix(name, t.Name+"-"), "Name %s is reserved for containers of tenant %s",
+			name, t.Name)
+	}
+}
+
+// setTenantDefaultQuotas sets default quotas of resources tenant has limits of to its container which has no
+// quotas of them, e.g. new clone
+func setTenantDefaultQuotas(tenantName, name string) {
+	if tenantName == "" {
+		return
+	}
+	tenant, err := db.FindTenant(tenantName)
+	log.Check(log.ErrorLevel, "Reading tenant from db", err)
+	if tenant == nil {
+		return
+	}
+
+	quotas := make(map[string]string)
+	for _, resource := range tenantLimits[:3] {
+		if *tenantLimit(tenant, resource) == 0 {
+			continue
+		}
+		value, err := currentQuota(name, resource)
+		log.Check(log.ErrorLevel, "Reading "+resource+" quota of "+name, err)
+		if quota, _ := strconv.Atoi(value); quota <= 0 {
+			quotas[resource] = strconv.Itoa(tenantDefaultQuota(resource))
+		}
+	}
+	if len(quotas) > 0 {
+		setQuotas(name, quotas)
+	}
+}
+
+// tenantDefaultQuota returns quota tenant containers without quota of resource are charged
+func tenantDefaultQuota(resource string) int {
+	switch resource {
+	case "cpu":
+		return config.Tenant.DefaultCpu
+	case "ram":
+		return config.Tenant.DefaultRam
+	}
+	return config.Tenant.DefaultDisk
+}
+
+// tenantContainers returns names of containers of tenant which exist on host
+func tenantContainers(tenant string) []string {
+	containers, err := db.FindTenantContainers(tenant)
+	log.Check(log.ErrorLevel, "Reading tenant containers from db", err)
+
+	var names []string
+	for _, c := range containers {
+		if container.IsContainer(c.Name) {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
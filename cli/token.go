@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/log"
+)
+
+// TokenCreate registers token which may be used once to register container keys with management
+// within ttl seconds for operations of listed scopes. Token is generated and printed if it is not passed.
+// Only hash of token is stored, so generated token can not be retrieved later. Token of tenant registers keys
+// of containers of the tenant only
+//
+// subutai token create [token] [--ttl 3600] [--scope clone --scope restore] [--tenant acme]
+func TokenCreate(token string, ttl int, scopes []string, tenant string) {
+	if ttl <= 0 {
+		ttl = config.Management.TokenTtl
+	}
+	if len(scopes) == 0 {
+		scopes = gpg.Scopes
+	}
+	for _, scope := range scopes {
+		checkArgument(gpg.IsScope(scope), "Invalid scope %s, valid scopes are %s", scope, strings.Join(gpg.Scopes, ", "))
+	}
+	if tenant != "" {
+		t, err := db.FindTenant(tenant)
+		log.Check(log.ErrorLevel, "Reading tenant from db", err)
+		checkState(t != nil, "Tenant %s not found", tenant)
+	}
+
+	generated := token == ""
+	if generated {
+		buf := make([]byte, 32)
+		_, err := rand.Read(buf)
+		log.Check(log.ErrorLevel, "Generating token", err)
+		token = hex.EncodeToString(buf)
+	}
+
+	now := time.Now()
+	err := db.UpdateToken(gpg.HashToken(token), func(t *db.RegistrationToken) error {
+		if t.Id != 0 {
+			return errors.New("Token is already registered")
+		}
+		t.Scopes = scopes
+		t.Tenant = tenant
+		t.Created = now
+		t.Expires = now.Add(time.Duration(ttl) * time.Second)
+		return nil
+	})
+	log.Check(log.ErrorLevel, "Saving token", err)
+
+	if generated {
+		fmt.Println(token)
+	}
+}
+
+// TokenList prints registered and seen tokens with their state
+//
+// subutai token list
+func TokenList() {
+	tokens, err := db.GetAllTokens()
+	log.Check(log.ErrorLevel, "Reading tokens", err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "ID\tHASH\tSCOPES\tTENANT\tEXPIRES\tSTATE\tUSED BY")
+	for _, t := range tokens {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", t.Id, t.Hash[:12], strings.Join(t.Scopes, ","), orNone(t.Tenant),
+			t.Expires.Format(time.RFC3339), tokenState(t), t.UsedBy)
+	}
+	w.Flush()
+}
+
+// TokenRevoke prevents further use of token, revoked tokens stay recorded so they are not accepted as unknown ones
+//
+// subutai token revoke {id}
+func TokenRevoke(id int) {
+	token, err := db.FindTokenById(id)
+	log.Check(log.ErrorLevel, "Reading token", err)
+	checkState(token != nil, "Token %d not found", id)
+
+	token.Revoked = true
+	log.Check(log.ErrorLevel, "Saving token", db.SaveToken(token))
+}
+
+// TokenPurge removes tokens which can not be used anymore, i.e. used, revoked or expired ones
+//
+// subutai token purge
+func TokenPurge() {
+	tokens, err := db.GetAllTokens()
+	log.Check(log.ErrorLevel, "Reading tokens", err)
+
+	for i := range tokens {
+		if tokenState(tokens[i]) != "valid" {
+			log.Check(log.WarnLevel, fmt.Sprintf("Removing token %d", tokens[i].Id), db.RemoveToken(&tokens[i]))
+		}
+	}
+}
+
+func tokenState(t db.RegistrationToken) string {
+	switch {
+	case t.Revoked:
+		return "revoked"
+	case !t.Used.IsZero():
+		return "used"
+	case time.Now().After(t.Expires):
+		return "expired"
+	}
+	return "valid"
+}
@@ -1,13 +1,16 @@
 package cli
 
 import (
-	"os/exec"
-
+	"context"
+	"fmt"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/common"
 	"github.com/subutai-io/agent/lib/container"
+	exec2 "github.com/subutai-io/agent/lib/exec"
 	"github.com/subutai-io/agent/log"
+	"os"
 	"strings"
-	"github.com/subutai-io/agent/lib/common"
-	exec2 "github.com/subutai-io/agent/lib/exec"
+	"time"
 )
 
 // Update operation can be divided into two different types: container updates and Resource Host updates.
@@ -32,9 +35,9 @@ func Update(name string, check bool) {
 
 func updateRH(check bool) {
 
-	output, err := exec.Command("apt-get", "-qq", "update", "-y", "--force-yes", "-o", "Acquire::http::Timeout=5").CombinedOutput()
+	output, err := exec2.CombinedOutput("apt-get", "-qq", "update", "-y", "--force-yes", "-o", "Acquire::http::Timeout=5")
 	log.Check(log.FatalLevel, "Updating apt index "+string(output), err)
-	output, err = exec.Command("apt-get", "-qq", "dist-upgrade", "-y", "--force-yes", "-o", "Acquire::http::Timeout=5", "-s").CombinedOutput()
+	output, err = exec2.CombinedOutput("apt-get", "-qq", "dist-upgrade", "-y", "--force-yes", "-o", "Acquire::http::Timeout=5", "-s")
 	log.Check(log.FatalLevel, "Checking for available update "+string(output), err)
 	if len(output) == 0 {
 		log.Info("No update is available")
@@ -55,9 +58,12 @@ func updateContainer(name string, check bool) {
 	if !container.LxcInstanceExists(name) {
 		log.Error("no such instance \"" + name + "\"")
 	}
-	output, err := container.AttachExec(name, []string{"apt-get", "-qq", "update", "-y", "--force-yes", "-o", "Acquire::http::Timeout=5"})
+	//hung apt-get, e.g. waiting for dpkg lock, must not block update forever
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Timeouts.AptGet)*time.Second)
+	defer cancel()
+	output, err := container.AttachExecCtx(ctx, name, []string{"apt-get", "-qq", "update", "-y", "--force-yes", "-o", "Acquire::http::Timeout=5"})
 	log.Check(log.FatalLevel, "Updating apt index "+strings.Join(output, "\n"), err)
-	output, err = container.AttachExec(name, []string{"apt-get", "-qq", "upgrade", "-y", "--force-yes", "-o", "Acquire::http::Timeout=5", "-s"})
+	output, err = container.AttachExecCtx(ctx, name, []string{"apt-get", "-qq", "upgrade", "-y", "--force-yes", "-o", "Acquire::http::Timeout=5", "-s"})
 	log.Check(log.FatalLevel, "Checking for available update "+strings.Join(output, "\n"), err)
 	if len(output) == 0 {
 		log.Info("No update is available")
@@ -68,7 +74,11 @@ func updateContainer(name string, check bool) {
 	}
 	_, _, errResult := container.AttachExecOutput(name, []string{"dpkg", "--configure", "-a"}, []string{"DEBIAN_FRONTEND=noninteractive"})
 	log.Check(log.WarnLevel, "Configuring dpkg", errResult.Error())
-	_, _, errResult = container.AttachExecOutput(name, []string{"apt-get", "upgrade", "-y", "--allow-unauthenticated", "-o", "Acquire::http::Timeout=5", "-o", "Dpkg::Options::=--force-confdef", "-o", "Dpkg::Options::=--force-confold"},
-		[]string{"DEBIAN_FRONTEND=noninteractive"})
-	log.Check(log.FatalLevel, "Updating container", errResult.Error())
+	//upgrade may take long, its progress is shown as it goes
+	exitCode, err := container.AttachExecStream(name, []string{"apt-get", "upgrade", "-y", "--allow-unauthenticated", "-o", "Acquire::http::Timeout=5", "-o", "Dpkg::Options::=--force-confdef", "-o", "Dpkg::Options::=--force-confold"},
+		nil, os.Stdout, os.Stderr, time.Duration(config.Timeouts.AptGet)*time.Second, []string{"DEBIAN_FRONTEND=noninteractive"})
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("apt-get exited with code %d", exitCode)
+	}
+	log.Check(log.FatalLevel, "Updating container", err)
 }
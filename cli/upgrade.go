@@ -0,0 +1,388 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+// OutdatedContainer is container cloned from template which has newer version
+type OutdatedContainer struct {
+	Container string `json:"container"`
+	Template  string `json:"template"`
+	Version   string `json:"version"`
+	Latest    string `json:"latest"`
+	//maintenance window of automatic upgrades, empty if container is not opted in
+	Window string `json:"window,omitempty"`
+}
+
+// TemplateOutdated prints containers cloned from templates which have newer versions on CDN, with maintenance
+// window of containers opted into automatic upgrades. Versions last found by update watcher are used if CDN
+// is not reachable
+//
+// subutai template outdated [--json]
+func TemplateOutdated(asJson bool) {
+	outdated, err := outdatedContainers()
+	log.Check(log.ErrorLevel, "Checking template versions", err)
+
+	if asJson {
+		if outdated == nil {
+			outdated = []OutdatedContainer{}
+		}
+		out, err := json.Marshal(outdated)
+		log.Check(log.ErrorLevel, "Marshalling outdated containers", err)
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "CONTAINER\tTEMPLATE\tVERSION\tLATEST\tAUTO-UPGRADE")
+	for _, o := range outdated {
+		window := o.Window
+		if window == "" {
+			window = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", o.Container, o.Template, o.Version, o.Latest, window)
+	}
+	w.Flush()
+}
+
+// TemplateUpgrade moves container to the latest or given version of its template, importing it if needed.
+// Partitions rootfs and opt are replaced, home is kept and so is var unless replaceVar is set. Running container
+// is stopped only once template is imported and is started again, if it does not start it is rolled back. Rollback brings back version
+// replaced by the last upgrade
+//
+// subutai template upgrade foo [--ver 1.2.0] [--replace-var]
+// subutai template upgrade foo --rollback
+func TemplateUpgrade(name, version string, replaceVar, rollback bool) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	//container stopped for upgrade is started again if upgrade fails, also if failure stops process midway
+	running := container.State(name) == container.Running
+	stopped := false
+	log.AtExit(func(int) {
+		if stopped {
+			log.Check(log.WarnLevel, "Starting "+name, container.Start(name))
+		}
+	})
+	stop := func() {
+		if running {
+			log.Check(log.ErrorLevel, "Stopping "+name, container.Stop(name))
+			stopped = true
+		}
+	}
+
+	if rollback {
+		stop()
+		log.Check(log.ErrorLevel, "Rolling back upgrade of "+name, container.RollbackUpgrade(name))
+		if running {
+			stopped = false
+			log.Check(log.ErrorLevel, "Starting "+name, container.Start(name))
+		}
+		log.Info(name + " is rolled back to " + container.GetProperty(name, "subutai.parent.version"))
+		return
+	}
+
+	current, err := container.ParentRef(name)
+	log.Check(log.ErrorLevel, "Reading template of "+name, err)
+	if version == "" {
+		latest, err := latestTemplate(current.Name, current.Owner)
+		log.Check(log.ErrorLevel, "Looking up latest version of "+current.Name, err)
+		checkState(latest != nil, "Template %s not found on CDN", current.CdnString())
+		version = latest.Version
+	}
+	ref, err := templ.NewRef(current.Name, current.Owner, version)
+	checkValid(err)
+	checkState(ref != current, "Container %s is cloned from %s already", name, ref.CdnString())
+
+	if !container.IsTemplate(ref.String()) {
+		LxcImport(ref.CdnString(), "")
+	}
+
+	stop()
+	log.Check(log.ErrorLevel, "Upgrading "+name, container.Upgrade(name, ref, replaceVar))
+
+	if running {
+		if err = container.Start(name); err != nil {
+			log.Warn(name + " does not start after upgrade, rolling back: " + err.Error())
+			log.Check(log.ErrorLevel, "Rolling back upgrade of "+name, container.RollbackUpgrade(name))
+			stopped = false
+			log.Check(log.ErrorLevel, "Starting "+name, container.Start(name))
+			log.Error("Upgrade of " + name + " to " + ref.CdnString() + " is rolled back")
+		}
+		stopped = false
+	}
+
+	log.Info(name + " is upgraded to " + ref.CdnString())
+}
+
+// TemplateAutoUpgrade opts container into automatic upgrades to new versions of its template, done in
+// maintenance window by daemon if update watcher is enabled. Window is a weekday or daily followed by time
+// range, range ending before it starts spans midnight
+//
+// subutai template autoupgrade foo --window "sat 02:00-04:00"
+// subutai template autoupgrade foo --off
+func TemplateAutoUpgrade(name, window string, off bool) {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	policy, err := db.FindUpgradePolicy(name)
+	log.Check(log.ErrorLevel, "Reading upgrade policy", err)
+
+	if off {
+		if policy != nil {
+			log.Check(log.ErrorLevel, "Removing upgrade policy", db.RemoveUpgradePolicy(policy))
+		}
+		log.Info("Automatic upgrades of " + name + " are off")
+		return
+	}
+
+	window = strings.ToLower(strings.Join(strings.Fields(window), " "))
+	_, err = inWindow(window, time.Now())
+	checkValid(err)
+
+	if policy == nil {
+		policy = &db.UpgradePolicy{Container: name}
+	}
+	policy.Window = window
+	log.Check(log.ErrorLevel, "Saving upgrade policy", db.SaveUpgradePolicy(policy))
+
+	if !config.Updates.Enabled {
+		log.Warn("Update watcher is disabled in [updates] section of config, " + name + " is not upgraded until it is enabled")
+	}
+	log.Info(name + " is upgraded automatically in window " + window)
+}
+
+// MonitorTemplateUpdates watches CDN for new versions of templates of containers if enabled in config. New
+// versions are imported right away if predownload is on. Containers opted into automatic upgrades are
+// upgraded in stages: once version is known for soak period, a batch of containers with open maintenance window
+// is upgraded per check, rollout of version halts on the first failed upgrade
+func MonitorTemplateUpdates() {
+	if !config.Updates.Enabled {
+		return
+	}
+
+	interval := time.Duration(config.Updates.Interval) * time.Minute
+	if interval <= 0 {
+		interval = 6 * time.Hour
+	}
+
+	for {
+		common.RunNRecover(func() {
+			log.Check(log.WarnLevel, "Checking template updates", checkTemplateUpdates())
+		})
+
+		time.Sleep(interval)
+	}
+}
+
+func checkTemplateUpdates() error {
+	containers, err := db.FindContainers("", "", "")
+	if err != nil {
+		return err
+	}
+
+	//find new versions of templates in use
+	updates := make(map[string]*db.TemplateUpdate)
+	for _, c := range containers {
+		key := templateKey(c)
+		if c.Template == "" || c.TemplateOwner == "" || updates[key] != nil {
+			continue
+		}
+		update, err := findTemplateUpdate(c.Template, c.TemplateOwner)
+		if log.Check(log.WarnLevel, "Looking up latest version of "+key, err) || update == nil {
+			continue
+		}
+		updates[key] = update
+
+		ref := templ.Ref{Name: c.Template, Owner: c.TemplateOwner, Version: update.Version}
+		if config.Updates.Predownload && !container.IsTemplate(ref.String()) {
+			log.Check(log.WarnLevel, "Downloading "+ref.CdnString(), exec.Exec("subutai", "import", ref.CdnString()))
+		}
+	}
+
+	//upgrade opted in containers in batches
+	policies, err := db.GetAllUpgradePolicies()
+	if err != nil {
+		return err
+	}
+	batch := config.Updates.Batch
+	if batch <= 0 {
+		batch = 1
+	}
+	soak := time.Duration(config.Updates.Soak) * time.Hour
+	for _, c := range containers {
+		update := updates[templateKey(c)]
+		if batch == 0 || update == nil || update.FailedOn != "" || time.Since(update.Found) < soak ||
+			templ.CompareVersions(update.Version, c.TemplateVersion) <= 0 {
+			continue
+		}
+		var policy *db.UpgradePolicy
+		for i := range policies {
+			if policies[i].Container == c.Name {
+				policy = &policies[i]
+			}
+		}
+		if policy == nil {
+			continue
+		}
+		if open, err := inWindow(policy.Window, time.Now()); err != nil || !open {
+			continue
+		}
+
+		batch--
+		log.Info("Upgrading " + c.Name + " to " + update.Version)
+		//var partition holding data of services is kept on automatic upgrades
+		err := exec.Exec("subutai", "template", "upgrade", c.Name, "--ver", update.Version)
+		if err != nil {
+			//remaining containers keep current version until rollout is resumed by a newer version
+			update.FailedOn = c.Name
+			log.Check(log.WarnLevel, "Halting rollout of "+templateKey(c)+" "+update.Version,
+				db.SaveTemplateUpdate(update))
+			policy.LastError = err.Error()
+		} else {
+			policy.LastUpgrade = time.Now()
+			policy.LastError = ""
+		}
+		log.Check(log.WarnLevel, "Saving upgrade policy", db.SaveUpgradePolicy(policy))
+	}
+
+	return nil
+}
+
+// findTemplateUpdate looks up the latest version of template on CDN and records it when it is newer than one
+// recorded before
+func findTemplateUpdate(name, owner string) (*db.TemplateUpdate, error) {
+	key := name + "@" + owner
+	update, err := db.FindTemplateUpdate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := latestTemplate(name, owner)
+	if err != nil || latest == nil {
+		return update, err
+	}
+
+	if update == nil {
+		update = &db.TemplateUpdate{Template: key}
+	}
+	if templ.CompareVersions(latest.Version, update.Version) > 0 {
+		update.Version = latest.Version
+		update.Found = time.Now()
+		update.FailedOn = ""
+		if err = db.SaveTemplateUpdate(update); err != nil {
+			return nil, err
+		}
+		log.Info("New version " + latest.Version + " of template " + key + " found")
+	}
+
+	return update, nil
+}
+
+func outdatedContainers() ([]OutdatedContainer, error) {
+	containers, err := db.FindContainers("", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(map[string]*db.TemplateUpdate)
+	var outdated []OutdatedContainer
+	for _, c := range containers {
+		if c.Template == "" || c.TemplateOwner == "" {
+			continue
+		}
+		key := templateKey(c)
+		update, ok := updates[key]
+		if !ok {
+			update, err = findTemplateUpdate(c.Template, c.TemplateOwner)
+			log.Check(log.WarnLevel, "Looking up latest version of "+key, err)
+			updates[key] = update
+		}
+		if update == nil || templ.CompareVersions(update.Version, c.TemplateVersion) <= 0 {
+			continue
+		}
+
+		o := OutdatedContainer{Container: c.Name, Template: key, Version: c.TemplateVersion, Latest: update.Version}
+		policy, err := db.FindUpgradePolicy(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		if policy != nil {
+			o.Window = policy.Window
+		}
+		outdated = append(outdated, o)
+	}
+
+	return outdated, nil
+}
+
+func templateKey(c db.Container) string {
+	return c.Template + "@" + c.TemplateOwner
+}
+
+// inWindow checks if time falls into maintenance window, e.g. "sat 02:00-04:00" or "daily 22:00-02:00"
+func inWindow(window string, t time.Time) (bool, error) {
+	invalid := errors.Errorf("Invalid maintenance window \"%s\", expected e.g. \"sat 02:00-04:00\" or "+
+		"\"daily 22:00-02:00\"", window)
+
+	fields := strings.Fields(window)
+	if len(fields) != 2 {
+		return false, invalid
+	}
+	day, ok := weekdays[fields[0]]
+	if !ok && fields[0] != "daily" {
+		return false, invalid
+	}
+	bounds := strings.Split(fields[1], "-")
+	if len(bounds) != 2 {
+		return false, invalid
+	}
+	start, err := minuteOfDay(bounds[0])
+	end, err2 := minuteOfDay(bounds[1])
+	if err != nil || err2 != nil || start == end {
+		return false, invalid
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	dayMatches := func(d time.Weekday) bool {
+		return fields[0] == "daily" || d == day
+	}
+	if start < end {
+		return dayMatches(t.Weekday()) && now >= start && now < end, nil
+	}
+	//window spans midnight, it belongs to the day it starts
+	if now >= start {
+		return dayMatches(t.Weekday()), nil
+	}
+	return now < end && dayMatches(t.AddDate(0, 0, -1).Weekday()), nil
+}
+
+// minuteOfDay parses time in form hh:mm
+func minuteOfDay(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, errors.New("invalid time")
+	}
+	h, err := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, errors.New("invalid time")
+	}
+	return h*60 + m, nil
+}
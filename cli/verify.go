@@ -0,0 +1,262 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	container2 "github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// statuses of verification checks
+const (
+	VerifyPassed  = "passed"
+	VerifyFailed  = "failed"
+	VerifySkipped = "skipped"
+)
+
+// guidsManifest is file of snapshots archive holding guids of sent snapshots by partition
+const guidsManifest = "guids"
+
+// SnapshotVerification is result of verification of received container snapshots
+type SnapshotVerification struct {
+	Container  string
+	Label      string
+	Restorable bool
+	Checks     []VerificationCheck
+}
+
+// VerificationCheck is result of a single verification check
+type VerificationCheck struct {
+	Check  string
+	Status string
+	Detail string
+}
+
+func (v *SnapshotVerification) add(check string, passed bool, detail string) bool {
+	status := VerifyPassed
+	if !passed {
+		status = VerifyFailed
+		v.Restorable = false
+	}
+	v.Checks = append(v.Checks, VerificationCheck{Check: check, Status: status, Detail: detail})
+	return passed
+}
+
+func (v *SnapshotVerification) skip(check, detail string) {
+	v.Checks = append(v.Checks, VerificationCheck{Check: check, Status: VerifySkipped, Detail: detail})
+}
+
+func (v *SnapshotVerification) print() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+	for _, c := range v.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Check, c.Status, c.Detail)
+	}
+	w.Flush()
+}
+
+// VerifySnapshots checks that received snapshots of container form a valid chain and that its config refers to
+// existing template, so container is restorable. Guids of snapshot with label are compared with guids of sent
+// snapshots if they are given by partition. 1 is returned if container is not restorable
+//
+// subutai snapshot verify -c foo [-l tuesday]
+func VerifySnapshots(container, label string, guids map[string]string) int {
+	container = strings.TrimSpace(container)
+	checkArgument(container != "", "Invalid container name")
+	checkState(fs.DatasetExists(container), "Container %s not found", container)
+	if label != "" {
+		checkValid(container2.ValidateDatasetComponent(label))
+	}
+	for partition := range guids {
+		checkArgument(stringInList(partition, fs.ChildDatasets), "Invalid partition %s", partition)
+	}
+
+	result := verifySnapshots(container, label, guids)
+	result.print()
+	if !result.Restorable {
+		return 1
+	}
+	return 0
+}
+
+// verifySnapshots verifies partitions and config of received container, see VerifySnapshots
+func verifySnapshots(container, label string, guids map[string]string) SnapshotVerification {
+	result := SnapshotVerification{Container: container, Label: label, Restorable: true}
+
+	//config is checked first, since expected origins of partitions are read from it
+	configFile := path.Join(config.Agent.LxcPrefix, container, "config")
+	parent, parentLabel := "", container2.ParentSnapshot(container)
+	if result.add("config", fs.FileExists(configFile), configFile) {
+		if ref, err := container2.TemplateRef(container); err != nil {
+			result.add("template", false, err.Error())
+		} else {
+			result.add("template", fs.DatasetExists(ref.String()), "template "+ref.String())
+		}
+		if ref, err := container2.ParentRef(container); err != nil {
+			result.add("parent", false, err.Error())
+		} else {
+			parent = ref.String()
+			result.add("parent", fs.DatasetExists(getSnapshotName(parent, "rootfs", parentLabel)),
+				"snapshot "+getSnapshotName(parent, "all", parentLabel))
+		}
+	}
+
+	origins := make(map[string]string)
+	datasets, err := fs.ListDatasetsUsage()
+	if !result.add("datasets", err == nil, "listing datasets") {
+		result.Checks[len(result.Checks)-1].Detail = err.Error()
+	}
+	for _, d := range datasets {
+		origins[d.Name] = d.Origin
+	}
+	snapshotGuids, err := fs.SnapshotGuids(container)
+	if !result.add("guids", err == nil, "listing snapshot guids") {
+		result.Checks[len(result.Checks)-1].Detail = err.Error()
+	}
+
+	for _, partition := range fs.ChildDatasets {
+		dataset := path.Join(container, partition)
+		if !result.add(dataset, fs.DatasetExists(dataset), "dataset") {
+			continue
+		}
+
+		//received dataset is clone of template snapshot the container was created from
+		if parent != "" {
+			expected := getSnapshotName(parent, partition, parentLabel)
+			result.add(dataset+" origin", origins[dataset] == expected,
+				fmt.Sprintf("origin %s, expected %s", orNone(origins[dataset]), expected))
+		} else {
+			result.skip(dataset+" origin", "parent template unknown")
+		}
+
+		if label == "" {
+			continue
+		}
+		snapshot := getSnapshotName(container, partition, label)
+		guid, ok := snapshotGuids[snapshot]
+		if !result.add(snapshot, ok, "snapshot") {
+			continue
+		}
+		if expected, ok := guids[partition]; ok {
+			result.add(snapshot+" guid", guid == expected, fmt.Sprintf("guid %s, expected %s", guid, expected))
+		} else {
+			result.skip(snapshot+" guid", "guid of sent snapshot unknown")
+		}
+	}
+
+	return result
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// checkSnapshotChain verifies received snapshots of container and exits printing failed checks if container
+// is not restorable
+func checkSnapshotChain(container, label string, guids map[string]string) {
+	result := verifySnapshots(container, label, guids)
+	if !result.Restorable {
+		result.print()
+		log.Error("Received snapshots of " + container + " are not restorable")
+	}
+	log.Debug("Received snapshots of " + container + " verified")
+}
+
+// snapshotGuidsByPartition returns guids of snapshot with label of container partitions
+func snapshotGuidsByPartition(container, label string) (map[string]string, error) {
+	all, err := fs.SnapshotGuids(container)
+	if err != nil {
+		return nil, err
+	}
+
+	guids := make(map[string]string)
+	for _, partition := range fs.ChildDatasets {
+		snapshot := getSnapshotName(container, partition, label)
+		guid, ok := all[snapshot]
+		if !ok {
+			return nil, errors.Errorf("Snapshot %s not found", snapshot)
+		}
+		guids[partition] = guid
+	}
+
+	return guids, nil
+}
+
+// writeGuidsManifest writes guids of sent snapshot with label to snapshots archive directory
+func writeGuidsManifest(container, label, dir string) error {
+	guids, err := snapshotGuidsByPartition(container, label)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(struct {
+		Label string
+		Guids map[string]string
+	}{label, guids})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(dir, guidsManifest), data, 0644)
+}
+
+// readGuidsManifest returns label and guids of sent snapshots from snapshots archive directory, archives
+// sent by older agents have no manifest
+func readGuidsManifest(dir string) (string, map[string]string, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, guidsManifest))
+	if os.IsNotExist(err) {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	var manifest struct {
+		Label string
+		Guids map[string]string
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return "", nil, err
+	}
+
+	return manifest.Label, manifest.Guids, nil
+}
+
+// verifyRemoteSnapshots verifies snapshots of container received by agent of remote host against guids of
+// snapshot with label sent from this host
+func verifyRemoteSnapshots(remote []string, container, label string) error {
+	guids, err := snapshotGuidsByPartition(container, label)
+	if err != nil {
+		return err
+	}
+
+	args := append(append([]string{}, remote[1:]...), "subutai", "snapshot", "verify", "-c", container, "-l", label)
+	var partitions []string
+	for partition := range guids {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+	for _, partition := range partitions {
+		args = append(args, "--guid", partition+"="+guids[partition])
+	}
+
+	out, err := exec.Run(context.Background(), exec.Options{}, remote[0], args...)
+	if err != nil {
+		return errors.Errorf("Snapshots are not restorable:\n%s%s", out.Stdout, out.Stderr)
+	}
+
+	return nil
+}
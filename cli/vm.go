@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// VmCreate creates KVM virtual machine from qcow2 disk image and starts it.
+// It allows to run guests which can not be containerized, e.g. Windows, next to Subutai containers.
+// Defaults of VM hardware are compatible with Windows without additional drivers, `--virtio` switches
+// disk and network to virtio for better performance
+//
+// subutai vm create win10 -i /var/lib/subutai/images/win10.qcow2 -r 4096 -c 2
+func VmCreate(name, image string, ram, cpus int, virtio bool) {
+	checkValid(container.ValidateNewName(name))
+	checkArgument(strings.TrimSpace(image) != "", "Invalid path to VM image")
+	checkState(fs.FileExists(image), "Image %s not found", image)
+	checkArgument(ram > 0 && cpus > 0, "RAM and CPU count must be positive")
+	checkState(!container.IsVM(name) && !container.LxcInstanceExists(name), "%s already exists", name)
+
+	spec := container.VmSpec{Name: name, Image: image, RAM: ram, CPUs: cpus, Virtio: virtio}
+	log.Check(log.ErrorLevel, "Creating VM "+name, container.CreateVM(spec))
+	log.Info("VM " + name + " created")
+
+	VmStart(name)
+}
+
+// VmStart starts KVM virtual machine
+//
+// subutai vm start win10
+func VmStart(name string) {
+	checkState(container.IsVM(name), "VM %s not found", name)
+
+	rt := container.VmRuntime()
+	if rt.State(name) != container.Running {
+		log.Check(log.ErrorLevel, "Starting VM "+name, rt.Start(name))
+	}
+	log.Info("VM " + name + " started")
+}
+
+// VmStop shuts down KVM virtual machine gracefully, forcing power off if guest does not stop in time
+//
+// subutai vm stop win10
+func VmStop(name string) {
+	checkState(container.IsVM(name), "VM %s not found", name)
+
+	rt := container.VmRuntime()
+	if rt.State(name) == container.Running {
+		if log.Check(log.WarnLevel, "Shutting down VM "+name, rt.Shutdown(name, time.Minute*2)) {
+			log.Check(log.ErrorLevel, "Stopping VM "+name, rt.Stop(name))
+		}
+	}
+	log.Info("VM " + name + " stopped")
+}
+
+// VmDestroy removes KVM virtual machine with its disk, base image is kept
+//
+// subutai vm destroy win10
+func VmDestroy(name string) {
+	checkState(container.IsVM(name), "VM %s not found", name)
+
+	log.Check(log.ErrorLevel, "Destroying VM "+name, container.DestroyVM(name))
+	log.Info("VM " + name + " destroyed")
+}
+
+// VmList prints KVM virtual machines with their state and IP addresses
+//
+// subutai vm list
+func VmList() {
+	rt := container.VmRuntime()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NAME\tSTATE\tIP")
+	for _, name := range container.VMs() {
+		state := rt.State(name)
+		var ips []string
+		if state == container.Running {
+			ips, _ = rt.IPAddresses(name, "")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, state, strings.Join(ips, " "))
+	}
+	w.Flush()
+}
@@ -1,7 +1,7 @@
 package cli
 
 import (
-	"os/exec"
+	"github.com/subutai-io/agent/lib/exec"
 	"strings"
 
 	"github.com/subutai-io/agent/lib/net"
@@ -25,20 +25,20 @@ func DelVxlanTunnel(name string) {
 
 // tunnelCreate creates VXLAN tunnel
 func tunnelCreate(tunnel, addr, vlan, vni string) {
-	log.Check(log.WarnLevel, "Creating bridge ", exec.Command("ovs-vsctl", "--may-exist", "add-br", "gw-"+vlan).Run())
+	log.Check(log.WarnLevel, "Creating bridge ", exec.Exec("ovs-vsctl", "--may-exist", "add-br", "gw-"+vlan))
 
 	log.Check(log.FatalLevel, "Creating tunnel port",
-		exec.Command("ovs-vsctl", "--may-exist", "add-port", "gw-"+vlan, tunnel, "--", "set", "interface", tunnel, "type=vxlan",
-			"options:stp_enable=true", "options:key="+vni, "options:remote_ip="+addr).Run())
+		exec.Exec("ovs-vsctl", "--may-exist", "add-port", "gw-"+vlan, tunnel, "--", "set", "interface", tunnel, "type=vxlan",
+			"options:stp_enable=true", "options:key="+vni, "options:remote_ip="+addr))
 
-	log.Check(log.FatalLevel, "MakeVNIMap set port: ", exec.Command("ovs-vsctl", "--if-exists", "set", "port", tunnel, "tag="+vlan).Run())
+	log.Check(log.FatalLevel, "MakeVNIMap set port: ", exec.Exec("ovs-vsctl", "--if-exists", "set", "port", tunnel, "tag="+vlan))
 }
 
 //tunnelList prints a list of existing VXLAN tunnels
 func GetVxlanTunnels() []VxlanTunnel {
 	var res = []VxlanTunnel{}
 
-	ret, err := exec.Command("ovs-vsctl", "show").CombinedOutput()
+	ret, err := exec.CombinedOutput("ovs-vsctl", "show")
 	log.Check(log.FatalLevel, "Getting OVS interfaces list", err)
 	ports := strings.Split(string(ret), "\n")
 
@@ -4,8 +4,12 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"gopkg.in/gcfg.v1"
 
 	"github.com/subutai-io/agent/log"
@@ -14,6 +18,9 @@ import (
 
 const RhGpgUser = "rh@subutai.io"
 
+// ConfPath is path of agent configuration file
+const ConfPath = "/etc/subutai/agent.conf"
+
 type agentConfig struct {
 	Debug         bool
 	GpgUser       string
@@ -25,18 +32,54 @@ type agentConfig struct {
 	GpgHome       string
 	SshJumpServer string
 	LeStaging     bool
+	AlertRules    string
+	Compression   string
+	ZstdLevel     int
+	TemplateOwner string
+	ExportHooks   string
+	//container disk quota policy applied when pool grows: none or proportional
+	QuotaPolicy      string
+	PoolEventWebhook string
+	//container runtime: lxc or lxd
+	Runtime   string
+	LxdSocket string
+	//KVM virtual machine disks directory and libvirt network VMs are attached to
+	VmPrefix  string
+	VmNetwork string
+	//minutes between configuration drift checks reported in heartbeat, 0 disables them
+	DriftInterval int
+	//bytes per second snapshot transfers to other hosts and CDN uploads are limited to, e.g. 50M, empty for no limit
+	BwLimit string
 }
 
 type managementConfig struct {
 	Host string
-	//TODO remove or add securePort
-	Port    string
-	Secret  string
-	GpgUser string
+	//ports of management REST API/UI, agent endpoints and metrics database
+	Port        string
+	SecurePort  string
+	MetricsPort string
+	//name and network of local management container, containers cloned without network get .100-.199 of its
+	//network and the same gateway
+	ContainerName    string
+	ContainerIp      string
+	ContainerGateway string
+	Secret           string
+	GpgUser          string
+	//optional IPv6 address and gateway of management container, /64 network is assumed
+	ContainerIpv6     string
+	ContainerGateway6 string
 	//TODO remove
 	RestPublicKey string
 	Fingerprint   string
 	AllowInsecure bool
+	//seconds to wait for management container to become ready after bootstrap
+	ReadyTimeout int
+	//command run inside management container to set admin password passed in SUBUTAI_USER and SUBUTAI_PASSWORD
+	PasswordCommand string
+	//seconds registration token is valid after it is issued or first seen
+	TokenTtl int
+	//accept only registration tokens issued with "subutai token create"
+	StrictTokens bool
 }
 
 type influxdbConfig struct {
@@ -51,6 +94,191 @@ type cdnConfig struct {
 	SSLport             string
 	IpfsPath            string
 	TemplateDownloadUrl string
+	//comma separated gateways in the same form as templateDownloadUrl, e.g. local caching gateway or CDN mirrors,
+	//tried in order when download from templateDownloadUrl fails
+	TemplateMirrors string
+}
+
+//anonymized usage reporting, disabled unless explicitly enabled
+type telemetryConfig struct {
+	Enabled  bool
+	Endpoint string
+	//hours between reports
+	Interval int
+}
+
+//seconds external commands may run before they are killed, 0 disables timeout.
+//Commands without own setting are limited by Default
+type timeoutsConfig struct {
+	Default         int
+	Zfs             int
+	Uidmapshift     int
+	Ipfs            int
+	LxcUpdateConfig int
+	Service         int
+	AptGet          int
+	Gpg             int
+	Scan            int
+	Boot            int
+	Provision       int
+	//comma separated command:seconds pairs for commands without own setting, e.g. rsync:1800,lxc-start:300
+	Commands string
+}
+
+//transient systemd scopes created for running containers, disabled unless explicitly enabled
+type systemdConfig struct {
+	Enabled bool
+	//slice scopes are placed in
+	Slice string
+	//turn on CPU, memory and tasks accounting of scopes, only for hosts with unified cgroup hierarchy
+	//since on legacy hierarchy it moves container init out of cgroups limited by LXC
+	Accounting bool
+}
+
+//forwarding of container journals to syslog tagged by container name, disabled unless explicitly enabled
+type logsConfig struct {
+	Forward bool
+	//syslog server in form network:host:port, e.g. udp:10.0.0.5:514, local syslog if empty
+	Address string
+	//seconds between reads of container journals
+	Interval int
+}
+
+//options of proxies managed by "subutai proxy" and "subutai map" commands
+type proxyConfig struct {
+	//GeoIP country database for country based access policies, requires nginx geoip module
+	GeoipDb string
+	//space separated DNS servers nginx resolves OCSP responders with, nameservers of host if empty
+	Resolver string
+}
+
+//cluster of resource hosts running commands for each other, disabled unless explicitly enabled
+type clusterConfig struct {
+	Enabled bool
+	//comma separated addresses of peers, more peers are learnt from them if gossip is on
+	Peers  string
+	Gossip bool
+	//shared secret peers authenticate requests and responses with, cluster does not work without it
+	Secret string
+	//seconds between discovery rounds
+	Interval int
+	//keep archives of imported templates in cache and serve them to peers importing the same templates
+	ShareTemplates bool
+	//Gb kept archives may take in cache, least recently used archives are removed beyond it, 0 means no limit
+	ShareCacheSize int
+}
+
+//TLS listener of daemon serving cluster peers, disabled unless certificate and key are set.
+//Plain daemon port keeps serving Console
+type tlsConfig struct {
+	Port string
+	//certificate and key of host, used both to serve and to call peers
+	Cert string
+	Key  string
+	//CA certificate cluster peers present certificates issued by, any host with such certificate is a peer
+	ClusterCa string
+}
+
+//standby of management container, requires cluster mode to reach primary host
+type haConfig struct {
+	//name or address of cluster peer running management this host is standby for, empty if it is not standby
+	Primary string
+	//seconds between replications of management container
+	Interval int
+	//seconds primary may stay unreachable before standby promotes itself, 0 disables automatic promotion
+	FailoverTimeout int
+	//command run after promotion, e.g. to re-point DNS, with SUBUTAI_HA_IP set to address of promoted host
+	PromoteHook string
+}
+
+//scanning files of containers on host, e.g. with ClamAV, so scanners are not installed in containers
+type scanConfig struct {
+	//scanner command, directory to scan is appended; exit code 1 means findings, printed one per line, as with clamscan
+	Command string
+	//URL scan events are posted to, empty to only log them
+	Webhook string
+}
+
+//Prometheus exporter of container metrics served by daemon, disabled unless explicitly enabled
+type prometheusConfig struct {
+	Enabled bool
+	//address exporter listens on, e.g. :9273 or 10.0.0.2:9273
+	Address string
+	//comma separated addresses or networks of Prometheus servers allowed to scrape, e.g. 10.0.0.0/24, any if empty
+	Allow string
+}
+
+//limits of requests to endpoints of daemon per source address, disabled unless explicitly enabled
+type rateLimitConfig struct {
+	Enabled bool
+	//requests per minute a single address may send, with bursts up to Burst requests
+	Requests int
+	Burst    int
+	//requests refused for invalid credentials after which address is locked out for Lockout seconds
+	Failures int
+	Lockout  int
+	//comma separated addresses or networks which are not limited, management container is never limited
+	Exempt string
+}
+
+//API of daemon running subutai commands for callers authenticated with tokens, disabled unless backends are set
+type apiConfig struct {
+	//comma separated backends tokens are validated with, in order: local, oidc and ldap
+	Backends string
+	//OpenID Connect provider issuing RS256 signed ID or access tokens, e.g. https://sso.example.com/realms/ops
+	OidcIssuer   string
+	OidcAudience string
+	//claim holding groups of user
+	OidcGroupsClaim string
+	//LDAP server users bind to with basic auth, e.g. ldaps://ldap.example.com
+	LdapUrl string
+	//DN of user with %s standing for user name, e.g. uid=%s,ou=people,dc=example,dc=com
+	LdapUserDn string
+	//base of groups with member attribute holding DN of user, e.g. ou=groups,dc=example,dc=com
+	LdapGroupBase string
+	//comma separated groups of OIDC or LDAP users mapped to roles, the most privileged role wins
+	AdminGroups    string
+	OperatorGroups string
+	ViewerGroups   string
+}
+
+//FIPS mode restricts agent to approved algorithms for regulated deployments, see lib/fips
+type cryptoConfig struct {
+	Fips bool
+}
+
+//policy restricting templates host may import, denied owners and names take precedence, any template is allowed
+//unless set
+type importConfig struct {
+	//comma separated owners whose templates may be imported, any if empty, and owners whose templates are refused
+	AllowOwners string
+	DenyOwners  string
+	//comma separated name patterns, e.g. debian-*, of templates which may be imported, any if empty, and refused
+	AllowNames string
+	DenyNames  string
+	//only templates of owners verified by CDN and local archives signed with trusted keys are imported
+	VerifiedOnly bool
+}
+
+//watching CDN for new versions of templates containers are cloned from, disabled unless explicitly enabled
+type updatesConfig struct {
+	Enabled bool
+	//minutes between checks of CDN
+	Interval int
+	//import new versions as soon as they are found, so upgrades do not wait for downloads
+	Predownload bool
+	//hours new version must be known before containers are upgraded to it automatically
+	Soak int
+	//containers upgraded automatically per check, the rest follow in later checks unless upgrade fails
+	Batch int
+}
+
+type tenantConfig struct {
+	//quotas tenant containers get on clone for resources tenant has limits of, containers without quota are
+	//charged them too: cpu in percents of all cores, ram in Mb, disk in Gb
+	DefaultCpu  int
+	DefaultRam  int
+	DefaultDisk int
 }
 
 type configFile struct {
@@ -58,6 +286,22 @@ type configFile struct {
 	Management managementConfig
 	Influxdb   influxdbConfig
 	CDN        cdnConfig
+	Telemetry  telemetryConfig
+	Timeouts   timeoutsConfig
+	Systemd    systemdConfig
+	Logs       logsConfig
+	Proxy      proxyConfig
+	Cluster    clusterConfig
+	TLS        tlsConfig
+	HA         haConfig
+	Scan       scanConfig
+	Prometheus prometheusConfig
+	RateLimit  rateLimitConfig
+	API        apiConfig
+	Crypto     cryptoConfig
+	Import     importConfig
+	Updates    updatesConfig
+	Tenant     tenantConfig
 }
 
 const defaultConfig = `
@@ -71,15 +315,39 @@ const defaultConfig = `
     dataset = subutai/fs
     cacheDir = /var/cache/subutai
     sshJumpServer = cdn.subutai.io
+    alertRules = /etc/subutai/alerts.yaml
+    compression = gzip
+    zstdLevel = 3
+    templateOwner =
+    exportHooks = /etc/subutai/export.d
+    quotaPolicy = none
+    poolEventWebhook =
+    runtime = lxc
+    lxdSocket = /var/lib/lxd/unix.socket
+    vmPrefix = /var/lib/subutai/vms/
+    vmNetwork = default
+    driftInterval = 10
+    bwLimit =
 
 	[management]
 	host =
 	port = 8443
+	securePort = 8444
+	metricsPort = 8086
+	containerName = management
+	containerIp = 10.10.10.1
+	containerGateway = 10.10.10.254
+	containerIpv6 =
+	containerGateway6 =
 	secret = secret
 	gpgUser =
 	restPublicKey = /rest/v1/security/keyman/getpublickeyring
     fingerprint =
 	allowInsecure = true
+	readyTimeout = 600
+	passwordCommand = /opt/subutai-mng/bin/set-password
+	tokenTtl = 86400
+	strictTokens = false
 
 	[influxdb]
 	db = metrics
@@ -91,8 +359,114 @@ const defaultConfig = `
     sslPort = 443
     ipfsPath = /var/lib/ipfs/node
     templateDownloadUrl = https://ipfs.subutai.io/ipfs/{ID}
+    templateMirrors =
     allowInsecure = false
 
+    [telemetry]
+    enabled = false
+    endpoint =
+    interval = 24
+
+    [timeouts]
+    default = 600
+    zfs = 21600
+    uidmapshift = 3600
+    ipfs = 3600
+    lxcUpdateConfig = 60
+    service = 120
+    aptGet = 3600
+    gpg = 120
+    scan = 21600
+    boot = 300
+    provision = 3600
+    commands =
+
+    [systemd]
+    enabled = false
+    slice = subutai.slice
+    accounting = false
+
+    [logs]
+    forward = false
+    address =
+    interval = 10
+
+    [proxy]
+    geoipDb = /usr/share/GeoIP/GeoIP.dat
+    resolver =
+
+    [cluster]
+    enabled = false
+    peers =
+    gossip = true
+    secret =
+    interval = 60
+    shareTemplates = true
+    shareCacheSize = 20
+
+    [tls]
+    port = 8444
+    cert =
+    key =
+    clusterCa =
+
+    [ha]
+    primary =
+    interval = 300
+    failoverTimeout = 0
+    promoteHook =
+
+    [scan]
+    command = clamscan --recursive --infected --no-summary
+    webhook =
+
+    [prometheus]
+    enabled = false
+    address = :9273
+    allow =
+
+    [rateLimit]
+    enabled = false
+    requests = 120
+    burst = 30
+    failures = 10
+    lockout = 600
+    exempt = 127.0.0.1,::1
+
+    [api]
+    backends =
+    oidcIssuer =
+    oidcAudience =
+    oidcGroupsClaim = groups
+    ldapUrl =
+    ldapUserDn =
+    ldapGroupBase =
+    adminGroups =
+    operatorGroups =
+    viewerGroups =
+
+    [crypto]
+    fips = false
+
+    [import]
+    allowOwners =
+    denyOwners =
+    allowNames =
+    denyNames =
+    verifiedOnly = false
+
+    [updates]
+    enabled = false
+    interval = 360
+    predownload = false
+    soak = 24
+    batch = 1
+
+    [tenant]
+    defaultCpu = 10
+    defaultRam = 1024
+    defaultDisk = 10
+
 `
 
 var (
@@ -105,6 +479,38 @@ var (
 	Influxdb influxdbConfig
 	// CDN url and port
 	CDN cdnConfig
+	// Telemetry describes anonymized usage reporting
+	Telemetry telemetryConfig
+	// Timeouts limit run time of external commands
+	Timeouts timeoutsConfig
+	// Systemd describes transient systemd scopes of containers
+	Systemd systemdConfig
+	// Logs describes forwarding of container logs
+	Logs logsConfig
+	// Proxy describes options of proxies
+	Proxy proxyConfig
+	// Cluster describes peers of resource host
+	Cluster clusterConfig
+	// TLS describes certificates of TLS listener of daemon
+	TLS tlsConfig
+	// HA describes standby of management container
+	HA haConfig
+	// Scan describes scanner of container files
+	Scan scanConfig
+	// Prometheus describes exporter of container metrics
+	Prometheus prometheusConfig
+	// RateLimit describes limits of requests to daemon endpoints
+	RateLimit rateLimitConfig
+	// API describes authentication of callers of daemon API
+	API apiConfig
+	// Crypto restricts cryptographic algorithms used by agent
+	Crypto cryptoConfig
+	// Import restricts templates which may be imported
+	Import importConfig
+	// Updates describes watching CDN for new template versions and automatic upgrades of containers
+	Updates updatesConfig
+	// Tenant describes quotas of tenant containers cloned without them
+	Tenant tenantConfig
 
 	CdnUrl       string
 	ManagementIP string
@@ -116,7 +522,7 @@ func init() {
 	err := gcfg.ReadStringInto(&config, defaultConfig)
 	log.Check(log.InfoLevel, "Loading default config ", err)
 
-	confpath := "/etc/subutai/agent.conf"
+	confpath := ConfPath
 	log.Check(log.DebugLevel, "Opening Agent default configuration file", gcfg.ReadFileInto(&config, confpath))
 	if _, err := os.Stat(confpath); os.IsNotExist(err) {
 		log.Check(log.ErrorLevel, "Saving default configuration file", SaveDefaultConfig(confpath))
@@ -131,15 +537,70 @@ func init() {
 	if config.Agent.GpgHome == "" {
 		config.Agent.GpgHome = path.Join(config.Agent.DataPrefix, ".gnupg")
 	}
+	validateManagement()
+
 	Agent = config.Agent
 	Influxdb = config.Influxdb
 	Management = config.Management
 	CDN = config.CDN
+	Telemetry = config.Telemetry
+	Timeouts = config.Timeouts
+	Systemd = config.Systemd
+	Logs = config.Logs
+	Proxy = config.Proxy
+	Cluster = config.Cluster
+	TLS = config.TLS
+	HA = config.HA
+	Scan = config.Scan
+	Prometheus = config.Prometheus
+	RateLimit = config.RateLimit
+	API = config.API
+	Crypto = config.Crypto
+	Import = config.Import
+	Updates = config.Updates
+	Tenant = config.Tenant
 
 	CdnUrl = "https://" + path.Join(CDN.URL) + ":" + CDN.SSLport + "/rest/v1/cdn"
 
 }
 
+// validateManagement checks management container settings, agent can not work with invalid ones
+func validateManagement() {
+	if strings.TrimSpace(config.Management.ContainerName) == "" {
+		log.Error("Management container name is empty")
+	}
+
+	for _, ip := range []string{config.Management.ContainerIp, config.Management.ContainerGateway} {
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+			log.Error("Invalid management container IPv4 address " + ip)
+		}
+	}
+	if config.Management.ContainerIp == config.Management.ContainerGateway {
+		log.Error("Management container IP must differ from its gateway")
+	}
+	if config.Management.ContainerIpv6 != "" || config.Management.ContainerGateway6 != "" {
+		for _, ip := range []string{config.Management.ContainerIpv6, config.Management.ContainerGateway6} {
+			if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() != nil {
+				log.Error("Invalid management container IPv6 address " + ip)
+			}
+		}
+		if config.Management.ContainerIpv6 == config.Management.ContainerGateway6 {
+			log.Error("Management container IPv6 address must differ from its gateway")
+		}
+	}
+
+	ports := make(map[string]bool)
+	for _, port := range []string{config.Management.Port, config.Management.SecurePort, config.Management.MetricsPort} {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			log.Error("Invalid management port " + port)
+		}
+		if ports[port] {
+			log.Error("Management ports must be distinct, " + port + " is used twice")
+		}
+		ports[port] = true
+	}
+}
+
 // InitAgentDebug turns on Debug output for the Subutai Agent.
 func InitAgentDebug() {
 	if config.Agent.Debug {
@@ -182,3 +643,53 @@ func SaveDefaultConfig(conf string) error {
 	w.Flush()
 	return nil
 }
+
+// SaveOption sets option of section in agent configuration file keeping the rest of file as is, option and
+// section are added if missing. Running agent picks the new value up on restart
+func SaveOption(conf, section, name, value string) error {
+	data, err := ioutil.ReadFile(conf)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+	option := name + " = " + value
+	inSection, insertAt := false, -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			if inSection {
+				break
+			}
+			inSection = strings.EqualFold(strings.TrimSpace(trimmed[1:len(trimmed)-1]), section)
+			if inSection {
+				insertAt = i + 1
+			}
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if kv := strings.SplitN(trimmed, "=", 2); len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), name) {
+			lines[i] = line[:len(line)-len(strings.TrimLeft(line, " \t"))] + option
+			return ioutil.WriteFile(conf, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+		}
+		if trimmed != "" {
+			insertAt = i + 1
+		}
+	}
+
+	if insertAt < 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+section+"]", option)
+	} else {
+		lines = append(lines[:insertAt], append([]string{option}, lines[insertAt:]...)...)
+	}
+
+	return ioutil.WriteFile(conf, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -2,69 +2,158 @@ package db
 
 import (
 	"github.com/asdine/storm"
-	"path"
-	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/log"
-	"github.com/subutai-io/agent/lib/fs"
 	"time"
 	"go.etcd.io/bbolt"
 	"github.com/asdine/storm/q"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
-var (
-	dbFilePath = path.Join(config.Agent.DataPrefix, "agent.db")
-)
+func GetDiscoveredIp() (ip string, err error) {
+	var instance *handle
+	if instance, err = getDb(true); err == nil {
+		defer instance.Close()
+		instance.Bolt.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte("config")); b != nil {
+				ip = string(b.Get([]byte("DiscoveredIP")))
+			}
+			return nil
+		})
+	}
+	return ip, err
+}
 
-func init() {
-	if !fs.FileExists(dbFilePath) {
-		db, err := storm.Open(dbFilePath, storm.BoltOptions(0600, &bolt.Options{ReadOnly: false}))
-		log.Check(log.ErrorLevel, "Creating database", err)
-		defer db.Close()
+func SaveDiscoveredIp(ip string) (err error) {
+	var instance *handle
+	if instance, err = getDb(false); err == nil {
+		defer instance.Close()
+		return instance.Bolt.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists([]byte("config")); err == nil {
+				err = b.Put([]byte("DiscoveredIP"), []byte(ip))
+			}
+			return err
+		})
+	}
+	return err
+}
 
-		//init db structs
-		log.Check(log.ErrorLevel, "Initializing ssh tunnels storage", db.Init(&SshTunnel{}))
-		log.Check(log.ErrorLevel, "Initializing proxy storage", db.Init(&Proxy{}))
-		log.Check(log.ErrorLevel, "Initializing proxied servers storage", db.Init(&ProxiedServer{}))
+func GetMhGpgUsername() (ip string, err error) {
+	var instance *handle
+	if instance, err = getDb(true); err == nil {
+		defer instance.Close()
+		instance.Bolt.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte("config")); b != nil {
+				ip = string(b.Get([]byte("MhGpgUsername")))
+			}
+			return nil
+		})
 	}
+	return ip, err
+}
 
+func SaveMhGpgUsername(username string) (err error) {
+	var instance *handle
+	if instance, err = getDb(false); err == nil {
+		defer instance.Close()
+		return instance.Bolt.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists([]byte("config")); err == nil {
+				err = b.Put([]byte("MhGpgUsername"), []byte(username))
+			}
+			return err
+		})
+	}
+	return err
 }
 
-func getDb(readOnly bool) (*storm.DB, error) {
-	boltDB, err := storm.Open(dbFilePath,
-		//workaround: seems storm has bug related with read-only mode, it still tries to open db as read-write
-		storm.BoltOptions(0600, &bolt.Options{Timeout: 15 * time.Second, ReadOnly: false}))
-	//storm.BoltOptions(0600, &bolt.Options{Timeout: 15 * time.Second, ReadOnly: readOnly}))
+func GetTelemetryId() (id string, err error) {
+	var instance *handle
+	if instance, err = getDb(true); err == nil {
+		defer instance.Close()
+		instance.Bolt.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte("config")); b != nil {
+				id = string(b.Get([]byte("TelemetryId")))
+			}
+			return nil
+		})
+	}
+	return id, err
+}
 
-	if err != nil {
-		return nil, err
+func SaveTelemetryId(id string) (err error) {
+	var instance *handle
+	if instance, err = getDb(false); err == nil {
+		defer instance.Close()
+		return instance.Bolt.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists([]byte("config")); err == nil {
+				err = b.Put([]byte("TelemetryId"), []byte(id))
+			}
+			return err
+		})
 	}
+	return err
+}
 
-	return boltDB, nil
+// ManagementCredentialsSecret is key management admin credentials are stored under
+const ManagementCredentialsSecret = "management-credentials"
+
+// GetSecret returns secret stored under key, empty string if it is missing
+func GetSecret(key string) (secret string, err error) {
+	var instance *handle
+	if instance, err = getDb(true); err == nil {
+		defer instance.Close()
+		instance.Bolt.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte("secrets")); b != nil {
+				secret = string(b.Get([]byte(key)))
+			}
+			return nil
+		})
+	}
+	return secret, err
 }
 
-func GetDiscoveredIp() (ip string, err error) {
-	var instance *storm.DB
+// SaveSecret stores secret under key, db file is readable by root only
+func SaveSecret(key, secret string) (err error) {
+	var instance *handle
+	if instance, err = getDb(false); err == nil {
+		defer instance.Close()
+		return instance.Bolt.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists([]byte("secrets")); err == nil {
+				err = b.Put([]byte(key), []byte(secret))
+			}
+			return err
+		})
+	}
+	return err
+}
+
+func GetPoolSize() (size int64, err error) {
+	var instance *handle
 	if instance, err = getDb(true); err == nil {
 		defer instance.Close()
 		instance.Bolt.View(func(tx *bolt.Tx) error {
 			if b := tx.Bucket([]byte("config")); b != nil {
-				ip = string(b.Get([]byte("DiscoveredIP")))
+				size, _ = strconv.ParseInt(string(b.Get([]byte("PoolSize"))), 10, 64)
 			}
 			return nil
 		})
 	}
-	return ip, err
+	return size, err
 }
 
-func SaveDiscoveredIp(ip string) (err error) {
-	var instance *storm.DB
+func SavePoolSize(size int64) (err error) {
+	var instance *handle
 	if instance, err = getDb(false); err == nil {
 		defer instance.Close()
 		return instance.Bolt.Update(func(tx *bolt.Tx) error {
 			var b *bolt.Bucket
 			if b, err = tx.CreateBucketIfNotExists([]byte("config")); err == nil {
-				err = b.Put([]byte("DiscoveredIP"), []byte(ip))
+				err = b.Put([]byte("PoolSize"), []byte(strconv.FormatInt(size, 10)))
 			}
 			return err
 		})
@@ -72,28 +161,29 @@ func SaveDiscoveredIp(ip string) (err error) {
 	return err
 }
 
-func GetMhGpgUsername() (ip string, err error) {
-	var instance *storm.DB
+// GetQuotaPoolSize returns pool size disk quotas of containers were last scaled against
+func GetQuotaPoolSize() (size int64, err error) {
+	var instance *handle
 	if instance, err = getDb(true); err == nil {
 		defer instance.Close()
 		instance.Bolt.View(func(tx *bolt.Tx) error {
 			if b := tx.Bucket([]byte("config")); b != nil {
-				ip = string(b.Get([]byte("MhGpgUsername")))
+				size, _ = strconv.ParseInt(string(b.Get([]byte("QuotaPoolSize"))), 10, 64)
 			}
 			return nil
 		})
 	}
-	return ip, err
+	return size, err
 }
 
-func SaveMhGpgUsername(username string) (err error) {
-	var instance *storm.DB
+func SaveQuotaPoolSize(size int64) (err error) {
+	var instance *handle
 	if instance, err = getDb(false); err == nil {
 		defer instance.Close()
 		return instance.Bolt.Update(func(tx *bolt.Tx) error {
 			var b *bolt.Bucket
 			if b, err = tx.CreateBucketIfNotExists([]byte("config")); err == nil {
-				err = b.Put([]byte("MhGpgUsername"), []byte(username))
+				err = b.Put([]byte("QuotaPoolSize"), []byte(strconv.FormatInt(size, 10)))
 			}
 			return err
 		})
@@ -103,7 +193,7 @@ func SaveMhGpgUsername(username string) (err error) {
 
 //Container>>>>>>>
 func SaveContainer(container *Container) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -114,7 +204,7 @@ func SaveContainer(container *Container) (err error) {
 }
 
 func RemoveContainer(container *Container) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -125,7 +215,7 @@ func RemoveContainer(container *Container) (err error) {
 }
 
 func FindContainers(name, state, vlan string) (containers []Container, err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(true);
 	if err != nil {
 		return nil, err
@@ -156,7 +246,7 @@ func FindContainers(name, state, vlan string) (containers []Container, err error
 }
 
 func FindContainerByName(name string) (container *Container, err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(true);
 	if err != nil {
 		return nil, err
@@ -172,12 +262,75 @@ func FindContainerByName(name string) (container *Container, err error) {
 	return &result, err
 }
 
+// RenameContainer moves metadata of container and records keyed by its name to the new name in a single
+// transaction: package inventory, scan results, upgrade policy and snapshot metadata
+func RenameContainer(name, newName string) error {
+	return updateTx(func(tx storm.Node) error {
+		var c Container
+		err := tx.One("Name", name, &c)
+		if err == nil {
+			c.Name = newName
+			err = tx.Save(&c)
+		}
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		var inventory PackageInventory
+		err = tx.One("Container", name, &inventory)
+		if err == nil {
+			inventory.Container = newName
+			err = tx.Save(&inventory)
+		}
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		var policy UpgradePolicy
+		err = tx.One("Container", name, &policy)
+		if err == nil {
+			policy.Container = newName
+			err = tx.Save(&policy)
+		}
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		var results []ScanResult
+		err = tx.Find("Container", name, &results)
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+		for i := range results {
+			results[i].Container = newName
+			if err = tx.Save(&results[i]); err != nil {
+				return err
+			}
+		}
+
+		var metas []SnapshotMeta
+		if err = tx.All(&metas); err != nil {
+			return err
+		}
+		for i := range metas {
+			if strings.HasPrefix(metas[i].Snapshot, name+"@") {
+				metas[i].Snapshot = newName + strings.TrimPrefix(metas[i].Snapshot, name)
+				if err = tx.Save(&metas[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
 //<<<<<<<Container
 
 //Proxy>>>>>>>
 
 func SaveProxy(proxy *Proxy) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -188,7 +341,7 @@ func SaveProxy(proxy *Proxy) (err error) {
 }
 
 func RemoveProxy(proxy *Proxy) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -198,8 +351,36 @@ func RemoveProxy(proxy *Proxy) (err error) {
 	return db.DeleteStruct(proxy)
 }
 
+// RemoveProxyWithServers removes proxy together with its proxied servers and stats in a single transaction
+func RemoveProxyWithServers(proxy *Proxy) error {
+	return updateTx(func(tx storm.Node) error {
+		var servers []ProxiedServer
+		err := tx.Find("ProxyTag", proxy.Tag, &servers)
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		for i := range servers {
+			if err = tx.DeleteStruct(&servers[i]); err != nil {
+				return err
+			}
+		}
+
+		var stats ProxyStats
+		err = tx.One("ProxyTag", proxy.Tag, &stats)
+		if err == nil {
+			err = tx.DeleteStruct(&stats)
+		}
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		return tx.DeleteStruct(proxy)
+	})
+}
+
 func SaveProxiedServer(proxiedServer *ProxiedServer) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -210,7 +391,7 @@ func SaveProxiedServer(proxiedServer *ProxiedServer) (err error) {
 }
 
 func RemoveProxiedServer(proxiedServer *ProxiedServer) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -221,7 +402,7 @@ func RemoveProxiedServer(proxiedServer *ProxiedServer) (err error) {
 }
 
 func FindProxyByTag(tag string) (proxy *Proxy, err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(true);
 	if err != nil {
 		return nil, err
@@ -240,7 +421,7 @@ func FindProxyByTag(tag string) (proxy *Proxy, err error) {
 }
 
 func FindProxies(protocol, domain string, port int) (proxies []Proxy, err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(true);
 	if err != nil {
 		return nil, err
@@ -271,7 +452,7 @@ func FindProxies(protocol, domain string, port int) (proxies []Proxy, err error)
 }
 
 func FindProxiedServers(tag, socket string) (servers []ProxiedServer, err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(true);
 	if err != nil {
 		return nil, err
@@ -297,12 +478,42 @@ func FindProxiedServers(tag, socket string) (servers []ProxiedServer, err error)
 	return servers, err
 }
 
+func SaveProxyStats(stats *ProxyStats) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(stats)
+}
+
+func FindProxyStats(tag string) (stats *ProxyStats, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var s ProxyStats
+	err = db.One("ProxyTag", tag, &s)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
 //<<<<<<<Proxy
 
 // Ssh tunnels >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
 
 func SaveTunnel(tunnel *SshTunnel) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -313,7 +524,7 @@ func SaveTunnel(tunnel *SshTunnel) (err error) {
 }
 
 func UpdateTunnel(tunnel *SshTunnel) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -324,7 +535,7 @@ func UpdateTunnel(tunnel *SshTunnel) (err error) {
 }
 
 func FindTunnelByLocalSocket(localSocket string) (tunnel *SshTunnel, err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(true);
 	if err != nil {
 		return nil, err
@@ -341,7 +552,7 @@ func FindTunnelByLocalSocket(localSocket string) (tunnel *SshTunnel, err error)
 }
 
 func GetAllTunnels() (tunnels []SshTunnel, err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(true);
 	if err != nil {
 		return
@@ -354,7 +565,7 @@ func GetAllTunnels() (tunnels []SshTunnel, err error) {
 }
 
 func FindTunnelsByPid(pid int) (tunnels []SshTunnel, err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(true);
 	if err != nil {
 		return nil, err
@@ -384,7 +595,7 @@ func RemoveTunnelsByPid(pid int) error {
 }
 
 func RemoveTunnel(tunnel SshTunnel) (err error) {
-	var db *storm.DB
+	var db *handle
 	db, err = getDb(false);
 	if err != nil {
 		return err
@@ -395,3 +606,1055 @@ func RemoveTunnel(tunnel SshTunnel) (err error) {
 }
 
 // >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Ssh tunnels
+
+// Template stats >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func updateTemplateStats(template string, update func(stats *TemplateStats)) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats := TemplateStats{}
+	err = db.One("Template", template, &stats)
+	if err == storm.ErrNotFound {
+		stats = TemplateStats{Template: template}
+	} else if err != nil {
+		return err
+	}
+
+	update(&stats)
+
+	return db.Save(&stats)
+}
+
+func RecordTemplateImport(template string) error {
+	return updateTemplateStats(template, func(stats *TemplateStats) {
+		stats.Imports++
+		stats.LastImport = time.Now()
+	})
+}
+
+func RecordTemplateCacheLookup(template string, hit bool) error {
+	return updateTemplateStats(template, func(stats *TemplateStats) {
+		if hit {
+			stats.CacheHits++
+		} else {
+			stats.CacheMisses++
+		}
+	})
+}
+
+// RecordTemplateDownload records outcome of a single template download attempt from mirror.
+// Failed attempt is recorded with its reason
+func RecordTemplateDownload(template, mirror string, size int64, duration time.Duration, downloadErr error) error {
+	err := updateTemplateStats(template, func(stats *TemplateStats) {
+		if downloadErr != nil {
+			stats.Failures++
+			if stats.FailureReasons == nil {
+				stats.FailureReasons = make(map[string]int)
+			}
+			stats.FailureReasons[downloadErr.Error()]++
+		} else {
+			stats.Downloads++
+			stats.DownloadTime += duration
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats := MirrorStats{}
+	err = db.One("Mirror", mirror, &stats)
+	if err == storm.ErrNotFound {
+		stats = MirrorStats{Mirror: mirror}
+	} else if err != nil {
+		return err
+	}
+
+	if downloadErr != nil {
+		stats.Failures++
+		stats.ConsecutiveFailures++
+		stats.LastFailure = time.Now()
+	} else {
+		stats.Downloads++
+		stats.Bytes += size
+		stats.DownloadTime += duration
+		stats.ConsecutiveFailures = 0
+	}
+
+	return db.Save(&stats)
+}
+
+func GetTemplateStats() (stats []TemplateStats, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&stats)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return stats, err
+}
+
+func GetMirrorStats() (stats []MirrorStats, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&stats)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return stats, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Template stats
+
+// Registration tokens >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveToken(token *RegistrationToken) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(token)
+}
+
+// UpdateToken applies update to token with hash and saves it in a single transaction, so concurrent
+// registrations can not use the same token. Token not recorded yet is passed with zero Id.
+// Nothing is saved if update returns error
+func UpdateToken(hash string, update func(token *RegistrationToken) error) (err error) {
+	return updateTx(func(tx storm.Node) error {
+		token := RegistrationToken{}
+		err := tx.One("Hash", hash, &token)
+		if err == storm.ErrNotFound {
+			token = RegistrationToken{Hash: hash}
+		} else if err != nil {
+			return err
+		}
+
+		if err = update(&token); err != nil {
+			return err
+		}
+
+		return tx.Save(&token)
+	})
+}
+
+func FindTokenById(id int) (token *RegistrationToken, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var t RegistrationToken
+	err = db.One("Id", id, &t)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func FindTokenByHash(hash string) (token *RegistrationToken, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var t RegistrationToken
+	err = db.One("Hash", hash, &t)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func GetAllTokens() (tokens []RegistrationToken, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&tokens)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return tokens, err
+}
+
+func RemoveToken(token *RegistrationToken) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(token)
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Registration tokens
+
+// Tenants >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveTenant(tenant *Tenant) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(tenant)
+}
+
+func FindTenant(name string) (tenant *Tenant, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var t Tenant
+	err = db.One("Name", name, &t)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func GetAllTenants() (tenants []Tenant, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&tenants)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return tenants, err
+}
+
+func RemoveTenant(tenant *Tenant) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(tenant)
+}
+
+// FindTenantContainers returns containers of tenant
+func FindTenantContainers(tenant string) (containers []Container, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.Find("Tenant", tenant, &containers)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return containers, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Tenants
+
+// Quota profiles >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveQuotaProfile(profile *QuotaProfile) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(profile)
+}
+
+func FindQuotaProfile(name string) (profile *QuotaProfile, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var p QuotaProfile
+	err = db.One("Name", name, &p)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func GetAllQuotaProfiles() (profiles []QuotaProfile, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&profiles)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return profiles, err
+}
+
+func RemoveQuotaProfile(profile *QuotaProfile) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(profile)
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Quota profiles
+
+// Cluster peers >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveClusterPeer(peer *ClusterPeer) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(peer)
+}
+
+// FindClusterPeer looks up peer by name or address
+func FindClusterPeer(host string) (peer *ClusterPeer, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var p ClusterPeer
+	err = db.Select(q.Or(q.Eq("Name", host), q.Eq("Address", host))).First(&p)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func GetAllClusterPeers() (peers []ClusterPeer, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&peers)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return peers, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Cluster peers
+
+// Cluster leases >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveClusterLease(lease *ClusterLease) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(lease)
+}
+
+func RemoveClusterLease(name string) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.DeleteStruct(&ClusterLease{Name: name})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return err
+}
+
+func GetAllClusterLeases() (leases []ClusterLease, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&leases)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return leases, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Cluster leases
+
+// Management replica >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveManagementReplica(replica *ManagementReplica) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(replica)
+}
+
+func FindManagementReplica(container string) (replica *ManagementReplica, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var r ManagementReplica
+	err = db.One("Container", container, &r)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func RemoveManagementReplica(replica *ManagementReplica) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(replica)
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Management replica
+
+// Package inventory >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+// SavePackageInventory saves inventory replacing the one collected earlier for the same container
+func SavePackageInventory(inventory *PackageInventory) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var existing PackageInventory
+	err = db.One("Container", inventory.Container, &existing)
+	if err == nil {
+		inventory.Id = existing.Id
+	} else if err != storm.ErrNotFound {
+		return err
+	}
+
+	return db.Save(inventory)
+}
+
+func FindPackageInventory(container string) (inventory *PackageInventory, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var i PackageInventory
+	err = db.One("Container", container, &i)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+func RemovePackageInventory(container string) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var i PackageInventory
+	err = db.One("Container", container, &i)
+	if err == storm.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return db.DeleteStruct(&i)
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Package inventory
+
+// Scan results >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveScanResult(result *ScanResult) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(result)
+}
+
+func FindScanResults(container string) (results []ScanResult, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.Find("Container", container, &results)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return
+}
+
+func RemoveScanResults(container string) error {
+	results, err := FindScanResults(container)
+	if err != nil {
+		return err
+	}
+
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, r := range results {
+		if err = db.DeleteStruct(&r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Scan results
+
+// Snapshot metadata >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+// SaveSnapshotMeta saves metadata replacing the one of snapshot with the same name, e.g. removed earlier
+func SaveSnapshotMeta(meta *SnapshotMeta) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var existing SnapshotMeta
+	err = db.One("Snapshot", meta.Snapshot, &existing)
+	if err == nil {
+		meta.Id = existing.Id
+	} else if err != storm.ErrNotFound {
+		return err
+	}
+
+	return db.Save(meta)
+}
+
+func GetAllSnapshotMetas() (metas []SnapshotMeta, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&metas)
+
+	return
+}
+
+// RemoveSnapshotMetas removes metadata of snapshots for which match returns true
+func RemoveSnapshotMetas(match func(snapshot string) bool) error {
+	metas, err := GetAllSnapshotMetas()
+	if err != nil {
+		return err
+	}
+
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, m := range metas {
+		if match(m.Snapshot) {
+			if err = db.DeleteStruct(&m); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Snapshot metadata
+
+// Template metadata >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+// SaveTemplateMeta saves metadata replacing the one of template with the same reference
+func SaveTemplateMeta(meta *TemplateMeta) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var existing TemplateMeta
+	err = db.One("Template", meta.Template, &existing)
+	if err == nil {
+		meta.Id = existing.Id
+	} else if err != storm.ErrNotFound {
+		return err
+	}
+
+	return db.Save(meta)
+}
+
+func FindTemplateMeta(template string) (meta *TemplateMeta, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var m TemplateMeta
+	err = db.One("Template", template, &m)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func RemoveTemplateMeta(template string) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var m TemplateMeta
+	err = db.One("Template", template, &m)
+	if err == storm.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return db.DeleteStruct(&m)
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Template metadata
+
+// Operation audit >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+//number of the latest operation records kept
+const operationRecordsKept = 1000
+
+// SaveOperationRecord saves audit entry of command invocation, the oldest entries are removed periodically
+func SaveOperationRecord(record *OperationRecord) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err = db.Save(record); err != nil {
+		return err
+	}
+
+	if record.Id%100 == 0 && record.Id > operationRecordsKept {
+		err = db.Select(q.Lte("Id", record.Id-operationRecordsKept)).Delete(&OperationRecord{})
+		if err == storm.ErrNotFound {
+			err = nil
+		}
+	}
+
+	return err
+}
+
+// FindOperationRecords returns audit entries of operation, or the latest limit entries if operation is not set
+func FindOperationRecords(operation string, limit int) (records []OperationRecord, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if operation != "" {
+		err = db.Find("Operation", operation, &records)
+	} else {
+		err = db.AllByIndex("Id", &records, storm.Limit(limit), storm.Reverse())
+	}
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return records, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Operation audit
+
+// API tokens >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveApiToken(token *ApiToken) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(token)
+}
+
+func FindApiToken(field string, value interface{}) (token *ApiToken, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var t ApiToken
+	err = db.One(field, value, &t)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func GetAllApiTokens() (tokens []ApiToken, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&tokens)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return tokens, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> API tokens
+
+// Signing keys >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveSigningKey(key *SigningKey) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(key)
+}
+
+func FindSigningKey(name string) (key *SigningKey, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var k SigningKey
+	err = db.One("Name", name, &k)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+func GetAllSigningKeys() (keys []SigningKey, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&keys)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return keys, err
+}
+
+func RemoveSigningKey(key *SigningKey) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(key)
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Signing keys
+
+// Template updates >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveTemplateUpdate(update *TemplateUpdate) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(update)
+}
+
+func FindTemplateUpdate(template string) (update *TemplateUpdate, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var u TemplateUpdate
+	err = db.One("Template", template, &u)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func SaveUpgradePolicy(policy *UpgradePolicy) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(policy)
+}
+
+func FindUpgradePolicy(container string) (policy *UpgradePolicy, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var p UpgradePolicy
+	err = db.One("Container", container, &p)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func GetAllUpgradePolicies() (policies []UpgradePolicy, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&policies)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return policies, err
+}
+
+func RemoveUpgradePolicy(policy *UpgradePolicy) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(policy)
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Template updates
+
+// Shared templates >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveSharedTemplate(template *SharedTemplate) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(template)
+}
+
+func GetAllSharedTemplates() (templates []SharedTemplate, err error) {
+	var db *handle
+	db, err = getDb(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&templates)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return templates, err
+}
+
+func RemoveSharedTemplate(template *SharedTemplate) (err error) {
+	var db *handle
+	db, err = getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(template)
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Shared templates
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+	"go.etcd.io/bbolt"
+)
+
+// Database is opened once and shared by all operations of a process. Bolt allows only one process
+// to have database open, so shared instance is closed after short idle period to let other agent
+// processes (CLI commands executed by daemon and vice versa) access it
+
+const (
+	//how long database stays open after last operation
+	idleTimeout = 500 * time.Millisecond
+	//bolt waits for file lock up to openTimeout, opening is retried openRetries times
+	openTimeout = 5 * time.Second
+	openRetries = 3
+)
+
+var shared struct {
+	sync.Mutex
+	db    *storm.DB
+	refs  int
+	timer *time.Timer
+}
+
+// handle is a reference to shared database, closing it releases the reference
+type handle struct {
+	*storm.DB
+}
+
+func (h *handle) Close() error {
+	shared.Lock()
+	defer shared.Unlock()
+
+	shared.refs--
+	if shared.refs == 0 {
+		shared.timer = time.AfterFunc(idleTimeout, closeIdle)
+	}
+
+	return nil
+}
+
+// initDb creates database file with storage of db structs
+func initDb(dbFilePath string) error {
+	db, err := storm.Open(dbFilePath, storm.BoltOptions(0600, &bolt.Options{ReadOnly: false}))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	//init db structs
+	for _, data := range []interface{}{&SshTunnel{}, &Proxy{}, &ProxiedServer{}, &TemplateStats{}, &MirrorStats{}, &RegistrationToken{}} {
+		if err = db.Init(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDb returns reference to shared database located in data prefix, database is opened on first use.
+// Path is resolved on open, so data prefix may be changed at runtime, e.g. by test harness
+func getDb(readOnly bool) (*handle, error) {
+	shared.Lock()
+	defer shared.Unlock()
+
+	if shared.timer != nil {
+		shared.timer.Stop()
+		shared.timer = nil
+	}
+
+	if shared.db == nil {
+		db, err := open(path.Join(config.Agent.DataPrefix, "agent.db"))
+		if err != nil {
+			return nil, err
+		}
+		shared.db = db
+	}
+
+	shared.refs++
+	return &handle{shared.db}, nil
+}
+
+func open(dbFilePath string) (*storm.DB, error) {
+	if !fs.FileExists(dbFilePath) {
+		if err := initDb(dbFilePath); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	for attempt := 1; attempt <= openRetries; attempt++ {
+		var db *storm.DB
+		//workaround: seems storm has bug related with read-only mode, it still tries to open db as read-write
+		//batch mode coalesces concurrent writes of daemon routines into fewer transactions
+		db, err = storm.Open(dbFilePath, storm.Batch(),
+			storm.BoltOptions(0600, &bolt.Options{Timeout: openTimeout, ReadOnly: false}))
+		if err != bolt.ErrTimeout {
+			return db, err
+		}
+
+		log.Debug("Database is locked by another process, retrying")
+	}
+
+	return nil, err
+}
+
+func closeIdle() {
+	shared.Lock()
+	defer shared.Unlock()
+
+	//database got referenced again after timer fired
+	if shared.refs > 0 || shared.db == nil {
+		return
+	}
+
+	log.Check(log.DebugLevel, "Closing database", shared.db.Close())
+	shared.db = nil
+}
+
+// Close closes shared database immediately, pending references must be released before
+func Close() {
+	shared.Lock()
+	defer shared.Unlock()
+
+	if shared.timer != nil {
+		shared.timer.Stop()
+		shared.timer = nil
+	}
+	if shared.db != nil {
+		log.Check(log.DebugLevel, "Closing database", shared.db.Close())
+		shared.db = nil
+	}
+}
+
+// updateTx runs fn in a single read-write transaction, changes are committed only if fn returns nil
+func updateTx(fn func(tx storm.Node) error) error {
+	db, err := getDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -1,5 +1,7 @@
 package db
 
+import "time"
+
 type Proxy struct {
 	Id             int    `storm:"id,increment"`
 	Protocol       string `storm:"index"`
@@ -11,6 +13,23 @@ type Proxy struct {
 	Redirect80Port bool
 	SslBackend     bool
 	Http2          bool
+	//host interface or address proxy listens on, all addresses if empty
+	Bind string
+	//external address redirected to proxy for internal clients, no NAT reflection if empty
+	Reflect string
+	//set of servers receiving requests, default set if empty
+	ActiveSet string
+	//serve maintenance page instead of proxying requests, http(s) only
+	Maintenance bool
+	//CA bundle and CRL verifying client certificates, client certificates are not required if CA is empty
+	ClientCa   string
+	ClientCrl  string
+	ClientOcsp bool
+	//countries allowed or denied access, ISO 3166 alpha-2 codes; no country policy if both are empty
+	GeoAllow []string
+	GeoDeny  []string
+	//count requests per country of client
+	GeoLog bool
 }
 
 func (p Proxy) IsLE() bool {
@@ -21,6 +40,24 @@ type ProxiedServer struct {
 	Id       int    `storm:"id,increment"`
 	ProxyTag string `storm:"index"`
 	Socket   string `storm:"index"`
+	//set of servers for blue-green switch, default set if empty
+	Set string
+}
+
+// ProxyStats holds traffic of proxy accumulated from nginx access log since Since
+type ProxyStats struct {
+	Id       int    `storm:"id,increment"`
+	ProxyTag string `storm:"unique"`
+	//requests for http(s), sessions for tcp/udp
+	Requests int64
+	BytesIn  int64
+	BytesOut int64
+	Since    time.Time
+	//requests by country of client, counted while logging of countries is turned on
+	Countries map[string]int64
+	//bytes of access log and of its rotated copy already counted
+	Offset        int64
+	RotatedOffset int64
 }
 
 type SshTunnel struct {
@@ -39,10 +76,225 @@ type Container struct {
 	EnvironmentId   string
 	Gateway         string
 	Ip              string
+	Ipv6            string
+	Gateway6        string
 	Interface       string
 	Uid             string
 	Template        string
 	TemplateOwner   string
 	TemplateVersion string
 	TemplateId      string
+	//labels set with "subutai label" to select containers, e.g. by "subutai exec --label"
+	Labels map[string]string
+	//tenant container belongs to, empty for containers of host owner
+	Tenant string `storm:"index"`
+}
+
+type TemplateStats struct {
+	Id             int    `storm:"id,increment"`
+	Template       string `storm:"unique"`
+	Imports        int
+	CacheHits      int
+	CacheMisses    int
+	Downloads      int
+	DownloadTime   time.Duration
+	Failures       int
+	FailureReasons map[string]int
+	LastImport     time.Time
+}
+
+type MirrorStats struct {
+	Id           int    `storm:"id,increment"`
+	Mirror       string `storm:"unique"`
+	Downloads    int
+	Failures     int
+	Bytes        int64
+	DownloadTime time.Duration
+	//failures since the last successful download, mirror failing repeatedly is tried after healthy ones
+	ConsecutiveFailures int
+	LastFailure         time.Time
+}
+
+// RegistrationToken is a console secret used to register container keys with management.
+// Only hash of token is stored
+type RegistrationToken struct {
+	Id      int    `storm:"id,increment"`
+	Hash    string `storm:"unique"`
+	Scopes  []string
+	Created time.Time
+	Expires time.Time
+	Used    time.Time
+	UsedBy  string
+	Revoked bool
+	//token of tenant registers keys of containers of the tenant only
+	Tenant string
+}
+
+// QuotaProfile is a named set of quotas applied to container in one step.
+// Quotas are keyed by resource and hold values in form accepted by "subutai quota set"
+type QuotaProfile struct {
+	Id     int    `storm:"id,increment"`
+	Name   string `storm:"unique"`
+	Quotas map[string]string
+}
+
+// Tenant is a customer or Console instance sharing resource host with others. Containers of tenant are named
+// with tenant prefix and sum of their quotas is limited, zero limit means no limit
+type Tenant struct {
+	Id   int    `storm:"id,increment"`
+	Name string `storm:"unique"`
+	//percents of all cores
+	CPU int
+	//Mb
+	RAM int
+	//Gb
+	Disk       int
+	Containers int
+	Created    time.Time
+}
+
+// ClusterPeer is a resource host found by cluster discovery, either listed in config or learnt from other peers
+type ClusterPeer struct {
+	Id      int    `storm:"id,increment"`
+	Name    string `storm:"unique"`
+	Address string `storm:"index"`
+	Static  bool
+	Seen    time.Time
+}
+
+// ClusterLease is lease of cluster-wide lock granted by this host, leases are kept across restarts of daemon
+// so that restarted member does not grant lock held by another holder
+type ClusterLease struct {
+	Name    string `storm:"id"`
+	Holder  string
+	Expires time.Time
+}
+
+// ManagementReplica describes management container of primary host replicated to this standby host
+type ManagementReplica struct {
+	Id        int    `storm:"id,increment"`
+	Container string `storm:"unique"`
+	Primary   string
+	//label of the latest snapshot received from primary
+	Label    string
+	Updated  time.Time
+	Promoted bool
+}
+
+// Package is a package installed in container by its package manager
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+}
+
+// PackageInventory holds packages installed in container or template as collected last
+type PackageInventory struct {
+	Id        int       `storm:"id,increment" json:"id"`
+	Container string    `storm:"unique" json:"container"`
+	Manager   string    `json:"manager"`
+	Packages  []Package `json:"packages"`
+	Collected time.Time `json:"collected"`
+}
+
+// ScanResult holds outcome of scanning files of container with scanner configured on host
+type ScanResult struct {
+	Id        int       `storm:"id,increment" json:"id"`
+	Container string    `storm:"index" json:"container"`
+	Path      string    `json:"path"`
+	Status    string    `json:"status"`
+	Findings  []string  `json:"findings"`
+	Error     string    `json:"error,omitempty"`
+	Errors    []string  `json:"errors,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+}
+
+// SnapshotMeta describes snapshot created with "subutai snapshot create", snapshot of all partitions is keyed
+// by snapshot of container dataset, e.g. foo@pre-upgrade
+type SnapshotMeta struct {
+	Id          int    `storm:"id,increment"`
+	Snapshot    string `storm:"unique"`
+	Description string
+	Creator     string
+	Operation   string
+	Created     time.Time
+}
+
+// TemplateMeta describes provenance of installed template as carried in its manifest or published by CDN
+type TemplateMeta struct {
+	Id          int      `storm:"id,increment" json:"id"`
+	Template    string   `storm:"unique" json:"template"`
+	Description string   `json:"description,omitempty"`
+	License     string   `json:"license,omitempty"`
+	Homepage    string   `json:"homepage,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// OperationRecord is audit entry of a single agent command invocation, invocations of the same operation,
+// e.g. Console command and agent commands it ran, share operation id
+type OperationRecord struct {
+	Id        int       `storm:"id,increment" json:"id"`
+	Operation string    `storm:"index" json:"operation"`
+	Command   string    `json:"command"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	ExitCode  int       `json:"exitCode"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ApiToken is token of caller of daemon API validated by local backend, only hash of token is stored
+type ApiToken struct {
+	Id      int    `storm:"id,increment"`
+	Hash    string `storm:"unique"`
+	Name    string `storm:"unique"`
+	Role    string
+	Created time.Time
+	//zero if token does not expire
+	Expires time.Time
+	Revoked bool
+}
+
+// SigningKey is ed25519 key pair of host signing templates it exports, or public key of another host trusted
+// to sign templates imported here. Trusted keys have no private key
+type SigningKey struct {
+	Id        int    `storm:"id,increment"`
+	Name      string `storm:"unique"`
+	PublicKey []byte
+	//sealed with key kept outside of db, see lib/signing
+	PrivateKey []byte
+	Created    time.Time
+	//zero if key does not expire
+	Expires time.Time
+}
+
+// TemplateUpdate is the latest version of template containers are cloned from found on CDN by update watcher,
+// template is keyed as name@owner. Automatic upgrades to version start once it is known for soak period
+type TemplateUpdate struct {
+	Id       int    `storm:"id,increment"`
+	Template string `storm:"unique"`
+	Version  string
+	Found    time.Time
+	//container automatic upgrade of which to version failed, rollout of version is halted then
+	FailedOn string
+}
+
+// UpgradePolicy opts container into automatic upgrades to new versions of its template, upgrades are done
+// in maintenance window only
+type UpgradePolicy struct {
+	Id        int    `storm:"id,increment"`
+	Container string `storm:"unique"`
+	//maintenance window, e.g. "sat 02:00-04:00" or "daily 22:00-02:00"
+	Window      string
+	LastUpgrade time.Time
+	LastError   string
+}
+
+// SharedTemplate is archive of template kept in cache for cluster peers, least recently used archives are
+// removed once archives exceed cache limit
+type SharedTemplate struct {
+	Id   string `storm:"id"`
+	Size int64
+	//time archive was last imported or sent to peer
+	Used time.Time
 }
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/subutai-io/agent/log"
+)
+
+// Named locks serialize lifecycle operations on the same instance across agent processes,
+// while operations on different instances run in parallel.
+// To stay deadlock free locks are always acquired in the order: container, tenant, template, parent template,
+// network, cpu.
+// Locks acquired together by AcquireLocks are sorted accordingly; nested acquisition must follow the same order,
+// e.g. clone holds container lock while importing template, import holds template lock while importing parent.
+// Locks are reentrant within a process, so import may destroy leftovers of template it holds lock for.
+// Network lock is host wide, it is held only while address of container is chosen and written to its config.
+// CPU lock is host wide too, it is held while cores are assigned to containers
+
+// LockKind defines rank of a lock, locks of lower rank are acquired first
+type LockKind int
+
+const (
+	ContainerLock LockKind = iota
+	TenantLock
+	TemplateLock
+	NetworkLock
+	CPULock
+)
+
+// NetworkLockKey is the lock serializing allocation of container addresses on host
+var NetworkLockKey = LockKey{Kind: NetworkLock, Name: "address"}
+
+// CPULockKey is the lock serializing assignment of cores to containers on host
+var CPULockKey = LockKey{Kind: CPULock, Name: "cpuset"}
+
+func (k LockKind) String() string {
+	switch k {
+	case TenantLock:
+		return "tenant"
+	case TemplateLock:
+		return "template"
+	case NetworkLock:
+		return "network"
+	case CPULock:
+		return "cpu"
+	}
+	return "container"
+}
+
+// LockKey identifies named lock
+type LockKey struct {
+	Kind LockKind
+	Name string
+}
+
+// Locks holds named locks acquired together
+type Locks struct {
+	keys []LockKey
+}
+
+type heldLock struct {
+	file  lockfile.Lockfile
+	count int
+}
+
+var (
+	heldMu sync.Mutex
+	held   = make(map[LockKey]*heldLock)
+)
+
+//directory of lock files, shared with LockFile
+const lockDir = "/var/run/lock/"
+
+func (k LockKey) file() string {
+	return path.Join(lockDir, strings.Join([]string{"subutai", k.Kind.String(), strings.Replace(k.Name, "/", "_", -1)}, "."))
+}
+
+// AcquireLocks blocks until all locks are acquired, Release must be called to free them
+func AcquireLocks(keys ...LockKey) *Locks {
+	sorted := append([]LockKey(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Kind != sorted[j].Kind {
+			return sorted[i].Kind < sorted[j].Kind
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	locks := &Locks{}
+	for _, key := range sorted {
+		for !tryAcquire(key) {
+			time.Sleep(time.Second)
+		}
+		locks.keys = append(locks.keys, key)
+	}
+
+	return locks
+}
+
+// Release frees locks in reverse order of acquisition
+func (l *Locks) Release() {
+	heldMu.Lock()
+	defer heldMu.Unlock()
+
+	for i := len(l.keys) - 1; i >= 0; i-- {
+		key := l.keys[i]
+		h, ok := held[key]
+		if !ok {
+			continue
+		}
+
+		h.count--
+		if h.count == 0 {
+			log.Check(log.DebugLevel, "Unlocking "+key.file(), h.file.Unlock())
+			delete(held, key)
+		}
+	}
+	l.keys = nil
+}
+
+func tryAcquire(key LockKey) bool {
+	heldMu.Lock()
+	defer heldMu.Unlock()
+
+	if h, ok := held[key]; ok {
+		h.count++
+		return true
+	}
+
+	for other := range held {
+		if other.Kind > key.Kind {
+			log.Warn(fmt.Sprintf("Acquiring %s lock %s while holding %s lock %s violates lock order",
+				key.Kind, key.Name, other.Kind, other.Name))
+		}
+	}
+
+	file := key.file()
+	lock, err := lockfile.New(file)
+	if log.Check(log.DebugLevel, "Init lock "+file, err) {
+		return false
+	}
+
+	err = lock.TryLock()
+	if log.Check(log.DebugLevel, "Locking file "+file, err) {
+		//lock file of process which pid got reused by non-agent process is stale
+		if p, err := lock.GetOwner(); err == nil {
+			cmd, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/cmdline", p.Pid))
+			if err != nil || !strings.Contains(string(cmd), "subutai") {
+				log.Check(log.DebugLevel, "Removing broken lock file "+file, os.Remove(file))
+			}
+		}
+		return false
+	}
+
+	held[key] = &heldLock{file: lock, count: 1}
+	return true
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+)
+
+// OperationEnv is environment variable passing operation id to agent processes, commands executed for
+// Console get id of Console command, so its action can be traced through agent logs and audit
+const OperationEnv = "SUBUTAI_OPERATION_ID"
+
+var operationRx = regexp.MustCompile(`^[[:alnum:]_.:-]{1,64}$`)
+
+// NewOperationID generates random operation id
+func NewOperationID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// IsOperationID checks if id may be used as operation id
+func IsOperationID(id string) bool {
+	return operationRx.MatchString(id)
+}
@@ -3,10 +3,10 @@ package common
 import (
 	"fmt"
 	"github.com/nightlyone/lockfile"
+	"github.com/subutai-io/agent/lib/exec"
 	"github.com/subutai-io/agent/log"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"reflect"
 	"runtime"
@@ -57,7 +57,7 @@ func LockFile(name string, command string) (lockfile.Lockfile, error) {
 }
 
 func GetMajorVersion() uint16 {
-	output, err := exec.Command("lxc-info", "--version").Output()
+	output, err := exec.Output("lxc-info", "--version")
 	if err != nil {
 		log.Check(log.ErrorLevel, "Failed to get lxc version: ", err)
 		return 0
@@ -0,0 +1,90 @@
+package container
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/log"
+)
+
+//start of container is retried for a minute, e.g. while its network or cgroups are still being released
+const startAttempts = 60
+
+// BulkResult is outcome of bulk operation for one of its containers, error is nil if operation succeeded
+type BulkResult struct {
+	Name string
+	Err  error
+}
+
+// StartAll starts containers, up to parallel at once. Running containers are left as is, failed starts are
+// retried like "subutai start" does. Results are in order of names
+func StartAll(names []string, parallel int) []BulkResult {
+	return runAll(names, parallel, func(name string) error {
+		if !IsContainer(name) {
+			return errors.Errorf("Container %s not found", name)
+		}
+		if State(name) == Running {
+			return nil
+		}
+		err := Start(name)
+		for i := 0; i < startAttempts && err != nil; i++ {
+			log.Debug("Retrying start of " + name + ": " + err.Error())
+			time.Sleep(time.Second)
+			err = Start(name)
+		}
+		return err
+	})
+}
+
+// StopAll stops containers, up to parallel at once. Stopped containers are left as is. Results are in order
+// of names
+func StopAll(names []string, parallel int) []BulkResult {
+	return runAll(names, parallel, func(name string) error {
+		if !IsContainer(name) {
+			return errors.Errorf("Container %s not found", name)
+		}
+		if State(name) == Stopped {
+			return nil
+		}
+		return Stop(name)
+	})
+}
+
+// DestroyAll destroys containers and templates, up to parallel at once. Network resources of containers, e.g.
+// port mappings, must be released by caller beforehand. Results are in order of names
+func DestroyAll(names []string, parallel int) []BulkResult {
+	return runAll(names, parallel, func(name string) error {
+		if IsTemplate(name) {
+			return DestroyTemplate(name)
+		}
+		if !IsContainer(name) {
+			return errors.Errorf("%s not found", name)
+		}
+		return DestroyContainer(name)
+	})
+}
+
+// runAll runs operation for each of names in up to parallel goroutines
+func runAll(names []string, parallel int, op func(name string) error) []BulkResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]BulkResult, len(names))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for i, name := range names {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			results[i] = BulkResult{Name: name, Err: op(name)}
+		}(i, name)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,164 @@
+package container
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// file present in root of unified cgroup v2 hierarchy only
+const cgroup2Controllers = "/sys/fs/cgroup/cgroup.controllers"
+
+// cgroup v1 items of cpu, memory and block IO limits, io throttling and hugetlb limits are translated by
+// cgroup2Item too, other items used by agent are named the same in both hierarchies
+const (
+	cpuQuotaItem    = "cpu.cfs_quota_us"
+	memoryLimitItem = "memory.limit_in_bytes"
+	ioWeightItem    = "blkio.weight"
+)
+
+var unified struct {
+	sync.Once
+	value bool
+}
+
+// UnifiedCgroup checks if host runs containers in unified cgroup v2 hierarchy. Agent names cgroup items as
+// in v1 hierarchy, they are translated to v2 items when host has unified hierarchy
+func UnifiedCgroup() bool {
+	unified.Do(func() {
+		_, err := os.Stat(cgroup2Controllers)
+		unified.value = err == nil
+	})
+	return unified.value
+}
+
+// CgroupConf returns container config items setting cgroup v1 item to value for hierarchy of host, empty value
+// removes item. On unified hierarchy legacy item is removed too since LXC does not start containers having it
+func CgroupConf(item, value string) [][]string {
+	if UnifiedCgroup() {
+		v2Item, v2Value := cgroup2Item(item, value)
+		return [][]string{{"lxc.cgroup2." + v2Item, v2Value}, {"lxc.cgroup." + item, ""}}
+	}
+	return [][]string{{"lxc.cgroup." + item, value}}
+}
+
+// CgroupProperty returns value of cgroup v1 item in config of container in v1 form. On unified hierarchy
+// legacy item is returned if container config is not converted yet
+func CgroupProperty(name, item string) string {
+	if !UnifiedCgroup() {
+		return GetProperty(name, "lxc.cgroup."+item)
+	}
+
+	v2Item, _ := cgroup2Item(item, "")
+	props := GetProperties(name, "lxc.cgroup2."+v2Item, "lxc.cgroup."+item)
+	if value := props["lxc.cgroup2."+v2Item]; value != "" {
+		return cgroup1Value(item, value)
+	}
+	return props["lxc.cgroup."+item]
+}
+
+// cgroup2Item translates cgroup v1 item and its value to v2 hierarchy, e.g. cpu.cfs_quota_us 200000 to
+// cpu.max "200000 100000" or blkio.throttle.read_bps_device "8:0 1048576" to io.max "8:0 rbps=1048576".
+// Value meaning no limit is translated to max, empty value is kept
+func cgroup2Item(item, value string) (string, string) {
+	//hugetlb.2MB.limit_in_bytes is hugetlb.2MB.max
+	if strings.HasPrefix(item, "hugetlb.") && strings.HasSuffix(item, ".limit_in_bytes") {
+		if value == "-1" {
+			value = "max"
+		}
+		return strings.TrimSuffix(item, ".limit_in_bytes") + ".max", value
+	}
+
+	for i, throttle := range ioThrottleItems {
+		if item != throttle {
+			continue
+		}
+		if fields := strings.Fields(value); len(fields) == 2 {
+			rate, _ := strconv.ParseInt(fields[1], 10, 64)
+			value = fields[0] + " " + ioMaxKeys[i] + "=" + ioMaxValue(rate)
+		}
+		return "io.max", value
+	}
+
+	switch item {
+	case cpuQuotaItem:
+		switch value {
+		case "":
+		case "-1":
+			value = "max"
+		default:
+			value = value + " " + strconv.Itoa(cfsPeriod)
+		}
+		return "cpu.max", value
+	case memoryLimitItem:
+		if value == "-1" {
+			value = "max"
+		}
+		return "memory.max", value
+	case ioWeightItem:
+		//weight is scaled the way systemd does, so default 500 of blkio.weight is default 100 of io.weight
+		if weight, err := strconv.Atoi(value); err == nil {
+			value = strconv.Itoa(weight / 5)
+		}
+		return "io.weight", value
+	}
+
+	return item, value
+}
+
+// cgroup1Value translates value of cgroup v2 item to form of corresponding v1 item
+func cgroup1Value(item, value string) string {
+	for i, throttle := range ioThrottleItems {
+		if item != throttle {
+			continue
+		}
+		//io.max holds all limits of device, e.g. "8:0 rbps=1048576 wbps=max riops=max wiops=max"
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return value
+		}
+		rate := "0"
+		for _, f := range fields[1:] {
+			if strings.HasPrefix(f, ioMaxKeys[i]+"=") && f != ioMaxKeys[i]+"=max" {
+				rate = strings.TrimPrefix(f, ioMaxKeys[i]+"=")
+			}
+		}
+		return fields[0] + " " + rate
+	}
+
+	switch item {
+	case cpuQuotaItem:
+		//cpu.max holds quota and period, quota is scaled to period used by agent
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return value
+		}
+		if fields[0] == "max" {
+			return "-1"
+		}
+		if len(fields) == 2 {
+			quota, err := strconv.Atoi(fields[0])
+			period, err2 := strconv.Atoi(fields[1])
+			if err == nil && err2 == nil && period > 0 {
+				return strconv.Itoa(quota * cfsPeriod / period)
+			}
+		}
+		return fields[0]
+	case memoryLimitItem:
+		if value == "max" {
+			return strconv.FormatInt(unlimitedMemory, 10)
+		}
+	case ioWeightItem:
+		//io.weight of cgroup is read as "default 100"
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return value
+		}
+		if weight, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+			return strconv.Itoa(weight * 5)
+		}
+	}
+
+	return value
+}
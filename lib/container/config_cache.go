@@ -0,0 +1,122 @@
+package container
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/templ"
+)
+
+// parsedConfig holds items of container config file along with file attributes it was parsed at
+type parsedConfig struct {
+	modTime time.Time
+	size    int64
+	items   map[string]string
+}
+
+// configCache keeps parsed container config files keyed by path,
+// entry is re-parsed once modification time or size of file changes
+var configCache = struct {
+	sync.Mutex
+	entries map[string]*parsedConfig
+}{entries: make(map[string]*parsedConfig)}
+
+// parseConfig reads config file, first occurrence of item wins as it did with sequential scan
+func parseConfig(file *os.File) map[string]string {
+	items := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), "=")
+		if len(line) < 2 {
+			continue
+		}
+		key := strings.Trim(line[0], " ")
+		if _, ok := items[key]; !ok {
+			items[key] = strings.Trim(line[1], " ")
+		}
+	}
+	return items
+}
+
+// configItems returns parsed items of config file, nil if file can not be read
+func configItems(path string) map[string]string {
+	file, err := os.Open(path)
+	if err != nil {
+		invalidateConfig(path)
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		invalidateConfig(path)
+		return nil
+	}
+
+	configCache.Lock()
+	defer configCache.Unlock()
+
+	if entry, ok := configCache.entries[path]; ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.items
+	}
+
+	entry := &parsedConfig{modTime: info.ModTime(), size: info.Size(), items: parseConfig(file)}
+	configCache.entries[path] = entry
+
+	return entry.items
+}
+
+// invalidateConfig drops cached items of config file, it must be called after config is written
+// since modification time resolution may be too coarse to detect quick successive writes
+func invalidateConfig(path string) {
+	configCache.Lock()
+	delete(configCache.entries, path)
+	configCache.Unlock()
+}
+
+// GetConfigItems returns values of several parameters from the configuration file of the Subutai container,
+// missing parameters have empty values
+func GetConfigItems(path string, items ...string) map[string]string {
+	parsed := configItems(path)
+
+	values := make(map[string]string, len(items))
+	for _, item := range items {
+		values[item] = parsed[item]
+	}
+	return values
+}
+
+// ConfigRef returns template reference stored in config file under item, item.owner and item.version,
+// e.g. subutai.parent for parent template
+func ConfigRef(path, item string) (templ.Ref, error) {
+	props := GetConfigItems(path, item, item+".owner", item+".version")
+	return templ.NewRef(props[item], props[item+".owner"], props[item+".version"])
+}
+
+// ParentRef returns reference of parent template of container or template, template without parent refers to itself
+func ParentRef(templateOrContainerName string) (templ.Ref, error) {
+	return ConfigRef(path.Join(config.Agent.LxcPrefix, templateOrContainerName, "config"), "subutai.parent")
+}
+
+// ParentSnapshot returns label of parent template snapshot container was cloned from
+func ParentSnapshot(containerName string) string {
+	if label := GetProperty(containerName, "subutai.parent.snapshot"); label != "" {
+		return label
+	}
+	return "now"
+}
+
+// TemplateRef returns reference of template container was cloned from, or of template itself
+func TemplateRef(templateOrContainerName string) (templ.Ref, error) {
+	return ConfigRef(path.Join(config.Agent.LxcPrefix, templateOrContainerName, "config"), "subutai.template")
+}
+
+// GetProperties returns values of several parameters of the Subutai container or template
+func GetProperties(templateOrContainerName string, propertyNames ...string) map[string]string {
+	return GetConfigItems(path.Join(config.Agent.LxcPrefix, templateOrContainerName, "config"), propertyNames...)
+}
@@ -0,0 +1,379 @@
+package container
+
+import (
+	"io/ioutil"
+	"math"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/metrics/cgroup"
+	"github.com/subutai-io/agent/log"
+)
+
+// CPUsetAuto is cpuset quota letting agent assign cores of container and rebalance them periodically
+const CPUsetAuto = "auto"
+
+// cpusetMode is config item marking containers which cores are assigned by agent
+const cpusetMode = "subutai.cpuset"
+
+// balanceTolerance is load per core by which node of container may exceed the least loaded node
+// before container is moved, so containers do not flap between nodes of similar load
+const balanceTolerance = 0.1
+
+// NumaNode is NUMA node of host with its cores
+type NumaNode struct {
+	Id    int
+	Cores []int
+}
+
+// CPUDemand is request of container for cores
+type CPUDemand struct {
+	Name    string
+	Cores   int     //number of cores to assign
+	Load    float64 //expected load in cores
+	Current []int   //cores assigned now, kept where possible
+}
+
+// CPUPlacement is set of cores and memory nodes assigned to container
+type CPUPlacement struct {
+	Cores []int
+	Nodes []int
+}
+
+// CPUBalancer assigns cores to running containers with automatic cpuset. Usage of containers is sampled
+// between passes of the same balancer, the first pass relies on cpu quotas only
+type CPUBalancer struct {
+	samples map[string]cgroup.CPUSample
+}
+
+//named locks are reentrant within process, so balancers of the same process are serialized by mutex
+var balancerMu sync.Mutex
+
+// IsCPUsetAuto returns true if cores of container are assigned by agent
+func IsCPUsetAuto(name string) bool {
+	return GetProperty(name, cpusetMode) == CPUsetAuto
+}
+
+// CPUTopology returns NUMA nodes of host, all cores are placed in a single node if kernel exposes none
+func CPUTopology() ([]NumaNode, error) {
+	dirs, err := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []NumaNode
+	for _, dir := range dirs {
+		id, err := strconv.Atoi(strings.TrimPrefix(path.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		out, err := ioutil.ReadFile(path.Join(dir, "cpulist"))
+		if err != nil {
+			return nil, err
+		}
+		cores, err := ParseCPUList(string(out))
+		if err != nil {
+			return nil, err
+		}
+		//memory-only nodes have no cores to assign
+		if len(cores) > 0 {
+			nodes = append(nodes, NumaNode{Id: id, Cores: cores})
+		}
+	}
+
+	if len(nodes) == 0 {
+		node := NumaNode{}
+		for i := 0; i < runtime.NumCPU(); i++ {
+			node.Cores = append(node.Cores, i)
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Id < nodes[j].Id })
+
+	return nodes, nil
+}
+
+// ParseCPUList parses list of cores in kernel format, e.g. 0-3,8,10-11
+func ParseCPUList(list string) ([]int, error) {
+	var cores []int
+	seen := make(map[int]bool)
+	for _, item := range strings.Split(strings.TrimSpace(list), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		bounds := strings.SplitN(item, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, errors.Errorf("Invalid core %s in list %s", bounds[0], list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, errors.Errorf("Invalid range %s in list %s", item, list)
+			}
+		}
+		for core := first; core <= last; core++ {
+			if !seen[core] {
+				seen[core] = true
+				cores = append(cores, core)
+			}
+		}
+	}
+	sort.Ints(cores)
+
+	return cores, nil
+}
+
+// FormatCPUList formats cores in kernel format with consecutive cores joined into ranges
+func FormatCPUList(cores []int) string {
+	sorted := append([]int(nil), cores...)
+	sort.Ints(sorted)
+
+	var items []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			items = append(items, strconv.Itoa(sorted[i]))
+		} else {
+			items = append(items, strconv.Itoa(sorted[i])+"-"+strconv.Itoa(sorted[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(items, ",")
+}
+
+// BalanceCPUsets assigns cores to demands, load holds load of cores by containers which are not balanced.
+// Demand is kept within a single NUMA node when the node has enough cores, so memory of container stays
+// local to its cores. The least loaded node and cores are picked, current node and cores of container
+// are preferred unless they are loaded noticeably more than others
+func BalanceCPUsets(nodes []NumaNode, load map[int]float64, demands []CPUDemand) map[string]CPUPlacement {
+	coreLoad := make(map[int]float64)
+	coreNode := make(map[int]int)
+	var all []int
+	for _, node := range nodes {
+		for _, core := range node.Cores {
+			coreLoad[core] = load[core]
+			coreNode[core] = node.Id
+			all = append(all, core)
+		}
+	}
+
+	//larger demands are placed first while there are whole nodes to fit them
+	sorted := append([]CPUDemand(nil), demands...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Cores != sorted[j].Cores {
+			return sorted[i].Cores > sorted[j].Cores
+		}
+		if sorted[i].Load != sorted[j].Load {
+			return sorted[i].Load > sorted[j].Load
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	placements := make(map[string]CPUPlacement)
+	for _, demand := range sorted {
+		count := demand.Cores
+		if count < 1 {
+			count = 1
+		} else if count > len(all) {
+			count = len(all)
+		}
+		current := make(map[int]bool)
+		for _, core := range demand.Current {
+			current[core] = true
+		}
+
+		//home node holds most of current cores of container
+		best, home := -1, -1
+		bestLoad, homeLoad := 0.0, 0.0
+		homeCores := 0
+		for i, node := range nodes {
+			if len(node.Cores) < count {
+				continue
+			}
+			sum, owned := demand.Load, 0
+			for _, core := range node.Cores {
+				sum += coreLoad[core]
+				if current[core] {
+					owned++
+				}
+			}
+			avg := sum / float64(len(node.Cores))
+			if best < 0 || avg < bestLoad {
+				best, bestLoad = i, avg
+			}
+			if owned > homeCores {
+				home, homeLoad, homeCores = i, avg, owned
+			}
+		}
+		if home >= 0 && homeLoad <= bestLoad+balanceTolerance {
+			best = home
+		}
+
+		//demand larger than any node spans nodes
+		pool := all
+		if best >= 0 {
+			pool = nodes[best].Cores
+		}
+		pool = append([]int(nil), pool...)
+		sort.Slice(pool, func(i, j int) bool {
+			li, lj := coreLoad[pool[i]], coreLoad[pool[j]]
+			if math.Abs(li-lj) > balanceTolerance {
+				return li < lj
+			}
+			if current[pool[i]] != current[pool[j]] {
+				return current[pool[i]]
+			}
+			return pool[i] < pool[j]
+		})
+
+		placement := CPUPlacement{Cores: pool[:count]}
+		sort.Ints(placement.Cores)
+		used := make(map[int]bool)
+		for _, core := range placement.Cores {
+			coreLoad[core] += demand.Load / float64(count)
+			if !used[coreNode[core]] {
+				used[coreNode[core]] = true
+				placement.Nodes = append(placement.Nodes, coreNode[core])
+			}
+		}
+		sort.Ints(placement.Nodes)
+		placements[demand.Name] = placement
+	}
+
+	return placements
+}
+
+// Rebalance assigns cores to running containers with automatic cpuset. Number of cores follows cpu quota
+// of container, or its usage measured since the previous pass plus a spare core if quota is not set.
+// Cores pinned manually to other containers count as loaded by them. Balancers of host run one at a time
+func (b *CPUBalancer) Rebalance() error {
+	balancerMu.Lock()
+	defer balancerMu.Unlock()
+	locks := common.AcquireLocks(common.CPULockKey)
+	defer locks.Release()
+
+	nodes, err := CPUTopology()
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, node := range nodes {
+		total += len(node.Cores)
+	}
+
+	rt := GetRuntime()
+	load := make(map[int]float64)
+	samples := make(map[string]cgroup.CPUSample)
+	var demands []CPUDemand
+	for _, name := range Containers() {
+		if State(name) != Running {
+			continue
+		}
+
+		cores, err := ParseCPUList(rt.CgroupItem(name, "cpuset.cpus"))
+		if log.Check(log.DebugLevel, "Parsing cores of "+name, err) {
+			cores = nil
+		}
+
+		usage, measured := 0.0, false
+		if sample, err := cgroup.SampleCPU(rt, name); err == nil {
+			usage, measured = cgroup.CPURate(b.samples[name], sample)
+			samples[name] = sample
+		}
+
+		quota := 0.0
+		if value, err := strconv.Atoi(rt.CgroupItem(name, "cpu.cfs_quota_us")); err == nil && value > 0 {
+			quota = float64(value) / (cfsPeriodMs * 1000)
+		}
+
+		if !IsCPUsetAuto(name) {
+			//containers running on all cores do not load particular ones
+			if len(cores) == 0 || len(cores) >= total {
+				continue
+			}
+			weight := float64(len(cores))
+			if measured {
+				weight = usage
+			} else if quota > 0 {
+				weight = math.Min(quota, weight)
+			}
+			for _, core := range cores {
+				load[core] += weight / float64(len(cores))
+			}
+			continue
+		}
+
+		demand := CPUDemand{Name: name, Current: cores}
+		switch {
+		case quota > 0:
+			demand.Cores = int(math.Ceil(quota))
+		case measured:
+			demand.Cores = int(math.Ceil(usage)) + 1
+		case len(cores) > 0:
+			demand.Cores = len(cores)
+		default:
+			demand.Cores = total
+		}
+		demand.Load = float64(demand.Cores)
+		if measured {
+			demand.Load = usage
+		}
+		demands = append(demands, demand)
+	}
+	b.samples = samples
+
+	current := make(map[string]string)
+	for _, demand := range demands {
+		current[demand.Name] = FormatCPUList(demand.Current)
+	}
+
+	for name, placement := range BalanceCPUsets(nodes, load, demands) {
+		cpus := FormatCPUList(placement.Cores)
+		if cpus != current[name] {
+			if log.Check(log.WarnLevel, "Setting cores of "+name, rt.SetCgroupItem(name, "cpuset.cpus", cpus)) {
+				continue
+			}
+			log.Check(log.WarnLevel, "Saving cores of "+name,
+				SetContainerConf(name, CgroupConf("cpuset.cpus", cpus)))
+			log.Debug("Cores " + cpus + " assigned to " + name)
+		}
+
+		//memory is allocated from nodes of assigned cores
+		if len(nodes) > 1 {
+			mems := FormatCPUList(placement.Nodes)
+			if mems != rt.CgroupItem(name, "cpuset.mems") {
+				log.Check(log.DebugLevel, "Setting memory nodes of "+name, rt.SetCgroupItem(name, "cpuset.mems", mems))
+				SetContainerConf(name, CgroupConf("cpuset.mems", mems))
+			}
+		}
+	}
+
+	return nil
+}
+
+// releaseCPUset turns off automatic cpuset of container, memory of container is allowed on all nodes again
+func releaseCPUset(name string) {
+	if CgroupProperty(name, "cpuset.mems") != "" {
+		if nodes, err := CPUTopology(); err == nil {
+			var ids []int
+			for _, node := range nodes {
+				ids = append(ids, node.Id)
+			}
+			log.Check(log.DebugLevel, "Setting memory nodes of "+name,
+				GetRuntime().SetCgroupItem(name, "cpuset.mems", FormatCPUList(ids)))
+		}
+	}
+	SetContainerConf(name, append([][]string{{cpusetMode, ""}}, CgroupConf("cpuset.mems", "")...))
+}
@@ -0,0 +1,178 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/templ"
+)
+
+// FakeRuntime emulates container runtime in memory so that agent logic can be exercised
+// on hosts without LXC, e.g. by test harness. Containers are the directories in config.Agent.LxcPrefix
+// holding config file, as for liblxc. Commands are not executed but recorded
+type FakeRuntime struct {
+	mu      sync.Mutex
+	states  map[string]string
+	cgroups map[string]map[string]string
+	ips     map[string][]string
+	// Commands holds commands passed to Exec and Attach in order of calls
+	Commands []FakeCommand
+	// Handler, if set, is called for each executed command and returns its exit code
+	Handler func(name string, command []string, options ExecOptions) int
+}
+
+// FakeCommand is a command recorded by FakeRuntime
+type FakeCommand struct {
+	Container string
+	Command   []string
+	Env       []string
+}
+
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		states:  make(map[string]string),
+		cgroups: make(map[string]map[string]string),
+		ips:     make(map[string][]string),
+	}
+}
+
+// SetIPAddresses sets addresses returned for container
+func (f *FakeRuntime) SetIPAddresses(name string, ips ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.ips[name] = ips
+}
+
+func (f *FakeRuntime) exists(name string) bool {
+	_, err := os.Stat(path.Join(config.Agent.LxcPrefix, name, "config"))
+	return err == nil
+}
+
+func (f *FakeRuntime) Names() []string {
+	entries, err := ioutil.ReadDir(config.Agent.LxcPrefix)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && f.exists(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// IsTemplate checks datasets as liblxc runtime does, so they are emulated by fake driver of lib/fs
+func (f *FakeRuntime) IsTemplate(name string) bool {
+	return isTemplateDataset(name)
+}
+
+func (f *FakeRuntime) IsContainer(name string) bool {
+	return isContainerDataset(name)
+}
+
+func (f *FakeRuntime) Clone(parent templ.Ref, child, label string) error {
+	return cloneDatasets(parent, child, label)
+}
+
+func (f *FakeRuntime) State(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.exists(name) {
+		return Unknown
+	}
+	if state, ok := f.states[name]; ok {
+		return state
+	}
+	return Stopped
+}
+
+func (f *FakeRuntime) setState(name, state string) error {
+	if !f.exists(name) {
+		return errors.Errorf("Container %s not found", name)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.states[name] = state
+	return nil
+}
+
+func (f *FakeRuntime) Start(name string) error {
+	return f.setState(name, Running)
+}
+
+func (f *FakeRuntime) Stop(name string) error {
+	return f.setState(name, Stopped)
+}
+
+func (f *FakeRuntime) Shutdown(name string, timeout time.Duration) error {
+	return f.setState(name, Stopped)
+}
+
+func (f *FakeRuntime) Exec(name string, command []string, options ExecOptions) (int, error) {
+	if state := f.State(name); state != Running {
+		return -1, errors.New("Container is " + state)
+	}
+
+	f.mu.Lock()
+	f.Commands = append(f.Commands, FakeCommand{Container: name, Command: command, Env: options.Env})
+	handler := f.Handler
+	f.mu.Unlock()
+
+	if handler != nil {
+		return handler(name, command, options), nil
+	}
+	return 0, nil
+}
+
+func (f *FakeRuntime) Attach(name string, command []string, env []string) error {
+	_, err := f.Exec(name, command, ExecOptions{Env: env})
+	return err
+}
+
+func (f *FakeRuntime) CgroupItem(name, key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.cgroups[name][key]
+}
+
+func (f *FakeRuntime) SetCgroupItem(name, key, value string) error {
+	if !f.exists(name) {
+		return errors.Errorf("Container %s not found", name)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.cgroups[name] == nil {
+		f.cgroups[name] = make(map[string]string)
+	}
+	f.cgroups[name][key] = value
+	return nil
+}
+
+func (f *FakeRuntime) IPAddresses(name, iface string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.ips[name], nil
+}
+
+// InitPid returns pid of current process for running containers, so that /proc based lookups work
+func (f *FakeRuntime) InitPid(name string) int {
+	if f.State(name) != Running {
+		return -1
+	}
+	return os.Getpid()
+}
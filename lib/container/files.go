@@ -0,0 +1,118 @@
+package container
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+// HostRoot returns directory on host holding file inside container, i.e. rootfs or partition bind mounted into it,
+// and path of file relative to that directory, absolute path inside container is expected. Symlinks inside
+// container are resolved by container, e.g. to files of host otherwise, so file must be accessed relative to
+// returned directory not following them, see OpenFile and fs.OpenInRoot
+func HostRoot(name, file string) (root, rel string, err error) {
+	if !path.IsAbs(file) {
+		return "", "", errors.Errorf("Path %s is not absolute", file)
+	}
+	parts, err := fs.InRoot(file)
+	if err != nil {
+		return "", "", err
+	}
+
+	//partitions other than rootfs are kept in own datasets, bind mounted into rootfs of running container
+	root = path.Join(config.Agent.LxcPrefix, name, "rootfs")
+	for _, partition := range fs.ChildDatasets {
+		if len(parts) > 0 && parts[0] == partition && partition != "rootfs" {
+			root = path.Join(config.Agent.LxcPrefix, name, partition)
+			parts = parts[1:]
+			break
+		}
+	}
+
+	return root, path.Join(parts...), nil
+}
+
+// OpenFile opens file inside container not following symlinks in any component of its path
+func OpenFile(name, file string, flags int, perm os.FileMode) (*os.File, error) {
+	root, rel, err := HostRoot(name, file)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "" {
+		return os.OpenFile(root, flags|syscall.O_NOFOLLOW, perm)
+	}
+	return fs.OpenInRoot(root, rel, flags, perm)
+}
+
+// HostIds returns ids on host of user and group of container, which are shifted by id map of container.
+// Ids are shifted by owner of container rootfs, which belongs to root of container
+func HostIds(name string, uid, gid int) (int, int, error) {
+	info, err := os.Stat(path.Join(config.Agent.LxcPrefix, name, "rootfs"))
+	if err != nil {
+		return -1, -1, err
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+
+	return int(stat.Uid) + uid, int(stat.Gid) + gid, nil
+}
+
+// LookupOwner resolves user and group from account files of container, so container does not have to run.
+// Names and numeric ids are accepted, group defaults to primary group of user
+func LookupOwner(name, user, group string) (uid, gid int, err error) {
+	if user != "" {
+		fields, err := readAccount(name, "/etc/passwd", user)
+		if err != nil {
+			return -1, -1, err
+		}
+		if fields == nil {
+			return -1, -1, errors.Errorf("User %s not found in container %s", user, name)
+		}
+		if uid, err = strconv.Atoi(fields[2]); err != nil {
+			return -1, -1, errors.Errorf("Invalid uid of user %s: %s", user, fields[2])
+		}
+		if gid, err = strconv.Atoi(fields[3]); err != nil {
+			return -1, -1, errors.Errorf("Invalid gid of user %s: %s", user, fields[3])
+		}
+	}
+
+	if group != "" {
+		fields, err := readAccount(name, "/etc/group", group)
+		if err != nil {
+			return -1, -1, err
+		}
+		if fields == nil {
+			return -1, -1, errors.Errorf("Group %s not found in container %s", group, name)
+		}
+		if gid, err = strconv.Atoi(fields[2]); err != nil {
+			return -1, -1, errors.Errorf("Invalid gid of group %s: %s", group, fields[2])
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// readAccount returns fields of entry of account file matching name or numeric id, nil if there is none
+func readAccount(name, file, key string) ([]string, error) {
+	f, err := OpenFile(name, file, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) > 3 && (fields[0] == key || fields[2] == key) {
+			return fields, nil
+		}
+	}
+
+	return nil, scanner.Err()
+}
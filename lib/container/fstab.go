@@ -0,0 +1,54 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/common"
+)
+
+// Mounts managed by agent, e.g. huge pages and tmpfs, are kept in fstab file of container rather than in
+// lxc.mount.entry items, since SetContainerConf can not tell apart several items with the same key
+
+// setFstabEntry replaces entry of fstab of container mounted at target, e.g. dev/hugepages, empty entry
+// removes it. Fstab is referred from config while it has entries
+func setFstabEntry(name, target, entry string) error {
+	fstab := path.Join(config.Agent.LxcPrefix, name, "fstab")
+
+	var lines []string
+	if data, err := ioutil.ReadFile(fstab); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 && fields[1] != target {
+				lines = append(lines, line)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if entry != "" {
+		lines = append(lines, entry)
+	}
+
+	if len(lines) == 0 {
+		if err := os.Remove(fstab); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return SetContainerConf(name, [][]string{{fstabItem(), ""}})
+	}
+
+	if err := ioutil.WriteFile(fstab, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return SetContainerConf(name, [][]string{{fstabItem(), fstab}})
+}
+
+// fstabItem returns config item of fstab file of container
+func fstabItem() string {
+	if common.GetMajorVersion() < 3 {
+		return "lxc.mount"
+	}
+	return "lxc.mount.fstab"
+}
@@ -0,0 +1,148 @@
+package container
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+// markers of /etc/hosts block rewritten by agent, lines outside of it are kept as is
+const (
+	hostsBegin = "# BEGIN subutai managed hosts"
+	hostsEnd   = "# END subutai managed hosts"
+)
+
+var managedHostsRx = regexp.MustCompile(`(?s)\n*` + regexp.QuoteMeta(hostsBegin) + `.*` + regexp.QuoteMeta(hostsEnd) + `\n?`)
+
+// SetHostname changes hostname of container: /etc/hostname, uts name in container config and hosts of containers
+// of its environment are updated, running container gets new hostname right away
+func SetHostname(name, hostname string) error {
+	if !hostnameRx.MatchString(hostname) {
+		return errors.Errorf("Invalid hostname %s, it must start with a letter, end with a letter or digit, "+
+			"contain only letters, digits and hyphens and be 63 characters or less", hostname)
+	}
+	if !IsContainer(name) {
+		return errors.Errorf("Container %s not found", name)
+	}
+
+	uts := "lxc.uts.name"
+	if common.GetMajorVersion() < 3 {
+		uts = "lxc.utsname"
+	}
+	if err := SetContainerConf(name, [][]string{{uts, hostname}}); err != nil {
+		return err
+	}
+	if err := fs.WriteFileInRoot(rootfs(name), "etc/hostname", []byte(hostname), 0644); err != nil {
+		return errors.Wrap(err, "writing /etc/hostname")
+	}
+
+	if State(name) == Running {
+		if _, err := AttachExec(name, []string{"/bin/hostname", hostname}); err != nil {
+			return errors.Wrap(err, "applying hostname")
+		}
+	}
+
+	return updateHosts(name)
+}
+
+// GetHostname returns hostname of container, its name if hostname is not set or is not valid. Hostname is
+// written by root of container, so it is validated before it gets into hosts of other containers
+func GetHostname(name string) string {
+	hostname, err := fs.ReadFileInRoot(rootfs(name), "etc/hostname")
+	if err != nil || !hostnameRx.MatchString(strings.TrimSpace(string(hostname))) {
+		return name
+	}
+	return strings.TrimSpace(string(hostname))
+}
+
+// rootfs returns path of root filesystem of container, files in it are accessed without following symlinks
+// since they are controlled by root of container
+func rootfs(name string) string {
+	return path.Join(config.Agent.LxcPrefix, name, "rootfs")
+}
+
+// UpdateHosts writes /etc/hosts of container resolving its own addresses, management container and other
+// containers of its environment to their hostnames. Entries added by hand outside of block managed by agent
+// are kept
+func UpdateHosts(name string) error {
+	data, err := fs.ReadFileInRoot(rootfs(name), "etc/hosts")
+	if err != nil {
+		return errors.Wrap(err, "reading /etc/hosts")
+	}
+
+	c, err := db.FindContainerByName(name)
+	if err != nil {
+		return err
+	}
+
+	hostname := GetHostname(name)
+	var block []string
+	add := func(ip, host string) {
+		if ip != "" {
+			block = append(block, ip+"\t"+host)
+		}
+	}
+	if c != nil {
+		add(c.Ip, hostname)
+		add(c.Ipv6, hostname)
+	}
+	if name != Management {
+		add(ManagementIp, Management)
+		add(config.Management.ContainerIpv6, Management)
+	}
+	if c != nil && c.EnvironmentId != "" {
+		list, err := db.FindContainers("", "", "")
+		if err != nil {
+			return err
+		}
+		for _, peer := range list {
+			if peer.EnvironmentId == c.EnvironmentId && peer.Name != name {
+				add(peer.Ip, GetHostname(peer.Name))
+				add(peer.Ipv6, GetHostname(peer.Name))
+			}
+		}
+	}
+
+	//loopback alias of own hostname is kept for software resolving hostname without network
+	hosts := managedHostsRx.ReplaceAllString(string(data), "\n")
+	hosts = regexp.MustCompile(`(?m)^127\.0\.1\.1\s.*$`).ReplaceAllString(hosts, "127.0.1.1\t"+hostname)
+	hosts = strings.TrimRight(hosts, "\n") + "\n\n" + hostsBegin + "\n" + strings.Join(block, "\n") + "\n" +
+		hostsEnd + "\n"
+
+	return fs.WriteFileInRoot(rootfs(name), "etc/hosts", []byte(hosts), 0644)
+}
+
+// updateHosts writes /etc/hosts of container, or of all containers of its environment if it belongs to one
+func updateHosts(name string) error {
+	c, err := db.FindContainerByName(name)
+	if err != nil {
+		return err
+	}
+	if c != nil && c.EnvironmentId != "" {
+		return UpdateEnvironmentHosts(c.EnvironmentId)
+	}
+	return UpdateHosts(name)
+}
+
+// UpdateEnvironmentHosts updates /etc/hosts of all containers of environment, e.g. when container joins or
+// leaves it
+func UpdateEnvironmentHosts(environmentId string) error {
+	list, err := db.FindContainers("", "", "")
+	if err != nil {
+		return err
+	}
+	for _, c := range list {
+		if c.EnvironmentId == environmentId && IsContainer(c.Name) {
+			if err = UpdateHosts(c.Name); err != nil {
+				return errors.Wrapf(err, "updating hosts of %s", c.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package container
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// hugepagesItem is config item holding huge pages quota of container in Mb
+const hugepagesItem = "subutai.hugepages"
+
+// nrHugepages is size of huge pages pool of host
+const nrHugepages = "/proc/sys/vm/nr_hugepages"
+
+// QuotaHugepages reserves huge pages for container, e.g. for databases or JVMs, and returns its quota in Mb.
+// Pages are added to pool of host, limited by hugetlb cgroup and exposed to container as hugetlbfs
+// mounted at /dev/hugepages. Quota 0 releases pages of container. Mount appears in running container
+// after restart
+func QuotaHugepages(name string, size string) (int, error) {
+	current, _ := strconv.Atoi(GetProperty(name, hugepagesItem))
+	if size == "" {
+		return current, nil
+	}
+
+	quota, err := strconv.Atoi(size)
+	if err != nil || quota < 0 {
+		return current, errors.Errorf("Invalid huge pages quota %s, Mb expected", size)
+	}
+	pageSize, err := hugepageSize()
+	if err != nil {
+		return current, err
+	}
+	if quota*1024%pageSize != 0 {
+		return current, errors.Errorf("Huge pages quota must be multiple of page size %d Kb", pageSize)
+	}
+
+	rt := GetRuntime()
+	limitItem := "hugetlb." + pageSizeName(pageSize) + ".limit_in_bytes"
+	if quota == 0 {
+		if err = ReleaseHugepages(name); err != nil {
+			return current, err
+		}
+		log.Check(log.DebugLevel, "Setting "+limitItem, rt.SetCgroupItem(name, limitItem, "0"))
+		if err = setFstabEntry(name, "dev/hugepages", ""); err != nil {
+			return 0, err
+		}
+		return 0, SetContainerConf(name, CgroupConf(limitItem, ""))
+	}
+
+	if err = reserveHugepages((quota - current) * 1024 / pageSize); err != nil {
+		return current, err
+	}
+
+	limit := strconv.Itoa(quota * 1024 * 1024)
+	if State(name) == Running {
+		log.Check(log.DebugLevel, "Setting "+limitItem, rt.SetCgroupItem(name, limitItem, limit))
+	}
+
+	//hugetlbfs can not be mounted inside unprivileged container, mount of host is bound instead
+	entry := path.Join(config.Agent.LxcPrefix, name, "hugepages") + " dev/hugepages none bind,create=dir 0 0"
+	if err = setFstabEntry(name, "dev/hugepages", entry); err != nil {
+		return current, err
+	}
+	if err = MountHugepages(name); err != nil {
+		return current, err
+	}
+
+	return quota, SetContainerConf(name, append([][]string{{hugepagesItem, size}}, CgroupConf(limitItem, limit)...))
+}
+
+// MountHugepages mounts hugetlbfs limited by quota of container and owned by its root, if container has the quota.
+// Mount does not survive reboot of host, so it is checked before container starts
+func MountHugepages(name string) error {
+	quota, _ := strconv.Atoi(GetProperty(name, hugepagesItem))
+	if quota <= 0 {
+		return nil
+	}
+
+	dir := path.Join(config.Agent.LxcPrefix, name, "hugepages")
+	if hugepagesMounted(dir) {
+		//remount applies changed quota
+		return exec.Exec("mount", "-o", "remount,size="+strconv.Itoa(quota)+"M", dir)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	uid, gid, err := HostIds(name, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	return exec.Exec("mount", "-t", "hugetlbfs", "-o", "uid="+strconv.Itoa(uid)+",gid="+strconv.Itoa(gid)+
+		",mode=0775,size="+strconv.Itoa(quota)+"M", "hugetlbfs", dir)
+}
+
+// ReleaseHugepages unmounts huge pages of container and returns them to pool of host, e.g. before destroy.
+// Quota of container is dropped once pages are returned, so pages are not returned twice if destroy is retried
+func ReleaseHugepages(name string) error {
+	quota, _ := strconv.Atoi(GetProperty(name, hugepagesItem))
+	if quota <= 0 {
+		return nil
+	}
+
+	//files left in hugetlbfs hold their pages until it is unmounted
+	if err := unmountHugepages(name); err != nil {
+		return errors.Wrap(err, "Unmounting huge pages of "+name)
+	}
+	pageSize, err := hugepageSize()
+	if err != nil {
+		return err
+	}
+	if err = reserveHugepages(-quota * 1024 / pageSize); err != nil {
+		return err
+	}
+
+	return SetContainerConf(name, [][]string{{hugepagesItem, ""}})
+}
+
+func unmountHugepages(name string) error {
+	dir := path.Join(config.Agent.LxcPrefix, name, "hugepages")
+	if !hugepagesMounted(dir) {
+		return nil
+	}
+	if err := syscall.Unmount(dir, 0); err != nil {
+		return err
+	}
+
+	return os.Remove(dir)
+}
+
+// reserveHugepages changes size of huge pages pool of host by count pages, pool is restored if kernel could
+// not allocate all pages, e.g. due to fragmented memory
+func reserveHugepages(count int) error {
+	if count == 0 {
+		return nil
+	}
+
+	//pool is shared by containers, lock name is not a valid container name
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: "_hugepages"})
+	defer lock.Release()
+
+	current, err := readPages()
+	if err != nil {
+		return err
+	}
+	wanted := current + count
+	if wanted < 0 {
+		wanted = 0
+	}
+	if err = ioutil.WriteFile(nrHugepages, []byte(strconv.Itoa(wanted)), 0644); err != nil {
+		return err
+	}
+
+	allocated, err := readPages()
+	if err != nil {
+		return err
+	}
+	if allocated < wanted {
+		log.Check(log.WarnLevel, "Restoring huge pages pool", ioutil.WriteFile(nrHugepages, []byte(strconv.Itoa(current)), 0644))
+		return errors.Errorf("Only %d of %d huge pages could be allocated", allocated-current, count)
+	}
+
+	return nil
+}
+
+func readPages() (int, error) {
+	out, err := ioutil.ReadFile(nrHugepages)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
+// hugepageSize returns default huge page size of host in Kb
+func hugepageSize() (int, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "Hugepagesize:" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, errors.New("Huge pages are not supported by kernel")
+}
+
+// pageSizeName formats page size in Kb the way hugetlb cgroup names its files, e.g. 2MB
+func pageSizeName(size int) string {
+	switch {
+	case size >= 1024*1024:
+		return strconv.Itoa(size/1024/1024) + "GB"
+	case size >= 1024:
+		return strconv.Itoa(size/1024) + "MB"
+	}
+	return strconv.Itoa(size) + "KB"
+}
+
+// hugepagesMounted returns true if hugetlbfs is mounted at dir
+func hugepagesMounted(dir string) bool {
+	var stat syscall.Statfs_t
+	//HUGETLBFS_MAGIC
+	return syscall.Statfs(dir, &stat) == nil && stat.Type == 0x958458f6
+}
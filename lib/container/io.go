@@ -0,0 +1,182 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+// cgroup v1 items throttling block IO per device, in order of IOLimit fields, and keys of cgroup v2 io.max
+// item corresponding to them
+var (
+	ioThrottleItems = []string{"blkio.throttle.read_bps_device", "blkio.throttle.write_bps_device",
+		"blkio.throttle.read_iops_device", "blkio.throttle.write_iops_device"}
+	ioMaxKeys = []string{"rbps", "wbps", "riops", "wiops"}
+)
+
+// IOLimit holds block IO throttling of container, 0 means no limit
+type IOLimit struct {
+	ReadBps   int64
+	WriteBps  int64
+	ReadIops  int64
+	WriteIops int64
+}
+
+func (l IOLimit) values() []int64 {
+	return []int64{l.ReadBps, l.WriteBps, l.ReadIops, l.WriteIops}
+}
+
+// QuotaIO throttles block IO of container on devices of pool holding container datasets. Rates are in bytes and
+// operations per second, 0 removes limit. Limits are saved to config of container and applied to running container
+// right away.
+// Limits are largely ineffective: cgroups throttle only IO submitted by processes of container, while ZFS submits
+// nearly all disk IO from its own zio and txg threads. Writes are buffered and flushed by transaction groups,
+// reads served from ARC do not reach disks and reads that miss it are issued by zio threads too, so neither is
+// attributed to container. Only direct IO to devices passed to container is throttled reliably. ZFS has no
+// per-dataset IO limits, so IO of containers can not be isolated on this host
+func QuotaIO(name string, readBps, writeBps, readIops, writeIops int64) error {
+	limit := IOLimit{ReadBps: readBps, WriteBps: writeBps, ReadIops: readIops, WriteIops: writeIops}
+	for _, v := range limit.values() {
+		if v < 0 {
+			return errors.Errorf("Invalid io limit %d, non-negative value expected", v)
+		}
+	}
+	if !LxcInstanceExists(name) {
+		return errors.Errorf("Container %s not found", name)
+	}
+
+	devices, err := poolDevices()
+	if err != nil {
+		return err
+	}
+
+	if State(name) == Running {
+		rt := GetRuntime()
+		for _, device := range devices {
+			for i, v := range limit.values() {
+				if err = rt.SetCgroupItem(name, ioThrottleItems[i], device+" "+strconv.FormatInt(v, 10)); err != nil {
+					return errors.Wrapf(err, "setting %s of %s", ioThrottleItems[i], device)
+				}
+			}
+		}
+	}
+
+	if UnifiedCgroup() {
+		var lines []string
+		if limit != (IOLimit{}) {
+			for _, device := range devices {
+				line := device
+				for i, v := range limit.values() {
+					line += " " + ioMaxKeys[i] + "=" + ioMaxValue(v)
+				}
+				lines = append(lines, line)
+			}
+		}
+		for _, item := range ioThrottleItems {
+			if err = SetContainerConfList(name, "lxc.cgroup."+item, nil); err != nil {
+				return err
+			}
+		}
+		return SetContainerConfList(name, "lxc.cgroup2.io.max", lines)
+	}
+
+	for i, v := range limit.values() {
+		var lines []string
+		if v > 0 {
+			for _, device := range devices {
+				lines = append(lines, device+" "+strconv.FormatInt(v, 10))
+			}
+		}
+		if err = SetContainerConfList(name, "lxc.cgroup."+ioThrottleItems[i], lines); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetIOLimit returns block IO throttling of container as saved in its config, all devices are throttled alike
+func GetIOLimit(name string) (limit IOLimit, err error) {
+	if !LxcInstanceExists(name) {
+		return limit, errors.Errorf("Container %s not found", name)
+	}
+
+	values := make([]int64, len(ioThrottleItems))
+	for i, item := range ioThrottleItems {
+		//value is "major:minor rate"
+		fields := strings.Fields(CgroupProperty(name, item))
+		if len(fields) != 2 {
+			continue
+		}
+		if values[i], err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return limit, errors.Wrapf(err, "parsing %s", item)
+		}
+	}
+
+	return IOLimit{ReadBps: values[0], WriteBps: values[1], ReadIops: values[2], WriteIops: values[3]}, nil
+}
+
+func ioMaxValue(v int64) string {
+	if v <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// poolDevices returns major:minor numbers of disks holding pool, partitions are replaced with their disks
+// since IO is throttled per disk
+func poolDevices() ([]string, error) {
+	paths, err := fs.PoolDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+	for _, p := range paths {
+		device, err := diskNumber(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving disk of %s", p)
+		}
+		//partitions of the same disk resolve to one device
+		known := false
+		for _, d := range devices {
+			known = known || d == device
+		}
+		if !known {
+			devices = append(devices, device)
+		}
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("No block devices found in pool")
+	}
+
+	return devices, nil
+}
+
+// diskNumber returns major:minor number of disk holding device, e.g. 8:16 for /dev/sdb1
+func diskNumber(device string) (string, error) {
+	device, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return "", err
+	}
+
+	sysPath, err := filepath.EvalSymlinks(path.Join("/sys/class/block", filepath.Base(device)))
+	if err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(path.Join(sysPath, "partition")); err == nil {
+		sysPath = filepath.Dir(sysPath)
+	}
+
+	number, err := ioutil.ReadFile(path.Join(sysPath, "dev"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(number)), nil
+}
@@ -0,0 +1,119 @@
+package container
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/exec"
+)
+
+// JournalEntry is a log record read from journal of container
+type JournalEntry struct {
+	Time       time.Time `json:"time"`
+	Priority   int       `json:"priority"`
+	Identifier string    `json:"identifier,omitempty"`
+	Unit       string    `json:"unit,omitempty"`
+	Message    string    `json:"message"`
+}
+
+// journalDirs returns journal directories of container: persistent one on var partition
+// and volatile one which is reachable through init process while container is running
+func journalDirs(name string) []string {
+	var dirs []string
+
+	persistent := path.Join(config.Agent.LxcPrefix, name, "var", "log", "journal")
+	if _, err := os.Stat(persistent); err == nil {
+		dirs = append(dirs, persistent)
+	}
+
+	if pid := GetRuntime().InitPid(name); pid > 0 {
+		volatile := path.Join("/proc", strconv.Itoa(pid), "root", "run", "log", "journal")
+		if _, err := os.Stat(volatile); err == nil {
+			dirs = append(dirs, volatile)
+		}
+	}
+
+	return dirs
+}
+
+// ReadJournal returns up to lines latest entries of container journal, or up to lines earliest entries written
+// after since if it is not zero, so reader may continue from the last entry returned. Entries are ordered by
+// time. Containers not running journald have no entries
+func ReadJournal(name string, lines int, since time.Time) ([]JournalEntry, error) {
+	var entries []JournalEntry
+
+	for _, dir := range journalDirs(name) {
+		args := []string{"-D", dir, "-o", "json", "--no-pager"}
+		if since.IsZero() {
+			args = append(args, "-n", strconv.Itoa(lines))
+		} else {
+			//journalctl accepts seconds only, entries of the same second are filtered below before limit is applied
+			args = append(args, "--since", since.Local().Format("2006-01-02 15:04:05"))
+		}
+
+		out, err := exec.ExecuteNoLog("journalctl", args...)
+		if err != nil {
+			return nil, errors.Errorf("Error reading journal of %s: %s", name, out)
+		}
+
+		scanner := bufio.NewScanner(strings.NewReader(out))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			entry, ok := parseJournalEntry(scanner.Bytes())
+			if ok && entry.Time.After(since) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	if len(entries) > lines && since.IsZero() {
+		entries = entries[len(entries)-lines:]
+	} else if len(entries) > lines {
+		entries = entries[:lines]
+	}
+
+	return entries, nil
+}
+
+// parseJournalEntry converts record of journalctl JSON output, fields are strings there
+func parseJournalEntry(line []byte) (JournalEntry, bool) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return JournalEntry{}, false
+	}
+
+	field := func(key string) string {
+		if value, ok := fields[key].(string); ok {
+			return value
+		}
+		return ""
+	}
+
+	usec, err := strconv.ParseInt(field("__REALTIME_TIMESTAMP"), 10, 64)
+	if err != nil {
+		return JournalEntry{}, false
+	}
+
+	entry := JournalEntry{
+		Time:       time.Unix(0, usec*int64(time.Microsecond)),
+		Priority:   6,
+		Identifier: field("SYSLOG_IDENTIFIER"),
+		Unit:       field("_SYSTEMD_UNIT"),
+		//binary messages are exported as byte arrays and are skipped
+		Message: field("MESSAGE"),
+	}
+	if priority, err := strconv.Atoi(field("PRIORITY")); err == nil {
+		entry.Priority = priority
+	}
+
+	return entry, true
+}
@@ -0,0 +1,171 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	exec2 "github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+// kvmRuntime manages KVM virtual machines through libvirt virsh client.
+// It implements only a subset of Runtime: VMs have no cgroup limits managed by agent
+// and commands can not be executed inside guests
+type kvmRuntime struct{}
+
+var kvmDriver Runtime = kvmRuntime{}
+
+// VmRuntime returns runtime managing KVM virtual machines, they coexist with containers of the configured runtime
+func VmRuntime() Runtime {
+	return kvmDriver
+}
+
+func virsh(args ...string) (string, error) {
+	out, err := exec2.Execute("virsh", args...)
+	if err != nil {
+		return "", errors.Errorf("Error executing virsh %s: %s %s", args[0], out, err.Error())
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+func (kvmRuntime) Names() []string {
+	out, err := virsh("list", "--all", "--name")
+	if log.Check(log.DebugLevel, "Listing virtual machines", err) {
+		return nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(out, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsTemplate tells VMs are not templates, they are created by importing images, see CreateVM
+func (kvmRuntime) IsTemplate(name string) bool {
+	return false
+}
+
+// IsContainer tells VMs are not containers
+func (kvmRuntime) IsContainer(name string) bool {
+	return false
+}
+
+func (kvmRuntime) Clone(parent templ.Ref, child, label string) error {
+	return errors.New("Cloning VMs from templates is not supported")
+}
+
+func (kvmRuntime) State(name string) string {
+	out, err := virsh("domstate", name)
+	if log.Check(log.DebugLevel, "Getting state of VM "+name, err) {
+		return Unknown
+	}
+
+	switch out {
+	case "running":
+		return Running
+	case "shut off":
+		return Stopped
+	}
+	return Unknown
+}
+
+func (kvmRuntime) Start(name string) error {
+	_, err := virsh("start", name)
+	return err
+}
+
+func (kvmRuntime) Stop(name string) error {
+	_, err := virsh("destroy", name)
+	return err
+}
+
+// Shutdown sends ACPI power button event, guest OS must handle it to stop
+func (k kvmRuntime) Shutdown(name string, timeout time.Duration) error {
+	if _, err := virsh("shutdown", name); err != nil {
+		return err
+	}
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(time.Second) {
+		if k.State(name) == Stopped {
+			return nil
+		}
+	}
+
+	return errors.Errorf("VM %s did not shut down in %s", name, timeout)
+}
+
+func (kvmRuntime) Exec(name string, command []string, options ExecOptions) (int, error) {
+	return -1, errors.New("Executing commands inside VM is not supported")
+}
+
+// Attach connects to serial console of VM, commands are not supported
+func (kvmRuntime) Attach(name string, command []string, env []string) error {
+	if len(command) > 0 {
+		return errors.New("Executing commands inside VM is not supported")
+	}
+
+	cmd := exec.Command("virsh", "console", name)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+func (kvmRuntime) CgroupItem(name, key string) string {
+	return ""
+}
+
+func (kvmRuntime) SetCgroupItem(name, key, value string) error {
+	return errors.New("Cgroup limits are not supported for VMs")
+}
+
+// IPAddresses returns IPv4 addresses of VM interfaces, iface is ignored since guest interface names are OS specific.
+// Addresses are taken from DHCP leases of libvirt network falling back to host ARP table
+func (kvmRuntime) IPAddresses(name, iface string) ([]string, error) {
+	var ips []string
+	var err error
+
+	for _, source := range []string{"lease", "arp"} {
+		var out string
+		out, err = virsh("domifaddr", name, "--source", source)
+		if err != nil {
+			continue
+		}
+
+		//skip header
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[2] == "ipv4" {
+				ips = append(ips, strings.Split(fields[3], "/")[0])
+			}
+		}
+		if len(ips) > 0 {
+			return ips, nil
+		}
+	}
+
+	return ips, err
+}
+
+// InitPid returns pid of qemu process running VM
+func (kvmRuntime) InitPid(name string) int {
+	data, err := ioutil.ReadFile(path.Join("/var/run/libvirt/qemu", name+".pid"))
+	if err != nil {
+		return -1
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1
+	}
+	return pid
+}
@@ -0,0 +1,179 @@
+package container
+
+import (
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	prlimitPrefix = "lxc.prlimit."
+	sysctlPrefix  = "lxc.sysctl."
+	//host sysctls are raised on host for container, since they are not isolated by namespaces
+	hostSysctlPrefix = "subutai.sysctl."
+)
+
+// sysctlScript writes sysctl of running container, key is path under /proc/sys
+const sysctlScript = `echo "$2" > "/proc/sys/$1"`
+
+var (
+	prlimits = map[string]bool{"as": true, "core": true, "cpu": true, "data": true, "fsize": true, "locks": true,
+		"memlock": true, "msgqueue": true, "nice": true, "nofile": true, "nproc": true, "rss": true, "rtprio": true,
+		"rttime": true, "sigpending": true, "stack": true}
+	//sysctls isolated by network and ipc namespaces
+	namespacedSysctls = []string{"net.", "kernel.shm", "kernel.msg", "kernel.sem", "fs.mqueue."}
+	hostSysctls       = map[string]bool{"vm.max_map_count": true}
+	sysctlRx          = regexp.MustCompile(`^[a-z0-9_]+(\.[a-zA-Z0-9_-]+)+$`)
+)
+
+// Limits returns prlimits and sysctls of container by their names, e.g. nofile or net.core.somaxconn
+func Limits(name string) map[string]string {
+	limits := make(map[string]string)
+	for key, value := range configItems(path.Join(config.Agent.LxcPrefix, name, "config")) {
+		for _, prefix := range []string{prlimitPrefix, sysctlPrefix, hostSysctlPrefix} {
+			if strings.HasPrefix(key, prefix) {
+				limits[strings.TrimPrefix(key, prefix)] = value
+			}
+		}
+	}
+	return limits
+}
+
+// SetLimits sets prlimits, e.g. nofile=65536 or nofile=1024:65536, and sysctls, e.g. net.core.somaxconn=1024,
+// of container. Sysctls are written to running container at once, prlimits apply after restart.
+// Sysctls which are not namespaced, e.g. vm.max_map_count, are raised on host to at least the value
+func SetLimits(name string, limits map[string]string) error {
+	var conf [][]string
+	for key, value := range limits {
+		item, err := limitItem(key, value)
+		if err != nil {
+			return err
+		}
+		conf = append(conf, []string{item, value})
+	}
+	if err := SetContainerConf(name, conf); err != nil {
+		return err
+	}
+
+	running := State(name) == Running
+	for key, value := range limits {
+		switch {
+		case hostSysctls[key]:
+			if err := raiseHostSysctl(key, value); err != nil {
+				return err
+			}
+		case running && !prlimits[key]:
+			_, err := GetRuntime().Exec(name, []string{"/bin/sh", "-c", sysctlScript, "sh",
+				strings.Replace(key, ".", "/", -1), value}, ExecOptions{})
+			log.Check(log.WarnLevel, "Setting "+key+" of running container, it applies after restart", err)
+		}
+	}
+
+	return nil
+}
+
+// RemoveLimits removes prlimits and sysctls of container, defaults apply after restart
+func RemoveLimits(name string, keys []string) error {
+	var conf [][]string
+	for _, key := range keys {
+		for _, prefix := range []string{prlimitPrefix, sysctlPrefix, hostSysctlPrefix} {
+			conf = append(conf, []string{prefix + key, ""})
+		}
+	}
+	return SetContainerConf(name, conf)
+}
+
+// ApplyHostSysctls raises sysctls of host required by container, e.g. before it starts after reboot of host
+func ApplyHostSysctls(name string) error {
+	for key, value := range Limits(name) {
+		if hostSysctls[key] {
+			if err := raiseHostSysctl(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// limitItem validates limit and returns config item it is stored in
+func limitItem(key, value string) (string, error) {
+	if prlimits[key] {
+		return prlimitPrefix + key, validatePrlimit(key, value)
+	}
+
+	if !sysctlRx.MatchString(key) {
+		return "", errors.Errorf("Unknown limit %s, prlimit or sysctl expected", key)
+	}
+	if value == "" || strings.ContainsAny(value, "\n\"") {
+		return "", errors.Errorf("Invalid value of sysctl %s", key)
+	}
+	if hostSysctls[key] {
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return "", errors.Errorf("Invalid value %s of sysctl %s, number expected", value, key)
+		}
+		return hostSysctlPrefix + key, nil
+	}
+	for _, prefix := range namespacedSysctls {
+		if strings.HasPrefix(key, prefix) {
+			if common.GetMajorVersion() < 3 {
+				return "", errors.Errorf("Sysctls of containers require LXC 3 or later")
+			}
+			return sysctlPrefix + key, nil
+		}
+	}
+
+	return "", errors.Errorf("Sysctl %s is not namespaced, it may be set on host only", key)
+}
+
+// validatePrlimit checks value of prlimit, either single limit or soft:hard pair of numbers or unlimited
+func validatePrlimit(key, value string) error {
+	bounds := strings.Split(value, ":")
+	if len(bounds) > 2 {
+		return errors.Errorf("Invalid value %s of %s, use limit or soft:hard", value, key)
+	}
+
+	var numbers []uint64
+	for _, bound := range bounds {
+		if bound == "unlimited" {
+			numbers = append(numbers, ^uint64(0))
+			continue
+		}
+		number, err := strconv.ParseUint(bound, 10, 64)
+		if err != nil {
+			return errors.Errorf("Invalid value %s of %s, number or unlimited expected", value, key)
+		}
+		numbers = append(numbers, number)
+	}
+	if len(numbers) == 2 && numbers[0] > numbers[1] {
+		return errors.Errorf("Soft limit of %s exceeds hard limit", key)
+	}
+
+	return nil
+}
+
+// raiseHostSysctl sets sysctl of host to value unless it is already higher, e.g. for another container
+func raiseHostSysctl(key, value string) error {
+	file := path.Join("/proc/sys", strings.Replace(key, ".", "/", -1))
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	wanted, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	if current, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64); err == nil && current >= wanted {
+		return nil
+	}
+
+	log.Info("Raising " + key + " of host to " + value)
+	return ioutil.WriteFile(file, []byte(value), 0644)
+}
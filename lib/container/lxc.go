@@ -1,14 +1,16 @@
-//Package container main function is to provide control interface for Subutai containers through go-lxc bindings and system-level libraries and executables
+//Package container main function is to provide control interface for Subutai containers through container runtime (liblxc or LXD) and system-level libraries and executables
 package container
 
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
+	"github.com/subutai-io/agent/lib/exec"
 	"io/ioutil"
 	"os"
-	"os/exec"
-	"runtime"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -17,15 +19,15 @@ import (
 	"github.com/subutai-io/agent/db"
 	"github.com/subutai-io/agent/lib/fs"
 	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/lib/templ"
 	"github.com/subutai-io/agent/log"
 
 	"crypto/rand"
 	"fmt"
-	"github.com/nightlyone/lockfile"
 	"github.com/subutai-io/agent/lib/common"
-	"gopkg.in/lxc/go-lxc.v2"
 	"hash/crc32"
 	"io"
+	"math"
 	"path"
 	"time"
 )
@@ -36,23 +38,40 @@ const (
 	Unknown = "UNKNOWN"
 )
 
-const Management = "management"
-const ManagementIp = "10.10.10.1"
+// name of management template on CDN
+const ManagementTemplate = "management"
+
+var (
+	Management   = config.Management.ContainerName
+	ManagementIp = config.Management.ContainerIp
+)
 const ContainerDefaultIface = "eth0"
 
+// Ipv6Prefix is prefix length of IPv6 networks of containers
+const Ipv6Prefix = "64"
+
 var crc32Table = crc32.MakeTable(0xD5828281)
 
 // All returns list of all containers
 func All() []string {
-	return lxc.DefinedContainerNames(config.Agent.LxcPrefix)
+	return GetRuntime().Names()
 }
 
 // IsTemplate checks if Subutai container is template.
 func IsTemplate(name string) bool {
-	return fs.DatasetExists(name+"/rootfs") && fs.IsDatasetReadOnly(name+"/rootfs/")
+	return GetRuntime().IsTemplate(name)
 }
 
 func IsContainer(name string) bool {
+	return GetRuntime().IsContainer(name)
+}
+
+// isTemplateDataset checks if instance stored in datasets of agent is template, its rootfs is read-only
+func isTemplateDataset(name string) bool {
+	return fs.DatasetExists(name+"/rootfs") && fs.IsDatasetReadOnly(name+"/rootfs/")
+}
+
+func isContainerDataset(name string) bool {
 	return fs.DatasetExists(name+"/rootfs") && !fs.IsDatasetReadOnly(name+"/rootfs/")
 }
 
@@ -88,28 +107,19 @@ func LxcInstanceExists(name string) bool {
 
 // State returns container state in human readable format.
 func State(name string) (state string) {
-	if c, err := lxc.NewContainer(name, config.Agent.LxcPrefix); err == nil {
-		defer lxc.Release(c)
-		return c.State().String()
-	}
-	return Unknown
+	return GetRuntime().State(name)
 }
 
 // Start starts the Subutai container.
 func Start(name string) error {
+	log.Check(log.WarnLevel, "Mounting huge pages of "+name, MountHugepages(name))
+	log.Check(log.WarnLevel, "Raising sysctls of host for "+name, ApplyHostSysctls(name))
 
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-
-	if log.Check(log.DebugLevel, "Creating container object", err) {
+	if err := GetRuntime().Start(name); err != nil {
 		return err
 	}
-	defer lxc.Release(c)
 
-	log.Check(log.DebugLevel, "Starting LXC container "+name, c.Start())
-
-	if c.State().String() != Running {
-		return errors.New("Unable to start container " + name)
-	}
+	log.Check(log.WarnLevel, "Registering systemd scope of "+name, RegisterUnit(name))
 
 	SetContainerConf(name, [][]string{
 		{"lxc.start.auto", "1"}})
@@ -125,18 +135,9 @@ func Start(name string) error {
 
 // Stop stops the Subutai container.
 func Stop(name string) error {
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-
-	if log.Check(log.DebugLevel, "Creating container object", err) {
+	if err := GetRuntime().Stop(name); err != nil {
 		return err
 	}
-	defer lxc.Release(c)
-
-	log.Check(log.DebugLevel, "Stopping LXC container "+name, c.Stop())
-
-	if c.State().String() != Stopped {
-		return errors.New("Unable to stop container " + name)
-	}
 
 	SetContainerConf(name, [][]string{
 		{"lxc.start.auto", ""}})
@@ -151,23 +152,21 @@ func Stop(name string) error {
 }
 
 func Restart(name string) error {
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	rt := GetRuntime()
 
-	if log.Check(log.DebugLevel, "Creating container object", err) {
-		return err
-	}
-	defer lxc.Release(c)
-
-	if c.State().String() == Running {
-		log.Check(log.DebugLevel, "Stopping LXC container "+name, c.Stop())
+	if rt.State(name) == Running {
+		log.Check(log.DebugLevel, "Stopping container "+name, rt.Stop(name))
 	}
 
-	log.Check(log.DebugLevel, "Starting LXC container "+name, c.Start())
+	log.Check(log.WarnLevel, "Mounting huge pages of "+name, MountHugepages(name))
+	log.Check(log.WarnLevel, "Raising sysctls of host for "+name, ApplyHostSysctls(name))
 
-	if c.State().String() != Running {
-		return errors.New("Unable to start container " + name)
+	if err := rt.Start(name); err != nil {
+		return err
 	}
 
+	log.Check(log.WarnLevel, "Registering systemd scope of "+name, RegisterUnit(name))
+
 	SetContainerConf(name, [][]string{
 		{"lxc.start.auto", "1"}})
 
@@ -182,47 +181,34 @@ func Restart(name string) error {
 
 // AttachExec executes a command inside Subutai container.
 func AttachExec(name string, command []string, env ...[]string) (output []string, err error) {
+	return AttachExecCtx(context.Background(), name, command, env...)
+}
+
+// AttachExecCtx executes a command inside Subutai container, the command is killed together with processes it
+// spawned if ctx expires or is cancelled
+func AttachExecCtx(ctx context.Context, name string, command []string, env ...[]string) (output []string, err error) {
 	if !LxcInstanceExists(name) {
 		return output, errors.New("Container does not exist")
 	}
 
-	container, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err == nil {
-		defer lxc.Release(container)
-	}
-
-	if container.State() != lxc.RUNNING || err != nil {
-		return output, errors.New("Container is " + container.State().String())
-	}
-
-	bufR, bufW, err := os.Pipe()
-	if err != nil {
-		return output, errors.New("Failed to create OS pipe")
-	}
-	bufRErr, bufWErr, err := os.Pipe()
-	if err != nil {
-		return output, errors.New("Failed to create OS pipe")
+	rt := GetRuntime()
+	if state := rt.State(name); state != Running {
+		return output, errors.New("Container is " + state)
 	}
 
-	options := lxc.AttachOptions{
-		Namespaces: -1,
-		UID:        0,
-		GID:        0,
-		StdoutFd:   bufW.Fd(),
-		StderrFd:   bufWErr.Fd(),
-	}
+	var stdout bytes.Buffer
+	options := ExecOptions{Stdout: &stdout}
 	if len(env) > 0 {
 		options.Env = env[0]
 	}
 
-	_, err = container.RunCommand(command, options)
+	_, err = execCtx(ctx, name, command, options)
 	log.Check(log.DebugLevel, "Executing command inside container", err)
-	log.Check(log.DebugLevel, "Closing write buffer for stdout", bufW.Close())
-	defer bufR.Close()
-	log.Check(log.DebugLevel, "Closing write buffer for stderr", bufWErr.Close())
-	defer bufRErr.Close()
+	if ctx.Err() != nil {
+		return output, err
+	}
 
-	out := bufio.NewScanner(bufR)
+	out := bufio.NewScanner(&stdout)
 	for out.Scan() {
 		output = append(output, out.Text())
 	}
@@ -254,97 +240,204 @@ func GetErrResult(err error, exitCode int) ErrResult {
 
 // AttachExec executes a command inside Subutai container.
 func AttachExecOutput(name string, command []string, env ...[]string) (output string, errOutput string, errResult ErrResult) {
+	return AttachExecOutputCtx(context.Background(), name, command, env...)
+}
+
+// AttachExecOutputCtx executes a command inside Subutai container, the command is killed together with processes
+// it spawned if ctx expires or is cancelled
+func AttachExecOutputCtx(ctx context.Context, name string, command []string, env ...[]string) (output string, errOutput string, errResult ErrResult) {
 	if !LxcInstanceExists(name) {
 		return "", "", GetErrResult(errors.New("Container does not exist"), -1)
 	}
 
-	container, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err == nil {
-		defer lxc.Release(container)
-	}
-
-	if container.State() != lxc.RUNNING || err != nil {
-		return "", "", GetErrResult(errors.New("Container is "+container.State().String()), -1)
-	}
-
-	bufR, bufW, err := os.Pipe()
-	if err != nil {
-		return "", "", GetErrResult(errors.New("Failed to create OS pipe"), -1)
-	}
-	defer bufR.Close()
-	defer bufW.Close()
-
-	bufRErr, bufWErr, err := os.Pipe()
-	if err != nil {
-		return "", "", GetErrResult(errors.New("Failed to create OS pipe"), -1)
+	rt := GetRuntime()
+	if state := rt.State(name); state != Running {
+		return "", "", GetErrResult(errors.New("Container is "+state), -1)
 	}
-	defer bufRErr.Close()
-	defer bufWErr.Close()
 
-	options := lxc.AttachOptions{
-		Namespaces: -1,
-		UID:        0,
-		GID:        0,
-		StdoutFd:   bufW.Fd(),
-		StderrFd:   bufWErr.Fd(),
+	var stdoutBuf, stderrBuf bytes.Buffer
+	options := ExecOptions{
+		Stdout: io.MultiWriter(os.Stdout, &stdoutBuf),
+		Stderr: io.MultiWriter(os.Stderr, &stderrBuf),
 	}
 	if len(env) > 0 {
 		options.Env = env[0]
 	}
 
-	pid, err := container.RunCommandNoWait(command, options)
+	exitCode, err := execCtx(ctx, name, command, options)
 	log.Check(log.DebugLevel, "Executing command inside container", err)
 	if err != nil {
 		return "", "",
 			GetErrResult(errors.New(fmt.Sprintf("Failed to execute command inside container: %s", err.Error())), -1)
 	}
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	stdout := io.MultiWriter(os.Stdout, &stdoutBuf)
-	stderr := io.MultiWriter(os.Stderr, &stderrBuf)
-	go func() {
-		io.Copy(stdout, bufR)
-	}()
+	if exitCode != 0 {
+		log.ErrorNoExit("Command failed")
+		return string(stdoutBuf.Bytes()), string(stderrBuf.Bytes()), GetErrResult(nil, exitCode)
+	}
+
+	return string(stdoutBuf.Bytes()), string(stderrBuf.Bytes()), GetErrResult(nil, 0)
+}
+
+// execMarker is environment variable commands run by execCtx are marked with, so that the command and processes it
+// spawns can be found on host and killed
+const execMarker = "SUBUTAI_EXEC"
+
+// execKillAttempts bounds attempts to kill cancelled command, commands surviving them are left running
+const execKillAttempts = 12
+
+// execCtx runs command inside container, the command is killed once ctx is done. Runtimes can not kill
+// attached process, so processes of container marked as spawned by the command are killed from host
+func execCtx(ctx context.Context, name string, command []string, options ExecOptions) (int, error) {
+	rt := GetRuntime()
+	if ctx.Done() == nil {
+		return rt.Exec(name, command, options)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return -1, err
+	}
+	marker := fmt.Sprintf("%s=%x", execMarker, id)
+	options.Env = append(append([]string{}, options.Env...), marker)
+
+	type result struct {
+		exitCode int
+		err      error
+	}
+	done := make(chan result, 1)
 	go func() {
-		io.Copy(stderr, bufRErr)
+		exitCode, err := rt.Exec(name, command, options)
+		done <- result{exitCode, err}
 	}()
 
-	proc, err := os.FindProcess(pid)
-	log.Check(log.DebugLevel, "Looking process by pid "+strconv.Itoa(pid), err)
+	select {
+	case r := <-done:
+		return r.exitCode, r.err
+	case <-ctx.Done():
+	}
+
+	//kill is repeated in case command was cancelled before it started or spawned processes meanwhile
+	for attempt := 0; attempt < execKillAttempts; attempt++ {
+		log.Check(log.WarnLevel, "Killing command inside container "+name, killMarked(name, marker))
+		select {
+		case <-done:
+			return -1, ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	return -1, errors.New(fmt.Sprintf("Command inside container %s is cancelled but could not be killed", name))
+}
+
+// killMarked kills processes of container carrying marker in their environment along with their descendants.
+// Processes are looked up in /proc of host, only processes in pid namespace of container are killed
+func killMarked(name, marker string) error {
+	initPid := GetRuntime().InitPid(name)
+	if initPid <= 0 {
+		return errors.New("Container is not running")
+	}
+	ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", initPid))
 	if err != nil {
-		return "", "",
-			GetErrResult(errors.New(fmt.Sprintf("Failed to find process by pid: %s", err.Error())), -1)
+		return err
+	}
+
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == initPid {
+			continue
+		}
+		if link, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid)); err != nil || link != ns {
+			continue
+		}
+		environ, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+		if err != nil {
+			continue
+		}
+		for _, variable := range bytes.Split(environ, []byte{0}) {
+			if string(variable) == marker {
+				killTree(pid)
+				break
+			}
+		}
 	}
 
-	procState, err := proc.Wait()
-	log.Check(log.ErrorLevel, "Waiting for process completion", err)
+	return nil
+}
 
-	if !procState.Success() {
-		log.ErrorNoExit("Command failed")
-		exitCode := 1
-		if status, ok := procState.Sys().(syscall.WaitStatus); ok {
-			exitCode = status.ExitStatus()
+// killTree kills process and all its descendants, process is stopped first so it does not spawn anything new
+func killTree(pid int) {
+	syscall.Kill(pid, syscall.SIGSTOP)
+	tasks, _ := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	for _, task := range tasks {
+		children, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%s/children", pid, task.Name()))
+		for _, child := range strings.Fields(string(children)) {
+			if childPid, err := strconv.Atoi(child); err == nil {
+				killTree(childPid)
+			}
 		}
-		return string(stdoutBuf.Bytes()), string(stderrBuf.Bytes()), GetErrResult(nil, exitCode)
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+}
+
+// AttachExecStream executes a command inside Subutai container streaming its output to stdout and stderr as it
+// is produced and feeding stdin to it, e.g. for long running upgrades or dumps. Command is killed inside
+// container if it runs longer than positive timeout. Input is not supported by LXD runtime
+func AttachExecStream(name string, command []string, stdin io.Reader, stdout, stderr io.Writer,
+	timeout time.Duration, env ...[]string) (exitCode int, err error) {
+	if !LxcInstanceExists(name) {
+		return -1, errors.New("Container does not exist")
 	}
 
-	return string(stdoutBuf.Bytes()), string(stderrBuf.Bytes()), GetErrResult(nil, 0)
+	rt := GetRuntime()
+	if state := rt.State(name); state != Running {
+		return -1, errors.New("Container is " + state)
+	}
+
+	options := ExecOptions{Stdin: stdin, Stdout: stdout, Stderr: stderr}
+	if len(env) > 0 {
+		options.Env = env[0]
+	}
+
+	//runtimes can not kill attached process, coreutils timeout of container does it
+	if timeout > 0 {
+		seconds := strconv.Itoa(int(math.Ceil(timeout.Seconds())))
+		command = append([]string{"timeout", "-k", "10", seconds}, command...)
+	}
+
+	exitCode, err = rt.Exec(name, command, options)
+	if err != nil {
+		return exitCode, errors.New(fmt.Sprintf("Failed to execute command inside container: %s", err.Error()))
+	}
+	//timeout exits with 124 on expiry, or with 137 if command had to be killed
+	if timeout > 0 && (exitCode == 124 || exitCode == 137) {
+		return exitCode, errors.New("Command timed out after " + timeout.String())
+	}
+
+	return exitCode, nil
 }
 
 // Destroy deletes the Subutai container.
 func DestroyContainer(name string) error {
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: name})
+	defer lock.Release()
 
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-
-	if log.Check(log.WarnLevel, "Creating container object", err) {
+	if err := checkLocks(name); err != nil {
 		return err
 	}
 
-	defer lxc.Release(c)
+	log.Check(log.DebugLevel, "Shutting down container", GetRuntime().Shutdown(name, time.Second*120))
 
-	log.Check(log.DebugLevel, "Shutting down lxc", c.Shutdown(time.Second*120))
+	//mounted hugetlbfs keeps dataset of container busy, pages would never return to pool of host after destroy
+	if err := ReleaseHugepages(name); log.Check(log.WarnLevel, "Releasing huge pages", err) {
+		return err
+	}
 
-	err = Destroy(name, false)
+	err := Destroy(name, false)
 	for i := 1; err != nil && i < 3; i++ {
 		time.Sleep(time.Second * time.Duration(i*5))
 		err = Destroy(name, false)
@@ -354,38 +447,67 @@ func DestroyContainer(name string) error {
 		return err
 	}
 
+	UnregisterUnit(name)
+
 	cont, _ := db.FindContainerByName(name)
 	if cont != nil {
 		log.Check(log.WarnLevel, "Deleting container metadata entry", db.RemoveContainer(cont))
 	}
+	log.Check(log.WarnLevel, "Deleting package inventory", db.RemovePackageInventory(name))
+	log.Check(log.WarnLevel, "Deleting scan results", db.RemoveScanResults(name))
+	if policy, _ := db.FindUpgradePolicy(name); policy != nil {
+		log.Check(log.WarnLevel, "Deleting upgrade policy", db.RemoveUpgradePolicy(policy))
+	}
+	log.Check(log.WarnLevel, "Deleting snapshot metadata", db.RemoveSnapshotMetas(func(snapshot string) bool {
+		return strings.HasPrefix(snapshot, name+"@") || strings.HasPrefix(snapshot, name+"/")
+	}))
 
 	return nil
 }
 
 func DestroyTemplate(name string) error {
+	lock := common.AcquireLocks(common.LockKey{Kind: common.TemplateLock, Name: name})
+	defer lock.Release()
+
 	if !IsTemplate(name) {
 		return errors.New("Template " + name + " not found")
 	}
+	if err := checkLocks(name); err != nil {
+		return err
+	}
 
 	err := Destroy(name, false)
 
 	if log.Check(log.WarnLevel, "Removing template", err) {
 		return err
 	}
+	log.Check(log.WarnLevel, "Deleting package inventory", db.RemovePackageInventory(name))
+	log.Check(log.WarnLevel, "Deleting template metadata", db.RemoveTemplateMeta(name))
 
 	return nil
 }
 
+// checkLocks returns error if snapshots of container or template are locked, e.g. ones backups depend on
+func checkLocks(name string) error {
+	holds, err := fs.ListHolds(name)
+	if err != nil || len(holds) == 0 {
+		return err
+	}
+
+	var held []string
+	for snapshot := range holds {
+		held = append(held, snapshot)
+	}
+	sort.Strings(held)
+
+	return errors.New("Snapshots " + strings.Join(held, ", ") + " of " + name + " are locked, unlock them first")
+}
+
+// Destroy removes datasets of container or template, caller must hold lock of the instance
 func Destroy(name string, silent bool) error {
 
 	var err error = nil
 
-	var lock lockfile.Lockfile
-	for lock, err = common.LockFile("lxc", "destroy"); err != nil; lock, err = common.LockFile("lxc", "destroy") {
-		time.Sleep(time.Second * 1)
-	}
-	defer lock.Unlock()
-
 	out, err := fs.ListSnapshotNamesOnly(name)
 	if !silent && err != nil {
 		return err
@@ -403,8 +525,15 @@ func Destroy(name string, silent bool) error {
 		}
 	}
 
-	//destroy child datasets
+	//destroy child datasets, including partitions kept by upgrade
+	var children []string
 	for _, dataset := range fs.ChildDatasets {
+		children = append(children, dataset)
+	}
+	for _, dataset := range upgradedPartitions {
+		children = append(children, dataset+previousSuffix)
+	}
+	for _, dataset := range children {
 		childDataset := path.Join(name, dataset)
 		if fs.DatasetExists(childDataset) {
 			err = fs.RemoveDataset(childDataset, false)
@@ -428,31 +557,54 @@ func GetProperty(templateOrContainerName string, propertyName string) string {
 
 // Clone create the duplicate container from the Subutai template.
 func Clone(parent, child string) error {
+	return CloneFromSnapshot(parent, child, "now")
+}
 
-	//create parent dataset
-	err := fs.CreateDataset(child)
+// CloneFromSnapshot creates container from snapshot of the Subutai template with the given label,
+// e.g. pinned validated state of template. Snapshot must exist for every template partition
+func CloneFromSnapshot(parent, child, label string) error {
+	parentRef, err := templ.ParseFullRef(parent)
 	if err != nil {
 		return err
 	}
 
-	//create partitions
-	err = fs.CloneSnapshot(parent+"/rootfs@now", child+"/rootfs")
-	if err != nil {
+	if err = ValidateName(child); err != nil {
 		return err
 	}
-	err = fs.CloneSnapshot(parent+"/home@now", child+"/home")
-	if err != nil {
+
+	if err = ValidateDatasetComponent(label); err != nil {
 		return err
 	}
-	err = fs.CloneSnapshot(parent+"/var@now", child+"/var")
-	if err != nil {
-		return err
+
+	return GetRuntime().Clone(parentRef, child, label)
+}
+
+// cloneDatasets clones partitions of template stored in datasets of agent and writes config of new container
+func cloneDatasets(parentRef templ.Ref, child, label string) error {
+	parent := parentRef.String()
+
+	//check all partitions up front to not leave half cloned container behind
+	for _, partition := range fs.ChildDatasets {
+		snapshot := parent + "/" + partition + "@" + label
+		if !fs.DatasetExists(snapshot) {
+			return errors.New("Snapshot " + snapshot + " not found")
+		}
 	}
-	err = fs.CloneSnapshot(parent+"/opt@now", child+"/opt")
+
+	//create parent dataset
+	err := fs.CreateDataset(child)
 	if err != nil {
 		return err
 	}
 
+	//create partitions
+	for _, partition := range fs.ChildDatasets {
+		err = fs.CloneSnapshot(parent+"/"+partition+"@"+label, child+"/"+partition)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = fs.Copy(path.Join(config.Agent.LxcPrefix, parent, "config"), path.Join(config.Agent.LxcPrefix, child, "config"))
 	if err != nil {
 		return err
@@ -468,16 +620,14 @@ func Clone(parent, child string) error {
 		return err
 	}
 
-	parentParts := strings.Split(parent, ":")
-
 	if common.GetMajorVersion() < 3 {
 		err = SetContainerConf(child, [][]string{
 			{"lxc.network.hwaddr", mac},
 			{"lxc.network.veth.pair", strings.Replace(mac, ":", "", -1)},
 			{"lxc.network.mtu", strconv.Itoa(mtu)},
-			{"subutai.parent", parentParts[0]},
-			{"subutai.parent.owner", parentParts[1]},
-			{"subutai.parent.version", parentParts[2]},
+			{"subutai.parent", parentRef.Name},
+			{"subutai.parent.owner", parentRef.Owner},
+			{"subutai.parent.version", parentRef.Version},
 			{"lxc.rootfs", path.Join(config.Agent.LxcPrefix, child, "rootfs")},
 			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, child, "home") + " home none bind,rw 0 0"},
 			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, child, "opt") + " opt none bind,rw 0 0"},
@@ -490,9 +640,9 @@ func Clone(parent, child string) error {
 			{"lxc.net.0.hwaddr", mac},
 			{"lxc.net.0.veth.pair", strings.Replace(mac, ":", "", -1)},
 			{"lxc.net.0.mtu", strconv.Itoa(mtu)},
-			{"subutai.parent", parentParts[0]},
-			{"subutai.parent.owner", parentParts[1]},
-			{"subutai.parent.version", parentParts[2]},
+			{"subutai.parent", parentRef.Name},
+			{"subutai.parent.owner", parentRef.Owner},
+			{"subutai.parent.version", parentRef.Version},
 			{"lxc.rootfs.path", "zfs:" + path.Join(config.Agent.LxcPrefix, child, "rootfs")},
 			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, child, "home") + " home none bind,rw 0 0"},
 			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, child, "opt") + " opt none bind,rw 0 0"},
@@ -505,146 +655,197 @@ func Clone(parent, child string) error {
 		return err
 	}
 
+	//containers cloned from other snapshots can not be exported since template deltas are based on @now,
+	//snapshot of parent copied with config of promoted template is dropped for clones of its @now
+	snapshotItem := []string{"subutai.parent.snapshot"}
+	if label != "now" {
+		snapshotItem = append(snapshotItem, label)
+	}
+	if err = SetContainerConf(child, [][]string{snapshotItem}); err != nil {
+		return err
+	}
+
 	//create default hostname
 	return ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, child, "/rootfs/etc/hostname"), []byte(child), 0644)
 
 }
 
-//todo return error
-func QuotaDisk(name, size string) int {
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err == nil {
-		defer lxc.Release(c)
+// CloneContainer creates container from snapshot of all partitions of another container with the given label,
+// e.g. to fork environment as it was yesterday. Config is taken as it was at the snapshot, while mac address,
+// hostname and machine id are fresh. Huge pages are not reserved for the new container.
+// Snapshot can not be removed while the new container exists
+func CloneContainer(source, child, label string) error {
+	if err := ValidateName(child); err != nil {
+		return err
+	}
+	if err := ValidateDatasetComponent(label); err != nil {
+		return err
+	}
+
+	for _, partition := range fs.ChildDatasets {
+		snapshot := source + "/" + partition + "@" + label
+		if !fs.DatasetExists(snapshot) {
+			return errors.New("Snapshot " + snapshot + " not found")
+		}
 	}
-	log.Check(log.DebugLevel, "Looking for container: "+name, err)
 
-	if len(size) > 0 {
-		vs, err := strconv.Atoi(size)
-		log.Check(log.DebugLevel, "Parsing disk limit "+size, err)
-		err = fs.SetQuota(name, vs)
-		log.Check(log.DebugLevel, "Setting disk limit of container "+name, err)
+	//snapshot of all partitions includes config, otherwise current config of source is used
+	confDir := path.Join(config.Agent.LxcPrefix, source, ".zfs", "snapshot", label)
+	if !fs.FileExists(path.Join(confDir, "config")) {
+		confDir = path.Join(config.Agent.LxcPrefix, source)
 	}
-	vr, err := fs.GetQuota(name)
+
+	err := fs.CreateDataset(child)
+	if err != nil {
+		return err
+	}
+	for _, partition := range fs.ChildDatasets {
+		err = fs.CloneSnapshot(source+"/"+partition+"@"+label, child+"/"+partition)
+		if err != nil {
+			return err
+		}
+	}
+
+	//rootfs, bind mounts and fstab refer to directories of source
+	for _, file := range []string{"config", "fstab"} {
+		data, err := ioutil.ReadFile(path.Join(confDir, file))
+		if os.IsNotExist(err) && file == "fstab" {
+			continue
+		} else if err != nil {
+			return err
+		}
+		data = []byte(strings.Replace(string(data), path.Join(config.Agent.LxcPrefix, source)+"/",
+			path.Join(config.Agent.LxcPrefix, child)+"/", -1))
+		if err = ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, child, file), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	mac, err := Mac()
+	if err != nil {
+		return err
+	}
+	prefix, uts := "lxc.net.0.", "lxc.uts.name"
+	if common.GetMajorVersion() < 3 {
+		prefix, uts = "lxc.network.", "lxc.utsname"
+	}
+	err = SetContainerConf(child, [][]string{
+		{prefix + "hwaddr", mac},
+		{prefix + "veth.pair", strings.Replace(mac, ":", "", -1)},
+		{uts, child},
+		{hugepagesItem, ""},
+	})
+	if err != nil {
+		return err
+	}
+	if err = setFstabEntry(child, "dev/hugepages", ""); err != nil {
+		return err
+	}
+
+	//empty machine id is generated anew on boot
+	machineId := path.Join(config.Agent.LxcPrefix, child, "rootfs/etc/machine-id")
+	if fs.FileExists(machineId) {
+		if err = ioutil.WriteFile(machineId, nil, 0444); err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, child, "/rootfs/etc/hostname"), []byte(child), 0644)
+}
+
+// QuotaDisk sets disk quota of container in Gb if size is given and returns current quota, 0 if there is none.
+// Errors are only logged, see SetQuota and GetQuota
+func QuotaDisk(name, size string) int {
+	if size != "" {
+		setQuota(name, size, func(quota *Quota, value int) { quota.Disk = value })
+	}
+	quota, err := GetQuota(name)
 	log.Check(log.DebugLevel, "Getting disk limit of container "+name, err)
-	//convert bytes to GB
-	vr /= 1024 * 1024 * 1024
 
-	return vr
+	return quota.Disk
 }
 
 // QuotaRAM sets the memory quota to the Subutai container.
 // If quota size argument is missing, just return current value.
-//todo return error
+// Errors are only logged, see SetQuota and GetQuota
 func QuotaRAM(name string, size string) int {
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err == nil {
-		defer lxc.Release(c)
-	}
-	log.Check(log.DebugLevel, "Looking for container: "+name, err)
-
-	//set limit
 	if size != "" {
-		setLimit, err := strconv.Atoi(size)
-		log.Check(log.DebugLevel, "Parsing quota size", err)
-		log.Check(log.DebugLevel, "Setting memory limit", c.SetMemoryLimit(lxc.ByteSize(setLimit*1024*1024)))
-		SetContainerConf(name, [][]string{{"lxc.cgroup.memory.limit_in_bytes", size + "M"}})
-	}
-
-	limit, err := c.MemoryLimit()
-	if limit == 9223372036854771712 {
-		limit = 0
+		setQuota(name, size, func(quota *Quota, value int) { quota.RAM = value })
 	}
+	quota, err := GetQuota(name)
 	log.Check(log.DebugLevel, "Getting memory limit of container: "+name, err)
-	return int(limit / 1024 / 1024)
+
+	return quota.RAM
 }
 
 //todo remove MHz just leave %
 // QuotaCPU sets container CPU limitation and return current value in percents.
 // If passed value < 100, we assume that this value mean percents.
 // If passed value > 100, we assume that this value mean MHz.
-//todo return error
+// Errors are only logged, see SetQuota and GetQuota
 func QuotaCPU(name string, size string) int {
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err == nil {
-		defer lxc.Release(c)
-	}
-	log.Check(log.DebugLevel, "Looking for container: "+name, err)
-	cfsPeriod := 100000
-	var quota float32
 	if size != "" {
-		tmp, err := strconv.Atoi(size)
-		log.Check(log.DebugLevel, "Parsing quota size", err)
-		quota = float32(tmp)
-	}
-
-	if quota > 100 {
-		out, err := ioutil.ReadFile("/sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq")
-		freq, err := strconv.Atoi(strings.TrimSpace(string(out)))
-		log.Check(log.DebugLevel, "Parsing quota size", err)
-		freq = freq / 1000
-		if log.Check(log.DebugLevel, "Getting CPU max frequency", err) {
-			out, err := ioutil.ReadFile("/proc/cpuinfo")
-			scanner := bufio.NewScanner(bytes.NewReader(out))
-			for scanner.Scan() && err == nil {
-				if strings.HasPrefix(scanner.Text(), "cpu MHz") {
-					freq, err = strconv.Atoi(strings.TrimSpace(strings.Split(strings.Split(scanner.Text(), ":")[1], ".")[0]))
-					log.Check(log.DebugLevel, "Parsing quota size", err)
-					break
-				}
+		setQuota(name, size, func(quota *Quota, value int) {
+			if value > 100 {
+				percent, err := cpuPercent(value)
+				log.Check(log.DebugLevel, "Converting MHz to percents", err)
+				value = percent
 			}
-		}
-		quota = quota * 100 / float32(freq) / float32(runtime.NumCPU())
-	}
-
-	if size != "" && State(name) == Running {
-		value := strconv.Itoa(int(float32(cfsPeriod) * float32(runtime.NumCPU()) * quota / 100))
-		log.Check(log.DebugLevel, "Setting cpu.cfs_quota_us", c.SetCgroupItem("cpu.cfs_quota_us", value))
-
-		SetContainerConf(name, [][]string{{"lxc.cgroup.cpu.cfs_quota_us", value}})
+			quota.CPU = value
+		})
 	}
+	quota, err := GetQuota(name)
+	log.Check(log.DebugLevel, "Getting cpu limit of container: "+name, err)
 
-	result, err := strconv.Atoi(c.CgroupItem("cpu.cfs_quota_us")[0])
-	log.Check(log.DebugLevel, "Parsing quota size", err)
-	return result * 100 / cfsPeriod / runtime.NumCPU()
+	return quota.CPU
 }
 
-// QuotaCPUset sets particular cores that can be used by the Subutai container
-// todo return error
+// QuotaCPUset sets particular cores that can be used by the Subutai container.
+// With "auto" cores are assigned by agent and rebalanced periodically, see CPUBalancer.
+// Errors are only logged, see SetQuota and GetQuota
 func QuotaCPUset(name string, size string) string {
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err == nil {
-		defer lxc.Release(c)
+	if size != "" {
+		log.Check(log.WarnLevel, "Setting cpuset of "+name, setCPUsetQuota(name, size))
 	}
-	log.Check(log.DebugLevel, "Looking for container: "+name, err)
+	return GetRuntime().CgroupItem(name, "cpuset.cpus")
+}
+
+// QuotaIOWeight sets relative block IO weight of the Subutai container, from 10 to 1000, see QuotaIO for throttling
+func QuotaIOWeight(name string, size string) string {
+	rt := GetRuntime()
 	if size != "" {
-		log.Check(log.DebugLevel, "Setting cpuset.cpus", c.SetCgroupItem("cpuset.cpus", size))
-		SetContainerConf(name, [][]string{{"lxc.cgroup.cpuset.cpus", size}})
+		log.Check(log.DebugLevel, "Setting blkio.weight", rt.SetCgroupItem(name, ioWeightItem, size))
+		SetContainerConf(name, CgroupConf(ioWeightItem, size))
 	}
-	return c.CgroupItem("cpuset.cpus")[0]
+	return rt.CgroupItem(name, ioWeightItem)
 }
 
 // QuotaNet sets network bandwidth for the Subutai container.
-//todo return error
+// Errors are only logged, see SetQuota and GetQuota
 func QuotaNet(name string, size string) string {
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err == nil {
-		defer lxc.Release(c)
+	if size != "" {
+		setQuota(name, size, func(quota *Quota, value int) { quota.Net = value })
 	}
-	log.Check(log.DebugLevel, "Looking for container: "+name, err)
-	option := "lxc.net.0.veth.pair"
-	if common.GetMajorVersion() < 3 {
-		option = "lxc.network.veth.pair"
+	return net.RateLimit(vethPair(name), "")
+}
+
+//setQuota changes a single limit of quota of container, for callers of functions with string sizes
+func setQuota(name, size string, set func(quota *Quota, value int)) {
+	value, err := strconv.Atoi(size)
+	if log.Check(log.DebugLevel, "Parsing quota size "+size, err) {
+		return
 	}
-	nic := GetConfigItem(c.ConfigFileName(), option)
-	if size != "" {
-		SetContainerConf(name, [][]string{{"subutai.network.ratelimit", size}})
+	quota, err := GetQuota(name)
+	if log.Check(log.DebugLevel, "Getting quota of container "+name, err) {
+		return
 	}
-	return net.RateLimit(nic, size)
+	set(&quota, value)
+	log.Check(log.DebugLevel, "Setting quota of container "+name, SetQuota(name, quota))
 }
 
 func CreateContainerConf(confPath string, conf [][]string) error {
 	if common.GetMajorVersion() >= 3 {
-		err := exec.Command("lxc-update-config", "-c", confPath).Run()
+		err := exec.Exec("lxc-update-config", "-c", confPath)
 		log.Check(log.ErrorLevel, "Failed to upgrade lxc configuration", err)
 	}
 
@@ -681,6 +882,7 @@ func CreateContainerConf(confPath string, conf [][]string) error {
 			newconf = newconf + strings.TrimSpace(conf[i][0]) + " = " + strings.TrimSpace(conf[i][1]) + "\n"
 		}
 	}
+	defer invalidateConfig(confPath)
 	return ioutil.WriteFile(confPath, []byte(newconf), 0644)
 }
 
@@ -691,19 +893,93 @@ func SetContainerConf(container string, conf [][]string) error {
 	return CreateContainerConf(confPath, conf)
 }
 
-// GetConfigItem return any parameter from the configuration file of the Subutai container.
-func GetConfigItem(path, item string) string {
-	if cfg, err := os.Open(path); err == nil {
-		defer cfg.Close()
-		scanner := bufio.NewScanner(cfg)
-		for scanner.Scan() {
-			line := strings.Split(scanner.Text(), "=")
-			if strings.Trim(line[0], " ") == item {
-				return strings.Trim(line[1], " ")
+// SetContainerConfList replaces all occurrences of item in config of container with values, one line per value,
+// for items which may be repeated, e.g. per device cgroup limits. Empty values remove item
+func SetContainerConfList(container, item string, values []string) error {
+	confPath := path.Join(config.Agent.LxcPrefix, container, "config")
+
+	//each line of item is replaced by the next value or removed if values are exhausted
+	var conf [][]string
+	for _, v := range values {
+		conf = append(conf, []string{item, v})
+	}
+	if data, err := ioutil.ReadFile(confPath); err == nil {
+		existing := 0
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(strings.Split(line, "=")[0]) == item {
+				existing++
 			}
 		}
+		for i := len(values); i < existing; i++ {
+			conf = append(conf, []string{item})
+		}
+	}
+
+	return CreateContainerConf(confPath, conf)
+}
+
+// GetContainerConfList returns all values of item in config of container, for items which may be repeated,
+// e.g. mount entries
+func GetContainerConfList(container, item string) ([]string, error) {
+	data, err := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, container, "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == item {
+			values = append(values, strings.TrimSpace(kv[1]))
+		}
+	}
+	return values, nil
+}
+
+// ManagedConfig returns config items of container which agent derives from its db record,
+// items without recorded value are omitted
+func ManagedConfig(c db.Container) [][]string {
+	prefix := netPrefix()
+
+	address, address6 := "", ""
+	if c.Ip != "" {
+		address = c.Ip + "/24"
+	}
+	if c.Ipv6 != "" {
+		address6 = c.Ipv6 + "/" + Ipv6Prefix
+	}
+
+	var conf [][]string
+	for _, item := range [][]string{
+		{prefix + "veth.pair", c.Interface},
+		{prefix + "ipv4.address", address},
+		{prefix + "ipv4.gateway", c.Gateway},
+		{prefix + "ipv6.address", address6},
+		{prefix + "ipv6.gateway", c.Gateway6},
+		{"#vlan_id", c.Vlan},
+		{"subutai.parent", c.Template},
+		{"subutai.parent.owner", c.TemplateOwner},
+		{"subutai.parent.version", c.TemplateVersion},
+	} {
+		if item[1] != "" {
+			conf = append(conf, item)
+		}
+	}
+
+	return conf
+}
+
+// netPrefix returns prefix of config items of container network interface for installed LXC version
+func netPrefix() string {
+	if common.GetMajorVersion() < 3 {
+		return "lxc.network."
 	}
-	return ""
+	return "lxc.net.0."
+}
+
+// GetConfigItem return any parameter from the configuration file of the Subutai container.
+// Parsed config is cached until file changes, use GetConfigItems to read several parameters at once.
+func GetConfigItem(path, item string) string {
+	return configItems(path)[item]
 }
 
 func GetContainerUID(container string) string {
@@ -738,32 +1014,37 @@ func SetContainerUID(c string) (string, error) {
 
 	parentuid := strconv.Itoa(int(s.Sys().(*syscall.Stat_t).Uid))
 	log.Check(log.DebugLevel, "uidmapshift rootfs",
-		exec.Command("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, c, "rootfs"), parentuid, uid, "65536").Run())
+		exec.Exec("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, c, "rootfs"), parentuid, uid, "65536"))
 	log.Check(log.DebugLevel, "uidmapshift home",
-		exec.Command("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, c, "home"), parentuid, uid, "65536").Run())
+		exec.Exec("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, c, "home"), parentuid, uid, "65536"))
 	log.Check(log.DebugLevel, "uidmapshift opt",
-		exec.Command("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, c, "opt"), parentuid, uid, "65536").Run())
+		exec.Exec("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, c, "opt"), parentuid, uid, "65536"))
 	log.Check(log.DebugLevel, "uidmapshift var",
-		exec.Command("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, c, "var"), parentuid, uid, "65536").Run())
+		exec.Exec("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, c, "var"), parentuid, uid, "65536"))
 
 	return uid, os.Chmod(path.Join(config.Agent.LxcPrefix, c), 0755)
 }
 
 // SetDNS configures the Subutai containers to use internal DNS-server from the Resource Host.
+// Dual-stack containers use IPv6 gateway as second nameserver
 //todo return error
 func SetDNS(name string) {
-	dns := GetProperty(name, "lxc.network.ipv4.gateway")
+	props := GetProperties(name, netPrefix()+"ipv4.gateway", netPrefix()+"ipv6.gateway")
+	dns := props[netPrefix()+"ipv4.gateway"]
 	if len(dns) == 0 {
-		dns = "10.10.10.254"
+		dns = config.Management.ContainerGateway
 	}
 
-	resolv := []byte("domain\tintra.lan\nsearch\tintra.lan\nnameserver\t" + dns + "\n")
+	resolv := "domain\tintra.lan\nsearch\tintra.lan\nnameserver\t" + dns + "\n"
+	if dns6 := props[netPrefix()+"ipv6.gateway"]; dns6 != "" {
+		resolv += "nameserver\t" + dns6 + "\n"
+	}
 	log.Check(log.DebugLevel, "Writing resolv.conf.orig",
-		ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/resolvconf/resolv.conf.d/original"), resolv, 0644))
+		ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/resolvconf/resolv.conf.d/original"), []byte(resolv), 0644))
 	log.Check(log.DebugLevel, "Writing resolv.conf.tail",
-		ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/resolvconf/resolv.conf.d/tail"), resolv, 0644))
+		ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/resolvconf/resolv.conf.d/tail"), []byte(resolv), 0644))
 	log.Check(log.DebugLevel, "Writing resolv.conf",
-		ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/resolv.conf"), resolv, 0644))
+		ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/resolv.conf"), []byte(resolv), 0644))
 }
 
 //todo return error
@@ -775,13 +1056,17 @@ func CopyParentReference(name string, owner string, version string) {
 }
 
 // SetStaticNet sets static IP-address for the Subutai container.
+// Addresses of both families are configured by LXC, so IPv6 autoconfiguration of template is disabled too
 //todo return error
 func SetStaticNet(name string) {
 	data, err := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/network/interfaces"))
 	log.Check(log.WarnLevel, "Opening /etc/network/interfaces", err)
 
+	interfaces := strings.Replace(string(data), "dhcp", "manual", 1)
+	interfaces = regexp.MustCompile(`(?m)^(iface \S+ inet6) (auto|dhcp)$`).ReplaceAllString(interfaces, "$1 manual")
+
 	err = ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/network/interfaces"),
-		[]byte(strings.Replace(string(data), "dhcp", "manual", 1)), 0644)
+		[]byte(interfaces), 0644)
 	log.Check(log.WarnLevel, "Setting internal eth0 interface to manual", err)
 }
 
@@ -790,14 +1075,33 @@ func SetManagementNet() {
 	data, err := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, Management, "/rootfs/etc/network/interfaces"))
 	log.Check(log.WarnLevel, "Opening /etc/network/interfaces", err)
 
-	interfaces := string(data)
+	nameservers := config.Management.ContainerGateway
+	if config.Management.ContainerGateway6 != "" {
+		nameservers += " " + config.Management.ContainerGateway6
+	}
+
+	//IPv6 stanza is appended last and is rewritten on every call
+	interfaces := regexp.MustCompile(`(?s)\n*iface eth0 inet6 static\n.*$`).ReplaceAllString(string(data), "")
 	if strings.Contains(interfaces, "manual") {
 		interfaces = strings.Replace(interfaces, "manual", "static", 1)
-		interfaces += "address 10.10.10.1\n"
+		interfaces += "address " + ManagementIp + "\n"
 		interfaces += "netmask 255.255.255.0\n"
-		interfaces += "gateway 10.10.10.254\n"
+		interfaces += "gateway " + config.Management.ContainerGateway + "\n"
 		interfaces += "dns-search intra.lan\n"
-		interfaces += "dns-nameservers 10.10.10.254"
+		interfaces += "dns-nameservers " + nameservers
+	} else {
+		//network is already configured, e.g. on management reinit, apply current settings
+		interfaces = regexp.MustCompile(`(?m)^address .*$`).ReplaceAllString(interfaces, "address "+ManagementIp)
+		interfaces = regexp.MustCompile(`(?m)^gateway .*$`).ReplaceAllString(interfaces,
+			"gateway "+config.Management.ContainerGateway)
+		interfaces = regexp.MustCompile(`(?m)^dns-nameservers .*$`).ReplaceAllString(interfaces,
+			"dns-nameservers "+nameservers)
+	}
+	if config.Management.ContainerIpv6 != "" {
+		interfaces = strings.TrimRight(interfaces, "\n") + "\n\niface eth0 inet6 static\n"
+		interfaces += "address " + config.Management.ContainerIpv6 + "\n"
+		interfaces += "netmask " + Ipv6Prefix + "\n"
+		interfaces += "gateway " + config.Management.ContainerGateway6 + "\n"
 	}
 
 	err = ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, Management, "/rootfs/etc/network/interfaces"),
@@ -859,13 +1163,7 @@ func Mac() (string, error) {
 
 //todo return error
 func GetIp(name string) string {
-	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	if err == nil {
-		defer lxc.Release(c)
-	}
-	log.Check(log.DebugLevel, "Looking for container: "+name, err)
-
-	listip, err := c.IPAddress(ContainerDefaultIface)
+	listip, err := GetRuntime().IPAddresses(name, ContainerDefaultIface)
 	log.Check(log.DebugLevel, "Getting ip of container "+name, err)
 
 	return strings.Join(listip, " ")
@@ -0,0 +1,217 @@
+package container
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+	"gopkg.in/lxc/go-lxc.v2"
+)
+
+// lxcRuntime manages containers located in config.Agent.LxcPrefix directly via liblxc
+type lxcRuntime struct{}
+
+func (lxcRuntime) Names() []string {
+	return lxc.DefinedContainerNames(config.Agent.LxcPrefix)
+}
+
+func (lxcRuntime) IsTemplate(name string) bool {
+	return isTemplateDataset(name)
+}
+
+func (lxcRuntime) IsContainer(name string) bool {
+	return isContainerDataset(name)
+}
+
+func (lxcRuntime) Clone(parent templ.Ref, child, label string) error {
+	return cloneDatasets(parent, child, label)
+}
+
+func (lxcRuntime) State(name string) string {
+	if c, err := lxc.NewContainer(name, config.Agent.LxcPrefix); err == nil {
+		defer lxc.Release(c)
+		return c.State().String()
+	}
+	return Unknown
+}
+
+func (lxcRuntime) Start(name string) error {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if log.Check(log.DebugLevel, "Creating container object", err) {
+		return err
+	}
+	defer lxc.Release(c)
+
+	log.Check(log.DebugLevel, "Starting LXC container "+name, c.Start())
+
+	if c.State().String() != Running {
+		return errors.New("Unable to start container " + name)
+	}
+
+	return nil
+}
+
+func (lxcRuntime) Stop(name string) error {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if log.Check(log.DebugLevel, "Creating container object", err) {
+		return err
+	}
+	defer lxc.Release(c)
+
+	log.Check(log.DebugLevel, "Stopping LXC container "+name, c.Stop())
+
+	if c.State().String() != Stopped {
+		return errors.New("Unable to stop container " + name)
+	}
+
+	return nil
+}
+
+func (lxcRuntime) Shutdown(name string, timeout time.Duration) error {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if err != nil {
+		return err
+	}
+	defer lxc.Release(c)
+
+	return c.Shutdown(timeout)
+}
+
+func (lxcRuntime) Exec(name string, command []string, options ExecOptions) (int, error) {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if err != nil {
+		return -1, err
+	}
+	defer lxc.Release(c)
+
+	if c.State() != lxc.RUNNING {
+		return -1, errors.New("Container is " + c.State().String())
+	}
+
+	opts := lxc.DefaultAttachOptions
+	opts.UID, opts.GID = options.UID, options.GID
+	if options.Cwd != "" {
+		opts.Cwd = options.Cwd
+	}
+	opts.Env = options.Env
+	opts.ClearEnv = options.ClearEnv
+	opts.EnvToKeep = options.EnvToKeep
+
+	if options.Tty {
+		p, err := openPty()
+		if err != nil {
+			return -1, err
+		}
+		opts.StdinFd, opts.StdoutFd, opts.StderrFd = p.slave.Fd(), p.slave.Fd(), p.slave.Fd()
+		detach := p.attach()
+		defer detach()
+		return c.RunCommandStatus(command, opts)
+	}
+
+	stdin, err := pipeFrom(options.Stdin)
+	if err != nil {
+		return -1, errors.New("Failed to create OS pipe")
+	}
+	defer stdin.Close()
+
+	var wg sync.WaitGroup
+	stdout, err := pipeTo(options.Stdout, &wg)
+	if err != nil {
+		return -1, errors.New("Failed to create OS pipe")
+	}
+	stderr, err := pipeTo(options.Stderr, &wg)
+	if err != nil {
+		stdout.Close()
+		wg.Wait()
+		return -1, errors.New("Failed to create OS pipe")
+	}
+
+	opts.StdinFd = stdin.Fd()
+	opts.StdoutFd = stdout.Fd()
+	opts.StderrFd = stderr.Fd()
+
+	exitCode, err := c.RunCommandStatus(command, opts)
+	log.Check(log.DebugLevel, "Closing write buffer for stdout", stdout.Close())
+	log.Check(log.DebugLevel, "Closing write buffer for stderr", stderr.Close())
+	wg.Wait()
+
+	return exitCode, err
+}
+
+func (lxcRuntime) Attach(name string, command []string, env []string) error {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if err != nil {
+		return err
+	}
+	defer lxc.Release(c)
+
+	options := lxc.DefaultAttachOptions
+	options.EnvToKeep = []string{"TERM", "LS_COLORS"}
+	options.Env = env
+	options.ClearEnv = true
+
+	if len(command) == 0 {
+		return c.AttachShell(options)
+	}
+
+	_, err = c.RunCommand(command, options)
+	return err
+}
+
+// CgroupItem reads cgroup v1 item, on unified hierarchy corresponding v2 item is read and its value translated
+func (lxcRuntime) CgroupItem(name, key string) string {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if log.Check(log.DebugLevel, "Looking for container: "+name, err) {
+		return ""
+	}
+	defer lxc.Release(c)
+
+	if UnifiedCgroup() {
+		v2Key, _ := cgroup2Item(key, "")
+		if values := c.CgroupItem(v2Key); len(values) > 0 {
+			return cgroup1Value(key, values[0])
+		}
+		return ""
+	}
+
+	if values := c.CgroupItem(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (lxcRuntime) SetCgroupItem(name, key, value string) error {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if err != nil {
+		return err
+	}
+	defer lxc.Release(c)
+
+	if UnifiedCgroup() {
+		key, value = cgroup2Item(key, value)
+	}
+	return c.SetCgroupItem(key, value)
+}
+
+func (lxcRuntime) IPAddresses(name, iface string) ([]string, error) {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer lxc.Release(c)
+
+	return c.IPAddress(iface)
+}
+
+func (lxcRuntime) InitPid(name string) int {
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if err != nil {
+		return -1
+	}
+	defer lxc.Release(c)
+
+	return c.InitPid()
+}
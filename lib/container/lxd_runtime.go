@@ -0,0 +1,440 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+// lxdRuntime manages containers through REST API of local LXD daemon listening on config.Agent.LxdSocket.
+// Templates are LXD images aliased with template reference, e.g. "debian-stretch:subutai:0.4.5", containers
+// are LXD instances created from them. Commands are executed with recorded output, so output is available only
+// after command completes
+type lxdRuntime struct{}
+
+// limits of LXD instance config corresponding to cgroup items
+const (
+	lxdMemory       = "limits.memory"
+	lxdCpuAllowance = "limits.cpu.allowance"
+	lxdCpuset       = "limits.cpu"
+)
+
+// LXD uses 100ms cfs period as liblxc does
+const cfsPeriodMs = 100
+
+type lxdResponse struct {
+	Type      string          `json:"type"`
+	Error     string          `json:"error"`
+	Operation string          `json:"operation"`
+	Metadata  json.RawMessage `json:"metadata"`
+}
+
+type lxdOperation struct {
+	Status   string          `json:"status"`
+	Err      string          `json:"err"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+type lxdState struct {
+	Status string `json:"status"`
+	Pid    int    `json:"pid"`
+	CPU    struct {
+		//nanoseconds
+		Usage int64 `json:"usage"`
+	} `json:"cpu"`
+	Memory struct {
+		Usage     int64 `json:"usage"`
+		UsagePeak int64 `json:"usage_peak"`
+	} `json:"memory"`
+	Network map[string]struct {
+		Addresses []struct {
+			Family  string `json:"family"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"network"`
+}
+
+func (lxdRuntime) client() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", config.Agent.LxdSocket)
+		},
+	}}
+}
+
+// do sends request to LXD and returns raw response body
+func (l lxdRuntime) do(method, endpoint string, body interface{}) (io.ReadCloser, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, "http://lxd"+endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// request sends request to LXD, waits for completion of background operation if any
+// and unmarshals resulting metadata into result
+func (l lxdRuntime) request(method, endpoint string, body interface{}, result interface{}) error {
+	reader, err := l.do(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var resp lxdResponse
+	if err := json.NewDecoder(reader).Decode(&resp); err != nil {
+		return err
+	}
+
+	metadata := resp.Metadata
+	switch resp.Type {
+	case "error":
+		return errors.Errorf("LXD %s %s: %s", method, endpoint, resp.Error)
+	case "async":
+		var op lxdOperation
+		if err := l.request("GET", resp.Operation+"/wait", nil, &op); err != nil {
+			return err
+		}
+		if op.Err != "" {
+			return errors.Errorf("LXD %s %s: %s", method, endpoint, op.Err)
+		}
+		metadata = op.Metadata
+	}
+
+	if result == nil || len(metadata) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(metadata, result)
+}
+
+func (l lxdRuntime) changeState(name, action string, timeout time.Duration, force bool) error {
+	return l.request("PUT", "/1.0/containers/"+url.PathEscape(name)+"/state", map[string]interface{}{
+		"action": action, "timeout": int(timeout.Seconds()), "force": force,
+	}, nil)
+}
+
+func (l lxdRuntime) state(name string) (*lxdState, error) {
+	var state lxdState
+	if err := l.request("GET", "/1.0/containers/"+url.PathEscape(name)+"/state", nil, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (l lxdRuntime) limits(name string) (map[string]string, error) {
+	var instance struct {
+		Config map[string]string `json:"config"`
+	}
+	if err := l.request("GET", "/1.0/containers/"+url.PathEscape(name), nil, &instance); err != nil {
+		return nil, err
+	}
+	return instance.Config, nil
+}
+
+func (l lxdRuntime) Names() []string {
+	var urls []string
+	if log.Check(log.DebugLevel, "Listing LXD containers", l.request("GET", "/1.0/containers", nil, &urls)) {
+		return nil
+	}
+	var aliases []string
+	if !log.Check(log.DebugLevel, "Listing LXD image aliases", l.request("GET", "/1.0/images/aliases", nil, &aliases)) {
+		urls = append(urls, aliases...)
+	}
+
+	var names []string
+	for _, u := range urls {
+		name, err := url.PathUnescape(path.Base(u))
+		if err == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (l lxdRuntime) IsTemplate(name string) bool {
+	return l.request("GET", "/1.0/images/aliases/"+url.PathEscape(name), nil, nil) == nil
+}
+
+func (l lxdRuntime) IsContainer(name string) bool {
+	return l.request("GET", "/1.0/containers/"+url.PathEscape(name), nil, nil) == nil
+}
+
+// Clone creates instance from image of template, images have no snapshots so only "now" label is supported
+func (l lxdRuntime) Clone(parent templ.Ref, child, label string) error {
+	if label != "now" {
+		return errors.Errorf("Cloning from snapshot %s is not supported by LXD runtime", label)
+	}
+
+	return l.request("POST", "/1.0/containers", map[string]interface{}{
+		"name":   child,
+		"source": map[string]string{"type": "image", "alias": parent.String()},
+		"config": map[string]string{
+			"user.subutai.parent":         parent.Name,
+			"user.subutai.parent.owner":   parent.Owner,
+			"user.subutai.parent.version": parent.Version,
+		},
+	}, nil)
+}
+
+func (l lxdRuntime) State(name string) string {
+	state, err := l.state(name)
+	if log.Check(log.DebugLevel, "Getting state of container "+name, err) {
+		return Unknown
+	}
+
+	switch strings.ToUpper(state.Status) {
+	case Running:
+		return Running
+	case Stopped:
+		return Stopped
+	}
+	return Unknown
+}
+
+func (l lxdRuntime) Start(name string) error {
+	if err := l.changeState(name, "start", 0, false); err != nil {
+		return errors.Errorf("Unable to start container %s: %s", name, err.Error())
+	}
+	return nil
+}
+
+func (l lxdRuntime) Stop(name string) error {
+	if err := l.changeState(name, "stop", 0, true); err != nil {
+		return errors.Errorf("Unable to stop container %s: %s", name, err.Error())
+	}
+	return nil
+}
+
+func (l lxdRuntime) Shutdown(name string, timeout time.Duration) error {
+	return l.changeState(name, "stop", timeout, false)
+}
+
+func (l lxdRuntime) Exec(name string, command []string, options ExecOptions) (int, error) {
+	if state := l.State(name); state != Running {
+		return -1, errors.New("Container is " + state)
+	}
+	if options.Tty {
+		return -1, errors.New("Commands attached to terminal are not supported by LXD runtime")
+	}
+	if options.Stdin != nil {
+		return -1, errors.New("Passing input to commands is not supported by LXD runtime")
+	}
+
+	env := make(map[string]string)
+	if !options.ClearEnv {
+		options.EnvToKeep = nil
+		for _, kv := range os.Environ() {
+			options.EnvToKeep = append(options.EnvToKeep, strings.SplitN(kv, "=", 2)[0])
+		}
+	}
+	for _, key := range options.EnvToKeep {
+		if value, ok := os.LookupEnv(key); ok {
+			env[key] = value
+		}
+	}
+	for _, kv := range options.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	var result struct {
+		Return int               `json:"return"`
+		Output map[string]string `json:"output"`
+	}
+	err := l.request("POST", "/1.0/containers/"+url.PathEscape(name)+"/exec", map[string]interface{}{
+		"command":            command,
+		"environment":        env,
+		"wait-for-websocket": false,
+		"interactive":        false,
+		"record-output":      true,
+		"user":               options.UID,
+		"group":              options.GID,
+		"cwd":                options.Cwd,
+	}, &result)
+	if err != nil {
+		return -1, err
+	}
+
+	for fd, writer := range map[string]io.Writer{"1": options.Stdout, "2": options.Stderr} {
+		logFile, ok := result.Output[fd]
+		if !ok {
+			continue
+		}
+		if writer != nil {
+			if reader, err := l.do("GET", logFile, nil); !log.Check(log.DebugLevel, "Reading command output", err) {
+				io.Copy(writer, reader)
+				reader.Close()
+			}
+		}
+		if reader, err := l.do("DELETE", logFile, nil); err == nil {
+			reader.Close()
+		}
+	}
+
+	return result.Return, nil
+}
+
+// Attach relies on lxc client shipped with LXD since interactive sessions require websockets
+func (lxdRuntime) Attach(name string, command []string, env []string) error {
+	args := []string{"exec", name}
+	for _, kv := range env {
+		args = append(args, "--env", kv)
+	}
+	if len(command) == 0 {
+		command = []string{"/bin/bash", "--login"}
+	}
+	args = append(append(args, "--"), command...)
+
+	cmd := exec.Command("lxc", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// CgroupItem maps cgroup items used by agent to LXD limits and usage counters of LXD state, values are in form
+// of cgroup v1 items, other items are not supported
+func (l lxdRuntime) CgroupItem(name, key string) string {
+	switch key {
+	case "cpuacct.usage", "memory.usage_in_bytes", "memory.max_usage_in_bytes":
+		state, err := l.state(name)
+		if log.Check(log.DebugLevel, "Getting state of container "+name, err) {
+			return ""
+		}
+		switch key {
+		case "cpuacct.usage":
+			return strconv.FormatInt(state.CPU.Usage, 10)
+		case "memory.usage_in_bytes":
+			return strconv.FormatInt(state.Memory.Usage, 10)
+		}
+		return strconv.FormatInt(state.Memory.UsagePeak, 10)
+	}
+
+	limits, err := l.limits(name)
+	if log.Check(log.DebugLevel, "Getting limits of container "+name, err) {
+		return ""
+	}
+
+	switch key {
+	case "memory.limit_in_bytes":
+		return lxdBytes(limits[lxdMemory])
+	case "cpu.cfs_quota_us":
+		//allowance is set as "<quota>ms/100ms" by SetCgroupItem
+		allowance := strings.Split(limits[lxdCpuAllowance], "ms/")
+		if ms, err := strconv.Atoi(allowance[0]); err == nil && len(allowance) == 2 {
+			return strconv.Itoa(ms * 1000)
+		}
+		return "-1"
+	case "cpuset.cpus":
+		return limits[lxdCpuset]
+	}
+
+	return ""
+}
+
+// lxdBytes converts LXD size, e.g. 512MB or 2GiB, to bytes. No limit is reported as huge limit the way
+// cgroup v1 does
+func lxdBytes(size string) string {
+	size = strings.TrimSpace(size)
+	if size == "" {
+		return "9223372036854771712"
+	}
+
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"kB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+		{"B", 1},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(size, unit.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(size, unit.suffix)), 10, 64)
+			if err != nil {
+				return size
+			}
+			return strconv.FormatInt(n*unit.multiplier, 10)
+		}
+	}
+
+	return size
+}
+
+func (l lxdRuntime) SetCgroupItem(name, key, value string) error {
+	var limit string
+	switch key {
+	case "memory.limit_in_bytes":
+		limit = lxdMemory
+	case "cpu.cfs_quota_us":
+		limit = lxdCpuAllowance
+		quota, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		value = strconv.Itoa(quota/1000) + "ms/" + strconv.Itoa(cfsPeriodMs) + "ms"
+	case "cpuset.cpus":
+		limit = lxdCpuset
+	default:
+		return errors.Errorf("Cgroup item %s is not supported by LXD runtime", key)
+	}
+
+	return l.request("PATCH", "/1.0/containers/"+url.PathEscape(name), map[string]interface{}{
+		"config": map[string]string{limit: value},
+	}, nil)
+}
+
+func (l lxdRuntime) IPAddresses(name, iface string) ([]string, error) {
+	state, err := l.state(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, addr := range state.Network[iface].Addresses {
+		if addr.Family == "inet" {
+			ips = append(ips, addr.Address)
+		}
+	}
+	return ips, nil
+}
+
+func (l lxdRuntime) InitPid(name string) int {
+	state, err := l.state(name)
+	if err != nil || state.Pid == 0 {
+		return -1
+	}
+	return state.Pid
+}
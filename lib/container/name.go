@@ -0,0 +1,63 @@
+package container
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+var (
+	//labels must follow the rules for ARPANET host names: start with a letter, end with a letter or digit,
+	//have only letters, digits and hyphens inside and be 63 characters or less
+	hostnameRx = regexp.MustCompile(`^[[:alpha:]]([[:alnum:]-]{0,61}[[:alnum:]])?$`)
+	//characters allowed by ZFS in dataset and snapshot name components
+	datasetComponentRx = regexp.MustCompile(`^[[:alnum:]_.:-]+$`)
+)
+
+// ValidateName checks that name may be used for container, VM or template: it must be a valid host name
+// and must not collide with partition datasets since instance name is a dataset path component
+func ValidateName(name string) error {
+	if !hostnameRx.MatchString(name) {
+		return errors.Errorf("Invalid name %s, it must start with a letter, end with a letter or digit, "+
+			"contain only letters, digits and hyphens and be 63 characters or less", name)
+	}
+
+	for _, partition := range fs.ChildDatasets {
+		if strings.EqualFold(name, partition) {
+			return errors.Errorf("Name %s is reserved for container partition", name)
+		}
+	}
+
+	return nil
+}
+
+// ValidateNewName checks that name may be given to instance created by user, in addition to ValidateName
+// it rejects names reserved for agent's own instances
+func ValidateNewName(name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	if IsReservedName(name) {
+		return errors.Errorf("Name %s is reserved", name)
+	}
+
+	return nil
+}
+
+// IsReservedName tells if name belongs to management container or template
+func IsReservedName(name string) bool {
+	return strings.EqualFold(name, Management) || strings.EqualFold(name, ManagementTemplate)
+}
+
+// ValidateDatasetComponent checks that value, e.g. snapshot label or temporary template name,
+// contains only characters ZFS accepts in a single dataset name component
+func ValidateDatasetComponent(value string) error {
+	if value == "." || value == ".." || !datasetComponentRx.MatchString(value) {
+		return errors.Errorf("Invalid value %s, only letters, digits and _.:- are allowed", value)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package container
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/log"
+)
+
+// numaPolicy is config item holding NUMA placement policy of container
+const numaPolicy = "subutai.numa"
+
+const (
+	// NUMAAuto binds container to NUMA node having enough cores and memory for its quotas
+	NUMAAuto = "auto"
+	// NUMANone lets container use cores and memory of all nodes
+	NUMANone = "none"
+)
+
+// QuotaNUMA sets NUMA placement policy of container and returns nodes container is bound to.
+// Policy is either id of node, "auto" to pick node with the most free memory among nodes fitting cpu and ram
+// quotas of container, or "none" to release container. Cores and memory of bound container are restricted
+// to the node, so large containers, e.g. databases, do not access memory of remote node
+func QuotaNUMA(name string, policy string) (string, error) {
+	rt := GetRuntime()
+	if policy == "" {
+		return rt.CgroupItem(name, "cpuset.mems"), nil
+	}
+
+	nodes, err := CPUTopology()
+	if err != nil {
+		return "", err
+	}
+
+	if policy == NUMANone {
+		var cores, ids []int
+		for _, node := range nodes {
+			cores = append(cores, node.Cores...)
+			ids = append(ids, node.Id)
+		}
+		//running container keeps node till restart if cgroup can not be changed
+		log.Check(log.DebugLevel, "Setting cpuset.cpus", rt.SetCgroupItem(name, "cpuset.cpus", FormatCPUList(cores)))
+		log.Check(log.DebugLevel, "Setting cpuset.mems", rt.SetCgroupItem(name, "cpuset.mems", FormatCPUList(ids)))
+
+		conf := append([][]string{{numaPolicy, ""}}, CgroupConf("cpuset.cpus", "")...)
+		return FormatCPUList(ids), SetContainerConf(name, append(conf, CgroupConf("cpuset.mems", "")...))
+	}
+
+	var node *NumaNode
+	if policy == NUMAAuto {
+		if node, err = fittingNode(name, nodes); err != nil {
+			return "", err
+		}
+	} else {
+		id, err := strconv.Atoi(policy)
+		if err != nil {
+			return "", errors.Errorf("Invalid NUMA policy %s, node id, %s or %s expected", policy, NUMAAuto, NUMANone)
+		}
+		for i := range nodes {
+			if nodes[i].Id == id {
+				node = &nodes[i]
+			}
+		}
+		if node == nil {
+			return "", errors.Errorf("NUMA node %d not found", id)
+		}
+	}
+
+	//binding replaces automatic cpuset, the balancer counts cores of node as pinned
+	cpus, mems := FormatCPUList(node.Cores), strconv.Itoa(node.Id)
+	if State(name) == Running {
+		if err = rt.SetCgroupItem(name, "cpuset.cpus", cpus); err != nil {
+			return "", err
+		}
+		if err = rt.SetCgroupItem(name, "cpuset.mems", mems); err != nil {
+			return "", err
+		}
+	}
+
+	conf := append([][]string{{numaPolicy, policy}, {cpusetMode, ""}}, CgroupConf("cpuset.cpus", cpus)...)
+	return mems, SetContainerConf(name, append(conf, CgroupConf("cpuset.mems", mems)...))
+}
+
+// NUMAPolicy returns NUMA placement policy of container, empty if it is not set
+func NUMAPolicy(name string) string {
+	return GetProperty(name, numaPolicy)
+}
+
+// fittingNode returns node with the most free memory among nodes having enough cores for cpu quota
+// and enough memory for ram quota of container
+func fittingNode(name string, nodes []NumaNode) (*NumaNode, error) {
+	rt := GetRuntime()
+
+	cores := 1
+	if quota, err := strconv.Atoi(rt.CgroupItem(name, "cpu.cfs_quota_us")); err == nil && quota > 0 {
+		cores = int(math.Ceil(float64(quota) / (cfsPeriodMs * 1000)))
+	}
+	//unlimited memory is reported as huge limit
+	ram, err := strconv.ParseUint(rt.CgroupItem(name, "memory.limit_in_bytes"), 10, 64)
+	if err != nil || ram > math.MaxInt64/2 {
+		ram = 0
+	}
+
+	var best *NumaNode
+	var bestFree uint64
+	for i := range nodes {
+		if len(nodes[i].Cores) < cores {
+			continue
+		}
+		total, free, err := nodeMemory(nodes[i].Id)
+		if err != nil {
+			return nil, err
+		}
+		if total > 0 && ram > total {
+			continue
+		}
+		if best == nil || free > bestFree {
+			best, bestFree = &nodes[i], free
+		}
+	}
+	if best == nil {
+		return nil, errors.Errorf("No NUMA node has %d cores and %d Mb of memory for container %s",
+			cores, ram/1024/1024, name)
+	}
+
+	return best, nil
+}
+
+// nodeMemory returns total and free memory of NUMA node in bytes, zeros if kernel exposes no nodes
+func nodeMemory(id int) (total, free uint64, err error) {
+	file, err := os.Open(path.Join("/sys/devices/system/node", "node"+strconv.Itoa(id), "meminfo"))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	//lines look like "Node 0 MemTotal:       16307508 kB"
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[2] {
+		case "MemTotal:":
+			total = value * 1024
+		case "MemFree:":
+			free = value * 1024
+		}
+	}
+
+	return total, free, scanner.Err()
+}
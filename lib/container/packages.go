@@ -0,0 +1,94 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/db"
+)
+
+// packagesScript prints name of package manager found in container followed by installed packages,
+// one per line in form name<TAB>version<TAB>arch
+const packagesScript = `
+if command -v dpkg-query >/dev/null 2>&1; then
+	echo dpkg
+	dpkg-query -W -f '${db:Status-Status}\t${Package}\t${Version}\t${Architecture}\n' | sed -n 's/^installed\t//p'
+elif command -v rpm >/dev/null 2>&1; then
+	echo rpm
+	rpm -qa --qf '%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\n'
+elif [ -f /lib/apk/db/installed ]; then
+	echo apk
+	awk -F: '/^P:/{p=$2} /^V:/{v=$2} /^A:/{a=$2} /^$/{if(p!="")print p"\t"v"\t"a; p=""} END{if(p!="")print p"\t"v"\t"a}' /lib/apk/db/installed
+else
+	exit 3
+fi
+`
+
+// ListPackages returns package manager and packages installed in running container, dpkg, rpm and apk are supported
+func ListPackages(name string) (string, []db.Package, error) {
+	if !LxcInstanceExists(name) {
+		return "", nil, errors.New("Container does not exist")
+	}
+
+	rt := GetRuntime()
+	if state := rt.State(name); state != Running {
+		return "", nil, errors.New("Container is " + state)
+	}
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := rt.Exec(name, []string{"/bin/sh", "-c", packagesScript}, ExecOptions{Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		return "", nil, err
+	}
+	if exitCode == 3 {
+		return "", nil, errors.New("No supported package manager found in container")
+	} else if exitCode != 0 {
+		return "", nil, errors.Errorf("Listing packages failed with exit code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+
+	manager, err := stdout.ReadString('\n')
+	if err != nil {
+		return "", nil, errors.New("Listing packages returned no output")
+	}
+	packages, err := ParsePackages(&stdout)
+
+	return strings.TrimSpace(manager), packages, err
+}
+
+// ParsePackages reads package list in form written by WritePackages, packages are sorted by name
+func ParsePackages(input io.Reader) ([]db.Package, error) {
+	var packages []db.Package
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		pkg := db.Package{Name: fields[0], Version: fields[1]}
+		if len(fields) > 2 {
+			pkg.Arch = fields[2]
+		}
+		packages = append(packages, pkg)
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+
+	return packages, scanner.Err()
+}
+
+// WritePackages writes package list, one package per line in form name<TAB>version<TAB>arch
+func WritePackages(output io.Writer, packages []db.Package) error {
+	w := bufio.NewWriter(output)
+	for _, pkg := range packages {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", pkg.Name, pkg.Version, pkg.Arch); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,108 @@
+package container
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/log"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
+)
+
+// pty is pseudo terminal commands run in when they are attached to terminal of calling process
+type pty struct {
+	master *os.File
+	slave  *os.File
+}
+
+// openPty allocates pseudo terminal sized as terminal of calling process
+func openPty() (*pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening /dev/ptmx")
+	}
+
+	unlock := int32(0)
+	if err = ioctl(master.Fd(), unix.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, errors.Wrap(err, "unlocking pseudo terminal")
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, errors.Wrap(err, "reading pseudo terminal number")
+	}
+
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, errors.Wrap(err, "opening pseudo terminal")
+	}
+
+	p := &pty{master: master, slave: slave}
+	p.resize()
+
+	return p, nil
+}
+
+// resize sets size of pseudo terminal to size of terminal of calling process
+func (p *pty) resize() {
+	size, err := unix.IoctlGetWinsize(int(os.Stdin.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return
+	}
+	log.Check(log.DebugLevel, "Resizing pseudo terminal", ioctl(p.slave.Fd(), unix.TIOCSWINSZ, unsafe.Pointer(size)))
+}
+
+// ioctl performs request on fd taking pointer argument, which x/sys/unix does not export for pseudo terminals
+func ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// attach connects pseudo terminal to terminal of calling process switched to raw mode, so keys are passed
+// to command as they are typed. Returned function waits for output of command and restores the terminal
+func (p *pty) attach() func() {
+	var state *terminal.State
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		var err error
+		state, err = terminal.MakeRaw(int(os.Stdin.Fd()))
+		log.Check(log.DebugLevel, "Switching terminal to raw mode", err)
+	}
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			p.resize()
+		}
+	}()
+
+	//copying of input ends with process since read of terminal can not be interrupted
+	go io.Copy(p.master, os.Stdin)
+
+	done := make(chan struct{})
+	go func() {
+		//read fails once all copies of slave are closed
+		io.Copy(os.Stdout, p.master)
+		close(done)
+	}()
+
+	return func() {
+		p.slave.Close()
+		<-done
+		p.master.Close()
+		signal.Stop(winch)
+		close(winch)
+		if state != nil {
+			log.Check(log.DebugLevel, "Restoring terminal", terminal.Restore(int(os.Stdin.Fd()), state))
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/net"
+)
+
+// cfsPeriod is period of CFS bandwidth control in microseconds cpu quota is relative to
+const cfsPeriod = 100000
+
+// cgroup memory limit value meaning "no limit"
+const unlimitedMemory = 9223372036854771712
+
+// Quota holds resource limits of container. CPU, RAM, Disk and Net of 0 mean no limit, CPUSet holds cores
+// container may run on, e.g. 0-3,6, or auto if cores are assigned by agent
+type Quota struct {
+	//percents of all cores
+	CPU int
+	//Mb
+	RAM int
+	//Gb
+	Disk int
+	//empty for stopped container without cpuset, i.e. with all cores
+	CPUSet string
+	//Kbps of traffic sent by container
+	Net int
+}
+
+// SetQuota sets limits of container to quota, e.g. as returned by GetQuota with changed fields. Only limits
+// differing from current ones are set, empty CPUSet leaves cores unchanged. Limits of stopped container are
+// saved to its config and applied when it starts. The first failure stops setting, limits set before it are kept
+func SetQuota(name string, quota Quota) error {
+	current, err := GetQuota(name)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range []int{quota.CPU, quota.RAM, quota.Disk, quota.Net} {
+		if l < 0 {
+			return errors.Errorf("Invalid quota %d, non-negative value expected", l)
+		}
+	}
+
+	if quota.CPU != current.CPU {
+		if err = setCPUQuota(name, quota.CPU); err != nil {
+			return errors.Wrap(err, "setting cpu quota")
+		}
+	}
+	if quota.RAM != current.RAM {
+		if err = setRAMQuota(name, quota.RAM); err != nil {
+			return errors.Wrap(err, "setting ram quota")
+		}
+	}
+	if quota.Disk != current.Disk {
+		if err = fs.SetQuota(name, quota.Disk); err != nil {
+			return errors.Wrap(err, "setting disk quota")
+		}
+	}
+	if quota.CPUSet != "" && quota.CPUSet != current.CPUSet {
+		if err = setCPUsetQuota(name, quota.CPUSet); err != nil {
+			return errors.Wrap(err, "setting cpuset")
+		}
+	}
+	if quota.Net != current.Net {
+		if err = setNetQuota(name, quota.Net); err != nil {
+			return errors.Wrap(err, "setting network quota")
+		}
+	}
+
+	return nil
+}
+
+// GetQuota returns limits of container, limits of stopped container are read from its config
+func GetQuota(name string) (quota Quota, err error) {
+	if !LxcInstanceExists(name) {
+		return quota, errors.Errorf("Container %s not found", name)
+	}
+	running := State(name) == Running
+	rt := GetRuntime()
+
+	//cgroup holds -1 if cpu is not limited
+	value := CgroupProperty(name, cpuQuotaItem)
+	if running {
+		value = rt.CgroupItem(name, cpuQuotaItem)
+	}
+	if value != "" {
+		us, err := strconv.Atoi(value)
+		if err != nil {
+			return quota, errors.Wrap(err, "parsing cpu quota")
+		}
+		if us > 0 {
+			quota.CPU = us * 100 / cfsPeriod / runtime.NumCPU()
+		}
+	}
+
+	if running {
+		limit, err := strconv.ParseInt(rt.CgroupItem(name, memoryLimitItem), 10, 64)
+		if err != nil {
+			return quota, errors.Wrap(err, "parsing ram quota")
+		}
+		if limit < unlimitedMemory {
+			quota.RAM = int(limit / 1024 / 1024)
+		}
+	} else if value := CgroupProperty(name, memoryLimitItem); value != "" {
+		limit, err := fs.ConvertToBytes(value)
+		if err != nil {
+			return quota, errors.Wrap(err, "parsing ram quota")
+		}
+		quota.RAM = int(limit / 1024 / 1024)
+	}
+
+	disk, err := fs.GetQuota(name)
+	if err != nil {
+		return quota, errors.Wrap(err, "reading disk quota")
+	}
+	quota.Disk = disk / 1024 / 1024 / 1024
+
+	switch {
+	case IsCPUsetAuto(name):
+		quota.CPUSet = CPUsetAuto
+	case running:
+		quota.CPUSet = rt.CgroupItem(name, "cpuset.cpus")
+	default:
+		quota.CPUSet = CgroupProperty(name, "cpuset.cpus")
+	}
+
+	value = GetProperty(name, "subutai.network.ratelimit")
+	if running {
+		value = net.RateLimit(vethPair(name), "")
+	}
+	//ovs reports rate of interface without limit as 0
+	if kbps, err := strconv.Atoi(value); err == nil {
+		quota.Net = kbps
+	}
+
+	return quota, nil
+}
+
+func setCPUQuota(name string, percent int) error {
+	value := ""
+	if percent > 0 {
+		value = strconv.Itoa(cfsPeriod * runtime.NumCPU() * percent / 100)
+	}
+	if State(name) == Running {
+		limit := value
+		if limit == "" {
+			limit = "-1"
+		}
+		if err := GetRuntime().SetCgroupItem(name, cpuQuotaItem, limit); err != nil {
+			return err
+		}
+	}
+
+	return SetContainerConf(name, CgroupConf(cpuQuotaItem, value))
+}
+
+func setRAMQuota(name string, mb int) error {
+	if State(name) == Running {
+		limit := "-1"
+		if mb > 0 {
+			limit = strconv.Itoa(mb * 1024 * 1024)
+		}
+		if err := GetRuntime().SetCgroupItem(name, memoryLimitItem, limit); err != nil {
+			return err
+		}
+	}
+
+	value := ""
+	if mb > 0 {
+		value = strconv.Itoa(mb) + "M"
+	}
+	return SetContainerConf(name, CgroupConf(memoryLimitItem, value))
+}
+
+//with "auto" cores are assigned by agent and rebalanced periodically, see CPUBalancer
+func setCPUsetQuota(name string, cores string) error {
+	if cores == CPUsetAuto {
+		//balancer keeps container within a single node where possible, so binding to node is dropped
+		if err := SetContainerConf(name, [][]string{{cpusetMode, CPUsetAuto}, {numaPolicy, ""}}); err != nil {
+			return err
+		}
+		return new(CPUBalancer).Rebalance()
+	}
+
+	if State(name) == Running {
+		if err := GetRuntime().SetCgroupItem(name, "cpuset.cpus", cores); err != nil {
+			return err
+		}
+	}
+	if err := SetContainerConf(name, CgroupConf("cpuset.cpus", cores)); err != nil {
+		return err
+	}
+	if IsCPUsetAuto(name) {
+		releaseCPUset(name)
+	}
+
+	return nil
+}
+
+func setNetQuota(name string, kbps int) error {
+	value := ""
+	if kbps > 0 {
+		value = strconv.Itoa(kbps)
+	}
+	if State(name) == Running {
+		nic := vethPair(name)
+		if nic == "" {
+			return errors.Errorf("Container %s has no network interface", name)
+		}
+		if err := net.SetRateLimit(nic, kbps); err != nil {
+			return err
+		}
+	}
+
+	return SetContainerConf(name, [][]string{{"subutai.network.ratelimit", value}})
+}
+
+// vethPair returns host side of veth interface of container
+func vethPair(name string) string {
+	if common.GetMajorVersion() < 3 {
+		return GetProperty(name, "lxc.network.veth.pair")
+	}
+	return GetProperty(name, "lxc.net.0.veth.pair")
+}
+
+// cpuPercent converts CPU quota in MHz to percents of all cores
+func cpuPercent(mhz int) (int, error) {
+	out, err := ioutil.ReadFile("/sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq")
+	freq, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+	freq = freq / 1000
+	if err != nil || freq <= 0 {
+		out, err := ioutil.ReadFile("/proc/cpuinfo")
+		if err != nil {
+			return 0, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "cpu MHz") {
+				fields := strings.Split(scanner.Text(), ":")
+				freq, _ = strconv.Atoi(strings.TrimSpace(strings.Split(fields[len(fields)-1], ".")[0]))
+				break
+			}
+		}
+	}
+	if freq <= 0 {
+		return 0, errors.New("Failed to detect CPU frequency")
+	}
+
+	return mhz * 100 / freq / runtime.NumCPU(), nil
+}
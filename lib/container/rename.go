@@ -0,0 +1,108 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// Rename renames stopped container in place: its datasets are renamed along with snapshots, paths of rootfs and
+// bind mounts in config and fstab are rewritten, uts name and hostname follow the new name unless they were
+// changed, and metadata in db moves to the new name. Mac address and ip addresses are kept, so port mappings
+// and proxies, which refer to addresses of container, keep working. Steps done before a failure are undone
+func Rename(name, newName string) error {
+	if name == Management || newName == Management {
+		return errors.New("Management container can not be renamed")
+	}
+	if err := ValidateName(newName); err != nil {
+		return err
+	}
+
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: name},
+		common.LockKey{Kind: common.ContainerLock, Name: newName})
+	defer lock.Release()
+
+	if !IsContainer(name) {
+		return errors.Errorf("Container %s not found", name)
+	}
+	if LxcInstanceExists(newName) || fs.DatasetExists(newName) {
+		return errors.Errorf("Container or template %s already exists", newName)
+	}
+	if State(name) != Stopped {
+		return errors.Errorf("Container %s must be stopped to be renamed", name)
+	}
+
+	renamedHostname := GetHostname(name) == name
+
+	//completed steps are undone in reverse order if a later one fails
+	var undo []func() error
+	rollback := func(err error) error {
+		for i := len(undo) - 1; i >= 0; i-- {
+			log.Check(log.WarnLevel, "Rolling back rename of "+name, undo[i]())
+		}
+		return err
+	}
+
+	if err := fs.RenameDataset(name, newName); err != nil {
+		return errors.Wrap(err, "renaming datasets")
+	}
+	undo = append(undo, func() error { return fs.RenameDataset(newName, name) })
+
+	if err := renameConfig(newName, name, newName); err != nil {
+		return rollback(err)
+	}
+	undo = append(undo, func() error { return renameConfig(newName, newName, name) })
+
+	if renamedHostname {
+		if err := fs.WriteFileInRoot(rootfs(newName), "etc/hostname", []byte(newName), 0644); err != nil {
+			return rollback(errors.Wrap(err, "writing /etc/hostname"))
+		}
+		undo = append(undo, func() error {
+			return fs.WriteFileInRoot(rootfs(newName), "etc/hostname", []byte(name), 0644)
+		})
+	}
+
+	if err := db.RenameContainer(name, newName); err != nil {
+		return rollback(errors.Wrap(err, "renaming container metadata"))
+	}
+
+	//container is renamed, stale hosts of its environment are not a reason to undo it
+	log.Check(log.WarnLevel, "Updating hosts of "+newName, updateHosts(newName))
+	return nil
+}
+
+// renameConfig rewrites paths of container in its config and fstab, found in directory of container dir, from
+// name to newName, uts name is changed if it is the old name
+func renameConfig(dir, name, newName string) error {
+	for _, file := range []string{"config", "fstab"} {
+		filePath := path.Join(config.Agent.LxcPrefix, dir, file)
+		data, err := ioutil.ReadFile(filePath)
+		if os.IsNotExist(err) && file == "fstab" {
+			continue
+		} else if err != nil {
+			return err
+		}
+		data = []byte(strings.Replace(string(data), path.Join(config.Agent.LxcPrefix, name)+"/",
+			path.Join(config.Agent.LxcPrefix, newName)+"/", -1))
+		if err = ioutil.WriteFile(filePath, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	uts := "lxc.uts.name"
+	if common.GetMajorVersion() < 3 {
+		uts = "lxc.utsname"
+	}
+	if GetProperty(dir, uts) == name {
+		return SetContainerConf(dir, [][]string{{uts, newName}})
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package container
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/templ"
+)
+
+// Container runtimes selectable in config
+const (
+	// raw liblxc through go-lxc bindings
+	RuntimeLxc = "lxc"
+	// LXD daemon through its REST API
+	RuntimeLxd = "lxd"
+)
+
+// ExecOptions describe how command is executed inside container
+type ExecOptions struct {
+	UID int
+	GID int
+	//working directory, container default if empty
+	Cwd string
+	//variables in form KEY=VALUE
+	Env []string
+	//clear host environment keeping only variables listed in EnvToKeep
+	ClearEnv  bool
+	EnvToKeep []string
+	//command gets no input if reader is nil
+	Stdin io.Reader
+	//output is discarded if writer is nil
+	Stdout io.Writer
+	Stderr io.Writer
+	//command runs in pseudo terminal connected to terminal of calling process instead of Stdin, Stdout and Stderr
+	Tty bool
+}
+
+// Runtime controls lifecycle, resource limits and command execution of containers.
+// Runtime tells templates from containers and clones containers from templates, since they are stored by
+// runtime, e.g. liblxc ones in datasets managed by agent and LXD ones in storage of LXD
+type Runtime interface {
+	// Names returns names of all containers and templates known to runtime
+	Names() []string
+	IsTemplate(name string) bool
+	IsContainer(name string) bool
+	// Clone creates container child from snapshot of template parent with the given label
+	Clone(parent templ.Ref, child, label string) error
+	// State returns container state: Running, Stopped or Unknown
+	State(name string) string
+	Start(name string) error
+	// Stop stops container immediately
+	Stop(name string) error
+	// Shutdown asks container init to stop and waits for it up to timeout
+	Shutdown(name string, timeout time.Duration) error
+	// Exec runs command inside running container and waits for its completion
+	Exec(name string, command []string, options ExecOptions) (exitCode int, err error)
+	// Attach runs command inside container attached to the current terminal, interactive shell if command is empty
+	Attach(name string, command []string, env []string) error
+	// CgroupItem returns value of cgroup item, e.g. memory.limit_in_bytes
+	CgroupItem(name, key string) string
+	SetCgroupItem(name, key, value string) error
+	IPAddresses(name, iface string) ([]string, error)
+	// InitPid returns host pid of container init process, -1 if container is not running
+	InitPid(name string) int
+}
+
+var (
+	lxcDriver Runtime = lxcRuntime{}
+	lxdDriver Runtime = lxdRuntime{}
+)
+
+//runtime set with SetRuntime, it takes precedence over config
+var runtimeOverride Runtime
+
+// GetRuntime returns container runtime configured for this host
+func GetRuntime() Runtime {
+	if runtimeOverride != nil {
+		return runtimeOverride
+	}
+
+	if config.Agent.Runtime == RuntimeLxd {
+		return lxdDriver
+	}
+
+	return lxcDriver
+}
+
+// SetRuntime replaces configured runtime, e.g. with FakeRuntime to run agent logic without LXC.
+// Passing nil restores configured runtime
+func SetRuntime(r Runtime) {
+	runtimeOverride = r
+}
+
+// pipeTo returns write end of pipe which content is copied to writer.
+// Caller must close returned file and wait for wg to make sure all output is copied
+func pipeTo(writer io.Writer, wg *sync.WaitGroup) (*os.File, error) {
+	if writer == nil {
+		writer = ioutil.Discard
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer r.Close()
+		io.Copy(writer, r)
+	}()
+
+	return w, nil
+}
+
+// pipeFrom returns read end of pipe fed with content of reader, or null device if reader is nil.
+// Copying blocks while command does not consume its input, so large streams are not buffered in memory.
+// Caller must close returned file once command exits, copying of input left unread stops then
+func pipeFrom(reader io.Reader) (*os.File, error) {
+	if reader == nil {
+		return os.Open(os.DevNull)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer w.Close()
+		io.Copy(w, reader)
+	}()
+
+	return r, nil
+}
@@ -0,0 +1,101 @@
+package container
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// UnitName returns name of transient systemd scope of container, e.g. subutai-container-foo.scope
+func UnitName(name string) string {
+	return "subutai-container-" + name + ".scope"
+}
+
+// RegisterUnit places init process of running container into transient systemd scope, so that processes
+// of container are attributed to it in journald and systemd accounting. Scope is created by agent on every
+// start of container and disappears when container stops, it is never used to control container.
+// Nothing is done unless scopes are enabled in [systemd] section of agent config
+func RegisterUnit(name string) error {
+	if !config.Systemd.Enabled {
+		return nil
+	}
+
+	pid := GetRuntime().InitPid(name)
+	if pid <= 0 {
+		return errors.Errorf("Container %s is not running", name)
+	}
+
+	unit := UnitName(name)
+	if unitActive(unit) {
+		return nil
+	}
+	//failed scope left by previous run of container would prevent creation of new one
+	exec.ExecuteNoLog("systemctl", "reset-failed", unit)
+
+	args := []string{"call", "org.freedesktop.systemd1", "/org/freedesktop/systemd1",
+		"org.freedesktop.systemd1.Manager", "StartTransientUnit", "ssa(sv)a(sa(sv))", unit, "fail"}
+
+	props := [][]string{
+		{"PIDs", "au", "1", strconv.Itoa(pid)},
+		{"Description", "s", "Subutai container " + name},
+		//cgroups below scope belong to container runtime
+		{"Delegate", "b", "true"},
+	}
+	if config.Systemd.Slice != "" {
+		props = append(props, []string{"Slice", "s", config.Systemd.Slice})
+	}
+	if config.Systemd.Accounting {
+		props = append(props, []string{"CPUAccounting", "b", "true"}, []string{"MemoryAccounting", "b", "true"},
+			[]string{"TasksAccounting", "b", "true"})
+	}
+
+	args = append(args, strconv.Itoa(len(props)))
+	for _, prop := range props {
+		args = append(args, prop...)
+	}
+	//no auxiliary units
+	args = append(args, "0")
+
+	out, err := exec.Execute("busctl", args...)
+	if err != nil {
+		return errors.Errorf("Error creating systemd scope %s: %s %s", unit, out, err.Error())
+	}
+
+	return nil
+}
+
+// SyncUnit creates systemd scope of running container if it is missing, e.g. when container
+// was started by lxc autostart rather than by agent
+func SyncUnit(name string) error {
+	if !config.Systemd.Enabled || State(name) != Running {
+		return nil
+	}
+
+	return RegisterUnit(name)
+}
+
+// UnregisterUnit removes systemd scope of container, it is called when container is destroyed
+func UnregisterUnit(name string) {
+	if !config.Systemd.Enabled {
+		return
+	}
+
+	removeUnit(UnitName(name))
+}
+
+func unitActive(unit string) bool {
+	_, err := exec.ExecuteNoLog("systemctl", "is-active", "--quiet", unit)
+	return err == nil
+}
+
+// removeUnit stops scope if it is still active and clears its failed state, missing scope is not an error
+func removeUnit(unit string) {
+	if unitActive(unit) {
+		out, err := exec.Execute("systemctl", "stop", unit)
+		log.Check(log.WarnLevel, "Stopping systemd scope "+unit+" "+out, err)
+	}
+	exec.ExecuteNoLog("systemctl", "reset-failed", unit)
+}
@@ -0,0 +1,86 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// tmpfsItem is prefix of config items holding size of tmpfs mounted at directory of container in Mb
+const tmpfsItem = "subutai.tmpfs."
+
+// tmpfsModes are modes of directories of container which may be mounted as tmpfs
+var tmpfsModes = map[string]string{"tmp": "1777", "run": "755"}
+
+// QuotaTmpfs sets size in Mb of tmpfs mounted at /tmp or /run of container and returns it, so runaway files
+// do not consume memory of host. Size of tmpfs mounted in running container is changed at once, new tmpfs is
+// mounted and removed one is unmounted after restart
+func QuotaTmpfs(name, dir, size string) (int, error) {
+	current, _ := strconv.Atoi(GetProperty(name, tmpfsItem+dir))
+	if size == "" {
+		return current, nil
+	}
+
+	mode, ok := tmpfsModes[dir]
+	if !ok {
+		return current, errors.Errorf("Directory %s can not be mounted as tmpfs", dir)
+	}
+	quota, err := strconv.Atoi(size)
+	if err != nil || quota < 0 {
+		return current, errors.Errorf("Invalid tmpfs size %s, Mb expected", size)
+	}
+
+	entry, value := "", ""
+	if quota > 0 {
+		entry = fmt.Sprintf("tmpfs %s tmpfs rw,nosuid,nodev,mode=%s,size=%dM,create=dir 0 0", dir, mode, quota)
+		value = strconv.Itoa(quota)
+	}
+	if err = setFstabEntry(name, dir, entry); err != nil {
+		return current, err
+	}
+	if err = SetContainerConf(name, [][]string{{tmpfsItem + dir, value}}); err != nil {
+		return current, err
+	}
+
+	if quota > 0 && State(name) == Running {
+		pid := GetRuntime().InitPid(name)
+		if tmpfsMounted(pid, "/"+dir) {
+			//mount namespace of container is entered, since its root may not be allowed to remount
+			err = exec.Exec("nsenter", "-t", strconv.Itoa(pid), "-m", "--",
+				"mount", "-o", "remount,size="+value+"M", "/"+dir)
+			if err != nil {
+				return current, err
+			}
+		} else {
+			log.Info("tmpfs is mounted at /" + dir + " of " + name + " after restart")
+		}
+	}
+
+	return quota, nil
+}
+
+// tmpfsMounted returns true if tmpfs is mounted at target in mount namespace of process
+func tmpfsMounted(pid int, target string) bool {
+	if pid <= 0 {
+		return false
+	}
+	file, err := os.Open("/proc/" + strconv.Itoa(pid) + "/mounts")
+	if log.Check(log.DebugLevel, "Reading mounts of container", err) {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 2 && fields[1] == target && fields[2] == "tmpfs" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package container
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/templ"
+	"github.com/subutai-io/agent/log"
+)
+
+// partitions of container replaced by partitions of new template version on upgrade, home is kept so data
+// which must survive upgrades belongs there. Var holds data of services, e.g. databases and logs, so it is
+// kept too unless its replacement is asked for
+var upgradedPartitions = []string{"rootfs", "opt"}
+
+// partition which is replaced on upgrade only on demand
+const varPartition = "var"
+
+// suffix of partitions replaced on upgrade, they are kept for rollback until the next upgrade
+const previousSuffix = ".previous"
+
+// config item holding reference of template container was cloned from before upgrade
+const previousParentItem = "subutai.parent.previous"
+
+// Upgrade moves stopped container to another version of its template: rootfs and opt partitions, and var one
+// if replaceVar is set, are replaced with clones of installed template, home partition and container config are
+// kept. Setup done on clone, e.g. uid shifting, network, DNS and hostname, is redone on new partitions. Replaced
+// partitions are kept, along with their snapshots, until the next upgrade so RollbackUpgrade can bring them back
+func Upgrade(name string, ref templ.Ref, replaceVar bool) error {
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: name})
+	defer lock.Release()
+
+	if !IsContainer(name) {
+		return errors.Errorf("Container %s not found", name)
+	}
+	if State(name) == Running {
+		return errors.Errorf("Container %s must be stopped to be upgraded", name)
+	}
+	if !IsTemplate(ref.String()) {
+		return errors.Errorf("Template %s is not installed", ref.String())
+	}
+	partitions := upgradedPartitions
+	if replaceVar {
+		partitions = append(partitions[:len(partitions):len(partitions)], varPartition)
+	}
+	for _, partition := range partitions {
+		if snapshot := ref.String() + "/" + partition + "@now"; !fs.DatasetExists(snapshot) {
+			return errors.Errorf("Snapshot %s not found", snapshot)
+		}
+	}
+
+	current, err := ParentRef(name)
+	if err != nil {
+		return errors.Wrap(err, "reading template of "+name)
+	}
+	if current.Name != ref.Name || current.Owner != ref.Owner {
+		return errors.Errorf("Container %s is cloned from %s, not from %s", name, current.CdnString(),
+			templ.Ref{Name: ref.Name, Owner: ref.Owner}.CdnString())
+	}
+	if current == ref {
+		return errors.Errorf("Container %s is cloned from %s already", name, ref.CdnString())
+	}
+
+	//partitions replaced by previous upgrade are removed whether or not they are replaced this time
+	for _, partition := range append(upgradedPartitions[:len(upgradedPartitions):len(upgradedPartitions)], varPartition) {
+		previous := path.Join(name, partition+previousSuffix)
+		if fs.DatasetExists(previous) {
+			if err = Destroy(previous, false); err != nil {
+				return errors.Wrap(err, "removing partition replaced by previous upgrade")
+			}
+		}
+	}
+
+	//hostname is kept in rootfs which is replaced
+	hostname := GetHostname(name)
+
+	if err = swapPartitions(name, partitions, func(partition string) error {
+		return fs.CloneSnapshot(ref.String()+"/"+partition+"@now", path.Join(name, partition))
+	}, func(partition string) error {
+		return Destroy(path.Join(name, partition), false)
+	}); err != nil {
+		return err
+	}
+
+	if err = setupPartitions(name, partitions, hostname); err != nil {
+		return errors.Wrap(err, "setting up new partitions of "+name)
+	}
+
+	return setParent(name, ref, current.String())
+}
+
+// RollbackUpgrade brings back partitions and template of stopped container replaced by the last upgrade,
+// partitions of upgraded version are removed
+func RollbackUpgrade(name string) error {
+	lock := common.AcquireLocks(common.LockKey{Kind: common.ContainerLock, Name: name})
+	defer lock.Release()
+
+	if !IsContainer(name) {
+		return errors.Errorf("Container %s not found", name)
+	}
+	if State(name) == Running {
+		return errors.Errorf("Container %s must be stopped to roll back its upgrade", name)
+	}
+	previousRef := GetProperty(name, previousParentItem)
+	for _, partition := range upgradedPartitions {
+		if !fs.DatasetExists(path.Join(name, partition+previousSuffix)) {
+			previousRef = ""
+		}
+	}
+	//var is rolled back only if the last upgrade replaced it
+	partitions := upgradedPartitions
+	if fs.DatasetExists(path.Join(name, varPartition+previousSuffix)) {
+		partitions = append(partitions[:len(partitions):len(partitions)], varPartition)
+	}
+	if previousRef == "" {
+		return errors.Errorf("Container %s has no upgrade to roll back", name)
+	}
+	ref, err := templ.ParseFullRef(previousRef)
+	if err != nil {
+		return err
+	}
+
+	//partitions of upgraded version take place of previous ones and are removed once swap succeeds
+	for _, partition := range partitions {
+		if err = fs.RenameDataset(path.Join(name, partition+previousSuffix), path.Join(name, partition+".rollback")); err != nil {
+			return err
+		}
+	}
+	if err = swapPartitions(name, partitions, func(partition string) error {
+		return fs.RenameDataset(path.Join(name, partition+".rollback"), path.Join(name, partition))
+	}, func(partition string) error {
+		return fs.RenameDataset(path.Join(name, partition), path.Join(name, partition+".rollback"))
+	}); err != nil {
+		for _, partition := range partitions {
+			log.Check(log.WarnLevel, "Restoring previous partition "+partition, fs.RenameDataset(
+				path.Join(name, partition+".rollback"), path.Join(name, partition+previousSuffix)))
+		}
+		return err
+	}
+	for _, partition := range partitions {
+		if err = Destroy(path.Join(name, partition+previousSuffix), false); err != nil {
+			return errors.Wrap(err, "removing partition of upgraded version")
+		}
+	}
+
+	//hosts of environment may have changed since previous version was replaced
+	log.Check(log.WarnLevel, "Updating hosts of "+name, updateHosts(name))
+
+	return setParent(name, ref, "")
+}
+
+// swapPartitions moves partitions of container aside and creates new ones with create. If any of new ones can
+// not be created, created ones are removed with undo and moved partitions are restored
+func swapPartitions(name string, partitions []string, create, undo func(partition string) error) error {
+	var err error
+	var moved []string
+	for _, partition := range partitions {
+		dataset := path.Join(name, partition)
+		if err = fs.RenameDataset(dataset, dataset+previousSuffix); err != nil {
+			break
+		}
+		moved = append(moved, partition)
+		if err = create(partition); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	for _, partition := range moved {
+		dataset := path.Join(name, partition)
+		if fs.DatasetExists(dataset) {
+			log.Check(log.WarnLevel, "Removing new partition "+dataset, undo(partition))
+		}
+		log.Check(log.WarnLevel, "Restoring partition "+dataset, fs.RenameDataset(dataset+previousSuffix, dataset))
+	}
+	return errors.Wrap(err, "replacing partitions of "+name)
+}
+
+// setupPartitions redoes setup of clone on partitions of template which replaced partitions of container: files
+// are shifted to uid range of container and network, DNS, hostname and ssh settings of container are applied
+// to new rootfs
+func setupPartitions(name string, partitions []string, hostname string) error {
+	s, err := os.Stat(path.Join(config.Agent.LxcPrefix, name, "rootfs"))
+	if err != nil {
+		return err
+	}
+	parentuid := strconv.Itoa(int(s.Sys().(*syscall.Stat_t).Uid))
+	uid := GetContainerUID(name)
+	if parentuid != uid {
+		for _, partition := range partitions {
+			if err = exec.Exec("uidmapshift", "-b", path.Join(config.Agent.LxcPrefix, name, partition),
+				parentuid, uid, "65536"); err != nil {
+				return errors.Wrap(err, "shifting uids of "+partition)
+			}
+		}
+	}
+
+	SetStaticNet(name)
+	SetDNS(name)
+	DisableSSHPwd(name)
+	if err = fs.WriteFileInRoot(rootfs(name), "etc/hostname", []byte(hostname), 0644); err != nil {
+		return errors.Wrap(err, "writing /etc/hostname")
+	}
+
+	return updateHosts(name)
+}
+
+// setParent records template container is cloned from in its config and db record
+func setParent(name string, ref templ.Ref, previous string) error {
+	err := SetContainerConf(name, [][]string{
+		{"subutai.parent", ref.Name},
+		{"subutai.parent.owner", ref.Owner},
+		{"subutai.parent.version", ref.Version},
+		{"subutai.parent.snapshot"},
+		{previousParentItem, previous},
+	})
+	if err != nil {
+		return err
+	}
+
+	c, err := db.FindContainerByName(name)
+	if err != nil || c == nil {
+		return err
+	}
+	c.Template, c.TemplateOwner, c.TemplateVersion = ref.Name, ref.Owner, ref.Version
+	c.TemplateId = ""
+	if err = db.SaveContainer(c); err != nil {
+		return err
+	}
+
+	//inventory of replaced rootfs is stale
+	return db.RemovePackageInventory(name)
+}
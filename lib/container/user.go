@@ -0,0 +1,78 @@
+package container
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lookupScript prints entry of user or group database of container matching name or numeric id,
+// /etc files are read directly where getent is missing
+const lookupScript = `getent "$1" "$2" 2>/dev/null || awk -F: -v k="$2" '$1 == k || $3 == k { print; exit }' "/etc/$1"`
+
+// User is account of container commands are executed as
+type User struct {
+	Name string
+	UID  int
+	GID  int
+	Home string
+}
+
+// LookupUser resolves user and group inside running container, names and numeric ids are accepted.
+// Group defaults to primary group of user, empty user means root
+func LookupUser(name, user, group string) (User, error) {
+	result := User{Name: "root", Home: "/root"}
+
+	if user = strings.TrimSpace(user); user != "" {
+		fields, err := lookupEntry(name, "passwd", user)
+		if err != nil {
+			return result, err
+		}
+		if len(fields) < 6 {
+			return result, errors.Errorf("User %s not found in container %s", user, name)
+		}
+
+		result.Name, result.Home = fields[0], fields[5]
+		if result.UID, err = strconv.Atoi(fields[2]); err != nil {
+			return result, errors.Errorf("Invalid uid of user %s: %s", user, fields[2])
+		}
+		if result.GID, err = strconv.Atoi(fields[3]); err != nil {
+			return result, errors.Errorf("Invalid gid of user %s: %s", user, fields[3])
+		}
+	}
+
+	if group = strings.TrimSpace(group); group != "" {
+		fields, err := lookupEntry(name, "group", group)
+		if err != nil {
+			return result, err
+		}
+		if len(fields) < 3 {
+			return result, errors.Errorf("Group %s not found in container %s", group, name)
+		}
+
+		if result.GID, err = strconv.Atoi(fields[2]); err != nil {
+			return result, errors.Errorf("Invalid gid of group %s: %s", group, fields[2])
+		}
+	}
+
+	return result, nil
+}
+
+// lookupEntry returns fields of the first entry of database matching key, nil if there is none
+func lookupEntry(name, database, key string) ([]string, error) {
+	var stdout bytes.Buffer
+	_, err := GetRuntime().Exec(name, []string{"/bin/sh", "-c", lookupScript, "sh", database, key},
+		ExecOptions{Stdout: &stdout})
+	if err != nil {
+		return nil, err
+	}
+
+	line := strings.TrimSpace(strings.SplitN(stdout.String(), "\n", 2)[0])
+	if line == "" {
+		return nil, nil
+	}
+
+	return strings.Split(line, ":"), nil
+}
@@ -0,0 +1,154 @@
+package container
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	exec2 "github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// VmSpec describes KVM virtual machine created from disk image
+type VmSpec struct {
+	Name string
+	//path to qcow2 base image, VM disk is created as copy-on-write overlay on top of it
+	Image string
+	//RAM in MB
+	RAM  int
+	CPUs int
+	//use virtio disk and network, guest OS must have virtio drivers
+	Virtio bool
+}
+
+// domain defaults are compatible with Windows guests without additional drivers
+var domainTemplate = template.Must(template.New("domain").Parse(`<domain type='kvm'>
+  <name>{{.Name}}</name>
+  <memory unit='MiB'>{{.RAM}}</memory>
+  <vcpu>{{.CPUs}}</vcpu>
+  <os>
+    <type arch='x86_64'>hvm</type>
+    <boot dev='hd'/>
+  </os>
+  <features>
+    <acpi/>
+    <apic/>
+    <hyperv>
+      <relaxed state='on'/>
+      <vapic state='on'/>
+      <spinlocks state='on' retries='8191'/>
+    </hyperv>
+  </features>
+  <cpu mode='host-passthrough'/>
+  <clock offset='localtime'>
+    <timer name='hypervclock' present='yes'/>
+  </clock>
+  <on_poweroff>destroy</on_poweroff>
+  <on_reboot>restart</on_reboot>
+  <on_crash>restart</on_crash>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='{{.Disk}}'/>
+      <target dev='{{if .Virtio}}vda{{else}}sda{{end}}' bus='{{if .Virtio}}virtio{{else}}sata{{end}}'/>
+    </disk>
+    <interface type='network'>
+      <source network='{{.Network}}'/>
+      <model type='{{if .Virtio}}virtio{{else}}e1000{{end}}'/>
+    </interface>
+    <serial type='pty'/>
+    <console type='pty'/>
+    <input type='tablet' bus='usb'/>
+    <graphics type='vnc' autoport='yes' listen='127.0.0.1'/>
+    <video>
+      <model type='vga'/>
+    </video>
+  </devices>
+</domain>
+`))
+
+// VMs returns list of all KVM virtual machines
+func VMs() []string {
+	return VmRuntime().Names()
+}
+
+// IsVM checks if KVM virtual machine exists
+func IsVM(name string) bool {
+	for _, vm := range VMs() {
+		if vm == name {
+			return true
+		}
+	}
+	return false
+}
+
+func vmDisk(name string) string {
+	return path.Join(config.Agent.VmPrefix, name+".qcow2")
+}
+
+// CreateVM creates disk overlay on top of base image and defines libvirt domain for it
+func CreateVM(spec VmSpec) error {
+	if err := os.MkdirAll(config.Agent.VmPrefix, 0755); err != nil {
+		return err
+	}
+
+	disk := vmDisk(spec.Name)
+	if out, err := exec2.Execute("qemu-img", "create", "-f", "qcow2", "-F", "qcow2", "-b", spec.Image, disk); err != nil {
+		return errors.Errorf("Error creating disk of VM %s: %s %s", spec.Name, out, err.Error())
+	}
+
+	var domain bytes.Buffer
+	err := domainTemplate.Execute(&domain, struct {
+		VmSpec
+		Disk    string
+		Network string
+	}{spec, disk, config.Agent.VmNetwork})
+	if err != nil {
+		log.Check(log.WarnLevel, "Removing disk of VM "+spec.Name, os.Remove(disk))
+		return err
+	}
+
+	domainFile, err := ioutil.TempFile("", spec.Name+"-domain")
+	if err != nil {
+		log.Check(log.WarnLevel, "Removing disk of VM "+spec.Name, os.Remove(disk))
+		return err
+	}
+	defer os.Remove(domainFile.Name())
+
+	_, err = domainFile.Write(domain.Bytes())
+	log.Check(log.DebugLevel, "Closing domain file", domainFile.Close())
+	if err == nil {
+		_, err = virsh("define", domainFile.Name())
+	}
+	if err != nil {
+		log.Check(log.WarnLevel, "Removing disk of VM "+spec.Name, os.Remove(disk))
+		return err
+	}
+
+	return nil
+}
+
+// DestroyVM stops VM, removes its libvirt domain and disk overlay. Base image is kept
+func DestroyVM(name string) error {
+	rt := VmRuntime()
+
+	if rt.State(name) == Running {
+		if err := rt.Stop(name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := virsh("undefine", name, "--nvram"); err != nil {
+		return err
+	}
+
+	if err := os.Remove(vmDisk(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -1,65 +1,195 @@
+// Package exec runs external commands for agent. Every command is limited by timeout configured for it
+// in [timeouts] section of agent config and is killed together with processes it spawned once timeout expires.
+// Interactive sessions and long lived processes, e.g. attach or ssh tunnels, are run directly by their callers
 package exec
 
 import (
-	"os/exec"
-	"fmt"
 	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/log"
-	"os"
-	"io"
 )
 
-// executes command
-// returns stdout and nil if command executes successfully
-// returns stderr and error if command executes with error
-func ExecB(command string, args ...string) ([]byte, error) {
+// Options tune execution of external command
+type Options struct {
+	// Timeout overrides timeout configured for command, negative value disables it
+	Timeout time.Duration
+	// Env is appended to agent environment
+	Env   []string
+	Stdin io.Reader
+	// Stdout and Stderr receive output as it is produced, in addition to output captured in Result
+	Stdout io.Writer
+	Stderr io.Writer
+	// StreamStdout leaves stdout out of Result, e.g. when it is a stream too large to be kept in memory
+	StreamStdout bool
+	// Quiet suppresses logging of command line, e.g. when arguments hold secrets
+	Quiet bool
+}
 
-	log.Debug("Executing command " + command + " " + strings.Join(args, " "))
+// Result holds output captured from command
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Timeout returns timeout configured for command, 0 means command is not limited.
+// Subutai commands invoked by agent itself are not limited since they run external commands with own timeouts
+func Timeout(command string) time.Duration {
+	seconds := commandTimeout(filepath.Base(command))
+
+	switch filepath.Base(command) {
+	case "subutai":
+		seconds = 0
+	case "zfs":
+		seconds = config.Timeouts.Zfs
+	case "uidmapshift":
+		seconds = config.Timeouts.Uidmapshift
+	case "ipfs":
+		seconds = config.Timeouts.Ipfs
+	case "lxc-update-config":
+		seconds = config.Timeouts.LxcUpdateConfig
+	case "service":
+		seconds = config.Timeouts.Service
+	case "apt-get":
+		seconds = config.Timeouts.AptGet
+	case "gpg", "gpg1", "gpg2":
+		seconds = config.Timeouts.Gpg
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// commandTimeout returns seconds set for command in Commands of [timeouts] section, Default if it is not set there
+func commandTimeout(command string) int {
+	for _, pair := range strings.Split(config.Timeouts.Commands, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != command {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if log.Check(log.WarnLevel, "Parsing timeout of "+command, err) {
+			break
+		}
+		return seconds
+	}
+
+	return config.Timeouts.Default
+}
+
+// Run executes command, it is killed when its timeout expires or ctx is cancelled.
+// Error is returned if command can not be started, is killed or exits with non-zero code
+func Run(ctx context.Context, options Options, command string, args ...string) (Result, error) {
+	if !options.Quiet {
+		log.Debug("Executing command " + command + " " + strings.Join(args, " "))
+	}
+
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = Timeout(command)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
 
 	cmd := exec.Command(command, args...)
-	cmd.Env = os.Environ()
+	cmd.Env = append(os.Environ(), options.Env...)
+	cmd.Stdin = options.Stdin
+	cmd.Stdout = tee(&stdout, options.Stdout)
+	if options.StreamStdout {
+		cmd.Stdout = options.Stdout
+	}
+	cmd.Stderr = tee(&stderr, options.Stderr)
+	//own process group allows to kill processes spawned by command, e.g. by shell
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	var out bytes.Buffer
-	var stderr bytes.Buffer
+	result := Result{ExitCode: -1}
 
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
 
-	err := cmd.Run()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
 
-	if err != nil {
-		return []byte(fmt.Sprint(err) + ": " + stderr.String()), err
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		if err = ctx.Err(); err == context.DeadlineExceeded {
+			err = errors.Errorf("%s timed out after %s", command, timeout)
+		}
 	}
 
-	return out.Bytes(), nil
+	result.Stdout, result.Stderr = stdout.Bytes(), stderr.Bytes()
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	return result, err
+}
+
+// LookPath searches for executable in directories named by PATH
+func LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func tee(buffer *bytes.Buffer, writer io.Writer) io.Writer {
+	if writer == nil {
+		return buffer
+	}
+	return io.MultiWriter(buffer, writer)
 }
 
 // executes command
 // returns stdout and nil if command executes successfully
 // returns stderr and error if command executes with error
-func ExecuteNoLog(command string, args ...string) (string, error) {
+func ExecB(command string, args ...string) ([]byte, error) {
 
-	cmd := exec.Command(command, args...)
-	cmd.Env = os.Environ()
+	result, err := Run(context.Background(), Options{}, command, args...)
 
-	var out bytes.Buffer
-	var stderr bytes.Buffer
+	if err != nil {
+		return []byte(fmt.Sprint(err) + ": " + string(result.Stderr)), err
+	}
 
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
+	return result.Stdout, nil
+}
+
+// executes command
+// returns stdout and nil if command executes successfully
+// returns stderr and error if command executes with error
+func ExecuteNoLog(command string, args ...string) (string, error) {
 
-	err := cmd.Run()
+	result, err := Run(context.Background(), Options{Quiet: true}, command, args...)
 
 	if err != nil {
-		errMsg := stderr.String()
+		errMsg := string(result.Stderr)
 		if strings.TrimSpace(errMsg) == "" {
-			errMsg = out.String()
+			errMsg = string(result.Stdout)
 		}
 		return fmt.Sprint(err) + ": " + errMsg, err
 	}
 
-	return out.String(), nil
+	return string(result.Stdout), nil
 }
 
 // executes command
@@ -90,40 +220,51 @@ func Exec(command string, args ...string) error {
 	return err
 }
 
+// executes command
+// returns stdout and error if command executes with error, like Output of os/exec
+func Output(command string, args ...string) ([]byte, error) {
+
+	result, err := Run(context.Background(), Options{}, command, args...)
+
+	return result.Stdout, err
+}
+
+// executes command
+// returns stdout followed by stderr and error if command executes with error
+func CombinedOutput(command string, args ...string) ([]byte, error) {
+
+	result, err := Run(context.Background(), Options{}, command, args...)
+
+	return append(result.Stdout, result.Stderr...), err
+}
+
 // executes command and prints its output progressively
 // returns nil if command executes successfully
 // returns error if command executes with error
-func ExecuteOutput(command string, env map[string]string, args ... string) (string, error) {
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd := exec.Command(command, args...)
-	cmd.Env = os.Environ()
+func ExecuteOutput(command string, env map[string]string, args ...string) (string, error) {
+	var vars []string
 	for key, val := range env {
-		cmd.Env = append(cmd.Env, key+"="+val)
+		vars = append(vars, key+"="+val)
 	}
 
-	stdoutIn, _ := cmd.StdoutPipe()
-	stderrIn, _ := cmd.StderrPipe()
-
-	stdout := io.MultiWriter(os.Stdout, &stdoutBuf)
-	stderr := io.MultiWriter(os.Stderr, &stderrBuf)
-	err := cmd.Start()
+	result, err := Run(context.Background(), Options{Env: vars, Stdout: os.Stdout, Stderr: os.Stderr}, command, args...)
 	if err != nil {
 		return fmt.Sprint(err), err
 	}
 
-	go func() {
-		io.Copy(stdout, stdoutIn)
-	}()
+	return string(result.Stdout), nil
+}
 
-	go func() {
-		io.Copy(stderr, stderrIn)
-	}()
+// executes command feeding input to its stdin
+// returns stdout and nil if command executes successfully
+// returns stderr and error if command executes with error
+func ExecuteWithInput(input io.Reader, command string, args ...string) (string, error) {
+
+	result, err := Run(context.Background(), Options{Stdin: input}, command, args...)
 
-	err = cmd.Wait()
 	if err != nil {
-		return fmt.Sprint(err), err
+		return fmt.Sprint(err) + ": " + string(result.Stderr), err
 	}
 
-	outStr := string(stdoutBuf.Bytes())
-	return outStr, nil
+	return string(result.Stdout), nil
 }
@@ -0,0 +1,173 @@
+// Package fault injects failures at defined points of agent flows for resilience testing.
+// It is turned on by SUBUTAI_FAULTS environment variable holding comma separated list of points
+// in form point[=value], e.g. SUBUTAI_FAULTS="zfs-receive=0.5,cdn-drop,slow-io=2s".
+// For failure points value is probability of failure (1 if omitted), for slow-io it is delay added
+// to each IO operation (1s if omitted). Injection is off when variable is not set
+package fault
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/log"
+)
+
+// Injection points
+const (
+	// SlowIO delays reads of template downloads, zfs streams and file copies
+	SlowIO = "slow-io"
+	// ZfsReceive fails receiving of zfs streams
+	ZfsReceive = "zfs-receive"
+	// CdnDrop drops connection to CDN in the middle of response body
+	CdnDrop = "cdn-drop"
+	// NginxReload fails reload of nginx after proxy config changes
+	NginxReload = "nginx-reload"
+)
+
+const envVar = "SUBUTAI_FAULTS"
+
+//bytes of response body read before connection is dropped
+const dropAfter = 64 * 1024
+
+var (
+	once          sync.Once
+	probabilities map[string]float64
+	delay         time.Duration
+)
+
+func load() {
+	probabilities = make(map[string]float64)
+	spec := strings.TrimSpace(os.Getenv(envVar))
+	if spec == "" {
+		return
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	for _, item := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		point := kv[0]
+
+		if point == SlowIO {
+			delay = time.Second
+			if len(kv) == 2 {
+				d, err := time.ParseDuration(kv[1])
+				if log.Check(log.WarnLevel, "Parsing "+envVar+" delay "+kv[1], err) {
+					continue
+				}
+				delay = d
+			}
+		}
+
+		probability := 1.0
+		if len(kv) == 2 && point != SlowIO {
+			p, err := strconv.ParseFloat(kv[1], 64)
+			if log.Check(log.WarnLevel, "Parsing "+envVar+" probability "+kv[1], err) {
+				continue
+			}
+			probability = p
+		}
+		probabilities[point] = probability
+	}
+
+	log.Warn("Failure injection is enabled: " + spec)
+}
+
+// Active checks if failure should be injected at point now
+func Active(point string) bool {
+	once.Do(load)
+
+	probability, ok := probabilities[point]
+	return ok && rand.Float64() < probability
+}
+
+// Fail returns injected error if failure at point is active
+func Fail(point string) error {
+	if !Active(point) {
+		return nil
+	}
+
+	log.Warn("Injecting failure at " + point)
+	return errors.Errorf("Injected %s failure", point)
+}
+
+// Delay sleeps if slow IO is injected
+func Delay() {
+	if Active(SlowIO) {
+		time.Sleep(delay)
+	}
+}
+
+type slowReader struct {
+	io.Reader
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	Delay()
+	return r.Reader.Read(p)
+}
+
+// Reader wraps reader to delay each read if slow IO is injected
+func Reader(r io.Reader) io.Reader {
+	if !Active(SlowIO) {
+		return r
+	}
+	return slowReader{r}
+}
+
+type transport struct {
+	http.RoundTripper
+}
+
+type droppingBody struct {
+	io.ReadCloser
+	read int
+}
+
+func (b *droppingBody) Read(p []byte) (int, error) {
+	if b.read >= dropAfter {
+		log.Warn("Injecting failure at " + CdnDrop)
+		return 0, errors.Errorf("Injected %s failure: connection reset by peer", CdnDrop)
+	}
+	if len(p) > dropAfter-b.read {
+		p = p[:dropAfter-b.read]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if Active(CdnDrop) {
+		resp.Body = &droppingBody{ReadCloser: resp.Body}
+	}
+	if Active(SlowIO) {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{slowReader{resp.Body}, resp.Body}
+	}
+
+	return resp, nil
+}
+
+// Transport wraps CDN client transport to drop connections and slow down responses when injected
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return transport{rt}
+}
@@ -0,0 +1,92 @@
+// Package fips restricts agent to approved cryptographic algorithms when fips option of [crypto] section of
+// config is on: TLS connections use TLS 1.2 with AES-GCM cipher suites over NIST curves, downloads are only
+// accepted with sha256 digests and GPG signs messages with SHA-256 and refuses SHA-1 signatures.
+// Operations for which only unapproved algorithms are available fail instead of falling back to them
+package fips
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+)
+
+// Digest methods approved for verification of downloads
+var approvedDigests = []string{"sha256", "sha384", "sha512"}
+
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var curves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+func init() {
+	//requests made with default client, e.g. http.Head, are constrained too
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.TLSClientConfig = TLSConfig(t.TLSClientConfig)
+	}
+}
+
+// Enabled checks if agent is restricted to approved algorithms
+func Enabled() bool {
+	return config.Crypto.Fips
+}
+
+// TLSConfig constrains versions, cipher suites and curves of cfg if FIPS mode is on and returns it,
+// nil cfg is replaced with a new config
+func TLSConfig(cfg *tls.Config) *tls.Config {
+	if !Enabled() {
+		return cfg
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+
+	cfg.MinVersion = tls.VersionTLS12
+	//cipher suites of TLS 1.3 can not be chosen and include ChaCha20 which is not approved
+	cfg.MaxVersion = tls.VersionTLS12
+	cfg.CipherSuites = cipherSuites
+	cfg.CurvePreferences = curves
+
+	return cfg
+}
+
+// Transport constrains TLS of transport if FIPS mode is on, round trippers other than *http.Transport
+// are returned as is
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	if t, ok := rt.(*http.Transport); ok {
+		t.TLSClientConfig = TLSConfig(t.TLSClientConfig)
+	}
+	return rt
+}
+
+// CheckDigest returns error if downloads verified with digest method may not be accepted
+func CheckDigest(method string) error {
+	if !Enabled() {
+		return nil
+	}
+	for _, m := range approvedDigests {
+		if strings.EqualFold(m, method) {
+			return nil
+		}
+	}
+	if method == "" {
+		return errors.New("Digest is not published, FIPS mode requires sha256 digest")
+	}
+	return errors.Errorf("Digest method %s is not approved in FIPS mode, sha256 digest is required", method)
+}
+
+// GpgArgs returns options of gpg making it sign with SHA-256 and AES-256 and refuse SHA-1 signatures
+// if FIPS mode is on
+func GpgArgs() []string {
+	if !Enabled() {
+		return nil
+	}
+	return []string{"--digest-algo", "SHA256", "--cipher-algo", "AES256", "--s2k-digest-algo", "SHA256",
+		"--weak-digest", "SHA1"}
+}
@@ -0,0 +1,207 @@
+package fs
+
+import (
+	"io"
+
+	"github.com/subutai-io/agent/lib/fault"
+)
+
+// Driver manages datasets holding container and template filesystems.
+// Dataset and snapshot names are relative to root dataset, snapshots are named "dataset@snapshot".
+// Dataset is mounted at the same path relative to config.Agent.LxcPrefix
+type Driver interface {
+	IsDatasetReadOnly(dataset string) bool
+	SetDatasetReadOnly(dataset string) error
+	DatasetExists(dataset string) bool
+	// RemoveDataset removes dataset or snapshot, with recursive flag all children are removed too
+	RemoveDataset(dataset string, recursive bool) error
+	CreateDataset(dataset string) error
+	// RenameDataset renames dataset together with its children and snapshots
+	RenameDataset(dataset, newName string) error
+	// ListSnapshots returns table of snapshots of dataset and its children with header and creation time column
+	ListSnapshots(dataset string) (string, error)
+	// ListSnapshotNamesOnly returns full names of snapshots of dataset and its children, one per line
+	ListSnapshotNamesOnly(dataset string) (string, error)
+	RollbackToSnapshot(snapshot string, forceRollback bool) error
+	CreateSnapshot(snapshot string, recursive bool) error
+	CloneSnapshot(snapshot, dataset string) error
+	// ReceiveStream restores dataset from stream file written by SendStream
+	ReceiveStream(dataset, delta string, force bool) error
+	ReceiveStreamFrom(dataset string, stream io.Reader, force bool) error
+	// SendStream writes incremental stream between snapshots to delta file
+	SendStream(snapshotFrom, snapshotTo, delta string) error
+	// SendStreamTo writes incremental stream between snapshots to writer, non-empty token resumes
+	// interrupted stream instead
+	SendStreamTo(snapshotFrom, snapshotTo, token string, stream io.Writer) error
+	// EstimateStream returns size in bytes of incremental stream between snapshots without sending it
+	EstimateStream(snapshotFrom, snapshotTo string) (int64, error)
+	// ReceiveResumable receives stream to dataset keeping partially received state if stream is interrupted
+	ReceiveResumable(dataset string, stream io.Reader) error
+	// ResumeToken returns token to resume interrupted stream to dataset, empty if nothing is to resume
+	ResumeToken(dataset string) (string, error)
+	SetQuota(dataset string, quotaInGb int) error
+	GetQuota(dataset string) (int, error)
+	DatasetDiskUsage(dataset string) (int, error)
+	ListDatasetsUsage() ([]DatasetUsage, error)
+	GetPoolUsage() (size, allocated, free int64, err error)
+	// PoolHealth returns health of pool holding root dataset, e.g. ONLINE or DEGRADED
+	PoolHealth() (string, error)
+	ExpandPool() error
+	// PoolDevices returns paths of block devices of pool holding root dataset, e.g. /dev/sdb1
+	PoolDevices() ([]string, error)
+	// HoldSnapshot places hold with tag on snapshot, held snapshot can not be removed until all its holds are released
+	HoldSnapshot(snapshot, tag string) error
+	ReleaseSnapshot(snapshot, tag string) error
+	// ListHolds returns tags of holds by snapshot for snapshots of dataset and its children, all snapshots for empty name
+	ListHolds(dataset string) (map[string][]string, error)
+	// SnapshotGuids returns guids by snapshot for snapshots of dataset and its children. Guid is kept by send and
+	// receive, so it identifies the same snapshot on different hosts
+	SnapshotGuids(dataset string) (map[string]string, error)
+}
+
+var driver Driver = zfsDriver{}
+
+// GetDriver returns driver used by package functions
+func GetDriver() Driver {
+	return driver
+}
+
+// SetDriver replaces driver used by package functions, e.g. with FakeDriver to run agent logic without ZFS
+func SetDriver(d Driver) {
+	driver = d
+}
+
+// Package functions below delegate to the current driver, see Driver and zfsDriver for details
+
+func IsDatasetReadOnly(dataset string) bool {
+	return driver.IsDatasetReadOnly(dataset)
+}
+
+func SetDatasetReadOnly(dataset string) error {
+	return driver.SetDatasetReadOnly(dataset)
+}
+
+func DatasetExists(dataset string) bool {
+	return driver.DatasetExists(dataset)
+}
+
+func RemoveDataset(dataset string, recursive bool) error {
+	return driver.RemoveDataset(dataset, recursive)
+}
+
+func CreateDataset(dataset string) error {
+	return driver.CreateDataset(dataset)
+}
+
+func RenameDataset(dataset, newName string) error {
+	return driver.RenameDataset(dataset, newName)
+}
+
+func ListSnapshots(dataset string) (string, error) {
+	return driver.ListSnapshots(dataset)
+}
+
+func ListSnapshotNamesOnly(dataset string) (string, error) {
+	return driver.ListSnapshotNamesOnly(dataset)
+}
+
+func RollbackToSnapshot(snapshot string, forceRollback bool) error {
+	return driver.RollbackToSnapshot(snapshot, forceRollback)
+}
+
+func CreateSnapshot(snapshot string, recursive bool) error {
+	return driver.CreateSnapshot(snapshot, recursive)
+}
+
+func CloneSnapshot(snapshot, dataset string) error {
+	return driver.CloneSnapshot(snapshot, dataset)
+}
+
+func ReceiveStream(dataset, delta string, force bool) error {
+	if err := fault.Fail(fault.ZfsReceive); err != nil {
+		return err
+	}
+	fault.Delay()
+
+	return driver.ReceiveStream(dataset, delta, force)
+}
+
+func ReceiveStreamFrom(dataset string, stream io.Reader, force bool) error {
+	if err := fault.Fail(fault.ZfsReceive); err != nil {
+		return err
+	}
+
+	return driver.ReceiveStreamFrom(dataset, fault.Reader(stream), force)
+}
+
+func SendStream(snapshotFrom, snapshotTo, delta string) error {
+	return driver.SendStream(snapshotFrom, snapshotTo, delta)
+}
+
+func SendStreamTo(snapshotFrom, snapshotTo, token string, stream io.Writer) error {
+	return driver.SendStreamTo(snapshotFrom, snapshotTo, token, stream)
+}
+
+func EstimateStream(snapshotFrom, snapshotTo string) (int64, error) {
+	return driver.EstimateStream(snapshotFrom, snapshotTo)
+}
+
+func ReceiveResumable(dataset string, stream io.Reader) error {
+	if err := fault.Fail(fault.ZfsReceive); err != nil {
+		return err
+	}
+
+	return driver.ReceiveResumable(dataset, fault.Reader(stream))
+}
+
+func ResumeToken(dataset string) (string, error) {
+	return driver.ResumeToken(dataset)
+}
+
+func SetQuota(dataset string, quotaInGb int) error {
+	return driver.SetQuota(dataset, quotaInGb)
+}
+
+func GetQuota(dataset string) (int, error) {
+	return driver.GetQuota(dataset)
+}
+
+func DatasetDiskUsage(dataset string) (int, error) {
+	return driver.DatasetDiskUsage(dataset)
+}
+
+func ListDatasetsUsage() ([]DatasetUsage, error) {
+	return driver.ListDatasetsUsage()
+}
+
+func GetPoolUsage() (size, allocated, free int64, err error) {
+	return driver.GetPoolUsage()
+}
+
+func PoolHealth() (string, error) {
+	return driver.PoolHealth()
+}
+
+func ExpandPool() error {
+	return driver.ExpandPool()
+}
+
+func PoolDevices() ([]string, error) {
+	return driver.PoolDevices()
+}
+
+func HoldSnapshot(snapshot, tag string) error {
+	return driver.HoldSnapshot(snapshot, tag)
+}
+
+func ReleaseSnapshot(snapshot, tag string) error {
+	return driver.ReleaseSnapshot(snapshot, tag)
+}
+
+func ListHolds(dataset string) (map[string][]string, error) {
+	return driver.ListHolds(dataset)
+}
+
+func SnapshotGuids(dataset string) (map[string]string, error) {
+	return driver.SnapshotGuids(dataset)
+}
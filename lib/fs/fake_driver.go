@@ -0,0 +1,862 @@
+package fs
+
+import (
+	"archive/tar"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FakeDriver emulates ZFS datasets with plain directories so that agent logic can be exercised
+// on hosts without ZFS, e.g. by test harness. Datasets are mounted as directories under mountRoot,
+// snapshots are full copies kept under storeRoot. Streams are tar archives of snapshot content,
+// so incremental streams are not really incremental but can be received the same way
+type FakeDriver struct {
+	mu        sync.Mutex
+	mountRoot string
+	storeRoot string
+	//root dataset name used in snapshot listings
+	rootDataset string
+	//pool size reported by GetPoolUsage
+	PoolSize  int64
+	datasets  map[string]*fakeDataset
+	snapshots map[string]*fakeSnapshot
+	//sequence used to order snapshots by creation
+	seq int
+}
+
+type fakeDataset struct {
+	readOnly bool
+	quota    int
+	//snapshot dataset is cloned from
+	origin string
+}
+
+type fakeSnapshot struct {
+	seq     int
+	created string
+	holds   []string
+}
+
+// name of stream entry holding label of sent snapshot
+const fakeStreamLabel = ".fake-snapshot"
+
+// name of stream entry holding snapshot of another dataset stream is sent from, received dataset is its clone
+const fakeStreamOrigin = ".fake-origin"
+
+// NewFakeDriver returns driver with datasets mounted under mountRoot and snapshots kept in storeRoot.
+// rootDataset prefixes snapshot names returned by listings the same way ZFS root dataset does
+func NewFakeDriver(mountRoot, storeRoot, rootDataset string) *FakeDriver {
+	return &FakeDriver{
+		mountRoot:   mountRoot,
+		storeRoot:   storeRoot,
+		rootDataset: rootDataset,
+		PoolSize:    100 * 1024 * 1024 * 1024,
+		datasets:    make(map[string]*fakeDataset),
+		snapshots:   make(map[string]*fakeSnapshot),
+	}
+}
+
+func normalize(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+func (f *FakeDriver) mountpoint(dataset string) string {
+	return path.Join(f.mountRoot, dataset)
+}
+
+func (f *FakeDriver) snapshotDir(snapshot string) string {
+	return path.Join(f.storeRoot, strings.Replace(snapshot, "/", "_", -1))
+}
+
+// children returns names of datasets nested in dataset, all datasets for empty name
+func (f *FakeDriver) children(dataset string) []string {
+	var children []string
+	for name := range f.datasets {
+		if dataset == "" || strings.HasPrefix(name, dataset+"/") {
+			children = append(children, name)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// datasetSnapshots returns snapshots of dataset, with recursive flag snapshots of its children too
+func (f *FakeDriver) datasetSnapshots(dataset string, recursive bool) []string {
+	var snapshots []string
+	for name := range f.snapshots {
+		ds := strings.Split(name, "@")[0]
+		if ds == dataset || (recursive && (dataset == "" || strings.HasPrefix(ds, dataset+"/"))) {
+			snapshots = append(snapshots, name)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return f.snapshots[snapshots[i]].seq < f.snapshots[snapshots[j]].seq
+	})
+	return snapshots
+}
+
+func (f *FakeDriver) IsDatasetReadOnly(dataset string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ds, ok := f.datasets[normalize(dataset)]
+	return ok && ds.readOnly
+}
+
+func (f *FakeDriver) SetDatasetReadOnly(dataset string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ds, ok := f.datasets[normalize(dataset)]
+	if !ok {
+		return errors.Errorf("Error setting dataset %s readonly: dataset does not exist", dataset)
+	}
+	ds.readOnly = true
+
+	return nil
+}
+
+func (f *FakeDriver) DatasetExists(dataset string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dataset = normalize(dataset)
+	_, isDataset := f.datasets[dataset]
+	_, isSnapshot := f.snapshots[dataset]
+	return isDataset || isSnapshot
+}
+
+func (f *FakeDriver) RemoveDataset(dataset string, recursive bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dataset = normalize(dataset)
+
+	if strings.Contains(dataset, "@") {
+		parts := strings.SplitN(dataset, "@", 2)
+		snapshots := []string{dataset}
+		if recursive {
+			snapshots = nil
+			for _, name := range f.datasetSnapshots(parts[0], true) {
+				if strings.HasSuffix(name, "@"+parts[1]) {
+					snapshots = append(snapshots, name)
+				}
+			}
+		}
+		if len(snapshots) == 0 || f.snapshots[snapshots[0]] == nil {
+			return errors.Errorf("Error removing dataset/snapshot %s: could not find any snapshots to destroy", dataset)
+		}
+		for _, snapshot := range snapshots {
+			if err := f.removeSnapshot(snapshot); err != nil {
+				return errors.Errorf("Error removing dataset/snapshot %s: %s", dataset, err.Error())
+			}
+		}
+		return nil
+	}
+
+	if _, ok := f.datasets[dataset]; !ok {
+		return errors.Errorf("Error removing dataset/snapshot %s: dataset does not exist", dataset)
+	}
+
+	children := f.children(dataset)
+	snapshots := f.datasetSnapshots(dataset, true)
+	if !recursive && (len(children) > 0 || len(snapshots) > 0) {
+		return errors.Errorf("Error removing dataset/snapshot %s: filesystem has children, use '-r' to destroy", dataset)
+	}
+
+	for _, snapshot := range snapshots {
+		if err := f.removeSnapshot(snapshot); err != nil {
+			return errors.Errorf("Error removing dataset/snapshot %s: %s", dataset, err.Error())
+		}
+	}
+	for _, child := range append(children, dataset) {
+		delete(f.datasets, child)
+	}
+
+	return os.RemoveAll(f.mountpoint(dataset))
+}
+
+func (f *FakeDriver) removeSnapshot(snapshot string) error {
+	if s, ok := f.snapshots[snapshot]; ok && len(s.holds) > 0 {
+		return errors.New("dataset is busy")
+	}
+	for name, ds := range f.datasets {
+		if ds.origin == snapshot {
+			return errors.Errorf("snapshot has dependent clone %s", name)
+		}
+	}
+
+	delete(f.snapshots, snapshot)
+	return os.RemoveAll(f.snapshotDir(snapshot))
+}
+
+func (f *FakeDriver) CreateDataset(dataset string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.createDataset(normalize(dataset))
+}
+
+func (f *FakeDriver) createDataset(dataset string) error {
+	if _, ok := f.datasets[dataset]; ok {
+		return errors.Errorf("Error creating dataset %s: dataset already exists", dataset)
+	}
+	if parent := path.Dir(dataset); parent != "." {
+		if _, ok := f.datasets[parent]; !ok {
+			return errors.Errorf("Error creating dataset %s: parent does not exist", dataset)
+		}
+	}
+
+	if err := os.MkdirAll(f.mountpoint(dataset), 0755); err != nil {
+		return errors.Errorf("Error creating dataset %s: %s", dataset, err.Error())
+	}
+	f.datasets[dataset] = &fakeDataset{}
+
+	return nil
+}
+
+func (f *FakeDriver) RenameDataset(dataset, newName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dataset, newName = normalize(dataset), normalize(newName)
+	if _, ok := f.datasets[dataset]; !ok {
+		return errors.Errorf("Error renaming dataset %s to %s: dataset does not exist", dataset, newName)
+	}
+	if _, ok := f.datasets[newName]; ok {
+		return errors.Errorf("Error renaming dataset %s to %s: dataset already exists", dataset, newName)
+	}
+
+	rename := func(name string) string {
+		if name == dataset || strings.HasPrefix(name, dataset+"/") || strings.HasPrefix(name, dataset+"@") {
+			return newName + strings.TrimPrefix(name, dataset)
+		}
+		return name
+	}
+
+	for _, snapshot := range f.datasetSnapshots(dataset, true) {
+		renamed := rename(snapshot)
+		if err := os.Rename(f.snapshotDir(snapshot), f.snapshotDir(renamed)); err != nil {
+			return errors.Errorf("Error renaming dataset %s to %s: %s", dataset, newName, err.Error())
+		}
+		f.snapshots[renamed] = f.snapshots[snapshot]
+		delete(f.snapshots, snapshot)
+	}
+	for _, child := range append(f.children(dataset), dataset) {
+		f.datasets[rename(child)] = f.datasets[child]
+		delete(f.datasets, child)
+	}
+	for _, ds := range f.datasets {
+		ds.origin = rename(ds.origin)
+	}
+
+	if err := os.Rename(f.mountpoint(dataset), f.mountpoint(newName)); err != nil {
+		return errors.Errorf("Error renaming dataset %s to %s: %s", dataset, newName, err.Error())
+	}
+
+	return nil
+}
+
+func (f *FakeDriver) ListSnapshots(dataset string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dataset = normalize(dataset)
+	if _, ok := f.datasets[dataset]; dataset != "" && !ok {
+		return "", errors.Errorf("Error listing snapshots for %s: dataset does not exist", dataset)
+	}
+
+	out := "NAME  :CREATED\n"
+	for _, name := range f.datasetSnapshots(dataset, true) {
+		out += fmt.Sprintf("%s  %s\n", path.Join(f.rootDataset, name), f.snapshots[name].created)
+	}
+
+	return out, nil
+}
+
+func (f *FakeDriver) ListSnapshotNamesOnly(dataset string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dataset = normalize(dataset)
+	if _, ok := f.datasets[dataset]; dataset != "" && !ok {
+		return "", errors.Errorf("Error listing snapshots for %s: dataset does not exist", dataset)
+	}
+
+	out := ""
+	for _, name := range f.datasetSnapshots(dataset, true) {
+		out += path.Join(f.rootDataset, name) + "\n"
+	}
+
+	return out, nil
+}
+
+func (f *FakeDriver) RollbackToSnapshot(snapshot string, forceRollback bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshot = normalize(snapshot)
+	snap, ok := f.snapshots[snapshot]
+	if !ok {
+		return errors.Errorf("Error rolling back to snapshot %s: snapshot does not exist", snapshot)
+	}
+
+	dataset := strings.Split(snapshot, "@")[0]
+	for _, name := range f.datasetSnapshots(dataset, false) {
+		if f.snapshots[name].seq <= snap.seq {
+			continue
+		}
+		if !forceRollback {
+			return errors.Errorf("Error rolling back to snapshot %s: more recent snapshots exist, use '-r' to force deletion", snapshot)
+		}
+		if err := f.removeSnapshot(name); err != nil {
+			return errors.Errorf("Error rolling back to snapshot %s: %s", snapshot, err.Error())
+		}
+	}
+
+	if err := f.clearDataset(dataset); err != nil {
+		return errors.Errorf("Error rolling back to snapshot %s: %s", snapshot, err.Error())
+	}
+
+	return copyTree(f.snapshotDir(snapshot), f.mountpoint(dataset), nil)
+}
+
+func (f *FakeDriver) CreateSnapshot(snapshot string, recursive bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshot = normalize(snapshot)
+	parts := strings.SplitN(snapshot, "@", 2)
+	if len(parts) != 2 {
+		return errors.Errorf("Error creating snapshot %s: invalid snapshot name", snapshot)
+	}
+	if _, ok := f.datasets[parts[0]]; !ok {
+		return errors.Errorf("Error creating snapshot %s: dataset does not exist", snapshot)
+	}
+
+	datasets := []string{parts[0]}
+	if recursive {
+		datasets = append(datasets, f.children(parts[0])...)
+	}
+
+	for _, dataset := range datasets {
+		if err := f.createSnapshot(dataset + "@" + parts[1]); err != nil {
+			return errors.Errorf("Error creating snapshot %s: %s", snapshot, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (f *FakeDriver) createSnapshot(snapshot string) error {
+	if _, ok := f.snapshots[snapshot]; ok {
+		return errors.New("dataset already exists")
+	}
+
+	dataset := strings.Split(snapshot, "@")[0]
+	if err := copyTree(f.mountpoint(dataset), f.snapshotDir(snapshot), f.isNestedDataset(dataset)); err != nil {
+		return err
+	}
+
+	f.seq++
+	f.snapshots[snapshot] = &fakeSnapshot{seq: f.seq, created: getTimestamp()}
+
+	return nil
+}
+
+// isNestedDataset returns filter of paths inside dataset mountpoint which belong to child datasets
+func (f *FakeDriver) isNestedDataset(dataset string) func(rel string) bool {
+	return func(rel string) bool {
+		_, ok := f.datasets[path.Join(dataset, rel)]
+		return ok
+	}
+}
+
+// clearDataset removes content of dataset mountpoint keeping mountpoints of child datasets
+func (f *FakeDriver) clearDataset(dataset string) error {
+	entries, err := ioutil.ReadDir(f.mountpoint(dataset))
+	if err != nil {
+		return err
+	}
+
+	nested := f.isNestedDataset(dataset)
+	for _, entry := range entries {
+		if !nested(entry.Name()) {
+			if err := os.RemoveAll(path.Join(f.mountpoint(dataset), entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *FakeDriver) CloneSnapshot(snapshot, dataset string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshot, dataset = normalize(snapshot), normalize(dataset)
+	if _, ok := f.snapshots[snapshot]; !ok {
+		return errors.Errorf("Error cloning snapshot %s to dataset %s: snapshot does not exist", snapshot, dataset)
+	}
+
+	if err := f.createDataset(dataset); err != nil {
+		return errors.Errorf("Error cloning snapshot %s to dataset %s: %s", snapshot, dataset, err.Error())
+	}
+	f.datasets[dataset].origin = snapshot
+
+	return copyTree(f.snapshotDir(snapshot), f.mountpoint(dataset), nil)
+}
+
+func (f *FakeDriver) ReceiveStream(dataset, delta string, force bool) error {
+	stream, err := os.Open(delta)
+	if err != nil {
+		return errors.Errorf("Error receiving stream from %s to %s: %s", delta, dataset, err.Error())
+	}
+	defer stream.Close()
+
+	return f.ReceiveStreamFrom(dataset, stream, force)
+}
+
+// ReceiveStreamFrom creates dataset with content of stream and snapshot of it with label of sent snapshot
+func (f *FakeDriver) ReceiveStreamFrom(dataset string, stream io.Reader, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dataset = normalize(dataset)
+	_, exists := f.datasets[dataset]
+	if exists {
+		if !force {
+			return errors.Errorf("Error receiving stream to %s: destination exists", dataset)
+		}
+		if err := f.clearDataset(dataset); err != nil {
+			return errors.Errorf("Error receiving stream to %s: %s", dataset, err.Error())
+		}
+	} else if err := f.createDataset(dataset); err != nil {
+		return errors.Errorf("Error receiving stream to %s: %s", dataset, err.Error())
+	}
+
+	label, origin := "", ""
+	reader := tar.NewReader(stream)
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Errorf("Error receiving stream to %s: %s", dataset, err.Error())
+		}
+
+		if hdr.Name == fakeStreamLabel {
+			data, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return errors.Errorf("Error receiving stream to %s: %s", dataset, err.Error())
+			}
+			label = string(data)
+			continue
+		}
+		if hdr.Name == fakeStreamOrigin {
+			data, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return errors.Errorf("Error receiving stream to %s: %s", dataset, err.Error())
+			}
+			origin = string(data)
+			continue
+		}
+
+		if err = extractTarArchiveFile(hdr, f.mountpoint(dataset), reader); err != nil {
+			return errors.Errorf("Error receiving stream to %s: %s", dataset, err.Error())
+		}
+	}
+
+	if label == "" {
+		return errors.Errorf("Error receiving stream to %s: invalid stream", dataset)
+	}
+	if _, ok := f.snapshots[origin]; ok && !exists {
+		f.datasets[dataset].origin = origin
+	}
+
+	if _, ok := f.snapshots[dataset+"@"+label]; ok {
+		if err := f.removeSnapshot(dataset + "@" + label); err != nil {
+			return errors.Errorf("Error receiving stream to %s: %s", dataset, err.Error())
+		}
+	}
+
+	return f.createSnapshot(dataset + "@" + label)
+}
+
+// SendStream writes full content of snapshotTo, snapshotFrom is only checked for existence and recorded as origin
+// of received dataset if it is snapshot of another dataset
+func (f *FakeDriver) SendStream(snapshotFrom, snapshotTo, delta string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshotFrom, snapshotTo = normalize(snapshotFrom), normalize(snapshotTo)
+	for _, snapshot := range []string{snapshotFrom, snapshotTo} {
+		if _, ok := f.snapshots[snapshot]; !ok {
+			return errors.Errorf("Error sending stream between %s and %s: snapshot %s does not exist", snapshotFrom, snapshotTo, snapshot)
+		}
+	}
+
+	out, err := os.Create(delta)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err = f.writeStream(snapshotFrom, snapshotTo, out); err != nil {
+		return errors.Errorf("Error sending stream between %s and %s to %s: %s", snapshotFrom, snapshotTo, delta, err.Error())
+	}
+
+	return nil
+}
+
+// SendStreamTo writes full content of snapshotTo to writer, streams can not be resumed
+func (f *FakeDriver) SendStreamTo(snapshotFrom, snapshotTo, token string, stream io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if token != "" {
+		return errors.New("Error sending stream: resume is not supported")
+	}
+	snapshotFrom, snapshotTo = normalize(snapshotFrom), normalize(snapshotTo)
+	for _, snapshot := range []string{snapshotFrom, snapshotTo} {
+		if _, ok := f.snapshots[snapshot]; !ok {
+			return errors.Errorf("Error sending stream between %s and %s: snapshot %s does not exist", snapshotFrom, snapshotTo, snapshot)
+		}
+	}
+
+	if err := f.writeStream(snapshotFrom, snapshotTo, stream); err != nil {
+		return errors.Errorf("Error sending stream between %s and %s: %s", snapshotFrom, snapshotTo, err.Error())
+	}
+
+	return nil
+}
+
+// EstimateStream returns size of content of snapshotTo, since streams are full copies
+func (f *FakeDriver) EstimateStream(snapshotFrom, snapshotTo string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshotFrom, snapshotTo = normalize(snapshotFrom), normalize(snapshotTo)
+	for _, snapshot := range []string{snapshotFrom, snapshotTo} {
+		if _, ok := f.snapshots[snapshot]; !ok {
+			return -1, errors.Errorf("Error estimating stream between %s and %s: snapshot %s does not exist", snapshotFrom, snapshotTo, snapshot)
+		}
+	}
+
+	return treeSize(f.snapshotDir(snapshotTo), nil)
+}
+
+// writeStream writes label, origin and content of snapshot as tar archive
+func (f *FakeDriver) writeStream(snapshotFrom, snapshot string, out io.Writer) error {
+	writer := tar.NewWriter(out)
+	writeEntry := func(name, value string) error {
+		err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(value)), Typeflag: tar.TypeReg})
+		if err == nil {
+			_, err = writer.Write([]byte(value))
+		}
+		return err
+	}
+
+	err := writeEntry(fakeStreamLabel, strings.SplitN(snapshot, "@", 2)[1])
+	if err == nil && strings.SplitN(snapshotFrom, "@", 2)[0] != strings.SplitN(snapshot, "@", 2)[0] {
+		err = writeEntry(fakeStreamOrigin, snapshotFrom)
+	}
+	if err == nil {
+		err = writeTree(writer, f.snapshotDir(snapshot))
+	}
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// ReceiveResumable receives stream the same way as ReceiveStreamFrom, interrupted receive leaves nothing to resume
+func (f *FakeDriver) ReceiveResumable(dataset string, stream io.Reader) error {
+	return f.ReceiveStreamFrom(dataset, stream, true)
+}
+
+// ResumeToken returns empty token since streams can not be resumed
+func (f *FakeDriver) ResumeToken(dataset string) (string, error) {
+	return "", nil
+}
+
+func (f *FakeDriver) SetQuota(dataset string, quotaInGb int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ds, ok := f.datasets[normalize(dataset)]
+	if !ok {
+		return errors.Errorf("Error setting quota %dG to %s: dataset does not exist", quotaInGb, dataset)
+	}
+	ds.quota = quotaInGb
+
+	return nil
+}
+
+func (f *FakeDriver) GetQuota(dataset string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ds, ok := f.datasets[normalize(dataset)]
+	if !ok {
+		return -1, errors.Errorf("Dataset %s does not exist", dataset)
+	}
+
+	return ds.quota * 1024 * 1024 * 1024, nil
+}
+
+func (f *FakeDriver) DatasetDiskUsage(dataset string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dataset = normalize(dataset)
+	if _, ok := f.datasets[dataset]; !ok {
+		return -1, errors.Errorf("Dataset %s does not exist", dataset)
+	}
+
+	size, err := treeSize(f.mountpoint(dataset), f.isNestedDataset(dataset))
+	return int(size), err
+}
+
+func (f *FakeDriver) ListDatasetsUsage() ([]DatasetUsage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var datasets []DatasetUsage
+	for _, name := range f.children("") {
+		size, err := treeSize(f.mountpoint(name), f.isNestedDataset(name))
+		if err != nil {
+			return nil, errors.Errorf("Error listing datasets: %s", err.Error())
+		}
+
+		var snapshots int64
+		for _, snapshot := range f.datasetSnapshots(name, false) {
+			snapSize, err := treeSize(f.snapshotDir(snapshot), nil)
+			if err != nil {
+				return nil, errors.Errorf("Error listing datasets: %s", err.Error())
+			}
+			snapshots += snapSize
+		}
+
+		datasets = append(datasets, DatasetUsage{
+			Name:            name,
+			Used:            size + snapshots,
+			UsedByDataset:   size,
+			UsedBySnapshots: snapshots,
+			Referenced:      size,
+			Origin:          f.datasets[name].origin,
+		})
+	}
+
+	return datasets, nil
+}
+
+func (f *FakeDriver) GetPoolUsage() (size, allocated, free int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, dir := range []string{f.mountRoot, f.storeRoot} {
+		used, err := treeSize(dir, nil)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		allocated += used
+	}
+
+	return f.PoolSize, allocated, f.PoolSize - allocated, nil
+}
+
+func (f *FakeDriver) PoolHealth() (string, error) {
+	return "ONLINE", nil
+}
+
+func (f *FakeDriver) ExpandPool() error {
+	return nil
+}
+
+// PoolDevices returns no devices, fake pool is kept in directories
+func (f *FakeDriver) PoolDevices() ([]string, error) {
+	return nil, nil
+}
+
+// copyTree copies directory src to dst, paths relative to src for which skip returns true are left out
+func copyTree(src, dst string, skip func(rel string) bool) error {
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		if rel != "." && skip != nil && skip(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			if err := Copy(file, target); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode())
+		}
+	})
+}
+
+// writeTree writes content of directory to tar archive with paths relative to it
+func writeTree(writer *tar.Writer, dir string) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if err = writer.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		_, err = io.Copy(writer, in)
+		return err
+	})
+}
+
+func (f *FakeDriver) HoldSnapshot(snapshot, tag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.snapshots[normalize(snapshot)]
+	if !ok {
+		return errors.Errorf("Error holding snapshot %s: snapshot does not exist", snapshot)
+	}
+	for _, hold := range s.holds {
+		if hold == tag {
+			return errors.Errorf("Error holding snapshot %s: tag already exists on this dataset", snapshot)
+		}
+	}
+	s.holds = append(s.holds, tag)
+
+	return nil
+}
+
+func (f *FakeDriver) ReleaseSnapshot(snapshot, tag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.snapshots[normalize(snapshot)]
+	if !ok {
+		return errors.Errorf("Error releasing snapshot %s: snapshot does not exist", snapshot)
+	}
+	for i, hold := range s.holds {
+		if hold == tag {
+			s.holds = append(s.holds[:i], s.holds[i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.Errorf("Error releasing snapshot %s: no such tag on this dataset", snapshot)
+}
+
+func (f *FakeDriver) ListHolds(dataset string) (map[string][]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	holds := make(map[string][]string)
+	for _, name := range f.datasetSnapshots(normalize(dataset), true) {
+		if len(f.snapshots[name].holds) > 0 {
+			holds[name] = append([]string(nil), f.snapshots[name].holds...)
+		}
+	}
+
+	return holds, nil
+}
+
+// SnapshotGuids returns guids derived from snapshot labels, so sent and received snapshots have the same guid
+func (f *FakeDriver) SnapshotGuids(dataset string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	guids := make(map[string]string)
+	for _, name := range f.datasetSnapshots(normalize(dataset), true) {
+		label := strings.SplitN(name, "@", 2)[1]
+		guids[name] = strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(label))), 10)
+	}
+
+	return guids, nil
+}
+
+// treeSize returns total size of regular files in directory, paths for which skip returns true are left out
+func treeSize(dir string, skip func(rel string) bool) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(dir, file); err == nil && rel != "." && skip != nil && skip(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
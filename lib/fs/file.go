@@ -8,6 +8,8 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"path/filepath"
+
+	"github.com/subutai-io/agent/lib/fault"
 )
 
 // Copy creates a copy of passed "source" file to "dest" file
@@ -24,7 +26,7 @@ func Copy(source string, dest string) error {
 	}
 	defer df.Close()
 
-	_, err = io.Copy(df, sf)
+	_, err = io.Copy(df, fault.Reader(sf))
 	if log.Check(log.FatalLevel, "Copying file "+source+" to "+dest, err) {
 		return err
 	}
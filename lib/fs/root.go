@@ -0,0 +1,189 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// InRoot cleans name and returns its components relative to root, leading "/" is dropped so absolute names
+// are resolved against root too. Names leaving root with ".." are refused
+func InRoot(name string) ([]string, error) {
+	clean := path.Clean(strings.TrimLeft(name, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, errors.Errorf("%s leaves its root directory", name)
+	}
+	if clean == "." {
+		return nil, nil
+	}
+	return strings.Split(clean, "/"), nil
+}
+
+// OpenInRoot opens file at name relative to root without following symlinks in any of its components, so
+// contents of root, e.g. rootfs of container, can not redirect access outside of it. Missing parent
+// directories are created if flags include O_CREATE
+func OpenInRoot(root, name string, flags int, perm os.FileMode) (*os.File, error) {
+	parts, err := InRoot(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, errors.Errorf("%s is not a file", name)
+	}
+
+	dir, err := openDirInRoot(root, parts[:len(parts)-1], flags&os.O_CREATE != 0, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(dir)
+
+	fd, err := unix.Openat(dir, parts[len(parts)-1], flags|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(perm.Perm()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s in %s", name, root)
+	}
+	return os.NewFile(uintptr(fd), path.Join(root, path.Join(parts...))), nil
+}
+
+// ReadFileInRoot reads file at name relative to root not following symlinks, see OpenInRoot
+func ReadFileInRoot(root, name string) ([]byte, error) {
+	file, err := OpenInRoot(root, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// WriteFileInRoot writes data to file at name relative to root not following symlinks, see OpenInRoot
+func WriteFileInRoot(root, name string, data []byte, perm os.FileMode) error {
+	file, err := OpenInRoot(root, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// MkdirInRoot creates directory name relative to root along with missing parents not following symlinks
+func MkdirInRoot(root, name string, perm os.FileMode) error {
+	parts, err := InRoot(name)
+	if err != nil {
+		return err
+	}
+	dir, err := openDirInRoot(root, parts, true, -1, -1)
+	if err != nil {
+		return err
+	}
+	if len(parts) > 0 {
+		unix.Fchmod(dir, uint32(perm.Perm()))
+	}
+	return unix.Close(dir)
+}
+
+// SymlinkInRoot creates symlink name relative to root pointing to target, target must stay inside of root
+func SymlinkInRoot(root, name, target string) error {
+	parts, err := InRoot(name)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return errors.Errorf("%s is not a file", name)
+	}
+	if path.IsAbs(target) {
+		return errors.Errorf("symlink %s points to absolute path %s", name, target)
+	}
+	if _, err = InRoot(path.Join(path.Join(parts[:len(parts)-1]...), target)); err != nil {
+		return errors.Errorf("symlink %s points outside of its root to %s", name, target)
+	}
+
+	dir, err := openDirInRoot(root, parts[:len(parts)-1], true, -1, -1)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dir)
+	return unix.Symlinkat(target, dir, parts[len(parts)-1])
+}
+
+// ReplaceFileInRoot replaces file at name relative to root atomically with data not following symlinks, file
+// is written to temporary file renamed over it. File gets owner and perm set, missing parent directories are
+// created and owned by owner of root, e.g. by root of container for its rootfs
+func ReplaceFileInRoot(root, name string, data []byte, perm os.FileMode, uid, gid int) error {
+	parts, err := InRoot(name)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return errors.Errorf("%s is not a file", name)
+	}
+	var stat unix.Stat_t
+	if err = unix.Stat(root, &stat); err != nil {
+		return errors.Wrapf(err, "opening %s", root)
+	}
+
+	dir, err := openDirInRoot(root, parts[:len(parts)-1], true, int(stat.Uid), int(stat.Gid))
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dir)
+
+	base := parts[len(parts)-1]
+	tmp := "." + base + "." + strconv.Itoa(os.Getpid())
+	fd, err := unix.Openat(dir, tmp, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "creating temporary file for %s in %s", name, root)
+	}
+	file := os.NewFile(uintptr(fd), tmp)
+	_, err = file.Write(data)
+	if err == nil {
+		err = unix.Fchown(fd, uid, gid)
+	}
+	if err == nil {
+		err = unix.Fchmod(fd, uint32(perm.Perm()))
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	//rename replaces symlink itself rather than file it points to
+	if err == nil {
+		err = unix.Renameat(dir, tmp, dir, base)
+	}
+	if err != nil {
+		unix.Unlinkat(dir, tmp, 0)
+		return errors.Wrapf(err, "writing %s in %s", name, root)
+	}
+	return nil
+}
+
+// openDirInRoot opens directory of parts relative to root, missing directories are created if create is set and
+// are owned by uid and gid unless they are -1
+func openDirInRoot(root string, parts []string, create bool, uid, gid int) (int, error) {
+	dir, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, errors.Wrapf(err, "opening %s", root)
+	}
+	for i, part := range parts {
+		next, err := unix.Openat(dir, part, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err == unix.ENOENT && create {
+			if err = unix.Mkdirat(dir, part, 0755); err == nil && uid >= 0 {
+				err = unix.Fchownat(dir, part, uid, gid, unix.AT_SYMLINK_NOFOLLOW)
+			}
+			if err == nil || err == unix.EEXIST {
+				next, err = unix.Openat(dir, part, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+			}
+		}
+		unix.Close(dir)
+		if err != nil {
+			return -1, errors.Wrapf(err, "opening %s in %s", path.Join(parts[:i+1]...), root)
+		}
+		dir = next
+	}
+	return dir, nil
+}
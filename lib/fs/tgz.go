@@ -1,27 +1,78 @@
 package fs
 
 import (
-	"path/filepath"
-	"os"
+	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"fmt"
-	"os/exec"
-	"archive/tar"
-	"io"
-	"net/http"
 	"github.com/jhoonb/archivex"
+	"github.com/klauspost/compress/zstd"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/exec"
 	"github.com/subutai-io/agent/log"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
 )
 
-// Compress function creates archive file (tar.gz) of specified folder
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// ArchiveExtension returns extension of archive files produced with compression set in agent configuration
+func ArchiveExtension() string {
+	if config.Agent.Compression == Zstd {
+		return ".tar.zst"
+	}
+	return ".tar.gz"
+}
+
+// Compress function creates archive file of specified folder.
+// Compression is chosen by file extension: tar.zst for zstd, tar.gz for gzip
 func Compress(folder, file string) {
+	if strings.HasSuffix(file, ".tar.zst") {
+		log.Check(log.FatalLevel, "Packing file "+folder, compressZstd(folder, file))
+		return
+	}
+
 	archive := new(archivex.TarFile)
 	archive.Create(file)
 	log.Check(log.FatalLevel, "Packing file "+folder, archive.AddAll(folder, false))
 	archive.Close()
 }
 
-// Decompress function extracts contents of specified archive file (tar.gz) into specified folder
+func compressZstd(folder, file string) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	encoder, err := zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(config.Agent.ZstdLevel)))
+	if err != nil {
+		return err
+	}
+
+	//archivex writes plain tar for non .tar.gz names, zstd encoder does the compression
+	archive := new(archivex.TarFile)
+	archive.CreateWriter(file, encoder)
+	if err = archive.AddAll(folder, false); err != nil {
+		archive.Close()
+		return err
+	}
+	if err = archive.Close(); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// Decompress function extracts contents of specified archive file (tar.gz or tar.zst) into specified folder
 // source code taken from and credits to "code.cloudfoundry.org/archiver/extractor"
 func Decompress(src, dest string) error {
 	srcType, err := mimeType(src)
@@ -35,8 +86,101 @@ func Decompress(src, dest string) error {
 		if err != nil {
 			return err
 		}
+	case "application/zstd":
+		return walkArchive(src, func(hdr *tar.Header, name string, input io.Reader) error {
+			return extractTarArchiveFile(hdr, dest, input)
+		})
 	default:
-		return fmt.Errorf("%s is not a tgz archive: %s", src, srcType)
+		return fmt.Errorf("%s is not a tgz or zstd archive: %s", src, srcType)
+	}
+
+	return nil
+}
+
+// DecompressSkipping extracts contents of specified archive file (tar.gz or tar.zst) into specified folder
+// omitting entries for which skip returns true. Entry names are passed without leading "./"
+func DecompressSkipping(src, dest string, skip func(name string) bool) error {
+	return walkArchive(src, func(hdr *tar.Header, name string, input io.Reader) error {
+		if skip(name) {
+			return nil
+		}
+		return extractTarArchiveFile(hdr, dest, input)
+	})
+}
+
+// StreamEntries reads specified archive file (tar.gz or tar.zst) and passes contents of regular file entries
+// accepted by filter to handler without writing them to disk
+func StreamEntries(src string, filter func(name string) bool, handler func(name string, input io.Reader) error) error {
+	return walkArchive(src, func(hdr *tar.Header, name string, input io.Reader) error {
+		if !hdr.FileInfo().Mode().IsRegular() || !filter(name) {
+			return nil
+		}
+		return handler(name, input)
+	})
+}
+
+// WalkArchive reads specified archive file (tar.gz or tar.zst) and passes each regular file entry
+// with its size to handler without writing it to disk
+func WalkArchive(src string, handler func(name string, size int64, input io.Reader) error) error {
+	return walkArchive(src, func(hdr *tar.Header, name string, input io.Reader) error {
+		if !hdr.FileInfo().Mode().IsRegular() {
+			return nil
+		}
+		return handler(name, hdr.Size, input)
+	})
+}
+
+func walkArchive(src string, handler func(hdr *tar.Header, name string, input io.Reader) error) error {
+	fd, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	var reader io.Reader
+	srcType, err := mimeType(src)
+	if err != nil {
+		return err
+	}
+
+	switch srcType {
+	case "application/x-gzip":
+		gReader, err := gzip.NewReader(fd)
+		if err != nil {
+			return err
+		}
+		defer gReader.Close()
+		reader = gReader
+	case "application/zstd":
+		zReader, err := zstd.NewReader(fd)
+		if err != nil {
+			return err
+		}
+		defer zReader.Close()
+		reader = zReader
+	default:
+		return fmt.Errorf("%s is not a tgz or zstd archive: %s", src, srcType)
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "/")
+		if name == "." {
+			continue
+		}
+
+		err = handler(hdr, name, tarReader)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -51,7 +195,7 @@ func extractTgz(src, dest string) error {
 			return err
 		}
 
-		return exec.Command(tarPath, "pzxf", src, "-C", dest).Run()
+		return exec.Exec(tarPath, "pzxf", src, "-C", dest)
 	}
 
 	fd, err := os.Open(src)
@@ -107,27 +251,34 @@ func mimeType(src string) (string, error) {
 		return "", err
 	}
 
+	//zstd is not recognized by http package
+	if bytes.HasPrefix(data, zstdMagic) {
+		return "application/zstd", nil
+	}
+
 	return http.DetectContentType(data), nil
 }
 
+// extractTarArchiveFile writes entry of archive under dest. Entries can not leave dest: names with ".." are
+// refused, symlinks must point inside of dest and existing symlinks are not followed when writing
 func extractTarArchiveFile(header *tar.Header, dest string, input io.Reader) error {
-	filePath := filepath.Join(dest, header.Name)
 	fileInfo := header.FileInfo()
 
-	if fileInfo.IsDir() {
-		return os.MkdirAll(filePath, fileInfo.Mode())
-	}
-
-	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	err := os.MkdirAll(dest, 0755)
 	if err != nil {
 		return err
 	}
 
-	if fileInfo.Mode()&os.ModeSymlink != 0 {
-		return os.Symlink(header.Linkname, filePath)
+	switch {
+	case fileInfo.IsDir():
+		return MkdirInRoot(dest, header.Name, fileInfo.Mode())
+	case fileInfo.Mode()&os.ModeSymlink != 0:
+		return SymlinkInRoot(dest, header.Name, header.Linkname)
+	case !fileInfo.Mode().IsRegular():
+		return fmt.Errorf("%s is not a regular file, directory or symlink", header.Name)
 	}
 
-	fileCopy, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileInfo.Mode())
+	fileCopy, err := OpenInRoot(dest, header.Name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileInfo.Mode())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,109 @@
+package fs
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+)
+
+// throttleSlices is number of chunks a second of transfer is split into, so transfer is smooth rather than
+// bursting once a second
+const throttleSlices = 10
+
+// TransferRate returns bandwidth limit of transfers in bytes per second, e.g. for limit 50M, limit set in
+// agent config is used if limit is empty. Zero means transfers are not limited
+func TransferRate(limit string) (int64, error) {
+	if limit = strings.TrimSpace(limit); limit == "" {
+		limit = config.Agent.BwLimit
+	}
+	if limit == "" || limit == "0" {
+		return 0, nil
+	}
+
+	//ConvertToBytes expects unit suffix
+	if last := limit[len(limit)-1]; last >= '0' && last <= '9' {
+		limit += "B"
+	}
+	rate, err := ConvertToBytes(limit)
+	if err != nil || rate <= 0 {
+		return 0, errors.Errorf("Invalid bandwidth limit %s, bytes per second like 50M expected", limit)
+	}
+
+	return int64(rate), nil
+}
+
+// throttle delays transfer so that it does not exceed rate on average since its start
+type throttle struct {
+	rate        int64
+	started     time.Time
+	transferred int64
+}
+
+func newThrottle(rate int64) *throttle {
+	return &throttle{rate: rate, started: time.Now()}
+}
+
+// chunk returns size of the next piece of transfer
+func (t *throttle) chunk(size int) int {
+	if max := int(t.rate / throttleSlices); max > 0 && size > max {
+		return max
+	}
+	return size
+}
+
+// wait sleeps until transfer of n more bytes fits the rate
+func (t *throttle) wait(n int) {
+	t.transferred += int64(n)
+	due := time.Duration(float64(t.transferred) / float64(t.rate) * float64(time.Second))
+	if elapsed := time.Since(t.started); due > elapsed {
+		time.Sleep(due - elapsed)
+	}
+}
+
+type throttledReader struct {
+	reader io.Reader
+	*throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p[:r.chunk(len(p))])
+	r.wait(n)
+	return n, err
+}
+
+type throttledWriter struct {
+	writer io.Writer
+	*throttle
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := w.writer.Write(p[written : written+w.chunk(len(p)-written)])
+		written += n
+		w.wait(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ThrottleReader returns reader limited to rate bytes per second, reader itself if rate is not positive
+func ThrottleReader(reader io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return reader
+	}
+	return &throttledReader{reader: reader, throttle: newThrottle(rate)}
+}
+
+// ThrottleWriter returns writer limited to rate bytes per second, writer itself if rate is not positive
+func ThrottleWriter(writer io.Writer, rate int64) io.Writer {
+	if rate <= 0 {
+		return writer
+	}
+	return &throttledWriter{writer: writer, throttle: newThrottle(rate)}
+}
@@ -9,27 +9,32 @@ Root dataset taken from configuration parameter Agent.Dataset is automatically p
 package fs
 
 import (
-	"path"
-	"strings"
-	"github.com/subutai-io/agent/log"
-	"github.com/subutai-io/agent/lib/exec"
-	"strconv"
+	"context"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+	"io"
+	"path"
+	"strconv"
+	"strings"
 	"time"
-	"fmt"
 )
 
 var zfsRootDataset string
 var ChildDatasets = []string{"rootfs", "home", "var", "opt"}
 
+// zfsDriver manages datasets of ZFS pool using zfs and zpool utilities
+type zfsDriver struct{}
+
 func init() {
 	zfsRootDataset = config.Agent.Dataset
 }
 
 // Checks if dataset is readonly
 // e.g. IsDatasetReadOnly("debian-stretch")
-func IsDatasetReadOnly(dataset string) bool {
+func (zfsDriver) IsDatasetReadOnly(dataset string) bool {
 	out, _ := exec.ExecuteWithBash(
 		"zfs get readonly -H " + path.Join(zfsRootDataset, dataset) + " | awk '{print $3}' ")
 	return strings.TrimSpace(out) == "on"
@@ -37,7 +42,7 @@ func IsDatasetReadOnly(dataset string) bool {
 
 // Sets dataset readonly
 // e.g. SetDatasetReadOnly("debian-stretch")
-func SetDatasetReadOnly(dataset string) error {
+func (zfsDriver) SetDatasetReadOnly(dataset string) error {
 	out, err := exec.Execute("zfs", "set", "readonly=on", path.Join(zfsRootDataset, dataset))
 	if err != nil {
 		return errors.Errorf("Error setting dataset %s readonly: %s %s", dataset, out, err.Error())
@@ -48,7 +53,7 @@ func SetDatasetReadOnly(dataset string) error {
 
 // Checks if dataset exists
 // e.g. DatasetExists("foo")
-func DatasetExists(dataset string) bool {
+func (zfsDriver) DatasetExists(dataset string) bool {
 	out, err := exec.Execute("zfs", "list", "-H", path.Join(zfsRootDataset, dataset))
 	log.Debug("Checking dataset " + dataset + " existence " + out)
 	return err == nil
@@ -57,7 +62,7 @@ func DatasetExists(dataset string) bool {
 // Removes dataset or snapshot.
 // Parameter "recursive" allows to remove all children.
 // If snapshot is to be removed, "dataset" parameter must be in form "dataset@snapshotName"
-func RemoveDataset(dataset string, recursive bool) error {
+func (zfsDriver) RemoveDataset(dataset string, recursive bool) error {
 	args := []string{"destroy"}
 	if recursive {
 		args = append(args, "-r")
@@ -73,7 +78,7 @@ func RemoveDataset(dataset string, recursive bool) error {
 
 // Creates dataset
 // e.g. CreateDataset("debian-stretch")
-func CreateDataset(dataset string) error {
+func (zfsDriver) CreateDataset(dataset string) error {
 	out, err := exec.Execute("zfs", "create", path.Join(zfsRootDataset, dataset))
 	if err != nil {
 		return errors.Errorf("Error creating dataset %s: %s %s", dataset, out, err)
@@ -82,9 +87,20 @@ func CreateDataset(dataset string) error {
 	return nil
 }
 
+// Renames dataset, its children and snapshots are renamed and remounted as well
+// e.g. RenameDataset("foo", "foo:owner:0.1.0")
+func (zfsDriver) RenameDataset(dataset, newName string) error {
+	out, err := exec.Execute("zfs", "rename", path.Join(zfsRootDataset, dataset), path.Join(zfsRootDataset, newName))
+	if err != nil {
+		return errors.Errorf("Error renaming dataset %s to %s: %s %s", dataset, newName, out, err.Error())
+	}
+
+	return nil
+}
+
 // Lists snapshots for dataset
 // Returns output of `zfs list -t snapshot -r {root}/{dataset}` command
-func ListSnapshots(dataset string) (string, error) {
+func (zfsDriver) ListSnapshots(dataset string) (string, error) {
 	out, err := exec.Execute("zfs", "list", "-t", "snapshot", "-o", "name,:created", "-r", path.Join(zfsRootDataset, dataset))
 	if err != nil {
 		return "", errors.Errorf("Error listing snapshots for %s: %s %s", dataset, out, err.Error())
@@ -94,7 +110,7 @@ func ListSnapshots(dataset string) (string, error) {
 
 // Lists snapshots names only for dataset
 // Returns output of `zfs list -t snapshot -H -t snapshot -r {dataset} | awk '{print $1}'` command
-func ListSnapshotNamesOnly(dataset string) (string, error) {
+func (zfsDriver) ListSnapshotNamesOnly(dataset string) (string, error) {
 	out, err := exec.Execute("zfs", "list", "-H", "-t", "snapshot", "-o", "name", "-r", path.Join(zfsRootDataset, dataset))
 	if err != nil {
 		return "", errors.Errorf("Error listing snapshots for %s: %s %s", dataset, out, err.Error())
@@ -103,7 +119,7 @@ func ListSnapshotNamesOnly(dataset string) (string, error) {
 }
 
 // Rollbacks parent dataset to the specified snapshot
-func RollbackToSnapshot(snapshot string, forceRollback bool) error {
+func (zfsDriver) RollbackToSnapshot(snapshot string, forceRollback bool) error {
 	args := []string{"rollback"}
 	if forceRollback {
 		args = append(args, "-r")
@@ -118,7 +134,7 @@ func RollbackToSnapshot(snapshot string, forceRollback bool) error {
 
 // Creates snapshot
 // e.g. CreateSnapshot("foo/rootfs@now")
-func CreateSnapshot(snapshot string, recursive bool) error {
+func (zfsDriver) CreateSnapshot(snapshot string, recursive bool) error {
 	args := []string{"snapshot", "-o", ":created=" + getTimestamp()}
 	if recursive {
 		args = append(args, "-r")
@@ -133,7 +149,7 @@ func CreateSnapshot(snapshot string, recursive bool) error {
 
 // Clones snapshot to dataset
 // e.g. CloneSnapshot("debian-stretch/rootfs@now", "foo/rootfs")
-func CloneSnapshot(snapshot, dataset string) error {
+func (zfsDriver) CloneSnapshot(snapshot, dataset string) error {
 	out, err := exec.Execute("zfs", "clone", path.Join(zfsRootDataset, snapshot),
 		path.Join(zfsRootDataset, dataset))
 	if err != nil {
@@ -144,7 +160,7 @@ func CloneSnapshot(snapshot, dataset string) error {
 
 // Receives delta file to dataset
 // e.g. ReceiveStream("foo/rootfs", "/tmp/rootfs.delta")
-func ReceiveStream(dataset, delta string, force bool) error {
+func (zfsDriver) ReceiveStream(dataset, delta string, force bool) error {
 	cmd := "zfs receive " + path.Join(zfsRootDataset, dataset) + " < " + delta
 	if force {
 		cmd += " -F"
@@ -157,21 +173,103 @@ func ReceiveStream(dataset, delta string, force bool) error {
 	return nil
 }
 
+// Receives stream read from reader to dataset
+// e.g. ReceiveStreamFrom("foo/rootfs", reader, false)
+func (zfsDriver) ReceiveStreamFrom(dataset string, stream io.Reader, force bool) error {
+	args := []string{"receive"}
+	if force {
+		args = append(args, "-F")
+	}
+	args = append(args, path.Join(zfsRootDataset, dataset))
+
+	out, err := exec.ExecuteWithInput(stream, "zfs", args...)
+	if err != nil {
+		return errors.Errorf("Error receiving stream to %s: %s %s", dataset, out, err.Error())
+	}
+
+	return nil
+}
+
 // Saves incremental stream to delta file
 // e.g. SendStream("debian-stretch/rootfs@now", "foo/rootfs@now", "/tmp/rootfs.delta")
-func SendStream(snapshotFrom, snapshotTo, delta string) error {
-	out, err := exec.ExecuteWithBash("zfs send -i " + path.Join(zfsRootDataset, snapshotFrom) +
-		" " + path.Join(zfsRootDataset, snapshotTo) + " > " + delta)
+func (zfsDriver) SendStream(snapshotFrom, snapshotTo, delta string) error {
+	//shell redirects stream to file, zfs timeout applies to it
+	result, err := exec.Run(context.Background(), exec.Options{Timeout: exec.Timeout("zfs")}, "/bin/bash", "-c",
+		"zfs send -i "+path.Join(zfsRootDataset, snapshotFrom)+" "+path.Join(zfsRootDataset, snapshotTo)+" > "+delta)
 	if err != nil {
-		errors.Errorf("Error sending stream between %s and %s to %s: %s %s", snapshotFrom, snapshotTo, delta, out, err.Error())
+		return errors.Errorf("Error sending stream between %s and %s to %s: %s %s", snapshotFrom, snapshotTo, delta, result.Stderr, err.Error())
 	}
 
 	return nil
 }
 
+// Writes incremental stream to writer, or resumes interrupted stream with token returned by ResumeToken
+// e.g. SendStreamTo("foo/rootfs@monday", "foo/rootfs@tuesday", "", writer)
+func (zfsDriver) SendStreamTo(snapshotFrom, snapshotTo, token string, stream io.Writer) error {
+	args := []string{"send", "-i", path.Join(zfsRootDataset, snapshotFrom), path.Join(zfsRootDataset, snapshotTo)}
+	if token != "" {
+		args = []string{"send", "-t", token}
+	}
+
+	result, err := exec.Run(context.Background(), exec.Options{Stdout: stream, StreamStdout: true}, "zfs", args...)
+	if err != nil {
+		return errors.Errorf("Error sending stream between %s and %s: %s %s", snapshotFrom, snapshotTo, result.Stderr, err.Error())
+	}
+
+	return nil
+}
+
+// Returns size of incremental stream between snapshots estimated by dry run of send
+// e.g. EstimateStream("foo/rootfs@monday", "foo/rootfs@tuesday")
+func (zfsDriver) EstimateStream(snapshotFrom, snapshotTo string) (int64, error) {
+	result, err := exec.Run(context.Background(), exec.Options{Timeout: exec.Timeout("zfs")}, "zfs", "send", "-nvP",
+		"-i", path.Join(zfsRootDataset, snapshotFrom), path.Join(zfsRootDataset, snapshotTo))
+	if err != nil {
+		return -1, errors.Errorf("Error estimating stream between %s and %s: %s %s", snapshotFrom, snapshotTo, result.Stderr, err.Error())
+	}
+
+	//older zfs versions print dry run summary to stderr
+	for _, line := range strings.Split(string(result.Stdout)+string(result.Stderr), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "size" {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+
+	return -1, errors.Errorf("Error estimating stream between %s and %s: size not reported", snapshotFrom, snapshotTo)
+}
+
+// Receives stream to dataset, interrupted receive leaves resume token on dataset
+// e.g. ReceiveResumable("foo/rootfs", reader)
+func (zfsDriver) ReceiveResumable(dataset string, stream io.Reader) error {
+	out, err := exec.ExecuteWithInput(stream, "zfs", "receive", "-s", "-F", path.Join(zfsRootDataset, dataset))
+	if err != nil {
+		return errors.Errorf("Error receiving stream to %s: %s %s", dataset, out, err.Error())
+	}
+
+	return nil
+}
+
+// Returns token of interrupted receive to dataset, empty if there is none
+// e.g. ResumeToken("foo/rootfs")
+func (d zfsDriver) ResumeToken(dataset string) (string, error) {
+	if !d.DatasetExists(dataset) {
+		return "", nil
+	}
+
+	out, err := exec.Execute("zfs", "get", "-H", "-o", "value", "receive_resume_token", path.Join(zfsRootDataset, dataset))
+	if err != nil {
+		return "", errors.Errorf("Error reading resume token of %s: %s %s", dataset, out, err.Error())
+	}
+	if token := strings.TrimSpace(out); token != "-" {
+		return token, nil
+	}
+
+	return "", nil
+}
+
 // Sets dataset quota in GB
 // e.g. SetQuota("foo", 10)
-func SetQuota(dataset string, quotaInGb int) error {
+func (zfsDriver) SetQuota(dataset string, quotaInGb int) error {
 	out, err := exec.Execute("zfs", "set", "quota="+strconv.Itoa(quotaInGb)+"G", path.Join(zfsRootDataset, dataset))
 	if err != nil {
 		return errors.Errorf("Error setting quota %dG to %s: %s %s", quotaInGb, dataset, out, err.Error())
@@ -182,7 +280,7 @@ func SetQuota(dataset string, quotaInGb int) error {
 
 // Returns dataset quota in bytes, 0 if no quota set
 // e.g. GetQuota("foo")
-func GetQuota(dataset string) (int, error) {
+func (zfsDriver) GetQuota(dataset string) (int, error) {
 	out, err := exec.Execute("zfs", "get", "quota", path.Join(zfsRootDataset, dataset))
 	if err != nil {
 		return -1, err
@@ -218,7 +316,7 @@ func GetQuota(dataset string) (int, error) {
 }
 
 //Returns dataset disk usage in bytes
-func DatasetDiskUsage(dataset string) (int, error) {
+func (zfsDriver) DatasetDiskUsage(dataset string) (int, error) {
 
 	out, err := exec.Execute("zfs", "list", path.Join(zfsRootDataset, dataset))
 	if err != nil {
@@ -244,6 +342,209 @@ func DatasetDiskUsage(dataset string) (int, error) {
 	return -1, errors.New("Failed to parse disk usage from " + out)
 }
 
+// DatasetUsage holds zfs space accounting of a filesystem dataset, sizes are in bytes
+type DatasetUsage struct {
+	//dataset name relative to root dataset
+	Name            string
+	Used            int64
+	UsedByDataset   int64
+	UsedBySnapshots int64
+	UsedByChildren  int64
+	Referenced      int64
+	//origin snapshot relative to root dataset, empty if dataset is not a clone
+	Origin string
+}
+
+// Lists space accounting of all filesystem datasets under root dataset
+func (zfsDriver) ListDatasetsUsage() ([]DatasetUsage, error) {
+	out, err := exec.Execute("zfs", "list", "-Hp", "-r", "-t", "filesystem",
+		"-o", "name,used,usedbydataset,usedbysnapshots,usedbychildren,referenced,origin", zfsRootDataset)
+	if err != nil {
+		return nil, errors.Errorf("Error listing datasets: %s %s", out, err.Error())
+	}
+
+	var datasets []DatasetUsage
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 || fields[0] == zfsRootDataset {
+			continue
+		}
+
+		var values [5]int64
+		for i := range values {
+			values[i], err = strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("Failed to parse dataset usage from %s", line)
+			}
+		}
+
+		origin := ""
+		if fields[6] != "-" {
+			origin = strings.TrimPrefix(fields[6], zfsRootDataset+"/")
+		}
+
+		datasets = append(datasets, DatasetUsage{
+			Name:            strings.TrimPrefix(fields[0], zfsRootDataset+"/"),
+			Used:            values[0],
+			UsedByDataset:   values[1],
+			UsedBySnapshots: values[2],
+			UsedByChildren:  values[3],
+			Referenced:      values[4],
+			Origin:          origin,
+		})
+	}
+
+	return datasets, nil
+}
+
+// Returns size, allocated and free bytes of pool holding root dataset
+func (zfsDriver) GetPoolUsage() (size, allocated, free int64, err error) {
+	pool := strings.Split(zfsRootDataset, "/")[0]
+	out, err := exec.Execute("zpool", "list", "-Hp", "-o", "size,allocated,free", pool)
+	if err != nil {
+		return 0, 0, 0, errors.Errorf("Error getting pool %s usage: %s %s", pool, out, err.Error())
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) < 3 {
+		return 0, 0, 0, errors.New("Failed to parse pool usage from " + out)
+	}
+
+	var values [3]int64
+	for i := range values {
+		values[i], err = strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return 0, 0, 0, errors.New("Failed to parse pool usage from " + out)
+		}
+	}
+
+	return values[0], values[1], values[2], nil
+}
+
+// Returns health of pool holding root dataset
+func (zfsDriver) PoolHealth() (string, error) {
+	pool := strings.Split(zfsRootDataset, "/")[0]
+	out, err := exec.Execute("zpool", "list", "-H", "-o", "health", pool)
+	if err != nil {
+		return "", errors.Errorf("Error getting pool %s health: %s %s", pool, out, err.Error())
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// Expands all devices of pool holding root dataset to use their full capacity,
+// this is required after underlying disks or partitions got grown
+func (z zfsDriver) ExpandPool() error {
+	pool := strings.Split(zfsRootDataset, "/")[0]
+	devices, err := z.PoolDevices()
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		out, err := exec.Execute("zpool", "online", "-e", pool, device)
+		if err != nil {
+			return errors.Errorf("Error expanding device %s: %s %s", device, out, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Returns devices of pool holding root dataset, vdev groups like mirror-0 are skipped
+func (zfsDriver) PoolDevices() ([]string, error) {
+	pool := strings.Split(zfsRootDataset, "/")[0]
+	out, err := exec.Execute("zpool", "list", "-vHP", pool)
+	if err != nil {
+		return nil, errors.Errorf("Error listing pool %s devices: %s %s", pool, out, err.Error())
+	}
+
+	var devices []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+		devices = append(devices, fields[0])
+	}
+
+	return devices, nil
+}
+
+// Places hold on snapshot, zfs refuses to destroy held snapshot
+// e.g. HoldSnapshot("foo/rootfs@backup", "subutai")
+func (zfsDriver) HoldSnapshot(snapshot, tag string) error {
+	out, err := exec.Execute("zfs", "hold", tag, path.Join(zfsRootDataset, snapshot))
+	if err != nil {
+		return errors.Errorf("Error holding snapshot %s: %s %s", snapshot, out, err.Error())
+	}
+	return nil
+}
+
+// Releases hold of snapshot
+func (zfsDriver) ReleaseSnapshot(snapshot, tag string) error {
+	out, err := exec.Execute("zfs", "release", tag, path.Join(zfsRootDataset, snapshot))
+	if err != nil {
+		return errors.Errorf("Error releasing snapshot %s: %s %s", snapshot, out, err.Error())
+	}
+	return nil
+}
+
+// Lists holds of snapshots of dataset and its children.
+// Only snapshots with user references are passed to `zfs holds`, which lists holds of given snapshots
+func (zfsDriver) ListHolds(dataset string) (map[string][]string, error) {
+	out, err := exec.Execute("zfs", "list", "-H", "-t", "snapshot", "-o", "name,userrefs", "-r", path.Join(zfsRootDataset, dataset))
+	if err != nil {
+		return nil, errors.Errorf("Error listing snapshots for %s: %s %s", dataset, out, err.Error())
+	}
+
+	var held []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] != "0" {
+			held = append(held, fields[0])
+		}
+	}
+
+	holds := make(map[string][]string)
+	if len(held) == 0 {
+		return holds, nil
+	}
+
+	out, err = exec.Execute("zfs", append([]string{"holds", "-H"}, held...)...)
+	if err != nil {
+		return nil, errors.Errorf("Error listing holds for %s: %s %s", dataset, out, err.Error())
+	}
+	//lines are "name<TAB>tag<TAB>timestamp"
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) >= 2 {
+			snapshot := strings.TrimPrefix(fields[0], zfsRootDataset+"/")
+			holds[snapshot] = append(holds[snapshot], fields[1])
+		}
+	}
+
+	return holds, nil
+}
+
+// Returns guids of snapshots of dataset and its children
+// e.g. SnapshotGuids("foo")
+func (zfsDriver) SnapshotGuids(dataset string) (map[string]string, error) {
+	out, err := exec.Execute("zfs", "list", "-Hp", "-t", "snapshot", "-o", "name,guid", "-r", path.Join(zfsRootDataset, dataset))
+	if err != nil {
+		return nil, errors.Errorf("Error listing snapshots for %s: %s %s", dataset, out, err.Error())
+	}
+
+	guids := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			guids[strings.TrimPrefix(fields[0], zfsRootDataset+"/")] = fields[1]
+		}
+	}
+
+	return guids, nil
+}
+
 func ConvertToBytes(input string) (int, error) {
 	input = strings.Replace(strings.ToUpper(strings.TrimSpace(input)), ",", ".", 1)
 
@@ -4,21 +4,22 @@ package gpg
 import (
 	"bufio"
 	"bytes"
+	"context"
+	exec2 "github.com/subutai-io/agent/lib/exec"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	exec2 "github.com/subutai-io/agent/lib/exec"
 
+	"fmt"
+	"github.com/subutai-io/agent/agent/util"
 	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fips"
+	"github.com/subutai-io/agent/lib/fs"
 	"github.com/subutai-io/agent/log"
-	"path"
-	"fmt"
-	"github.com/subutai-io/agent/agent/util"
 	"net/http"
-	"github.com/subutai-io/agent/lib/fs"
+	"path"
 )
 
 var (
@@ -75,13 +76,15 @@ func ensureGPGVersion() {
 }
 
 func EncryptFile(pathToFile, password string) error {
-	_, err := exec2.ExecuteNoLog(GPG, "--batch", "--passphrase", password, "--symmetric", "--cipher-algo", "AES256", pathToFile)
+	args := append(fips.GpgArgs(), "--batch", "--passphrase", password, "--symmetric", "--cipher-algo", "AES256", pathToFile)
+	_, err := exec2.ExecuteNoLog(GPG, args...)
 
 	return err
 }
 
 func DecryptFile(pathToSrcFile, pathToDestFile, password string) error {
-	_, err := exec2.ExecuteNoLog(GPG, "--batch", "--passphrase", password, "--output", pathToDestFile, "--decrypt", pathToSrcFile)
+	args := append(fips.GpgArgs(), "--batch", "--passphrase", password, "--output", pathToDestFile, "--decrypt", pathToSrcFile)
+	_, err := exec2.ExecuteNoLog(GPG, args...)
 
 	return err
 }
@@ -98,7 +101,7 @@ func ImportPk(k []byte) error {
 	}
 	log.Check(log.WarnLevel, "Closing "+tmpfile.Name(), tmpfile.Close())
 
-	_, err = exec.Command(GPG, "--import", tmpfile.Name()).CombinedOutput()
+	_, err = exec2.CombinedOutput(GPG, "--import", tmpfile.Name())
 	if log.Check(log.WarnLevel, "Importing gpg public key from "+tmpfile.Name(), err) {
 		return err
 	}
@@ -109,14 +112,14 @@ func ImportPk(k []byte) error {
 // GetContainerPk returns GPG Public Key for container.
 func GetContainerPk(name string) string {
 	lxcPath := path.Join(config.Agent.LxcPrefix, name, "public.pub")
-	stdout, err := exec.Command("/bin/bash", "-c", GPG+" --no-default-keyring --keyring "+lxcPath+" --export -a "+name+"@subutai.io").Output()
+	stdout, err := exec2.Output("/bin/bash", "-c", GPG+" --no-default-keyring --keyring "+lxcPath+" --export -a "+name+"@subutai.io")
 	log.Check(log.WarnLevel, "Getting Container public key", err)
 	return string(stdout)
 }
 
 // GetPk returns GPG Public Key from the Resource Host.
 func GetPk(name string) string {
-	stdout, err := exec.Command(GPG, "--export", "-a", name).Output()
+	stdout, err := exec2.Output(GPG, "--export", "-a", name)
 	if !log.Check(log.WarnLevel, "Getting public key", err) {
 		return string(stdout)
 	}
@@ -125,38 +128,28 @@ func GetPk(name string) string {
 
 // DecryptWrapper decrypts GPG message.
 func DecryptWrapper(args ...string) string {
-	gpg := GPG + " --passphrase " + config.Agent.GpgPassword + " --no-tty"
+	gpg := strings.Join(append([]string{GPG}, fips.GpgArgs()...), " ") + " --passphrase " + config.Agent.GpgPassword + " --no-tty"
 	if len(args) == 3 {
 		gpg = gpg + " --no-default-keyring --keyring " + args[2] + " --secret-keyring " + args[1]
 	}
-	command := exec.Command("/bin/bash", "-c", gpg)
-	stdin, err := command.StdinPipe()
-	if err == nil {
-		_, err = stdin.Write([]byte(args[0]))
-		log.Check(log.DebugLevel, "Writing to stdin of gpg", err)
-		log.Check(log.DebugLevel, "Closing stdin of gpg", stdin.Close())
-	}
+	//command line holds passphrase
+	result, err := exec2.Run(context.Background(), exec2.Options{Stdin: strings.NewReader(args[0]), Quiet: true,
+		Timeout: exec2.Timeout(GPG)}, "/bin/bash", "-c", gpg)
+	log.Check(log.WarnLevel, "Decrypting message", err)
 
-	output, err := command.Output()
-	log.Check(log.WarnLevel, "Executing command "+gpg, err)
-
-	return string(output)
+	return string(result.Stdout)
 }
 
 // EncryptWrapper encrypts GPG message.
 func EncryptWrapper(user, recipient string, message []byte, args ...string) ([]byte, error) {
-	gpg := GPG + " --batch --passphrase " + config.Agent.GpgPassword + " --trust-model always --armor -u " + user + " -r " + recipient + " --sign --encrypt --no-tty"
+	gpg := strings.Join(append([]string{GPG}, fips.GpgArgs()...), " ") + " --batch --passphrase " + config.Agent.GpgPassword + " --trust-model always --armor -u " + user + " -r " + recipient + " --sign --encrypt --no-tty"
 	if len(args) >= 2 {
 		gpg = gpg + " --no-default-keyring --keyring " + args[0] + " --secret-keyring " + args[1]
 	}
-	command := exec.Command("/bin/bash", "-c", gpg)
-	stdin, err := command.StdinPipe()
-	if err == nil {
-		_, err = stdin.Write(message)
-		log.Check(log.DebugLevel, "Writing to stdin of gpg", err)
-		log.Check(log.DebugLevel, "Closing stdin of gpg", stdin.Close())
-	}
-	return command.Output()
+	//command line holds passphrase
+	result, err := exec2.Run(context.Background(), exec2.Options{Stdin: bytes.NewReader(message), Quiet: true,
+		Timeout: exec2.Timeout(GPG)}, "/bin/bash", "-c", gpg)
+	return result.Stdout, err
 }
 
 // GenerateKey generates GPG-key for Subutai Agent.
@@ -208,12 +201,12 @@ func GenerateKey(name string) error {
 
 	//rh gpg key
 	if !container.LxcInstanceExists(name) {
-		out, err := exec.Command(GPG, "--allow-secret-key-import", "--import", "/root/.gnupg/secret.sec").CombinedOutput()
+		out, err := exec2.CombinedOutput(GPG, "--allow-secret-key-import", "--import", "/root/.gnupg/secret.sec")
 		if log.Check(log.DebugLevel, "Importing secret key "+string(out), err) {
 			fs.RemoveFilesWildcard(filepath.Join(config.Agent.GpgHome, "*.lock"))
 			return err
 		}
-		out, err = exec.Command(GPG, "--import", "/root/.gnupg/public.pub").CombinedOutput()
+		out, err = exec2.CombinedOutput(GPG, "--import", "/root/.gnupg/public.pub")
 		if log.Check(log.DebugLevel, "Importing public key "+string(out), err) {
 			fs.RemoveFilesWildcard(filepath.Join(config.Agent.GpgHome, "*.lock"))
 			return err
@@ -308,7 +301,7 @@ func sendData(c string) {
 	log.Check(log.FatalLevel, "Reading encrypted stdin.txt.asc", err)
 	defer asc.Close()
 
-	resp, err := secureClient.Post("https://"+path.Join(config.ManagementIP)+":8444/rest/v1/registration/verify/container-token", "text/plain", asc)
+	resp, err := secureClient.Post("https://"+path.Join(config.ManagementIP)+":"+config.Management.SecurePort+"/rest/v1/registration/verify/container-token", "text/plain", asc)
 	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix, c, "stdin.txt.asc"), os.Remove(path.Join(config.Agent.LxcPrefix, c, "stdin.txt.asc")))
 	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix, c, "stdin.txt"), os.Remove(path.Join(config.Agent.LxcPrefix, c, "stdin.txt")))
 	log.Check(log.FatalLevel, "Sending container registration request to management", err)
@@ -322,31 +315,29 @@ func sendData(c string) {
 // ExchangeAndEncrypt installs the Management server GPG public key to the container keyring.
 // Sends container's GPG public key to the Management server. It requires encrypting and singing message
 // received from the Management server.
-func ExchangeAndEncrypt(c, t string) {
-	var impout, expout, imperr, experr bytes.Buffer
+// Token t is single use, it must be valid for scope of operation registering the container and is consumed
+// only once the exchange succeeds
+func ExchangeAndEncrypt(c, t, scope string) {
+	log.Check(log.ErrorLevel, "Validating registration token", checkToken(t, scope))
 
 	installMgmtKey(c)
 
 	//import mgmt key to container
-	impkey := exec.Command(GPG, "-v", "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--import", path.Join(config.Agent.LxcPrefix, c, "mgn.key"))
-	impkey.Stdout = &impout
-	impkey.Stderr = &imperr
-	err := impkey.Run()
+	imported, err := exec2.Run(context.Background(), exec2.Options{}, GPG, "-v", "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--import", path.Join(config.Agent.LxcPrefix, c, "mgn.key"))
 	log.Check(log.FatalLevel, "Importing Management public key to keyring", err)
 
-	id := parseKeyID(imperr.String())
-	expkey := exec.Command(GPG, "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--export", "--armor", c+"@subutai.io")
-	expkey.Stdout = &expout
-	expkey.Stderr = &experr
-	err = expkey.Run()
+	id := parseKeyID(string(imported.Stderr))
+	exported, err := exec2.Run(context.Background(), exec2.Options{}, GPG, "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--export", "--armor", c+"@subutai.io")
 	log.Check(log.FatalLevel, "Exporting armored key", err)
 
-	writeData(c, t, expout.String(), experr.String())
+	writeData(c, t, string(exported.Stdout), string(exported.Stderr))
 
-	err = exec.Command(GPG, "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--trust-model", "always", "--armor", "-r", id, "--encrypt", path.Join(config.Agent.LxcPrefix, c, "stdin.txt")).Run()
+	err = exec2.Exec(GPG, "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--trust-model", "always", "--armor", "-r", id, "--encrypt", path.Join(config.Agent.LxcPrefix, c, "stdin.txt"))
 	log.Check(log.FatalLevel, "Encrypting stdin.txt", err)
 
 	sendData(c)
+
+	log.Check(log.ErrorLevel, "Consuming registration token", useToken(t, scope, c))
 }
 
 //todo move to ssl
@@ -376,19 +367,10 @@ func ValidatePem(pathToCert string) bool {
 }
 
 func ExtractKeyID(k []byte) string {
-	command := exec.Command(GPG)
-	stdin, err := command.StdinPipe()
-	if err != nil {
-		return ""
-	}
-
-	_, err = stdin.Write(k)
-	log.Check(log.DebugLevel, "Writing to stdin pipe", err)
-	log.Check(log.DebugLevel, "Closing stdin pipe", stdin.Close())
-	out, err := command.Output()
+	result, err := exec2.Run(context.Background(), exec2.Options{Stdin: bytes.NewReader(k)}, GPG)
 	log.Check(log.WarnLevel, "Extracting ID from Key", err)
 
-	if line := strings.Fields(string(out)); len(line) > 1 {
+	if line := strings.Fields(string(result.Stdout)); len(line) > 1 {
 		if key := strings.Split(line[1], "/"); len(key) > 1 {
 			return key[1]
 		}
@@ -0,0 +1,98 @@
+package gpg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+)
+
+// Scopes of registration tokens, i.e. operations token can register container keys for
+const (
+	ScopeClone      = "clone"
+	ScopeRestore    = "restore"
+	ScopeManagement = "management"
+)
+
+var Scopes = []string{ScopeClone, ScopeRestore, ScopeManagement}
+
+// HashToken returns hash under which token is recorded
+func HashToken(t string) string {
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsScope checks if scope is one of known token scopes
+func IsScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// checkToken checks if token can be used for operation of scope without marking it as used, so token is not
+// burned by exchange which fails afterwards
+func checkToken(t, scope string) error {
+	token, err := db.FindTokenByHash(HashToken(t))
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		token = &db.RegistrationToken{}
+	}
+	return validateToken(token, scope, time.Now())
+}
+
+// useToken marks token as used by container c for operation of scope.
+// Token must be not expired, not revoked, not used before and allow the scope.
+// Unknown token is recorded on its first use unless strict tokens are configured
+func useToken(t, scope, c string) error {
+	return db.UpdateToken(HashToken(t), func(token *db.RegistrationToken) error {
+		now := time.Now()
+
+		if err := validateToken(token, scope, now); err != nil {
+			return err
+		}
+		if token.Id == 0 {
+			token.Scopes = []string{scope}
+			token.Created = now
+			token.Expires = now.Add(time.Duration(config.Management.TokenTtl) * time.Second)
+		}
+
+		token.Used = now
+		token.UsedBy = c
+
+		return nil
+	})
+}
+
+// validateToken checks token against scope at time now, unknown token is valid unless strict tokens are configured
+func validateToken(token *db.RegistrationToken, scope string, now time.Time) error {
+	if token.Id == 0 {
+		if config.Management.StrictTokens {
+			return errors.New("Token is not registered")
+		}
+		return nil
+	}
+
+	switch {
+	case token.Revoked:
+		return errors.New("Token is revoked")
+	case !token.Used.IsZero():
+		return errors.Errorf("Token was already used by %s at %s", token.UsedBy, token.Used.Format(time.RFC3339))
+	case now.After(token.Expires):
+		return errors.Errorf("Token expired at %s", token.Expires.Format(time.RFC3339))
+	}
+
+	for _, s := range token.Scopes {
+		if s == scope {
+			return nil
+		}
+	}
+	return errors.Errorf("Token is not valid for %s", scope)
+}
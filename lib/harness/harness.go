@@ -0,0 +1,196 @@
+// Package harness runs agent container flows (import, clone, snapshot, export, destroy) against fake
+// filesystem and container runtime drivers, so that they can be exercised on hosts without ZFS and LXC.
+// Harness redirects agent directories to a temporary root and puts stubs of external utilities
+// required by the flows (lxc-info, lxc-update-config, p2p, zfs) in front of PATH.
+// Harness changes process wide state, so only one harness may be active at a time.
+// Agent packages are initialized from host on load, so tests using harness still need writable /etc/subutai and gpg1
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/cli"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/templ"
+)
+
+// stubs of external utilities invoked by container flows
+var stubs = map[string]string{
+	"lxc-info":          "#!/bin/sh\necho 3.0.3\n",
+	"lxc-start":         "#!/bin/sh\nexit 0\n",
+	"lxc-update-config": "#!/bin/sh\nexit 0\n",
+	"p2p":               "#!/bin/sh\necho 1500\n",
+	"zfs":               "#!/bin/sh\necho yes\n",
+}
+
+// Harness holds fake drivers installed for agent packages
+type Harness struct {
+	// Root is temporary directory holding containers, caches and agent data
+	Root    string
+	Fs      *fs.FakeDriver
+	Runtime *container.FakeRuntime
+
+	//state restored on Close
+	lxcPrefix, cacheDir, dataPrefix string
+	driver                          fs.Driver
+	envPath                         string
+}
+
+// New creates temporary root and installs fake drivers, Close must be called to restore agent state
+func New() (*Harness, error) {
+	root, err := ioutil.TempDir("", "subutai-harness")
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Harness{Root: root, lxcPrefix: config.Agent.LxcPrefix, cacheDir: config.Agent.CacheDir,
+		dataPrefix: config.Agent.DataPrefix, driver: fs.GetDriver(), envPath: os.Getenv("PATH")}
+
+	for _, dir := range []string{"lxc", "snapshots", "cache", "data", "bin"} {
+		if err = os.MkdirAll(path.Join(root, dir), 0755); err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+	}
+	for name, content := range stubs {
+		if err = ioutil.WriteFile(path.Join(root, "bin", name), []byte(content), 0755); err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+	}
+
+	config.Agent.LxcPrefix = path.Join(root, "lxc") + "/"
+	config.Agent.CacheDir = path.Join(root, "cache")
+	config.Agent.DataPrefix = path.Join(root, "data") + "/"
+	os.Setenv("PATH", path.Join(root, "bin")+":"+h.envPath)
+
+	h.Fs = fs.NewFakeDriver(config.Agent.LxcPrefix, path.Join(root, "snapshots"), config.Agent.Dataset)
+	h.Runtime = container.NewFakeRuntime()
+	fs.SetDriver(h.Fs)
+	container.SetRuntime(h.Runtime)
+
+	return h, nil
+}
+
+// Close restores agent drivers, directories and PATH and removes temporary root
+func (h *Harness) Close() error {
+	fs.SetDriver(h.driver)
+	container.SetRuntime(nil)
+	config.Agent.LxcPrefix, config.Agent.CacheDir, config.Agent.DataPrefix = h.lxcPrefix, h.cacheDir, h.dataPrefix
+	os.Setenv("PATH", h.envPath)
+
+	return os.RemoveAll(h.Root)
+}
+
+// AddTemplate creates template as import does. Template is referenced as name:owner:version,
+// files are keyed by path relative to template directory, e.g. "rootfs/etc/hostname"
+func (h *Harness) AddTemplate(ref string, files map[string]string) error {
+	r, err := templ.ParseFullRef(ref)
+	if err != nil {
+		return err
+	}
+	ref = r.String()
+
+	if err = fs.CreateDataset(ref); err != nil {
+		return err
+	}
+	for _, partition := range fs.ChildDatasets {
+		if err := fs.CreateDataset(path.Join(ref, partition)); err != nil {
+			return err
+		}
+	}
+
+	if err := h.WriteFiles(ref, files); err != nil {
+		return err
+	}
+
+	for _, partition := range fs.ChildDatasets {
+		if err := fs.CreateSnapshot(path.Join(ref, partition)+"@now", false); err != nil {
+			return err
+		}
+		if err := fs.SetDatasetReadOnly(path.Join(ref, partition)); err != nil {
+			return err
+		}
+	}
+
+	return container.CreateContainerConf(path.Join(config.Agent.LxcPrefix, ref, "config"), [][]string{
+		{"subutai.template", r.Name},
+		{"subutai.template.owner", r.Owner},
+		{"subutai.template.version", r.Version},
+		{"subutai.parent", r.Name},
+		{"subutai.parent.owner", r.Owner},
+		{"subutai.parent.version", r.Version},
+		{"lxc.uts.name", r.Name},
+	})
+}
+
+// WriteFiles writes files into container or template directory, keyed by path relative to it
+func (h *Harness) WriteFiles(name string, files map[string]string) error {
+	for file, content := range files {
+		target := path.Join(config.Agent.LxcPrefix, name, file)
+		if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadFile returns content of file of container or template, path is relative to its directory
+func (h *Harness) ReadFile(name, file string) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, name, file))
+	return string(data), err
+}
+
+// Clone creates container from template
+func (h *Harness) Clone(ref, name string) error {
+	if !container.IsTemplate(ref) {
+		return errors.Errorf("Template %s not found", ref)
+	}
+
+	return container.Clone(ref, name)
+}
+
+// Snapshot creates snapshot of all container partitions with label
+func (h *Harness) Snapshot(name, label string) error {
+	return fs.CreateSnapshot(name+"@"+label, true)
+}
+
+// Rollback restores all container partitions to snapshot with label
+func (h *Harness) Rollback(name, label string, force bool) error {
+	for _, partition := range fs.ChildDatasets {
+		if err := fs.RollbackToSnapshot(path.Join(name, partition)+"@"+label, force); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Export exports container as local template archive the way export command does and returns path of
+// the archive. Export exits process on failure as the command does
+func (h *Harness) Export(name, owner, version string) string {
+	cli.LxcExport(name, "", version, "", "", owner, true, false, "", cli.TemplateMetadata{})
+
+	return path.Join(config.Agent.CacheDir, name+"-subutai-template_"+version+"_"+runtime.GOARCH) + fs.ArchiveExtension()
+}
+
+// Import installs template from local archive the way import command does. Import exits process on
+// failure as the command does
+func (h *Harness) Import(archive string) {
+	cli.LxcImport(archive, "")
+}
+
+// Destroy removes container or template with its snapshots
+func (h *Harness) Destroy(name string) error {
+	return container.Destroy(name, false)
+}
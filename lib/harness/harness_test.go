@@ -0,0 +1,87 @@
+package harness
+
+import (
+	"testing"
+
+	"github.com/subutai-io/agent/lib/container"
+)
+
+const base = "base:subutai:1.0.0"
+
+func newHarness(t *testing.T) *Harness {
+	h, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.AddTemplate(base, map[string]string{"rootfs/etc/motd": "base\n"}); err != nil {
+		h.Close()
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestCloneSnapshotRollback(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	if err := h.Clone(base, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if !container.IsContainer("foo") {
+		t.Fatal("foo is not a container after clone")
+	}
+	if motd, err := h.ReadFile("foo", "rootfs/etc/motd"); err != nil || motd != "base\n" {
+		t.Fatalf("motd of clone is %q, %v", motd, err)
+	}
+
+	if err := h.Snapshot("foo", "before"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WriteFiles("foo", map[string]string{"rootfs/etc/motd": "changed\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Rollback("foo", "before", false); err != nil {
+		t.Fatal(err)
+	}
+	if motd, err := h.ReadFile("foo", "rootfs/etc/motd"); err != nil || motd != "base\n" {
+		t.Fatalf("motd after rollback is %q, %v", motd, err)
+	}
+
+	if err := h.Destroy("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if container.IsContainer("foo") {
+		t.Fatal("foo is a container after destroy")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	if err := h.Clone(base, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WriteFiles("foo", map[string]string{"rootfs/etc/motd": "foo\n", "opt/app": "app\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := h.Export("foo", "tester", "1.0.1")
+	if err := h.Destroy("foo"); err != nil {
+		t.Fatal(err)
+	}
+	h.Import(archive)
+
+	ref := "foo:tester:1.0.1"
+	if !container.IsTemplate(ref) {
+		t.Fatalf("%s is not a template after import", ref)
+	}
+	if err := h.Clone(ref, "bar"); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{"rootfs/etc/motd": "foo\n", "opt/app": "app\n"} {
+		if data, err := h.ReadFile("bar", file); err != nil || data != content {
+			t.Errorf("%s of clone of imported template is %q, %v", file, data, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package cgroup reads resource consumption of containers from their cgroup items. It does not depend on
+// container package, so container package itself may read consumption of containers, e.g. to balance cores
+package cgroup
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//cgroup memory limit value meaning "no limit"
+const unlimitedMemory = 9223372036854771712
+
+// Reader reads cgroup items of containers, implemented by runtime of containers
+type Reader interface {
+	CgroupItem(name, key string) string
+}
+
+// CPUSample is CPU time in nanoseconds consumed by container since its start and time it was read at
+type CPUSample struct {
+	Usage uint64
+	Time  time.Time
+}
+
+// SampleCPU reads CPU time consumed by container
+func SampleCPU(r Reader, name string) (CPUSample, error) {
+	usage, err := readUint(r, name, "cpuacct.usage")
+	return CPUSample{Usage: usage, Time: time.Now()}, err
+}
+
+// CPURate returns number of cores container consumed between two samples, false if previous sample is not taken
+// or samples are not consecutive
+func CPURate(prev, cur CPUSample) (float64, bool) {
+	if prev.Time.IsZero() || !cur.Time.After(prev.Time) || cur.Usage < prev.Usage {
+		return 0, false
+	}
+
+	return float64(cur.Usage-prev.Usage) / float64(cur.Time.Sub(prev.Time).Nanoseconds()), true
+}
+
+// MemoryUsage returns memory used by container in bytes
+func MemoryUsage(r Reader, name string) (uint64, error) {
+	return readUint(r, name, "memory.usage_in_bytes")
+}
+
+// MemoryMaxUsage returns maximum memory used by container in bytes
+func MemoryMaxUsage(r Reader, name string) (uint64, error) {
+	return readUint(r, name, "memory.max_usage_in_bytes")
+}
+
+// MemoryLimit returns memory limit of container in bytes, 0 means no limit
+func MemoryLimit(r Reader, name string) (uint64, error) {
+	value := strings.TrimSpace(r.CgroupItem(name, "memory.limit_in_bytes"))
+	//unified hierarchy reports no limit as max
+	if value == "max" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading memory.limit_in_bytes of %s", name)
+	}
+	if limit >= unlimitedMemory {
+		return 0, nil
+	}
+
+	return limit, nil
+}
+
+func readUint(r Reader, name, key string) (uint64, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(r.CgroupItem(name, key)), 10, 64)
+	return n, errors.Wrapf(err, "reading %s of %s", key, name)
+}
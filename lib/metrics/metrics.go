@@ -0,0 +1,233 @@
+// Package metrics reads actual resource consumption of containers from their cgroups and host side of their
+// veth interfaces, along with quotas set, so consumption can be compared with quotas
+package metrics
+
+import (
+	"bufio"
+	"io/ioutil"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/metrics/cgroup"
+	"github.com/subutai-io/agent/lib/net"
+)
+
+//directory of statistics of network interfaces
+var netStatsDir = "/sys/class/net"
+
+// ContainerMetrics is resource consumption of container at the moment it was collected
+type ContainerMetrics struct {
+	Container string         `json:"container"`
+	Collected time.Time      `json:"collected"`
+	CPU       CPUMetrics     `json:"cpu"`
+	Memory    MemoryMetrics  `json:"memory"`
+	BlockIO   BlockIOMetrics `json:"blkio"`
+	Network   NetMetrics     `json:"network"`
+	Disk      DiskMetrics    `json:"disk"`
+}
+
+// CPUMetrics is CPU time consumed by container
+type CPUMetrics struct {
+	//nanoseconds consumed since container start
+	Usage uint64 `json:"usage"`
+	//percents of all cores between two samples, -1 if container was sampled once
+	Load float64 `json:"load"`
+	//percents of all cores, 0 means no quota
+	Quota int `json:"quota"`
+}
+
+// MemoryMetrics is memory of container in bytes
+type MemoryMetrics struct {
+	Usage    uint64 `json:"usage"`
+	MaxUsage uint64 `json:"maxUsage"`
+	Cache    uint64 `json:"cache"`
+	//0 means no quota
+	Quota uint64 `json:"quota"`
+}
+
+// BlockIOMetrics is block IO of container summed over all devices
+type BlockIOMetrics struct {
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteBytes uint64 `json:"writeBytes"`
+	ReadOps    uint64 `json:"readOps"`
+	WriteOps   uint64 `json:"writeOps"`
+	//relative weight, from 10 to 1000
+	Weight int `json:"weight"`
+}
+
+// NetMetrics is traffic of container as seen by container, i.e. bytes received by container are bytes
+// sent by host side of its veth interface
+type NetMetrics struct {
+	Interface string `json:"interface"`
+	RxBytes   uint64 `json:"rxBytes"`
+	TxBytes   uint64 `json:"txBytes"`
+	RxPackets uint64 `json:"rxPackets"`
+	TxPackets uint64 `json:"txPackets"`
+	RxDropped uint64 `json:"rxDropped"`
+	TxDropped uint64 `json:"txDropped"`
+	//Kbps of traffic sent by container, 0 means no quota
+	Quota int `json:"quota"`
+}
+
+// DiskMetrics is disk space used by all partitions of container in bytes
+type DiskMetrics struct {
+	Used uint64 `json:"used"`
+	//0 means no quota
+	Quota uint64 `json:"quota"`
+}
+
+// Collect reads counters and quotas of running container, CPU load is unknown until container is sampled twice,
+// see Sample
+func Collect(name string) (*ContainerMetrics, error) {
+	if !container.LxcInstanceExists(name) {
+		return nil, errors.Errorf("Container %s not found", name)
+	}
+	rt := container.GetRuntime()
+	if state := rt.State(name); state != container.Running {
+		return nil, errors.Errorf("Container %s is %s", name, state)
+	}
+
+	m := &ContainerMetrics{Container: name, Collected: time.Now()}
+	m.CPU.Load = -1
+
+	cpu, err := cgroup.SampleCPU(rt, name)
+	if err != nil {
+		return nil, err
+	}
+	m.CPU.Usage = cpu.Usage
+	m.CPU.Quota = container.QuotaCPU(name, "")
+	if m.CPU.Quota < 0 {
+		m.CPU.Quota = 0
+	}
+
+	if m.Memory.Usage, err = cgroup.MemoryUsage(rt, name); err != nil {
+		return nil, err
+	}
+	m.Memory.MaxUsage, _ = cgroup.MemoryMaxUsage(rt, name)
+	m.Memory.Cache = parseKeyed(rt.CgroupItem(name, "memory.stat"))["cache"]
+	m.Memory.Quota, _ = cgroup.MemoryLimit(rt, name)
+
+	m.BlockIO.ReadBytes, m.BlockIO.WriteBytes = parseBlkio(rt.CgroupItem(name, "blkio.throttle.io_service_bytes"))
+	m.BlockIO.ReadOps, m.BlockIO.WriteOps = parseBlkio(rt.CgroupItem(name, "blkio.throttle.io_serviced"))
+	m.BlockIO.Weight, _ = strconv.Atoi(rt.CgroupItem(name, "blkio.weight"))
+
+	m.Network.Interface = vethPair(name)
+	if m.Network.Interface != "" {
+		stats := path.Join(netStatsDir, m.Network.Interface, "statistics")
+		//host side of veth sends what container receives
+		m.Network.RxBytes = readUint(path.Join(stats, "tx_bytes"))
+		m.Network.TxBytes = readUint(path.Join(stats, "rx_bytes"))
+		m.Network.RxPackets = readUint(path.Join(stats, "tx_packets"))
+		m.Network.TxPackets = readUint(path.Join(stats, "rx_packets"))
+		m.Network.RxDropped = readUint(path.Join(stats, "tx_dropped"))
+		m.Network.TxDropped = readUint(path.Join(stats, "rx_dropped"))
+		m.Network.Quota, _ = strconv.Atoi(net.RateLimit(m.Network.Interface, ""))
+	}
+
+	if used, err := fs.DatasetDiskUsage(name); err == nil {
+		m.Disk.Used = uint64(used)
+	}
+	if quota, err := fs.GetQuota(name); err == nil && quota > 0 {
+		m.Disk.Quota = uint64(quota)
+	}
+
+	return m, nil
+}
+
+// Sample collects metrics of container twice with interval between, so CPU load is known
+func Sample(name string, interval time.Duration) (*ContainerMetrics, error) {
+	prev, err := Collect(name)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(interval)
+	m, err := Collect(name)
+	if err != nil {
+		return nil, err
+	}
+	m.CPU.Load = CPULoad(prev, m)
+
+	return m, nil
+}
+
+// CPULoad returns percents of all cores container consumed between two samples, -1 if samples are not consecutive
+func CPULoad(prev, cur *ContainerMetrics) float64 {
+	cores, ok := cgroup.CPURate(cgroup.CPUSample{Usage: prev.CPU.Usage, Time: prev.Collected},
+		cgroup.CPUSample{Usage: cur.CPU.Usage, Time: cur.Collected})
+	if !ok {
+		return -1
+	}
+
+	//load is relative to all cores
+	return cores * 100 / float64(runtime.NumCPU())
+}
+
+// vethPair returns host side of veth interface of container
+func vethPair(name string) string {
+	if common.GetMajorVersion() < 3 {
+		return container.GetProperty(name, "lxc.network.veth.pair")
+	}
+	return container.GetProperty(name, "lxc.net.0.veth.pair")
+}
+
+// parseBlkio sums read and write values of blkio.throttle items over devices, lines are in form "8:0 Read 1024"
+func parseBlkio(value string) (read, write uint64) {
+	scanner := bufio.NewScanner(strings.NewReader(value))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			read += n
+		case "Write":
+			write += n
+		}
+	}
+
+	return read, write
+}
+
+// parseKeyed parses cgroup items with lines in form "key value", e.g. memory.stat
+func parseKeyed(value string) map[string]uint64 {
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(strings.NewReader(value))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+
+	return values
+}
+
+func parseUint(value string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+}
+
+//counters of missing interface are reported as zero
+func readUint(file string) uint64 {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	n, _ := parseUint(string(data))
+
+	return n
+}
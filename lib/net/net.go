@@ -3,7 +3,6 @@ package net
 import (
 	"strings"
 	"github.com/subutai-io/agent/log"
-	"os/exec"
 	exc "github.com/subutai-io/agent/lib/exec"
 	"net"
 	"strconv"
@@ -16,19 +15,76 @@ import (
 
 func DelIface(iface string) {
 	log.Debug("Removing interface " + iface)
-	exec.Command("ovs-vsctl", "--if-exists", "del-br", iface).Run()
-	exec.Command("ovs-vsctl", "--if-exists", "del-port", iface).Run()
-	exec.Command("ip", "set", "dev", iface, "down").Run()
+	exc.Exec("ovs-vsctl", "--if-exists", "del-br", iface)
+	exc.Exec("ovs-vsctl", "--if-exists", "del-port", iface)
+	exc.Exec("ip", "set", "dev", iface, "down")
 }
 
+// IsValidSocket checks that socket is address and port of a server, IPv6 address must be enclosed in brackets,
+// e.g. 10.10.10.100:80 or [fd00::100]:80
 func IsValidSocket(socket string) bool {
-	if addr := strings.Split(socket, ":"); len(addr) == 2 {
-		if _, err := net.ResolveIPAddr("ip4", addr[0]); err == nil {
-			if port, err := strconv.Atoi(addr[1]); err == nil && port < 65536 {
-				return true
+	host, portStr, err := net.SplitHostPort(socket)
+	if err != nil {
+		return false
+	}
+	if net.ParseIP(host) == nil {
+		if _, err := net.ResolveIPAddr("ip", host); err != nil {
+			return false
+		}
+	}
+	port, err := strconv.Atoi(portStr)
+	return err == nil && port > 0 && port < 65536
+}
+
+// SocketHost returns address part of server socket, brackets of IPv6 address are removed
+func SocketHost(socket string) string {
+	if host, _, err := net.SplitHostPort(socket); err == nil {
+		return host
+	}
+	return socket
+}
+
+// ParseNetworks parses comma separated addresses and networks in CIDR notation, single address is a network
+// of its own
+func ParseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
 			}
 		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// InNetworks checks if address, with or without port, belongs to one of networks
+func InNetworks(addr string, networks []*net.IPNet) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
 	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
 	return false
 }
 
@@ -41,37 +97,37 @@ func RemoveP2pIface(name string) {
 			mac = iface.HardwareAddr.String()
 		}
 	}
-	out, _ := exec.Command("p2p", "show").Output()
+	out, _ := exc.Output("p2p", "show")
 	scanner := bufio.NewScanner(bytes.NewReader(out))
 	for scanner.Scan() {
 		line := strings.Fields(scanner.Text())
 		if len(line) > 1 && line[0] == mac {
-			log.Check(log.WarnLevel, "Removing p2p interface", exec.Command("p2p", "stop", "-hash", line[2]).Run())
+			log.Check(log.WarnLevel, "Removing p2p interface", exc.Exec("p2p", "stop", "-hash", line[2]))
 
 		}
 	}
-	log.Check(log.WarnLevel, "Removing p2p interface from registered list", exec.Command("p2p", "stop", "--dev", name).Run())
-	log.Check(log.WarnLevel, "Removing p2p interface from system", exec.Command("ip", "link", "delete", name).Run())
-	log.Check(log.WarnLevel, "Disabling p2p link", exec.Command("ip", "set", "dev", name, "down").Run())
+	log.Check(log.WarnLevel, "Removing p2p interface from registered list", exc.Exec("p2p", "stop", "--dev", name))
+	log.Check(log.WarnLevel, "Removing p2p interface from system", exc.Exec("ip", "link", "delete", name))
+	log.Check(log.WarnLevel, "Disabling p2p link", exc.Exec("ip", "set", "dev", name, "down"))
 	iptablesCleanUp(name)
 }
 
 // iptablesCleanUp removes Iptables rules applied for passed interface
 func iptablesCleanUp(name string) {
-	out, _ := exec.Command("iptables-save").Output()
+	out, _ := exc.Output("iptables-save")
 	scanner := bufio.NewScanner(bytes.NewReader(out))
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.Contains(line, name) {
 			args := strings.Fields(line)
 			args[0] = "-D"
-			exec.Command("iptables", append([]string{"-t", "nat"}, args...)...).Run()
+			exc.Exec("iptables", append([]string{"-t", "nat"}, args...)...)
 		}
 	}
 }
 
 func GetP2pMtu() (int, error){
-	out, err := exec.Command("p2p", "show", "--mtu").CombinedOutput()
+	out, err := exc.CombinedOutput("p2p", "show", "--mtu")
 	output := strings.TrimSpace(string(out))
 	if log.Check(log.DebugLevel, "Getting p2p mtu: "+output, err) {
 		return -1, errors.New(fmt.Sprintf("Error getting p2p mtu: %s", err.Error()))
@@ -86,20 +142,24 @@ func GetP2pMtu() (int, error){
 	return mtu - 50, nil
 }
 
+// SetRateLimit limits rate of traffic ovs interface receives from container to kbps, 0 removes limit
+func SetRateLimit(nic string, kbps int) error {
+	err := exc.Exec("ovs-vsctl", "set", "interface", nic, "ingress_policing_rate="+strconv.Itoa(kbps))
+	if err != nil {
+		return err
+	}
+
+	return exc.Exec("ovs-vsctl", "set", "interface", nic, "ingress_policing_burst="+strconv.Itoa(kbps/10))
+}
+
 // RateLimit sets throughput limits for container's network interfaces if "quota" is specified
 func RateLimit(nic string, rate string) string {
 	if rate != "" {
-		burst, _ := strconv.Atoi(rate)
-		burst = burst / 10
-
-		exec.Command("ovs-vsctl", "set", "interface", nic,
-			"ingress_policing_rate="+rate).Run()
-
-		exec.Command("ovs-vsctl", "set", "interface", nic,
-			"ingress_policing_burst="+strconv.Itoa(burst)).Run()
+		kbps, _ := strconv.Atoi(rate)
+		log.Check(log.DebugLevel, "Setting rate limit of "+nic, SetRateLimit(nic, kbps))
 	}
 
-	out, _ := exec.Command("ovs-vsctl", "list", "interface", nic).Output()
+	out, _ := exc.Output("ovs-vsctl", "list", "interface", nic)
 
 	scanner := bufio.NewScanner(bytes.NewReader(out))
 	for scanner.Scan() {
@@ -125,3 +185,36 @@ func GetIp() string {
 
 	return ip
 }
+
+// BindAddress resolves bind setting of port mapping to host address: IP address, including secondary one,
+// must be assigned to a host interface, for interface name its first IPv4 address is returned
+func BindAddress(bind string) (string, error) {
+	if ip := net.ParseIP(bind); ip != nil {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return "", err
+		}
+		for _, addr := range addrs {
+			if hostIp, _, err := net.ParseCIDR(addr.String()); err == nil && hostIp.Equal(ip) {
+				return ip.String(), nil
+			}
+		}
+		return "", errors.Errorf("Address %s is not assigned to any host interface", bind)
+	}
+
+	iface, err := net.InterfaceByName(bind)
+	if err != nil {
+		return "", errors.Errorf("Interface %s not found", bind)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ip, _, err := net.ParseCIDR(addr.String()); err == nil && ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+
+	return "", errors.Errorf("Interface %s has no IPv4 address", bind)
+}
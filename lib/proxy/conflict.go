@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	gonet "net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/net"
+)
+
+// sources of port conflicts
+const (
+	ConflictListener = "listener"
+	ConflictNginx    = "nginx"
+	ConflictFirewall = "firewall"
+)
+
+var (
+	listenRx = regexp.MustCompile(`^\s*listen\s+(?:\S*:)?(\d+)\b`)
+	dportRx  = regexp.MustCompile(`--dports?\s+(\S+)`)
+)
+
+// PortConflict describes host service or setting which prevents proxy from using port
+type PortConflict struct {
+	// Source is one of ConflictListener, ConflictNginx or ConflictFirewall
+	Source string `json:"source"`
+	//tcp or udp
+	Transport string `json:"transport"`
+	Port      int    `json:"port"`
+	//host address port is busy on, for listeners only
+	Address string `json:"address,omitempty"`
+	//process listening on port, nginx config file or firewall rule
+	Detail string `json:"detail"`
+}
+
+func (c PortConflict) String() string {
+	switch c.Source {
+	case ConflictListener:
+		return fmt.Sprintf("%d/%s is in use on %s by %s", c.Port, c.Transport, c.Address, c.Detail)
+	case ConflictNginx:
+		return fmt.Sprintf("%d/%s is used by nginx config %s not managed by agent", c.Port, c.Transport, c.Detail)
+	default:
+		return fmt.Sprintf("%d/%s is claimed by firewall rule \"%s\"", c.Port, c.Transport, c.Detail)
+	}
+}
+
+// PortConflictError is returned when proxy can not be created because of port conflicts
+type PortConflictError struct {
+	Conflicts []PortConflict
+}
+
+func (e PortConflictError) Error() string {
+	var descriptions []string
+	for _, c := range e.Conflicts {
+		descriptions = append(descriptions, c.String())
+	}
+	return "Port is busy: " + strings.Join(descriptions, "; ")
+}
+
+// CheckPort finds conflicts of port with services and settings of host which are not managed by agent:
+// processes listening on any host address, nginx configs not created by agent and firewall rules
+// redirecting or blocking the port. Ports used by proxies recorded in db are not reported by listener check,
+// NAT reflection rules of proxies are not reported by firewall check.
+// If bind is set, listener check is limited to address of this host interface or address
+func CheckPort(protocol, bind string, port int) ([]PortConflict, error) {
+	transport := TCP
+	if protocol == UDP {
+		transport = UDP
+	}
+
+	var conflicts []PortConflict
+
+	bindIp := ""
+	if bind != "" {
+		var err error
+		if bindIp, err = net.BindAddress(bind); err != nil {
+			return nil, err
+		}
+	}
+
+	proxies, err := db.FindProxies("", "", port)
+	if err != nil {
+		return nil, err
+	}
+	//nginx already listens on port for other proxies of the same transport
+	nginxListens := false
+	for _, p := range proxies {
+		if (p.Protocol == UDP) == (transport == UDP) {
+			nginxListens = true
+		}
+	}
+
+	if !nginxListens {
+		conflicts = append(conflicts, listenerConflicts(transport, bindIp, port)...)
+	}
+
+	nginxConflicts, err := nginxConflicts(transport, port)
+	if err != nil {
+		return nil, err
+	}
+	conflicts = append(conflicts, nginxConflicts...)
+
+	return append(conflicts, firewallConflicts(transport, port)...), nil
+}
+
+// listenerConflicts tries to bind port on bindIp or, if it is empty, on every host address
+func listenerConflicts(transport, bindIp string, port int) []PortConflict {
+	addrs, err := gonet.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	owners := portOwners(transport, port)
+
+	var conflicts []PortConflict
+	for _, addr := range addrs {
+		ip, _, err := gonet.ParseCIDR(addr.String())
+		if err != nil || ip.IsLinkLocalUnicast() || (bindIp != "" && ip.String() != bindIp) {
+			continue
+		}
+
+		socket := gonet.JoinHostPort(ip.String(), strconv.Itoa(port))
+		if transport == UDP {
+			conn, err := gonet.ListenPacket(UDP, socket)
+			if err == nil {
+				conn.Close()
+				continue
+			}
+		} else {
+			listener, err := gonet.Listen(TCP, socket)
+			if err == nil {
+				listener.Close()
+				continue
+			}
+		}
+
+		owner := owners[ip.String()]
+		if owner == "" {
+			owner = owners["*"]
+		}
+		if owner == "" {
+			owner = "unknown process"
+		}
+		conflicts = append(conflicts, PortConflict{Source: ConflictListener, Transport: transport, Port: port,
+			Address: ip.String(), Detail: owner})
+	}
+
+	return conflicts
+}
+
+// portOwners returns processes listening on port keyed by local address, "*" for wildcard address.
+// Missing ss utility or insufficient privileges just leave owners unknown
+func portOwners(transport string, port int) map[string]string {
+	owners := make(map[string]string)
+
+	flag := "-t"
+	if transport == UDP {
+		flag = "-u"
+	}
+	out, err := exec.ExecuteNoLog("ss", "-H", "-l", "-n", "-p", flag, "sport = :"+strconv.Itoa(port))
+	if err != nil {
+		return owners
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		host, _, err := gonet.SplitHostPort(fields[3])
+		if err != nil {
+			continue
+		}
+		host = strings.Trim(strings.Split(host, "%")[0], "[]")
+		if host == "0.0.0.0" || host == "::" || host == "*" {
+			host = "*"
+		}
+		owner := "unknown process"
+		if len(fields) > 5 {
+			owner = strings.Join(fields[5:], " ")
+		}
+		owners[host] = owner
+	}
+
+	return owners
+}
+
+// nginxConflicts finds listen directives for port in nginx configs which do not belong to proxies recorded in db
+func nginxConflicts(transport string, port int) ([]PortConflict, error) {
+	proxies, err := db.FindProxies("", "", 0)
+	if err != nil {
+		return nil, err
+	}
+	managed := make(map[string]bool)
+	for i := range proxies {
+		managed[configPath(&proxies[i])] = true
+		if proxies[i].IsLE() {
+			managed[path.Join(nginxInc, HTTP, proxies[i].Domain+"-80.conf")] = true
+		}
+	}
+
+	var conflicts []PortConflict
+	err = filepath.Walk(nginxInc, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || managed[file] || !strings.HasSuffix(file, ".conf") {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			match := listenRx.FindStringSubmatch(line)
+			if match == nil || match[1] != strconv.Itoa(port) {
+				continue
+			}
+			if strings.Contains(line, " udp") == (transport == UDP) {
+				conflicts = append(conflicts, PortConflict{Source: ConflictNginx, Transport: transport, Port: port, Detail: file})
+				break
+			}
+		}
+		return nil
+	})
+
+	return conflicts, err
+}
+
+// firewallConflicts finds iptables rules redirecting, dropping or rejecting traffic to port
+func firewallConflicts(transport string, port int) []PortConflict {
+	out, err := exec.ExecuteNoLog("iptables-save")
+	if err != nil {
+		return nil
+	}
+
+	var conflicts []PortConflict
+	for _, rule := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(rule, "-A ") || !strings.Contains(rule, "-p "+transport) {
+			continue
+		}
+		//NAT reflection rules of proxies are managed by agent
+		if reflectTag(strings.Fields(rule)) != "" {
+			continue
+		}
+		if !(strings.Contains(rule, "-j DNAT") || strings.Contains(rule, "-j REDIRECT") ||
+			strings.Contains(rule, "-j DROP") || strings.Contains(rule, "-j REJECT")) {
+			continue
+		}
+
+		match := dportRx.FindStringSubmatch(rule)
+		if match != nil && portInSpec(port, match[1]) {
+			conflicts = append(conflicts, PortConflict{Source: ConflictFirewall, Transport: transport, Port: port, Detail: rule})
+		}
+	}
+
+	return conflicts
+}
+
+// portInSpec checks if port is listed in iptables port spec, e.g. 80, 8000:8080 or 80,443
+func portInSpec(port int, spec string) bool {
+	for _, item := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(item, ":", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+		if port >= from && port <= to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/subutai-io/agent/db"
+)
+
+// ConfigState describes nginx config of proxy compared to one rendered from db
+type ConfigState struct {
+	Tag  string
+	File string
+	//Missing is set when proxy has servers but config file does not exist
+	Missing bool
+	//Modified is set when config file differs from rendered one, e.g. after manual edit
+	Modified bool
+}
+
+// CheckConfigs compares nginx configs of all proxies having active servers with configs rendered from db.
+// Only configs which are out of sync are returned
+func CheckConfigs() ([]ConfigState, error) {
+	proxies, err := db.FindProxies("", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []ConfigState
+	for i := range proxies {
+		proxy := &proxies[i]
+
+		servers, err := db.FindProxiedServers(proxy.Tag, "")
+		if err != nil {
+			return nil, err
+		}
+		//proxy without servers has no config
+		if !hasConfig(proxy, servers) {
+			continue
+		}
+
+		expected, err := renderConfig(proxy, servers)
+		if err != nil {
+			return nil, err
+		}
+
+		state := ConfigState{Tag: proxy.Tag, File: configPath(proxy)}
+		actual, err := ioutil.ReadFile(state.File)
+		if os.IsNotExist(err) {
+			state.Missing = true
+		} else if err != nil {
+			return nil, err
+		} else if string(actual) != expected {
+			state.Modified = true
+		}
+
+		if state.Missing || state.Modified {
+			states = append(states, state)
+		}
+	}
+
+	return states, nil
+}
+
+// RewriteConfig renders nginx config of proxy from db again and reloads nginx
+func RewriteConfig(tag string) error {
+	return applyConfig(tag, false)
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+// Country of client is looked up by nginx geoip module in database set by geoipDb option of [proxy] config section.
+// The database is loaded by shared include which exists while any proxy has country policy
+var geoipInclude = path.Join(nginxInc, HTTP, "subutai-geoip.conf")
+
+var (
+	countryRx = regexp.MustCompile(`^[A-Z]{2}$`)
+	nonWordRx = regexp.MustCompile(`\W`)
+)
+
+//place-holders: {db}
+const geoipDirective = `geoip_country {db};
+`
+
+//variable is set to 1 for blocked countries
+//place-holders: {var}, {default}, {countries}
+const geoMap = `
+map $geoip_country_code ${var} {
+    default {default};
+{countries}}
+`
+
+//place-holders: {var}
+const geoCheck = `
+    if (${var}) {
+        return 403;
+    }
+`
+
+// SetGeoPolicy restricts access to http(s) proxies of domain by country of client: only countries from allow
+// or all but countries from deny are let through. If logCountries is set, requests are counted per country
+// in proxy statistics. Empty policy without logging turns country lookup off for domain
+func SetGeoPolicy(domain string, allow, deny []string, logCountries bool) error {
+	allow, err := normalizeCountries(allow)
+	if err != nil {
+		return err
+	}
+	deny, err = normalizeCountries(deny)
+	if err != nil {
+		return err
+	}
+	if len(allow) > 0 && len(deny) > 0 {
+		return errors.New("Either allowed or denied countries may be specified")
+	}
+
+	enabled := len(allow) > 0 || len(deny) > 0 || logCountries
+	if enabled {
+		if !fs.FileExists(config.Proxy.GeoipDb) {
+			return errors.Errorf("GeoIP database %s not found", config.Proxy.GeoipDb)
+		}
+		content := strings.Replace(geoipDirective, "{db}", config.Proxy.GeoipDb, -1)
+		if err := writeIfChanged(geoipInclude, content); err != nil {
+			return errors.Wrap(err, "Saving GeoIP nginx config")
+		}
+	}
+
+	err = updateDomain(domain, func(proxy *db.Proxy) {
+		proxy.GeoAllow, proxy.GeoDeny, proxy.GeoLog = allow, deny, logCountries
+	})
+	if err != nil {
+		return err
+	}
+
+	return removeUnusedGeoip()
+}
+
+func normalizeCountries(countries []string) ([]string, error) {
+	var result []string
+	for _, country := range countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if country == "" {
+			continue
+		}
+		if !countryRx.MatchString(country) {
+			return nil, errors.Errorf("Invalid country code %s, ISO 3166 alpha-2 code expected", country)
+		}
+		result = append(result, country)
+	}
+	return result, nil
+}
+
+func usesGeoip(proxy *db.Proxy) bool {
+	return isWeb(proxy) && (len(proxy.GeoAllow) > 0 || len(proxy.GeoDeny) > 0 || proxy.GeoLog)
+}
+
+// removeUnusedGeoip removes GeoIP include once no proxy needs it, so nginx works without geoip module
+func removeUnusedGeoip() error {
+	proxies, err := db.FindProxies("", "", 0)
+	if err != nil {
+		return err
+	}
+	for i := range proxies {
+		if usesGeoip(&proxies[i]) {
+			return nil
+		}
+	}
+
+	err = os.Remove(geoipInclude)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func writeIfChanged(file, content string) error {
+	if current, err := ioutil.ReadFile(file); err == nil && string(current) == content {
+		return nil
+	}
+	return ioutil.WriteFile(file, []byte(content), 0644)
+}
+
+// geoVariable returns name of nginx variable holding result of country check for proxy,
+// checksum keeps names unique since variable names allow only letters, digits and underscores
+func geoVariable(proxy *db.Proxy) string {
+	name := statsName(proxy)
+	return fmt.Sprintf("subutai_geo_%s_%08x", nonWordRx.ReplaceAllString(name, "_"),
+		crc32.ChecksumIEEE([]byte(name)))
+}
+
+// geoDirectives returns map of countries at http level and check of it in server block of proxy
+func geoDirectives(proxy *db.Proxy) (string, string) {
+	if !isWeb(proxy) || (len(proxy.GeoAllow) == 0 && len(proxy.GeoDeny) == 0) {
+		return "", ""
+	}
+
+	countries, blocked, listed := proxy.GeoDeny, "0", "1"
+	if len(proxy.GeoAllow) > 0 {
+		countries, blocked, listed = proxy.GeoAllow, "1", "0"
+	}
+
+	entries := ""
+	for _, country := range countries {
+		entries += "    " + country + " " + listed + ";\n"
+	}
+
+	variable := geoVariable(proxy)
+	geoMapConfig := strings.Replace(geoMap, "{var}", variable, -1)
+	geoMapConfig = strings.Replace(geoMapConfig, "{default}", blocked, -1)
+	geoMapConfig = strings.Replace(geoMapConfig, "{countries}", entries, -1)
+
+	return geoMapConfig, strings.Replace(geoCheck, "{var}", variable, -1)
+}
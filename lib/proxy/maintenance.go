@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// DefaultSet is a set of servers used when set is not specified
+const DefaultSet = "default"
+
+var (
+	serverSetRx = regexp.MustCompile(`^[[:alnum:]_-]+$`)
+	//dot separated labels, the first one may be wildcard
+	domainRx = regexp.MustCompile(`^(\*|[[:alnum:]_]([[:alnum:]_-]*[[:alnum:]])?)(\.[[:alnum:]_]([[:alnum:]_-]*[[:alnum:]])?)*$`)
+	//maintenance pages are kept per domain in own directories served as nginx root
+	maintenancePagesDir = path.Join(config.Agent.DataPrefix, "web/maintenance")
+)
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html>
+<head><title>Maintenance</title></head>
+<body>
+<h1>Down for maintenance</h1>
+<p>The service is being updated and will be back shortly.</p>
+</body>
+</html>
+`
+
+// ServerSet returns name of set server belongs to
+func ServerSet(server db.ProxiedServer) string {
+	if server.Set == "" {
+		return DefaultSet
+	}
+	return server.Set
+}
+
+// ActiveSet returns name of set of servers proxy passes requests to
+func ActiveSet(proxy *db.Proxy) string {
+	if proxy.ActiveSet == "" {
+		return DefaultSet
+	}
+	return proxy.ActiveSet
+}
+
+func maintenanceDir(domain string) string {
+	return path.Join(maintenancePagesDir, domain)
+}
+
+// SetMaintenance turns maintenance of http(s) proxies of domain on or off. While it is on, nginx returns
+// page with status 503 instead of proxying requests. Page is replaced with content of file if it is not empty,
+// otherwise previously set or default page is served
+func SetMaintenance(domain string, on bool, page string) error {
+	domain = strings.ToLower(domain)
+	if on {
+		if err := installMaintenancePage(domain, page); err != nil {
+			return err
+		}
+	}
+
+	return updateDomain(domain, func(proxy *db.Proxy) {
+		proxy.Maintenance = on
+	})
+}
+
+// SwitchServerSet makes set of servers active for http(s) proxies of domain, e.g. to switch from blue deployment
+// to green one. Every proxy of domain must have servers in the set unless it is in maintenance
+func SwitchServerSet(domain, set string) error {
+	if !serverSetRx.MatchString(set) {
+		return errors.Errorf("Invalid server set %s", set)
+	}
+
+	return updateDomain(domain, func(proxy *db.Proxy) {
+		proxy.ActiveSet = set
+	})
+}
+
+func installMaintenancePage(domain, page string) error {
+	//domain names directory of page
+	if len(domain) > 253 || !domainRx.MatchString(domain) {
+		return errors.Errorf("Invalid domain %s", domain)
+	}
+
+	dir := maintenanceDir(domain)
+	file := path.Join(dir, "maintenance.html")
+
+	content := []byte(defaultMaintenancePage)
+	if page != "" {
+		var err error
+		if content, err = ioutil.ReadFile(page); err != nil {
+			return errors.Wrap(err, "Reading maintenance page")
+		}
+	} else if fs.FileExists(file) {
+		return nil
+	}
+
+	if err := makeDir(dir); err != nil {
+		return errors.Wrap(err, "Creating maintenance page directory")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(file, content, 0644), "Saving maintenance page")
+}
+
+// updateDomain changes http(s) proxies of domain and applies their configs with single nginx reload.
+// Configs of all proxies are rendered before any change is saved, and changes already applied are rolled back
+// if applying any proxy or reloading nginx fails, so either all proxies are changed or none
+func updateDomain(domain string, change func(proxy *db.Proxy)) error {
+	var err error
+	var lock lockfile.Lockfile
+	for lock, err = common.LockFile("port", "server"); err != nil; lock, err = common.LockFile("port", "server") {
+		time.Sleep(time.Second * 1)
+	}
+	defer lock.Unlock()
+
+	proxies, err := db.FindProxies("", strings.ToLower(domain), 0)
+	if err != nil {
+		return errors.Wrap(err, "Looking up proxies in db")
+	}
+
+	type update struct {
+		proxy    db.Proxy
+		original db.Proxy
+		servers  []db.ProxiedServer
+	}
+	var updates []update
+	for _, proxy := range proxies {
+		if !isWeb(&proxy) {
+			continue
+		}
+
+		servers, err := db.FindProxiedServers(proxy.Tag, "")
+		if err != nil {
+			return errors.Wrap(err, "Looking up servers in db")
+		}
+
+		original := proxy
+		change(&proxy)
+		if !hasConfig(&proxy, servers) && len(servers) > 0 {
+			return errors.Errorf("Proxy %s has no servers in set %s", proxy.Tag, ActiveSet(&proxy))
+		}
+		if hasConfig(&proxy, servers) {
+			if _, err := renderConfig(&proxy, servers); err != nil {
+				return err
+			}
+		}
+
+		updates = append(updates, update{proxy, original, servers})
+	}
+	if len(updates) == 0 {
+		return errors.Errorf("No http(s) proxies found for domain %s", domain)
+	}
+
+	for i := range updates {
+		if err = applyDomainProxy(&updates[i].proxy, updates[i].servers); err != nil {
+			//nginx is not reloaded yet, so restoring configs of changed proxies is enough
+			for j := i; j >= 0; j-- {
+				log.Check(log.WarnLevel, "Restoring proxy "+updates[j].original.Tag,
+					applyDomainProxy(&updates[j].original, updates[j].servers))
+			}
+			return err
+		}
+	}
+
+	if err = reloadNginx(); err != nil {
+		//nginx keeps running previous configs if reload fails
+		for i := range updates {
+			log.Check(log.WarnLevel, "Restoring proxy "+updates[i].original.Tag,
+				applyDomainProxy(&updates[i].original, updates[i].servers))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// applyDomainProxy saves proxy and writes its nginx config and NAT reflection, nginx is not reloaded
+func applyDomainProxy(proxy *db.Proxy, servers []db.ProxiedServer) error {
+	if err := db.SaveProxy(proxy); err != nil {
+		return errors.Wrap(err, "Saving proxy to db")
+	}
+
+	var err error
+	active := hasConfig(proxy, servers)
+	if active {
+		err = createConfig(proxy, servers)
+	} else {
+		err = removeConfig(*proxy)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Applying nginx config of proxy "+proxy.Tag)
+	}
+
+	return errors.Wrap(applyReflection(proxy, active), "Applying NAT reflection of proxy "+proxy.Tag)
+}
@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/log"
+)
+
+// CA bundles and CRLs used to verify client certificates are kept per domain
+var clientAuthDir = path.Join(config.Agent.DataPrefix, "web/mtls")
+
+//place-holders: {ca}
+const clientAuthDirectives = `
+    ssl_client_certificate {ca};
+    ssl_verify_client on;
+`
+
+//place-holders: {crl}
+const clientCrlDirective = `    ssl_crl {crl};
+`
+
+//place-holders: {resolver}
+const clientOcspDirective = `    ssl_ocsp on;
+    resolver {resolver};
+`
+
+// nginx checks client certificates with OCSP since 1.19.0
+var (
+	nginxVersionRx   = regexp.MustCompile(`nginx/(\d+)\.(\d+)\.(\d+)`)
+	ocspNginxVersion = [3]int{1, 19, 0}
+)
+
+// SetClientAuth makes https proxies of domain require client certificates signed by CA from caBundle file.
+// Certificates are checked against CRL from crl file if it is not empty and with OCSP responders named
+// in them if ocsp is set, which requires nginx 1.19.0 or newer. Empty caBundle turns the requirement off
+func SetClientAuth(domain, caBundle, crl string, ocsp bool) error {
+	domain = strings.ToLower(domain)
+	dir := path.Join(clientAuthDir, domain)
+
+	proxies, err := db.FindProxies(HTTPS, domain, 0)
+	if err != nil {
+		return errors.Wrap(err, "Looking up proxies in db")
+	}
+	if len(proxies) == 0 {
+		return errors.Errorf("No https proxies found for domain %s", domain)
+	}
+
+	if caBundle == "" {
+		if crl != "" || ocsp {
+			return errors.New("CRL and OCSP checks require CA bundle")
+		}
+		if err := updateHttps(domain, func(proxy *db.Proxy) {
+			proxy.ClientCa, proxy.ClientCrl, proxy.ClientOcsp = "", "", false
+		}); err != nil {
+			return err
+		}
+		return os.RemoveAll(dir)
+	}
+
+	if ocsp {
+		if err := checkOcsp(); err != nil {
+			return err
+		}
+	}
+
+	if err := makeDir(dir); err != nil {
+		return errors.Wrap(err, "Creating client certificates directory")
+	}
+
+	ca := path.Join(dir, "ca.pem")
+	if err := copyPem(caBundle, ca, "CERTIFICATE"); err != nil {
+		return errors.Wrap(err, "Installing CA bundle")
+	}
+
+	installedCrl := ""
+	if crl != "" {
+		installedCrl = path.Join(dir, "crl.pem")
+		if err := copyPem(crl, installedCrl, "X509 CRL"); err != nil {
+			return errors.Wrap(err, "Installing CRL")
+		}
+	} else if err := os.Remove(path.Join(dir, "crl.pem")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return updateHttps(domain, func(proxy *db.Proxy) {
+		proxy.ClientCa, proxy.ClientCrl, proxy.ClientOcsp = ca, installedCrl, ocsp
+	})
+}
+
+// updateHttps changes https proxies of domain
+func updateHttps(domain string, change func(proxy *db.Proxy)) error {
+	return updateDomain(domain, func(proxy *db.Proxy) {
+		if proxy.Protocol == HTTPS {
+			change(proxy)
+		}
+	})
+}
+
+// copyPem copies file after checking that it holds PEM block of given type
+func copyPem(src, dst, blockType string) error {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(content), "-----BEGIN "+blockType+"-----") {
+		return errors.Errorf("%s does not contain PEM encoded %s", src, strings.ToLower(blockType))
+	}
+
+	return ioutil.WriteFile(dst, content, 0644)
+}
+
+// clientAuthConfig returns directives requiring client certificates for proxy, empty if they are not required
+func clientAuthConfig(proxy *db.Proxy) string {
+	if proxy.Protocol != HTTPS || proxy.ClientCa == "" {
+		return ""
+	}
+
+	directives := strings.Replace(clientAuthDirectives, "{ca}", proxy.ClientCa, -1)
+	if proxy.ClientCrl != "" {
+		directives += strings.Replace(clientCrlDirective, "{crl}", proxy.ClientCrl, -1)
+	}
+	if proxy.ClientOcsp {
+		//unknown directive would fail reload of all proxies, e.g. after nginx is downgraded
+		if err := checkOcsp(); err != nil {
+			log.Warn("Skipping OCSP check of client certificates for " + proxy.Domain + ": " + err.Error())
+		} else {
+			resolver, _ := ocspResolver()
+			directives += strings.Replace(clientOcspDirective, "{resolver}", resolver, -1)
+		}
+	}
+
+	return directives
+}
+
+// checkOcsp checks that installed nginx can check client certificates with OCSP and that DNS servers to resolve
+// responders with are known
+func checkOcsp() error {
+	out, err := exec.CombinedOutput("nginx", "-v")
+	if err != nil {
+		return errors.Wrap(err, "Getting nginx version")
+	}
+	match := nginxVersionRx.FindStringSubmatch(string(out))
+	if match == nil {
+		return errors.Errorf("Unknown nginx version %s", strings.TrimSpace(string(out)))
+	}
+	for i, min := range ocspNginxVersion {
+		v, _ := strconv.Atoi(match[i+1])
+		if v > min {
+			break
+		}
+		if v < min {
+			return errors.Errorf("OCSP check of client certificates requires nginx 1.19.0 or newer, nginx %s.%s.%s is installed",
+				match[1], match[2], match[3])
+		}
+	}
+
+	_, err = ocspResolver()
+	return err
+}
+
+// ocspResolver returns DNS servers nginx resolves OCSP responders with, resolver option of [proxy] config section
+// or nameservers of host
+func ocspResolver() (string, error) {
+	servers := strings.Fields(config.Proxy.Resolver)
+	if len(servers) == 0 {
+		content, err := ioutil.ReadFile("/etc/resolv.conf")
+		if err != nil {
+			return "", errors.Wrap(err, "Reading nameservers of host")
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "nameserver" {
+				servers = append(servers, fields[1])
+			}
+		}
+	}
+
+	var resolvers []string
+	for _, server := range servers {
+		ip := net.ParseIP(server)
+		if ip == nil {
+			return "", errors.Errorf("Invalid DNS server %s", server)
+		}
+		if ip.To4() == nil {
+			server = "[" + server + "]"
+		}
+		resolvers = append(resolvers, server)
+	}
+	if len(resolvers) == 0 {
+		return "", errors.New("No DNS servers to resolve OCSP responders with, set resolver in [proxy] section of agent config")
+	}
+
+	return strings.Join(resolvers, " "), nil
+}
@@ -1,26 +1,28 @@
 package proxy
 
 import (
-	"github.com/nightlyone/lockfile"
-	"time"
-	"strings"
-	"github.com/subutai-io/agent/lib/gpg"
 	"fmt"
-	"os"
-	"io/ioutil"
-	"path/filepath"
-	"sort"
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/db"
 	"github.com/subutai-io/agent/lib/common"
-	"github.com/subutai-io/agent/lib/net"
-	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fault"
 	"github.com/subutai-io/agent/lib/fs"
-	"strconv"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/lib/net"
+	"io/ioutil"
+	gonet "net"
+	"os"
 	"path"
-	"github.com/subutai-io/agent/config"
-	"github.com/subutai-io/agent/lib/exec"
-	"github.com/subutai-io/agent/agent/util"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //todo split this file into types, snippets,
@@ -57,11 +59,11 @@ location /.well-known {
 `
 
 //for https only
-//place-holders: {domain}
+//place-holders: {domain}, {port}, {listen-80}
 const redirect80Section = `
 
 server {
-	listen 80;
+	listen {listen-80};
 	server_name {domain};
 
     {well-known}
@@ -71,8 +73,10 @@ server {
 
 `
 
-//place-holders: {protocol}, {port}, {load-balancing}, {servers}, {udp}
+//place-holders: {protocol}, {port}, {listen}, {load-balancing}, {servers}, {udp}, {stats-format}, {access-log}
 const streamConfig = `
+{stats-format}
+
 upstream {protocol}-{port} {
     {load-balancing}
 
@@ -80,30 +84,46 @@ upstream {protocol}-{port} {
 }
 
 server {
-	listen {port} {udp};
+	listen {listen} {udp};
 	proxy_pass {protocol}-{port};
+	{access-log}
 }
 
 `
 
 //http & https
-//place-holders: {protocol}, {port}, {domain}, {load-balancing}, {servers}, {ssl}, {http2}
+//place-holders: {protocol}, {port}, {listen}, {domain}, {upstream}, {location}, {ssl}, {http2}, {stats-format}, {access-log},
+//{geo-map}, {geo-check}
 const webConfig = `
-upstream {protocol}-{port}-{domain}{
-    {load-balancing}
-
-{servers}
-}
-
+{stats-format}
+{geo-map}{upstream}
 server {
-    listen {port} {http2};
+    listen {listen} {http2};
     server_name {domain};
     client_max_body_size 1G;
-
+    {access-log}
+{geo-check}
 {ssl}
 
     error_page 497	https://$host$request_uri;
+{location}
+	#well-known
+	{well-known}
+}
+
+`
 
+//place-holders: {protocol}, {port}, {domain}, {load-balancing}, {servers}
+const webUpstream = `
+upstream {protocol}-{port}-{domain}{
+    {load-balancing}
+
+{servers}
+}
+`
+
+//place-holders: {protocol}, {port}, {domain}, {ssl-backend}
+const webProxyLocation = `
     location / {
         proxy_pass         http{ssl-backend}://{protocol}-{port}-{domain}; 
         proxy_set_header   X-Real-IP $remote_addr;
@@ -114,11 +134,20 @@ server {
         proxy_set_header   Upgrade $http_upgrade;
         proxy_set_header   Connection $http_connection;
     }
+`
 
-	#well-known
-	{well-known}
-}
+//served instead of proxying requests while domain is in maintenance
+//place-holders: {maintenance-dir}
+const maintenanceLocation = `
+    location / {
+        return 503;
+    }
 
+    error_page 503 /maintenance.html;
+    location = /maintenance.html {
+        root {maintenance-dir};
+        internal;
+    }
 `
 
 const lEConfig = `
@@ -165,6 +194,7 @@ func init() {
 	makeDir(path.Join(nginxInc, HTTP))
 	makeDir(path.Join(nginxInc, TCP))
 	makeDir(path.Join(nginxInc, UDP))
+	makeDir(statsDir)
 }
 
 func GetProxies(protocol string) ([]ProxyNServers, error) {
@@ -197,7 +227,7 @@ func FindProxiedServers(tag, socket string) ([]db.ProxiedServer, error) {
 
 //subutai prxy create -p https -n test.com -e 80 -t 123 [-b round_robin] [--redirect] [-c path/to/cert] [--sslbackend]
 //subutai prxy create -p http -n test.com -e 80 -t 123 [-b round_robin]
-func CreateProxy(protocol, domain, loadBalancing, tag string, port int, redirect80Port, sslBackend bool, certPath string, http2 bool) error {
+func CreateProxy(protocol, domain, loadBalancing, tag string, port int, redirect80Port, sslBackend bool, certPath string, http2 bool, bind, reflect string) error {
 	var err error = nil
 	var lock lockfile.Lockfile
 	for lock, err = common.LockFile("port", "proxy");
@@ -381,6 +411,38 @@ func CreateProxy(protocol, domain, loadBalancing, tag string, port int, redirect
 		http2 = false
 	}
 
+	//check bind interface or address
+	bind = strings.TrimSpace(bind)
+	if bind != "" {
+		if _, err := net.BindAddress(bind); err != nil {
+			return errors.New(fmt.Sprintf("Invalid bind setting: %s", err.Error()))
+		}
+	}
+
+	//check external address for NAT reflection
+	reflect = strings.TrimSpace(reflect)
+	if reflect != "" && gonet.ParseIP(reflect).To4() == nil {
+		return errors.New("Reflection address must be a valid IPv4 address")
+	}
+
+	//check that services of host do not occupy ports needed by proxy,
+	//https proxies redirecting port 80 or using LE certs need port 80 too
+	ports := []int{port}
+	if protocol == HTTPS && port != 80 && (redirect80Port || certPath == "") {
+		ports = append(ports, 80)
+	}
+	var conflicts []PortConflict
+	for _, p := range ports {
+		found, err := CheckPort(protocol, bind, p)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error checking port %d: %s", p, err.Error()))
+		}
+		conflicts = append(conflicts, found...)
+	}
+	if len(conflicts) > 0 {
+		return PortConflictError{Conflicts: conflicts}
+	}
+
 	//save proxy
 	proxy = &db.Proxy{
 		Protocol:       protocol,
@@ -392,6 +454,8 @@ func CreateProxy(protocol, domain, loadBalancing, tag string, port int, redirect
 		LoadBalancing:  loadBalancing,
 		SslBackend:     sslBackend,
 		Http2:          http2,
+		Bind:           bind,
+		Reflect:        reflect,
 	}
 
 	err = db.SaveProxy(proxy)
@@ -424,7 +488,15 @@ func RemoveProxy(tag string) error {
 	return nil
 }
 
-func AddProxiedServer(tag, socket string) error {
+// AddProxiedServer adds server to set of proxy, default set is used if set is empty.
+// Only servers of active set of proxy receive requests
+func AddProxiedServer(tag, socket, set string) error {
+	if set == "" {
+		set = DefaultSet
+	}
+	if !serverSetRx.MatchString(set) {
+		return errors.New(fmt.Sprintf("Invalid server set %s, only letters, digits, - and _ are allowed", set))
+	}
 
 	var err error = nil
 	var lock lockfile.Lockfile
@@ -456,9 +528,11 @@ func AddProxiedServer(tag, socket string) error {
 		return errors.New(fmt.Sprintf("Server socket is not valid"))
 	}
 
-	if proxy.Port == 8443 || proxy.Port == 8444 || proxy.Port == 8086 {
+	port := strconv.Itoa(proxy.Port)
+	if port == config.Management.Port || port == config.Management.SecurePort || port == config.Management.MetricsPort {
 		//check that server is management container
-		if "10.10.10.1:"+strconv.Itoa(proxy.Port) != socket {
+		if host := net.SocketHost(socket); host != config.Management.ContainerIp &&
+			(host != config.Management.ContainerIpv6 || config.Management.ContainerIpv6 == "") {
 			return errors.New("Reserved system port")
 		}
 	}
@@ -466,6 +540,7 @@ func AddProxiedServer(tag, socket string) error {
 	proxiedServer := &db.ProxiedServer{
 		ProxyTag: tag,
 		Socket:   socket,
+		Set:      set,
 	}
 
 	err = db.SaveProxiedServer(proxiedServer)
@@ -517,12 +592,17 @@ func applyConfig(tag string, creating bool) error {
 		return errors.New(fmt.Sprintf("Error looking up server in db: %s", err.Error()))
 	}
 
-	if len(proxiedServers) > 0 {
+	if hasConfig(proxy, proxiedServers) {
 		//create config
 		err = createConfig(proxy, proxiedServers)
 		if err != nil {
 			return errors.New(fmt.Sprintf("Error creating nginx config: %s", err.Error()))
 		}
+
+		err = applyReflection(proxy, true)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error applying NAT reflection: %s", err.Error()))
+		}
 	} else {
 		if creating {
 			//Install certificates for https
@@ -543,6 +623,11 @@ func applyConfig(tag string, creating bool) error {
 			if err != nil {
 				return errors.New(fmt.Sprintf("Error removing nginx config: %s", err.Error()))
 			}
+
+			err = removeReflection(proxy)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Error removing NAT reflection: %s", err.Error()))
+			}
 		}
 	}
 
@@ -599,6 +684,10 @@ func obtainLECerts(proxy *db.Proxy) error {
 }
 
 func reloadNginx() error {
+	if err := fault.Fail(fault.NginxReload); err != nil {
+		return errors.New(fmt.Sprintf("Error reloading nginx: %s", err.Error()))
+	}
+
 	out, err := exec.Execute("service", "subutai-nginx", "reload")
 	if err != nil {
 		return errors.New(fmt.Sprintf("Error reloading nginx: %s", out+", "+err.Error()))
@@ -674,16 +763,70 @@ func generateLEConfig(proxy *db.Proxy) error {
 	return nil
 }
 
-func createConfig(proxy *db.Proxy, servers []db.ProxiedServer) error {
-	cfg := ""
-	var err error
+// activeServers returns servers of proxy which belong to its active set
+func activeServers(proxy *db.Proxy, servers []db.ProxiedServer) []db.ProxiedServer {
+	var active []db.ProxiedServer
+	for _, server := range servers {
+		if ServerSet(server) == ActiveSet(proxy) {
+			active = append(active, server)
+		}
+	}
+	return active
+}
+
+// hasConfig tells if nginx config is rendered for proxy: it must have servers in active set
+// or be in maintenance, which does not need servers
+func hasConfig(proxy *db.Proxy, servers []db.ProxiedServer) bool {
+	return len(activeServers(proxy, servers)) > 0 || (proxy.Maintenance && isWeb(proxy))
+}
+
+func isWeb(proxy *db.Proxy) bool {
+	return proxy.Protocol == HTTP || proxy.Protocol == HTTPS
+}
+
+// renderConfig composes nginx config of proxy with servers of its active set
+func renderConfig(proxy *db.Proxy, servers []db.ProxiedServer) (string, error) {
+	servers = activeServers(proxy, servers)
+
 	if proxy.Protocol == HTTPS || proxy.Protocol == HTTP {
-		cfg, err = createHttpHttpsConfig(proxy, servers)
+		cfg, err := createHttpHttpsConfig(proxy, servers)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Error composing http(s) nginx config: %s", err.Error()))
+			return "", errors.New(fmt.Sprintf("Error composing http(s) nginx config: %s", err.Error()))
 		}
-	} else {
-		cfg = createTcpUdpConfig(proxy, servers)
+		return cfg, nil
+	}
+
+	cfg, err := createTcpUdpConfig(proxy, servers)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error composing tcp/udp nginx config: %s", err.Error()))
+	}
+	return cfg, nil
+}
+
+// listenSocket returns socket for nginx listen directive of proxy on port,
+// interface is resolved on every rendering since its address may change
+func listenSocket(proxy *db.Proxy, port int) (string, error) {
+	if proxy.Bind == "" {
+		return strconv.Itoa(port), nil
+	}
+
+	address, err := net.BindAddress(proxy.Bind)
+	if err != nil {
+		return "", err
+	}
+
+	return gonet.JoinHostPort(address, strconv.Itoa(port)), nil
+}
+
+// configPath returns path of nginx config of proxy
+func configPath(proxy *db.Proxy) string {
+	return path.Join(nginxInc, proxy.Protocol, proxy.Domain+"-"+strconv.Itoa(proxy.Port)+".conf")
+}
+
+func createConfig(proxy *db.Proxy, servers []db.ProxiedServer) error {
+	cfg, err := renderConfig(proxy, servers)
+	if err != nil {
+		return err
 	}
 
 	if proxy.IsLE() && proxy.Redirect80Port {
@@ -701,18 +844,27 @@ func createConfig(proxy *db.Proxy, servers []db.ProxiedServer) error {
 		}
 	}
 
-	err = ioutil.WriteFile(path.Join(nginxInc, proxy.Protocol,
-		proxy.Domain+"-"+strconv.Itoa(proxy.Port)+".conf"), []byte(cfg), 0744)
+	err = ioutil.WriteFile(configPath(proxy), []byte(cfg), 0744)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Error saving nginx config: %s", err.Error()))
 	}
 	return nil
 }
 
-func createTcpUdpConfig(proxy *db.Proxy, servers []db.ProxiedServer) string {
-	//place-holders: {protocol}, {port}, {load-balancing}, {servers},
+func createTcpUdpConfig(proxy *db.Proxy, servers []db.ProxiedServer) (string, error) {
+	//place-holders: {protocol}, {port}, {listen}, {load-balancing}, {servers},
+	listen, err := listenSocket(proxy, proxy.Port)
+	if err != nil {
+		return "", err
+	}
+
 	effectiveConfig := strings.Replace(streamConfig, "{protocol}", proxy.Protocol, -1)
 	effectiveConfig = strings.Replace(effectiveConfig, "{port}", strconv.Itoa(proxy.Port), -1)
+	effectiveConfig = strings.Replace(effectiveConfig, "{listen}", listen, -1)
+
+	statsFormat, accessLog := statsDirectives(proxy)
+	effectiveConfig = strings.Replace(effectiveConfig, "{stats-format}", statsFormat, -1)
+	effectiveConfig = strings.Replace(effectiveConfig, "{access-log}", accessLog, -1)
 
 	//load balancing
 	loadBalancing := ""
@@ -741,13 +893,35 @@ func createTcpUdpConfig(proxy *db.Proxy, servers []db.ProxiedServer) string {
 		effectiveConfig = strings.Replace(effectiveConfig, "{udp}", "", -1)
 	}
 
-	return effectiveConfig
+	return effectiveConfig, nil
 }
 
 func createHttpHttpsConfig(proxy *db.Proxy, servers []db.ProxiedServer) (string, error) {
-	//place-holders: {protocol}, {port}, {domain}, {load-balancing}, {servers}, {ssl}, {ssl-backend}, {http2}
+	//place-holders: {protocol}, {port}, {listen}, {domain}, {load-balancing}, {servers}, {ssl}, {ssl-backend}, {http2}
 	effectiveConfig := webConfig
 
+	//upstream is not needed while domain is in maintenance and may have no servers then
+	upstream := ""
+	if len(servers) > 0 {
+		upstream = webUpstream
+	}
+	effectiveConfig = strings.Replace(effectiveConfig, "{upstream}", upstream, -1)
+
+	location := webProxyLocation
+	if proxy.Maintenance {
+		location = strings.Replace(maintenanceLocation, "{maintenance-dir}", maintenanceDir(proxy.Domain), -1)
+	}
+	effectiveConfig = strings.Replace(effectiveConfig, "{location}", location, -1)
+
+	geoMapConfig, geoCheckConfig := geoDirectives(proxy)
+	effectiveConfig = strings.Replace(effectiveConfig, "{geo-map}", geoMapConfig, -1)
+	effectiveConfig = strings.Replace(effectiveConfig, "{geo-check}", geoCheckConfig, -1)
+
+	listen, err := listenSocket(proxy, proxy.Port)
+	if err != nil {
+		return "", err
+	}
+
 	//for http-80 proxy check if there is https proxy for the same domain with LE cert
 	//if such poxy exists we need to add "well-known" section for LE cert renewal support
 	if proxy.Protocol == HTTP && proxy.Port == 80 {
@@ -767,10 +941,19 @@ func createHttpHttpsConfig(proxy *db.Proxy, servers []db.ProxiedServer) (string,
 
 	effectiveConfig = strings.Replace(effectiveConfig, "{protocol}", proxy.Protocol, -1)
 	effectiveConfig = strings.Replace(effectiveConfig, "{port}", strconv.Itoa(proxy.Port), -1)
+	effectiveConfig = strings.Replace(effectiveConfig, "{listen}", listen, -1)
+
+	statsFormat, accessLog := statsDirectives(proxy)
+	effectiveConfig = strings.Replace(effectiveConfig, "{stats-format}", statsFormat, -1)
+	effectiveConfig = strings.Replace(effectiveConfig, "{access-log}", accessLog, -1)
 	effectiveConfig = strings.Replace(effectiveConfig, "{domain}", proxy.Domain, -1)
 
 	if proxy.Redirect80Port {
-		redirect := redirect80Section
+		listen80, err := listenSocket(proxy, 80)
+		if err != nil {
+			return "", err
+		}
+		redirect := strings.Replace(redirect80Section, "{listen-80}", listen80, -1)
 		if proxy.IsLE() {
 			redirect = strings.Replace(redirect, "{well-known}", letsEncryptWellKnownSection, -1)
 		} else {
@@ -816,6 +999,7 @@ func createHttpHttpsConfig(proxy *db.Proxy, servers []db.ProxiedServer) (string,
 			certDir := proxy.Domain + "-" + strconv.Itoa(proxy.Port)
 			sslConfig = strings.Replace(selfSignedSslDirectives, "{domain}", certDir, -1)
 		}
+		sslConfig += clientAuthConfig(proxy)
 	}
 	effectiveConfig = strings.Replace(effectiveConfig, "{ssl}", sslConfig, -1)
 
@@ -920,6 +1104,16 @@ func deleteProxy(proxy *db.Proxy) error {
 		return errors.New(fmt.Sprintf("Error removing nginx config: %s", err.Error()))
 	}
 
+	err = removeReflection(proxy)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error removing NAT reflection: %s", err.Error()))
+	}
+
+	err = removeStatsLog(proxy)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error removing access log: %s", err.Error()))
+	}
+
 	if proxy.Protocol == HTTPS {
 		//remove certificates
 		err = removeCert(proxy)
@@ -929,20 +1123,8 @@ func deleteProxy(proxy *db.Proxy) error {
 
 	}
 
-	proxiedServers, err := db.FindProxiedServers(proxy.Tag, "")
-	if err != nil {
-		return errors.New(fmt.Sprintf("Error looking up server in db: %s", err.Error()))
-	}
-	//remove proxied servers from db
-	for _, server := range proxiedServers {
-		err = db.RemoveProxiedServer(&server)
-		if err != nil {
-			return errors.New(fmt.Sprintf("Error removing server from db: %s", err.Error()))
-		}
-	}
-
-	//remove proxy from db
-	err = db.RemoveProxy(proxy)
+	//remove proxy and its proxied servers from db
+	err = db.RemoveProxyWithServers(proxy)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Error removing proxy from db: %s", err.Error()))
 	}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bufio"
+	gonet "net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/log"
+)
+
+// NAT reflection lets containers and host processes reach mapped port via external address, e.g. public IP
+// of router forwarding traffic to host, which is not reachable from inside in the most setups.
+// Traffic to external address and port is redirected to the address nginx listens on for the mapping.
+// Rules are marked with comment holding proxy tag
+const reflectComment = "subutai-reflect-"
+
+// applyReflection installs reflection rules of proxy if it has servers and removes them otherwise
+func applyReflection(proxy *db.Proxy, active bool) error {
+	if err := removeReflection(proxy); err != nil {
+		return err
+	}
+
+	if proxy.Reflect == "" || !active {
+		return nil
+	}
+
+	address := net.GetIp()
+	if proxy.Bind != "" {
+		var err error
+		if address, err = net.BindAddress(proxy.Bind); err != nil {
+			return err
+		}
+	}
+	if address == "" {
+		return errors.New("Host address not found")
+	}
+
+	transport := TCP
+	if proxy.Protocol == UDP {
+		transport = UDP
+	}
+
+	ports := []int{proxy.Port}
+	if proxy.Redirect80Port {
+		ports = append(ports, 80)
+	}
+
+	for _, port := range ports {
+		//PREROUTING catches traffic of containers, OUTPUT - traffic of host processes
+		for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+			err := exec.Exec("iptables", "-t", "nat", "-A", chain, "-d", proxy.Reflect, "-p", transport,
+				"--dport", strconv.Itoa(port), "-m", "comment", "--comment", reflectComment+proxy.Tag,
+				"-j", "DNAT", "--to-destination", gonet.JoinHostPort(address, strconv.Itoa(port)))
+			if err != nil {
+				return errors.Wrap(err, "Adding NAT reflection rule")
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeReflection removes reflection rules of proxy
+func removeReflection(proxy *db.Proxy) error {
+	if proxy.Reflect == "" {
+		return nil
+	}
+
+	out, err := exec.ExecuteNoLog("iptables-save", "-t", "nat")
+	if err != nil {
+		return errors.Wrap(err, "Reading iptables rules")
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 || args[0] != "-A" || reflectTag(args) != proxy.Tag {
+			continue
+		}
+		args[0] = "-D"
+		if err := exec.Exec("iptables", append([]string{"-t", "nat"}, args...)...); err != nil {
+			return errors.Wrap(err, "Removing NAT reflection rule")
+		}
+	}
+
+	return nil
+}
+
+// reflectTag returns tag of proxy which reflection rule belongs to, empty for other rules
+func reflectTag(rule []string) string {
+	for i := 0; i < len(rule)-1; i++ {
+		if rule[i] == "--comment" && strings.HasPrefix(rule[i+1], reflectComment) {
+			return strings.TrimPrefix(rule[i+1], reflectComment)
+		}
+	}
+	return ""
+}
+
+// RestoreReflection installs reflection rules of all proxies having servers, e.g. after host reboot
+func RestoreReflection() {
+	proxies, err := db.FindProxies("", "", 0)
+	if log.Check(log.WarnLevel, "Reading proxies from db", err) {
+		return
+	}
+
+	for i := range proxies {
+		proxy := &proxies[i]
+		if proxy.Reflect == "" {
+			continue
+		}
+
+		servers, err := db.FindProxiedServers(proxy.Tag, "")
+		if log.Check(log.WarnLevel, "Reading servers of proxy "+proxy.Tag, err) {
+			continue
+		}
+
+		log.Check(log.WarnLevel, "Restoring NAT reflection of proxy "+proxy.Tag,
+			applyReflection(proxy, hasConfig(proxy, servers)))
+	}
+}
@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/exec"
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+// Traffic of proxies is taken from access logs nginx writes for every proxy to statsDir: each line holds bytes
+// received and sent for single request (http, https) or session (tcp, udp). Logs are consumed by collectStats,
+// which adds their content to counters kept in db along with offset of log it has read to. Logs are never
+// truncated, since nginx may write to them meanwhile, large logs are rotated instead
+var statsDir = path.Join(config.Agent.DataPrefix, "nginx/stats")
+
+// log larger than this is rotated: renamed and reopened by nginx on reload
+const maxStatsLog = 16 << 20
+
+// rotated log is read till nginx stopped writing to it this long ago, workers of nginx finishing requests
+// after reload keep writing to it
+const rotatedLogIdle = time.Minute
+
+//place-holders: {name}
+const webStatsFormat = `log_format subutai_{name} '$request_length $bytes_sent';`
+const streamStatsFormat = `log_format subutai_{name} '$bytes_received $bytes_sent';`
+const geoStatsFormat = `log_format subutai_{name} '$request_length $bytes_sent $geoip_country_code';`
+
+//place-holders: {name}, {log}
+const statsAccessLog = `access_log {log} subutai_{name};`
+
+// Stats describes traffic of proxy
+type Stats struct {
+	Tag      string    `json:"tag"`
+	Protocol string    `json:"protocol"`
+	Port     int       `json:"port"`
+	Domain   string    `json:"domain"`
+	Requests int64     `json:"requests"`
+	BytesIn  int64     `json:"bytesIn"`
+	BytesOut int64     `json:"bytesOut"`
+	Since    time.Time `json:"since"`
+	//established connections to proxy port, shared by all http(s) proxies on the port, 0 for udp
+	Connections int `json:"connections"`
+	//requests by country of client, if logging of countries is turned on
+	Countries map[string]int64 `json:"countries,omitempty"`
+}
+
+// statsName returns name identifying access log of proxy, unique as its config file
+func statsName(proxy *db.Proxy) string {
+	return proxy.Protocol + "-" + strings.TrimSuffix(filepath.Base(configPath(proxy)), ".conf")
+}
+
+func statsLogPath(proxy *db.Proxy) string {
+	return path.Join(statsDir, statsName(proxy)+".log")
+}
+
+func rotatedLogPath(proxy *db.Proxy) string {
+	return statsLogPath(proxy) + ".1"
+}
+
+// statsDirectives returns log format and access log directives of proxy
+func statsDirectives(proxy *db.Proxy) (string, string) {
+	format := webStatsFormat
+	if proxy.Protocol == TCP || proxy.Protocol == UDP {
+		format = streamStatsFormat
+	} else if proxy.GeoLog {
+		format = geoStatsFormat
+	}
+	format = strings.Replace(format, "{name}", statsName(proxy), -1)
+
+	accessLog := strings.Replace(statsAccessLog, "{name}", statsName(proxy), -1)
+	accessLog = strings.Replace(accessLog, "{log}", statsLogPath(proxy), -1)
+
+	return format, accessLog
+}
+
+// collectStats adds traffic logged by nginx since previous collection to counters of proxies
+func collectStats() error {
+	var err error
+	var lock lockfile.Lockfile
+	for lock, err = common.LockFile("proxy", "stats"); err != nil; lock, err = common.LockFile("proxy", "stats") {
+		time.Sleep(time.Second * 1)
+	}
+	defer lock.Unlock()
+
+	proxies, err := db.FindProxies("", "", 0)
+	if err != nil {
+		return err
+	}
+
+	rotated := false
+	for i := range proxies {
+		proxy := &proxies[i]
+
+		stats, err := db.FindProxyStats(proxy.Tag)
+		if err != nil {
+			return err
+		}
+		if stats == nil {
+			stats = &db.ProxyStats{ProxyTag: proxy.Tag, Since: time.Now()}
+		}
+		prev := *stats
+
+		if err = consumeRotatedLog(proxy, stats); err != nil {
+			return errors.Wrap(err, "Reading rotated access log of proxy "+proxy.Tag)
+		}
+		delta, offset, err := consumeLog(statsLogPath(proxy), stats.Offset)
+		if err != nil {
+			return errors.Wrap(err, "Reading access log of proxy "+proxy.Tag)
+		}
+		addStats(stats, delta)
+		stats.Offset = offset
+
+		//log is rotated once its rotated copy is gone, lines nginx writes after rename are read from copy
+		if stats.Offset > maxStatsLog && !fs.FileExists(rotatedLogPath(proxy)) {
+			if err = os.Rename(statsLogPath(proxy), rotatedLogPath(proxy)); err != nil {
+				return errors.Wrap(err, "Rotating access log of proxy "+proxy.Tag)
+			}
+			stats.RotatedOffset, stats.Offset = stats.Offset, 0
+			rotated = true
+		}
+
+		if prev.Id != 0 && stats.Requests == prev.Requests && stats.Offset == prev.Offset &&
+			stats.RotatedOffset == prev.RotatedOffset {
+			continue
+		}
+		if err = db.SaveProxyStats(stats); err != nil {
+			return err
+		}
+	}
+
+	//nginx reopens its logs on reload
+	if rotated {
+		return reloadNginx()
+	}
+
+	return nil
+}
+
+// consumeRotatedLog adds traffic in rotated copy of access log to stats, copy is removed once nginx stopped
+// writing to it
+func consumeRotatedLog(proxy *db.Proxy, stats *db.ProxyStats) error {
+	file := rotatedLogPath(proxy)
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		stats.RotatedOffset = 0
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	delta, offset, err := consumeLog(file, stats.RotatedOffset)
+	if err != nil {
+		return err
+	}
+	addStats(stats, delta)
+	stats.RotatedOffset = offset
+
+	if time.Since(info.ModTime()) > rotatedLogIdle {
+		if err = os.Remove(file); err != nil {
+			return err
+		}
+		stats.RotatedOffset = 0
+	}
+
+	return nil
+}
+
+func addStats(stats *db.ProxyStats, delta Stats) {
+	stats.Requests += delta.Requests
+	stats.BytesIn += delta.BytesIn
+	stats.BytesOut += delta.BytesOut
+	for country, requests := range delta.Countries {
+		if stats.Countries == nil {
+			stats.Countries = make(map[string]int64)
+		}
+		stats.Countries[country] += requests
+	}
+}
+
+// consumeLog sums traffic in access log logged after offset and returns offset of log it has read to. Only
+// complete lines are counted, line being written is counted next time. Log is read from the beginning if it
+// is shorter than offset, i.e. it was replaced
+func consumeLog(file string, offset int64) (Stats, int64, error) {
+	var stats Stats
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return stats, 0, nil
+	} else if err != nil {
+		return stats, offset, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return stats, offset, err
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return stats, offset, err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, offset, err
+		}
+		offset += int64(len(line))
+
+		//country is logged as third field if logging of countries is turned on
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			continue
+		}
+		in, err1 := strconv.ParseInt(fields[0], 10, 64)
+		out, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		stats.Requests++
+		stats.BytesIn += in
+		stats.BytesOut += out
+		if len(fields) == 3 && fields[2] != "-" {
+			if stats.Countries == nil {
+				stats.Countries = make(map[string]int64)
+			}
+			stats.Countries[fields[2]]++
+		}
+	}
+
+	return stats, offset, nil
+}
+
+// GetStats collects traffic logged by nginx and returns traffic of proxies accumulated in db,
+// all proxies are returned if tag is empty
+func GetStats(tag string) ([]Stats, error) {
+	if err := collectStats(); err != nil {
+		return nil, err
+	}
+
+	proxies, err := db.FindProxies("", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Stats
+	for _, proxy := range proxies {
+		if tag != "" && proxy.Tag != tag {
+			continue
+		}
+
+		item := Stats{Tag: proxy.Tag, Protocol: proxy.Protocol, Port: proxy.Port, Domain: proxy.Domain}
+		stats, err := db.FindProxyStats(proxy.Tag)
+		if err != nil {
+			return nil, err
+		}
+		if stats != nil {
+			item.Requests, item.BytesIn, item.BytesOut, item.Since = stats.Requests, stats.BytesIn, stats.BytesOut, stats.Since
+			item.Countries = stats.Countries
+		}
+		if proxy.Protocol != UDP {
+			item.Connections = activeConnections(proxy.Port)
+		}
+
+		result = append(result, item)
+	}
+
+	if tag != "" && len(result) == 0 {
+		return nil, errors.Errorf("Proxy not found by tag %s", tag)
+	}
+
+	return result, nil
+}
+
+// activeConnections counts established tcp connections to local port
+func activeConnections(port int) int {
+	out, err := exec.ExecuteNoLog("ss", "-H", "-t", "-n", "state", "established", "sport = :"+strconv.Itoa(port))
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// removeStatsLog removes access log of proxy along with its rotated copy
+func removeStatsLog(proxy *db.Proxy) error {
+	for _, file := range []string{statsLogPath(proxy), rotatedLogPath(proxy)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
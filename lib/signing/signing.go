@@ -0,0 +1,227 @@
+// Package signing signs templates exported by host with its ed25519 keys and verifies signatures of imported
+// templates with own keys and keys of other hosts trusted with "subutai keys trust". Keys are kept in agent db
+// with their expiry, expired keys neither sign nor verify. Private keys are sealed with AES-GCM key kept in
+// separate root only file, so copies of db do not disclose them.
+// Keys are not used to authenticate cluster peers, peers present TLS certificates issued by cluster CA instead
+package signing
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+)
+
+// KeyType prefixes exported public keys, e.g. "ed25519 AAAA... host1"
+const KeyType = "ed25519"
+
+// sealingKeyFile holds key private keys are sealed with, it is kept next to agent config rather than in db
+var sealingKeyFile = path.Join(path.Dir(config.ConfPath), "signing.key")
+
+// Generate creates key pair used to sign templates, ttl of 0 means key does not expire
+func Generate(name string, ttl time.Duration) (*db.SigningKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(private)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &db.SigningKey{Name: name, PublicKey: public, PrivateKey: sealed, Created: time.Now()}
+	if ttl > 0 {
+		key.Expires = key.Created.Add(ttl)
+	}
+	return key, db.SaveSigningKey(key)
+}
+
+// seal encrypts private key, nonce is prepended to sealed key
+func seal(private ed25519.PrivateKey) ([]byte, error) {
+	aead, err := sealingCipher(true)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, private, nil), nil
+}
+
+// unseal decrypts private key sealed by seal, keys stored before they were sealed are returned as is
+func unseal(sealed []byte) (ed25519.PrivateKey, error) {
+	if len(sealed) == ed25519.PrivateKeySize {
+		return sealed, nil
+	}
+	aead, err := sealingCipher(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("Invalid sealed private key")
+	}
+	private, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unsealing private key")
+	}
+	return private, nil
+}
+
+// sealingCipher returns cipher private keys are sealed with, key is generated on first use if create is set
+func sealingCipher(create bool) (cipher.AEAD, error) {
+	secret, err := ioutil.ReadFile(sealingKeyFile)
+	if os.IsNotExist(err) && create {
+		secret = make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(path.Dir(sealingKeyFile), 0755); err == nil {
+			err = ioutil.WriteFile(sealingKeyFile, secret, 0600)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Reading key sealing private keys")
+	}
+	if len(secret) != 32 {
+		return nil, errors.Errorf("Invalid key in %s", sealingKeyFile)
+	}
+
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Trust saves public key of another host, templates signed with it are accepted
+func Trust(name string, public []byte, ttl time.Duration) (*db.SigningKey, error) {
+	if len(public) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("Invalid %s public key of %d bytes", KeyType, len(public))
+	}
+
+	key := &db.SigningKey{Name: name, PublicKey: public, Created: time.Now()}
+	if ttl > 0 {
+		key.Expires = key.Created.Add(ttl)
+	}
+	return key, db.SaveSigningKey(key)
+}
+
+// Export formats public key of key as single line accepted by ParsePublicKey
+func Export(key db.SigningKey) string {
+	return KeyType + " " + base64.StdEncoding.EncodeToString(key.PublicKey) + " " + key.Name
+}
+
+// ParsePublicKey parses public key exported by Export, returns key and name it was exported with
+func ParsePublicKey(line string) ([]byte, string, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != KeyType {
+		return nil, "", errors.Errorf("Invalid public key, \"%s <base64 key> [name]\" expected", KeyType)
+	}
+	public, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return nil, "", errors.New("Invalid public key encoding")
+	}
+
+	name := ""
+	if len(fields) > 2 {
+		name = fields[2]
+	}
+	return public, name, nil
+}
+
+// Fingerprint returns short form identifying public key, e.g. SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU
+func Fingerprint(key db.SigningKey) string {
+	sum := sha256.Sum256(key.PublicKey)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// IsOwn checks if key is key pair of host rather than trusted public key
+func IsOwn(key db.SigningKey) bool {
+	return len(key.PrivateKey) > 0
+}
+
+// IsExpired checks if key is past its expiry
+func IsExpired(key db.SigningKey) bool {
+	return !key.Expires.IsZero() && time.Now().After(key.Expires)
+}
+
+// Sign signs data with the most recently created unexpired key pair of host, returns name of key and signature.
+// Empty name is returned if host has no usable key
+func Sign(data []byte) (string, []byte, error) {
+	keys, err := db.GetAllSigningKeys()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var signer *db.SigningKey
+	for i, key := range keys {
+		if IsOwn(key) && !IsExpired(key) && (signer == nil || key.Created.After(signer.Created)) {
+			signer = &keys[i]
+		}
+	}
+	if signer == nil {
+		return "", nil, nil
+	}
+
+	private, err := unseal(signer.PrivateKey)
+	if err != nil {
+		return "", nil, err
+	}
+	//keys stored before private keys were sealed are sealed on first use
+	if len(signer.PrivateKey) == ed25519.PrivateKeySize {
+		if signer.PrivateKey, err = seal(private); err != nil {
+			return "", nil, err
+		}
+		if err = db.SaveSigningKey(signer); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return signer.Name, ed25519.Sign(private, data), nil
+}
+
+// Verify checks signature of data made with key of name, key must be own or trusted and not expired
+func Verify(name string, data, signature []byte) error {
+	key, err := db.FindSigningKey(name)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.Errorf("Signing key %s is not trusted", name)
+	}
+	if IsExpired(*key) {
+		return errors.Errorf("Signing key %s expired on %s", name, key.Expires.Format(time.RFC3339))
+	}
+	if !ed25519.Verify(key.PublicKey, data, signature) {
+		return errors.Errorf("Invalid signature made with key %s", name)
+	}
+
+	return nil
+}
+
+// Required tells if signatures are enforced, i.e. keys of other hosts are trusted
+func Required() (bool, error) {
+	keys, err := db.GetAllSigningKeys()
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if !IsOwn(key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
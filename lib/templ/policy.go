@@ -0,0 +1,105 @@
+package templ
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+)
+
+// Policy restricts templates host may import by owner and name, denied owners and names take precedence
+// over allowed ones, empty allow list allows any
+type Policy struct {
+	AllowOwners []string
+	DenyOwners  []string
+	//name patterns as matched by path.Match, e.g. debian-*
+	AllowNames []string
+	DenyNames  []string
+	//only templates of verified owners or signed with trusted keys are allowed
+	VerifiedOnly bool
+}
+
+// HostPolicy returns import policy set in [import] section of config
+func HostPolicy() Policy {
+	return Policy{
+		AllowOwners:  splitList(config.Import.AllowOwners),
+		DenyOwners:   splitList(config.Import.DenyOwners),
+		AllowNames:   splitList(config.Import.AllowNames),
+		DenyNames:    splitList(config.Import.DenyNames),
+		VerifiedOnly: config.Import.VerifiedOnly,
+	}
+}
+
+// Check returns error describing violated rule if policy forbids import of template, verified tells if owner
+// of template is verified by CDN or its archive is signed with trusted key
+func (p Policy) Check(ref Ref, verified bool) error {
+	owner, name := strings.ToLower(ref.Owner), strings.ToLower(ref.Name)
+	violation := func(reason string, args ...interface{}) error {
+		return errors.Errorf("Import of template %s is forbidden by host policy: "+reason,
+			append([]interface{}{ref.CdnString()}, args...)...)
+	}
+
+	if contains(p.DenyOwners, owner) {
+		return violation("owner %s is denied", owner)
+	}
+	if len(p.AllowOwners) > 0 && !contains(p.AllowOwners, owner) {
+		return violation("owner %s is not allowed", owner)
+	}
+
+	denied, err := matches(p.DenyNames, name)
+	if err != nil {
+		return err
+	}
+	if denied {
+		return violation("name matches denied pattern")
+	}
+	if len(p.AllowNames) > 0 {
+		allowed, err := matches(p.AllowNames, name)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return violation("name matches no allowed pattern")
+		}
+	}
+
+	if p.VerifiedOnly && !verified {
+		return violation("only templates of verified owners or signed with trusted keys are allowed")
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return false, errors.Errorf("Invalid template name pattern %s in import policy", pattern)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// splitList splits comma separated list of config, owners and names are lower case as in references
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
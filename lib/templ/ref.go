@@ -0,0 +1,170 @@
+// Package templ handles references of Subutai templates.
+// Full reference identifies template as name, owner and version. Users and CDN refer to templates
+// as name@owner:version, while local template directories and container configs use name:owner:version.
+// Owner and version may be omitted in user input, then verified owner and latest version are implied
+package templ
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	namePartRx = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	versionRx  = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+)
+
+// Ref is template reference, Owner and Version are empty in partial references
+type Ref struct {
+	Name    string
+	Owner   string
+	Version string
+}
+
+// ParseRef parses and validates template reference in one of forms name, name@owner, name:owner,
+// name@owner:version or name:owner:version. Case of name and owner is kept since references are case sensitive
+func ParseRef(ref string) (Ref, error) {
+	var r Ref
+
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return r, errors.New("Empty template reference")
+	}
+
+	parts := strings.SplitN(ref, "@", 2)
+	if len(parts) == 2 {
+		//owner and version follow @
+		r.Name = parts[0]
+		rest := strings.Split(parts[1], ":")
+		if len(rest) > 2 {
+			return r, errors.Errorf("Invalid template reference %s", ref)
+		}
+		r.Owner = rest[0]
+		if len(rest) == 2 {
+			r.Version = rest[1]
+		}
+		if r.Owner == "" {
+			return r, errors.Errorf("Invalid template reference %s: owner is empty", ref)
+		}
+	} else {
+		rest := strings.Split(ref, ":")
+		if len(rest) > 3 {
+			return r, errors.Errorf("Invalid template reference %s", ref)
+		}
+		r.Name = rest[0]
+		if len(rest) > 1 {
+			r.Owner = rest[1]
+			if r.Owner == "" {
+				return r, errors.Errorf("Invalid template reference %s: owner is empty", ref)
+			}
+		}
+		if len(rest) > 2 {
+			r.Version = rest[2]
+		}
+	}
+
+	if err := r.validate(); err != nil {
+		return r, errors.Errorf("Invalid template reference %s: %s", ref, err.Error())
+	}
+
+	return r, nil
+}
+
+// ParseFullRef parses reference which must include owner and version
+func ParseFullRef(ref string) (Ref, error) {
+	r, err := ParseRef(ref)
+	if err != nil {
+		return r, err
+	}
+
+	if !r.IsFull() {
+		return r, errors.Errorf("Invalid template reference %s: owner and version are required", ref)
+	}
+
+	return r, nil
+}
+
+// NewRef makes full reference from its parts, e.g. read from container config
+func NewRef(name, owner, version string) (Ref, error) {
+	r := Ref{Name: strings.TrimSpace(name), Owner: strings.TrimSpace(owner), Version: strings.TrimSpace(version)}
+
+	if !r.IsFull() {
+		return r, errors.Errorf("Incomplete template reference %s", r.CdnString())
+	}
+	if err := r.validate(); err != nil {
+		return r, errors.Errorf("Invalid template reference %s: %s", r.CdnString(), err.Error())
+	}
+
+	return r, nil
+}
+
+func (r Ref) validate() error {
+	if !namePartRx.MatchString(r.Name) {
+		return errors.Errorf("invalid name \"%s\"", r.Name)
+	}
+	if r.Owner != "" && !namePartRx.MatchString(r.Owner) {
+		return errors.Errorf("invalid owner \"%s\"", r.Owner)
+	}
+	if r.Version != "" && !versionRx.MatchString(r.Version) {
+		return errors.Errorf("invalid version \"%s\", expected x.y.z", r.Version)
+	}
+	return nil
+}
+
+// IsFull tells if reference includes owner and version
+func (r Ref) IsFull() bool {
+	return r.Owner != "" && r.Version != ""
+}
+
+// String returns reference in form name:owner:version used for local templates, omitting missing parts
+func (r Ref) String() string {
+	parts := []string{r.Name}
+	if r.Owner != "" {
+		parts = append(parts, r.Owner)
+		if r.Version != "" {
+			parts = append(parts, r.Version)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// CdnString returns reference in form name@owner:version, omitting missing parts
+func (r Ref) CdnString() string {
+	s := r.Name
+	if r.Owner != "" {
+		s += "@" + r.Owner
+		if r.Version != "" {
+			s += ":" + r.Version
+		}
+	}
+	return s
+}
+
+// CompareVersions compares versions in form x.y.z numerically, returns -1, 0 or 1 if a is lower than, equal to
+// or greater than b. Invalid versions are lower than valid ones
+func CompareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(version string) [3]int {
+	parts := [3]int{-1, -1, -1}
+	if !versionRx.MatchString(version) {
+		return parts
+	}
+	for i, p := range strings.Split(version, ".") {
+		parts[i], _ = strconv.Atoi(p)
+	}
+	return parts
+}
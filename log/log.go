@@ -2,6 +2,7 @@
 package log
 
 import (
+	"fmt"
 	"log/syslog"
 	"os"
 	lSyslog "github.com/sirupsen/logrus/hooks/syslog"
@@ -23,6 +24,14 @@ var (
 	PanicLevel = logrus.PanicLevel
 )
 
+var (
+	//operation id entries are tagged with
+	operation string
+	//message of error process exits with
+	failure      string
+	exitHandlers []func(code int)
+)
+
 func init() {
 
 	//add syslog hook
@@ -59,10 +68,45 @@ func Check(level logrus.Level, msg string, err error) bool {
 		}
 		return true
 	}
-	logrus.Debug(msg)
+	logger().Debug(msg)
 	return false
 }
 
+// SetOperation tags all following entries with operation id, so entries of a single invocation can be
+// correlated across agent processes and with the caller
+func SetOperation(id string) {
+	operation = id
+}
+
+// Operation returns id of operation entries are tagged with
+func Operation() string {
+	return operation
+}
+
+// Failure returns message of error process is stopped with, empty if there is none
+func Failure() string {
+	return failure
+}
+
+// AtExit registers handler called with exit code when process is stopped by Error or Fatal
+func AtExit(handler func(code int)) {
+	exitHandlers = append(exitHandlers, handler)
+}
+
+func exit(code int, msg ...interface{}) {
+	failure = fmt.Sprint(msg...)
+	for _, handler := range exitHandlers {
+		handler(code)
+	}
+}
+
+func logger() logrus.FieldLogger {
+	if operation == "" {
+		return logrus.StandardLogger()
+	}
+	return logrus.WithField("op", operation)
+}
+
 // Level sets output level
 func Level(level logrus.Level) {
 	logrus.SetLevel(level)
@@ -71,38 +115,40 @@ func Level(level logrus.Level) {
 // Panic stops process after showing panic message. Highest error level
 func Panic(msg ...interface{}) {
 	logrus.SetOutput(os.Stderr)
-	logrus.Panic(msg...)
+	logger().Panic(msg...)
 }
 
 // Fatal stops process after showing fatal message.
 func Fatal(msg ...interface{}) {
 	logrus.SetOutput(os.Stderr)
-	logrus.Fatal(msg...)
+	exit(1, msg...)
+	logger().Fatal(msg...)
 }
 
 // Error stops process after showing error message.
 func Error(msg ...interface{}) {
 	logrus.SetOutput(os.Stderr)
-	logrus.Error(msg...)
+	logger().Error(msg...)
+	exit(1, msg...)
 	os.Exit(1)
 }
 
 func ErrorNoExit(msg ... interface{}) {
 	logrus.SetOutput(os.Stderr)
-	logrus.Error(msg...)
+	logger().Error(msg...)
 }
 
 // Warn keeps process working after showing warning message.
 func Warn(msg ...interface{}) {
-	logrus.Warn(msg...)
+	logger().Warn(msg...)
 }
 
 // Info keeps process working after showing information message.
 func Info(msg ...interface{}) {
-	logrus.Info(msg...)
+	logger().Info(msg...)
 }
 
 // Debug logs debug information
 func Debug(msg ...interface{}) {
-	logrus.Debug(msg...)
+	logger().Debug(msg...)
 }
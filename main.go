@@ -6,6 +6,7 @@ import (
 	"github.com/subutai-io/agent/agent"
 	"github.com/subutai-io/agent/cli"
 	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
 	"github.com/subutai-io/agent/log"
 	prxy "github.com/subutai-io/agent/lib/proxy"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -20,8 +21,12 @@ import (
 var version = "unknown"
 
 var (
-	app       = kingpin.New("subutai", "Subutai Agent")
-	debugFlag = app.Flag("debug", "Set log level to DEBUG").Short('d').Bool()
+	app          = kingpin.New("subutai", "Subutai Agent")
+	debugFlag    = app.Flag("debug", "Set log level to DEBUG").Short('d').Bool()
+	hostFlag     = app.Flag("host", "Run command on cluster peer, by name or address").String()
+	opFlag       = app.Flag("operation", "Operation id logs and audit of command are tagged with, generated if not set").String()
+	progressFlag = app.Flag("progress", "How progress of template import is reported: auto (bar on terminal, plain otherwise), bar, plain, json or none").
+			Default("auto").Enum("auto", "bar", "plain", "json", "none")
 
 	//daemon command
 	daemonCmd = app.Command("daemon", "Run subutai agent daemon")
@@ -34,6 +39,7 @@ var (
 	subutai list info
 	subutai list containers -n foo
 	subutai list all -p
+	subutai list containers --tenant acme
 	 */
 	listCmd               = app.Command("list", "List containers/templates").Alias("ls")
 	listContainers        = listCmd.Command("containers", "List containers").Alias("c")
@@ -42,6 +48,10 @@ var (
 	listContainersDetails = listCmd.Command("info", "List containers info").Alias("i")
 	listName              = listCmd.Flag("name", "container/template name").Short('n').String()
 	listParents           = listCmd.Flag("parents", "list parents").Short('p').Bool()
+	listUsage             = listCmd.Flag("usage", "show CPU%, RAM, disk usage and uptime of containers").Short('u').Bool()
+	listSortBy            = listCmd.Flag("sort-by", "sort containers by name, cpu, ram, disk or uptime").String()
+	listWatch             = listCmd.Flag("watch", "refresh container usage every 2 seconds").Short('w').Bool()
+	listTenant            = listCmd.Flag("tenant", "list containers of tenant only").String()
 
 	existsCmd     = app.Command("exists", "Check if container/template exists, exit code 0 - exists, 1 - not found")
 	existsCmdName = existsCmd.Arg("name", "name of container/template").Required().String()
@@ -55,22 +65,118 @@ var (
 	attachName    = attachCmd.Arg("name", "running container name").Required().String()
 	attachCommand = attachCmd.Arg("command", "ad-hoc command to execute").String()
 
+	//exec command
+	/*
+	subutai exec foo -- ls -la
+	subutai exec foo --user www-data --cwd /var/www -- php artisan migrate
+	subutai exec foo -i -- mysql shop < dump.sql
+	subutai exec --label app=web -- systemctl reload nginx
+	*/
+	execCmd         = app.Command("exec", "Execute command inside running container")
+	execName        = execCmd.Arg("name", "running container name").Required().String()
+	execCommand     = execCmd.Arg("command", "command with arguments, separate it with -- if it has flags").Strings()
+	execUser        = execCmd.Flag("user", "user name or uid inside container, root by default").Short('u').String()
+	execGroup       = execCmd.Flag("group", "group name or gid inside container, primary group of user by default").Short('g').String()
+	execCwd         = execCmd.Flag("cwd", "working directory, home of user by default").Short('w').String()
+	execInteractive = execCmd.Flag("interactive", "pass standard input to command").Short('i').Bool()
+	execTty         = execCmd.Flag("tty", "attach command to the current terminal").Short('t').Bool()
+	execEnv         = execCmd.Flag("env", "environment variable in form KEY=VALUE").Short('e').Strings()
+	execAll         = execCmd.Flag("all-running", "run command in all running containers except management, name is omitted then").Bool()
+	execLabels      = execCmd.Flag("label", "run command in running containers with label key=value, name is omitted then").Short('l').Strings()
+	execParallel    = execCmd.Flag("parallel", "number of containers running command at once").Default("10").Int()
+
+	//render command
+	/*
+	subutai render foo --template app.conf.tmpl --dest /etc/app/app.conf --vars db=10.10.10.2,port=5432 --restart app
+	*/
+	renderCmd       = app.Command("render", "Render template file with variables into container")
+	renderContainer = renderCmd.Arg("container", "container name").Required().String()
+	renderTemplate  = renderCmd.Flag("template", "path to Go template on host").Short('t').Required().String()
+	renderDest      = renderCmd.Flag("dest", "absolute path of file inside container").Short('d').Required().String()
+	renderVars      = renderCmd.Flag("vars", "variables in form key=value, separated with commas").Strings()
+	renderOwner     = renderCmd.Flag("owner", "owner of file inside container in form user[:group], root by default").Short('o').String()
+	renderMode      = renderCmd.Flag("mode", "file mode").Short('m').Default("0644").String()
+	renderRestart   = renderCmd.Flag("restart", "service to restart inside container after file is written").String()
+
+	//label command
+	/*
+	subutai label foo app=web tier=front
+	subutai label foo --remove tier
+	*/
+	labelCmd       = app.Command("label", "Set, remove or print labels of container")
+	labelContainer = labelCmd.Arg("container", "container name").Required().String()
+	labelLabels    = labelCmd.Arg("labels", "labels in form key=value").Strings()
+	labelRemove    = labelCmd.Flag("remove", "key of label to remove").Short('r').Strings()
+
+	//limits command
+	/*
+	subutai limits foo nofile=65536 net.core.somaxconn=1024 vm.max_map_count=262144
+	subutai limits foo --remove nofile
+	*/
+	limitsCmd       = app.Command("limits", "Set, remove or print prlimits and sysctls of container")
+	limitsContainer = limitsCmd.Arg("container", "container name").Required().String()
+	limitsLimits    = limitsCmd.Arg("limits", "prlimits, e.g. nofile=1024:65536, and sysctls in form key=value").Strings()
+	limitsRemove    = limitsCmd.Flag("remove", "prlimit or sysctl to remove").Short('r').Strings()
+
+	//scan command
+	/*
+	subutai scan foo [--path /home]
+	subutai scan foo --list
+	*/
+	scanCmd       = app.Command("scan", "Scan files of container with scanner configured on host")
+	scanContainer = scanCmd.Arg("container", "container name").Required().String()
+	scanPath      = scanCmd.Flag("path", "directory of container to scan, the whole container by default").Short('p').String()
+	scanList      = scanCmd.Flag("list", "print results of previous scans").Bool()
+
 	//clone command
 	/*
-	subutai clone master foo [-e {env-id} -n {net-settings} -s {secret}]
+	subutai clone master foo [-e {env-id} -n {net-settings} -s {secret} --snapshot {label} -f {provision.yaml}]
+	subutai clone master acme-foo --tenant acme
 	*/
 	cloneCmd       = app.Command("clone", "Create Subutai container")
 	cloneTemplate  = cloneCmd.Arg("template", "source template").Required().String()
 	cloneContainer = cloneCmd.Arg("container", "container name").Required().String()
 	cloneEnvId     = cloneCmd.Flag("environment", "id of container environment").Short('e').String()
-	cloneNetwork   = cloneCmd.Flag("network", "container network settings in form 'ip/mask vlan'").Short('n').String()
+	cloneNetwork   = cloneCmd.Flag("network", "container network settings in form 'ip/mask vlan [ipv6]'").Short('n').String()
 	cloneSecret    = cloneCmd.Flag("secret", "console secret").Short('s').String()
+	cloneSnapshot  = cloneCmd.Flag("snapshot", "label of template snapshot to clone from, now by default").String()
+	cloneProvision = cloneCmd.Flag("file", "yaml provisioning payload with env, files and scripts applied on first boot").Short('f').String()
+	cloneTenant    = cloneCmd.Flag("tenant", "tenant container belongs to, container name must start with tenant name and dash").String()
+
+	adoptCmd      = app.Command("adopt", "Convert LXC container created outside of Subutai into managed one, list such containers without name")
+	adoptName     = adoptCmd.Arg("name", "LXC container name").String()
+	adoptTemplate = adoptCmd.Flag("template", "template to record as parent of container, name:owner:version").String()
+	adoptEnvId    = adoptCmd.Flag("environment", "id of container environment").Short('e').String()
+	adoptNetwork  = adoptCmd.Flag("network", "container network settings in form 'ip/mask vlan [ipv6]'").Short('n').String()
+
+	//migrate command
+	/*
+	subutai migrate foo ssh://root@10.0.0.2 [--destroy] [--bwlimit 50M]
+	*/
+	migrateCmd       = app.Command("migrate", "Move container to another resource host over ssh")
+	migrateContainer = migrateCmd.Arg("container", "container name").Required().String()
+	migrateTarget    = migrateCmd.Arg("target-host", "target host, e.g. ssh://root@host or host").Required().String()
+	migrateDestroy   = migrateCmd.Flag("destroy", "destroy container on this host once it is migrated").Bool()
+	migrateBwLimit   = migrateCmd.Flag("bwlimit", "bandwidth limit per second, e.g. 50M").String()
+
+	//estimate command
+	/*
+	subutai estimate export foo
+	subutai estimate backup foo monday [tuesday]
+	*/
+	estimateCmd                = app.Command("estimate", "Estimate size of transfers before running them")
+	estimateExportCmd          = estimateCmd.Command("export", "Estimate size of template exported from container")
+	estimateExportCmdContainer = estimateExportCmd.Arg("container", "container name").Required().String()
+	estimateBackupCmd          = estimateCmd.Command("backup", "Estimate size of snapshots sent by snapshot send")
+	estimateBackupCmdContainer = estimateBackupCmd.Arg("container", "container name").Required().String()
+	estimateBackupCmdLabels    = estimateBackupCmd.Arg("label", "snapshot label, delta between 2 labels is estimated if both are given").Required().Strings()
 
 	restoreCmd       = app.Command("restore", "Restore container")
 	restoreContainer = restoreCmd.Arg("container", "container name").Required().String()
 	restoreEnvId     = restoreCmd.Flag("environment", "id of container environment").Short('e').String()
-	restoreNetwork   = restoreCmd.Flag("network", "container network settings in form 'ip/mask vlan'").Short('n').String()
+	restoreNetwork   = restoreCmd.Flag("network", "container network settings in form 'ip/mask vlan [ipv6]'").Short('n').String()
 	restoreSecret    = restoreCmd.Flag("secret", "console secret").Short('s').String()
+	restoreTenant    = restoreCmd.Flag("tenant", "tenant container belongs to, container name must start with tenant name and dash").String()
 
 	//cleanup command
 	/*
@@ -87,22 +193,57 @@ var (
 
 	//destroy command
 	/*
-	subutai destroy foo
+	subutai destroy foo [bar baz] [--parallel 5]
+	subutai destroy --env {env-id}
+	subutai destroy --all
+	*/
+	destroyCmd      = app.Command("destroy", "Destroy Subutai container/template").Alias("rm").Alias("del")
+	destroyName     = destroyCmd.Arg("name", "container/template name").Strings()
+	destroyAll      = destroyCmd.Flag("all", "destroy all containers except management").Bool()
+	destroyEnv      = destroyCmd.Flag("env", "destroy containers of environment").String()
+	destroyParallel = destroyCmd.Flag("parallel", "number of containers destroyed at once").Default("5").Int()
+
+	//rename command
+	/*
+	subutai rename foo bar
+	*/
+	renameCmd     = app.Command("rename", "Rename container in place")
+	renameName    = renameCmd.Arg("name", "container name").Required().String()
+	renameNewName = renameCmd.Arg("new name", "new container name").Required().String()
+
+	//promote command
+	/*
+	subutai promote foo [-n {template-name} -o {owner} -r 1.0.0 -s tiny]
 	*/
-	destroyCmd  = app.Command("destroy", "Destroy Subutai container/template").Alias("rm").Alias("del")
-	destroyName = destroyCmd.Arg("name", "container/template name").Required().Strings()
+	promoteCmd       = app.Command("promote", "Turn container into local template without export")
+	promoteContainer = promoteCmd.Arg("container", "source container").Required().String()
+	promoteName      = promoteCmd.Flag("name", "template name").Short('n').String()
+	promoteSize      = promoteCmd.Flag("size", "template preferred size").Short('s').String()
+	promoteVersion   = promoteCmd.Flag("ver", "template version").Short('r').String()
+	promoteOwner     = promoteCmd.Flag("owner", "template owner").Short('o').String()
 
 	//export command
 	/*
-	subutai export foo -t {token} [-n {template-name} -s tiny -r 1.0.0 --local]
+	subutai export foo -t {token} [-n {template-name} -s tiny -r 1.0.0]
+	subutai export foo --local [-o {owner} -n {template-name} -s tiny -r 1.0.0]
+	subutai export foo -t {token} --live
+	subutai export foo -t {token} --bwlimit 50M
+	subutai export foo -t {token} --description "nginx on stretch" --license MIT --homepage https://example.com --tag web,nginx
 	*/
 	exportCmd       = app.Command("export", "Export container as a template")
 	exportContainer = exportCmd.Arg("container", "source container").Required().String()
-	exportToken     = exportCmd.Flag("token", "CDN token").Required().Short('t').String()
+	exportToken     = exportCmd.Flag("token", "CDN token, not required for local export").Short('t').String()
 	exportName      = exportCmd.Flag("name", "template name").Short('n').String()
 	exportSize      = exportCmd.Flag("size", "template preferred size").Short('s').String()
 	exportLocal     = exportCmd.Flag("local", "export template to local cache").Short('l').Bool()
 	exportVersion   = exportCmd.Flag("ver", "template version").Short('r').String()
+	exportOwner     = exportCmd.Flag("owner", "template owner for local export").Short('o').String()
+	exportLive      = exportCmd.Flag("live", "export from temporary snapshot clone without stopping container").Bool()
+	exportBwLimit   = exportCmd.Flag("bwlimit", "upload bandwidth limit per second, e.g. 50M").String()
+	exportDesc      = exportCmd.Flag("description", "template description").String()
+	exportLicense   = exportCmd.Flag("license", "template license, e.g. MIT").String()
+	exportHomepage  = exportCmd.Flag("homepage", "template homepage url").String()
+	exportTags      = exportCmd.Flag("tag", "template tags, comma separated or repeated").Strings()
 
 	//import command
 	/*
@@ -141,6 +282,30 @@ var (
 	infoQuotaCmd       = infoCmd.Command("qu", "container quota usage")
 	infoQuotaContainer = infoQuotaCmd.Arg("container", "container name").Required().String()
 
+	//host command
+	hostCmd = app.Command("host", "Host resources")
+	//subutai host capacity [--json]
+	hostCapacityCmd  = hostCmd.Command("capacity", "Total, committed by quotas and used resources of host")
+	hostCapacityJson = hostCapacityCmd.Flag("json", "print capacity as JSON").Bool()
+	//subutai host placement debian-stretch --size medium [--json]
+	hostPlacementCmd      = hostCmd.Command("placement", "Tell if container of template and size can be placed on host")
+	hostPlacementTemplate = hostPlacementCmd.Arg("template", "template reference").Required().String()
+	hostPlacementSize     = hostPlacementCmd.Flag("size", "size preset (tiny, small, medium, large, huge) or quota profile").Short('s').Default("tiny").String()
+	hostPlacementJson     = hostPlacementCmd.Flag("json", "print placement as JSON").Bool()
+	//subutai host init [--console 10.0.0.5 --secret {secret}] [--check]
+	hostInitCmd     = hostCmd.Command("init", "Check prerequisites, prepare datasets and config of fresh host and register it with Console")
+	hostInitConsole = hostInitCmd.Flag("console", "address of Console to register host with").String()
+	hostInitSecret  = hostInitCmd.Flag("secret-file", "file with Console secret, - reads it from stdin").String()
+	hostInitCheck   = hostInitCmd.Flag("check", "only check prerequisites").Bool()
+
+	//stats command
+	/*
+	subutai stats foo [--json]
+	*/
+	statsCmd       = app.Command("stats", "Print resource usage of container next to its quotas")
+	statsContainer = statsCmd.Arg("container", "container name").Required().String()
+	statsJson      = statsCmd.Flag("json", "print metrics as JSON").Bool()
+
 	//hostname command
 	//TODO add hostname read commands e.g. subutai hostname rh, subutai hostname con foo [no-console-change]
 	/*
@@ -164,13 +329,16 @@ var (
 	mapAddCmd            = mapCmd.Command("add", "Add port mapping")
 	mapAddProtocol       = mapAddCmd.Flag("protocol", "protocol [http,https,tcp,udp]").Short('p').Required().String()
 	mapAddExternalPort   = mapAddCmd.Flag("external port", "external port in range [80,443,1000-65535]").Short('e').Required().Int()
-	mapAddInternalServer = mapAddCmd.Flag("internal server", "ip:port").Short('i').Required().String()
+	mapAddInternalServer = mapAddCmd.Flag("internal server", "ip:port or vm/container name:port").Short('i').Required().String()
 	mapAddDomain         = mapAddCmd.Flag("domain", "domain name").Short('n').String()
 	mapAddCertificate    = mapAddCmd.Flag("certificate", "path to joint x509 cert and private key pem file; if not specified, LE certificates will be obtained").Short('c').String()
 	mapAddBalancing      = mapAddCmd.Flag("balancing", "load balancing policy [rr(round_robin),sticky(ip_hash),lcon(least_conn)]").Short('b').String()
 	mapAddSslBackend     = mapAddCmd.Flag("sslbackend", "use ssl backend in https upstream").Short('s').Bool()
 	mapAddRedirect       = mapAddCmd.Flag("redirect", "redirect port 80 to external port").Short('r').Bool()
 	mapAddHttp2          = mapAddCmd.Flag("http2", "use http2 protocol").Bool()
+	mapAddBind           = mapAddCmd.Flag("bind", "host interface or address to listen on; all addresses if not specified").String()
+	mapAddReflect        = mapAddCmd.Flag("reflect", "external address to make reachable from containers and host (NAT reflection)").String()
+	mapAddSet            = mapAddCmd.Flag("set", "server set for blue-green deployment").Default(prxy.DefaultSet).String()
 
 	/*
 	subutai map rm tcp ...
@@ -178,7 +346,7 @@ var (
 	mapRemoveCmd            = mapCmd.Command("rm", "Remove port mapping").Alias("del")
 	mapRemoveProtocol       = mapRemoveCmd.Flag("protocol", "protocol [http,https,tcp,udp]").Short('p').Required().String()
 	mapRemoveExternalPort   = mapRemoveCmd.Flag("external port", "external port in range [80,443,1000-65535]").Short('e').Required().Int()
-	mapRemoveInternalServer = mapRemoveCmd.Flag("internal server", "ip:port").Short('i').String()
+	mapRemoveInternalServer = mapRemoveCmd.Flag("internal server", "ip:port or vm/container name:port").Short('i').String()
 	mapRemoveDomain         = mapRemoveCmd.Flag("domain", "domain name").Short('n').String()
 
 	/*
@@ -188,6 +356,15 @@ var (
 	mapList         = mapCmd.Command("list", "List mapped ports").Alias("ls")
 	mapListProtocol = mapList.Flag("protocol", "http, https, tcp or udp").Short('p').String()
 
+	/*
+	subutai map check -p tcp -e 8080 [--json]
+	*/
+	mapCheckCmd      = mapCmd.Command("check", "Show host services and settings conflicting with port")
+	mapCheckProtocol = mapCheckCmd.Flag("protocol", "protocol [http,https,tcp,udp]").Short('p').Required().String()
+	mapCheckPort     = mapCheckCmd.Flag("external port", "external port").Short('e').Required().Int()
+	mapCheckJson     = mapCheckCmd.Flag("json", "print conflicts as JSON").Bool()
+	mapCheckBind     = mapCheckCmd.Flag("bind", "host interface or address to check; all addresses if not specified").String()
+
 	//metrics command
 	//subutai metrics -s "2018-08-17 02:26:11" -e "2018-08-17 03:26:11"
 	metricsCmd   = app.Command("metrics", "Print host/container metrics")
@@ -208,11 +385,39 @@ var (
 	prxyCreateRedirect      = prxyCreateCmd.Flag("redirect", "redirect port 80 to external port").Short('r').Bool()
 	prxyCreateSslBackend    = prxyCreateCmd.Flag("sslbackend", "use ssl backend in https upstream").Short('s').Bool()
 	prxyCreateHttp2         = prxyCreateCmd.Flag("http2", "use http2 protocol").Bool()
+	prxyCreateBind          = prxyCreateCmd.Flag("bind", "host interface or address to listen on; all addresses if not specified").String()
+	prxyCreateReflect       = prxyCreateCmd.Flag("reflect", "external address to make reachable from containers and host (NAT reflection)").String()
 
 	prxyListCmd      = prxyCmd.Command("list", "List proxies").Alias("ls")
 	prxyListProtocol = prxyListCmd.Flag("protocol", "filer by protocol [http,https]").Short('p').String()
 	prxyListTag      = prxyListCmd.Flag("tag", "proxy tag").Short('t').String()
 
+	prxyMaintenanceCmd    = prxyCmd.Command("maintenance", "Serve maintenance page for domain instead of proxying requests")
+	prxyMaintenanceDomain = prxyMaintenanceCmd.Arg("domain", "proxy domain").Required().String()
+	prxyMaintenanceState  = prxyMaintenanceCmd.Arg("state", "on or off").Required().Enum("on", "off")
+	prxyMaintenancePage   = prxyMaintenanceCmd.Flag("page", "path to html page to serve; previous or default page if not specified").String()
+
+	prxySwitchCmd    = prxyCmd.Command("switch", "Switch domain to another set of servers (blue-green deployment)")
+	prxySwitchDomain = prxySwitchCmd.Arg("domain", "proxy domain").Required().String()
+	prxySwitchSet    = prxySwitchCmd.Flag("to", "server set").Required().String()
+
+	prxyMtlsCmd    = prxyCmd.Command("mtls", "Require client certificates on https proxies of domain")
+	prxyMtlsDomain = prxyMtlsCmd.Arg("domain", "proxy domain").Required().String()
+	prxyMtlsCa     = prxyMtlsCmd.Flag("ca", "path to PEM bundle of CAs signing client certificates").String()
+	prxyMtlsCrl    = prxyMtlsCmd.Flag("crl", "path to PEM CRL to check client certificates against").String()
+	prxyMtlsOcsp   = prxyMtlsCmd.Flag("ocsp", "check client certificates with OCSP").Bool()
+	prxyMtlsOff    = prxyMtlsCmd.Flag("off", "stop requiring client certificates").Bool()
+
+	prxyGeoCmd    = prxyCmd.Command("geo", "Restrict or log access to http(s) proxies of domain by country")
+	prxyGeoDomain = prxyGeoCmd.Arg("domain", "proxy domain").Required().String()
+	prxyGeoAllow  = prxyGeoCmd.Flag("allow", "comma separated country codes allowed to access domain").String()
+	prxyGeoDeny   = prxyGeoCmd.Flag("deny", "comma separated country codes denied access to domain").String()
+	prxyGeoLog    = prxyGeoCmd.Flag("log", "count requests per country in proxy stats").Bool()
+
+	prxyStatsCmd  = prxyCmd.Command("stats", "Show traffic of proxies")
+	prxyStatsTag  = prxyStatsCmd.Flag("tag", "proxy tag").Short('t').String()
+	prxyStatsJson = prxyStatsCmd.Flag("json", "print statistics as JSON").Bool()
+
 	prxyRemoveCmd = prxyCmd.Command("remove", "Remove proxy").Alias("rm").Alias("del")
 	prxyRemoveTag = prxyRemoveCmd.Flag("tag", "proxy tag").Short('t').Required().String()
 
@@ -222,6 +427,7 @@ var (
 	prxyServerAddCmd    = prxyServerCmd.Command("add", "Add proxied server")
 	prxyServerAddTag    = prxyServerAddCmd.Flag("tag", "proxy tag").Short('t').Required().String()
 	prxyServerAddSocket = prxyServerAddCmd.Flag("server", "ip:port").Short('s').Required().String()
+	prxyServerAddSet    = prxyServerAddCmd.Flag("set", "server set for blue-green deployment").Default(prxy.DefaultSet).String()
 
 	prxyServerRemoveCmd    = prxyServerCmd.Command("remove", "Remove proxied server").Alias("rm").Alias("del")
 	prxyServerRemoveTag    = prxyServerRemoveCmd.Flag("tag", "proxy tag").Short('t').Required().String()
@@ -236,23 +442,65 @@ var (
 	quotaSetCmd = quotaCmd.Command("set", "Set container resource quota")
 
 	//subutai quota get -c foo -r cpu
-	quotaGetResource = quotaGetCmd.Flag("resource", "resource type (cpu, cpuset, ram, disk, network)").
+	quotaGetResource = quotaGetCmd.Flag("resource", "resource type (cpu, cpuset, ram, disk, network, io, read-bps, write-bps, read-iops, write-iops, hugepages, tmp, run, numa)").
 		Short('r').Required().String()
 	quotaGetContainer = quotaGetCmd.Flag("container", "container name").Short('c').Required().String()
 
-	//subutai quota set -c foo -r cpu 123
-	quotaSetResource = quotaSetCmd.Flag("resource", "resource type (cpu, cpuset, ram, disk, network)").
+	//subutai quota set -c foo -r cpu 123 [--force]
+	quotaSetResource = quotaSetCmd.Flag("resource", "resource type (cpu, cpuset, ram, disk, network, io, read-bps, write-bps, read-iops, write-iops, hugepages, tmp, run, numa)").
 		Short('r').Required().String()
 	quotaSetContainer = quotaSetCmd.Flag("container", "container name").Short('c').Required().String()
-	quotaSetLimit     = quotaSetCmd.Arg("limit", "limit (% for cpu, # for cpuset, b for network, mb for ram, gb for disk )").Required().String()
+	quotaSetLimit     = quotaSetCmd.Arg("limit", "limit (% for cpu, cores or auto for cpuset, b for network, mb for ram, gb for disk, weight for io, bytes or operations per second for read-bps, write-bps, read-iops and write-iops, mb for hugepages, tmp and run, node or auto for numa)").Required().String()
+	quotaSetForce     = quotaSetCmd.Flag("force", "apply disk quota below current usage, writes to container fail till data is removed").Bool()
+
+	//subutai quota foo [cpu [50]] [--json]
+	//subutai quota foo cpu=50 ram=2048 [--force]
+	quotaShowCmd       = quotaCmd.Command("show", "Print all quotas of container or set several quotas at once").Default()
+	quotaShowContainer = quotaShowCmd.Arg("container", "container name").Required().String()
+	quotaShowQuotas    = quotaShowCmd.Arg("quotas", "resource to print, resource and limit, or resource=limit pairs to set").Strings()
+	quotaShowJson      = quotaShowCmd.Flag("json", "print quotas as JSON").Bool()
+	quotaShowForce     = quotaShowCmd.Flag("force", "apply disk quota below current usage, writes to container fail till data is removed").Bool()
+
+	//subutai quota apply foo --profile db-large
+	quotaApplyCmd       = quotaCmd.Command("apply", "Apply quota profile to container")
+	quotaApplyContainer = quotaApplyCmd.Arg("container", "container name").Required().String()
+	quotaApplyProfile   = quotaApplyCmd.Flag("profile", "quota profile name").Short('p').Required().String()
+
+	//quota profile
+	quotaProfileCmd = quotaCmd.Command("profile", "Manage named quota profiles")
+	//subutai quota profile add db-large cpu=50 ram=4096 disk=100
+	quotaProfileAddCmd    = quotaProfileCmd.Command("add", "Create or replace quota profile").Alias("set")
+	quotaProfileAddName   = quotaProfileAddCmd.Arg("name", "profile name").Required().String()
+	quotaProfileAddQuotas = quotaProfileAddCmd.Arg("quotas", "quotas in form resource=limit (cpu, cpuset, ram, disk, network, io, read-bps, write-bps, read-iops, write-iops, hugepages, tmp, run, numa)").Required().StringMap()
+	//subutai quota profile list
+	quotaProfileListCmd = quotaProfileCmd.Command("list", "List quota profiles").Alias("ls")
+	//subutai quota profile remove db-large
+	quotaProfileRemoveCmd  = quotaProfileCmd.Command("remove", "Remove quota profile").Alias("rm").Alias("del")
+	quotaProfileRemoveName = quotaProfileRemoveCmd.Arg("name", "profile name").Required().String()
 
 	//start command
+	/*
+	subutai start foo [bar baz] [--parallel 5]
+	subutai start --env {env-id}
+	subutai start --all
+	*/
 	startCmd          = app.Command("start", "Start Subutai container")
-	startCmdContainer = startCmd.Arg("name(s)", "container name(s)").Required().Strings()
+	startCmdContainer = startCmd.Arg("name(s)", "container name(s)").Strings()
+	startAll          = startCmd.Flag("all", "start all containers except management").Bool()
+	startEnv          = startCmd.Flag("env", "start containers of environment").String()
+	startParallel     = startCmd.Flag("parallel", "number of containers started at once").Default("5").Int()
 
 	//stop command
+	/*
+	subutai stop foo [bar baz] [--parallel 5]
+	subutai stop --env {env-id}
+	subutai stop --all
+	*/
 	stopCmd          = app.Command("stop", "Stop Subutai container")
-	stopCmdContainer = stopCmd.Arg("name(s)", "container name(s)").Required().Strings()
+	stopCmdContainer = stopCmd.Arg("name(s)", "container name(s)").Strings()
+	stopAll          = stopCmd.Flag("all", "stop all containers except management").Bool()
+	stopEnv          = stopCmd.Flag("env", "stop containers of environment").String()
+	stopParallel     = stopCmd.Flag("parallel", "number of containers stopped at once").Default("5").Int()
 
 	//snapshot command
 	snapshotCmd                = app.Command("snapshot", "Manage container snapshots").Alias("snap")
@@ -262,6 +510,8 @@ var (
 		"partition", "container partition [rootfs|var|opt|home|config|all]").Short('p').Required().String()
 	snapshotCreateCmdLabel = snapshotCreateCmd.Flag("label", "snapshot label").Short('l').Required().String()
 	snapshotCreateCmdStop  = snapshotCreateCmd.Flag("stop", "stop container when doing snapshot").Short('s').Bool()
+	snapshotCreateCmdDesc  = snapshotCreateCmd.Flag("description", "snapshot description").Short('d').String()
+	snapshotCreateCmdOp    = snapshotCreateCmd.Flag("operation", "id of operation snapshot is taken for").String()
 
 	snapshotRemoveCmd          = snapshotCmd.Command("remove", "Remove snapshot").Alias("rm").Alias("del")
 	snapshotRemoveCmdContainer = snapshotRemoveCmd.Flag("container", "container name").Short('c').Required().String()
@@ -269,6 +519,25 @@ var (
 		"partition", "container partition [rootfs|var|opt|home|config|all]").Short('p').Required().String()
 	snapshotRemoveCmdLabel = snapshotRemoveCmd.Flag("label", "snapshot label").Short('l').Required().String()
 
+	snapshotLockCmd          = snapshotCmd.Command("lock", "Lock snapshot against removal, print locks without label")
+	snapshotLockCmdContainer = snapshotLockCmd.Flag("container", "container name").Short('c').Required().String()
+	snapshotLockCmdPartition = snapshotLockCmd.Flag(
+		"partition", "container partition [rootfs|var|opt|home|config|all]").Short('p').Default("all").String()
+	snapshotLockCmdLabel = snapshotLockCmd.Flag("label", "snapshot label").Short('l').String()
+	snapshotLockCmdTag   = snapshotLockCmd.Flag("tag", "lock tag, snapshot stays locked till all its tags are unlocked").Short('t').Default("subutai").String()
+
+	snapshotUnlockCmd          = snapshotCmd.Command("unlock", "Unlock snapshot")
+	snapshotUnlockCmdContainer = snapshotUnlockCmd.Flag("container", "container name").Short('c').Required().String()
+	snapshotUnlockCmdPartition = snapshotUnlockCmd.Flag(
+		"partition", "container partition [rootfs|var|opt|home|config|all]").Short('p').Default("all").String()
+	snapshotUnlockCmdLabel = snapshotUnlockCmd.Flag("label", "snapshot label").Short('l').Required().String()
+	snapshotUnlockCmdTag   = snapshotUnlockCmd.Flag("tag", "lock tag").Short('t').Default("subutai").String()
+
+	snapshotCloneCmd          = snapshotCmd.Command("clone", "Clone new container from snapshot of all partitions of container")
+	snapshotCloneCmdContainer = snapshotCloneCmd.Arg("container", "container name").Required().String()
+	snapshotCloneCmdLabel     = snapshotCloneCmd.Arg("label", "snapshot label").Required().String()
+	snapshotCloneCmdChild     = snapshotCloneCmd.Arg("new-container", "name of new container").Required().String()
+
 	snapshotListCmd          = snapshotCmd.Command("list", "List snapshots").Alias("ls")
 	snapshotListCmdContainer = snapshotListCmd.Flag("container", "container name").Short('c').String()
 	snapshotListCmdPartition = snapshotListCmd.Flag(
@@ -286,10 +555,19 @@ var (
 	snapshotSendCmdContainer   = snapshotSendCmd.Flag("container", "container name").Short('c').Required().String()
 	snapshotSendCmdSnapshots   = snapshotSendCmd.Flag("label(s)", "snapshot label(s). You can specify up to 2 labels separated by space").Short('l').Required().String()
 	snapshotSendCmdDestination = snapshotSendCmd.Flag("destination", "Destination directory").Default(config.Agent.CacheDir).String()
+	snapshotSendCmdTo          = snapshotSendCmd.Flag("to", "send to agent of remote host over ssh, e.g. ssh://root@host, instead of file").String()
+	snapshotSendCmdBwLimit     = snapshotSendCmd.Flag("bwlimit", "bandwidth limit per second of sending to remote host, e.g. 50M").String()
 
 	snapshotReceiveCmd          = snapshotCmd.Command("receive", "Receive snapshots from a file").Alias("recv")
 	snapshotReceiveCmdContainer = snapshotReceiveCmd.Flag("container", "container name").Short('c').Required().String()
-	snapshotReceiveCmdFile      = snapshotReceiveCmd.Flag("file", "path to archive file containing snapshots").Short('f').Required().String()
+	snapshotReceiveCmdFile      = snapshotReceiveCmd.Flag("file", "path to archive file containing snapshots").Short('f').String()
+	snapshotReceiveCmdStream    = snapshotReceiveCmd.Flag("stream", "receive stream of partition or config from stdin").Hidden().String()
+	snapshotReceiveCmdToken     = snapshotReceiveCmd.Flag("token", "print token to resume interrupted stream").Hidden().Bool()
+
+	snapshotVerifyCmd          = snapshotCmd.Command("verify", "Verify that received snapshots are restorable")
+	snapshotVerifyCmdContainer = snapshotVerifyCmd.Flag("container", "container name").Short('c').Required().String()
+	snapshotVerifyCmdLabel     = snapshotVerifyCmd.Flag("label", "label of received snapshot").Short('l').String()
+	snapshotVerifyCmdGuids     = snapshotVerifyCmd.Flag("guid", "guid of sent snapshot by partition, e.g. rootfs=123").Hidden().StringMap()
 
 	cdnCmd               = app.Command("cdn", "Download/upload files from/to CDN")
 	cdnDownloadCmd       = cdnCmd.Command("get", "Download file")
@@ -299,6 +577,7 @@ var (
 	cdnUploadCmd      = cdnCmd.Command("put", "Upload file")
 	cdnUploadCmdFile  = cdnUploadCmd.Flag("file", "path to file to upload").Short('f').Required().String()
 	cndUploadCmdToken = cdnUploadCmd.Flag("token", "CDN token").Short('t').Required().String()
+	cdnUploadCmdLimit = cdnUploadCmd.Flag("bwlimit", "upload bandwidth limit per second, e.g. 50M").String()
 
 	fileCmd                = app.Command("file", "Encrypt/decrypt files with password")
 	fileEncryptCmd         = fileCmd.Command("encrypt", "Encrypt file")
@@ -336,6 +615,105 @@ var (
 	//tunnel check
 	tunnelCheckCmd = tunnelCmd.Command("check", "for internal usage").Hidden()
 
+	//logs command
+	logsCmd       = app.Command("logs", "Print latest records of container journal")
+	logsContainer = logsCmd.Arg("container", "container name").Required().String()
+	logsLines     = logsCmd.Flag("lines", "number of records").Short('n').Default("100").Int()
+	logsJson      = logsCmd.Flag("json", "print records as JSON").Bool()
+
+	//packages command
+	packagesCmd       = app.Command("packages", "Print packages installed in container or template")
+	packagesContainer = packagesCmd.Arg("container", "container or template name").Required().String()
+	packagesRefresh   = packagesCmd.Flag("refresh", "collect packages of running container again").Bool()
+	packagesJson      = packagesCmd.Flag("json", "print inventory as JSON").Bool()
+
+	//drift command
+	driftCmd       = app.Command("drift", "Show managed configs which differ from db")
+	driftReconcile = driftCmd.Flag("reconcile", "rewrite drifted configs from db").Bool()
+
+	//telemetry command
+	telemetryCmd = app.Command("telemetry", "Anonymized usage reporting")
+	//telemetry show
+	telemetryShowCmd = telemetryCmd.Command("show", "Show report sent when telemetry is enabled")
+
+	//token command
+	tokenCmd = app.Command("token", "Manage registration tokens")
+	//token create [token] [--ttl 3600] [--scope clone] [--tenant acme]
+	tokenCreateCmd    = tokenCmd.Command("create", "Register token, new one is generated if it is not specified").Alias("add")
+	tokenCreateToken  = tokenCreateCmd.Arg("token", "token to register").String()
+	tokenCreateTtl    = tokenCreateCmd.Flag("ttl", "seconds token is valid").Int()
+	tokenCreateScopes = tokenCreateCmd.Flag("scope", "operation token is valid for: clone, restore or management").Strings()
+	tokenCreateTenant = tokenCreateCmd.Flag("tenant", "tenant token registers containers of").String()
+	//token list
+	tokenListCmd = tokenCmd.Command("list", "List registration tokens").Alias("ls")
+	//token revoke {id}
+	tokenRevokeCmd = tokenCmd.Command("revoke", "Revoke registration token")
+	tokenRevokeId  = tokenRevokeCmd.Arg("id", "token id").Required().Int()
+	//token purge
+	tokenPurgeCmd = tokenCmd.Command("purge", "Remove used, revoked and expired tokens")
+
+	//tenant command
+	tenantCmd = app.Command("tenant", "Manage tenants sharing the host")
+	//subutai tenant add acme cpu=200 ram=8192 disk=200 containers=10
+	tenantAddCmd    = tenantCmd.Command("add", "Create tenant or change its limits").Alias("set")
+	tenantAddName   = tenantAddCmd.Arg("name", "tenant name").Required().String()
+	tenantAddLimits = tenantAddCmd.Arg("limits", "limits of sums of quotas of tenant containers in form resource=limit (cpu, ram, disk, containers)").StringMap()
+	//subutai tenant list
+	tenantListCmd = tenantCmd.Command("list", "List tenants with their usage of limits").Alias("ls")
+	//subutai tenant remove acme
+	tenantRemoveCmd  = tenantCmd.Command("remove", "Remove tenant without containers").Alias("rm").Alias("del")
+	tenantRemoveName = tenantRemoveCmd.Arg("name", "tenant name").Required().String()
+
+	//operation command
+	operationCmd = app.Command("operation", "Show audit of commands by their operation ids")
+	//subutai operation list [--limit 20]
+	operationListCmd   = operationCmd.Command("list", "List the latest commands").Alias("ls")
+	operationListLimit = operationListCmd.Flag("limit", "number of commands to list").Default("20").Int()
+	//subutai operation show {id}
+	operationShowCmd = operationCmd.Command("show", "Show commands of operation")
+	operationShowId  = operationShowCmd.Arg("id", "operation id").Required().String()
+
+	//api command
+	apiCmd      = app.Command("api", "Manage access to daemon API")
+	apiTokenCmd = apiCmd.Command("token", "Manage tokens validated by local backend")
+	//subutai api token create ci --role operator [--ttl 86400]
+	apiTokenCreateCmd  = apiTokenCmd.Command("create", "Generate token").Alias("add")
+	apiTokenCreateName = apiTokenCreateCmd.Arg("name", "token name").Required().String()
+	apiTokenCreateRole = apiTokenCreateCmd.Flag("role", "role limiting commands token may run: admin, operator or viewer").Default("viewer").String()
+	apiTokenCreateTtl  = apiTokenCreateCmd.Flag("ttl", "seconds token is valid, it does not expire if not set").Int()
+	//subutai api token list
+	apiTokenListCmd = apiTokenCmd.Command("list", "List tokens").Alias("ls")
+	//subutai api token revoke ci
+	apiTokenRevokeCmd  = apiTokenCmd.Command("revoke", "Revoke token")
+	apiTokenRevokeName = apiTokenRevokeCmd.Arg("name", "token name").Required().String()
+
+	//keys command
+	keysCmd = app.Command("keys", "Manage keys signing templates")
+	//subutai keys generate host1 [--ttl 365]
+	keysGenerateCmd  = keysCmd.Command("generate", "Generate key pair signing exported templates").Alias("gen")
+	keysGenerateName = keysGenerateCmd.Arg("name", "key name").Required().String()
+	keysGenerateTtl  = keysGenerateCmd.Flag("ttl", "days key is valid, it does not expire if not set").Int()
+	//subutai keys list
+	keysListCmd = keysCmd.Command("list", "List own and trusted keys").Alias("ls")
+	//subutai keys export host1
+	keysExportCmd  = keysCmd.Command("export", "Print public key to be trusted by other hosts")
+	keysExportName = keysExportCmd.Arg("name", "key name").Required().String()
+	//subutai keys trust host2 "ed25519 AAAA... host2" [--ttl 365]
+	keysTrustCmd  = keysCmd.Command("trust", "Trust public key of another host")
+	keysTrustName = keysTrustCmd.Arg("name", "key name").Required().String()
+	keysTrustKey  = keysTrustCmd.Arg("key", "public key printed by keys export, or file holding it").Required().String()
+	keysTrustTtl  = keysTrustCmd.Flag("ttl", "days key is trusted, it does not expire if not set").Int()
+	//subutai keys remove host2
+	keysRemoveCmd  = keysCmd.Command("remove", "Remove key").Alias("rm").Alias("del")
+	keysRemoveName = keysRemoveCmd.Arg("name", "key name").Required().String()
+
+	//audit command
+	auditCmd = app.Command("audit", "Audit containers")
+	//subutai audit security foo [--json]
+	auditSecurityCmd       = auditCmd.Command("security", "Check container for settings weakening its isolation from host")
+	auditSecurityContainer = auditSecurityCmd.Arg("container", "container name").Required().String()
+	auditSecurityJson      = auditSecurityCmd.Flag("json", "print report as JSON").Bool()
+
 	//vxlan command
 	vxlanCmd = app.Command("vxlan", "Manage vxlan tunnels")
 	//vxlan add command
@@ -350,6 +728,130 @@ var (
 	//vxlan list
 	vxlanListCmd = vxlanCmd.Command("list", "List vxlan tunnels").Alias("ls")
 
+	//storage command
+	storageCmd           = app.Command("storage", "Storage operations")
+	storageReportCmd     = storageCmd.Command("report", "Print space usage by templates and containers with recommendations")
+	storageExpandCmd     = storageCmd.Command("expand", "Detect new pool capacity and recalibrate container disk quotas")
+	storageExpandPolicy  = storageExpandCmd.Flag("policy", "disk quota policy: none, proportional").Short('p').String()
+	storageExpandDevices = storageExpandCmd.Flag("devices", "expand pool devices to capacity of grown disks").Bool()
+
+	//runtime command
+	runtimeCmd = app.Command("runtime", "Export/import container runtime state (quotas, proxies, restart policy)")
+	//runtime export
+	runtimeExportCmd       = runtimeCmd.Command("export", "Export container runtime state as JSON bundle")
+	runtimeExportContainer = runtimeExportCmd.Arg("container", "container name").Required().String()
+	runtimeExportFile      = runtimeExportCmd.Flag("file", "path to bundle file, printed to stdout if missing").Short('f').String()
+	//runtime import
+	runtimeImportCmd       = runtimeCmd.Command("import", "Apply container runtime state from JSON bundle")
+	runtimeImportContainer = runtimeImportCmd.Arg("container", "container name").Required().String()
+	runtimeImportFile      = runtimeImportCmd.Arg("file", "path to bundle file").Required().String()
+
+	//management command
+	managementCmd            = app.Command("management", "Management container operations")
+	managementReinitCmd      = managementCmd.Command("reinit", "Re-run bootstrap of management container")
+	managementReinitKeepData = managementReinitCmd.Flag("keep-data", "keep container filesystem and keys, reconfigure only").Bool()
+	managementCredentialsCmd = managementCmd.Command("credentials", "Print management admin credentials")
+	managementCredsRotate    = managementCredentialsCmd.Flag("rotate", "generate new password and set it in management").Bool()
+	managementReplicateCmd   = managementCmd.Command("replicate", "Pull the latest snapshot of management from primary host")
+	managementPromoteCmd     = managementCmd.Command("promote", "Run replicated management on this standby host")
+	managementPromoteForce   = managementPromoteCmd.Flag("force", "promote even if primary host is reachable").Bool()
+	managementStandbyCmd     = managementCmd.Command("standby", "Show state of management replica")
+
+	//vm command
+	vmCmd = app.Command("vm", "Manage KVM virtual machines")
+	//vm create
+	vmCreateCmd    = vmCmd.Command("create", "Create and start VM from qcow2 disk image")
+	vmCreateName   = vmCreateCmd.Arg("name", "VM name").Required().String()
+	vmCreateImage  = vmCreateCmd.Flag("image", "path to qcow2 base image").Short('i').Required().String()
+	vmCreateRam    = vmCreateCmd.Flag("ram", "RAM in MB").Short('r').Default("2048").Int()
+	vmCreateCpu    = vmCreateCmd.Flag("cpu", "number of vCPUs").Short('c').Default("2").Int()
+	vmCreateVirtio = vmCreateCmd.Flag("virtio", "use virtio disk and network, guest must have virtio drivers").Bool()
+	//vm start/stop/destroy
+	vmStartCmd    = vmCmd.Command("start", "Start VM")
+	vmStartName   = vmStartCmd.Arg("name", "VM name").Required().String()
+	vmStopCmd     = vmCmd.Command("stop", "Stop VM")
+	vmStopName    = vmStopCmd.Arg("name", "VM name").Required().String()
+	vmDestroyCmd  = vmCmd.Command("destroy", "Destroy VM").Alias("rm").Alias("del")
+	vmDestroyName = vmDestroyCmd.Arg("name", "VM name").Required().String()
+	vmListCmd     = vmCmd.Command("list", "List VMs").Alias("ls")
+
+	//template command
+	templateCmd      = app.Command("template", "Template operations")
+	templateStatsCmd = templateCmd.Command("stats", "Print template usage and download statistics")
+	//template info debian-stretch:subutai:0.4.5 [--json]
+	templateInfoCmd      = templateCmd.Command("info", "Print metadata of installed template")
+	templateInfoTemplate = templateInfoCmd.Arg("template", "template reference in form name:owner:version").Required().String()
+	templateInfoJson     = templateInfoCmd.Flag("json", "print in JSON format").Bool()
+	//template inspect
+	templateInspectCmd     = templateCmd.Command("inspect", "Print contents of template archive without importing it")
+	templateInspectArchive = templateInspectCmd.Arg("archive", "path to template archive").Required().String()
+	//template edit
+	templateEditCmd      = templateCmd.Command("edit", "Clone installed template to edit container")
+	templateEditTemplate = templateEditCmd.Arg("template", "template reference in form name:owner:version").Required().String()
+	templateEditName     = templateEditCmd.Flag("name", "edit container name, {template}-edit by default").Short('n').String()
+	templateEditExec     = templateEditCmd.Flag("exec", "command to apply inside edit container, result is committed right away").Short('e').String()
+	templateEditVersion  = templateEditCmd.Flag("ver", "version of edited template, next patch version by default").Short('r').String()
+	//template commit
+	templateCommitCmd       = templateCmd.Command("commit", "Promote edit container to new version of edited template")
+	templateCommitContainer = templateCommitCmd.Arg("container", "edit container name").Required().String()
+	templateCommitVersion   = templateCommitCmd.Flag("ver", "version of edited template, next patch version by default").Short('r').String()
+	//template lxd-import
+	templateLxdImportCmd     = templateCmd.Command("lxd-import", "Import LXD unified image as template")
+	templateLxdImportArchive = templateLxdImportCmd.Arg("archive", "path to LXD image tarball").Required().String()
+	templateLxdImportName    = templateLxdImportCmd.Flag("name", "template name, {os}-{release} of image by default").Short('n').String()
+	templateLxdImportOwner   = templateLxdImportCmd.Flag("owner", "template owner, templateOwner of agent config by default").Short('o').String()
+	templateLxdImportVersion = templateLxdImportCmd.Flag("ver", "template version, 1.0.0 by default").Short('r').String()
+	templateLxdImportBase    = templateLxdImportCmd.Flag("base", "template to take lxc settings from, in form name:owner:version").String()
+	//template lxd-export
+	templateLxdExportCmd      = templateCmd.Command("lxd-export", "Export template as LXD unified image")
+	templateLxdExportTemplate = templateLxdExportCmd.Arg("template", "template reference in form name:owner:version").Required().String()
+	templateLxdExportDir      = templateLxdExportCmd.Flag("dir", "directory to write image to, cache directory by default").Short('d').String()
+	//template outdated [--json]
+	templateOutdatedCmd  = templateCmd.Command("outdated", "List containers whose templates have newer versions on CDN")
+	templateOutdatedJson = templateOutdatedCmd.Flag("json", "print in JSON format").Bool()
+	//template upgrade foo [--ver 1.2.0] [--replace-var] [--rollback]
+	templateUpgradeCmd        = templateCmd.Command("upgrade", "Upgrade container to newer version of its template, home and var partitions are kept")
+	templateUpgradeContainer  = templateUpgradeCmd.Arg("container", "container name").Required().String()
+	templateUpgradeVersion    = templateUpgradeCmd.Flag("ver", "template version, latest by default").Short('r').String()
+	templateUpgradeReplaceVar = templateUpgradeCmd.Flag("replace-var", "replace var partition with one of new version too").Bool()
+	templateUpgradeRollback   = templateUpgradeCmd.Flag("rollback", "roll back the last upgrade").Bool()
+	//template autoupgrade foo --window "sat 02:00-04:00" | --off
+	templateAutoUpgradeCmd       = templateCmd.Command("autoupgrade", "Upgrade container automatically in maintenance window")
+	templateAutoUpgradeContainer = templateAutoUpgradeCmd.Arg("container", "container name").Required().String()
+	templateAutoUpgradeWindow    = templateAutoUpgradeCmd.Flag("window", "maintenance window, e.g. \"sat 02:00-04:00\" or \"daily 22:00-02:00\"").Short('w').String()
+	templateAutoUpgradeOff       = templateAutoUpgradeCmd.Flag("off", "turn automatic upgrades off").Bool()
+
+	//alert command
+	alertCmd = app.Command("alert", "Manage alert rules")
+	//alert add
+	alertAddCmd       = alertCmd.Command("add", "Add or replace alert rule")
+	alertAddName      = alertAddCmd.Arg("name", "rule name").Required().String()
+	alertAddMetric    = alertAddCmd.Flag("metric", "metric (cpu, ram, disk, state)").Required().Short('m').String()
+	alertAddTarget    = alertAddCmd.Flag("target", "container name, \"host\" or \"*\" for all containers").Required().Short('t').String()
+	alertAddOperator  = alertAddCmd.Flag("operator", "comparison operator (>, >=, <, <=, ==, !=)").Default(">").Short('o').String()
+	alertAddThreshold = alertAddCmd.Flag("threshold", "threshold value").Required().Short('l').Float64()
+	alertAddFor       = alertAddCmd.Flag("for", "duration of breach before rule fires, e.g. 5m").Short('f').String()
+	alertAddSeverity  = alertAddCmd.Flag("severity", "severity (info, warning, critical)").Default("warning").Short('s').String()
+	alertAddActions   = alertAddCmd.Flag("action", "action in form type[=url] (log, webhook=url, restart)").Default("log").Short('a').Strings()
+	//alert del
+	alertDelCmd  = alertCmd.Command("del", "Delete alert rule").Alias("rm")
+	alertDelName = alertDelCmd.Arg("name", "rule name").Required().String()
+	//alert list
+	alertListCmd = alertCmd.Command("list", "List alert rules").Alias("ls")
+	//alert check
+	alertCheckCmd = alertCmd.Command("check", "Evaluate alert rules once and print current values")
+
+	//cluster command
+	clusterCmd      = app.Command("cluster", "Manage cluster of resource hosts")
+	clusterPeersCmd = clusterCmd.Command("peers", "List cluster peers")
+	//cluster lock
+	clusterLockCmd     = clusterCmd.Command("lock", "Run command holding cluster-wide lock")
+	clusterLockName    = clusterLockCmd.Arg("name", "lock name").Required().String()
+	clusterLockCommand = clusterLockCmd.Arg("command", "command with arguments, separate it with -- if it has flags").Required().Strings()
+	clusterLockTtl     = clusterLockCmd.Flag("ttl", "lease time in seconds, lease is renewed while command runs").Default("60").Int()
+	//cluster locks
+	clusterLocksCmd = clusterCmd.Command("locks", "List leases of cluster-wide locks granted by this host")
+
 	//batch command
 	batchCmd  = app.Command("batch", "Execute a batch of commands")
 	batchJson = batchCmd.Arg("commands", "batch of commands in JSON").Required().String()
@@ -372,38 +874,94 @@ func main() {
 	}
 
 	vars.IsDaemon = input == daemonCmd.FullCommand()
+	cli.SetProgress(*progressFlag)
+
+	if !vars.IsDaemon {
+		cli.BeginOperation(*opFlag, input, os.Args[1:])
+	}
+
+	if *hostFlag != "" && input != daemonCmd.FullCommand() {
+		exit(cli.RemoteExec(*hostFlag, os.Args[1:]))
+	}
+
+	//release database right after command completes instead of waiting for idle timeout
+	defer db.Close()
 
 	switch input {
 
 	case listContainers.FullCommand():
-		cli.LxcList(*listName, true, false, false, *listParents)
+		if *listUsage || *listSortBy != "" || *listWatch {
+			cli.LxcListUsage(*listName, *listTenant, *listParents, *listSortBy, *listWatch)
+			break
+		}
+		cli.LxcList(*listName, *listTenant, true, false, false, *listParents)
 	case listTemplates.FullCommand():
-		cli.LxcList(*listName, false, true, false, *listParents)
+		cli.LxcList(*listName, *listTenant, false, true, false, *listParents)
 	case listContainersDetails.FullCommand():
-		cli.LxcList(*listName, false, false, true, *listParents)
+		if *listUsage || *listSortBy != "" || *listWatch {
+			cli.LxcListUsage(*listName, *listTenant, *listParents, *listSortBy, *listWatch)
+			break
+		}
+		cli.LxcList(*listName, *listTenant, false, false, true, *listParents)
 	case listAll.FullCommand():
-		cli.LxcList(*listName, true, true, false, *listParents)
+		cli.LxcList(*listName, *listTenant, true, true, false, *listParents)
 	case existsCmd.FullCommand():
 		if !container.LxcInstanceExists(*existsCmdName) {
-			os.Exit(1)
+			exit(1)
 		}
 	case daemonCmd.FullCommand():
 		config.InitAgentDebug()
 		agent.Start()
 	case attachCmd.FullCommand():
 		cli.LxcAttach(*attachName, *attachCommand)
+	case execCmd.FullCommand():
+		var code int
+		if *execAll || len(*execLabels) > 0 {
+			if *execInteractive || *execTty {
+				log.Error("Input and terminal can not be passed to commands run in several containers")
+			}
+			//there is no container name, the first argument starts command
+			command := append([]string{*execName}, *execCommand...)
+			code = cli.ContainerExecAll(*execAll, *execLabels, command, *execUser, *execGroup, *execCwd, *execEnv, *execParallel)
+		} else {
+			code = cli.ContainerExec(*execName, *execCommand, *execUser, *execGroup, *execCwd, *execInteractive, *execTty, *execEnv)
+		}
+		exit(code)
+	case renderCmd.FullCommand():
+		cli.RenderTemplate(*renderContainer, *renderTemplate, *renderDest, *renderVars, *renderOwner, *renderMode, *renderRestart)
+	case labelCmd.FullCommand():
+		cli.ContainerLabel(*labelContainer, *labelLabels, *labelRemove)
+	case limitsCmd.FullCommand():
+		cli.ContainerLimits(*limitsContainer, *limitsLimits, *limitsRemove)
+	case scanCmd.FullCommand():
+		code := cli.ContainerScan(*scanContainer, *scanPath, *scanList)
+		exit(code)
 	case cloneCmd.FullCommand():
-		cli.LxcClone(*cloneTemplate, *cloneContainer, *cloneEnvId, *cloneNetwork, *cloneSecret)
+		cli.LxcClone(*cloneTemplate, *cloneContainer, *cloneSnapshot, *cloneEnvId, *cloneNetwork, *cloneSecret, *cloneProvision, *cloneTenant)
+	case migrateCmd.FullCommand():
+		cli.ContainerMigrate(*migrateContainer, *migrateTarget, *migrateDestroy, *migrateBwLimit)
+	case estimateExportCmd.FullCommand():
+		cli.EstimateExport(*estimateExportCmdContainer)
+	case estimateBackupCmd.FullCommand():
+		cli.EstimateBackup(*estimateBackupCmdContainer, *estimateBackupCmdLabels...)
+
+	case adoptCmd.FullCommand():
+		cli.LxcAdopt(*adoptName, *adoptTemplate, *adoptEnvId, *adoptNetwork)
 	case restoreCmd.FullCommand():
-		cli.RestoreContainer(*restoreContainer, *restoreEnvId, *restoreNetwork, *restoreSecret)
+		cli.RestoreContainer(*restoreContainer, *restoreEnvId, *restoreNetwork, *restoreSecret, *restoreTenant)
 	case cleanupCmd.FullCommand():
 		cli.Cleanup(*cleanupVlan)
 	case pruneCmd.FullCommand():
 		cli.Prune()
 	case destroyCmd.FullCommand():
-		cli.LxcDestroy(*destroyName...)
+		cli.DestroyContainers(*destroyName, *destroyAll, *destroyEnv, *destroyParallel)
+	case renameCmd.FullCommand():
+		cli.LxcRename(*renameName, *renameNewName)
+	case promoteCmd.FullCommand():
+		cli.LxcPromote(*promoteContainer, *promoteName, *promoteVersion, *promoteSize, *promoteOwner)
 	case exportCmd.FullCommand():
-		cli.LxcExport(*exportContainer, *exportName, *exportVersion, *exportSize, *exportToken, *exportLocal)
+		cli.LxcExport(*exportContainer, *exportName, *exportVersion, *exportSize, *exportToken, *exportOwner, *exportLocal, *exportLive, *exportBwLimit,
+			cli.TemplateMetadata{Description: *exportDesc, License: *exportLicense, Homepage: *exportHomepage, Tags: *exportTags})
 	case importCmd.FullCommand():
 		cli.LxcImport(*importName, *importSecret)
 	case infoIdCmd.FullCommand():
@@ -422,6 +980,14 @@ func main() {
 		fmt.Println(cli.GetDiskUsage(*infoDUContainer))
 	case infoQuotaCmd.FullCommand():
 		fmt.Println(cli.GetContainerQuotaUsage(*infoQuotaContainer))
+	case hostCapacityCmd.FullCommand():
+		cli.HostCapacity(*hostCapacityJson)
+	case hostPlacementCmd.FullCommand():
+		cli.HostPlacement(*hostPlacementTemplate, *hostPlacementSize, *hostPlacementJson)
+	case hostInitCmd.FullCommand():
+		cli.HostInit(*hostInitConsole, *hostInitSecret, *hostInitCheck)
+	case statsCmd.FullCommand():
+		cli.ContainerStats(*statsContainer, *statsJson)
 	case hostnameRh.FullCommand():
 		cli.Hostname(*hostnameRhNewHostname)
 	case hostnameContainer.FullCommand():
@@ -429,7 +995,7 @@ func main() {
 
 	case mapAddCmd.FullCommand():
 		cli.AddPortMapping(*mapAddProtocol, *mapAddDomain, *mapAddBalancing, *mapAddExternalPort,
-			*mapAddInternalServer, *mapAddCertificate, *mapAddRedirect, *mapAddSslBackend, *mapAddHttp2)
+			*mapAddInternalServer, *mapAddCertificate, *mapAddRedirect, *mapAddSslBackend, *mapAddHttp2, *mapAddBind, *mapAddReflect, *mapAddSet)
 	case mapRemoveCmd.FullCommand():
 		cli.RemovePortMapping(*mapRemoveProtocol, *mapRemoveDomain, *mapRemoveExternalPort, *mapRemoveInternalServer)
 
@@ -437,25 +1003,28 @@ func main() {
 		for _, v := range cli.GetPortMappings(*mapListProtocol) {
 			fmt.Println(v)
 		}
+	case mapCheckCmd.FullCommand():
+		cli.CheckPortMapping(*mapCheckProtocol, *mapCheckBind, *mapCheckPort, *mapCheckJson)
 
 		//prxy command
 
 	case prxyCreateCmd.FullCommand():
 		log.Check(log.ErrorLevel, "Creating proxy", prxy.CreateProxy(*prxyCreateProtocol,
 			*prxyCreateDomain, *prxyCreateLoadBalancing, *prxyCreateTag, *prxyCreatePort,
-			*prxyCreateRedirect, *prxyCreateSslBackend, *prxyCreateCertificate, *prxyCreateHttp2))
+			*prxyCreateRedirect, *prxyCreateSslBackend, *prxyCreateCertificate, *prxyCreateHttp2, *prxyCreateBind, *prxyCreateReflect))
 
 	case prxyListCmd.FullCommand():
-		lines := []string{"Tag\tProtocol\tPort\tDomain\tBalancing\tRedirected\tSslBackend\tLE\tHttp2\tApplied"}
+		lines := []string{"Tag\tProtocol\tPort\tDomain\tBalancing\tRedirected\tSslBackend\tLE\tHttp2\tBind\tSet\tMaintenance\tApplied"}
 		proxies, err := prxy.GetProxies(*prxyListProtocol)
 		log.Check(log.ErrorLevel, "Getting proxies", err)
 		for _, v := range proxies {
 			proxy := v.Proxy
 			if *prxyListTag == "" || *prxyListTag == proxy.Tag {
 				servers := v.Servers
-				lines = append(lines, fmt.Sprintf("%s\t%s\t%d\t%s\t%s\t%t\t%t\t%t\t%t\t%t",
+				lines = append(lines, fmt.Sprintf("%s\t%s\t%d\t%s\t%s\t%t\t%t\t%t\t%t\t%s\t%s\t%t\t%t",
 					proxy.Tag, proxy.Protocol, proxy.Port, proxy.Domain, proxy.LoadBalancing, proxy.Redirect80Port,
-					proxy.SslBackend, proxy.IsLE(), proxy.Http2, len(servers) > 0))
+					proxy.SslBackend, proxy.IsLE(), proxy.Http2, proxy.Bind, prxy.ActiveSet(&proxy), proxy.Maintenance,
+					len(servers) > 0))
 			}
 		}
 		output(lines)
@@ -465,30 +1034,64 @@ func main() {
 
 	case prxyServerAddCmd.FullCommand():
 		log.Check(log.ErrorLevel, "Adding server",
-			prxy.AddProxiedServer(*prxyServerAddTag, *prxyServerAddSocket))
+			prxy.AddProxiedServer(*prxyServerAddTag, *prxyServerAddSocket, *prxyServerAddSet))
 	case prxyServerRemoveCmd.FullCommand():
 		log.Check(log.ErrorLevel, "Removing server",
 			prxy.RemoveProxiedServer(*prxyServerRemoveTag, *prxyServerRemoveSocket))
+	case prxyMaintenanceCmd.FullCommand():
+		log.Check(log.ErrorLevel, "Setting maintenance", prxy.SetMaintenance(*prxyMaintenanceDomain,
+			*prxyMaintenanceState == "on", *prxyMaintenancePage))
+	case prxySwitchCmd.FullCommand():
+		log.Check(log.ErrorLevel, "Switching server set", prxy.SwitchServerSet(*prxySwitchDomain, *prxySwitchSet))
+	case prxyMtlsCmd.FullCommand():
+		if *prxyMtlsOff == (*prxyMtlsCa != "") {
+			log.Error("Specify either --ca or --off")
+		}
+		log.Check(log.ErrorLevel, "Setting client certificate requirement",
+			prxy.SetClientAuth(*prxyMtlsDomain, *prxyMtlsCa, *prxyMtlsCrl, *prxyMtlsOcsp))
+	case prxyGeoCmd.FullCommand():
+		log.Check(log.ErrorLevel, "Setting country policy", prxy.SetGeoPolicy(*prxyGeoDomain,
+			strings.Split(*prxyGeoAllow, ","), strings.Split(*prxyGeoDeny, ","), *prxyGeoLog))
+	case clusterPeersCmd.FullCommand():
+		cli.ClusterPeers()
+	case clusterLockCmd.FullCommand():
+		code := cli.ClusterLock(*clusterLockName, *clusterLockTtl, *clusterLockCommand)
+		exit(code)
+	case clusterLocksCmd.FullCommand():
+		cli.ClusterLocks()
+	case prxyStatsCmd.FullCommand():
+		cli.ProxyStats(*prxyStatsTag, *prxyStatsJson)
 	case prxyServerListCmd.FullCommand():
-		lines := []string{"Protocol\tPort\tDomain\tServer"}
+		lines := []string{"Protocol\tPort\tDomain\tServer\tSet\tActive"}
 		proxies, err := prxy.GetProxies("")
 		log.Check(log.ErrorLevel, "Getting proxies", err)
 		for _, v := range proxies {
 			proxy := v.Proxy
 			if *prxyServerListTag == proxy.Tag {
 				for _, server := range v.Servers {
-					lines = append(lines, fmt.Sprintf("%s\t%d\t%s\t%s", proxy.Protocol, proxy.Port, proxy.Domain, server.Socket))
+					lines = append(lines, fmt.Sprintf("%s\t%d\t%s\t%s\t%s\t%t", proxy.Protocol, proxy.Port, proxy.Domain,
+						server.Socket, prxy.ServerSet(server), prxy.ServerSet(server) == prxy.ActiveSet(&proxy)))
 				}
 			}
 		}
 		output(lines)
 
 	case snapshotCreateCmd.FullCommand():
-		cli.CreateSnapshot(*snapshotCreateCmdContainer, *snapshotCreateCmdPartition, *snapshotCreateCmdLabel, *snapshotCreateCmdStop)
+		cli.CreateSnapshot(*snapshotCreateCmdContainer, *snapshotCreateCmdPartition, *snapshotCreateCmdLabel, *snapshotCreateCmdStop,
+			*snapshotCreateCmdDesc, *snapshotCreateCmdOp)
 
 	case snapshotRemoveCmd.FullCommand():
 		cli.RemoveSnapshot(*snapshotRemoveCmdContainer, *snapshotRemoveCmdPartition, *snapshotRemoveCmdLabel)
 
+	case snapshotCloneCmd.FullCommand():
+		cli.CloneSnapshot(*snapshotCloneCmdContainer, *snapshotCloneCmdLabel, *snapshotCloneCmdChild)
+
+	case snapshotLockCmd.FullCommand():
+		cli.LockSnapshot(*snapshotLockCmdContainer, *snapshotLockCmdPartition, *snapshotLockCmdLabel, *snapshotLockCmdTag)
+
+	case snapshotUnlockCmd.FullCommand():
+		cli.UnlockSnapshot(*snapshotUnlockCmdContainer, *snapshotUnlockCmdPartition, *snapshotUnlockCmdLabel, *snapshotUnlockCmdTag)
+
 	case snapshotListCmd.FullCommand():
 		fmt.Println(cli.ListSnapshots(*snapshotListCmdContainer, *snapshotListCmdPartition))
 
@@ -496,16 +1099,24 @@ func main() {
 		cli.RollbackToSnapshot(*snapshotRollBackCmdContainer, *snapshotRollbackCmdPartition, *snapshotRollbackCmdLabel, *snapshotRollbackCmdForce, *snapshotRollbackCmdStop)
 
 	case snapshotSendCmd.FullCommand():
-		cli.SendContainerSnapshots(*snapshotSendCmdContainer, *snapshotSendCmdDestination, strings.Split(*snapshotSendCmdSnapshots, ",")...)
+		cli.SendContainerSnapshots(*snapshotSendCmdContainer, *snapshotSendCmdDestination, *snapshotSendCmdTo, *snapshotSendCmdBwLimit, strings.Split(*snapshotSendCmdSnapshots, ",")...)
 
 	case snapshotReceiveCmd.FullCommand():
-		cli.ReceiveContainerSnapshots(*snapshotReceiveCmdContainer, *snapshotReceiveCmdFile)
+		if *snapshotReceiveCmdStream != "" {
+			cli.ReceiveContainerStream(*snapshotReceiveCmdContainer, *snapshotReceiveCmdStream, *snapshotReceiveCmdToken)
+		} else {
+			cli.ReceiveContainerSnapshots(*snapshotReceiveCmdContainer, *snapshotReceiveCmdFile)
+		}
+
+	case snapshotVerifyCmd.FullCommand():
+		code := cli.VerifySnapshots(*snapshotVerifyCmdContainer, *snapshotVerifyCmdLabel, *snapshotVerifyCmdGuids)
+		exit(code)
 
 	case cdnDownloadCmd.FullCommand():
 		cli.DownloadRawFile(*cdnDownloadCmdId, *cdnDowloadCmdDestDir)
 
 	case cdnUploadCmd.FullCommand():
-		cli.UploadRawFile(*cdnUploadCmdFile, *cndUploadCmdToken)
+		cli.UploadRawFile(*cdnUploadCmdFile, *cndUploadCmdToken, *cdnUploadCmdLimit)
 
 	case fileEncryptCmd.FullCommand():
 		cli.EncryptFile(*fileEncryptCmdPath, *fileEncryptCmdPassword)
@@ -516,17 +1127,71 @@ func main() {
 		fmt.Println(cli.GetHostMetrics(*metricsHost, *metricsStart, *metricsEnd))
 
 	case quotaGetCmd.FullCommand():
-		cli.LxcQuota(*quotaGetContainer, *quotaGetResource, "", "")
+		cli.LxcQuota(*quotaGetContainer, *quotaGetResource, "", "", false)
 	case quotaSetCmd.FullCommand():
-		cli.LxcQuota(*quotaSetContainer, *quotaSetResource, *quotaSetLimit, "")
+		cli.LxcQuota(*quotaSetContainer, *quotaSetResource, *quotaSetLimit, "", *quotaSetForce)
+	case quotaShowCmd.FullCommand():
+		cli.ContainerQuota(*quotaShowContainer, *quotaShowQuotas, *quotaShowJson, *quotaShowForce)
+	case quotaApplyCmd.FullCommand():
+		cli.QuotaApply(*quotaApplyContainer, *quotaApplyProfile)
+	case quotaProfileAddCmd.FullCommand():
+		cli.QuotaProfileAdd(*quotaProfileAddName, *quotaProfileAddQuotas)
+	case quotaProfileListCmd.FullCommand():
+		cli.QuotaProfileList()
+	case quotaProfileRemoveCmd.FullCommand():
+		cli.QuotaProfileRemove(*quotaProfileRemoveName)
 	case startCmd.FullCommand():
-		cli.LxcStart(*startCmdContainer...)
+		cli.StartContainers(*startCmdContainer, *startAll, *startEnv, *startParallel)
 	case stopCmd.FullCommand():
-		cli.LxcStop(*stopCmdContainer...)
+		cli.StopContainers(*stopCmdContainer, *stopAll, *stopEnv, *stopParallel)
 	case restartCmd.FullCommand():
 		cli.LxcRestart(*restartCmdContainer...)
 	case updateCmd.FullCommand():
 		cli.Update(*updateCmdComponent, *updateCheck)
+	case logsCmd.FullCommand():
+		cli.ContainerLogs(*logsContainer, *logsLines, *logsJson)
+	case packagesCmd.FullCommand():
+		cli.ContainerPackages(*packagesContainer, *packagesRefresh, *packagesJson)
+	case driftCmd.FullCommand():
+		cli.Drift(*driftReconcile)
+	case telemetryShowCmd.FullCommand():
+		cli.TelemetryShow()
+	case tokenCreateCmd.FullCommand():
+		cli.TokenCreate(*tokenCreateToken, *tokenCreateTtl, *tokenCreateScopes, *tokenCreateTenant)
+	case tokenListCmd.FullCommand():
+		cli.TokenList()
+	case tokenRevokeCmd.FullCommand():
+		cli.TokenRevoke(*tokenRevokeId)
+	case tokenPurgeCmd.FullCommand():
+		cli.TokenPurge()
+	case tenantAddCmd.FullCommand():
+		cli.TenantAdd(*tenantAddName, *tenantAddLimits)
+	case tenantListCmd.FullCommand():
+		cli.TenantList()
+	case tenantRemoveCmd.FullCommand():
+		cli.TenantRemove(*tenantRemoveName)
+	case operationListCmd.FullCommand():
+		cli.OperationList("", *operationListLimit)
+	case operationShowCmd.FullCommand():
+		cli.OperationList(*operationShowId, 0)
+	case apiTokenCreateCmd.FullCommand():
+		cli.ApiTokenCreate(*apiTokenCreateName, *apiTokenCreateRole, *apiTokenCreateTtl)
+	case apiTokenListCmd.FullCommand():
+		cli.ApiTokenList()
+	case apiTokenRevokeCmd.FullCommand():
+		cli.ApiTokenRevoke(*apiTokenRevokeName)
+	case keysGenerateCmd.FullCommand():
+		cli.KeysGenerate(*keysGenerateName, *keysGenerateTtl)
+	case keysListCmd.FullCommand():
+		cli.KeysList()
+	case keysExportCmd.FullCommand():
+		cli.KeysExport(*keysExportName)
+	case keysTrustCmd.FullCommand():
+		cli.KeysTrust(*keysTrustName, *keysTrustKey, *keysTrustTtl)
+	case keysRemoveCmd.FullCommand():
+		cli.KeysRemove(*keysRemoveName)
+	case auditSecurityCmd.FullCommand():
+		cli.AuditSecurity(*auditSecurityContainer, *auditSecurityJson)
 	case tunnelAddCmd.FullCommand():
 		cli.AddSshTunnel(*tunneAddSocket, *tunnelAddTimeout, *tunnelAddHumanFriendly)
 	case tunnelDelCmd.FullCommand():
@@ -548,10 +1213,82 @@ func main() {
 			fmt.Println(tun.Name, tun.RemoteIp, tun.Vlan, tun.Vni)
 		}
 
+	case storageReportCmd.FullCommand():
+		cli.StorageReport()
+	case storageExpandCmd.FullCommand():
+		cli.ExpandStorage(*storageExpandPolicy, *storageExpandDevices)
+
+	case runtimeExportCmd.FullCommand():
+		cli.ExportRuntimeBundle(*runtimeExportContainer, *runtimeExportFile)
+	case runtimeImportCmd.FullCommand():
+		cli.ImportRuntimeBundle(*runtimeImportContainer, *runtimeImportFile)
+
+	case managementReinitCmd.FullCommand():
+		cli.ManagementReinit(*managementReinitKeepData)
+	case managementCredentialsCmd.FullCommand():
+		cli.ManagementCredentialsCmd(*managementCredsRotate)
+	case managementReplicateCmd.FullCommand():
+		cli.ManagementReplicate()
+	case managementPromoteCmd.FullCommand():
+		cli.ManagementPromote(*managementPromoteForce)
+	case managementStandbyCmd.FullCommand():
+		cli.ManagementStandby()
+
+	case vmCreateCmd.FullCommand():
+		cli.VmCreate(*vmCreateName, *vmCreateImage, *vmCreateRam, *vmCreateCpu, *vmCreateVirtio)
+	case vmStartCmd.FullCommand():
+		cli.VmStart(*vmStartName)
+	case vmStopCmd.FullCommand():
+		cli.VmStop(*vmStopName)
+	case vmDestroyCmd.FullCommand():
+		cli.VmDestroy(*vmDestroyName)
+	case vmListCmd.FullCommand():
+		cli.VmList()
+
+	case templateStatsCmd.FullCommand():
+		cli.PrintTemplateStats()
+	case templateInfoCmd.FullCommand():
+		cli.TemplateInfo(*templateInfoTemplate, *templateInfoJson)
+	case templateInspectCmd.FullCommand():
+		cli.InspectTemplate(*templateInspectArchive)
+	case templateEditCmd.FullCommand():
+		cli.TemplateEdit(*templateEditTemplate, *templateEditName, *templateEditExec, *templateEditVersion)
+	case templateCommitCmd.FullCommand():
+		cli.TemplateCommit(*templateCommitContainer, *templateCommitVersion)
+	case templateLxdImportCmd.FullCommand():
+		cli.LxdImport(*templateLxdImportArchive, *templateLxdImportName, *templateLxdImportOwner,
+			*templateLxdImportVersion, *templateLxdImportBase)
+	case templateLxdExportCmd.FullCommand():
+		cli.LxdExport(*templateLxdExportTemplate, *templateLxdExportDir)
+	case templateOutdatedCmd.FullCommand():
+		cli.TemplateOutdated(*templateOutdatedJson)
+	case templateUpgradeCmd.FullCommand():
+		cli.TemplateUpgrade(*templateUpgradeContainer, *templateUpgradeVersion, *templateUpgradeReplaceVar, *templateUpgradeRollback)
+	case templateAutoUpgradeCmd.FullCommand():
+		cli.TemplateAutoUpgrade(*templateAutoUpgradeContainer, *templateAutoUpgradeWindow, *templateAutoUpgradeOff)
+
+	case alertAddCmd.FullCommand():
+		cli.AddAlertRule(*alertAddName, *alertAddMetric, *alertAddTarget, *alertAddOperator, *alertAddThreshold,
+			*alertAddFor, *alertAddSeverity, *alertAddActions)
+	case alertDelCmd.FullCommand():
+		cli.RemoveAlertRule(*alertDelName)
+	case alertListCmd.FullCommand():
+		cli.ListAlertRules()
+	case alertCheckCmd.FullCommand():
+		cli.CheckAlerts()
+
 	case batchCmd.FullCommand():
 		cli.Batch(*batchJson)
 	}
 
+	cli.EndOperation(0)
+}
+
+//exit records operation in audit and releases database before process exits with code
+func exit(code int) {
+	cli.EndOperation(code)
+	db.Close()
+	os.Exit(code)
 }
 
 func output(lines []string) {